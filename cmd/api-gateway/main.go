@@ -11,10 +11,31 @@ import (
     "time"
 
     "github.com/gin-gonic/gin"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+    "github.com/bhanukaranwal/UrbanZen/internal/auth"
     "github.com/bhanukaranwal/UrbanZen/internal/config"
+    "github.com/bhanukaranwal/UrbanZen/internal/deviceaccess"
+    "github.com/bhanukaranwal/UrbanZen/internal/devicecred"
+    "github.com/bhanukaranwal/UrbanZen/internal/devicestatus"
+    "github.com/bhanukaranwal/UrbanZen/internal/devicetype"
+    "github.com/bhanukaranwal/UrbanZen/internal/flags"
     "github.com/bhanukaranwal/UrbanZen/internal/gateway"
+    "github.com/bhanukaranwal/UrbanZen/internal/geofence"
     "github.com/bhanukaranwal/UrbanZen/internal/middleware"
+    "github.com/bhanukaranwal/UrbanZen/internal/privacy"
+    "github.com/bhanukaranwal/UrbanZen/internal/residency"
+    "github.com/bhanukaranwal/UrbanZen/internal/security"
+    "github.com/bhanukaranwal/UrbanZen/internal/subscription"
+    "github.com/bhanukaranwal/UrbanZen/internal/telemetryschema"
+    "github.com/bhanukaranwal/UrbanZen/internal/tenant"
+    "github.com/bhanukaranwal/UrbanZen/pkg/cache"
+    "github.com/bhanukaranwal/UrbanZen/pkg/database"
+    "github.com/bhanukaranwal/UrbanZen/pkg/events"
+    "github.com/bhanukaranwal/UrbanZen/pkg/heartbeat"
+    "github.com/bhanukaranwal/UrbanZen/pkg/kafka"
     "github.com/bhanukaranwal/UrbanZen/pkg/logger"
+    "github.com/bhanukaranwal/UrbanZen/pkg/shutdown"
+    "github.com/bhanukaranwal/UrbanZen/pkg/startup"
 )
 
 func main() {
@@ -26,6 +47,65 @@ func main() {
     if err != nil {
         log.Fatal("Failed to load configuration:", err)
     }
+    
+    // Wait for dependencies that are still starting rather than exiting
+    wait := startup.New(context.Background(), cfg, logger)
+    if err := wait.Dependencies(); err != nil {
+        log.Fatal("Dependencies unavailable:", err)
+    }
+    
+    // Initialize database connections
+    db, err := startup.Connect(wait, "postgres", func() (*database.PostgresDB, error) {
+        return database.NewPostgres(cfg)
+    })
+    if err != nil {
+        log.Fatal("Failed to connect to PostgreSQL:", err)
+    }
+    
+    // Telemetry aggregates back the utilities endpoints
+    tsdb, err := startup.Connect(wait, "timescaledb", func() (*database.PostgresDB, error) {
+        return database.NewTimescaleDB(cfg)
+    })
+    if err != nil {
+        log.Fatal("Failed to connect to TimescaleDB:", err)
+    }
+    
+    redis, err := startup.Connect(wait, "redis", func() (*database.RedisClient, error) {
+        return database.NewRedisClient(cfg)
+    })
+    if err != nil {
+        log.Fatal("Failed to connect to Redis:", err)
+    }
+    
+    err = wait.Retry("kafka topics", func() error {
+        return kafka.EnsureTopics(context.Background(), cfg, logger, cfg.Kafka.Topics.Notifications)
+    })
+    if err != nil {
+        log.Fatal("Failed to create Kafka topics:", err)
+    }
+    
+    producer, err := kafka.NewProducer(cfg.Kafka.Brokers)
+    if err != nil {
+        log.Fatal("Failed to create Kafka producer:", err)
+    }
+    
+    featureFlags := flags.New(redis, cfg, logger)
+    authService := auth.NewService(db, redis, events.New(producer, cfg, logger), auth.NewConfig(cfg), logger)
+    if captcha := cfg.Auth.LoginThrottle.Captcha; captcha.VerifyURL != "" {
+        authService.SetCaptchaVerifier(auth.NewSiteVerifyCaptcha(captcha.VerifyURL, captcha.Secret, captcha.Timeout))
+    }
+    for name, provider := range cfg.Auth.SSO {
+        authService.RegisterProvider(name,
+            auth.NewOIDCProvider(provider.Issuer, provider.ClientID, provider.ClientSecret, provider.RedirectURL, provider.Scopes, provider.Timeout),
+            auth.SSOPolicy{
+                TenantID:       provider.TenantID,
+                DefaultRole:    provider.DefaultRole,
+                AutoProvision:  provider.AutoProvision,
+                LinkByEmail:    provider.LinkByEmail,
+                AllowedDomains: provider.AllowedDomains,
+                FrontendURL:    provider.FrontendURL,
+            })
+    }
 
     // Initialize Gin router
     if cfg.Environment == "production" {
@@ -35,41 +115,154 @@ func main() {
     router := gin.New()
     
     // Add middlewares
+    // Outermost, so requests that panic are recorded with Recovery's 500
+    router.Use(middleware.Metrics())
     router.Use(gin.Recovery())
+    router.Use(middleware.LoadShedding(cfg, map[string]middleware.LoadSignal{
+        "postgres_pool":    middleware.PoolLoad(db.DB),
+        "timescaledb_pool": middleware.PoolLoad(tsdb.DB),
+    }))
     router.Use(middleware.Logger(logger))
-    router.Use(middleware.CORS(cfg))
-    router.Use(middleware.Security())
+    router.Use(middleware.Envelope())
+    router.Use(middleware.BodyLimit(cfg))
+    router.Use(middleware.BodyLogging(cfg, logger))
+    security.NewMiddleware(security.NewConfig(cfg, "api-gateway"), logger).Apply(router)
     router.Use(middleware.RateLimiter(cfg))
 
     // Initialize gateway
-    gw := gateway.New(cfg, logger)
+    tenants := tenant.NewStore(db, cfg, logger)
+    statuses := devicestatus.NewStore(redis)
+    deviceTypes := devicetype.NewStore(db)
+    tokens := auth.NewTokenStore(db, redis, cfg.Auth.SessionOutageGrace, logger)
+    deviceAccess := deviceaccess.NewStore(db)
+    regions := residency.NewStore(db, cfg, logger)
+    deviceCA, err := devicecred.LoadCA(cfg)
+    if err != nil {
+        log.Fatal("Failed to load device CA:", err)
+    }
+    credentials := devicecred.NewStore(db, deviceCA, cfg)
+    schemas := telemetryschema.NewStore(db)
+    
+    // Changes made here clear the other services' caches, and theirs ours
+    caches := cache.NewSyncer(redis, cfg.Cache.SyncInterval, logger)
+    tenants.SyncWith(caches)
+    deviceTypes.SyncWith(caches)
+    schemas.SyncWith(caches)
+    
+    gw := gateway.New(cfg, db, tsdb, redis, authService, tenants, featureFlags, statuses, deviceTypes, tokens, deviceAccess, credentials, privacy.NewStore(db), geofence.NewStore(db),
+        heartbeat.NewMonitor(redis), schemas, subscription.NewStore(db), regions, producer, logger)
     
     // Setup routes
     v1 := router.Group("/api/v1")
     {
+        // Public status page; needs no authentication
+        v1.GET("/public/status", gw.PlatformStatus)
+        
         // Authentication routes
         auth := v1.Group("/auth")
         {
             auth.POST("/login", gw.Login)
-            auth.POST("/logout", gw.Logout)
+            auth.POST("/register", middleware.Tenant(), gw.Register)
+            auth.POST("/password/forgot", gw.ForgotPassword)
+            auth.POST("/password/reset", gw.ResetPassword)
+            auth.POST("/logout", middleware.AuthRequiredOrToken(cfg, tokens), gw.Logout)
             auth.POST("/refresh", gw.RefreshToken)
-            auth.GET("/me", middleware.AuthRequired(cfg), gw.GetProfile)
+            auth.GET("/sso", gw.ListSSOProviders)
+            auth.GET("/sso/:provider/login", gw.BeginSSO)
+            auth.GET("/sso/:provider/callback", gw.CompleteSSO)
+            auth.POST("/sso/verify", gw.VerifySSO)
+            auth.GET("/me", middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(), gw.GetProfile)
+            auth.GET("/me/notification-digest", middleware.AuthRequiredOrToken(cfg, tokens), gw.GetNotificationDigest)
+            auth.PUT("/me/notification-digest", middleware.AuthRequiredOrToken(cfg, tokens), gw.UpdateNotificationDigest)
+            auth.GET("/me/alternate-contacts", middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(), gw.GetAlternateContacts)
+            auth.PUT("/me/alternate-contacts", middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(), gw.UpdateAlternateContacts)
+            
+            tokenRoutes := auth.Group("/tokens")
+            tokenRoutes.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant())
+            {
+                tokenRoutes.POST("", gw.CreateAccessToken)
+                tokenRoutes.GET("", gw.ListAccessTokens)
+                tokenRoutes.DELETE("/:id", gw.RevokeAccessToken)
+            }
+        }
+        
+        // Data subject requests: users act on themselves, admins on anyone
+        users := v1.Group("/users")
+        users.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(), regions.RequireUser("id"))
+        {
+            users.GET("/:id/data-export", gw.ExportUserData)
+            users.DELETE("/:id", gw.EraseUser)
         }
         
         // Device management routes
         devices := v1.Group("/devices")
-        devices.Use(middleware.AuthRequired(cfg))
+        // Requests for one device are held to the caller's regions
+        devices.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(), regions.RequireDevice("id"))
         {
             devices.GET("", gw.ListDevices)
-            devices.POST("", gw.CreateDevice)
-            devices.GET("/:id", gw.GetDevice)
-            devices.PUT("/:id", gw.UpdateDevice)
+            devices.POST("", middleware.RequireRole("operator"), gw.CreateDevice)
+            devices.POST("/status/bulk", gw.BulkDeviceStatus)
+            devices.PATCH("/bulk", middleware.RequireRole("operator"), gw.BulkUpdateDevices)
+            devices.GET("/:id", deviceAccess.RequireDevice("id"), gw.GetDevice)
+            devices.GET("/:id/status", deviceAccess.RequireDevice("id"), gw.GetDeviceStatus)
+            devices.GET("/:id/history", deviceAccess.RequireDevice("id"), gw.GetDeviceHistory)
+            devices.GET("/:id/config", deviceAccess.RequireDevice("id"), gw.GetDeviceConfig)
+            devices.GET("/:id/children", deviceAccess.RequireDevice("id"), gw.ListDeviceChildren)
+            devices.POST("/:id/tags", middleware.RequireRole("operator"), deviceAccess.RequireDevice("id"), gw.AddDeviceTags)
+            devices.DELETE("/:id/tags/:tag", middleware.RequireRole("operator"), deviceAccess.RequireDevice("id"), gw.RemoveDeviceTag)
+            devices.GET("/:id/commands", middleware.RequireRole("operator"), gw.ListDeviceCommands)
+            devices.GET("/:id/geofence", middleware.RequireRole("operator"), gw.GetDeviceGeofence)
+            devices.PUT("/:id/geofence", middleware.RequireRole("operator"), gw.SaveDeviceGeofence)
+            devices.DELETE("/:id/geofence", middleware.RequireRole("operator"), gw.DeleteDeviceGeofence)
+            devices.PUT("/:id", middleware.RequireRole("operator"), deviceAccess.RequireDevice("id"), gw.UpdateDevice)
             devices.DELETE("/:id", gw.DeleteDevice)
         }
         
+        // Users' alerts on device metrics
+        subscriptions := v1.Group("/subscriptions")
+        subscriptions.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant())
+        {
+            subscriptions.GET("", gw.ListSubscriptions)
+            subscriptions.POST("", gw.CreateSubscription)
+            subscriptions.GET("/:id", gw.GetSubscription)
+            subscriptions.PUT("/:id", gw.UpdateSubscription)
+            subscriptions.DELETE("/:id", gw.DeleteSubscription)
+        }
+        
+        // Command history routes
+        commands := v1.Group("/commands")
+        commands.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(), middleware.RequireRole("operator"))
+        {
+            commands.GET("", gw.ListCommands)
+        }
+        
+        // Alert routes
+        alerts := v1.Group("/alerts")
+        alerts.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant())
+        {
+            alerts.GET("", gw.ListAlerts)
+            alerts.POST("/:id/ack", middleware.RequireRole("operator"), gw.AcknowledgeAlert)
+            alerts.POST("/:id/resolve", middleware.RequireRole("operator"), gw.ResolveAlert)
+            alerts.POST("/bulk/ack", middleware.RequireRole("operator"), gw.BulkAcknowledgeAlerts)
+            alerts.POST("/bulk/resolve", middleware.RequireRole("operator"), gw.BulkResolveAlerts)
+        }
+        
+        // Anomaly routes, for investigating what the detectors raised
+        anomalies := v1.Group("/anomalies")
+        anomalies.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(), middleware.RequireRole("operator"))
+        {
+            anomalies.GET("", gw.ListAnomalies)
+            anomalies.GET("/:id", gw.GetAnomaly)
+            anomalies.POST("/:id/resolve", gw.ResolveAnomaly)
+        }
+        
+        // One-call summary backing the main dashboard
+        v1.GET("/dashboard/summary", middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(),
+            middleware.RequireRole("operator"), gw.GetDashboardSummary)
+        
         // Utility services routes
         utilities := v1.Group("/utilities")
-        utilities.Use(middleware.AuthRequired(cfg))
+        utilities.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant())
         {
             water := utilities.Group("/water")
             {
@@ -83,6 +276,35 @@ func main() {
                 electricity.GET("/grid-status", gw.GetGridStatus)
             }
         }
+        
+        // Administration routes
+        admin := v1.Group("/admin")
+        admin.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant(), middleware.RequireRole("admin"))
+        {
+            admin.POST("/users/:id/unlock", regions.RequireUser("id"), gw.UnlockUser)
+            admin.PUT("/users/:id/regions", regions.RequireUser("id"), gw.SetUserRegions)
+            admin.POST("/users/import", gw.ImportUsers)
+            admin.GET("/config", gw.GetEffectiveConfig)
+            admin.GET("/tenant/config", gw.GetTenantConfig)
+            admin.PUT("/tenant/config", gw.UpdateTenantConfig)
+            admin.GET("/flags", gw.ListFeatureFlags)
+            admin.PUT("/flags/:name", gw.UpdateFeatureFlag)
+            admin.GET("/device-types", gw.ListDeviceTypes)
+            admin.PUT("/device-types/:type", gw.SaveDeviceType)
+            admin.GET("/device-types/:type/telemetry-schemas", gw.ListTelemetrySchemas)
+            admin.POST("/device-types/:type/telemetry-schemas", gw.CreateTelemetrySchema)
+            admin.GET("/device-types/:type/telemetry-schemas/:version", gw.GetTelemetrySchema)
+            admin.DELETE("/device-types/:type/telemetry-schemas/:version", gw.DeleteTelemetrySchema)
+            admin.GET("/devices/:id/assignments", regions.RequireDevice("id"), gw.ListDeviceAssignments)
+            admin.PUT("/devices/:id/assignments/:user_id", regions.RequireDevice("id"), gw.AssignDevice)
+            admin.DELETE("/devices/:id/assignments/:user_id", regions.RequireDevice("id"), gw.UnassignDevice)
+            admin.GET("/devices/:id/credentials", regions.RequireDevice("id"), gw.ListDeviceCredentials)
+            admin.POST("/devices/:id/credentials", regions.RequireDevice("id"), gw.IssueDeviceCredential)
+            admin.POST("/devices/:id/credentials/rotate", regions.RequireDevice("id"), gw.RotateDeviceCredential)
+            admin.DELETE("/devices/:id/credentials/:credential_id", regions.RequireDevice("id"), gw.RevokeDeviceCredential)
+            admin.POST("/notifications/:id/resend", gw.ResendNotification)
+            admin.PATCH("/notifications/preferences/bulk", gw.BulkUpdateNotificationPreferences)
+        }
     }
     
     // Health check endpoint
@@ -94,6 +316,17 @@ func main() {
         })
     })
     
+    // Report this instance's health for the status page
+    reporterCtx, stopReporter := context.WithCancel(context.Background())
+    defer stopReporter()
+    
+    reporter := heartbeat.NewReporter(redis, "api-gateway", cfg.Version, logger)
+    reporter.AddCheck("postgres", db.PingContext)
+    reporter.AddCheck("timescaledb", tsdb.PingContext)
+    reporter.AddCheck("redis", redis.Ping)
+    go reporter.Run(reporterCtx)
+    go caches.Run(reporterCtx)
+    
     // Setup HTTP server
     srv := &http.Server{
         Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
@@ -108,19 +341,40 @@ func main() {
         }
     }()
     
+    metricsSrv := &http.Server{
+        Addr:    fmt.Sprintf(":%d", cfg.Monitoring.MetricsPort),
+        Handler: promhttp.Handler(),
+    }
+    
+    go func() {
+        if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            logger.Error("Failed to start metrics server", "error", err)
+        }
+    }()
+    
     // Wait for interrupt signal
     quit := make(chan os.Signal, 1)
     signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
     <-quit
     
     logger.Info("Shutting down server...")
+    stopReporter()
     
-    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-    defer cancel()
+    // Requests in flight still need the producer and databases
+    stop := shutdown.New(logger)
+    stop.Phase("intake", cfg.Shutdown.DrainTimeout,
+        shutdown.Server("http", srv),
+        shutdown.Server("metrics", metricsSrv))
+    stop.Phase("messaging", cfg.Shutdown.CloseTimeout,
+        shutdown.Close("kafka producer", func() error { producer.Close(); return nil }))
+    stop.Phase("storage", cfg.Shutdown.CloseTimeout,
+        shutdown.Close("postgres", db.Close),
+        shutdown.Close("timescaledb", tsdb.Close),
+        shutdown.Close("redis", redis.Close))
     
-    if err := srv.Shutdown(ctx); err != nil {
-        log.Fatal("Server forced to shutdown:", err)
+    if !stop.Run() {
+        log.Fatal("Server did not shut down cleanly")
     }
     
     logger.Info("Server exited")
-}
\ No newline at end of file
+}
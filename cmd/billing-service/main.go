@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -9,11 +10,21 @@ import (
 	"syscall"
 	
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/bhanukaranwal/urbanzen/internal/auth"
 	"github.com/bhanukaranwal/urbanzen/internal/billing"
 	"github.com/bhanukaranwal/urbanzen/internal/config"
 	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/security"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
 	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
 	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/events"
+	"github.com/bhanukaranwal/urbanzen/pkg/heartbeat"
+	"github.com/bhanukaranwal/urbanzen/pkg/kafka"
+	"github.com/bhanukaranwal/urbanzen/pkg/shutdown"
+	"github.com/bhanukaranwal/urbanzen/pkg/startup"
 )
 
 func main() {
@@ -26,27 +37,76 @@ func main() {
 		log.Fatal("Failed to load configuration", "error", err)
 	}
 	
+	// Wait for dependencies that are still starting rather than exiting
+	wait := startup.New(context.Background(), cfg, log)
+	if err := wait.Dependencies(); err != nil {
+		log.Fatal("Dependencies unavailable", "error", err)
+	}
+	
 	// Initialize database connections
-	db, err := database.NewPostgres(cfg)
+	db, err := startup.Connect(wait, "postgres", func() (*database.PostgresDB, error) {
+		return database.NewPostgres(cfg)
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to PostgreSQL", "error", err)
 	}
-	defer db.Close()
 	
-	tsdb, err := database.NewTimescaleDB(cfg)
+	tsdb, err := startup.Connect(wait, "timescaledb", func() (*database.TimescaleDB, error) {
+		return database.NewTimescaleDB(cfg)
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to TimescaleDB", "error", err)
 	}
-	defer tsdb.Close()
 	
-	redis, err := database.NewRedis(cfg)
+	redis, err := startup.Connect(wait, "redis", func() (*database.RedisDB, error) {
+		return database.NewRedis(cfg)
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to Redis", "error", err)
 	}
-	defer redis.Close()
+	
+	err = wait.Retry("kafka topics", func() error {
+		return kafka.EnsureTopics(context.Background(), cfg, log, cfg.Kafka.Topics.Notifications)
+	})
+	if err != nil {
+		log.Fatal("Failed to create Kafka topics", "error", err)
+	}
+	
+	// Initialize Kafka producer for user notifications
+	producer, err := kafka.NewProducer(cfg.Kafka.Brokers)
+	if err != nil {
+		log.Fatal("Failed to create Kafka producer", "error", err)
+	}
 	
 	// Initialize billing service
-	billingService := billing.NewService(db, tsdb, redis, cfg, log)
+	// Sessions, heartbeats and cache generations share the Redis client
+	sharedRedis := database.WrapRedis(redis)
+	tenants := tenant.NewStore(db, cfg, log)
+	tokens := auth.NewTokenStore(db, sharedRedis, cfg.Auth.SessionOutageGrace, log)
+	billingService := billing.NewService(db, tsdb, redis, events.New(producer, cfg, log), tenants, cfg, log)
+	
+	// Scheduled tariff changes applied here clear the other services'
+	// caches, and tariff edits made through them clear this one's
+	caches := cache.NewSyncer(sharedRedis, cfg.Cache.SyncInterval, log)
+	tenants.SyncWith(caches)
+	billingService.SyncWith(caches)
+	
+	// Start background jobs
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	go caches.Run(jobsCtx)
+	
+	jobsStopped := make(chan struct{})
+	go func() {
+		billingService.Start(jobsCtx)
+		close(jobsStopped)
+	}()
+	
+	// Report this instance's health for the status page
+	reporter := heartbeat.NewReporter(sharedRedis, "billing-service", cfg.Version, log)
+	reporter.AddCheck("postgres", db.PingContext)
+	reporter.AddCheck("timescaledb", tsdb.PingContext)
+	reporter.AddCheck("redis", sharedRedis.Ping)
+	go reporter.Run(jobsCtx)
 	
 	// Setup HTTP router
 	if cfg.Environment == "production" {
@@ -54,21 +114,31 @@ func main() {
 	}
 	
 	router := gin.New()
+	// Outermost, so requests that panic are recorded with Recovery's 500
+	router.Use(middleware.Metrics())
 	router.Use(gin.Recovery())
+	router.Use(middleware.LoadShedding(cfg, map[string]middleware.LoadSignal{
+		"postgres_pool":    middleware.PoolLoad(db.DB),
+		"timescaledb_pool": middleware.PoolLoad(tsdb.DB),
+	}))
 	router.Use(middleware.Logger(log))
-	router.Use(middleware.CORS())
-	router.Use(middleware.Security())
+	router.Use(middleware.Envelope())
+	router.Use(middleware.BodyLimit(cfg))
+	security.NewMiddleware(security.NewConfig(cfg, "billing-service"), log).Apply(router)
 	
 	// Setup routes
 	v1 := router.Group("/api/v1")
-	v1.Use(middleware.AuthRequired())
+	v1.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.Tenant())
 	{
 		bills := v1.Group("/bills")
 		{
 			bills.GET("", billingService.GetUserBills)
 			bills.GET("/:id", billingService.GetBill)
 			bills.POST("/:id/pay", billingService.ProcessPayment)
+			bills.POST("/:id/payment-plan", billingService.CreatePaymentPlan)
+			bills.GET("/:id/payment-plan", billingService.GetPaymentPlan)
 			bills.GET("/:id/download", billingService.DownloadBill)
+			bills.POST("/:id/dispute", billingService.CreateDispute)
 		}
 		
 		consumption := v1.Group("/consumption")
@@ -76,6 +146,14 @@ func main() {
 			consumption.GET("/water", billingService.GetWaterConsumption)
 			consumption.GET("/electricity", billingService.GetElectricityConsumption)
 			consumption.GET("/analytics", billingService.GetConsumptionAnalytics)
+			consumption.GET("/comparison", billingService.GetConsumptionComparison)
+		}
+		
+		budgets := v1.Group("/budgets")
+		{
+			budgets.GET("", billingService.ListBudgets)
+			budgets.PUT("/:utility", billingService.SaveBudget)
+			budgets.DELETE("/:utility", billingService.DeleteBudget)
 		}
 		
 		admin := v1.Group("/admin")
@@ -83,7 +161,16 @@ func main() {
 		{
 			admin.POST("/generate-bills", billingService.GenerateBills)
 			admin.GET("/billing-reports", billingService.GetBillingReports)
+			admin.GET("/rates", billingService.GetRates)
 			admin.POST("/rates", billingService.UpdateRates)
+			admin.GET("/rates/history", billingService.ListRateChanges)
+			admin.GET("/rates/history/:id", billingService.GetRateChange)
+			admin.POST("/rates/history/:id/cancel", billingService.CancelRateChange)
+			admin.POST("/rates/history/:id/rollback", billingService.RollbackRateChange)
+			admin.POST("/consumption/rebuild", billingService.RebuildConsumption)
+			admin.GET("/disputes", billingService.ListDisputes)
+			admin.PUT("/disputes/:id", billingService.UpdateDispute)
+			admin.POST("/bills/:id/adjust", billingService.AdjustBill)
 		}
 	}
 	
@@ -105,6 +192,17 @@ func main() {
 		}
 	}()
 	
+	metricsSrv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Monitoring.MetricsPort),
+		Handler: promhttp.Handler(),
+	}
+	
+	go func() {
+		if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("Failed to start metrics server", "error", err)
+		}
+	}()
+	
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -112,12 +210,25 @@ func main() {
 	
 	log.Info("Shutting down billing service...")
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown", "error", err)
+	// Requests and a job run in progress finish before the connections
+	// they use are closed
+	stop := shutdown.New(log)
+	stop.Phase("intake", cfg.Shutdown.DrainTimeout,
+		shutdown.Server("http", srv),
+		shutdown.Server("metrics", metricsSrv))
+	stop.Phase("processing", cfg.Shutdown.FlushTimeout,
+		shutdown.Cancel("billing jobs", cancelJobs, jobsStopped))
+	stop.Phase("messaging", cfg.Shutdown.CloseTimeout,
+		shutdown.Close("kafka producer", func() error { producer.Close(); return nil }))
+	stop.Phase("storage", cfg.Shutdown.CloseTimeout,
+		shutdown.Close("postgres", db.Close),
+		shutdown.Close("timescaledb", tsdb.Close),
+		shutdown.Close("redis", redis.Close))
+	
+	if !stop.Run() {
+		log.Error("Billing service did not shut down cleanly")
+		os.Exit(1)
 	}
 	
 	log.Info("Billing service exited")
-}
\ No newline at end of file
+}
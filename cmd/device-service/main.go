@@ -2,16 +2,44 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"github.com/bhanukaranwal/urbanzen/internal/auth"
+	"github.com/bhanukaranwal/urbanzen/internal/baseline"
+	"github.com/bhanukaranwal/urbanzen/internal/breachwindow"
 	"github.com/bhanukaranwal/urbanzen/internal/device"
 	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceaccess"
+	"github.com/bhanukaranwal/urbanzen/internal/devicecred"
+	"github.com/bhanukaranwal/urbanzen/internal/devicestatus"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/flags"
+	"github.com/bhanukaranwal/urbanzen/internal/geofence"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/residency"
+	"github.com/bhanukaranwal/urbanzen/internal/rpc"
+	"github.com/bhanukaranwal/urbanzen/internal/rpc/devicev1"
+	"github.com/bhanukaranwal/urbanzen/internal/security"
+	"github.com/bhanukaranwal/urbanzen/internal/subscription"
+	"github.com/bhanukaranwal/urbanzen/internal/telemetryschema"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+	"github.com/bhanukaranwal/urbanzen/internal/totalizer"
 	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
 	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/heartbeat"
 	"github.com/bhanukaranwal/urbanzen/pkg/kafka"
+	"github.com/bhanukaranwal/urbanzen/pkg/shutdown"
+	"github.com/bhanukaranwal/urbanzen/pkg/startup"
 )
 
 func main() {
@@ -24,40 +52,240 @@ func main() {
 		log.Fatal("Failed to load configuration", "error", err)
 	}
 	
+	// Wait for dependencies that are still starting rather than exiting
+	wait := startup.New(context.Background(), cfg, log)
+	if err := wait.Dependencies(); err != nil {
+		log.Fatal("Dependencies unavailable", "error", err)
+	}
+	
 	// Initialize database connections
-	db, err := database.NewPostgres(cfg)
+	db, err := startup.Connect(wait, "postgres", func() (*database.PostgresDB, error) {
+		return database.NewPostgres(cfg)
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to PostgreSQL", "error", err)
 	}
-	defer db.Close()
 	
-	tsdb, err := database.NewTimescaleDB(cfg)
+	tsdb, err := startup.Connect(wait, "timescaledb", func() (*database.TimescaleDB, error) {
+		return database.NewTimescaleDB(cfg)
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to TimescaleDB", "error", err)
 	}
-	defer tsdb.Close()
+	
+	redis, err := startup.Connect(wait, "redis", func() (*database.RedisClient, error) {
+		return database.NewRedisClient(cfg)
+	})
+	if err != nil {
+		log.Fatal("Failed to connect to Redis", "error", err)
+	}
+	
+	// Topics this service consumes from or publishes to
+	err = wait.Retry("kafka topics", func() error {
+		return kafka.EnsureTopics(context.Background(), cfg, log,
+			"device-data", cfg.Kafka.Topics.DeviceData, cfg.Kafka.Topics.Commands, cfg.Kafka.Topics.DeviceEvents,
+			cfg.Kafka.Topics.DeviceDataProtobuf, "analytics-data", "alerts",
+			kafka.DeadLetterTopic("device-data"), kafka.DeadLetterTopic("device-telemetry"),
+			kafka.DeadLetterTopic(cfg.Kafka.Topics.DeviceDataProtobuf), kafka.DeadLetterTopic("device-commands"))
+	})
+	if err != nil {
+		log.Fatal("Failed to create Kafka topics", "error", err)
+	}
 	
 	// Initialize Kafka producer and consumer
 	producer, err := kafka.NewProducer(cfg.Kafka.Brokers)
 	if err != nil {
 		log.Fatal("Failed to create Kafka producer", "error", err)
 	}
-	defer producer.Close()
 	
 	consumer, err := kafka.NewConsumer(cfg.Kafka.Brokers, "device-service-group")
 	if err != nil {
 		log.Fatal("Failed to create Kafka consumer", "error", err)
 	}
-	defer consumer.Close()
+	commandConsumer, err := kafka.NewConsumer(cfg.Kafka.Brokers, "device-service-group")
+	if err != nil {
+		log.Fatal("Failed to create Kafka command consumer", "error", err)
+	}
 	
 	// Initialize device service
-	deviceService := device.NewService(db, tsdb, producer, consumer, log)
+	tenants := tenant.NewStore(db, cfg, log)
+	statuses := devicestatus.NewStore(redis)
+	deviceTypes := devicetype.NewStore(db)
+	tokens := auth.NewTokenStore(db, redis, cfg.Auth.SessionOutageGrace, log)
+	regions := residency.NewStore(db, cfg, log)
+	
+	deviceCA, err := devicecred.LoadCA(cfg)
+	if err != nil {
+		log.Fatal("Failed to load device CA", "error", err)
+	}
+	credentials := devicecred.NewStore(db, deviceCA, cfg)
+	tlsConfig, err := devicecred.LoadServerTLS(cfg, deviceCA)
+	if err != nil {
+		log.Fatal("Failed to load TLS configuration", "error", err)
+	}
+	
+	baselines := baseline.NewStore(db, tsdb, baseline.Settings{
+		LearnInterval:      cfg.Devices.Baselines.LearnInterval,
+		Lookback:           cfg.Devices.Baselines.Lookback,
+		MinSamples:         cfg.Devices.Baselines.MinSamples,
+		MinHourSamples:     cfg.Devices.Baselines.MinHourSamples,
+		ZThreshold:         cfg.Devices.Baselines.ZThreshold,
+		CriticalZThreshold: cfg.Devices.Baselines.CriticalZThreshold,
+	})
+	
+	schemas := telemetryschema.NewStore(db)
+	
+	// Type, schema and tenant config edits made through the gateway clear
+	// this service's caches
+	caches := cache.NewSyncer(redis, cfg.Cache.SyncInterval, log)
+	tenants.SyncWith(caches)
+	deviceTypes.SyncWith(caches)
+	schemas.SyncWith(caches)
+	
+	deviceService := device.NewService(db, tsdb, producer, consumer, commandConsumer, tenants, statuses, deviceTypes,
+		totalizer.NewTracker(redis), breachwindow.New(redis), geofence.NewChecker(db), baselines,
+		schemas, subscription.NewChecker(db), regions, flags.New(redis, cfg, log), cfg, log)
+	deviceService.SyncWith(caches)
 	
 	// Start the service
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	go caches.Run(ctx)
+	
+	stopped := make(chan struct{})
+	go func() {
+		deviceService.Start(ctx)
+		close(stopped)
+	}()
+	
+	// Report this instance's health for the status page
+	reporter := heartbeat.NewReporter(redis, "device-service", cfg.Version, log)
+	reporter.AddCheck("postgres", db.PingContext)
+	reporter.AddCheck("timescaledb", tsdb.PingContext)
+	reporter.AddCheck("redis", redis.Ping)
+	go reporter.Run(ctx)
 	
-	go deviceService.Start(ctx)
+	// Setup HTTP router for direct telemetry ingestion
+	if cfg.Environment == "production" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	
+	router := gin.New()
+	// Outermost, so requests that panic are recorded with Recovery's 500
+	router.Use(middleware.Metrics())
+	router.Use(gin.Recovery())
+	router.Use(middleware.LoadShedding(cfg, map[string]middleware.LoadSignal{
+		"postgres_pool":   middleware.PoolLoad(db.DB),
+		"ingestion_queue": deviceService.QueueLoad,
+	}))
+	router.Use(middleware.Logger(log))
+	router.Use(middleware.Envelope())
+	router.Use(middleware.BodyLimit(cfg))
+	security.NewMiddleware(security.NewConfig(cfg, "device-service"), log).Apply(router)
+	
+	// Devices send their own telemetry; service accounts and admins may
+	// send any device's, for backfills and integrations
+	telemetry := router.Group("/api/v1/telemetry")
+	telemetry.Use(middleware.AuthRequiredOrDevice(cfg, tokens, credentials), middleware.RequireRoleOrDevice(middleware.ServiceRole))
+	{
+		telemetry.POST("", deviceService.IngestTelemetry)
+		telemetry.POST("/batch", deviceService.IngestBatch)
+	}
+	
+	v1 := router.Group("/api/v1")
+	v1.Use(middleware.AuthRequiredOrToken(cfg, tokens), middleware.RequireRole("operator"))
+	{
+		devices := v1.Group("/devices")
+		devices.Use(middleware.Tenant(), regions.RequireDevice("id"))
+		{
+			devices.POST("/:id/command/template/:name", deviceService.InvokeCommandTemplate)
+			devices.GET("/:id/command/sequences/:sequenceId", deviceService.GetCommandSequence)
+		}
+		
+		schedules := v1.Group("/schedules")
+		schedules.Use(middleware.Tenant())
+		{
+			schedules.GET("", deviceService.ListSchedules)
+			schedules.POST("", deviceService.CreateSchedule)
+			schedules.GET("/:id", deviceService.GetSchedule)
+			schedules.PUT("/:id", deviceService.UpdateSchedule)
+			schedules.DELETE("/:id", deviceService.DeleteSchedule)
+		}
+		
+		processing := v1.Group("/processing")
+		processing.Use(middleware.RequireRole("admin"), middleware.Tenant())
+		{
+			processing.POST("/replay", deviceService.StartReplay)
+			processing.GET("/replay/:id", deviceService.GetReplay)
+			processing.POST("/replay/:id/cancel", deviceService.CancelReplay)
+			processing.POST("/replay/:id/resume", deviceService.ResumeReplay)
+			processing.GET("/backfills", deviceService.ListBackfills)
+			processing.POST("/backfills", deviceService.StartBackfill)
+			processing.GET("/backfills/:id", deviceService.GetBackfill)
+			processing.GET("/reconciliation", deviceService.GetReconciliation)
+		}
+		
+		anomalies := v1.Group("/anomalies")
+		anomalies.Use(middleware.Tenant())
+		{
+			anomalies.GET("/:id/telemetry", deviceService.GetAnomalyTelemetry)
+		}
+		
+		deviceTypes := v1.Group("/device-types")
+		{
+			deviceTypes.GET("/:type/command-templates", deviceService.ListCommandTemplates)
+			deviceTypes.PUT("/:type/command-templates/:name", middleware.RequireRole("admin"), deviceService.SaveCommandTemplate)
+		}
+	}
+	
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	})
+	
+	srv := &http.Server{
+		Addr:      ":8081",
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+	
+	go func() {
+		log.Info("Starting device service", "port", 8081, "tls", tlsConfig != nil)
+		serve := srv.ListenAndServe
+		if tlsConfig != nil {
+			// Certificates come from TLSConfig
+			serve = func() error { return srv.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server", "error", err)
+		}
+	}()
+	
+	// Internal gRPC API for other services; external clients use HTTP
+	grpcSrv := grpc.NewServer(grpc.UnaryInterceptor(rpc.UnaryAuthInterceptor(cfg, tokens, nil)))
+	devicev1.RegisterDeviceServiceServer(grpcSrv, device.NewGRPCServer(deviceService, deviceaccess.NewStore(db)))
+	
+	grpcListener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPC.Port))
+	if err != nil {
+		log.Fatal("Failed to listen for gRPC", "error", err)
+	}
+	
+	go func() {
+		log.Info("Starting device gRPC server", "port", cfg.GRPC.Port)
+		if err := grpcSrv.Serve(grpcListener); err != nil {
+			log.Error("gRPC server stopped", "error", err)
+		}
+	}()
+	
+	// Metrics are served separately so scrapes never compete with ingestion
+	metricsSrv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Monitoring.MetricsPort),
+		Handler: promhttp.Handler(),
+	}
+	
+	go func() {
+		if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("Failed to start metrics server", "error", err)
+		}
+	}()
 	
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -65,5 +293,27 @@ func main() {
 	<-quit
 	
 	log.Info("Shutting down device service...")
-	cancel()
-}
\ No newline at end of file
+	
+	// Stop taking telemetry before draining the queue it feeds, and close
+	// connections only once nothing is left to write through them
+	stop := shutdown.New(log)
+	stop.Phase("intake", cfg.Shutdown.DrainTimeout,
+		shutdown.Server("http", srv),
+		shutdown.GRPC("grpc", grpcSrv),
+		shutdown.Server("metrics", metricsSrv))
+	stop.Phase("processing", cfg.Shutdown.FlushTimeout,
+		shutdown.Cancel("device service", cancel, stopped))
+	stop.Phase("messaging", cfg.Shutdown.CloseTimeout,
+		shutdown.Close("kafka producer", func() error { producer.Close(); return nil }),
+		shutdown.Close("kafka consumer", func() error { consumer.Close(); return nil }),
+		shutdown.Close("kafka command consumer", func() error { commandConsumer.Close(); return nil }))
+	stop.Phase("storage", cfg.Shutdown.CloseTimeout,
+		shutdown.Close("postgres", db.Close),
+		shutdown.Close("timescaledb", tsdb.Close),
+		shutdown.Close("redis", redis.Close))
+	
+	if !stop.Run() {
+		log.Error("Device service did not shut down cleanly")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,346 @@
+// Command device-simulator drives the ingestion pipeline with virtual
+// devices for load testing and demos. Devices emit water or electricity
+// telemetry at a target aggregate rate, with optional injected anomalies
+// and offline periods, over HTTP ingestion or directly to Kafka.
+//
+//	device-simulator -devices 500 -rate 200 -profile mixed \
+//		-url http://localhost:8081/api/v1/telemetry -token $URBANZEN_TOKEN
+//
+// Telemetry from unregistered devices is rejected, so the first run against
+// a fresh environment should pass -register-url to create them. The
+// simulator sends every device's telemetry with one token, so it must
+// belong to a service account or an admin; operators can no longer send
+// telemetry for devices.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/kafka"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+const emitInterval = 10 * time.Millisecond
+
+var errThrottled = errors.New("ingestion queue full")
+
+type options struct {
+	devices         int
+	profile         string
+	prefix          string
+	rate            float64
+	duration        time.Duration
+	workers         int
+	target          string
+	url             string
+	token           string
+	tenant          string
+	brokers         string
+	topic           string
+	registerURL     string
+	anomalyRate     float64
+	offlineRate     float64
+	offlineDuration time.Duration
+	seed            int64
+}
+
+type sender interface {
+	Send(ctx context.Context, data *models.DeviceData) error
+}
+
+type stats struct {
+	sent, failed, throttled, dropped, anomalies, skippedOffline atomic.Int64
+}
+
+func main() {
+	log := logger.New("device-simulator")
+
+	opts := parseFlags()
+	rng := rand.New(rand.NewSource(opts.seed))
+
+	devices, err := newVirtualDevices(opts.devices, opts.prefix, opts.profile, rng)
+	if err != nil {
+		log.Fatal("Invalid simulator options", "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if opts.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.duration)
+		defer cancel()
+	}
+
+	if opts.registerURL != "" {
+		registered, err := registerDevices(ctx, opts, devices)
+		if err != nil {
+			log.Fatal("Failed to register devices", "error", err)
+		}
+		log.Info("Devices registered", "created", registered, "total", len(devices))
+	}
+
+	var out sender
+	switch opts.target {
+	case "http":
+		out = &httpSender{client: &http.Client{Timeout: 10 * time.Second}, url: opts.url, token: opts.token}
+	case "kafka":
+		producer, err := kafka.NewProducer(strings.Split(opts.brokers, ","))
+		if err != nil {
+			log.Fatal("Failed to create Kafka producer", "error", err)
+		}
+		defer producer.Close()
+		out = &kafkaSender{producer: producer, topic: opts.topic}
+	default:
+		log.Fatal("Unknown target, want http or kafka", "target", opts.target)
+	}
+
+	log.Info("Starting device simulator",
+		"devices", len(devices),
+		"profile", opts.profile,
+		"rate", opts.rate,
+		"target", opts.target,
+	)
+
+	counters := &stats{}
+	jobs := make(chan *models.DeviceData, opts.workers*4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for data := range jobs {
+				switch err := out.Send(ctx, data); {
+				case err == nil:
+					counters.sent.Add(1)
+				case errors.Is(err, errThrottled):
+					counters.throttled.Add(1)
+				case ctx.Err() != nil:
+				default:
+					counters.failed.Add(1)
+					log.Debug("Failed to send telemetry", "error", err, "device_id", data.DeviceID)
+				}
+			}
+		}()
+	}
+
+	go reportProgress(ctx, log, counters)
+
+	emit(ctx, opts, devices, rng, jobs, counters)
+	close(jobs)
+	wg.Wait()
+
+	log.Info("Device simulator stopped",
+		"sent", counters.sent.Load(),
+		"failed", counters.failed.Load(),
+		"throttled", counters.throttled.Load(),
+		"dropped", counters.dropped.Load(),
+		"anomalies", counters.anomalies.Load(),
+	)
+}
+
+func parseFlags() *options {
+	opts := &options{}
+	flag.IntVar(&opts.devices, "devices", 100, "number of virtual devices")
+	flag.StringVar(&opts.profile, "profile", "mixed", "device profile: water, electricity or mixed")
+	flag.StringVar(&opts.prefix, "prefix", "sim", "device ID prefix")
+	flag.Float64Var(&opts.rate, "rate", 50, "total readings per second across all devices")
+	flag.DurationVar(&opts.duration, "duration", 0, "how long to run (0 runs until interrupted)")
+	flag.IntVar(&opts.workers, "workers", 8, "concurrent senders")
+	flag.StringVar(&opts.target, "target", "http", "where to send telemetry: http or kafka")
+	flag.StringVar(&opts.url, "url", "http://localhost:8081/api/v1/telemetry", "HTTP ingestion endpoint")
+	flag.StringVar(&opts.token, "token", os.Getenv("URBANZEN_TOKEN"), "bearer token (JWT or personal access token) for HTTP requests")
+	flag.StringVar(&opts.tenant, "tenant", "", "tenant to register devices under (super admins only)")
+	flag.StringVar(&opts.brokers, "brokers", "localhost:9092", "comma-separated Kafka brokers")
+	flag.StringVar(&opts.topic, "topic", "device-telemetry", "Kafka telemetry topic")
+	flag.StringVar(&opts.registerURL, "register-url", "", "API gateway base URL; if set, devices are created there first")
+	flag.Float64Var(&opts.anomalyRate, "anomaly-rate", 0.01, "probability a reading is anomalous")
+	flag.Float64Var(&opts.offlineRate, "offline-rate", 0.001, "probability a device goes offline after a reading")
+	flag.DurationVar(&opts.offlineDuration, "offline-duration", 15*time.Minute, "how long an offline device stays silent")
+	flag.Int64Var(&opts.seed, "seed", time.Now().UnixNano(), "random seed, for reproducible runs")
+	flag.Parse()
+
+	if opts.devices < 1 || opts.rate <= 0 || opts.workers < 1 {
+		fmt.Fprintln(os.Stderr, "devices, rate and workers must be positive")
+		os.Exit(2)
+	}
+	return opts
+}
+
+// emit produces readings round-robin across devices at the target rate
+// until ctx is done. Readings the senders can't keep up with are dropped
+// and counted rather than queued, so the offered rate stays honest.
+func emit(ctx context.Context, opts *options, devices []*virtualDevice, rng *rand.Rand,
+	jobs chan<- *models.DeviceData, counters *stats) {
+	ticker := time.NewTicker(emitInterval)
+	defer ticker.Stop()
+
+	next, owed := 0, 0.0
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			owed += opts.rate * now.Sub(last).Seconds()
+			last = now
+
+			for ; owed >= 1; owed-- {
+				device := devices[next]
+				next = (next + 1) % len(devices)
+
+				if device.offline(now) {
+					counters.skippedOffline.Add(1)
+					continue
+				}
+
+				anomalous := rng.Float64() < opts.anomalyRate
+				data := device.reading(now, rng, anomalous)
+				if rng.Float64() < opts.offlineRate {
+					device.offlineUntil = now.Add(opts.offlineDuration)
+				}
+
+				select {
+				case jobs <- data:
+					if anomalous {
+						counters.anomalies.Add(1)
+					}
+				default:
+					counters.dropped.Add(1)
+				}
+			}
+		}
+	}
+}
+
+func reportProgress(ctx context.Context, log logger.Logger, counters *stats) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	var lastSent int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sent := counters.sent.Load()
+			log.Info("Simulator progress",
+				"sent", sent,
+				"per_second", float64(sent-lastSent)/10,
+				"failed", counters.failed.Load(),
+				"throttled", counters.throttled.Load(),
+				"dropped", counters.dropped.Load(),
+				"anomalies", counters.anomalies.Load(),
+				"skipped_offline", counters.skippedOffline.Load(),
+			)
+			lastSent = sent
+		}
+	}
+}
+
+type httpSender struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func (s *httpSender) Send(ctx context.Context, data *models.DeviceData) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.post(ctx, s.url, payload, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		return errThrottled
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("ingestion returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSender) post(ctx context.Context, url string, payload []byte, tenant string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	if tenant != "" {
+		req.Header.Set(middleware.TenantHeader, tenant)
+	}
+	return s.client.Do(req)
+}
+
+type kafkaSender struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+func (s *kafkaSender) Send(ctx context.Context, data *models.DeviceData) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.producer.ProduceMessage(s.topic, data.DeviceID, payload)
+}
+
+// registerDevices creates the virtual devices through the API gateway.
+// Devices that already exist (from an earlier run) are left as they are.
+func registerDevices(ctx context.Context, opts *options, devices []*virtualDevice) (int, error) {
+	client := &httpSender{client: &http.Client{Timeout: 10 * time.Second}, token: opts.token}
+	url := strings.TrimRight(opts.registerURL, "/") + "/api/v1/devices"
+
+	created := 0
+	for _, device := range devices {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"id":        device.id,
+			"name":      "Simulated " + device.id,
+			"type":      device.profile.deviceType,
+			"latitude":  device.location.Latitude,
+			"longitude": device.location.Longitude,
+			"metadata":  map[string]interface{}{"simulated": true},
+			// Telemetry is sent with the simulator's own token
+			"credential": "none",
+		})
+
+		resp, err := client.post(ctx, url, payload, opts.tenant)
+		if err != nil {
+			return created, err
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			return created, fmt.Errorf("creating device %s returned %s", device.id, resp.Status)
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+// Centre of the area simulated devices are scattered around (New Delhi)
+var simulatedCentre = models.Location{Latitude: 28.6139, Longitude: 77.2090}
+
+// profile generates readings for one device type. Normal readings follow a
+// daily cycle with noise; anomalous ones exceed the default thresholds in
+// configs/config.yaml.
+type profile struct {
+	deviceType string
+	reading    func(device *virtualDevice, now time.Time, rng *rand.Rand, anomalous bool) map[string]interface{}
+}
+
+var profiles = map[string]profile{
+	"water": {
+		deviceType: "water_sensor",
+		reading: func(device *virtualDevice, now time.Time, rng *rand.Rand, anomalous bool) map[string]interface{} {
+			flow := 20 + 180*dailyCycle(now, device.phase) + rng.NormFloat64()*10
+			if anomalous {
+				flow = 1000 + rng.Float64()*1500
+			}
+			flow = math.Max(flow, 0)
+			device.total += flow * device.elapsedMinutes(now)
+
+			return map[string]interface{}{
+				"flow_rate":   round(flow),
+				"volume":      round(device.total),
+				"pressure":    round(3 + rng.NormFloat64()*0.3),
+				"temperature": round(22 + 4*dailyCycle(now, device.phase) + rng.NormFloat64()),
+			}
+		},
+	},
+	"electricity": {
+		deviceType: "electricity_meter",
+		reading: func(device *virtualDevice, now time.Time, rng *rand.Rand, anomalous bool) map[string]interface{} {
+			voltage := 230 + rng.NormFloat64()*4
+			power := 0.5 + 4.5*dailyCycle(now, device.phase) + rng.NormFloat64()*0.2
+			if anomalous {
+				power = voltage * (100 + rng.Float64()*100) / 1000
+			}
+			power = math.Max(power, 0)
+			device.total += power * device.elapsedMinutes(now) / 60
+
+			return map[string]interface{}{
+				"power":   round(power),
+				"energy":  round(device.total),
+				"voltage": round(voltage),
+				"current": round(power * 1000 / voltage),
+			}
+		},
+	},
+}
+
+// virtualDevice is the running state of one simulated device.
+type virtualDevice struct {
+	id           string
+	profile      profile
+	location     models.Location
+	phase        float64
+	battery      float64
+	total        float64
+	lastReading  time.Time
+	offlineUntil time.Time
+}
+
+func newVirtualDevices(count int, prefix, profileName string, rng *rand.Rand) ([]*virtualDevice, error) {
+	var names []string
+	if profileName == "mixed" {
+		names = []string{"water", "electricity"}
+	} else if _, ok := profiles[profileName]; ok {
+		names = []string{profileName}
+	} else {
+		return nil, fmt.Errorf("unknown profile %q (want water, electricity or mixed)", profileName)
+	}
+
+	devices := make([]*virtualDevice, count)
+	for i := range devices {
+		p := profiles[names[i%len(names)]]
+		devices[i] = &virtualDevice{
+			id:      fmt.Sprintf("%s-%s-%05d", prefix, p.deviceType, i+1),
+			profile: p,
+			location: models.Location{
+				Latitude:  simulatedCentre.Latitude + (rng.Float64()-0.5)*0.2,
+				Longitude: simulatedCentre.Longitude + (rng.Float64()-0.5)*0.2,
+			},
+			// Spread peaks so devices don't move in lockstep
+			phase:   rng.Float64() * 2,
+			battery: 60 + rng.Float64()*40,
+		}
+	}
+	return devices, nil
+}
+
+func (d *virtualDevice) offline(now time.Time) bool {
+	return now.Before(d.offlineUntil)
+}
+
+func (d *virtualDevice) reading(now time.Time, rng *rand.Rand, anomalous bool) *models.DeviceData {
+	metrics := d.profile.reading(d, now, rng, anomalous)
+	d.lastReading = now
+	d.battery = math.Max(d.battery-0.001, 5)
+
+	return &models.DeviceData{
+		DeviceID:   d.id,
+		DeviceType: d.profile.deviceType,
+		Timestamp:  now.UTC(),
+		Location:   d.location,
+		Metrics:    metrics,
+		Metadata: map[string]interface{}{
+			"battery_level":   round(d.battery),
+			"signal_strength": round(-60 - rng.Float64()*30),
+			"simulated":       true,
+		},
+	}
+}
+
+// elapsedMinutes is the time since the previous reading, used to
+// accumulate totals. The first reading contributes nothing.
+func (d *virtualDevice) elapsedMinutes(now time.Time) float64 {
+	if d.lastReading.IsZero() {
+		return 0
+	}
+	return now.Sub(d.lastReading).Minutes()
+}
+
+// dailyCycle is 0 at night and 1 at the daily peak, shifted by phase hours.
+func dailyCycle(now time.Time, phase float64) float64 {
+	hour := float64(now.Hour()) + float64(now.Minute())/60 + phase
+	return (1 - math.Cos((hour-6)/24*2*math.Pi)) / 2
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/bhanukaranwal/urbanzen/internal/notification"
 	"github.com/bhanukaranwal/urbanzen/internal/config"
 	"github.com/bhanukaranwal/urbanzen/pkg/logger"
 	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/heartbeat"
 	"github.com/bhanukaranwal/urbanzen/pkg/kafka"
+	"github.com/bhanukaranwal/urbanzen/pkg/shutdown"
+	"github.com/bhanukaranwal/urbanzen/pkg/startup"
 )
 
 func main() {
@@ -24,35 +30,77 @@ func main() {
 		log.Fatal("Failed to load configuration", "error", err)
 	}
 	
+	// Wait for dependencies that are still starting rather than exiting
+	wait := startup.New(context.Background(), cfg, log)
+	if err := wait.Dependencies(); err != nil {
+		log.Fatal("Dependencies unavailable", "error", err)
+	}
+	
 	// Initialize database connection
-	db, err := database.NewPostgres(cfg)
+	db, err := startup.Connect(wait, "postgres", func() (*database.PostgresDB, error) {
+		return database.NewPostgres(cfg)
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to database", "error", err)
 	}
-	defer db.Close()
 	
 	// Initialize Redis
-	redis, err := database.NewRedis(cfg)
+	redis, err := startup.Connect(wait, "redis", func() (*database.RedisDB, error) {
+		return database.NewRedis(cfg)
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to Redis", "error", err)
 	}
-	defer redis.Close()
 	
-	// Initialize Kafka consumer
+	err = wait.Retry("kafka topics", func() error {
+		return kafka.EnsureTopics(context.Background(), cfg, log, cfg.Kafka.Topics.Notifications, "system-alerts", "emergency-alerts",
+			kafka.DeadLetterTopic(cfg.Kafka.Topics.Notifications), kafka.DeadLetterTopic("system-alerts"), kafka.DeadLetterTopic("emergency-alerts"))
+	})
+	if err != nil {
+		log.Fatal("Failed to create Kafka topics", "error", err)
+	}
+	
+	// Initialize Kafka consumer, and the producer for notifications it
+	// can't handle
 	consumer, err := kafka.NewConsumer(cfg.Kafka.Brokers, "notification-service-group")
 	if err != nil {
 		log.Fatal("Failed to create Kafka consumer", "error", err)
 	}
-	defer consumer.Close()
+	
+	producer, err := kafka.NewProducer(cfg.Kafka.Brokers)
+	if err != nil {
+		log.Fatal("Failed to create Kafka producer", "error", err)
+	}
 	
 	// Initialize notification service
-	notificationService := notification.NewService(db, redis, consumer, cfg, log)
+	notificationService := notification.NewService(db, redis, producer, consumer, cfg, log)
 	
 	// Start the service
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 	
-	go notificationService.Start(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		notificationService.Start(ctx)
+		close(stopped)
+	}()
+	
+	// Report this instance's health for the status page
+	heartbeats := database.WrapRedis(redis)
+	reporter := heartbeat.NewReporter(heartbeats, "notification-service", cfg.Version, log)
+	reporter.AddCheck("postgres", db.PingContext)
+	reporter.AddCheck("redis", heartbeats.Ping)
+	go reporter.Run(ctx)
+	
+	metricsSrv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Monitoring.MetricsPort),
+		Handler: promhttp.Handler(),
+	}
+	
+	go func() {
+		if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("Failed to start metrics server", "error", err)
+		}
+	}()
 	
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -60,5 +108,23 @@ func main() {
 	<-quit
 	
 	log.Info("Shutting down notification service...")
-	cancel()
-}
\ No newline at end of file
+	
+	// Let sends in progress finish before closing the connections they
+	// record delivery through
+	stop := shutdown.New(log)
+	stop.Phase("intake", cfg.Shutdown.DrainTimeout,
+		shutdown.Server("metrics", metricsSrv))
+	stop.Phase("processing", cfg.Shutdown.FlushTimeout,
+		shutdown.Cancel("notification service", cancel, stopped))
+	stop.Phase("messaging", cfg.Shutdown.CloseTimeout,
+		shutdown.Close("kafka producer", func() error { producer.Close(); return nil }),
+		shutdown.Close("kafka consumer", func() error { consumer.Close(); return nil }))
+	stop.Phase("storage", cfg.Shutdown.CloseTimeout,
+		shutdown.Close("postgres", db.Close),
+		shutdown.Close("redis", redis.Close))
+	
+	if !stop.Run() {
+		log.Error("Notification service did not shut down cleanly")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordResetTTL = time.Hour
+
+type RegisterRequest struct {
+	TenantID  string `json:"-"`
+	Username  string `json:"username" binding:"required"`
+	Email     string `json:"email" binding:"required,email"`
+	Password  string `json:"password" binding:"required"`
+	FirstName string `json:"first_name" binding:"required"`
+	LastName  string `json:"last_name" binding:"required"`
+	Phone     string `json:"phone"`
+}
+
+// Register creates a citizen account after validating the password policy.
+func (s *Service) Register(ctx context.Context, req *RegisterRequest) (string, error) {
+	if err := s.ValidatePassword(ctx, req.Password, req.Username, req.Email); err != nil {
+		return "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	query := `
+		INSERT INTO users (tenant_id, username, email, password_hash, first_name, last_name, phone, role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 'citizen')
+		RETURNING id
+	`
+
+	var userID string
+	err = s.db.QueryRowContext(ctx, query,
+		req.TenantID,
+		req.Username,
+		req.Email,
+		string(hash),
+		req.FirstName,
+		req.LastName,
+		req.Phone,
+	).Scan(&userID)
+	if err != nil {
+		return "", err
+	}
+
+	s.logger.Info("User registered", "user_id", userID, "username", req.Username, "tenant_id", req.TenantID)
+	return userID, nil
+}
+
+// RequestPasswordReset issues a single-use reset token and emails it. It
+// succeeds silently for unknown emails so the endpoint can't enumerate users.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) error {
+	var userID string
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM users WHERE email = $1 AND is_active = true`, email).Scan(&userID)
+	if err != nil {
+		return nil
+	}
+
+	token, err := s.issueResetToken(ctx, userID, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	s.notifyUser(userID, "password_reset", "high",
+		"Reset your password",
+		fmt.Sprintf("Use this token to reset your password within the next hour: %s", token),
+		nil,
+		[]string{"email"},
+	)
+
+	return nil
+}
+
+// issueResetToken stores a single-use token that ResetPassword accepts for
+// userID until ttl passes.
+func (s *Service) issueResetToken(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	token := base64.URLEncoding.EncodeToString(tokenBytes)
+
+	key := fmt.Sprintf("password_reset:%s", token)
+	if err := s.redis.Set(ctx, key, userID, ttl); err != nil {
+		return "", fmt.Errorf("failed to store reset token: %w", err)
+	}
+	return token, nil
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset.
+func (s *Service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	key := fmt.Sprintf("password_reset:%s", token)
+	userID, err := s.redis.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("invalid or expired reset token")
+	}
+
+	var username, email string
+	err = s.db.QueryRowContext(ctx, `SELECT username, email FROM users WHERE id = $1 AND is_active = true`, userID).Scan(&username, &email)
+	if err != nil {
+		return fmt.Errorf("invalid or expired reset token")
+	}
+
+	if err := s.ValidatePassword(ctx, newPassword, username, email); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	query := `
+		UPDATE users
+		SET password_hash = $1, failed_login_attempts = 0, locked_until = NULL
+		WHERE id = $2
+	`
+	if _, err := s.db.ExecContext(ctx, query, string(hash), userID); err != nil {
+		return err
+	}
+
+	s.redis.Del(ctx, key)
+
+	s.logger.Info("Password reset", "user_id", userID)
+	return nil
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+)
+
+// NewConfig maps the application config onto the auth service settings.
+func NewConfig(cfg *config.Config) *Config {
+	return &Config{
+		JWTSecret:          cfg.JWT.Secret,
+		AccessTokenExpiry:  cfg.JWT.ExpiresIn,
+		RefreshTokenExpiry: cfg.Auth.RefreshTokenExpiry,
+		PasswordMinLength:  cfg.Auth.PasswordMinLength,
+		PasswordPolicy: PasswordPolicy{
+			MinLength:     cfg.Auth.PasswordMinLength,
+			RequireUpper:  cfg.Auth.PasswordPolicy.RequireUpper,
+			RequireLower:  cfg.Auth.PasswordPolicy.RequireLower,
+			RequireDigit:  cfg.Auth.PasswordPolicy.RequireDigit,
+			RequireSymbol: cfg.Auth.PasswordPolicy.RequireSymbol,
+			CheckBreached: cfg.Auth.PasswordPolicy.CheckBreached,
+			BreachTimeout: cfg.Auth.PasswordPolicy.BreachTimeout,
+		},
+		MaxLoginAttempts:     cfg.Auth.MaxLoginAttempts,
+		LockoutDuration:      cfg.Auth.LockoutDuration,
+		RequireMFA:           cfg.Auth.RequireMFA,
+		SlidingSessions:      cfg.Auth.SlidingSessions,
+		SessionMaxLifetime:   cfg.Auth.SessionMaxLifetime,
+		SessionOutageGrace:   cfg.Auth.SessionOutageGrace,
+		ImpossibleTravelKmh:  cfg.Auth.ImpossibleTravelKmh,
+		LoginConfirmationTTL: cfg.Auth.LoginConfirmationTTL,
+		LoginThrottle: LoginThrottle{
+			FreeAttempts:   cfg.Auth.LoginThrottle.FreeAttempts,
+			IPFreeAttempts: cfg.Auth.LoginThrottle.IPFreeAttempts,
+			BaseDelay:      cfg.Auth.LoginThrottle.BaseDelay,
+			MaxDelay:       cfg.Auth.LoginThrottle.MaxDelay,
+			Window:         cfg.Auth.LoginThrottle.Window,
+			CaptchaAfter:   cfg.Auth.LoginThrottle.CaptchaAfter,
+		},
+	}
+}
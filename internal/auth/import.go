@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+const (
+	// MaxImportRows bounds one import. Every row costs a bcrypt hash, so
+	// larger files would outlive the server's write timeout.
+	MaxImportRows = 200
+
+	// Imported users have longer to act on their setup email than a
+	// password reset allows, since they weren't expecting it.
+	passwordSetupTTL = 72 * time.Hour
+)
+
+// Import row outcomes
+const (
+	ImportCreated = "created"
+	ImportExists  = "exists"  // the email or username is already registered
+	ImportInvalid = "invalid" // the row failed validation; nothing was written
+	ImportFailed  = "failed"  // the insert failed on our side
+)
+
+// importRoles are the roles an import may grant. Super admins are created
+// by hand, never in bulk.
+var importRoles = map[string]bool{
+	"citizen":  true,
+	"operator": true,
+	"admin":    true,
+	"service":  true,
+}
+
+var requiredImportColumns = []string{"email", "name", "role"}
+var optionalImportColumns = []string{"ward", "phone"}
+
+// ImportError is returned when the file as a whole can't be imported:
+// it isn't CSV, lacks a required column or has too many rows. No accounts
+// are created.
+type ImportError struct {
+	Reason string
+}
+
+func (e *ImportError) Error() string {
+	return e.Reason
+}
+
+// ImportResult is the outcome for one data row. Row is the line number in
+// the file, counting the header as line 1.
+type ImportResult struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email,omitempty"`
+	Status string `json:"status"`
+	UserID string `json:"user_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type importRow struct {
+	email, firstName, lastName, role, ward, phone string
+}
+
+// ImportUsers creates an account for each row of a CSV file with the
+// columns email, name and role, plus optional ward and phone. Each account
+// gets a random password nobody knows and a password setup email, so the
+// user chooses their own before first login. Rows are independent: one
+// bad row doesn't stop the others, and each gets its own result.
+func (s *Service) ImportUsers(ctx context.Context, tenantID string, file io.Reader) ([]ImportResult, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &ImportError{Reason: "file is empty"}
+	}
+	if err != nil {
+		return nil, &ImportError{Reason: fmt.Sprintf("invalid CSV: %v", err)}
+	}
+	columns, err := importColumns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	type record struct {
+		line   int
+		fields []string
+	}
+	var records []record
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ImportError{Reason: fmt.Sprintf("invalid CSV: %v", err)}
+		}
+		if len(records) == MaxImportRows {
+			return nil, &ImportError{Reason: fmt.Sprintf("file has more than %d rows; split it into smaller files", MaxImportRows)}
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record{line: line, fields: fields})
+	}
+	if len(records) == 0 {
+		return nil, &ImportError{Reason: "file has no rows after the header"}
+	}
+
+	results := make([]ImportResult, 0, len(records))
+	seen := make(map[string]int)
+	for _, rec := range records {
+		row, err := parseImportRow(columns, rec.fields)
+		result := ImportResult{Row: rec.line, Email: row.email}
+		if err == nil {
+			if first, dup := seen[row.email]; dup {
+				err = fmt.Errorf("duplicate of row %d", first)
+			}
+		}
+		if err != nil {
+			result.Status, result.Error = ImportInvalid, err.Error()
+			results = append(results, result)
+			continue
+		}
+		seen[row.email] = rec.line
+
+		result.UserID, result.Status, result.Error = s.importUser(ctx, tenantID, row)
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// importUser creates one account and sends its setup email, returning the
+// new user's ID and the row's status and error message.
+func (s *Service) importUser(ctx context.Context, tenantID string, row *importRow) (string, string, string) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		s.logger.Error("Failed to generate temporary password", "error", err)
+		return "", ImportFailed, "failed to create account"
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(base64.RawURLEncoding.EncodeToString(secret)), bcrypt.DefaultCost)
+	if err != nil {
+		s.logger.Error("Failed to hash temporary password", "error", err)
+		return "", ImportFailed, "failed to create account"
+	}
+
+	query := `
+		INSERT INTO users (tenant_id, username, email, password_hash, first_name, last_name, phone, role, ward)
+		VALUES ($1, $2, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''))
+		RETURNING id
+	`
+
+	var userID string
+	err = s.db.QueryRowContext(ctx, query,
+		tenantID,
+		row.email,
+		string(hash),
+		row.firstName,
+		row.lastName,
+		row.phone,
+		row.role,
+		row.ward,
+	).Scan(&userID)
+	if constraintErr, ok := database.AsConstraintError(err); ok && constraintErr.Kind == database.UniqueViolation {
+		return "", ImportExists, "a user with this email already exists"
+	}
+	if err != nil {
+		s.logger.Error("Failed to import user", "error", err, "email", row.email, "tenant_id", tenantID)
+		return "", ImportFailed, "failed to create account"
+	}
+
+	s.logger.Info("User imported", "user_id", userID, "role", row.role, "tenant_id", tenantID)
+
+	token, err := s.issueResetToken(ctx, userID, passwordSetupTTL)
+	if err != nil {
+		// The account exists, so report it as created; an admin can have
+		// the user request a password reset instead.
+		s.logger.Error("Failed to issue password setup token", "error", err, "user_id", userID)
+		return userID, ImportCreated, "account created but the setup email could not be sent"
+	}
+
+	s.notifyUser(userID, "account_setup", "high",
+		"Set up your UrbanZen account",
+		fmt.Sprintf("An account has been created for you. Use this token to choose your password within the next 3 days: %s", token),
+		nil,
+		[]string{"email"},
+	)
+	return userID, ImportCreated, ""
+}
+
+// importColumns maps each recognised header to its field index.
+func importColumns(header []string) (map[string]int, error) {
+	known := make(map[string]bool)
+	for _, name := range append(requiredImportColumns, optionalImportColumns...) {
+		known[name] = true
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !known[name] {
+			return nil, &ImportError{Reason: fmt.Sprintf("unknown column %q", name)}
+		}
+		if _, dup := columns[name]; dup {
+			return nil, &ImportError{Reason: fmt.Sprintf("column %q appears twice", name)}
+		}
+		columns[name] = i
+	}
+	for _, name := range requiredImportColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, &ImportError{Reason: fmt.Sprintf("missing required column %q", name)}
+		}
+	}
+	return columns, nil
+}
+
+func parseImportRow(columns map[string]int, fields []string) (*importRow, error) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+
+	row := &importRow{
+		email: strings.ToLower(field("email")),
+		role:  strings.ToLower(field("role")),
+		ward:  field("ward"),
+		phone: field("phone"),
+	}
+
+	if row.email == "" {
+		return row, errors.New("email is required")
+	}
+	if addr, err := mail.ParseAddress(row.email); err != nil || addr.Address != row.email {
+		return row, errors.New("email is not a valid address")
+	}
+
+	name := strings.Fields(field("name"))
+	if len(name) == 0 {
+		return row, errors.New("name is required")
+	}
+	row.firstName, row.lastName = name[0], strings.Join(name[1:], " ")
+
+	if !importRoles[row.role] {
+		return row, fmt.Errorf("role must be one of citizen, operator, admin or service (got %q)", row.role)
+	}
+	if len(row.ward) > 100 {
+		return row, errors.New("ward must be at most 100 characters")
+	}
+	if len(row.phone) > 20 {
+		return row, errors.New("phone must be at most 20 characters")
+	}
+	return row, nil
+}
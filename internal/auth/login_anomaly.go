@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/events"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+const (
+	// Faster than a commercial flight between two logins is treated as impossible travel
+	defaultImpossibleTravelKmh = 900.0
+	earthRadiusKm              = 6371.0
+
+	// A confirmation code is discarded after this many wrong tries, so it
+	// can't be guessed within its TTL; the user signs in again for a new one
+	maxConfirmationAttempts = 5
+)
+
+// GeoLocator resolves an IP address to an approximate location. It is
+// optional; without one, only new-IP and new-device checks are performed.
+type GeoLocator interface {
+	Locate(ctx context.Context, ip string) (*GeoInfo, error)
+}
+
+type GeoInfo struct {
+	Country  string          `json:"country"`
+	City     string          `json:"city"`
+	Location models.Location `json:"location"`
+}
+
+type LoginRisk struct {
+	Suspicious bool     `json:"suspicious"`
+	Reasons    []string `json:"reasons,omitempty"`
+	Geo        *GeoInfo `json:"geo,omitempty"`
+}
+
+type loginRecord struct {
+	IPAddress string
+	UserAgent string
+	Geo       *GeoInfo
+	Timestamp time.Time
+}
+
+func (s *Service) SetGeoLocator(locator GeoLocator) {
+	s.geo = locator
+}
+
+func (s *Service) assessLoginRisk(ctx context.Context, userID string, req *LoginRequest) *LoginRisk {
+	risk := &LoginRisk{}
+
+	if s.geo != nil && req.IPAddress != "" {
+		if geo, err := s.geo.Locate(ctx, req.IPAddress); err == nil {
+			risk.Geo = geo
+		} else {
+			s.logger.Warn("Failed to geolocate login IP", "error", err, "ip", req.IPAddress)
+		}
+	}
+
+	last, err := s.getLastSuccessfulLogin(ctx, userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			s.logger.Error("Failed to load login history", "error", err, "user_id", userID)
+		}
+		// First recorded login establishes the baseline
+		return risk
+	}
+
+	if req.IPAddress != "" && !s.hasLoggedInFrom(ctx, userID, "ip_address", req.IPAddress) {
+		risk.Reasons = append(risk.Reasons, "new_ip")
+	}
+
+	if req.UserAgent != "" && !s.hasLoggedInFrom(ctx, userID, "user_agent", req.UserAgent) {
+		risk.Reasons = append(risk.Reasons, "new_device")
+	}
+
+	if risk.Geo != nil && last.Geo != nil {
+		if risk.Geo.Country != "" && last.Geo.Country != "" && risk.Geo.Country != last.Geo.Country {
+			risk.Reasons = append(risk.Reasons, "new_country")
+		}
+
+		if isImpossibleTravel(last.Geo.Location, risk.Geo.Location, time.Since(last.Timestamp), s.impossibleTravelKmh()) {
+			risk.Reasons = append(risk.Reasons, "impossible_travel")
+		}
+	}
+
+	risk.Suspicious = len(risk.Reasons) > 0
+	return risk
+}
+
+// stepUp demands a second factor for a suspicious login: the MFA code if the
+// user has MFA enrolled, otherwise a one-time code sent by email. Wrong codes
+// count as failed logins, towards the lockout and the throttle.
+func (s *Service) stepUp(ctx context.Context, user *models.User, req *LoginRequest, risk *LoginRisk) error {
+	if user.MFAEnabled {
+		if req.MFACode == "" {
+			return fmt.Errorf("MFA code required for this sign-in")
+		}
+		if !s.verifyMFACode(ctx, user.ID, req.MFACode) {
+			s.loginFailed(ctx, req)
+			return fmt.Errorf("invalid MFA code")
+		}
+		return nil
+	}
+
+	key := fmt.Sprintf("login_confirmation:%s", user.ID)
+	attemptsKey := fmt.Sprintf("login_confirmation_attempts:%s", user.ID)
+
+	if req.ConfirmationCode != "" {
+		expected, err := s.redis.Get(ctx, key)
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(req.ConfirmationCode)) == 1 {
+			s.redis.Del(ctx, key, attemptsKey)
+			return nil
+		}
+
+		s.loginFailed(ctx, req)
+		if err == nil {
+			s.confirmationFailed(ctx, key, attemptsKey)
+		}
+		return fmt.Errorf("invalid or expired confirmation code")
+	}
+
+	code, err := generateConfirmationCode()
+	if err != nil {
+		return fmt.Errorf("failed to generate confirmation code: %w", err)
+	}
+
+	if err := s.redis.Set(ctx, key, code, s.loginConfirmationTTL()); err != nil {
+		return fmt.Errorf("failed to store confirmation code: %w", err)
+	}
+	s.redis.Del(ctx, attemptsKey)
+
+	s.notifyUser(user.ID, "login_confirmation", "high",
+		"Confirm your sign-in",
+		fmt.Sprintf("We noticed a sign-in from a new location or device. Use code %s to confirm it was you.", code),
+		map[string]interface{}{"reasons": risk.Reasons, "ip_address": req.IPAddress},
+		[]string{"email"},
+	)
+
+	return fmt.Errorf("login confirmation required, a code has been sent to your email")
+}
+
+// confirmationFailed counts a wrong confirmation code, discarding the code
+// once maxConfirmationAttempts have been made against it. If the count
+// can't be kept the code is discarded at once rather than left open.
+func (s *Service) confirmationFailed(ctx context.Context, key, attemptsKey string) {
+	attempts, err := s.redis.Incr(ctx, attemptsKey)
+	if err == nil {
+		err = s.redis.Expire(ctx, attemptsKey, s.loginConfirmationTTL())
+	}
+	if err != nil || attempts >= maxConfirmationAttempts {
+		s.redis.Del(ctx, key, attemptsKey)
+	}
+}
+
+// recordLogin writes the login history used for anomaly detection and
+// audits. It ignores cancellation so an abandoned attempt is still recorded.
+func (s *Service) recordLogin(ctx context.Context, userID string, req *LoginRequest, risk *LoginRisk, success bool) {
+	query := `
+		INSERT INTO login_history (user_id, ip_address, user_agent, country, city, latitude, longitude,
+			suspicious, reasons, success, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	var country, city string
+	var latitude, longitude *float64
+	if risk.Geo != nil {
+		country, city = risk.Geo.Country, risk.Geo.City
+		latitude, longitude = &risk.Geo.Location.Latitude, &risk.Geo.Location.Longitude
+	}
+
+	reasonsJSON, _ := json.Marshal(risk.Reasons)
+
+	_, err := s.db.Exec(query,
+		userID,
+		nullIfEmpty(req.IPAddress),
+		req.UserAgent,
+		country,
+		city,
+		latitude,
+		longitude,
+		risk.Suspicious,
+		reasonsJSON,
+		success,
+		time.Now(),
+	)
+	if err != nil {
+		s.logger.Error("Failed to record login history", "error", err, "user_id", userID)
+	}
+}
+
+func (s *Service) getLastSuccessfulLogin(ctx context.Context, userID string) (*loginRecord, error) {
+	query := `
+		SELECT COALESCE(host(ip_address), ''), user_agent, country, city, latitude, longitude, created_at
+		FROM login_history
+		WHERE user_id = $1 AND success = true
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	var record loginRecord
+	var country, city string
+	var latitude, longitude sql.NullFloat64
+
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&record.IPAddress,
+		&record.UserAgent,
+		&country,
+		&city,
+		&latitude,
+		&longitude,
+		&record.Timestamp,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if latitude.Valid && longitude.Valid {
+		record.Geo = &GeoInfo{
+			Country:  country,
+			City:     city,
+			Location: models.Location{Latitude: latitude.Float64, Longitude: longitude.Float64},
+		}
+	}
+
+	return &record, nil
+}
+
+func (s *Service) hasLoggedInFrom(ctx context.Context, userID, column, value string) bool {
+	// column is one of a fixed set of identifiers, never user input
+	query := fmt.Sprintf(`
+		SELECT EXISTS (
+			SELECT 1 FROM login_history
+			WHERE user_id = $1 AND success = true AND %s = $2
+		)
+	`, column)
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, query, userID, value).Scan(&exists); err != nil {
+		// Fail towards "known" so a DB hiccup doesn't lock users out
+		return true
+	}
+	return exists
+}
+
+// notifyUser hands a notification to the notification service over Kafka.
+func (s *Service) notifyUser(userID, notificationType, priority, title, message string,
+	metadata map[string]interface{}, channels []string) {
+	if s.bus == nil {
+		return
+	}
+
+	err := events.Publish(context.Background(), s.bus, events.Notification{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Type:     notificationType,
+		Title:    title,
+		Message:  message,
+		Priority: priority,
+		Channels: channels,
+		Metadata: metadata,
+	})
+	metrics.NotificationPublishes.WithLabelValues("auth", metrics.Result(err)).Inc()
+}
+
+func (s *Service) impossibleTravelKmh() float64 {
+	if s.config.ImpossibleTravelKmh > 0 {
+		return s.config.ImpossibleTravelKmh
+	}
+	return defaultImpossibleTravelKmh
+}
+
+func (s *Service) loginConfirmationTTL() time.Duration {
+	if s.config.LoginConfirmationTTL > 0 {
+		return s.config.LoginConfirmationTTL
+	}
+	return 15 * time.Minute
+}
+
+func isImpossibleTravel(from, to models.Location, elapsed time.Duration, maxKmh float64) bool {
+	distance := haversineKm(from, to)
+	// Ignore short hops; IP geolocation is rarely better than this
+	if distance < 100 {
+		return false
+	}
+
+	hours := elapsed.Hours()
+	if hours <= 0 {
+		return true
+	}
+
+	return distance/hours > maxKmh
+}
+
+func haversineKm(a, b models.Location) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := (b.Latitude - a.Latitude) * math.Pi / 180
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+func generateConfirmationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
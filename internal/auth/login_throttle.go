@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+var (
+	// ErrCaptchaRequired asks the client to retry with a captcha_token.
+	ErrCaptchaRequired = errors.New("captcha required")
+
+	// ErrCaptchaInvalid means the captcha_token was rejected by the verifier.
+	ErrCaptchaInvalid = errors.New("captcha verification failed")
+)
+
+// LoginThrottle holds the settings for per-username and per-IP login
+// throttling; see config.Auth.LoginThrottle.
+type LoginThrottle struct {
+	FreeAttempts   int
+	IPFreeAttempts int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	Window         time.Duration
+
+	// CaptchaAfter counts failures past a subject's free attempts, so a
+	// shared IP is challenged later than a single username
+	CaptchaAfter int
+}
+
+// ThrottleError is returned while a client must wait before it may try
+// to sign in again.
+type ThrottleError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("too many failed sign-ins, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+var loginThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_login_throttled_total",
+	Help: "Login attempts refused before the password check, by reason: delay or captcha.",
+}, []string{"reason"})
+
+// checkThrottleScript reads each subject's state and returns the most
+// failures past any subject's free attempts and the latest time, in Unix
+// milliseconds, before which another attempt is refused.
+// KEYS: subject hashes. ARGV: each subject's free attempts, in order.
+const checkThrottleScript = `
+local excess, wait = 0, 0
+for i, key in ipairs(KEYS) do
+	local state = redis.call('HMGET', key, 'failures', 'until')
+	excess = math.max(excess, (tonumber(state[1]) or 0) - tonumber(ARGV[i]))
+	wait = math.max(wait, tonumber(state[2]) or 0)
+end
+return {excess, wait}
+`
+
+// failThrottleScript counts a failure against each subject. Past its free
+// attempts a subject must wait base * 2^(failures past free - 1), capped.
+// State lives for the window after the last failure, or the wait if longer.
+// KEYS: subject hashes. ARGV: now ms, base ms, max ms, window ms, then each
+// subject's free attempts.
+const failThrottleScript = `
+local now, base, cap, window = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+for i, key in ipairs(KEYS) do
+	local excess = redis.call('HINCRBY', key, 'failures', 1) - tonumber(ARGV[4 + i])
+	local delay = 0
+	if excess > 0 then
+		delay = math.floor(math.min(cap, base * 2 ^ math.min(excess - 1, 40)))
+		redis.call('HSET', key, 'until', now + delay)
+	end
+	redis.call('PEXPIRE', key, math.max(window, delay))
+end
+return 1
+`
+
+func throttleUserKey(username string) string {
+	return fmt.Sprintf("login_throttle:user:%s", strings.ToLower(username))
+}
+
+// throttleSubjects returns the Redis keys a login attempt counts against
+// and each one's free attempts, as script arguments.
+func (s *Service) throttleSubjects(req *LoginRequest) ([]string, []interface{}) {
+	keys := []string{throttleUserKey(req.Username)}
+	free := []interface{}{s.config.LoginThrottle.FreeAttempts}
+	if req.IPAddress != "" {
+		keys = append(keys, fmt.Sprintf("login_throttle:ip:%s", req.IPAddress))
+		free = append(free, s.config.LoginThrottle.IPFreeAttempts)
+	}
+	return keys, free
+}
+
+// checkLoginThrottle refuses an attempt made before the username's or
+// IP's delay has passed, and demands a CAPTCHA once either has failed
+// often enough. It fails open when Redis is unreachable: lockouts still
+// apply from the database.
+func (s *Service) checkLoginThrottle(ctx context.Context, req *LoginRequest) error {
+	keys, free := s.throttleSubjects(req)
+	reply, err := s.redis.Eval(ctx, checkThrottleScript, keys, free...)
+	if err != nil {
+		s.logger.Warn("Login throttle unavailable", "error", err, "username", req.Username)
+		metrics.RedisFallbacks.WithLabelValues("login_throttle").Inc()
+		return nil
+	}
+
+	state, ok := reply.([]interface{})
+	if !ok || len(state) != 2 {
+		return nil
+	}
+	excess, _ := state[0].(int64)
+	until, _ := state[1].(int64)
+
+	if wait := time.Until(time.UnixMilli(until)); wait > 0 {
+		loginThrottled.WithLabelValues("delay").Inc()
+		return &ThrottleError{RetryAfter: wait}
+	}
+
+	threshold := s.config.LoginThrottle.CaptchaAfter
+	if s.captcha == nil || threshold <= 0 || excess < int64(threshold) {
+		return nil
+	}
+	if req.CaptchaToken == "" {
+		loginThrottled.WithLabelValues("captcha").Inc()
+		return ErrCaptchaRequired
+	}
+
+	valid, err := s.captcha.Verify(ctx, req.CaptchaToken, req.IPAddress)
+	if err != nil {
+		// The delays still apply, so an outage at the provider doesn't
+		// lock everyone out
+		s.logger.Warn("CAPTCHA verification unavailable", "error", err, "username", req.Username)
+		return nil
+	}
+	if !valid {
+		loginThrottled.WithLabelValues("captcha").Inc()
+		return ErrCaptchaInvalid
+	}
+	return nil
+}
+
+// loginFailed counts a failed attempt towards both the account lockout and
+// the login throttle.
+func (s *Service) loginFailed(ctx context.Context, req *LoginRequest) {
+	s.incrementFailedAttempts(ctx, req.Username)
+
+	throttle := s.config.LoginThrottle
+	keys, free := s.throttleSubjects(req)
+	args := append([]interface{}{
+		time.Now().UnixMilli(),
+		throttle.BaseDelay.Milliseconds(),
+		throttle.MaxDelay.Milliseconds(),
+		throttle.Window.Milliseconds(),
+	}, free...)
+	if _, err := s.redis.Eval(ctx, failThrottleScript, keys, args...); err != nil {
+		s.logger.Warn("Failed to record login failure for throttling", "error", err, "username", req.Username)
+		metrics.RedisFallbacks.WithLabelValues("login_throttle").Inc()
+	}
+}
+
+// clearLoginThrottle forgets a username's failures after it signs in. The
+// IP's count is left to expire, so one good password doesn't reset a
+// client that is guessing at many accounts.
+func (s *Service) clearLoginThrottle(ctx context.Context, username string) {
+	s.redis.Del(ctx, throttleUserKey(username))
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const hibpRangeURL = "https://api.pwnedpasswords.com/range/"
+
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	CheckBreached bool
+	BreachTimeout time.Duration
+}
+
+// PasswordPolicyError lists every rule a password failed, so clients can
+// show all problems at once instead of one per attempt.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet policy: " + strings.Join(e.Violations, "; ")
+}
+
+// ValidatePassword checks a candidate password against the configured policy
+// and, if enabled, against the Have I Been Pwned breach corpus.
+func (s *Service) ValidatePassword(ctx context.Context, password string, identifiers ...string) error {
+	policy := s.config.PasswordPolicy
+	if policy.MinLength == 0 {
+		policy.MinLength = s.config.PasswordMinLength
+	}
+
+	var violations []string
+
+	if len([]rune(password)) < policy.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", policy.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	if policy.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if policy.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	lower := strings.ToLower(password)
+	for _, id := range identifiers {
+		if id != "" && strings.Contains(lower, strings.ToLower(id)) {
+			violations = append(violations, "must not contain your username or email")
+			break
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	if policy.CheckBreached {
+		breached, err := s.isBreachedPassword(ctx, password, policy.BreachTimeout)
+		if err != nil {
+			// Availability of a third-party API shouldn't block password changes
+			s.logger.Warn("Breached password check failed", "error", err)
+		} else if breached {
+			return &PasswordPolicyError{Violations: []string{"has appeared in a known data breach, choose a different password"}}
+		}
+	}
+
+	return nil
+}
+
+// isBreachedPassword uses the HIBP k-anonymity range API: only the first five
+// hex characters of the SHA-1 hash ever leave the service.
+func (s *Service) isBreachedPassword(ctx context.Context, password string, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+
+	hash := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hibpRangeURL+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// Padding hides the real response size from on-path observers
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "urbanzen-auth")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status from breach API: %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		// Padded entries carry a zero count
+		if parts[0] == suffix && strings.TrimSpace(parts[1]) != "0" {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
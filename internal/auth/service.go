@@ -3,23 +3,36 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 	
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 	"github.com/bhanukaranwal/urbanzen/internal/models"
 	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/events"
 	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
 )
 
 type Service struct {
-	db     *database.PostgresDB
-	redis  *database.RedisClient
-	config *Config
-	logger logger.Logger
+	db       *database.PostgresDB
+	redis    *database.RedisClient
+	bus      *events.Bus
+	geo      GeoLocator
+	captcha  CaptchaVerifier
+	sso      map[string]*ssoProvider
+	config   *Config
+	logger   logger.Logger
+	
+	sessions *sessionChecker
 }
 
 type Config struct {
@@ -30,37 +43,65 @@ type Config struct {
 	MaxLoginAttempts    int
 	LockoutDuration     time.Duration
 	RequireMFA          bool
+	PasswordPolicy      PasswordPolicy
+	
+	// With sliding sessions each refresh extends the refresh-token window,
+	// but never beyond SessionMaxLifetime from the original login
+	SlidingSessions    bool
+	SessionMaxLifetime time.Duration
+	
+	// How long session checks keep passing while Redis is unreachable
+	SessionOutageGrace time.Duration
+	
+	// Login anomaly detection
+	ImpossibleTravelKmh  float64
+	LoginConfirmationTTL time.Duration
+	
+	LoginThrottle LoginThrottle
 }
 
 type Claims struct {
 	UserID      string   `json:"user_id"`
 	Username    string   `json:"username"`
 	Role        string   `json:"role"`
+	TenantID    string   `json:"tenant_id"`
 	Permissions []string `json:"permissions"`
 	SessionID   string   `json:"session_id"`
+	Regions     []string `json:"regions,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type LoginRequest struct {
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
-	MFACode  string `json:"mfa_code,omitempty"`
+	Username         string `json:"username" validate:"required"`
+	Password         string `json:"password" validate:"required"`
+	MFACode          string `json:"mfa_code,omitempty"`
+	ConfirmationCode string `json:"confirmation_code,omitempty"`
+	CaptchaToken     string `json:"captcha_token,omitempty"`
+	
+	// Populated by the HTTP handler from the request, never from the body
+	IPAddress string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 type LoginResponse struct {
-	AccessToken  string           `json:"access_token"`
-	RefreshToken string           `json:"refresh_token"`
-	ExpiresIn    int64            `json:"expires_in"`
-	User         *models.UserInfo `json:"user"`
+	AccessToken      string           `json:"access_token"`
+	RefreshToken     string           `json:"refresh_token"`
+	ExpiresIn        int64            `json:"expires_in"`
+	RefreshExpiresIn int64            `json:"refresh_expires_in"`
+	SessionExpiresAt time.Time        `json:"session_expires_at"`
+	User             *models.UserInfo `json:"user"`
 }
 
-func NewService(db *database.PostgresDB, redis *database.RedisClient, 
+func NewService(db *database.PostgresDB, redis *database.RedisClient, bus *events.Bus,
 	config *Config, logger logger.Logger) *Service {
 	return &Service{
-		db:     db,
-		redis:  redis,
-		config: config,
-		logger: logger,
+		db:       db,
+		redis:    redis,
+		bus:      bus,
+		config:   config,
+		logger:   logger,
+		
+		sessions: newSessionChecker(redis, config.SessionOutageGrace, logger),
 	}
 }
 
@@ -69,11 +110,14 @@ func (s *Service) Login(ctx context.Context, req *LoginRequest) (*LoginResponse,
 	if err := s.checkRateLimit(ctx, req.Username); err != nil {
 		return nil, err
 	}
+	if err := s.checkLoginThrottle(ctx, req); err != nil {
+		return nil, err
+	}
 	
 	// Get user from database
 	user, err := s.getUserByUsername(ctx, req.Username)
 	if err != nil {
-		s.incrementFailedAttempts(ctx, req.Username)
+		s.loginFailed(ctx, req)
 		return nil, fmt.Errorf("invalid credentials")
 	}
 	
@@ -84,56 +128,113 @@ func (s *Service) Login(ctx context.Context, req *LoginRequest) (*LoginResponse,
 	
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		s.incrementFailedAttempts(ctx, req.Username)
+		s.loginFailed(ctx, req)
 		return nil, fmt.Errorf("invalid credentials")
 	}
 	
-	// Check MFA if required
+	risk, err := s.secondFactor(ctx, user, req)
+	if err != nil {
+		return nil, err
+	}
+	
+	resp, sessionID, err := s.finishLogin(ctx, user, req, risk)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Log successful login
+	s.logger.Info("User logged in successfully", 
+		"user_id", user.ID, 
+		"username", user.Username,
+		"session_id", sessionID,
+	)
+	
+	return resp, nil
+}
+
+// secondFactor checks what a user must give besides their password or
+// single sign-on: the MFA code when MFA is required, and the step-up a
+// suspicious sign-in (new IP/device, impossible travel) calls for. It
+// returns the sign-in's risk for the login history.
+func (s *Service) secondFactor(ctx context.Context, user *models.User, req *LoginRequest) (*LoginRisk, error) {
 	if s.config.RequireMFA && user.MFAEnabled {
 		if req.MFACode == "" {
 			return nil, fmt.Errorf("MFA code required")
 		}
 		
 		if !s.verifyMFACode(ctx, user.ID, req.MFACode) {
-			s.incrementFailedAttempts(ctx, req.Username)
+			s.loginFailed(ctx, req)
 			return nil, fmt.Errorf("invalid MFA code")
 		}
 	}
 	
-	// Reset failed attempts
+	risk := s.assessLoginRisk(ctx, user.ID, req)
+	if risk.Suspicious {
+		s.logger.Warn("Suspicious login detected",
+			"user_id", user.ID,
+			"ip", req.IPAddress,
+			"reasons", risk.Reasons,
+		)
+		
+		if err := s.stepUp(ctx, user, req, risk); err != nil {
+			s.recordLogin(ctx, user.ID, req, risk, false)
+			return nil, err
+		}
+	}
+	return risk, nil
+}
+
+// finishLogin starts a session for a user who has passed every check,
+// clearing their failed attempts and recording the login.
+func (s *Service) finishLogin(ctx context.Context, user *models.User, req *LoginRequest, risk *LoginRisk) (*LoginResponse, string, error) {
 	s.resetFailedAttempts(ctx, req.Username)
+	s.clearLoginThrottle(ctx, req.Username)
 	
-	// Generate tokens
+	resp, sessionID, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, "", err
+	}
+	
+	s.updateLastLogin(ctx, user.ID)
+	s.recordLogin(ctx, user.ID, req, risk, true)
+	
+	if risk.Suspicious {
+		s.notifyUser(user.ID, "security_alert", "high",
+			"New sign-in to your account",
+			fmt.Sprintf("Your account was signed in from %s. If this wasn't you, reset your password immediately.", req.IPAddress),
+			map[string]interface{}{"reasons": risk.Reasons, "ip_address": req.IPAddress, "user_agent": req.UserAgent},
+			[]string{"email", "push"},
+		)
+	}
+	return resp, sessionID, nil
+}
+
+// startSession issues a new session's tokens to a user who has proved who
+// they are, by password or single sign-on, and returns its ID.
+func (s *Service) startSession(ctx context.Context, user *models.User) (*LoginResponse, string, error) {
 	sessionID := uuid.New().String()
-	accessToken, err := s.generateAccessToken(user, sessionID)
+	accessToken, err := s.generateAccessToken(ctx, user, sessionID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
+		return nil, "", fmt.Errorf("failed to generate access token: %w", err)
 	}
 	
-	refreshToken, err := s.generateRefreshToken(user.ID, sessionID)
+	startedAt := time.Now()
+	refreshToken, refreshTTL, err := s.generateRefreshToken(user.ID, sessionID, startedAt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+		return nil, "", fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 	
 	// Store session
-	if err := s.storeSession(ctx, sessionID, user.ID); err != nil {
-		return nil, fmt.Errorf("failed to store session: %w", err)
+	if err := s.storeSession(ctx, sessionID, user.ID, refreshToken, refreshTTL); err != nil {
+		return nil, "", fmt.Errorf("failed to store session: %w", err)
 	}
 	
-	// Update last login
-	s.updateLastLogin(ctx, user.ID)
-	
-	// Log successful login
-	s.logger.Info("User logged in successfully", 
-		"user_id", user.ID, 
-		"username", user.Username,
-		"session_id", sessionID,
-	)
-	
 	return &LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(s.config.AccessTokenExpiry.Seconds()),
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        int64(s.config.AccessTokenExpiry.Seconds()),
+		RefreshExpiresIn: int64(refreshTTL.Seconds()),
+		SessionExpiresAt: s.sessionDeadline(startedAt),
 		User: &models.UserInfo{
 			ID:        user.ID,
 			Username:  user.Username,
@@ -142,11 +243,15 @@ func (s *Service) Login(ctx context.Context, req *LoginRequest) (*LoginResponse,
 			LastName:  user.LastName,
 			Role:      user.Role,
 		},
-	}, nil
+	}, sessionID, nil
 }
 
-func (s *Service) generateAccessToken(user *models.User, sessionID string) (string, error) {
-	permissions, err := s.getUserPermissions(context.Background(), user.ID)
+func (s *Service) generateAccessToken(ctx context.Context, user *models.User, sessionID string) (string, error) {
+	permissions, err := s.getUserPermissions(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+	regions, err := s.getUserRegions(ctx, user.ID)
 	if err != nil {
 		return "", err
 	}
@@ -155,8 +260,10 @@ func (s *Service) generateAccessToken(user *models.User, sessionID string) (stri
 		UserID:      user.ID,
 		Username:    user.Username,
 		Role:        user.Role,
+		TenantID:    user.TenantID,
 		Permissions: permissions,
 		SessionID:   sessionID,
+		Regions:     regions,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.config.AccessTokenExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -171,20 +278,50 @@ func (s *Service) generateAccessToken(user *models.User, sessionID string) (stri
 	return token.SignedString([]byte(s.config.JWTSecret))
 }
 
-func (s *Service) generateRefreshToken(userID, sessionID string) (string, error) {
+func (s *Service) generateRefreshToken(userID, sessionID string, startedAt time.Time) (string, time.Duration, error) {
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
-		return "", err
+		return "", 0, err
 	}
 	
 	refreshToken := base64.URLEncoding.EncodeToString(tokenBytes)
+	ttl := s.refreshTokenTTL(startedAt)
+	if ttl <= 0 {
+		return "", 0, fmt.Errorf("session expired")
+	}
 	
-	// Store refresh token with expiry
+	// Store refresh token with expiry; the session start travels with it so
+	// later refreshes can enforce the absolute lifetime
 	key := fmt.Sprintf("refresh_token:%s", refreshToken)
-	value := fmt.Sprintf("%s:%s", userID, sessionID)
+	value := fmt.Sprintf("%s:%s:%d", userID, sessionID, startedAt.Unix())
 	
-	err := s.redis.Set(context.Background(), key, value, s.config.RefreshTokenExpiry)
-	return refreshToken, err
+	if err := s.redis.Set(context.Background(), key, value, ttl); err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrSessionStoreUnavailable, err)
+	}
+	return refreshToken, ttl, nil
+}
+
+// refreshTokenTTL returns how long a newly issued refresh token should live.
+// Without sliding sessions the window is fixed from the original login.
+func (s *Service) refreshTokenTTL(startedAt time.Time) time.Duration {
+	now := time.Now()
+	
+	if !s.config.SlidingSessions {
+		return startedAt.Add(s.config.RefreshTokenExpiry).Sub(now)
+	}
+	
+	ttl := s.config.RefreshTokenExpiry
+	if remaining := s.sessionDeadline(startedAt).Sub(now); remaining < ttl {
+		ttl = remaining
+	}
+	return ttl
+}
+
+func (s *Service) sessionDeadline(startedAt time.Time) time.Time {
+	if s.config.SlidingSessions && s.config.SessionMaxLifetime > 0 {
+		return startedAt.Add(s.config.SessionMaxLifetime)
+	}
+	return startedAt.Add(s.config.RefreshTokenExpiry)
 }
 
 func (s *Service) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
@@ -216,16 +353,24 @@ func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*Login
 	// Get user and session from refresh token
 	key := fmt.Sprintf("refresh_token:%s", refreshToken)
 	value, err := s.redis.Get(ctx, key)
-	if err != nil {
+	if database.IsMiss(err) {
 		return nil, fmt.Errorf("invalid refresh token")
 	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSessionStoreUnavailable, err)
+	}
 	
 	parts := strings.Split(value, ":")
-	if len(parts) != 2 {
+	if len(parts) != 3 {
 		return nil, fmt.Errorf("invalid refresh token format")
 	}
 	
 	userID, sessionID := parts[0], parts[1]
+	startedUnix, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token format")
+	}
+	startedAt := time.Unix(startedUnix, 0)
 	
 	// Get user
 	user, err := s.getUserByID(ctx, userID)
@@ -234,23 +379,29 @@ func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*Login
 	}
 	
 	// Generate new tokens
-	newAccessToken, err := s.generateAccessToken(user, sessionID)
+	newAccessToken, err := s.generateAccessToken(ctx, user, sessionID)
 	if err != nil {
 		return nil, err
 	}
 	
-	newRefreshToken, err := s.generateRefreshToken(userID, sessionID)
+	newRefreshToken, refreshTTL, err := s.generateRefreshToken(userID, sessionID, startedAt)
 	if err != nil {
 		return nil, err
 	}
 	
+	if err := s.storeSession(ctx, sessionID, userID, newRefreshToken, refreshTTL); err != nil {
+		return nil, err
+	}
+	
 	// Invalidate old refresh token
 	s.redis.Del(ctx, key)
 	
 	return &LoginResponse{
-		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken,
-		ExpiresIn:    int64(s.config.AccessTokenExpiry.Seconds()),
+		AccessToken:      newAccessToken,
+		RefreshToken:     newRefreshToken,
+		ExpiresIn:        int64(s.config.AccessTokenExpiry.Seconds()),
+		RefreshExpiresIn: int64(refreshTTL.Seconds()),
+		SessionExpiresAt: s.sessionDeadline(startedAt),
 		User: &models.UserInfo{
 			ID:        user.ID,
 			Username:  user.Username,
@@ -262,30 +413,43 @@ func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*Login
 	}, nil
 }
 
+// Logout revokes the session and its refresh token. Unlike the session
+// check it fails closed: reporting success without recording the
+// revocation would leave the user signed in.
 func (s *Service) Logout(ctx context.Context, sessionID string) error {
-	// Invalidate session
-	sessionKey := fmt.Sprintf("session:%s", sessionID)
+	key := sessionKey(sessionID)
 	
 	// Get refresh token to invalidate it too
-	if sessionData, err := s.redis.Get(ctx, sessionKey); err == nil {
-		var session models.Session
+	sessionData, err := s.redis.Get(ctx, key)
+	if err != nil && !database.IsMiss(err) {
+		return err
+	}
+	if err == nil {
+		var session storedSession
 		if err := json.Unmarshal([]byte(sessionData), &session); err == nil {
 			refreshKey := fmt.Sprintf("refresh_token:%s", session.RefreshToken)
 			s.redis.Del(ctx, refreshKey)
 		}
 	}
 	
-	return s.redis.Del(ctx, sessionKey)
+	return s.redis.Del(ctx, key)
 }
 
 func (s *Service) checkRateLimit(ctx context.Context, username string) error {
 	key := fmt.Sprintf("login_attempts:%s", username)
 	attempts, err := s.redis.Get(ctx, key)
-	if err != nil {
+	if database.IsMiss(err) {
 		return nil // No previous attempts
 	}
+	if err != nil {
+		// The counter only spares the database: lockouts are enforced from
+		// users.locked_until, so logins carry on without it
+		s.logger.Warn("Login attempt counter unavailable", "error", err, "username", username)
+		metrics.RedisFallbacks.WithLabelValues("login_attempts").Inc()
+		return nil
+	}
 	
-	if attempts >= fmt.Sprintf("%d", s.config.MaxLoginAttempts) {
+	if count, _ := strconv.Atoi(attempts); count >= s.config.MaxLoginAttempts {
 		return fmt.Errorf("too many login attempts, try again later")
 	}
 	
@@ -296,11 +460,78 @@ func (s *Service) incrementFailedAttempts(ctx context.Context, username string)
 	key := fmt.Sprintf("login_attempts:%s", username)
 	s.redis.Incr(ctx, key)
 	s.redis.Expire(ctx, key, s.config.LockoutDuration)
+	
+	// The database is authoritative for lockouts so a Redis flush can't lift one.
+	// An expired lockout restarts the count rather than relocking immediately.
+	// It ignores cancellation so a client can't dodge the count by hanging up.
+	query := `
+		UPDATE users u
+		SET failed_login_attempts = a.attempts,
+			locked_until = CASE WHEN a.attempts >= $2 THEN $3 ELSE u.locked_until END
+		FROM (
+			SELECT id,
+				CASE WHEN locked_until IS NOT NULL AND locked_until <= NOW() THEN 1
+					ELSE failed_login_attempts + 1 END AS attempts
+			FROM users
+			WHERE username = $1
+		) a
+		WHERE u.id = a.id
+		RETURNING u.failed_login_attempts, u.locked_until
+	`
+	
+	var attempts int
+	var lockedUntil *time.Time
+	err := s.db.QueryRow(query, username, s.config.MaxLoginAttempts, time.Now().Add(s.config.LockoutDuration)).
+		Scan(&attempts, &lockedUntil)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			s.logger.Error("Failed to persist failed login attempt", "error", err, "username", username)
+		}
+		return
+	}
+	
+	if attempts >= s.config.MaxLoginAttempts && lockedUntil != nil {
+		s.logger.Warn("Account locked after repeated failed logins",
+			"username", username,
+			"attempts", attempts,
+			"locked_until", lockedUntil,
+		)
+	}
 }
 
 func (s *Service) resetFailedAttempts(ctx context.Context, username string) {
 	key := fmt.Sprintf("login_attempts:%s", username)
 	s.redis.Del(ctx, key)
+	
+	query := `
+		UPDATE users
+		SET failed_login_attempts = 0, locked_until = NULL
+		WHERE username = $1 AND (failed_login_attempts > 0 OR locked_until IS NOT NULL)
+	`
+	if _, err := s.db.ExecContext(ctx, query, username); err != nil {
+		s.logger.Error("Failed to reset failed login attempts", "error", err, "username", username)
+	}
+}
+
+// UnlockAccount clears a lockout in both the database and Redis. It returns
+// sql.ErrNoRows if the user doesn't exist within the tenant.
+func (s *Service) UnlockAccount(ctx context.Context, tenantID, userID, actorID string) error {
+	query := `
+		UPDATE users
+		SET failed_login_attempts = 0, locked_until = NULL
+		WHERE id = $1 AND tenant_id = $2
+		RETURNING username
+	`
+	
+	var username string
+	if err := s.db.QueryRowContext(ctx, query, userID, tenantID).Scan(&username); err != nil {
+		return err
+	}
+	
+	s.redis.Del(ctx, fmt.Sprintf("login_attempts:%s", username))
+	
+	s.logger.Info("Account unlocked", "user_id", userID, "username", username, "unlocked_by", actorID)
+	return nil
 }
 
 // Role-Based Access Control (RBAC) Implementation
@@ -330,7 +561,7 @@ func (s *Service) getUserPermissions(ctx context.Context, userID string) ([]stri
 		WHERE u.id = $1 AND u.is_active = true
 	`
 	
-	rows, err := s.db.Query(query, userID)
+	rows, err := s.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -346,4 +577,18 @@ func (s *Service) getUserPermissions(ctx context.Context, userID string) ([]stri
 	}
 	
 	return permissions, nil
+}
+
+// getUserRegions returns the regions a user may reach: their own first,
+// "" if they have none, then any they were granted.
+func (s *Service) getUserRegions(ctx context.Context, userID string) ([]string, error) {
+	var region string
+	var allowed []string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(region, ''), allowed_regions FROM users WHERE id = $1
+	`, userID).Scan(&region, pq.Array(&allowed))
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{region}, allowed...), nil
 }
\ No newline at end of file
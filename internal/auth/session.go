@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// ErrSessionStoreUnavailable is returned when a session or refresh token
+// can't be read or written because Redis is unreachable. Callers should
+// answer 503 rather than treating it as bad credentials.
+var ErrSessionStoreUnavailable = errors.New("sign-in is temporarily unavailable, try again shortly")
+
+// storedSession is the Redis record behind a session ID. It remembers the
+// current refresh token so logging out can revoke it too.
+type storedSession struct {
+	UserID       string `json:"user_id"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func sessionKey(sessionID string) string {
+	return fmt.Sprintf("session:%s", sessionID)
+}
+
+// storeSession records a live session for as long as its refresh token
+// lives. It is called again on every refresh to track the new token.
+func (s *Service) storeSession(ctx context.Context, sessionID, userID, refreshToken string, ttl time.Duration) error {
+	value, err := json.Marshal(storedSession{UserID: userID, RefreshToken: refreshToken})
+	if err != nil {
+		return err
+	}
+
+	if err := s.redis.Set(ctx, sessionKey(sessionID), value, ttl); err != nil {
+		return fmt.Errorf("%w: %v", ErrSessionStoreUnavailable, err)
+	}
+	return nil
+}
+
+// isSessionValid reports whether the session has not been logged out or
+// expired.
+func (s *Service) isSessionValid(ctx context.Context, sessionID, userID string) bool {
+	return s.sessions.valid(ctx, sessionID, userID)
+}
+
+// sessionChecker looks sessions up in Redis. Access tokens live for
+// jwt.expires_in, up to a day, so it can't simply trust a signed token
+// while Redis is down: a logged-out token would keep working that long.
+// It fails open only for grace after Redis last answered it, so a blip
+// doesn't sign everyone out, and closed after that.
+type sessionChecker struct {
+	redis  *database.RedisClient
+	grace  time.Duration
+	logger logger.Logger
+
+	// Unix nanoseconds of the last lookup Redis answered
+	lastAnswered atomic.Int64
+}
+
+func newSessionChecker(redis *database.RedisClient, grace time.Duration, log logger.Logger) *sessionChecker {
+	return &sessionChecker{redis: redis, grace: grace, logger: log}
+}
+
+func (s *sessionChecker) valid(ctx context.Context, sessionID, userID string) bool {
+	if sessionID == "" {
+		return false
+	}
+	value, err := s.redis.Get(ctx, sessionKey(sessionID))
+	if err != nil && !database.IsMiss(err) {
+		metrics.RedisFallbacks.WithLabelValues("session_check").Inc()
+		if time.Since(time.Unix(0, s.lastAnswered.Load())) < s.grace {
+			s.logger.Warn("Session store unavailable, accepting token within the outage grace",
+				"error", err, "session_id", sessionID)
+			return true
+		}
+		s.logger.Error("Session store unavailable, refusing token", "error", err, "session_id", sessionID)
+		return false
+	}
+	s.lastAnswered.Store(time.Now().UnixNano())
+	if err != nil {
+		return false
+	}
+
+	var session storedSession
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return false
+	}
+	return session.UserID == userID
+}
@@ -0,0 +1,446 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// SSOStateTTL is how long a user has to sign in at the provider, and then
+// to give a second factor if one is asked for.
+const SSOStateTTL = 10 * time.Minute
+
+// takeStateScript reads and deletes a sign-in's state in one step, so a
+// callback can only be used once.
+const takeStateScript = `
+local value = redis.call('GET', KEYS[1])
+if value then
+	redis.call('DEL', KEYS[1])
+end
+return value
+`
+
+var (
+	ErrUnknownProvider = errors.New("unknown sign-in provider")
+	ErrSSOStateInvalid = errors.New("sign-in expired or was already completed, start again")
+
+	// ErrIdentityProvider wraps failures talking to the provider or
+	// verifying what it returned
+	ErrIdentityProvider = errors.New("sign-in provider failed")
+)
+
+// SSODeniedError is returned when the provider vouched for the user but
+// they may not sign in here.
+type SSODeniedError struct {
+	Reason string
+}
+
+func (e *SSODeniedError) Error() string {
+	return e.Reason
+}
+
+// SSOChallengeError is returned when the provider vouched for the user but
+// they must still give an MFA or confirmation code, as a password login
+// would. The sign-in is held under Token for VerifySSO to finish.
+type SSOChallengeError struct {
+	Token  string
+	Reason string
+}
+
+func (e *SSOChallengeError) Error() string {
+	return e.Reason
+}
+
+// ExternalIdentity is a user as an identity provider knows them. Subject
+// is the provider's stable ID for them.
+type ExternalIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// IdentityProvider signs users in with an external service, such as a
+// state government's SSO. OIDCProvider covers OpenID Connect; other
+// protocols can be added by implementing this.
+type IdentityProvider interface {
+	// AuthURL is where to send the browser to sign in. state, nonce and
+	// the PKCE code challenge must be sent to the provider.
+	AuthURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+
+	// Identify exchanges the code from the provider's callback for the
+	// identity that signed in, checking it was issued for nonce.
+	Identify(ctx context.Context, code, nonce, codeVerifier string) (*ExternalIdentity, error)
+}
+
+// SSOPolicy decides who a provider may sign in and what happens to
+// identities without a local user.
+type SSOPolicy struct {
+	TenantID       string
+	DefaultRole    string
+	AutoProvision  bool
+	LinkByEmail    bool
+	AllowedDomains []string
+	FrontendURL    string
+}
+
+type ssoProvider struct {
+	provider IdentityProvider
+	policy   SSOPolicy
+}
+
+// ssoState is what a sign-in in progress remembers between redirecting to
+// the provider and its callback.
+type ssoState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+func ssoStateKey(state string) string {
+	return fmt.Sprintf("sso_state:%s", state)
+}
+
+// ssoPending is a sign-in the provider has vouched for that is waiting on
+// a second factor.
+type ssoPending struct {
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+}
+
+func ssoPendingKey(token string) string {
+	return fmt.Sprintf("sso_pending:%s", token)
+}
+
+// RegisterProvider makes a provider available for sign-in under name.
+// Call it before serving requests.
+func (s *Service) RegisterProvider(name string, provider IdentityProvider, policy SSOPolicy) {
+	if s.sso == nil {
+		s.sso = make(map[string]*ssoProvider)
+	}
+	s.sso[name] = &ssoProvider{provider: provider, policy: policy}
+}
+
+// SSOProviders lists the providers users can sign in with.
+func (s *Service) SSOProviders() []string {
+	names := make([]string, 0, len(s.sso))
+	for name := range s.sso {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SSOFrontendURL is where the browser goes with its tokens after signing in
+// with the provider, or "" to return them as JSON.
+func (s *Service) SSOFrontendURL(name string) string {
+	if provider, ok := s.sso[name]; ok {
+		return provider.policy.FrontendURL
+	}
+	return ""
+}
+
+// BeginSSO starts signing in with a provider and returns the URL to send
+// the browser to, and the state the provider will send back. The caller
+// must tie the state to the browser, so that a callback started elsewhere
+// is refused.
+func (s *Service) BeginSSO(ctx context.Context, name string) (string, string, error) {
+	provider, ok := s.sso[name]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	value, err := json.Marshal(ssoState{Provider: name, Nonce: nonce, CodeVerifier: verifier})
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.redis.Set(ctx, ssoStateKey(state), value, SSOStateTTL); err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrSessionStoreUnavailable, err)
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	url, err := provider.provider.AuthURL(ctx, state, nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrIdentityProvider, err)
+	}
+	return url, state, nil
+}
+
+// CompleteSSO finishes signing in from the provider's callback: it checks
+// the state, has the provider identify the user, maps them to a local
+// user and starts a session as Login does. req carries the client's IP
+// and user agent for the login history.
+func (s *Service) CompleteSSO(ctx context.Context, name, state, code string, req *LoginRequest) (*LoginResponse, error) {
+	provider, ok := s.sso[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	reply, err := s.redis.Eval(ctx, takeStateScript, []string{ssoStateKey(state)})
+	if err != nil && !database.IsMiss(err) {
+		return nil, fmt.Errorf("%w: %v", ErrSessionStoreUnavailable, err)
+	}
+	stored, _ := reply.(string)
+	var pending ssoState
+	if stored == "" || json.Unmarshal([]byte(stored), &pending) != nil || pending.Provider != name {
+		return nil, ErrSSOStateInvalid
+	}
+
+	identity, err := provider.provider.Identify(ctx, code, pending.Nonce, pending.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIdentityProvider, err)
+	}
+
+	userID, err := s.ssoUser(ctx, name, provider.policy, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, &SSODeniedError{Reason: "this account has been deactivated"}
+	}
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		return nil, &SSODeniedError{Reason: fmt.Sprintf("account locked until %v", user.LockedUntil)}
+	}
+
+	// The provider's own checks stand in for the password, not for the
+	// second factor
+	req.Username = user.Username
+	risk, err := s.secondFactor(ctx, user, req)
+	if err != nil {
+		return nil, s.holdSSO(ctx, name, user.ID, err)
+	}
+
+	resp, sessionID, err := s.finishLogin(ctx, user, req, risk)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("User signed in with SSO",
+		"user_id", user.ID,
+		"provider", name,
+		"session_id", sessionID,
+	)
+
+	return resp, nil
+}
+
+// holdSSO keeps a sign-in that needs a second factor for VerifySSO, which
+// the browser reaches with the code after being sent back from the
+// provider.
+func (s *Service) holdSSO(ctx context.Context, name, userID string, reason error) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(ssoPending{UserID: userID, Provider: name})
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, ssoPendingKey(token), value, SSOStateTTL); err != nil {
+		return fmt.Errorf("%w: %v", ErrSessionStoreUnavailable, err)
+	}
+	return &SSOChallengeError{Token: token, Reason: reason.Error()}
+}
+
+// VerifySSO finishes a sign-in CompleteSSO held for a second factor, with
+// the MFA or confirmation code in req. A wrong code counts against the
+// account as it does for Login, and the sign-in can be tried again until
+// it expires.
+func (s *Service) VerifySSO(ctx context.Context, token string, req *LoginRequest) (*LoginResponse, error) {
+	stored, err := s.redis.Get(ctx, ssoPendingKey(token))
+	if err != nil && !database.IsMiss(err) {
+		return nil, fmt.Errorf("%w: %v", ErrSessionStoreUnavailable, err)
+	}
+	var pending ssoPending
+	if stored == "" || json.Unmarshal([]byte(stored), &pending) != nil {
+		return nil, ErrSSOStateInvalid
+	}
+
+	user, err := s.getUserByID(ctx, pending.UserID)
+	if err != nil {
+		return nil, err
+	}
+	req.Username = user.Username
+
+	if err := s.checkRateLimit(ctx, req.Username); err != nil {
+		return nil, err
+	}
+	if err := s.checkLoginThrottle(ctx, req); err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, &SSODeniedError{Reason: "this account has been deactivated"}
+	}
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		return nil, &SSODeniedError{Reason: fmt.Sprintf("account locked until %v", user.LockedUntil)}
+	}
+
+	risk, err := s.secondFactor(ctx, user, req)
+	if err != nil {
+		return nil, err
+	}
+	s.redis.Del(ctx, ssoPendingKey(token))
+
+	resp, sessionID, err := s.finishLogin(ctx, user, req, risk)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("User signed in with SSO",
+		"user_id", user.ID,
+		"provider", pending.Provider,
+		"session_id", sessionID,
+	)
+
+	return resp, nil
+}
+
+// ssoUser returns the local user an identity signs in as: the one already
+// linked to it, else one with the same verified email if the policy links
+// by email, else a new user if it provisions them.
+func (s *Service) ssoUser(ctx context.Context, name string, policy SSOPolicy, identity *ExternalIdentity) (string, error) {
+	if identity.Subject == "" {
+		return "", fmt.Errorf("%w: identity has no subject", ErrIdentityProvider)
+	}
+	if len(policy.AllowedDomains) > 0 && !(identity.EmailVerified && emailInDomains(identity.Email, policy.AllowedDomains)) {
+		return "", &SSODeniedError{Reason: "this account's email domain may not sign in here"}
+	}
+
+	var userID string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE user_identities SET last_login_at = NOW(), email = NULLIF($3, '')
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_id::text
+	`, name, identity.Subject, identity.Email).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if policy.LinkByEmail && identity.EmailVerified && identity.Email != "" {
+		err = tx.QueryRowContext(ctx, `
+			SELECT id::text FROM users
+			WHERE lower(email) = lower($1) AND tenant_id = $2 AND erased_at IS NULL
+		`, identity.Email, policy.TenantID).Scan(&userID)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+
+	if userID == "" {
+		if !policy.AutoProvision {
+			return "", &SSODeniedError{Reason: "no account is linked to this sign-in, ask an administrator for access"}
+		}
+		if userID, err = s.provisionSSOUser(ctx, tx, policy, identity); err != nil {
+			return "", err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, tenant_id, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NOW())
+	`, name, identity.Subject, userID, policy.TenantID, identity.Email)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	s.logger.Info("Linked sign-in identity", "user_id", userID, "provider", name, "tenant_id", policy.TenantID)
+	return userID, nil
+}
+
+// provisionSSOUser creates a user for a first sign-in. Like imported
+// users, their username is their email and their password is random, so
+// until they reset it they can only sign in through the provider.
+func (s *Service) provisionSSOUser(ctx context.Context, tx *sql.Tx, policy SSOPolicy, identity *ExternalIdentity) (string, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return "", &SSODeniedError{Reason: "the sign-in provider did not share a verified email address"}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(base64.RawURLEncoding.EncodeToString(secret)), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	var userID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (tenant_id, username, email, password_hash, first_name, last_name, role, email_verified)
+		VALUES ($1, $2, $2, $3, $4, $5, $6, true)
+		RETURNING id::text
+	`, policy.TenantID, identity.Email, string(hash), identity.FirstName, identity.LastName, policy.DefaultRole).Scan(&userID)
+	if constraintErr, ok := database.AsConstraintError(err); ok && constraintErr.Kind == database.UniqueViolation {
+		return "", &SSODeniedError{Reason: "an account with this email already exists, ask an administrator to link it"}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	s.logger.Info("User provisioned by SSO", "user_id", userID, "role", policy.DefaultRole, "tenant_id", policy.TenantID)
+	return userID, nil
+}
+
+func emailInDomains(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range domains {
+		if domain == strings.ToLower(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
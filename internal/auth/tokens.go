@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+const (
+	defaultTokenLifetimeDays = 90
+	maxTokenLifetimeDays     = 365
+	maxActiveTokensPerUser   = 50
+)
+
+var tokenScopes = map[string]bool{
+	middleware.ScopeRead:  true,
+	middleware.ScopeWrite: true,
+}
+
+// PersonalAccessToken describes a token without its secret.
+type PersonalAccessToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// TokenStore manages personal access tokens. Only a SHA-256 hash of each
+// token is stored; tokens are random, so a slow hash adds nothing. It also
+// answers whether a session JWT's session is still live, so services
+// without an auth.Service honour logouts.
+type TokenStore struct {
+	db       *database.PostgresDB
+	redis    *database.RedisClient
+	sessions *sessionChecker
+	logger   logger.Logger
+}
+
+// NewTokenStore returns a store whose session checks pass for up to
+// outageGrace after Redis last answered, as auth.session_outage_grace.
+func NewTokenStore(db *database.PostgresDB, redis *database.RedisClient, outageGrace time.Duration,
+	log logger.Logger) *TokenStore {
+	return &TokenStore{db: db, redis: redis, sessions: newSessionChecker(redis, outageGrace, log), logger: log}
+}
+
+// SessionValid reports whether a session has not been logged out or
+// expired, as auth.Service does for its own tokens.
+func (s *TokenStore) SessionValid(ctx context.Context, sessionID, userID string) bool {
+	return s.sessions.valid(ctx, sessionID, userID)
+}
+
+// Create issues a token for the user and returns it with its description.
+// The token itself cannot be retrieved again.
+func (s *TokenStore) Create(ctx context.Context, userID, tenantID string, req *CreateTokenRequest) (string, *PersonalAccessToken, error) {
+	if len(req.Scopes) == 0 {
+		return "", nil, fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !tokenScopes[scope] {
+			return "", nil, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = defaultTokenLifetimeDays
+	}
+	if days < 0 || days > maxTokenLifetimeDays {
+		return "", nil, fmt.Errorf("expires_in_days must be between 1 and %d", maxTokenLifetimeDays)
+	}
+
+	var active int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	`, userID).Scan(&active)
+	if err != nil {
+		return "", nil, err
+	}
+	if active >= maxActiveTokensPerUser {
+		return "", nil, fmt.Errorf("at most %d active tokens are allowed", maxActiveTokensPerUser)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	token := middleware.PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	created := &PersonalAccessToken{
+		Name:      req.Name,
+		Prefix:    token[:len(middleware.PersonalAccessTokenPrefix)+6],
+		Scopes:    req.Scopes,
+		ExpiresAt: time.Now().AddDate(0, 0, days),
+	}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO personal_access_tokens (user_id, tenant_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, userID, tenantID, created.Name, hashToken(token), created.Prefix, pq.Array(created.Scopes), created.ExpiresAt,
+	).Scan(&created.ID, &created.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, created, nil
+}
+
+// List returns the user's tokens, including revoked and expired ones.
+func (s *TokenStore) List(ctx context.Context, userID string) ([]*PersonalAccessToken, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, token_prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+		FROM personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		token := &PersonalAccessToken{}
+		err := rows.Scan(&token.ID, &token.Name, &token.Prefix, pq.Array(&token.Scopes),
+			&token.ExpiresAt, &token.LastUsedAt, &token.RevokedAt, &token.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke revokes one of the user's tokens. It returns sql.ErrNoRows if the
+// user has no such active token.
+func (s *TokenStore) Revoke(ctx context.Context, userID, tokenID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE personal_access_tokens SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Authenticate resolves a token to its user. The user's current role
+// applies, so demoting or deactivating a user also limits their tokens.
+func (s *TokenStore) Authenticate(ctx context.Context, token string) (*middleware.TokenIdentity, error) {
+	identity := &middleware.TokenIdentity{}
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE personal_access_tokens t SET last_used_at = NOW()
+		FROM users u
+		WHERE t.token_hash = $1
+			AND t.revoked_at IS NULL
+			AND t.expires_at > NOW()
+			AND u.id = t.user_id
+			AND u.is_active
+		RETURNING t.id, u.id, u.username, u.role, t.tenant_id, t.scopes,
+			ARRAY[COALESCE(u.region, '')] || u.allowed_regions
+	`, hashToken(token)).Scan(&identity.TokenID, &identity.UserID, &identity.Username, &identity.Role,
+		&identity.TenantID, pq.Array(&identity.Scopes), pq.Array(&identity.Regions))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invalid token")
+		}
+		return nil, err
+	}
+	return identity, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,81 @@
+// Package baseline learns what each device's metrics normally look like,
+// overall and by hour of day, so readings can be judged by how far they
+// stray from the device's own history instead of a fixed threshold.
+package baseline
+
+import (
+	"math"
+	"time"
+)
+
+// AnomalyType is the type of anomaly raised for a reading far outside its
+// baseline.
+const AnomalyType = "baseline_deviation"
+
+// Settings controls learning and scoring; see config.Devices.Baselines.
+type Settings struct {
+	LearnInterval time.Duration
+	Lookback      time.Duration
+
+	// A metric needs MinSamples readings in the lookback to be scored at
+	// all, and an hour needs MinHourSamples to use its own profile
+	MinSamples     int
+	MinHourSamples int
+
+	ZThreshold         float64
+	CriticalZThreshold float64
+}
+
+// Profile is the distribution of a metric's readings.
+type Profile struct {
+	Mean    float64 `json:"mean"`
+	StdDev  float64 `json:"stddev"`
+	Samples int64   `json:"samples"`
+}
+
+// Baseline is a device metric's overall profile and its seasonal profile,
+// one entry per UTC hour of day.
+type Baseline struct {
+	DeviceID  string      `json:"device_id"`
+	Metric    string      `json:"metric"`
+	Overall   Profile     `json:"overall"`
+	Hourly    [24]Profile `json:"hourly"`
+	LearnedAt time.Time   `json:"learned_at"`
+}
+
+// combine merges per-hour profiles into the overall one.
+func combine(hourly [24]Profile) Profile {
+	var n int64
+	var sum, sumSquares float64
+	for _, hour := range hourly {
+		n += hour.Samples
+		sum += float64(hour.Samples) * hour.Mean
+		sumSquares += float64(hour.Samples) * (hour.StdDev*hour.StdDev + hour.Mean*hour.Mean)
+	}
+	if n == 0 {
+		return Profile{}
+	}
+
+	mean := sum / float64(n)
+	variance := math.Max(sumSquares/float64(n)-mean*mean, 0)
+	return Profile{Mean: mean, StdDev: math.Sqrt(variance), Samples: n}
+}
+
+// ZScore returns how many standard deviations value lies from what is
+// usual at that time of day, falling back to the overall profile when the
+// hour has too few readings. It reports false when the metric has too
+// little history, or never varies, to be scored.
+func (b *Baseline) ZScore(value float64, at time.Time, settings Settings) (float64, bool) {
+	if b.Overall.Samples < int64(settings.MinSamples) {
+		return 0, false
+	}
+
+	profile := b.Overall
+	if hour := b.Hourly[at.UTC().Hour()]; hour.Samples >= int64(settings.MinHourSamples) && hour.StdDev > 0 {
+		profile = hour
+	}
+	if profile.StdDev == 0 {
+		return 0, false
+	}
+	return (value - profile.Mean) / profile.StdDev, true
+}
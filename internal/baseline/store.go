@@ -0,0 +1,204 @@
+package baseline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/devicelifecycle"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+const (
+	// A device's baselines are cached for this long; they change only when
+	// relearned, which happens every Settings.LearnInterval
+	cacheTTL = 10 * time.Minute
+
+	// A learning run not finished within this time is assumed to have died
+	// with its instance and may be claimed again
+	learnTimeout = 10 * time.Minute
+)
+
+// Store learns baselines from TimescaleDB history, keeps them in
+// PostgreSQL and serves them to the detector.
+type Store struct {
+	db       *database.PostgresDB
+	tsdb     *database.PostgresDB
+	settings Settings
+
+	// device ID -> cachedBaselines
+	cache sync.Map
+}
+
+type cachedBaselines struct {
+	metrics map[string]*Baseline
+	expires time.Time
+}
+
+func NewStore(db, tsdb *database.PostgresDB, settings Settings) *Store {
+	return &Store{db: db, tsdb: tsdb, settings: settings}
+}
+
+func (s *Store) Settings() Settings {
+	return s.settings
+}
+
+// ForDevice returns a device's baselines by metric.
+func (s *Store) ForDevice(ctx context.Context, deviceID string) (map[string]*Baseline, error) {
+	if cached, ok := s.cache.Load(deviceID); ok {
+		if entry := cached.(cachedBaselines); time.Now().Before(entry.expires) {
+			return entry.metrics, nil
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT metric, mean, stddev, samples, hourly, learned_at
+		FROM device_baselines
+		WHERE device_id = $1
+	`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := make(map[string]*Baseline)
+	for rows.Next() {
+		baseline := Baseline{DeviceID: deviceID}
+		var hourly []byte
+		if err := rows.Scan(&baseline.Metric, &baseline.Overall.Mean, &baseline.Overall.StdDev,
+			&baseline.Overall.Samples, &hourly, &baseline.LearnedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(hourly, &baseline.Hourly); err != nil {
+			return nil, err
+		}
+		metrics[baseline.Metric] = &baseline
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.cache.Store(deviceID, cachedBaselines{metrics: metrics, expires: time.Now().Add(cacheTTL)})
+	return metrics, nil
+}
+
+// LearnNext relearns the baselines of one active device that is due,
+// reporting false when none is. Devices are claimed so instances running
+// the job in parallel never learn the same one.
+func (s *Store) LearnNext(ctx context.Context) (bool, error) {
+	deviceID, err := s.claimDue(ctx)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	learnErr := s.learn(ctx, deviceID)
+
+	// A device that fails is retried after the interval like any other,
+	// rather than blocking the queue
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE device_baseline_runs SET learned_at = NOW(), running_since = NULL WHERE device_id = $1
+	`, deviceID)
+	if learnErr != nil {
+		return true, learnErr
+	}
+	return true, err
+}
+
+func (s *Store) claimDue(ctx context.Context) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var deviceID string
+	err = tx.QueryRowContext(ctx, `
+		SELECT d.id FROM devices d
+		LEFT JOIN device_baseline_runs r ON r.device_id = d.id
+		WHERE d.status = $1
+			AND (r.learned_at IS NULL OR r.learned_at < NOW() - $2 * INTERVAL '1 second')
+			AND (r.running_since IS NULL OR r.running_since < NOW() - $3 * INTERVAL '1 second')
+		ORDER BY r.learned_at NULLS FIRST
+		LIMIT 1
+		FOR UPDATE OF d SKIP LOCKED
+	`, devicelifecycle.Active, s.settings.LearnInterval.Seconds(), learnTimeout.Seconds()).Scan(&deviceID)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO device_baseline_runs (device_id, running_since) VALUES ($1, NOW())
+		ON CONFLICT (device_id) DO UPDATE SET running_since = NOW()
+	`, deviceID); err != nil {
+		return "", err
+	}
+
+	return deviceID, tx.Commit()
+}
+
+// learn computes each numeric metric's profile per UTC hour over the
+// lookback and replaces the device's baselines. Metrics the device no
+// longer reports lose theirs.
+func (s *Store) learn(ctx context.Context, deviceID string) error {
+	rows, err := s.tsdb.QueryContext(ctx, `
+		SELECT m.key, EXTRACT(HOUR FROM t.timestamp AT TIME ZONE 'UTC')::int,
+			COUNT(*), AVG(m.value::text::double precision),
+			COALESCE(STDDEV_POP(m.value::text::double precision), 0)
+		FROM device_telemetry t, jsonb_each(t.metrics) m
+		WHERE t.device_id = $1 AND t.timestamp > NOW() - $2 * INTERVAL '1 second'
+			AND jsonb_typeof(m.value) = 'number'
+		GROUP BY 1, 2
+	`, deviceID, s.settings.Lookback.Seconds())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	hourly := make(map[string]*[24]Profile)
+	for rows.Next() {
+		var metric string
+		var hour int
+		var profile Profile
+		if err := rows.Scan(&metric, &hour, &profile.Samples, &profile.Mean, &profile.StdDev); err != nil {
+			return err
+		}
+		if hourly[metric] == nil {
+			hourly[metric] = &[24]Profile{}
+		}
+		hourly[metric][hour] = profile
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM device_baselines WHERE device_id = $1`, deviceID); err != nil {
+		return err
+	}
+	for metric, hours := range hourly {
+		overall := combine(*hours)
+		hoursJSON, _ := json.Marshal(hours)
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO device_baselines (device_id, metric, mean, stddev, samples, hourly, learned_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		`, deviceID, metric, overall.Mean, overall.StdDev, overall.Samples, hoursJSON); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.cache.Delete(deviceID)
+	return nil
+}
@@ -0,0 +1,68 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+)
+
+const defaultDueDays = 15
+
+// issueBill inserts a newly generated bill. Every bill is created through
+// here so its due date always follows the tenant's tariff for the utility.
+// Callers give the metered consumption and its amount; consumption for
+// time the meter didn't report is estimated and charged here, at the
+// tariffs in force at the end of the period.
+func (s *Service) issueBill(ctx context.Context, tx *sql.Tx, bill *models.Bill) error {
+	tenantConfig, err := s.tariffsFor(ctx, bill)
+	if err != nil {
+		return err
+	}
+
+	if err := s.estimateUnmetered(ctx, bill, tenantConfig); err != nil {
+		return err
+	}
+
+	dueDays := defaultDueDays
+	if tariff, exists := tenantConfig.Tariffs[bill.UtilityType]; exists && tariff.DueDays > 0 {
+		dueDays = tariff.DueDays
+	}
+
+	bill.DueDate = time.Now().AddDate(0, 0, dueDays).Truncate(24 * time.Hour)
+	bill.Status = BillPending
+
+	return tx.QueryRowContext(ctx, `
+		INSERT INTO bills (tenant_id, user_id, device_id, utility_type, period_start, period_end,
+			consumption, amount, status, due_date, estimated_consumption, estimation_method)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''))
+		RETURNING id, created_at, updated_at
+	`,
+		bill.TenantID,
+		bill.UserID,
+		bill.DeviceID,
+		bill.UtilityType,
+		bill.PeriodStart,
+		bill.PeriodEnd,
+		bill.Consumption,
+		bill.Amount,
+		bill.Status,
+		bill.DueDate,
+		bill.EstimatedConsumption,
+		bill.EstimationMethod,
+	).Scan(&bill.ID, &bill.CreatedAt, &bill.UpdatedAt)
+}
+
+// tariffsFor resolves the tenant config in force at the end of the bill's
+// period, so a rate change scheduled for mid-cycle applies from the first
+// period ending after it. Periods before tariff history began use today's.
+func (s *Service) tariffsFor(ctx context.Context, bill *models.Bill) (*tenant.Config, error) {
+	periodEnd := bill.PeriodEnd.AddDate(0, 0, 1).Add(-time.Microsecond)
+	tenantConfig, err := s.tenants.ResolveAt(ctx, bill.TenantID, periodEnd)
+	if err == tenant.ErrBeforeHistory {
+		return s.tenants.Resolve(ctx, bill.TenantID)
+	}
+	return tenantConfig, err
+}
@@ -0,0 +1,353 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/timebucket"
+)
+
+const maxBudgetThreshold = 200
+
+var defaultBudgetThresholds = []int64{80, 100}
+
+// budgetMeter is the device type metering a utility and the metric its
+// consumption is materialized as.
+type budgetMeter struct {
+	deviceType string
+	metric     string
+}
+
+var budgetMeters = map[string]budgetMeter{
+	"water":       {deviceType: "water_sensor", metric: "volume"},
+	"electricity": {deviceType: "electricity_meter", metric: "energy"},
+}
+
+type budgetUsage struct {
+	models.ConsumptionBudget
+	PeriodStart time.Time `json:"period_start"`
+	Consumption float64   `json:"consumption"`
+	Percent     float64   `json:"percent"`
+}
+
+// ListBudgets returns the caller's budgets with this month's consumption
+// against each.
+func (s *Service) ListBudgets(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetString("user_id")
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, user_id, utility_type, monthly_limit, thresholds, created_at, updated_at
+		FROM consumption_budgets
+		WHERE tenant_id = $1 AND user_id::text = $2
+		ORDER BY utility_type
+	`, middleware.TenantID(c), userID)
+	if err != nil {
+		s.logger.Error("Failed to list budgets", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve budgets"})
+		return
+	}
+	defer rows.Close()
+
+	var budgets []models.ConsumptionBudget
+	for rows.Next() {
+		budget, err := scanBudget(rows)
+		if err != nil {
+			s.logger.Error("Failed to scan budget", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve budgets"})
+			return
+		}
+		budgets = append(budgets, *budget)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("Failed to list budgets", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve budgets"})
+		return
+	}
+
+	usage := make([]budgetUsage, 0, len(budgets))
+	for _, budget := range budgets {
+		periodStart := s.budgetPeriod(ctx, budget.TenantID, time.Now())
+		consumption, err := s.monthToDate(ctx, &budget, periodStart)
+		if err != nil {
+			s.logger.Error("Failed to compute consumption", "error", err, "budget_id", budget.ID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve budgets"})
+			return
+		}
+		usage = append(usage, budgetUsage{
+			ConsumptionBudget: budget,
+			PeriodStart:       periodStart,
+			Consumption:       round2(consumption),
+			Percent:           round2(consumption / budget.MonthlyLimit * 100),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"budgets": usage})
+}
+
+// SaveBudget creates or replaces the caller's budget for a utility. A
+// change mid-month applies to the whole month: thresholds already notified
+// and still crossed aren't repeated, and ones a raised limit uncrosses
+// notify again if consumption reaches them.
+func (s *Service) SaveBudget(c *gin.Context) {
+	utilityType := c.Param("utility")
+	if _, ok := budgetMeters[utilityType]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Budgets are available for water and electricity"})
+		return
+	}
+
+	var req struct {
+		MonthlyLimit float64 `json:"monthly_limit" binding:"required,gt=0"`
+		Thresholds   []int64 `json:"thresholds"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	thresholds, err := normalizeThresholds(req.Thresholds)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	budget, err := scanBudget(s.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO consumption_budgets (tenant_id, user_id, utility_type, monthly_limit, thresholds)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, utility_type)
+		DO UPDATE SET monthly_limit = $4, thresholds = $5, updated_at = NOW()
+		RETURNING id, tenant_id, user_id, utility_type, monthly_limit, thresholds, created_at, updated_at
+	`, middleware.TenantID(c), c.GetString("user_id"), utilityType, req.MonthlyLimit, pq.Array(thresholds)))
+	if err != nil {
+		s.logger.Error("Failed to save budget", "error", err, "user_id", c.GetString("user_id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save budget"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"budget":  budget,
+		"message": "Budget saved successfully",
+	})
+}
+
+// DeleteBudget removes the caller's budget for a utility.
+func (s *Service) DeleteBudget(c *gin.Context) {
+	result, err := s.db.ExecContext(c.Request.Context(), `
+		DELETE FROM consumption_budgets WHERE tenant_id = $1 AND user_id::text = $2 AND utility_type = $3
+	`, middleware.TenantID(c), c.GetString("user_id"), c.Param("utility"))
+	if err != nil {
+		s.logger.Error("Failed to delete budget", "error", err, "user_id", c.GetString("user_id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete budget"})
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Budget not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Budget deleted successfully"})
+}
+
+// checkBudgets notifies users whose month-to-date consumption has crossed
+// one of their budget thresholds. Each threshold is notified at most once
+// a month per limit: if a raised limit leaves a notified threshold no
+// longer crossed, its record is dropped so it can fire again.
+func (s *Service) checkBudgets(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, user_id, utility_type, monthly_limit, thresholds, created_at, updated_at
+		FROM consumption_budgets
+	`)
+	if err != nil {
+		s.logger.Error("Failed to query budgets", "error", err)
+		return
+	}
+
+	var budgets []models.ConsumptionBudget
+	for rows.Next() {
+		budget, err := scanBudget(rows)
+		if err != nil {
+			s.logger.Error("Failed to scan budget", "error", err)
+			continue
+		}
+		budgets = append(budgets, *budget)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("Failed to query budgets", "error", err)
+	}
+	rows.Close()
+
+	for i := range budgets {
+		periodStart := s.budgetPeriod(ctx, budgets[i].TenantID, time.Now())
+		if err := s.checkBudget(ctx, &budgets[i], periodStart); err != nil {
+			s.logger.Error("Failed to check budget", "error", err, "budget_id", budgets[i].ID)
+		}
+	}
+}
+
+func (s *Service) checkBudget(ctx context.Context, budget *models.ConsumptionBudget, periodStart time.Time) error {
+	consumption, err := s.monthToDate(ctx, budget, periodStart)
+	if err != nil {
+		return err
+	}
+	percent := consumption / budget.MonthlyLimit * 100
+	// period_start is a DATE; sent as a timestamp, local midnight would be
+	// cast back to the previous day in UTC
+	period := periodStart.Format("2006-01-02")
+
+	notifiedAt := make(map[int64]float64)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT threshold, monthly_limit FROM consumption_budget_alerts
+		WHERE budget_id = $1 AND period_start = $2
+	`, budget.ID, period)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var threshold int64
+		var limit float64
+		if err := rows.Scan(&threshold, &limit); err != nil {
+			rows.Close()
+			return err
+		}
+		notifiedAt[threshold] = limit
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Only the highest newly crossed threshold is worth a notification
+	var crossed int64
+	for _, threshold := range budget.Thresholds {
+		limit, notified := notifiedAt[threshold]
+		reached := percent >= float64(threshold)
+
+		switch {
+		case reached && !notified:
+			_, err := s.db.ExecContext(ctx, `
+				INSERT INTO consumption_budget_alerts (budget_id, period_start, threshold, monthly_limit, consumption)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT DO NOTHING
+			`, budget.ID, period, threshold, budget.MonthlyLimit, consumption)
+			if err != nil {
+				return err
+			}
+			crossed = threshold
+		case !reached && notified && limit != budget.MonthlyLimit:
+			_, err := s.db.ExecContext(ctx, `
+				DELETE FROM consumption_budget_alerts WHERE budget_id = $1 AND period_start = $2 AND threshold = $3
+			`, budget.ID, period, threshold)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if crossed == 0 {
+		return nil
+	}
+
+	unit := ""
+	if tariff, ok := s.tariff(ctx, budget.TenantID, budget.UtilityType); ok {
+		unit = " " + tariff.Unit
+	}
+
+	priority, title := "normal", fmt.Sprintf("You've used %d%% of your %s budget", crossed, budget.UtilityType)
+	if crossed >= 100 {
+		priority, title = "high", fmt.Sprintf("You've exceeded your %s budget", budget.UtilityType)
+	}
+	message := fmt.Sprintf("You've used %.2f%s of your %.2f%s %s budget for %s.",
+		consumption, unit, budget.MonthlyLimit, unit, budget.UtilityType, periodStart.Format("January"))
+
+	s.notifyUser(budget.UserID, "consumption_budget", priority, title, message, map[string]interface{}{
+		"budget_id":     budget.ID,
+		"utility_type":  budget.UtilityType,
+		"threshold":     crossed,
+		"consumption":   round2(consumption),
+		"monthly_limit": budget.MonthlyLimit,
+		"period_start":  period,
+	})
+	return nil
+}
+
+// monthToDate is the consumption since periodStart of the user's meters
+// for the budget's utility, read from the materialized consumption. Meters
+// are the devices assigned to the user.
+func (s *Service) monthToDate(ctx context.Context, budget *models.ConsumptionBudget, periodStart time.Time) (float64, error) {
+	meter := budgetMeters[budget.UtilityType]
+
+	var deviceIDs []string
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.id FROM device_assignments a
+		JOIN devices d ON d.id = a.device_id
+		WHERE a.user_id::text = $1 AND a.tenant_id = $2 AND d.type = $3
+	`, budget.UserID, budget.TenantID, meter.deviceType)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return 0, err
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(deviceIDs) == 0 {
+		return 0, nil
+	}
+
+	return s.consumptionBetween(ctx, deviceIDs, meter.metric, periodStart, time.Now())
+}
+
+// normalizeThresholds sorts and de-duplicates threshold percentages,
+// defaulting to 80% and 100%.
+func normalizeThresholds(thresholds []int64) ([]int64, error) {
+	if len(thresholds) == 0 {
+		return defaultBudgetThresholds, nil
+	}
+
+	seen := make(map[int64]bool, len(thresholds))
+	normalized := make([]int64, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		if threshold < 1 || threshold > maxBudgetThreshold {
+			return nil, fmt.Errorf("thresholds must be between 1 and %d percent", maxBudgetThreshold)
+		}
+		if !seen[threshold] {
+			seen[threshold] = true
+			normalized = append(normalized, threshold)
+		}
+	}
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i] < normalized[j] })
+	return normalized, nil
+}
+
+// budgetPeriod is local midnight on the first of the month containing t,
+// in the tenant's time zone, so budgets follow its billing calendar.
+func (s *Service) budgetPeriod(ctx context.Context, tenantID string, t time.Time) time.Time {
+	location := time.UTC
+	if tenantConfig, err := s.tenants.Resolve(ctx, tenantID); err == nil {
+		location = tenantConfig.Location()
+	} else {
+		s.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", tenantID)
+	}
+	return timebucket.MonthStart(t, location)
+}
+
+func scanBudget(row rowScanner) (*models.ConsumptionBudget, error) {
+	var budget models.ConsumptionBudget
+	err := row.Scan(&budget.ID, &budget.TenantID, &budget.UserID, &budget.UtilityType, &budget.MonthlyLimit,
+		pq.Array(&budget.Thresholds), &budget.CreatedAt, &budget.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
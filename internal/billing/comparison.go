@@ -0,0 +1,150 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+const defaultComparisonMinCohort = 10
+
+type periodConsumption struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Consumption float64   `json:"consumption"`
+}
+
+type neighborComparison struct {
+	// Scope is the area the cohort was drawn from: ward, or zone when the
+	// ward alone is too small
+	Scope      string  `json:"scope"`
+	Average    float64 `json:"average"`
+	Households int     `json:"households"`
+}
+
+// GetConsumptionComparison puts the caller's latest billed consumption in
+// context: against their own previous period, and against the average of
+// comparable meters nearby. Neighbor averages are only returned for cohorts
+// of at least billing.comparison_min_cohort households so no individual's
+// usage can be inferred.
+func (s *Service) GetConsumptionComparison(c *gin.Context) {
+	utilityType := c.DefaultQuery("utility", "electricity")
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+	userID := c.GetString("user_id")
+
+	var current periodConsumption
+	var deviceID sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT period_start, period_end, consumption, device_id FROM bills
+		WHERE tenant_id = $1 AND user_id = $2 AND utility_type = $3
+		ORDER BY period_end DESC
+		LIMIT 1
+	`, tenantID, userID, utilityType).Scan(&current.PeriodStart, &current.PeriodEnd, &current.Consumption, &deviceID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No consumption history for this utility"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load consumption", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load consumption comparison"})
+		return
+	}
+
+	response := gin.H{
+		"utility": utilityType,
+		"current": current,
+	}
+	if tariff, ok := s.tariff(ctx, tenantID, utilityType); ok {
+		response["unit"] = tariff.Unit
+	}
+
+	var previous periodConsumption
+	err = s.db.QueryRowContext(ctx, `
+		SELECT period_start, period_end, consumption FROM bills
+		WHERE tenant_id = $1 AND user_id = $2 AND utility_type = $3 AND period_end < $4
+		ORDER BY period_end DESC
+		LIMIT 1
+	`, tenantID, userID, utilityType, current.PeriodStart).Scan(&previous.PeriodStart, &previous.PeriodEnd, &previous.Consumption)
+	switch {
+	case err == nil:
+		response["previous"] = previous
+		if previous.Consumption > 0 {
+			response["change_percent"] = round2((current.Consumption - previous.Consumption) / previous.Consumption * 100)
+		}
+	case err != sql.ErrNoRows:
+		s.logger.Error("Failed to load previous consumption", "error", err, "user_id", userID)
+	}
+
+	if deviceID.Valid {
+		neighbors, err := s.neighborAverage(ctx, tenantID, userID, utilityType, deviceID.String, current)
+		if err != nil {
+			s.logger.Error("Failed to compute neighbor average", "error", err, "user_id", userID)
+		} else if neighbors != nil {
+			response["neighbors"] = neighbors
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// neighborAverage averages the same period's consumption of other
+// households whose meter is the same type and in the same ward, widening to
+// the zone if the ward cohort is too small. It returns nil when neither is
+// large enough.
+func (s *Service) neighborAverage(ctx context.Context, tenantID, userID, utilityType, deviceID string,
+	period periodConsumption) (*neighborComparison, error) {
+	minCohort := s.config.Billing.ComparisonMinCohort
+	if minCohort <= 0 {
+		minCohort = defaultComparisonMinCohort
+	}
+
+	var deviceType string
+	var ward, zone sql.NullString
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT type, ward, zone FROM devices WHERE id = $1
+	`, deviceID).Scan(&deviceType, &ward, &zone); err != nil {
+		return nil, err
+	}
+
+	for _, scope := range []struct {
+		column string
+		value  sql.NullString
+	}{{"ward", ward}, {"zone", zone}} {
+		if !scope.value.Valid || scope.value.String == "" {
+			continue
+		}
+
+		// column is one of a fixed set of identifiers, never user input
+		query := `
+			SELECT COUNT(DISTINCT b.user_id), COALESCE(AVG(b.consumption), 0)
+			FROM bills b
+			JOIN devices d ON d.id = b.device_id
+			WHERE b.tenant_id = $1 AND b.utility_type = $2 AND b.user_id <> $3
+				AND b.period_start = $4 AND b.period_end = $5
+				AND d.type = $6 AND d.` + scope.column + ` = $7
+		`
+
+		var households int
+		var average float64
+		if err := s.db.QueryRowContext(ctx, query, tenantID, utilityType, userID,
+			period.PeriodStart, period.PeriodEnd, deviceType, scope.value.String).Scan(&households, &average); err != nil {
+			return nil, err
+		}
+
+		if households >= minCohort {
+			return &neighborComparison{Scope: scope.column, Average: round2(average), Households: households}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
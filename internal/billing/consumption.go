@@ -0,0 +1,503 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/totalizer"
+)
+
+// consumptionSource is a metric whose consumption is materialized: either
+// a totalizer register, whose increases are the consumption, or a rate
+// integrated over time.
+type consumptionSource struct {
+	metric    string
+	reading   string
+	totalizer *devicetype.Totalizer
+	per       time.Duration
+}
+
+type meterReading struct {
+	at    time.Time
+	value float64
+}
+
+// periodUsage accumulates one bucket's row of device_consumption.
+type periodUsage struct {
+	consumption      float64
+	interpolated     float64
+	readings         int
+	resets           int
+	rollovers        int
+	unmeteredSeconds float64
+}
+
+// materializeConsumption keeps device_consumption up to date until ctx is
+// cancelled.
+func (s *Service) materializeConsumption(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Billing.Consumption.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.refreshConsumption(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("Failed to materialize consumption", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshConsumption materializes each metered device's readings since its
+// watermark, up to billing.consumption.settle ago. Replicas share the work:
+// a device another replica is materializing is skipped until the next run.
+func (s *Service) refreshConsumption(ctx context.Context) error {
+	sources, err := s.consumptionSources(ctx)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	deviceTypes := make([]string, 0, len(sources))
+	for deviceType := range sources {
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+
+	type meter struct {
+		id, tenantID, deviceType string
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, type FROM devices WHERE type = ANY($1) ORDER BY id
+	`, pq.Array(deviceTypes))
+	if err != nil {
+		return err
+	}
+	var meters []meter
+	for rows.Next() {
+		var m meter
+		if err := rows.Scan(&m.id, &m.tenantID, &m.deviceType); err != nil {
+			rows.Close()
+			return err
+		}
+		meters = append(meters, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	until := time.Now().Add(-s.config.Billing.Consumption.Settle)
+	for _, m := range meters {
+		for _, source := range sources[m.deviceType] {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := s.materializeMeter(ctx, m.id, m.tenantID, source, until); err != nil {
+				s.logger.Error("Failed to materialize device consumption", "error", err,
+					"device_id", m.id, "metric", source.metric)
+			}
+		}
+	}
+	return nil
+}
+
+// consumptionSources maps device types to the metrics materialized for
+// them: every totalizer, and the configured flows.
+func (s *Service) consumptionSources(ctx context.Context) (map[string][]consumptionSource, error) {
+	types, err := s.types.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string][]consumptionSource)
+	for _, deviceType := range types {
+		for metric, spec := range deviceType.Totalizers {
+			spec := spec
+			sources[deviceType.Name] = append(sources[deviceType.Name], consumptionSource{
+				metric:    metric,
+				reading:   metric,
+				totalizer: &spec,
+			})
+		}
+	}
+	for _, flow := range s.config.Billing.Consumption.Flows {
+		sources[flow.DeviceType] = append(sources[flow.DeviceType], consumptionSource{
+			metric:  flow.Metric,
+			reading: flow.RateMetric,
+			per:     flow.Per,
+		})
+	}
+	return sources, nil
+}
+
+// materializeMeter adds one device metric's readings since its watermark
+// to device_consumption and moves the watermark on, in one transaction so
+// a failed run is simply retried.
+func (s *Service) materializeMeter(ctx context.Context, deviceID, tenantID string, source consumptionSource,
+	until time.Time) error {
+	tx, err := s.tsdb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO consumption_watermarks (device_id, metric, tenant_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (device_id, metric) DO NOTHING
+	`, deviceID, source.metric, tenantID)
+	if err != nil {
+		return err
+	}
+
+	var materializedUntil, lastAt sql.NullTime
+	var lastValue sql.NullFloat64
+	err = tx.QueryRowContext(ctx, `
+		SELECT materialized_until, last_value, last_at FROM consumption_watermarks
+		WHERE device_id = $1 AND metric = $2
+		FOR UPDATE SKIP LOCKED
+	`, deviceID, source.metric).Scan(&materializedUntil, &lastValue, &lastAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var previous *meterReading
+	if lastAt.Valid && lastValue.Valid {
+		previous = &meterReading{at: lastAt.Time, value: lastValue.Float64}
+	} else if materializedUntil.Valid {
+		// Rebuilt from materializedUntil: continue from the last reading
+		// before it
+		if previous, err = readingBefore(ctx, tx, deviceID, source.reading, materializedUntil.Time); err != nil {
+			return err
+		}
+	}
+
+	readings, err := readingsBetween(ctx, tx, deviceID, source.reading, materializedUntil, until,
+		s.config.Billing.Consumption.BatchSize)
+	if err != nil {
+		return err
+	}
+	if len(readings) == 0 {
+		return nil
+	}
+
+	usage, last := s.accumulate(source, previous, readings, materializedUntil.Time)
+	for periodStart, period := range usage {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO device_consumption (device_id, tenant_id, metric, period_start, consumption, interpolated,
+				readings, resets, rollovers, unmetered_seconds)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (device_id, metric, period_start) DO UPDATE SET
+				consumption = device_consumption.consumption + EXCLUDED.consumption,
+				interpolated = device_consumption.interpolated + EXCLUDED.interpolated,
+				readings = device_consumption.readings + EXCLUDED.readings,
+				resets = device_consumption.resets + EXCLUDED.resets,
+				rollovers = device_consumption.rollovers + EXCLUDED.rollovers,
+				unmetered_seconds = device_consumption.unmetered_seconds + EXCLUDED.unmetered_seconds
+		`, deviceID, tenantID, source.metric, periodStart, period.consumption, period.interpolated,
+			period.readings, period.resets, period.rollovers, period.unmeteredSeconds)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The watermark is the last reading read, so readings that arrive late
+	// but after it are still counted next run
+	_, err = tx.ExecContext(ctx, `
+		UPDATE consumption_watermarks
+		SET materialized_until = $3, last_value = $4, last_at = $5, updated_at = NOW()
+		WHERE device_id = $1 AND metric = $2
+	`, deviceID, source.metric, readings[len(readings)-1].at, last.value, last.at)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// readingsBetween returns a device's numeric readings of metric after
+// from (from the first, if from is NULL) up to and including until,
+// oldest first.
+func readingsBetween(ctx context.Context, q querier, deviceID, metric string, from sql.NullTime, until time.Time,
+	limit int) ([]meterReading, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT timestamp, (metrics->>$2)::double precision
+		FROM device_telemetry
+		WHERE device_id = $1 AND jsonb_typeof(metrics->$2) = 'number'
+			AND ($3::timestamptz IS NULL OR timestamp > $3) AND timestamp <= $4
+		ORDER BY timestamp
+		LIMIT $5
+	`, deviceID, metric, from, until, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var readings []meterReading
+	for rows.Next() {
+		var reading meterReading
+		if err := rows.Scan(&reading.at, &reading.value); err != nil {
+			return nil, err
+		}
+		readings = append(readings, reading)
+	}
+	return readings, rows.Err()
+}
+
+// readingBefore returns the device's last numeric reading of metric at or
+// before at, or nil if raw telemetry no longer has one.
+func readingBefore(ctx context.Context, q querier, deviceID, metric string, at time.Time) (*meterReading, error) {
+	var reading meterReading
+	err := q.QueryRowContext(ctx, `
+		SELECT timestamp, (metrics->>$2)::double precision
+		FROM device_telemetry
+		WHERE device_id = $1 AND jsonb_typeof(metrics->$2) = 'number' AND timestamp <= $3
+		ORDER BY timestamp DESC
+		LIMIT 1
+	`, deviceID, metric, at).Scan(&reading.at, &reading.value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reading, nil
+}
+
+// accumulate works out the consumption between consecutive readings and
+// spreads it over the periods between them by time, leaving out any share
+// before materialized, which is already counted. It returns the usage per
+// period and the reading the next run continues from.
+//
+// For a register, a drop within its tolerance is noise and the higher
+// reading stays the baseline. A rollover counts up to RolloverAt and on
+// from zero; a reset, or a drop that looks like tampering, counts nothing
+// and starts again from the new reading. Increases across a gap longer
+// than billing.consumption.max_gap are real but their timing is not known,
+// so they are also recorded as interpolated.
+//
+// A rate is integrated between readings with the trapezoid rule. Across a
+// gap longer than max_gap nothing is integrated and the gap is recorded as
+// unmetered instead.
+func (s *Service) accumulate(source consumptionSource, previous *meterReading, readings []meterReading,
+	materialized time.Time) (map[time.Time]*periodUsage, *meterReading) {
+	consumption := s.config.Billing.Consumption
+	usage := make(map[time.Time]*periodUsage)
+	bucket := func(at time.Time) *periodUsage {
+		start := at.Truncate(consumption.Period)
+		if usage[start] == nil {
+			usage[start] = &periodUsage{}
+		}
+		return usage[start]
+	}
+
+	for _, reading := range readings {
+		current := bucket(reading.at)
+		current.readings++
+		if previous == nil || !reading.at.After(previous.at) {
+			if previous == nil {
+				previous = &meterReading{at: reading.at, value: reading.value}
+			}
+			continue
+		}
+
+		gap := reading.at.Sub(previous.at)
+		long := gap > consumption.MaxGap
+
+		if source.totalizer == nil {
+			if long {
+				s.spread(usage, consumption.Period, previous.at, reading.at, materialized, func(period *periodUsage, share float64) {
+					period.unmeteredSeconds += share * gap.Seconds()
+				})
+			} else {
+				used := math.Max((previous.value+reading.value)/2, 0) * gap.Seconds() / source.per.Seconds()
+				s.spread(usage, consumption.Period, previous.at, reading.at, materialized, func(period *periodUsage, share float64) {
+					period.consumption += share * used
+				})
+			}
+			*previous = reading
+			continue
+		}
+
+		var used float64
+		baseline := reading.value
+		switch totalizer.Classify(*source.totalizer, previous.value, reading.value) {
+		case totalizer.OK:
+			used = math.Max(reading.value-previous.value, 0)
+			baseline = math.Max(reading.value, previous.value)
+		case totalizer.Rollover:
+			used = source.totalizer.RolloverAt - previous.value + reading.value
+			current.rollovers++
+		default:
+			current.resets++
+		}
+
+		if used > 0 {
+			s.spread(usage, consumption.Period, previous.at, reading.at, materialized, func(period *periodUsage, share float64) {
+				period.consumption += share * used
+				if long {
+					period.interpolated += share * used
+				}
+			})
+		}
+		previous.at, previous.value = reading.at, baseline
+	}
+
+	return usage, previous
+}
+
+// spread hands each period overlapping (from, to] its share of the
+// interval by time. Nothing before materialized is handed out.
+func (s *Service) spread(usage map[time.Time]*periodUsage, length time.Duration, from, to, materialized time.Time,
+	add func(period *periodUsage, share float64)) {
+	total := to.Sub(from).Seconds()
+	if materialized.After(from) {
+		from = materialized
+	}
+	for start := from.Truncate(length); start.Before(to); start = start.Add(length) {
+		overlapStart, overlapEnd := start, start.Add(length)
+		if from.After(overlapStart) {
+			overlapStart = from
+		}
+		if to.Before(overlapEnd) {
+			overlapEnd = to
+		}
+		overlap := overlapEnd.Sub(overlapStart).Seconds()
+		if overlap <= 0 {
+			continue
+		}
+		if usage[start] == nil {
+			usage[start] = &periodUsage{}
+		}
+		add(usage[start], overlap/total)
+	}
+}
+
+// consumptionBetween sums the materialized consumption of the devices'
+// metric over [start, end). start and end should fall on
+// billing.consumption.period boundaries; local midnight always does.
+func (s *Service) consumptionBetween(ctx context.Context, deviceIDs []string, metric string,
+	start, end time.Time) (float64, error) {
+	var consumption float64
+	err := s.tsdb.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(consumption), 0) FROM device_consumption
+		WHERE device_id = ANY($1) AND metric = $2 AND period_start >= $3 AND period_start < $4
+	`, pq.Array(deviceIDs), metric, start, end).Scan(&consumption)
+	return consumption, err
+}
+
+// RebuildConsumption discards a device's materialized consumption from a
+// point on and has the next refresh recompute it from raw telemetry, for
+// after a backfill or a fix to its type's totalizers. Raw telemetry is only
+// kept for a while, so the rebuild may not start before the device's
+// oldest reading.
+func (s *Service) RebuildConsumption(c *gin.Context) {
+	var req struct {
+		DeviceID string    `json:"device_id" binding:"required"`
+		Since    time.Time `json:"since" binding:"required"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM devices WHERE id = $1 AND tenant_id = $2)
+	`, req.DeviceID, middleware.TenantID(c)).Scan(&exists)
+	if err != nil {
+		s.logger.Error("Failed to load device", "error", err, "device_id", req.DeviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild consumption"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	since := req.Since.Truncate(s.config.Billing.Consumption.Period)
+
+	var oldest sql.NullTime
+	err = s.tsdb.QueryRowContext(ctx, `
+		SELECT MIN(timestamp) FROM device_telemetry WHERE device_id = $1
+	`, req.DeviceID).Scan(&oldest)
+	if err != nil {
+		s.logger.Error("Failed to load oldest reading", "error", err, "device_id", req.DeviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild consumption"})
+		return
+	}
+	if oldest.Valid && since.Before(oldest.Time) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          "Raw telemetry from before the device's oldest reading is no longer kept",
+			"oldest_reading": oldest.Time,
+		})
+		return
+	}
+
+	tx, err := s.tsdb.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild consumption"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Waits for a refresh in progress, which would otherwise write behind
+	// the rebuild
+	result, err := tx.ExecContext(ctx, `
+		UPDATE consumption_watermarks
+		SET materialized_until = $2, last_value = NULL, last_at = NULL, updated_at = NOW()
+		WHERE device_id = $1 AND materialized_until > $2
+	`, req.DeviceID, since)
+	if err != nil {
+		s.logger.Error("Failed to reset consumption watermarks", "error", err, "device_id", req.DeviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild consumption"})
+		return
+	}
+	metrics, _ := result.RowsAffected()
+
+	result, err = tx.ExecContext(ctx, `
+		DELETE FROM device_consumption WHERE device_id = $1 AND period_start >= $2
+	`, req.DeviceID, since)
+	if err != nil {
+		s.logger.Error("Failed to discard materialized consumption", "error", err, "device_id", req.DeviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild consumption"})
+		return
+	}
+	discarded, _ := result.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit consumption rebuild", "error", err, "device_id", req.DeviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild consumption"})
+		return
+	}
+
+	s.logger.Info("Consumption rebuild requested", "device_id", req.DeviceID, "since", since,
+		"metrics", metrics, "periods_discarded", discarded, "user_id", c.GetString("user_id"))
+	c.JSON(http.StatusAccepted, gin.H{
+		"device_id":         req.DeviceID,
+		"since":             since,
+		"metrics":           metrics,
+		"periods_discarded": discarded,
+	})
+}
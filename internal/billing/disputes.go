@@ -0,0 +1,430 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/pagination"
+)
+
+const (
+	DisputeOpen        = "open"
+	DisputeUnderReview = "under_review"
+	DisputeResolved    = "resolved"
+	DisputeRejected    = "rejected"
+)
+
+// disputeTransitions lists the statuses each dispute status may move to.
+// Resolved and rejected are final.
+var disputeTransitions = map[string][]string{
+	DisputeOpen:        {DisputeUnderReview},
+	DisputeUnderReview: {DisputeResolved, DisputeRejected},
+}
+
+var (
+	errInvalidTransition  = errors.New("invalid dispute status transition")
+	errAdjustmentTooLarge = errors.New("adjustment would reduce the bill below the amount already paid")
+)
+
+type disputeRequest struct {
+	Reason string `json:"reason" binding:"required,min=10,max=2000"`
+}
+
+type disputeUpdateRequest struct {
+	Status     string   `json:"status" binding:"required"`
+	Resolution string   `json:"resolution"`
+	Adjustment *float64 `json:"adjustment"`
+}
+
+type adjustmentRequest struct {
+	Amount float64 `json:"amount" binding:"required"`
+	Reason string  `json:"reason" binding:"required"`
+}
+
+// CreateDispute opens a dispute against one of the caller's bills. Anomalies
+// detected on the bill's meter during the billing period are linked so the
+// reviewer sees them alongside the citizen's reason.
+func (s *Service) CreateDispute(c *gin.Context) {
+	var req disputeRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+	userID := c.GetString("user_id")
+	billID := c.Param("id")
+
+	var deviceID sql.NullString
+	var periodStart, periodEnd time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT device_id, period_start, period_end FROM bills
+		WHERE id = $1 AND tenant_id = $2 AND user_id = $3
+	`, billID, tenantID, userID).Scan(&deviceID, &periodStart, &periodEnd)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bill not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load bill", "error", err, "bill_id", billID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create dispute"})
+		return
+	}
+
+	dispute := &models.BillDispute{
+		TenantID: tenantID,
+		BillID:   billID,
+		UserID:   userID,
+		Reason:   req.Reason,
+		Status:   DisputeOpen,
+	}
+	if deviceID.Valid {
+		dispute.AnomalyIDs = s.periodAnomalies(ctx, deviceID.String, periodStart, periodEnd)
+	}
+
+	anomalyJSON, _ := json.Marshal(dispute.AnomalyIDs)
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO bill_disputes (tenant_id, bill_id, user_id, reason, status, anomaly_ids)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+	`, tenantID, billID, userID, req.Reason, DisputeOpen, anomalyJSON).Scan(&dispute.ID, &dispute.CreatedAt, &dispute.UpdatedAt)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A dispute for this bill is already in progress"})
+			return
+		}
+		s.logger.Error("Failed to create dispute", "error", err, "bill_id", billID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create dispute"})
+		return
+	}
+
+	s.notifyUser(userID, "bill_dispute", "normal",
+		"Bill dispute received",
+		"We have received your dispute and will review it shortly.",
+		map[string]interface{}{"dispute_id": dispute.ID, "bill_id": billID, "status": DisputeOpen},
+	)
+
+	c.JSON(http.StatusCreated, dispute)
+}
+
+// ListDisputes returns the tenant's disputes, optionally filtered by status.
+func (s *Service) ListDisputes(c *gin.Context) {
+	page, limit, err := pagination.Parse(c, s.config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+	status := c.Query("status")
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM bill_disputes
+		WHERE tenant_id = $1 AND ($2 = '' OR status = $2)
+	`, tenantID, status).Scan(&total); err != nil {
+		s.logger.Error("Failed to count disputes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list disputes"})
+		return
+	}
+
+	pages := pagination.New(page, limit, total)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, bill_id, user_id, reason, status, COALESCE(resolution, ''), anomaly_ids,
+			COALESCE(reviewed_by::text, ''), resolved_at, created_at, updated_at
+		FROM bill_disputes
+		WHERE tenant_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3 OFFSET $4
+	`, tenantID, status, limit, pages.Offset())
+	if err != nil {
+		s.logger.Error("Failed to list disputes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list disputes"})
+		return
+	}
+	defer rows.Close()
+
+	disputes := []models.BillDispute{}
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			s.logger.Error("Failed to scan dispute", "error", err)
+			continue
+		}
+		disputes = append(disputes, *dispute)
+	}
+
+	pages.Write(c)
+	c.JSON(http.StatusOK, gin.H{
+		"disputes":   disputes,
+		"pagination": pages,
+	})
+}
+
+// UpdateDispute moves a dispute through its workflow. Resolving a dispute
+// may carry an adjustment, applied to the bill in the same transaction.
+func (s *Service) UpdateDispute(c *gin.Context) {
+	var req disputeUpdateRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	final := req.Status == DisputeResolved || req.Status == DisputeRejected
+	if final && req.Resolution == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resolution is required when closing a dispute"})
+		return
+	}
+	if req.Adjustment != nil && req.Status != DisputeResolved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "adjustment is only allowed when resolving a dispute"})
+		return
+	}
+
+	dispute, err := s.transitionDispute(c.Request.Context(), middleware.TenantID(c), c.Param("id"), &req, c.GetString("user_id"))
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found"})
+		case errors.Is(err, errInvalidTransition), errors.Is(err, errAdjustmentTooLarge):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			s.logger.Error("Failed to update dispute", "error", err, "dispute_id", c.Param("id"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update dispute"})
+		}
+		return
+	}
+
+	s.notifyUser(dispute.UserID, "bill_dispute", "normal",
+		"Bill dispute updated",
+		disputeMessage(dispute),
+		map[string]interface{}{"dispute_id": dispute.ID, "bill_id": dispute.BillID, "status": dispute.Status},
+	)
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// AdjustBill applies a manual adjustment or credit to a bill outside of a
+// dispute, e.g. a goodwill credit after an outage.
+func (s *Service) AdjustBill(c *gin.Context) {
+	var req adjustmentRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	billID := c.Param("id")
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust bill"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, amount, err := s.adjustBill(ctx, tx, middleware.TenantID(c), billID, "", req.Amount, req.Reason, c.GetString("user_id"))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bill not found"})
+		case errors.Is(err, errAdjustmentTooLarge):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			s.logger.Error("Failed to adjust bill", "error", err, "bill_id", billID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust bill"})
+		}
+		return
+	}
+
+	s.notifyUser(userID, "bill_adjusted", "normal",
+		"Your bill has been adjusted",
+		fmt.Sprintf("Your bill has been adjusted by %.2f. The new amount due is %.2f.", req.Amount, amount),
+		map[string]interface{}{"bill_id": billID, "adjustment": req.Amount, "amount": amount},
+	)
+
+	c.JSON(http.StatusOK, gin.H{
+		"bill_id": billID,
+		"amount":  amount,
+		"message": "Bill adjusted successfully",
+	})
+}
+
+func (s *Service) transitionDispute(ctx context.Context, tenantID, disputeID string,
+	req *disputeUpdateRequest, actorID string) (*models.BillDispute, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+		SELECT id, tenant_id, bill_id, user_id, reason, status, COALESCE(resolution, ''), anomaly_ids,
+			COALESCE(reviewed_by::text, ''), resolved_at, created_at, updated_at
+		FROM bill_disputes
+		WHERE id = $1 AND tenant_id = $2
+		FOR UPDATE
+	`, disputeID, tenantID)
+
+	dispute, err := scanDispute(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canTransition(dispute.Status, req.Status) {
+		return nil, fmt.Errorf("%w: %s to %s", errInvalidTransition, dispute.Status, req.Status)
+	}
+
+	if req.Adjustment != nil && *req.Adjustment != 0 {
+		if _, _, err := s.adjustBill(ctx, tx, tenantID, dispute.BillID, dispute.ID,
+			*req.Adjustment, "Dispute resolution: "+req.Resolution, actorID); err != nil {
+			return nil, err
+		}
+	}
+
+	dispute.Status = req.Status
+	dispute.ReviewedBy = actorID
+	if req.Resolution != "" {
+		dispute.Resolution = req.Resolution
+	}
+	if req.Status == DisputeResolved || req.Status == DisputeRejected {
+		now := time.Now()
+		dispute.ResolvedAt = &now
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE bill_disputes
+		SET status = $1, resolution = NULLIF($2, ''), reviewed_by = $3, resolved_at = $4
+		WHERE id = $5
+	`, dispute.Status, dispute.Resolution, actorID, dispute.ResolvedAt, dispute.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return dispute, nil
+}
+
+// adjustBill changes a bill's amount and records why. It returns the bill's
+// owner and new amount.
+func (s *Service) adjustBill(ctx context.Context, tx *sql.Tx, tenantID, billID, disputeID string,
+	adjustment float64, reason, actorID string) (string, float64, error) {
+	var userID string
+	var amount, amountPaid float64
+	err := tx.QueryRowContext(ctx, `
+		SELECT user_id, amount, amount_paid FROM bills
+		WHERE id = $1 AND tenant_id = $2
+		FOR UPDATE
+	`, billID, tenantID).Scan(&userID, &amount, &amountPaid)
+	if err != nil {
+		return "", 0, err
+	}
+
+	newAmount := toCents(amount) + toCents(adjustment)
+	if newAmount < toCents(amountPaid) {
+		return "", 0, errAdjustmentTooLarge
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE bills SET amount = $1, status = $2 WHERE id = $3`,
+		fromCents(newAmount), billStatus(newAmount, toCents(amountPaid)), billID); err != nil {
+		return "", 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO bill_adjustments (bill_id, dispute_id, amount, reason, created_by)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5)
+	`, billID, disputeID, adjustment, reason, actorID); err != nil {
+		return "", 0, err
+	}
+
+	return userID, fromCents(newAmount), nil
+}
+
+// periodAnomalies returns the anomalies detected on a meter during a billing
+// period. Failures are logged rather than blocking the dispute.
+func (s *Service) periodAnomalies(ctx context.Context, deviceID string, start, end time.Time) []string {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id::text FROM anomalies
+		WHERE device_id = $1 AND timestamp >= $2 AND timestamp < $3::date + 1
+		ORDER BY timestamp
+	`, deviceID, start, end)
+	if err != nil {
+		s.logger.Warn("Failed to load anomalies for dispute", "error", err, "device_id", deviceID)
+		return nil
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDispute(row rowScanner) (*models.BillDispute, error) {
+	var dispute models.BillDispute
+	var anomalyJSON []byte
+
+	err := row.Scan(
+		&dispute.ID,
+		&dispute.TenantID,
+		&dispute.BillID,
+		&dispute.UserID,
+		&dispute.Reason,
+		&dispute.Status,
+		&dispute.Resolution,
+		&anomalyJSON,
+		&dispute.ReviewedBy,
+		&dispute.ResolvedAt,
+		&dispute.CreatedAt,
+		&dispute.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	json.Unmarshal(anomalyJSON, &dispute.AnomalyIDs)
+	return &dispute, nil
+}
+
+func canTransition(from, to string) bool {
+	for _, next := range disputeTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func disputeMessage(dispute *models.BillDispute) string {
+	switch dispute.Status {
+	case DisputeUnderReview:
+		return "Your bill dispute is now under review."
+	case DisputeResolved:
+		return "Your bill dispute has been resolved: " + dispute.Resolution
+	case DisputeRejected:
+		return "Your bill dispute was not upheld: " + dispute.Resolution
+	}
+	return "Your bill dispute has been updated."
+}
@@ -0,0 +1,217 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+)
+
+const (
+	EstimateHistory   = "history"
+	EstimateNeighbors = "neighbors"
+)
+
+// estimateUnmetered adds estimated consumption, and its charge, for the
+// part of the bill's period its meter didn't report: before its first
+// reading and after its last. Usage between readings is on the meter's
+// register, so gaps inside the period need no estimate. The methods in
+// billing.estimation.methods are tried in order until one has enough
+// data; if none has, the bill is left as metered and a warning logged.
+func (s *Service) estimateUnmetered(ctx context.Context, bill *models.Bill, tenantConfig *tenant.Config) error {
+	methods := s.config.Billing.Estimation.Methods
+	meter, metered := budgetMeters[bill.UtilityType]
+	if len(methods) == 0 || !metered || bill.DeviceID == "" {
+		return nil
+	}
+
+	location := tenantConfig.Location()
+	start := time.Date(bill.PeriodStart.Year(), bill.PeriodStart.Month(), bill.PeriodStart.Day(), 0, 0, 0, 0, location)
+	end := time.Date(bill.PeriodEnd.Year(), bill.PeriodEnd.Month(), bill.PeriodEnd.Day()+1, 0, 0, 0, 0, location)
+
+	unmetered, err := s.unmeteredTime(ctx, bill.DeviceID, meter.metric, start, end)
+	if err != nil {
+		return err
+	}
+	if unmetered < s.config.Billing.Estimation.MinGap {
+		return nil
+	}
+
+	for _, method := range methods {
+		var rate float64
+		var ok bool
+		switch method {
+		case EstimateHistory:
+			rate, ok, err = s.historicalRate(ctx, bill.DeviceID, meter.metric, end)
+		case EstimateNeighbors:
+			rate, ok, err = s.neighborRate(ctx, bill.TenantID, bill.DeviceID, meter, start, end)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		estimated := math.Round(rate*unmetered.Seconds()*1000) / 1000
+		bill.EstimatedConsumption = estimated
+		bill.EstimationMethod = method
+		bill.Consumption += estimated
+		if tariff, exists := tenantConfig.Tariffs[bill.UtilityType]; exists {
+			bill.Amount += round2(estimated * tariff.RatePerUnit * (1 + tariff.TaxRate))
+		}
+
+		s.logger.Info("Estimated unmetered consumption", "device_id", bill.DeviceID, "user_id", bill.UserID,
+			"period_start", bill.PeriodStart, "unmetered", unmetered, "method", method, "estimated", estimated)
+		return nil
+	}
+
+	s.logger.Warn("No estimate for unmetered consumption", "device_id", bill.DeviceID, "user_id", bill.UserID,
+		"period_start", bill.PeriodStart, "unmetered", unmetered, "methods", methods)
+	return nil
+}
+
+// unmeteredTime is how much of [start, end) lies before the meter's first
+// reading in it or after its last; all of it if there are none.
+func (s *Service) unmeteredTime(ctx context.Context, deviceID, metric string, start, end time.Time) (time.Duration, error) {
+	var first, last sql.NullTime
+	err := s.tsdb.QueryRowContext(ctx, `
+		SELECT MIN(bucket), MAX(last_at)
+		FROM device_telemetry_aggregates
+		WHERE device_id = $1 AND metric = $2 AND bucket >= $3 AND bucket < $4
+	`, deviceID, metric, start, end).Scan(&first, &last)
+	if err != nil {
+		return 0, err
+	}
+	if !first.Valid {
+		return end.Sub(start), nil
+	}
+
+	var unmetered time.Duration
+	if first.Time.After(start) {
+		unmetered += first.Time.Sub(start)
+	}
+	if last.Time.Before(end) {
+		unmetered += end.Sub(last.Time)
+	}
+	return unmetered, nil
+}
+
+// historicalRate is the meter's own average consumption per second over
+// billing.estimation.lookback before end. It is not ok unless the meter
+// reported over at least billing.estimation.min_gap of that time.
+func (s *Service) historicalRate(ctx context.Context, deviceID, metric string, end time.Time) (float64, bool, error) {
+	var used, seconds float64
+	err := s.tsdb.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(max_value) - MIN(min_value), 0), COALESCE(EXTRACT(EPOCH FROM MAX(last_at) - MIN(bucket)), 0)
+		FROM device_telemetry_aggregates
+		WHERE device_id = $1 AND metric = $2 AND bucket >= $3 AND bucket < $4
+	`, deviceID, metric, end.Add(-s.config.Billing.Estimation.Lookback), end).Scan(&used, &seconds)
+	if err != nil {
+		return 0, false, err
+	}
+	if seconds < s.config.Billing.Estimation.MinGap.Seconds() || used < 0 {
+		return 0, false, nil
+	}
+	return used / seconds, true, nil
+}
+
+// neighborRate averages the consumption per second over [start, end) of
+// other meters of the same type in the same ward, widening to the zone
+// like consumption comparisons do. Only meters that reported over at least
+// billing.estimation.min_gap count, and it is not ok unless
+// billing.comparison_min_cohort of them do.
+func (s *Service) neighborRate(ctx context.Context, tenantID, deviceID string, meter budgetMeter,
+	start, end time.Time) (float64, bool, error) {
+	minCohort := s.config.Billing.ComparisonMinCohort
+	if minCohort <= 0 {
+		minCohort = defaultComparisonMinCohort
+	}
+
+	var ward, zone sql.NullString
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT ward, zone FROM devices WHERE id = $1
+	`, deviceID).Scan(&ward, &zone); err != nil {
+		return 0, false, err
+	}
+
+	for _, scope := range []struct {
+		column string
+		value  sql.NullString
+	}{{"ward", ward}, {"zone", zone}} {
+		if !scope.value.Valid || scope.value.String == "" {
+			continue
+		}
+
+		neighbors, err := s.neighborMeters(ctx, tenantID, deviceID, meter.deviceType, scope.column, scope.value.String)
+		if err != nil {
+			return 0, false, err
+		}
+		if len(neighbors) < minCohort {
+			continue
+		}
+
+		rows, err := s.tsdb.QueryContext(ctx, `
+			SELECT (MAX(max_value) - MIN(min_value)) / EXTRACT(EPOCH FROM MAX(last_at) - MIN(bucket))
+			FROM device_telemetry_aggregates
+			WHERE device_id = ANY($1) AND metric = $2 AND bucket >= $3 AND bucket < $4
+			GROUP BY device_id
+			HAVING EXTRACT(EPOCH FROM MAX(last_at) - MIN(bucket)) >= $5
+		`, pq.Array(neighbors), meter.metric, start, end, s.config.Billing.Estimation.MinGap.Seconds())
+		if err != nil {
+			return 0, false, err
+		}
+
+		var total float64
+		count := 0
+		for rows.Next() {
+			var rate float64
+			if err := rows.Scan(&rate); err != nil {
+				rows.Close()
+				return 0, false, err
+			}
+			if rate >= 0 {
+				total += rate
+				count++
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, false, err
+		}
+
+		if count >= minCohort {
+			return total / float64(count), true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// neighborMeters lists the tenant's other devices of the type in the same
+// ward or zone.
+func (s *Service) neighborMeters(ctx context.Context, tenantID, deviceID, deviceType, column, value string) ([]string, error) {
+	// column is one of a fixed set of identifiers, never user input
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id FROM devices
+		WHERE tenant_id = $1 AND id <> $2 AND type = $3 AND `+column+` = $4
+	`, tenantID, deviceID, deviceType, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var neighbors []string
+	for rows.Next() {
+		var neighbor string
+		if err := rows.Scan(&neighbor); err != nil {
+			return nil, err
+		}
+		neighbors = append(neighbors, neighbor)
+	}
+	return neighbors, rows.Err()
+}
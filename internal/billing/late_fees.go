@@ -0,0 +1,237 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+)
+
+type overdueBill struct {
+	id          string
+	tenantID    string
+	utilityType string
+	dueDate     time.Time
+	applied     int
+}
+
+// applyLateFees charges the configured late fee on unpaid bills past their
+// grace period. Each charge has a sequence number unique per bill, so a
+// rerun or a concurrent instance can't apply the same fee twice. Bills with
+// a dispute in progress are left alone until it is closed.
+func (s *Service) applyLateFees(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT b.id, b.tenant_id, b.utility_type, b.due_date, COALESCE(MAX(f.sequence), 0)
+		FROM bills b
+		LEFT JOIN bill_late_fees f ON f.bill_id = b.id
+		WHERE b.status IN ($1, $2) AND b.due_date < CURRENT_DATE
+			AND NOT EXISTS (
+				SELECT 1 FROM bill_disputes d
+				WHERE d.bill_id = b.id AND d.status IN ($3, $4)
+			)
+		GROUP BY b.id
+	`, BillPending, BillPartiallyPaid, DisputeOpen, DisputeUnderReview)
+	if err != nil {
+		s.logger.Error("Failed to query overdue bills", "error", err)
+		return
+	}
+
+	var bills []overdueBill
+	for rows.Next() {
+		var bill overdueBill
+		if err := rows.Scan(&bill.id, &bill.tenantID, &bill.utilityType, &bill.dueDate, &bill.applied); err != nil {
+			s.logger.Error("Failed to scan overdue bill", "error", err)
+			continue
+		}
+		bills = append(bills, bill)
+	}
+	rows.Close()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for _, bill := range bills {
+		tariff, ok := s.tariff(ctx, bill.tenantID, bill.utilityType)
+		if !ok {
+			continue
+		}
+
+		due := lateFeeApplications(tariff.LateFee, daysBetween(bill.dueDate, today))
+		for sequence := bill.applied + 1; sequence <= due; sequence++ {
+			if err := s.chargeLateFee(ctx, bill.id, sequence, tariff.LateFee); err != nil {
+				s.logger.Error("Failed to apply late fee", "error", err, "bill_id", bill.id)
+				break
+			}
+		}
+	}
+}
+
+func (s *Service) chargeLateFee(ctx context.Context, billID string, sequence int, rule tenant.LateFee) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID, status string
+	var amount, amountPaid, lateFees float64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT user_id, amount, amount_paid, late_fees, status FROM bills WHERE id = $1 FOR UPDATE
+	`, billID).Scan(&userID, &amount, &amountPaid, &lateFees, &status); err != nil {
+		return err
+	}
+	if status == BillPaid {
+		return nil
+	}
+
+	// The rate applies to the unpaid charges only, so repeated fees don't
+	// compound on earlier fees
+	outstanding := toCents(amount) - toCents(amountPaid)
+	unpaidCharges := toCents(amount) - toCents(lateFees) - toCents(amountPaid)
+	if unpaidCharges < 0 {
+		unpaidCharges = 0
+	}
+	fee := toCents(rule.Flat) + toCents(fromCents(unpaidCharges)*rule.Rate)
+	if fee <= 0 {
+		return nil
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO bill_late_fees (bill_id, sequence, amount)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (bill_id, sequence) DO NOTHING
+	`, billID, sequence, fromCents(fee))
+	if err != nil {
+		return err
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE bills SET amount = amount + $1, late_fees = late_fees + $1 WHERE id = $2
+	`, fromCents(fee), billID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.notifyUser(userID, "late_fee", "high",
+		"Late fee applied",
+		fmt.Sprintf("A late fee of %.2f has been added to your overdue bill. Amount due: %.2f.",
+			fromCents(fee), fromCents(outstanding+fee)),
+		map[string]interface{}{"bill_id": billID, "late_fee": fromCents(fee), "balance": fromCents(outstanding + fee)},
+	)
+
+	return nil
+}
+
+// sendDueReminders notifies users about unpaid bills at the tariff's
+// reminder offsets (days relative to the due date; negative is before). If
+// the job misses a day, only the most recent reminder is sent, and each
+// offset is sent at most once per bill.
+func (s *Service) sendDueReminders(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, user_id, utility_type, due_date, amount - amount_paid
+		FROM bills
+		WHERE status IN ($1, $2)
+	`, BillPending, BillPartiallyPaid)
+	if err != nil {
+		s.logger.Error("Failed to query unpaid bills", "error", err)
+		return
+	}
+	defer rows.Close()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for rows.Next() {
+		var billID, tenantID, userID, utilityType string
+		var dueDate time.Time
+		var balance float64
+		if err := rows.Scan(&billID, &tenantID, &userID, &utilityType, &dueDate, &balance); err != nil {
+			s.logger.Error("Failed to scan unpaid bill", "error", err)
+			continue
+		}
+
+		tariff, ok := s.tariff(ctx, tenantID, utilityType)
+		if !ok {
+			continue
+		}
+
+		offset, ok := reminderOffset(tariff.ReminderDays, daysBetween(dueDate, today))
+		if !ok {
+			continue
+		}
+
+		result, err := s.db.ExecContext(ctx, `
+			INSERT INTO bill_reminders (bill_id, offset_days) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, billID, offset)
+		if err != nil {
+			s.logger.Error("Failed to record bill reminder", "error", err, "bill_id", billID)
+			continue
+		}
+		if inserted, _ := result.RowsAffected(); inserted == 0 {
+			continue
+		}
+
+		title, message := "Bill due soon", fmt.Sprintf("Your %s bill of %.2f is due on %s.",
+			utilityType, balance, dueDate.Format("02 Jan 2006"))
+		if offset > 0 {
+			title, message = "Bill overdue", fmt.Sprintf("Your %s bill of %.2f was due on %s. Please pay now to avoid late fees.",
+				utilityType, balance, dueDate.Format("02 Jan 2006"))
+		}
+
+		s.notifyUser(userID, "bill_reminder", "normal", title, message,
+			map[string]interface{}{"bill_id": billID, "due_date": dueDate, "balance": balance, "offset_days": offset},
+		)
+	}
+}
+
+func (s *Service) tariff(ctx context.Context, tenantID, utilityType string) (tenant.Tariff, bool) {
+	tenantConfig, err := s.tenants.Resolve(ctx, tenantID)
+	if err != nil {
+		s.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", tenantID)
+		return tenant.Tariff{}, false
+	}
+	tariff, exists := tenantConfig.Tariffs[utilityType]
+	return tariff, exists
+}
+
+// lateFeeApplications is how many late fees a bill daysOverdue past its due
+// date should have been charged in total.
+func lateFeeApplications(rule tenant.LateFee, daysOverdue int) int {
+	if rule.Flat <= 0 && rule.Rate <= 0 {
+		return 0
+	}
+	if daysOverdue <= rule.GraceDays {
+		return 0
+	}
+
+	applications := 1
+	if rule.RepeatDays > 0 {
+		applications += (daysOverdue - rule.GraceDays - 1) / rule.RepeatDays
+	}
+	if rule.MaxApplications > 0 && applications > rule.MaxApplications {
+		applications = rule.MaxApplications
+	}
+	return applications
+}
+
+// reminderOffset picks the latest configured offset that has been reached.
+func reminderOffset(offsets []int, daysPastDue int) (int, bool) {
+	sorted := append([]int(nil), offsets...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	for _, offset := range sorted {
+		if offset <= daysPastDue {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
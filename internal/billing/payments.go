@@ -0,0 +1,453 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+const (
+	BillPending       = "pending"
+	BillPartiallyPaid = "partially_paid"
+	BillPaid          = "paid"
+
+	PlanActive    = "active"
+	PlanCompleted = "completed"
+
+	InstallmentPending = "pending"
+	InstallmentPaid    = "paid"
+
+	defaultMaxInstallments     = 12
+	defaultInstallmentInterval = 30 * 24 * time.Hour
+)
+
+var errBillAlreadyPaid = errors.New("bill is already paid")
+
+type paymentRequest struct {
+	// Amount is optional; when omitted the full outstanding balance is paid
+	Amount        float64 `json:"amount"`
+	PaymentMethod string  `json:"payment_method" binding:"required"`
+	TransactionID string  `json:"transaction_id" binding:"required"`
+}
+
+type paymentPlanRequest struct {
+	Installments int `json:"installments" binding:"required,min=2"`
+}
+
+// ProcessPayment records a payment against one of the caller's bills. Bills
+// may be paid in parts; the bill stays partially_paid until the outstanding
+// balance reaches zero. Payments above the outstanding balance are rejected.
+func (s *Service) ProcessPayment(c *gin.Context) {
+	var req paymentRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+	userID := c.GetString("user_id")
+	billID := c.Param("id")
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process payment"})
+		return
+	}
+	defer tx.Rollback()
+
+	bill, err := lockUserBill(ctx, tx, tenantID, billID, userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bill not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load bill", "error", err, "bill_id", billID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process payment"})
+		return
+	}
+
+	outstanding := toCents(bill.Amount) - toCents(bill.AmountPaid)
+	if bill.Status == BillPaid || outstanding <= 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Bill is already paid"})
+		return
+	}
+
+	payment := toCents(req.Amount)
+	if payment == 0 {
+		payment = outstanding
+	}
+	if payment > outstanding {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":       "Payment exceeds the outstanding balance",
+			"outstanding": fromCents(outstanding),
+		})
+		return
+	}
+
+	var paymentID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO payments (tenant_id, bill_id, user_id, amount, payment_method, transaction_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, tenantID, billID, userID, fromCents(payment), req.PaymentMethod, req.TransactionID).Scan(&paymentID)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Payment has already been recorded"})
+			return
+		}
+		s.logger.Error("Failed to record payment", "error", err, "bill_id", billID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process payment"})
+		return
+	}
+
+	paid := toCents(bill.AmountPaid) + payment
+	bill.AmountPaid = fromCents(paid)
+	bill.Status = billStatus(toCents(bill.Amount), paid)
+
+	if _, err := tx.ExecContext(ctx, `UPDATE bills SET amount_paid = $1, status = $2 WHERE id = $3`,
+		bill.AmountPaid, bill.Status, billID); err != nil {
+		s.logger.Error("Failed to update bill", "error", err, "bill_id", billID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process payment"})
+		return
+	}
+
+	if err := allocateToInstallments(ctx, tx, billID, payment, bill.Status == BillPaid); err != nil {
+		s.logger.Error("Failed to apply payment to installments", "error", err, "bill_id", billID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process payment"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit payment", "error", err, "bill_id", billID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process payment"})
+		return
+	}
+
+	balance := fromCents(outstanding - payment)
+	message := fmt.Sprintf("We received your payment of %.2f. Remaining balance: %.2f.", fromCents(payment), balance)
+	if bill.Status == BillPaid {
+		message = fmt.Sprintf("We received your payment of %.2f. Your bill is now fully paid.", fromCents(payment))
+	}
+	s.notifyUser(userID, "payment_received", "normal", "Payment received", message,
+		map[string]interface{}{"bill_id": billID, "payment_id": paymentID, "amount": fromCents(payment), "balance": balance},
+	)
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_id":  paymentID,
+		"bill_id":     billID,
+		"amount":      fromCents(payment),
+		"amount_paid": bill.AmountPaid,
+		"balance":     balance,
+		"status":      bill.Status,
+		"message":     "Payment processed successfully",
+	})
+}
+
+// CreatePaymentPlan splits a bill's outstanding balance into equal
+// installments due at the configured interval.
+func (s *Service) CreatePaymentPlan(c *gin.Context) {
+	var req paymentPlanRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	maxInstallments := s.config.Billing.MaxInstallments
+	if maxInstallments <= 0 {
+		maxInstallments = defaultMaxInstallments
+	}
+	if req.Installments > maxInstallments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d installments are allowed", maxInstallments)})
+		return
+	}
+
+	plan, err := s.createPaymentPlan(c.Request.Context(), middleware.TenantID(c), c.Param("id"), c.GetString("user_id"), req.Installments)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bill not found"})
+		case errors.Is(err, errBillAlreadyPaid):
+			c.JSON(http.StatusConflict, gin.H{"error": "Bill is already paid"})
+		default:
+			if database.IsUniqueViolation(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Bill already has an active payment plan"})
+				return
+			}
+			s.logger.Error("Failed to create payment plan", "error", err, "bill_id", c.Param("id"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment plan"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, plan)
+}
+
+// GetPaymentPlan returns the active payment plan for one of the caller's bills.
+func (s *Service) GetPaymentPlan(c *gin.Context) {
+	ctx := c.Request.Context()
+	billID := c.Param("id")
+
+	plan := &models.PaymentPlan{BillID: billID}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, status, created_at FROM payment_plans
+		WHERE bill_id = $1 AND tenant_id = $2 AND user_id = $3 AND status = $4
+	`, billID, middleware.TenantID(c), c.GetString("user_id"), PlanActive).Scan(&plan.ID, &plan.UserID, &plan.Status, &plan.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active payment plan for this bill"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load payment plan", "error", err, "bill_id", billID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load payment plan"})
+		return
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, sequence, due_date, amount, amount_paid, status, reminded_at
+		FROM payment_plan_installments
+		WHERE plan_id = $1
+		ORDER BY sequence
+	`, plan.ID)
+	if err != nil {
+		s.logger.Error("Failed to load installments", "error", err, "plan_id", plan.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load payment plan"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var installment models.Installment
+		if err := rows.Scan(&installment.ID, &installment.Sequence, &installment.DueDate,
+			&installment.Amount, &installment.AmountPaid, &installment.Status, &installment.RemindedAt); err != nil {
+			s.logger.Error("Failed to scan installment", "error", err)
+			continue
+		}
+		plan.Installments = append(plan.Installments, installment)
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func (s *Service) createPaymentPlan(ctx context.Context, tenantID, billID, userID string, count int) (*models.PaymentPlan, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bill, err := lockUserBill(ctx, tx, tenantID, billID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	outstanding := toCents(bill.Amount) - toCents(bill.AmountPaid)
+	if bill.Status == BillPaid || outstanding <= 0 {
+		return nil, errBillAlreadyPaid
+	}
+
+	plan := &models.PaymentPlan{BillID: billID, UserID: userID, Status: PlanActive}
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO payment_plans (tenant_id, bill_id, user_id, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, tenantID, billID, userID, PlanActive).Scan(&plan.ID, &plan.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	interval := s.config.Billing.InstallmentInterval
+	if interval <= 0 {
+		interval = defaultInstallmentInterval
+	}
+
+	// Split evenly; the remainder cents go on the last installment
+	share := outstanding / int64(count)
+	for i := 0; i < count; i++ {
+		amount := share
+		if i == count-1 {
+			amount = outstanding - share*int64(count-1)
+		}
+
+		installment := models.Installment{
+			Sequence: i + 1,
+			DueDate:  plan.CreatedAt.Add(interval * time.Duration(i+1)).Truncate(24 * time.Hour),
+			Amount:   fromCents(amount),
+			Status:   InstallmentPending,
+		}
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO payment_plan_installments (plan_id, sequence, due_date, amount, status)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`, plan.ID, installment.Sequence, installment.DueDate, installment.Amount, installment.Status).Scan(&installment.ID); err != nil {
+			return nil, err
+		}
+		plan.Installments = append(plan.Installments, installment)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// allocateToInstallments applies a payment to the bill's active plan, oldest
+// installment first. When the payment closes the bill, the plan is completed.
+func allocateToInstallments(ctx context.Context, tx *sql.Tx, billID string, payment int64, closesBill bool) error {
+	var planID string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM payment_plans WHERE bill_id = $1 AND status = $2 FOR UPDATE
+	`, billID, PlanActive).Scan(&planID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, amount, amount_paid FROM payment_plan_installments
+		WHERE plan_id = $1 AND status = $2
+		ORDER BY sequence
+		FOR UPDATE
+	`, planID, InstallmentPending)
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		id        string
+		remaining int64
+		paid      int64
+	}
+	var installments []pending
+	for rows.Next() {
+		var id string
+		var amount, paid float64
+		if err := rows.Scan(&id, &amount, &paid); err != nil {
+			rows.Close()
+			return err
+		}
+		installments = append(installments, pending{id: id, remaining: toCents(amount) - toCents(paid), paid: toCents(paid)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, installment := range installments {
+		if payment <= 0 {
+			break
+		}
+
+		applied := min(payment, installment.remaining)
+		payment -= applied
+
+		status := InstallmentPending
+		if applied == installment.remaining {
+			status = InstallmentPaid
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE payment_plan_installments SET amount_paid = $1, status = $2 WHERE id = $3
+		`, fromCents(installment.paid+applied), status, installment.id); err != nil {
+			return err
+		}
+	}
+
+	if closesBill {
+		if _, err := tx.ExecContext(ctx, `UPDATE payment_plans SET status = $1 WHERE id = $2`, PlanCompleted, planID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remindInstallments notifies users of installments coming due within the
+// configured lead time. Each installment is reminded once.
+func (s *Service) remindInstallments(ctx context.Context) {
+	lead := s.config.Billing.InstallmentReminderLead
+	if lead <= 0 {
+		lead = 72 * time.Hour
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT i.id, i.sequence, i.due_date, i.amount - i.amount_paid, p.user_id, p.bill_id
+		FROM payment_plan_installments i
+		JOIN payment_plans p ON p.id = i.plan_id
+		WHERE p.status = $1 AND i.status = $2 AND i.reminded_at IS NULL AND i.due_date <= $3
+	`, PlanActive, InstallmentPending, time.Now().Add(lead))
+	if err != nil {
+		s.logger.Error("Failed to query upcoming installments", "error", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var installmentID, userID, billID string
+		var sequence int
+		var dueDate time.Time
+		var amount float64
+		if err := rows.Scan(&installmentID, &sequence, &dueDate, &amount, &userID, &billID); err != nil {
+			s.logger.Error("Failed to scan installment", "error", err)
+			continue
+		}
+
+		s.notifyUser(userID, "installment_reminder", "normal",
+			"Installment due soon",
+			fmt.Sprintf("Installment %d of %.2f for your bill is due on %s.", sequence, amount, dueDate.Format("02 Jan 2006")),
+			map[string]interface{}{"bill_id": billID, "installment_id": installmentID, "due_date": dueDate, "amount": amount},
+		)
+
+		if _, err := s.db.ExecContext(ctx, `UPDATE payment_plan_installments SET reminded_at = NOW() WHERE id = $1`, installmentID); err != nil {
+			s.logger.Error("Failed to mark installment reminded", "error", err, "installment_id", installmentID)
+		}
+	}
+}
+
+// lockUserBill loads one of a user's bills and locks it for the rest of the
+// transaction.
+func lockUserBill(ctx context.Context, tx *sql.Tx, tenantID, billID, userID string) (*models.Bill, error) {
+	bill := &models.Bill{ID: billID, TenantID: tenantID, UserID: userID}
+	err := tx.QueryRowContext(ctx, `
+		SELECT amount, amount_paid, status FROM bills
+		WHERE id = $1 AND tenant_id = $2 AND user_id = $3
+		FOR UPDATE
+	`, billID, tenantID, userID).Scan(&bill.Amount, &bill.AmountPaid, &bill.Status)
+	if err != nil {
+		return nil, err
+	}
+	return bill, nil
+}
+
+func billStatus(amount, paid int64) string {
+	switch {
+	case paid >= amount:
+		return BillPaid
+	case paid > 0:
+		return BillPartiallyPaid
+	}
+	return BillPending
+}
+
+// Money is handled in integer cents so repeated partial payments can't drift.
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
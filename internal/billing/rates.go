@@ -0,0 +1,227 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+)
+
+const (
+	rateHistoryLimit = 50
+
+	// Scheduled rate changes are applied within this long of coming due;
+	// either way they are in force from their effective time
+	ratePollInterval = time.Minute
+)
+
+// GetRates returns the tenant's effective tariffs, including due-date,
+// reminder and late-fee rules. With as_of, it returns the tariffs that
+// were in force at that time.
+func (s *Service) GetRates(c *gin.Context) {
+	tenantID := middleware.TenantID(c)
+
+	var query struct {
+		AsOf *time.Time `form:"as_of" time_format:"2006-01-02T15:04:05Z07:00"`
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if query.AsOf != nil {
+		tenantConfig, err := s.tenants.ResolveAt(c.Request.Context(), tenantID, *query.AsOf)
+		if err == tenant.ErrBeforeHistory {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tariff history does not go back to that time"})
+			return
+		}
+		if err != nil {
+			s.logger.Error("Failed to resolve past tenant config", "error", err, "tenant_id", tenantID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load rates"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tariffs": tenantConfig.Tariffs, "as_of": *query.AsOf})
+		return
+	}
+
+	tenantConfig, err := s.tenants.Resolve(c.Request.Context(), tenantID)
+	if err != nil {
+		s.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", tenantID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tariffs": tenantConfig.Tariffs})
+}
+
+// UpdateRates changes the tenant's tariffs. Changes, keyed by utility,
+// are merged into the tariffs in force; fields left out keep their value.
+// Every change needs a reason. One with a future effective_at is scheduled
+// rather than applied.
+func (s *Service) UpdateRates(c *gin.Context) {
+	tenantID := middleware.TenantID(c)
+
+	var req struct {
+		Tariffs     map[string]interface{} `json:"tariffs" binding:"required"`
+		Reason      string                 `json:"reason" binding:"required"`
+		EffectiveAt *time.Time             `json:"effective_at"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	var effectiveAt time.Time
+	if req.EffectiveAt != nil {
+		effectiveAt = *req.EffectiveAt
+	}
+
+	change, err := s.tenants.ChangeTariffs(c.Request.Context(), tenantID, req.Tariffs, req.Reason, effectiveAt, c.GetString("user_id"))
+	if errors.Is(err, tenant.ErrInvalidOverrides) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to change tariffs", "error", err, "tenant_id", tenantID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rates"})
+		return
+	}
+
+	if change.Status == tenant.TariffScheduled {
+		c.JSON(http.StatusAccepted, gin.H{
+			"change":  change,
+			"message": "Rate change scheduled",
+		})
+		return
+	}
+
+	tenantConfig, _ := s.tenants.Resolve(c.Request.Context(), tenantID)
+	c.JSON(http.StatusOK, gin.H{
+		"tariffs": tenantConfig.Tariffs,
+		"change":  change,
+		"message": "Rates updated successfully",
+	})
+}
+
+// ListRateChanges returns the tenant's latest tariff changes, newest
+// first, each with who made it, why, and what it altered.
+func (s *Service) ListRateChanges(c *gin.Context) {
+	changes, err := s.tenants.TariffHistory(c.Request.Context(), middleware.TenantID(c), rateHistoryLimit)
+	if err != nil {
+		s.logger.Error("Failed to list tariff changes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rate history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
+
+func (s *Service) GetRateChange(c *gin.Context) {
+	change, err := s.tenants.TariffChange(c.Request.Context(), middleware.TenantID(c), c.Param("id"))
+	if err == tenant.ErrTariffChangeNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rate change not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load tariff change", "error", err, "tariff_change_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rate change"})
+		return
+	}
+
+	c.JSON(http.StatusOK, change)
+}
+
+// CancelRateChange cancels a scheduled change before it takes effect.
+func (s *Service) CancelRateChange(c *gin.Context) {
+	change, err := s.tenants.CancelTariffChange(c.Request.Context(), middleware.TenantID(c), c.Param("id"), c.GetString("user_id"))
+	switch {
+	case err == tenant.ErrTariffChangeNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rate change not found"})
+	case err == tenant.ErrNotScheduled:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		s.logger.Error("Failed to cancel tariff change", "error", err, "tariff_change_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel rate change"})
+	default:
+		c.JSON(http.StatusOK, change)
+	}
+}
+
+// RollbackRateChange restores the tariffs in force before the tenant's
+// latest change. It is refused once bills have been generated for a
+// utility the change altered, since those bills were priced with it.
+func (s *Service) RollbackRateChange(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	change, err := s.tenants.TariffChange(ctx, tenantID, c.Param("id"))
+	if err == tenant.ErrTariffChangeNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rate change not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load tariff change", "error", err, "tariff_change_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back rate change"})
+		return
+	}
+
+	if change.AppliedAt != nil {
+		var billed bool
+		err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM bills WHERE tenant_id = $1 AND utility_type = ANY($2) AND created_at >= $3
+			)
+		`, tenantID, pq.Array(change.Utilities()), *change.AppliedAt).Scan(&billed)
+		if err != nil {
+			s.logger.Error("Failed to check bills for tariff change", "error", err, "tariff_change_id", change.ID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back rate change"})
+			return
+		}
+		if billed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Bills have been generated with this rate change; adjust them instead"})
+			return
+		}
+	}
+
+	rollback, err := s.tenants.RollbackTariffChange(ctx, tenantID, change.ID, req.Reason, c.GetString("user_id"))
+	switch {
+	case err == tenant.ErrNotLatest:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, tenant.ErrInvalidOverrides):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		s.logger.Error("Failed to roll back tariff change", "error", err, "tariff_change_id", change.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back rate change"})
+	default:
+		c.JSON(http.StatusOK, rollback)
+	}
+}
+
+// applyScheduledRates applies scheduled tariff changes as they come due.
+func (s *Service) applyScheduledRates(ctx context.Context) {
+	ticker := time.NewTicker(ratePollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.tenants.ApplyDueTariffChanges(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("Failed to apply scheduled rate changes", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package billing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/events"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+type Service struct {
+	db       *database.PostgresDB
+	tsdb     *database.PostgresDB
+	redis    *database.RedisDB
+	bus      *events.Bus
+	tenants  *tenant.Store
+	types    *devicetype.Store
+	config   *config.Config
+	logger   logger.Logger
+}
+
+func NewService(db *database.PostgresDB, tsdb *database.PostgresDB, redis *database.RedisDB,
+	bus *events.Bus, tenants *tenant.Store, cfg *config.Config, log logger.Logger) *Service {
+	return &Service{
+		db:       db,
+		tsdb:     tsdb,
+		redis:    redis,
+		bus:      bus,
+		tenants:  tenants,
+		types:    devicetype.NewStore(db),
+		config:   cfg,
+		logger:   log,
+	}
+}
+
+// SyncWith shares the service's device type cache invalidations with other
+// processes.
+func (s *Service) SyncWith(syncer *cache.Syncer) {
+	s.types.SyncWith(syncer)
+}
+
+// Start runs the billing background jobs until ctx is cancelled, and
+// returns once a run in progress has finished.
+func (s *Service) Start(ctx context.Context) error {
+	s.logger.Info("Billing service started")
+
+	var jobs sync.WaitGroup
+	jobs.Add(2)
+	go func() {
+		defer jobs.Done()
+		s.applyScheduledRates(ctx)
+	}()
+	go func() {
+		defer jobs.Done()
+		s.materializeConsumption(ctx)
+	}()
+
+	s.runScheduledJobs(ctx)
+	jobs.Wait()
+
+	s.logger.Info("Billing service stopped")
+	return nil
+}
+
+func (s *Service) runScheduledJobs(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		s.remindInstallments(ctx)
+		s.sendDueReminders(ctx)
+		s.applyLateFees(ctx)
+		s.checkBudgets(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// notifyUser hands a notification to the notification service over Kafka.
+func (s *Service) notifyUser(userID, notificationType, priority, title, message string,
+	metadata map[string]interface{}) {
+	if s.bus == nil {
+		return
+	}
+
+	err := events.Publish(context.Background(), s.bus, events.Notification{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Type:     notificationType,
+		Title:    title,
+		Message:  message,
+		Priority: priority,
+		Channels: []string{"email", "push"},
+		Metadata: metadata,
+	})
+	metrics.NotificationPublishes.WithLabelValues("billing", metrics.Result(err)).Inc()
+}
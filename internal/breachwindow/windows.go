@@ -0,0 +1,69 @@
+// Package breachwindow remembers whether each of a device's recent samples
+// breached a threshold, so a rule can require N of the last M samples to
+// breach before raising an anomaly. One transient spike then passes
+// unnoticed while a sustained problem is still caught.
+package breachwindow
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// Live is the scope of windows fed by incoming telemetry. Replays use
+// their own scope so historical samples never mix with live ones.
+const Live = "live"
+
+// windowTTL drops the window of a device that has gone quiet, so samples
+// from before a long gap don't count towards a new breach.
+const windowTTL = 24 * time.Hour
+
+// recordScript pushes the newest outcome, trims the window to its size and
+// returns how many outcomes in it are breaches.
+const recordScript = `
+redis.call('LPUSH', KEYS[1], ARGV[1])
+redis.call('LTRIM', KEYS[1], 0, tonumber(ARGV[2]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+local breaches = 0
+for _, outcome in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if outcome == '1' then
+		breaches = breaches + 1
+	end
+end
+return breaches
+`
+
+// Windows keeps one window per scope, device and metric in Redis.
+type Windows struct {
+	redis *database.RedisClient
+}
+
+func New(redis *database.RedisClient) *Windows {
+	return &Windows{redis: redis}
+}
+
+// Record adds a sample's outcome to the window of the last size samples
+// and returns how many of them breached.
+func (w *Windows) Record(ctx context.Context, scope, deviceID, metric string, size int, breached bool) (int, error) {
+	outcome := "0"
+	if breached {
+		outcome = "1"
+	}
+
+	reply, err := w.redis.Eval(ctx, recordScript, []string{key(scope, deviceID, metric)},
+		outcome, size, int(windowTTL.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	breaches, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected breach window reply %v", reply)
+	}
+	return int(breaches), nil
+}
+
+func key(scope, deviceID, metric string) string {
+	return fmt.Sprintf("anomaly_window:%s:%s:%s", scope, deviceID, metric)
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+    "strings"
     "time"
     "github.com/spf13/viper"
 )
@@ -16,6 +17,40 @@ type Config struct {
         IdleTimeout  time.Duration `mapstructure:"idle_timeout"`
     } `mapstructure:"server"`
     
+    // Shutdown bounds each phase of stopping a service: draining requests
+    // and background work, flushing buffered writes, and closing clients
+    // and connections.
+    Shutdown struct {
+        DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+        FlushTimeout time.Duration `mapstructure:"flush_timeout"`
+        CloseTimeout time.Duration `mapstructure:"close_timeout"`
+    } `mapstructure:"shutdown"`
+    
+    // Pagination sets the page size of list endpoints when a request gives
+    // no limit, and the largest limit a request may ask for.
+    Pagination struct {
+        DefaultLimit int `mapstructure:"default_limit"`
+        MaxLimit     int `mapstructure:"max_limit"`
+    } `mapstructure:"pagination"`
+    
+    // LoadShedding refuses non-critical requests while the database pool
+    // or the ingestion queue is nearly full. Threshold is the share of
+    // either at which shedding starts; critical routes are gin route
+    // patterns that are never shed.
+    LoadShedding struct {
+        Enabled        bool          `mapstructure:"enabled"`
+        Threshold      float64       `mapstructure:"threshold"`
+        RetryAfter     time.Duration `mapstructure:"retry_after"`
+        CriticalRoutes []string      `mapstructure:"critical_routes"`
+    } `mapstructure:"load_shedding"`
+    
+    // GRPC is the internal service-to-service API. Port is where a service
+    // serves it; the addresses are where clients find other services.
+    GRPC struct {
+        Port              int    `mapstructure:"port"`
+        DeviceServiceAddr string `mapstructure:"device_service_addr"`
+    } `mapstructure:"grpc"`
+    
     Database struct {
         Postgres struct {
             Host     string `mapstructure:"host"`
@@ -47,6 +82,148 @@ type Config struct {
         ExpiresIn time.Duration `mapstructure:"expires_in"`
     } `mapstructure:"jwt"`
     
+    Auth struct {
+        RefreshTokenExpiry   time.Duration `mapstructure:"refresh_token_expiry"`
+        PasswordMinLength    int           `mapstructure:"password_min_length"`
+        MaxLoginAttempts     int           `mapstructure:"max_login_attempts"`
+        LockoutDuration      time.Duration `mapstructure:"lockout_duration"`
+        RequireMFA           bool          `mapstructure:"require_mfa"`
+        SlidingSessions      bool          `mapstructure:"sliding_sessions"`
+        SessionMaxLifetime   time.Duration `mapstructure:"session_max_lifetime"`
+        SessionOutageGrace   time.Duration `mapstructure:"session_outage_grace"`
+        ImpossibleTravelKmh  float64       `mapstructure:"impossible_travel_kmh"`
+        LoginConfirmationTTL time.Duration `mapstructure:"login_confirmation_ttl"`
+        
+        PasswordPolicy struct {
+            RequireUpper  bool          `mapstructure:"require_upper"`
+            RequireLower  bool          `mapstructure:"require_lower"`
+            RequireDigit  bool          `mapstructure:"require_digit"`
+            RequireSymbol bool          `mapstructure:"require_symbol"`
+            CheckBreached bool          `mapstructure:"check_breached"`
+            BreachTimeout time.Duration `mapstructure:"breach_timeout"`
+        } `mapstructure:"password_policy"`
+        
+        // LoginThrottle delays logins after repeated failures for the same
+        // username or client IP, doubling the delay with each failure past
+        // the free attempts. Once either is CaptchaAfter failures past its
+        // free attempts the client must also pass a CAPTCHA, if a verifier
+        // is configured.
+        LoginThrottle struct {
+            FreeAttempts   int           `mapstructure:"free_attempts"`
+            IPFreeAttempts int           `mapstructure:"ip_free_attempts"`
+            BaseDelay      time.Duration `mapstructure:"base_delay"`
+            MaxDelay       time.Duration `mapstructure:"max_delay"`
+            Window         time.Duration `mapstructure:"window"`
+            CaptchaAfter   int           `mapstructure:"captcha_after"`
+            
+            // Captcha is a reCAPTCHA- or hCaptcha-style siteverify endpoint
+            Captcha struct {
+                VerifyURL string        `mapstructure:"verify_url"`
+                Secret    string        `mapstructure:"secret"`
+                Timeout   time.Duration `mapstructure:"timeout"`
+            } `mapstructure:"captcha"`
+        } `mapstructure:"login_throttle"`
+        
+        // SSO lists the OpenID Connect providers users can sign in with
+        // alongside passwords, by the name used in /auth/sso/:provider
+        SSO map[string]SSOProviderConfig `mapstructure:"sso"`
+    } `mapstructure:"auth"`
+    
+    Devices struct {
+        BulkStatusMax int `mapstructure:"bulk_status_max"`
+        
+        // BulkUpdate caps PATCH /devices/bulk. Updates touching more than
+        // ConfirmAbove devices must be resent with "confirm": true.
+        BulkUpdate struct {
+            MaxDevices   int `mapstructure:"max_devices"`
+            ConfirmAbove int `mapstructure:"confirm_above"`
+        } `mapstructure:"bulk_update"`
+        
+        Ingestion struct {
+            QueueCapacity int           `mapstructure:"queue_capacity"`
+            Workers       int           `mapstructure:"workers"`
+            RetryAfter    time.Duration `mapstructure:"retry_after"`
+            MaxBatchSize  int           `mapstructure:"max_batch_size"`
+        } `mapstructure:"ingestion"`
+        
+        // Credentials configures per-device API keys and certificates.
+        // Certificates need the CA files; serving ingestion over TLS, so
+        // devices can present them, needs the TLS files.
+        Credentials struct {
+            CACertFile          string        `mapstructure:"ca_cert_file"`
+            CAKeyFile           string        `mapstructure:"ca_key_file"`
+            CertificateLifetime time.Duration `mapstructure:"certificate_lifetime"`
+            RotationGrace       time.Duration `mapstructure:"rotation_grace"`
+            TLSCertFile         string        `mapstructure:"tls_cert_file"`
+            TLSKeyFile          string        `mapstructure:"tls_key_file"`
+        } `mapstructure:"credentials"`
+        
+        Replay struct {
+            BatchSize     int `mapstructure:"batch_size"`
+            RowsPerSecond int `mapstructure:"rows_per_second"`
+        } `mapstructure:"replay"`
+        
+        // Baselines learns each device's normal range from its telemetry
+        // and flags readings more than ZThreshold standard deviations out
+        Baselines struct {
+            Enabled            bool          `mapstructure:"enabled"`
+            LearnInterval      time.Duration `mapstructure:"learn_interval"`
+            Lookback           time.Duration `mapstructure:"lookback"`
+            MinSamples         int           `mapstructure:"min_samples"`
+            MinHourSamples     int           `mapstructure:"min_hour_samples"`
+            ZThreshold         float64       `mapstructure:"z_threshold"`
+            CriticalZThreshold float64       `mapstructure:"critical_z_threshold"`
+        } `mapstructure:"baselines"`
+        
+        // Reconciliation compares the device registry against telemetry:
+        // IDs sending data without being registered, telemetry left by
+        // devices since removed, and registered devices gone silent
+        Reconciliation struct {
+            Interval    time.Duration `mapstructure:"interval"`
+            Lookback    time.Duration `mapstructure:"lookback"`
+            SilentAfter time.Duration `mapstructure:"silent_after"`
+            
+            // AutoRegister registers unregistered IDs starting with one of
+            // Prefixes, if the tenant and type they claim exist; the rest
+            // are only flagged
+            AutoRegister struct {
+                Enabled  bool     `mapstructure:"enabled"`
+                Prefixes []string `mapstructure:"prefixes"`
+            } `mapstructure:"auto_register"`
+        } `mapstructure:"reconciliation"`
+    } `mapstructure:"devices"`
+    
+    Billing struct {
+        MaxInstallments         int           `mapstructure:"max_installments"`
+        InstallmentInterval     time.Duration `mapstructure:"installment_interval"`
+        InstallmentReminderLead time.Duration `mapstructure:"installment_reminder_lead"`
+        ComparisonMinCohort     int           `mapstructure:"comparison_min_cohort"`
+        
+        // Estimation fills the part of a billing period a meter didn't
+        // report. Methods are tried in order until one has enough data;
+        // an empty list turns estimation off.
+        Estimation struct {
+            Methods  []string      `mapstructure:"methods"`
+            Lookback time.Duration `mapstructure:"lookback"`
+            MinGap   time.Duration `mapstructure:"min_gap"`
+        } `mapstructure:"estimation"`
+        
+        // Consumption is materialized from raw telemetry into Period-long
+        // buckets every RefreshInterval, leaving readings younger than
+        // Settle for the next run so late arrivals are still counted.
+        // Register increases across a gap longer than MaxGap are spread
+        // over the gap and flagged as interpolated. Flows integrates rate
+        // metrics for types without a register.
+        Consumption struct {
+            RefreshInterval time.Duration           `mapstructure:"refresh_interval"`
+            Period          time.Duration           `mapstructure:"period"`
+            Settle          time.Duration           `mapstructure:"settle"`
+            MaxGap          time.Duration           `mapstructure:"max_gap"`
+            BatchSize       int                     `mapstructure:"batch_size"`
+            Flows           []ConsumptionFlowConfig `mapstructure:"flows"`
+        } `mapstructure:"consumption"`
+    } `mapstructure:"billing"`
+    
     Kafka struct {
         Brokers []string `mapstructure:"brokers"`
         Topics  struct {
@@ -54,40 +231,326 @@ type Config struct {
             Alerts        string `mapstructure:"alerts"`
             Commands      string `mapstructure:"commands"`
             Notifications string `mapstructure:"notifications"`
+            // DeviceEvents carries typed device events such as
+            // device.connectivity_changed for integrators
+            DeviceEvents string `mapstructure:"device_events"`
+            // DeviceDataProtobuf carries telemetryv1.TelemetryBatch
+            // messages, the compact alternative to JSON on DeviceData
+            DeviceDataProtobuf string `mapstructure:"device_data_protobuf"`
         } `mapstructure:"topics"`
+        
+        // AutoCreateTopics makes services create missing topics at startup.
+        // Leave it off for managed Kafka, where topics are provisioned
+        // separately and clients usually lack permission to create them.
+        AutoCreateTopics bool                   `mapstructure:"auto_create_topics"`
+        TopicDefaults    TopicConfig            `mapstructure:"topic_defaults"`
+        TopicOverrides   map[string]TopicConfig `mapstructure:"topic_overrides"`
+        
+        // Consumer sets how each polled batch is processed: up to
+        // Concurrency messages at once, those with the same key (a
+        // device, a user) in order. Offsets are committed once the whole
+        // batch is done.
+        Consumer struct {
+            Concurrency int `mapstructure:"concurrency"`
+        } `mapstructure:"consumer"`
     } `mapstructure:"kafka"`
     
+    Notifications struct {
+        // Retry governs transient failures when sending to email, SMS and
+        // push providers
+        Retry struct {
+            MaxAttempts    int           `mapstructure:"max_attempts"`
+            InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+            MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+            Jitter         float64       `mapstructure:"jitter"`
+        } `mapstructure:"retry"`
+        
+        // Health decides when a provider is skipped as degraded
+        Health struct {
+            Interval         time.Duration `mapstructure:"interval"`
+            Window           time.Duration `mapstructure:"window"`
+            FailureThreshold float64       `mapstructure:"failure_threshold"`
+            MinSamples       int           `mapstructure:"min_samples"`
+        } `mapstructure:"health"`
+        
+        // Dedup collapses repeats of the same notification to a user
+        Dedup struct {
+            Window           time.Duration `mapstructure:"window"`
+            IncludeEmergency bool          `mapstructure:"include_emergency"`
+        } `mapstructure:"dedup"`
+        
+        // Hold keeps notifications no channel could take until one
+        // recovers. Those still held after MaxAge are marked undeliverable.
+        Hold struct {
+            MaxAge time.Duration `mapstructure:"max_age"`
+        } `mapstructure:"hold"`
+        
+        // BulkPreferences caps PATCH /admin/notifications/preferences/bulk.
+        // Updates reaching more than ConfirmAbove users must be resent with
+        // "confirm": true.
+        BulkPreferences struct {
+            ConfirmAbove int `mapstructure:"confirm_above"`
+        } `mapstructure:"bulk_preferences"`
+    } `mapstructure:"notifications"`
+    
     Security struct {
-        CORSOrigins      []string `mapstructure:"cors_origins"`
-        RateLimitPerMin  int      `mapstructure:"rate_limit_per_min"`
+        CORSOrigins           []string `mapstructure:"cors_origins"`
+        RateLimitPerMin       int      `mapstructure:"rate_limit_per_min"`
+        EnableCSRF            bool     `mapstructure:"enable_csrf"`
+        CSRFExemptPaths       []string `mapstructure:"csrf_exempt_paths"`
+        RequireHTTPS          bool     `mapstructure:"require_https"`
+        FrameOptions          string   `mapstructure:"frame_options"`
+        ContentSecurityPolicy string   `mapstructure:"content_security_policy"`
+        
+        // MaxBodyBytes caps request bodies; BodyLimits overrides it per route
+        // pattern (e.g. "/api/v1/devices/:id") for larger uploads
+        MaxBodyBytes int64            `mapstructure:"max_body_bytes"`
+        BodyLimits   map[string]int64 `mapstructure:"body_limits"`
+        
+        HSTS struct {
+            Enabled           bool          `mapstructure:"enabled"`
+            MaxAge            time.Duration `mapstructure:"max_age"`
+            IncludeSubdomains bool          `mapstructure:"include_subdomains"`
+            Preload           bool          `mapstructure:"preload"`
+        } `mapstructure:"hsts"`
+        
+        // Services holds per-service overrides keyed by service name
+        Services map[string]ServiceSecurity `mapstructure:"services"`
     } `mapstructure:"security"`
     
+    Debug struct {
+        // BodyLogging logs the request and response bodies of the listed
+        // route patterns with secrets redacted. It is refused in production
+        // unless AllowInProduction is also set.
+        BodyLogging struct {
+            Enabled           bool     `mapstructure:"enabled"`
+            Routes            []string `mapstructure:"routes"`
+            MaxBytes          int      `mapstructure:"max_bytes"`
+            RedactFields      []string `mapstructure:"redact_fields"`
+            AllowInProduction bool     `mapstructure:"allow_in_production"`
+        } `mapstructure:"body_logging"`
+    } `mapstructure:"debug"`
+    
+    Tenancy struct {
+        CacheTTL time.Duration `mapstructure:"cache_ttl"`
+        
+        // Defaults are the base every tenant's overrides are layered onto
+        Defaults struct {
+            Timezone   string                                `mapstructure:"timezone"`
+            Tariffs    map[string]TariffConfig               `mapstructure:"tariffs"`
+            Thresholds map[string]map[string]ThresholdConfig `mapstructure:"thresholds"`
+            Templates  map[string]TemplateConfig             `mapstructure:"templates"`
+            
+            // AlertRouting picks the team notified of each new alert
+            AlertRouting AlertRoutingConfig `mapstructure:"alert_routing"`
+            
+            Branding struct {
+                DisplayName  string `mapstructure:"display_name"`
+                LogoURL      string `mapstructure:"logo_url"`
+                PrimaryColor string `mapstructure:"primary_color"`
+                SupportEmail string `mapstructure:"support_email"`
+            } `mapstructure:"branding"`
+        } `mapstructure:"defaults"`
+    } `mapstructure:"tenancy"`
+    
+    // Residency tags devices, their telemetry and users with the region
+    // (jurisdiction) their data must stay in. Callers only reach records in
+    // their own region and any they are granted. No regions turns it off.
+    Residency struct {
+        Regions []string `mapstructure:"regions"`
+        
+        // DefaultRegion is the region of records not tagged with one
+        DefaultRegion string `mapstructure:"default_region"`
+    } `mapstructure:"residency"`
+    
+    // Cache holds in-process caches of reference data together across
+    // services. Each process polls Redis every SyncInterval for changes
+    // made elsewhere; 0 leaves other processes to their caches' TTLs.
+    Cache struct {
+        SyncInterval time.Duration `mapstructure:"sync_interval"`
+    } `mapstructure:"cache"`
+    
+    // Features holds feature-flag defaults; runtime overrides live in Redis
+    Features map[string]FeatureConfig `mapstructure:"features"`
+    
     Monitoring struct {
         MetricsPort int    `mapstructure:"metrics_port"`
         LogLevel    string `mapstructure:"log_level"`
     } `mapstructure:"monitoring"`
+    
+    // Startup controls how a service waits for its dependencies before
+    // serving. Connections are retried with exponential backoff from
+    // InitialBackoff up to MaxBackoff until WaitTimeout has passed.
+    Startup struct {
+        CheckDependencies bool          `mapstructure:"check_dependencies"`
+        DependencyTimeout time.Duration `mapstructure:"dependency_timeout"`
+        WaitTimeout       time.Duration `mapstructure:"wait_timeout"`
+        InitialBackoff    time.Duration `mapstructure:"initial_backoff"`
+        MaxBackoff        time.Duration `mapstructure:"max_backoff"`
+    } `mapstructure:"startup"`
+}
+
+type ServiceSecurity struct {
+    CORSOrigins           []string `mapstructure:"cors_origins"`
+    EnableCSRF            *bool    `mapstructure:"enable_csrf"`
+    RequireHTTPS          *bool    `mapstructure:"require_https"`
+    EnableHSTS            *bool    `mapstructure:"enable_hsts"`
+    FrameOptions          string   `mapstructure:"frame_options"`
+    ContentSecurityPolicy string   `mapstructure:"content_security_policy"`
+}
+
+// TopicConfig is how an auto-created topic is laid out. A zero Retention
+// keeps the broker default.
+type TopicConfig struct {
+    Partitions        int           `mapstructure:"partitions"`
+    ReplicationFactor int           `mapstructure:"replication_factor"`
+    Retention         time.Duration `mapstructure:"retention"`
+}
+
+// Topic returns the layout for the named topic: its override if there is
+// one, with unset fields taken from the defaults.
+func (c *Config) Topic(name string) TopicConfig {
+    topic := c.Kafka.TopicDefaults
+    override := c.Kafka.TopicOverrides[name]
+    if override.Partitions > 0 {
+        topic.Partitions = override.Partitions
+    }
+    if override.ReplicationFactor > 0 {
+        topic.ReplicationFactor = override.ReplicationFactor
+    }
+    if override.Retention > 0 {
+        topic.Retention = override.Retention
+    }
+    return topic
+}
+
+type TariffConfig struct {
+    Unit         string        `mapstructure:"unit"`
+    RatePerUnit  float64       `mapstructure:"rate_per_unit"`
+    FixedCharge  float64       `mapstructure:"fixed_charge"`
+    TaxRate      float64       `mapstructure:"tax_rate"`
+    DueDays      int           `mapstructure:"due_days"`
+    ReminderDays []int         `mapstructure:"reminder_days"`
+    LateFee      LateFeeConfig `mapstructure:"late_fee"`
+}
+
+// LateFeeConfig is charged once a bill is GraceDays past due, then again
+// every RepeatDays (if set) up to MaxApplications times.
+type LateFeeConfig struct {
+    Flat            float64 `mapstructure:"flat"`
+    Rate            float64 `mapstructure:"rate"`
+    GraceDays       int     `mapstructure:"grace_days"`
+    RepeatDays      int     `mapstructure:"repeat_days"`
+    MaxApplications int     `mapstructure:"max_applications"`
+}
+
+type ThresholdConfig struct {
+    Max         float64 `mapstructure:"max"`
+    Type        string  `mapstructure:"type"`
+    Severity    string  `mapstructure:"severity"`
+    Description string  `mapstructure:"description"`
+    // Window and Breaches require Breaches of the last Window samples to
+    // exceed Max; unset, every breaching sample raises an anomaly
+    Window   int `mapstructure:"window"`
+    Breaches int `mapstructure:"breaches"`
+}
+
+type TemplateConfig struct {
+    Title   string `mapstructure:"title"`
+    Message string `mapstructure:"message"`
 }
 
+// AlertRoutingConfig sends alerts matching a route to its team, and the
+// rest to DefaultTeam. Routes are tried in order.
+type AlertRoutingConfig struct {
+    DefaultTeam string                     `mapstructure:"default_team"`
+    Teams       map[string]AlertTeamConfig `mapstructure:"teams"`
+    Routes      []AlertRouteConfig         `mapstructure:"routes"`
+}
+
+type AlertTeamConfig struct {
+    Roles []string `mapstructure:"roles"`
+    Users []string `mapstructure:"users"`
+}
+
+type AlertRouteConfig struct {
+    AlertTypes  []string `mapstructure:"alert_types"`
+    DeviceTypes []string `mapstructure:"device_types"`
+    Severities  []string `mapstructure:"severities"`
+    Wards       []string `mapstructure:"wards"`
+    Zones       []string `mapstructure:"zones"`
+    Team        string   `mapstructure:"team"`
+}
+
+// SSOProviderConfig is an OpenID Connect identity provider. Users it signs
+// in are matched to local users by their subject there, then by verified
+// email if LinkByEmail is set; others are created in TenantID with
+// DefaultRole if AutoProvision is set. AllowedDomains, if set, limits
+// sign-in to those email domains. After signing in the browser is sent to
+// FrontendURL with the tokens in the fragment, or given them as JSON if
+// it's empty.
+type SSOProviderConfig struct {
+    Issuer         string        `mapstructure:"issuer"`
+    ClientID       string        `mapstructure:"client_id"`
+    ClientSecret   string        `mapstructure:"client_secret"`
+    RedirectURL    string        `mapstructure:"redirect_url"`
+    Scopes         []string      `mapstructure:"scopes"`
+    TenantID       string        `mapstructure:"tenant_id"`
+    DefaultRole    string        `mapstructure:"default_role"`
+    AutoProvision  bool          `mapstructure:"auto_provision"`
+    LinkByEmail    bool          `mapstructure:"link_by_email"`
+    AllowedDomains []string      `mapstructure:"allowed_domains"`
+    FrontendURL    string        `mapstructure:"frontend_url"`
+    Timeout        time.Duration `mapstructure:"timeout"`
+}
+
+// ConsumptionFlowConfig materializes a device type's RateMetric, in units
+// per Per, as consumption of Metric. A flow in litres per minute is
+// {rate_metric: flow_rate, metric: volume, per: 1m}.
+type ConsumptionFlowConfig struct {
+    DeviceType string        `mapstructure:"device_type"`
+    RateMetric string        `mapstructure:"rate_metric"`
+    Metric     string        `mapstructure:"metric"`
+    Per        time.Duration `mapstructure:"per"`
+}
+
+type FeatureConfig struct {
+    Description string   `mapstructure:"description"`
+    Enabled     bool     `mapstructure:"enabled"`
+    Percentage  int      `mapstructure:"percentage"`
+    Tenants     []string `mapstructure:"tenants"`
+}
+
+// Load builds the configuration from, lowest precedence first: defaults,
+// configs/config.yaml, configs/config.{environment}.yaml and environment
+// variables, where server.read_timeout is read from SERVER_READ_TIMEOUT.
+// The environment comes from ENVIRONMENT, or the base file if unset.
 func Load() (*Config, error) {
-    viper.SetConfigName("config")
     viper.SetConfigType("yaml")
-    viper.AddConfigPath("./configs")
-    viper.AddConfigPath(".")
     
     // Set defaults
     setDefaults()
     
     // Enable environment variable binding
+    viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
     viper.AutomaticEnv()
     
-    // Read config file (optional)
-    viper.ReadInConfig()
+    // Read config files (optional)
+    if err := readConfigFiles(); err != nil {
+        return nil, err
+    }
     
     var cfg Config
     if err := viper.Unmarshal(&cfg); err != nil {
         return nil, err
     }
     
+    // Dependencies are checked by the service once it has a logger, see
+    // startup.Waiter.Dependencies
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+    
     return &cfg, nil
 }
 
@@ -98,11 +561,70 @@ func setDefaults() {
     viper.SetDefault("server.read_timeout", "30s")
     viper.SetDefault("server.write_timeout", "30s")
     viper.SetDefault("server.idle_timeout", "60s")
+    viper.SetDefault("shutdown.drain_timeout", "30s")
+    viper.SetDefault("shutdown.flush_timeout", "15s")
+    viper.SetDefault("shutdown.close_timeout", "5s")
+    viper.SetDefault("pagination.default_limit", 20)
+    viper.SetDefault("pagination.max_limit", 100)
+    viper.SetDefault("load_shedding.enabled", false)
+    viper.SetDefault("load_shedding.threshold", 0.9)
+    viper.SetDefault("load_shedding.retry_after", "5s")
+    viper.SetDefault("load_shedding.critical_routes", []string{
+        "/health",
+        "/api/v1/public/status",
+        "/api/v1/alerts/:id/ack",
+        "/api/v1/alerts/:id/resolve",
+    })
+    viper.SetDefault("grpc.port", 9091)
+    viper.SetDefault("grpc.device_service_addr", "localhost:9091")
     viper.SetDefault("jwt.secret", "default-secret-change-in-production")
     viper.SetDefault("jwt.expires_in", "24h")
+    viper.SetDefault("auth.refresh_token_expiry", "168h")
+    viper.SetDefault("auth.password_min_length", 8)
+    viper.SetDefault("auth.password_policy.require_upper", true)
+    viper.SetDefault("auth.password_policy.require_lower", true)
+    viper.SetDefault("auth.password_policy.require_digit", true)
+    viper.SetDefault("auth.password_policy.breach_timeout", "3s")
+    viper.SetDefault("auth.session_max_lifetime", "720h")
+    viper.SetDefault("auth.session_outage_grace", "1m")
+    viper.SetDefault("auth.max_login_attempts", 5)
+    viper.SetDefault("auth.lockout_duration", "15m")
+    viper.SetDefault("auth.impossible_travel_kmh", 900)
+    viper.SetDefault("auth.login_confirmation_ttl", "15m")
+    viper.SetDefault("auth.login_throttle.free_attempts", 3)
+    viper.SetDefault("auth.login_throttle.ip_free_attempts", 20)
+    viper.SetDefault("auth.login_throttle.base_delay", "1s")
+    viper.SetDefault("auth.login_throttle.max_delay", "15m")
+    viper.SetDefault("auth.login_throttle.window", "1h")
+    viper.SetDefault("auth.login_throttle.captcha_after", 2)
+    viper.SetDefault("auth.login_throttle.captcha.timeout", "3s")
+    viper.SetDefault("auth.sso", map[string]SSOProviderConfig{})
     viper.SetDefault("monitoring.metrics_port", 9090)
     viper.SetDefault("monitoring.log_level", "info")
+    viper.SetDefault("notifications.retry.max_attempts", 3)
+    viper.SetDefault("notifications.retry.initial_backoff", "500ms")
+    viper.SetDefault("notifications.retry.max_backoff", "10s")
+    viper.SetDefault("notifications.retry.jitter", 0.2)
+    viper.SetDefault("notifications.health.interval", "30s")
+    viper.SetDefault("notifications.health.window", "5m")
+    viper.SetDefault("notifications.health.failure_threshold", 0.5)
+    viper.SetDefault("notifications.health.min_samples", 5)
+    viper.SetDefault("notifications.dedup.window", "15m")
+    viper.SetDefault("notifications.dedup.include_emergency", false)
+    viper.SetDefault("notifications.hold.max_age", "72h")
+    viper.SetDefault("notifications.bulk_preferences.confirm_above", 100)
     viper.SetDefault("security.rate_limit_per_min", 100)
+    viper.SetDefault("security.frame_options", "DENY")
+    viper.SetDefault("security.max_body_bytes", 1<<20)
+    viper.SetDefault("security.csrf_exempt_paths", []string{"/api/v1/auth/login", "/api/v1/auth/refresh", "/api/v1/auth/sso/verify", "/api/v1/telemetry*"})
+    viper.SetDefault("security.hsts.enabled", true)
+    viper.SetDefault("security.hsts.max_age", "8760h")
+    viper.SetDefault("security.hsts.include_subdomains", true)
+    viper.SetDefault("debug.body_logging.enabled", false)
+    viper.SetDefault("debug.body_logging.routes", []string{})
+    viper.SetDefault("debug.body_logging.max_bytes", 16384)
+    viper.SetDefault("debug.body_logging.redact_fields", []string{})
+    viper.SetDefault("debug.body_logging.allow_in_production", false)
     viper.SetDefault("database.postgres.host", "localhost")
     viper.SetDefault("database.postgres.port", 5432)
     viper.SetDefault("database.postgres.user", "postgres")
@@ -113,4 +635,54 @@ func setDefaults() {
     viper.SetDefault("database.redis.port", 6379)
     viper.SetDefault("database.redis.db", 0)
     viper.SetDefault("kafka.brokers", []string{"localhost:9092"})
+    viper.SetDefault("kafka.auto_create_topics", false)
+    viper.SetDefault("kafka.topic_defaults.partitions", 3)
+    viper.SetDefault("kafka.topic_defaults.replication_factor", 1)
+    viper.SetDefault("kafka.consumer.concurrency", 8)
+    viper.SetDefault("devices.bulk_status_max", 5000)
+    viper.SetDefault("devices.bulk_update.max_devices", 1000)
+    viper.SetDefault("devices.bulk_update.confirm_above", 50)
+    viper.SetDefault("devices.ingestion.queue_capacity", 10000)
+    viper.SetDefault("devices.ingestion.workers", 4)
+    viper.SetDefault("devices.ingestion.retry_after", "5s")
+    viper.SetDefault("devices.ingestion.max_batch_size", 1000)
+    viper.SetDefault("devices.credentials.certificate_lifetime", "8760h")
+    viper.SetDefault("devices.credentials.rotation_grace", "24h")
+    viper.SetDefault("devices.replay.batch_size", 500)
+    viper.SetDefault("devices.replay.rows_per_second", 1000)
+    viper.SetDefault("devices.baselines.enabled", true)
+    viper.SetDefault("devices.baselines.learn_interval", "24h")
+    viper.SetDefault("devices.baselines.lookback", "672h")
+    viper.SetDefault("devices.baselines.min_samples", 200)
+    viper.SetDefault("devices.baselines.min_hour_samples", 30)
+    viper.SetDefault("devices.baselines.z_threshold", 4)
+    viper.SetDefault("devices.baselines.critical_z_threshold", 6)
+    viper.SetDefault("devices.reconciliation.interval", "1h")
+    viper.SetDefault("devices.reconciliation.lookback", "168h")
+    viper.SetDefault("devices.reconciliation.silent_after", "72h")
+    viper.SetDefault("devices.reconciliation.auto_register.enabled", false)
+    viper.SetDefault("devices.reconciliation.auto_register.prefixes", []string{})
+    viper.SetDefault("billing.max_installments", 12)
+    viper.SetDefault("billing.installment_interval", "720h")
+    viper.SetDefault("billing.installment_reminder_lead", "72h")
+    viper.SetDefault("billing.comparison_min_cohort", 10)
+    viper.SetDefault("billing.estimation.methods", []string{"history", "neighbors"})
+    viper.SetDefault("billing.estimation.lookback", "2160h")
+    viper.SetDefault("billing.estimation.min_gap", "24h")
+    viper.SetDefault("billing.consumption.refresh_interval", "5m")
+    viper.SetDefault("billing.consumption.period", "15m")
+    viper.SetDefault("billing.consumption.settle", "10m")
+    viper.SetDefault("billing.consumption.max_gap", "6h")
+    viper.SetDefault("billing.consumption.batch_size", 10000)
+    viper.SetDefault("billing.consumption.flows", []ConsumptionFlowConfig{})
+    viper.SetDefault("tenancy.cache_ttl", "5m")
+    viper.SetDefault("tenancy.defaults.timezone", "Asia/Kolkata")
+    viper.SetDefault("residency.regions", []string{})
+    viper.SetDefault("residency.default_region", "")
+    viper.SetDefault("cache.sync_interval", "5s")
+    viper.SetDefault("startup.check_dependencies", true)
+    viper.SetDefault("startup.dependency_timeout", "3s")
+    viper.SetDefault("startup.wait_timeout", "2m")
+    viper.SetDefault("startup.initial_backoff", "1s")
+    viper.SetDefault("startup.max_backoff", "15s")
 }
\ No newline at end of file
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// Keys whose values are credentials. A key matches if its last
+// underscore-separated word is listed, e.g. "api_key" or "jwt_secret" but
+// not "refresh_token_expiry".
+var secretWords = map[string]bool{
+	"password": true,
+	"secret":   true,
+	"token":    true,
+	"key":      true,
+	"dsn":      true,
+}
+
+// Redacted returns the configuration as nested maps keyed like the config
+// file, with credentials replaced, for showing operators what a service
+// actually loaded. Durations are rendered as strings such as "30s".
+func (c *Config) Redacted() map[string]interface{} {
+	return dumpValue(reflect.ValueOf(*c), "").(map[string]interface{})
+}
+
+func dumpValue(value reflect.Value, key string) interface{} {
+	if isSecret(key) {
+		if value.IsZero() {
+			return ""
+		}
+		return redacted
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return dumpValue(value.Elem(), key)
+	case reflect.Struct:
+		out := make(map[string]interface{}, value.NumField())
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name := field.Tag.Get("mapstructure")
+			if name == "" || name == "-" {
+				continue
+			}
+			out[name] = dumpValue(value.Field(i), name)
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]interface{}, value.Len())
+		for _, mapKey := range value.MapKeys() {
+			name := mapKey.String()
+			out[name] = dumpValue(value.MapIndex(mapKey), name)
+		}
+		return out
+	case reflect.Slice:
+		if value.IsNil() {
+			return []interface{}{}
+		}
+		out := make([]interface{}, value.Len())
+		for i := range out {
+			out[i] = dumpValue(value.Index(i), "")
+		}
+		return out
+	case reflect.Int64:
+		if duration, ok := value.Interface().(time.Duration); ok {
+			return duration.String()
+		}
+		return value.Int()
+	default:
+		return value.Interface()
+	}
+}
+
+func isSecret(key string) bool {
+	words := strings.Split(strings.ToLower(key), "_")
+	return secretWords[words[len(words)-1]]
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/spf13/viper"
+)
+
+// Directories searched for config files, in order
+var configPaths = []string{"./configs", "."}
+
+// Placeholders like ${POSTGRES_HOST:localhost} are replaced by the
+// environment variable, or the default after the colon if it is unset
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?\}`)
+
+// readConfigFiles loads config.yaml and then merges config.{environment}.yaml
+// over it, so an environment file only needs the keys it changes. Either
+// file may be missing; a file that exists but can't be parsed is an error.
+func readConfigFiles() error {
+	base, err := findConfigFile("config.yaml")
+	if err != nil {
+		return err
+	}
+	if base != "" {
+		if err := mergeConfigFile(base); err != nil {
+			return err
+		}
+	}
+
+	// ENVIRONMENT takes precedence over the base file's environment
+	environment := viper.GetString("environment")
+	if environment == "" {
+		return nil
+	}
+
+	overlay, err := findConfigFile(fmt.Sprintf("config.%s.yaml", environment))
+	if err != nil || overlay == "" {
+		return err
+	}
+	return mergeConfigFile(overlay)
+}
+
+// findConfigFile returns the first match for name in configPaths, or "" if
+// there is none.
+func findConfigFile(name string) (string, error) {
+	for _, dir := range configPaths {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+func mergeConfigFile(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	expanded := placeholderPattern.ReplaceAllFunc(contents, func(match []byte) []byte {
+		parts := placeholderPattern.FindSubmatch(match)
+		if value, ok := os.LookupEnv(string(parts[1])); ok {
+			return []byte(value)
+		}
+		return parts[2]
+	})
+
+	if err := viper.MergeConfig(bytes.NewReader(expanded)); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	return nil
+}
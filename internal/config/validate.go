@@ -0,0 +1,379 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/timebucket"
+)
+
+const defaultJWTSecret = "default-secret-change-in-production"
+
+// maxThresholdWindow matches tenant.MaxThresholdWindow, which this package
+// can't import
+const maxThresholdWindow = 100
+
+var logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+// ssoRoles are the roles single sign-on may give new users. Super admins
+// are created by hand.
+var ssoRoles = map[string]bool{"citizen": true, "operator": true, "admin": true, "service": true}
+
+var estimationMethods = map[string]bool{"history": true, "neighbors": true}
+
+// ValidationError lists every problem found in a configuration, so a bad
+// deployment can be fixed in one pass rather than one restart per field.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) required(key, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.addf("%s is required", key)
+	}
+}
+
+func (v *validator) port(key string, value int) {
+	if value < 1 || value > 65535 {
+		v.addf("%s must be between 1 and 65535 (got %d)", key, value)
+	}
+}
+
+func (v *validator) positive(key string, value time.Duration) {
+	if value <= 0 {
+		v.addf("%s must be greater than 0 (got %s)", key, value)
+	}
+}
+
+// pair requires two settings to be set together or not at all.
+func (v *validator) pair(key, value, otherKey, otherValue string) {
+	if (value == "") != (otherValue == "") {
+		v.addf("%s and %s must be set together", key, otherKey)
+	}
+}
+
+func (v *validator) atLeast(key string, value, min int) {
+	if value < min {
+		v.addf("%s must be at least %d (got %d)", key, min, value)
+	}
+}
+
+func (v *validator) fraction(key string, value float64) {
+	if value < 0 || value > 1 {
+		v.addf("%s must be between 0 and 1 (got %g)", key, value)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+// Validate checks required fields and value ranges. It returns a
+// *ValidationError listing every problem, or nil.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.port("server.port", c.Server.Port)
+	v.port("monitoring.metrics_port", c.Monitoring.MetricsPort)
+	v.port("grpc.port", c.GRPC.Port)
+	if c.GRPC.Port == c.Monitoring.MetricsPort {
+		v.addf("grpc.port and monitoring.metrics_port must differ (both %d)", c.GRPC.Port)
+	}
+	v.positive("server.read_timeout", c.Server.ReadTimeout)
+	v.positive("server.write_timeout", c.Server.WriteTimeout)
+	v.positive("server.idle_timeout", c.Server.IdleTimeout)
+	v.positive("shutdown.drain_timeout", c.Shutdown.DrainTimeout)
+	v.positive("shutdown.flush_timeout", c.Shutdown.FlushTimeout)
+	v.positive("shutdown.close_timeout", c.Shutdown.CloseTimeout)
+	v.atLeast("pagination.default_limit", c.Pagination.DefaultLimit, 1)
+	v.atLeast("pagination.max_limit", c.Pagination.MaxLimit, 1)
+	if c.Pagination.DefaultLimit > c.Pagination.MaxLimit {
+		v.addf("pagination.default_limit must not exceed pagination.max_limit (%d > %d)",
+			c.Pagination.DefaultLimit, c.Pagination.MaxLimit)
+	}
+	if c.LoadShedding.Enabled {
+		v.fraction("load_shedding.threshold", c.LoadShedding.Threshold)
+		v.positive("load_shedding.retry_after", c.LoadShedding.RetryAfter)
+	}
+	if !logLevels[c.Monitoring.LogLevel] {
+		v.addf("monitoring.log_level must be one of debug, info, warn or error (got %q)", c.Monitoring.LogLevel)
+	}
+
+	v.required("database.postgres.host", c.Database.Postgres.Host)
+	v.port("database.postgres.port", c.Database.Postgres.Port)
+	v.required("database.postgres.user", c.Database.Postgres.User)
+	v.required("database.postgres.dbname", c.Database.Postgres.DBName)
+	v.required("database.redis.host", c.Database.Redis.Host)
+	v.port("database.redis.port", c.Database.Redis.Port)
+	if c.Database.Redis.DB < 0 {
+		v.addf("database.redis.db must not be negative (got %d)", c.Database.Redis.DB)
+	}
+
+	if len(c.Kafka.Brokers) == 0 {
+		v.addf("kafka.brokers must list at least one broker")
+	}
+	for _, broker := range c.Kafka.Brokers {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			v.addf("kafka.brokers entry %q must be host:port", broker)
+		}
+	}
+
+	if c.Kafka.AutoCreateTopics {
+		v.atLeast("kafka.topic_defaults.partitions", c.Kafka.TopicDefaults.Partitions, 1)
+		v.atLeast("kafka.topic_defaults.replication_factor", c.Kafka.TopicDefaults.ReplicationFactor, 1)
+		for name, topic := range c.Kafka.TopicOverrides {
+			if topic.Partitions < 0 || topic.ReplicationFactor < 0 {
+				v.addf("kafka.topic_overrides.%s partitions and replication_factor must not be negative", name)
+			}
+		}
+	}
+	v.atLeast("kafka.consumer.concurrency", c.Kafka.Consumer.Concurrency, 1)
+
+	v.required("jwt.secret", c.JWT.Secret)
+	if c.Environment == "production" && c.JWT.Secret == defaultJWTSecret {
+		v.addf("jwt.secret must be changed from the default in production")
+	}
+	v.positive("jwt.expires_in", c.JWT.ExpiresIn)
+	v.positive("auth.refresh_token_expiry", c.Auth.RefreshTokenExpiry)
+	v.positive("auth.lockout_duration", c.Auth.LockoutDuration)
+	if c.Auth.SessionOutageGrace < 0 {
+		v.addf("auth.session_outage_grace must not be negative (got %s)", c.Auth.SessionOutageGrace)
+	}
+	v.atLeast("auth.password_min_length", c.Auth.PasswordMinLength, 1)
+	v.atLeast("auth.max_login_attempts", c.Auth.MaxLoginAttempts, 1)
+	v.atLeast("auth.login_throttle.free_attempts", c.Auth.LoginThrottle.FreeAttempts, 0)
+	v.atLeast("auth.login_throttle.ip_free_attempts", c.Auth.LoginThrottle.IPFreeAttempts, 0)
+	v.atLeast("auth.login_throttle.captcha_after", c.Auth.LoginThrottle.CaptchaAfter, 0)
+	v.positive("auth.login_throttle.base_delay", c.Auth.LoginThrottle.BaseDelay)
+	v.positive("auth.login_throttle.window", c.Auth.LoginThrottle.Window)
+	if c.Auth.LoginThrottle.MaxDelay < c.Auth.LoginThrottle.BaseDelay {
+		v.addf("auth.login_throttle.max_delay must be at least auth.login_throttle.base_delay (got %s < %s)",
+			c.Auth.LoginThrottle.MaxDelay, c.Auth.LoginThrottle.BaseDelay)
+	}
+	v.pair("auth.login_throttle.captcha.verify_url", c.Auth.LoginThrottle.Captcha.VerifyURL,
+		"auth.login_throttle.captcha.secret", c.Auth.LoginThrottle.Captcha.Secret)
+	for name, provider := range c.Auth.SSO {
+		key := "auth.sso." + name
+		v.required(key+".issuer", provider.Issuer)
+		v.required(key+".client_id", provider.ClientID)
+		v.required(key+".client_secret", provider.ClientSecret)
+		v.required(key+".redirect_url", provider.RedirectURL)
+		v.required(key+".tenant_id", provider.TenantID)
+		if !ssoRoles[provider.DefaultRole] {
+			v.addf("%s.default_role must be citizen, operator, admin or service (got %q)", key, provider.DefaultRole)
+		}
+		v.positive(key+".timeout", provider.Timeout)
+	}
+
+	v.atLeast("devices.ingestion.queue_capacity", c.Devices.Ingestion.QueueCapacity, 1)
+	v.atLeast("devices.ingestion.workers", c.Devices.Ingestion.Workers, 1)
+	v.atLeast("devices.ingestion.max_batch_size", c.Devices.Ingestion.MaxBatchSize, 1)
+	v.pair("devices.credentials.ca_cert_file", c.Devices.Credentials.CACertFile,
+		"devices.credentials.ca_key_file", c.Devices.Credentials.CAKeyFile)
+	v.pair("devices.credentials.tls_cert_file", c.Devices.Credentials.TLSCertFile,
+		"devices.credentials.tls_key_file", c.Devices.Credentials.TLSKeyFile)
+	v.positive("devices.credentials.certificate_lifetime", c.Devices.Credentials.CertificateLifetime)
+	if c.Devices.Credentials.RotationGrace < 0 {
+		v.addf("devices.credentials.rotation_grace must not be negative (got %s)", c.Devices.Credentials.RotationGrace)
+	}
+	v.atLeast("devices.replay.batch_size", c.Devices.Replay.BatchSize, 1)
+	if baselines := c.Devices.Baselines; baselines.Enabled {
+		v.positive("devices.baselines.learn_interval", baselines.LearnInterval)
+		v.positive("devices.baselines.lookback", baselines.Lookback)
+		v.atLeast("devices.baselines.min_samples", baselines.MinSamples, 2)
+		v.atLeast("devices.baselines.min_hour_samples", baselines.MinHourSamples, 2)
+		if baselines.ZThreshold <= 0 {
+			v.addf("devices.baselines.z_threshold must be greater than 0 (got %g)", baselines.ZThreshold)
+		}
+		if baselines.CriticalZThreshold < baselines.ZThreshold {
+			v.addf("devices.baselines.critical_z_threshold must be at least z_threshold (got %g, z_threshold %g)",
+				baselines.CriticalZThreshold, baselines.ZThreshold)
+		}
+	}
+	v.positive("devices.reconciliation.interval", c.Devices.Reconciliation.Interval)
+	v.positive("devices.reconciliation.lookback", c.Devices.Reconciliation.Lookback)
+	v.positive("devices.reconciliation.silent_after", c.Devices.Reconciliation.SilentAfter)
+	if c.Devices.Reconciliation.SilentAfter > c.Devices.Reconciliation.Lookback {
+		v.addf("devices.reconciliation.silent_after must not be longer than lookback (got %s, lookback %s)",
+			c.Devices.Reconciliation.SilentAfter, c.Devices.Reconciliation.Lookback)
+	}
+	if auto := c.Devices.Reconciliation.AutoRegister; auto.Enabled && len(auto.Prefixes) == 0 {
+		v.addf("devices.reconciliation.auto_register.prefixes must list at least one prefix when enabled")
+	}
+	v.atLeast("devices.bulk_update.max_devices", c.Devices.BulkUpdate.MaxDevices, 1)
+	v.atLeast("devices.bulk_update.confirm_above", c.Devices.BulkUpdate.ConfirmAbove, 0)
+
+	for _, method := range c.Billing.Estimation.Methods {
+		if !estimationMethods[method] {
+			v.addf("billing.estimation.methods must list only history and neighbors (got %q)", method)
+		}
+	}
+	v.positive("billing.estimation.lookback", c.Billing.Estimation.Lookback)
+	v.positive("billing.estimation.min_gap", c.Billing.Estimation.MinGap)
+	consumption := c.Billing.Consumption
+	v.positive("billing.consumption.refresh_interval", consumption.RefreshInterval)
+	v.positive("billing.consumption.period", consumption.Period)
+	if consumption.Period > 0 && time.Hour%consumption.Period != 0 {
+		v.addf("billing.consumption.period must divide an hour evenly (got %s)", consumption.Period)
+	}
+	v.positive("billing.consumption.settle", consumption.Settle)
+	v.positive("billing.consumption.max_gap", consumption.MaxGap)
+	v.atLeast("billing.consumption.batch_size", consumption.BatchSize, 1)
+	for i, flow := range consumption.Flows {
+		key := fmt.Sprintf("billing.consumption.flows[%d]", i)
+		v.required(key+".device_type", flow.DeviceType)
+		v.required(key+".rate_metric", flow.RateMetric)
+		v.required(key+".metric", flow.Metric)
+		v.positive(key+".per", flow.Per)
+	}
+
+	v.atLeast("notifications.retry.max_attempts", c.Notifications.Retry.MaxAttempts, 1)
+	v.fraction("notifications.retry.jitter", c.Notifications.Retry.Jitter)
+	v.positive("notifications.health.interval", c.Notifications.Health.Interval)
+	v.positive("notifications.health.window", c.Notifications.Health.Window)
+	v.fraction("notifications.health.failure_threshold", c.Notifications.Health.FailureThreshold)
+	v.positive("notifications.hold.max_age", c.Notifications.Hold.MaxAge)
+	v.atLeast("notifications.bulk_preferences.confirm_above", c.Notifications.BulkPreferences.ConfirmAbove, 0)
+
+	v.positive("startup.dependency_timeout", c.Startup.DependencyTimeout)
+	if c.Startup.WaitTimeout < 0 {
+		v.addf("startup.wait_timeout must not be negative (got %s)", c.Startup.WaitTimeout)
+	}
+	v.positive("startup.initial_backoff", c.Startup.InitialBackoff)
+	if c.Startup.MaxBackoff < c.Startup.InitialBackoff {
+		v.addf("startup.max_backoff must be at least startup.initial_backoff (got %s < %s)",
+			c.Startup.MaxBackoff, c.Startup.InitialBackoff)
+	}
+
+	if c.Security.MaxBodyBytes <= 0 {
+		v.addf("security.max_body_bytes must be greater than 0 (got %d)", c.Security.MaxBodyBytes)
+	}
+	for route, limit := range c.Security.BodyLimits {
+		if limit <= 0 {
+			v.addf("security.body_limits[%q] must be greater than 0 (got %d)", route, limit)
+		}
+	}
+	v.atLeast("security.rate_limit_per_min", c.Security.RateLimitPerMin, 1)
+
+	if logging := c.Debug.BodyLogging; logging.Enabled {
+		if c.Environment == "production" && !logging.AllowInProduction {
+			v.addf("debug.body_logging.enabled requires debug.body_logging.allow_in_production in production")
+		}
+		if len(logging.Routes) == 0 {
+			v.addf("debug.body_logging.routes must list at least one route when enabled")
+		}
+		v.atLeast("debug.body_logging.max_bytes", logging.MaxBytes, 1)
+	}
+
+	if _, err := timebucket.LoadLocation(c.Tenancy.Defaults.Timezone); err != nil {
+		v.addf("tenancy.defaults.timezone: %v", err)
+	}
+	for deviceType, metrics := range c.Tenancy.Defaults.Thresholds {
+		for metric, threshold := range metrics {
+			key := fmt.Sprintf("tenancy.defaults.thresholds.%s.%s", deviceType, metric)
+			if threshold.Window < 0 || threshold.Window > maxThresholdWindow {
+				v.addf("%s.window must be between 1 and %d (got %d)", key, maxThresholdWindow, threshold.Window)
+			}
+			if threshold.Breaches < 0 || threshold.Breaches > threshold.Window {
+				v.addf("%s.breaches must be between 1 and window (got %d)", key, threshold.Breaches)
+			}
+		}
+	}
+	routing := c.Tenancy.Defaults.AlertRouting
+	for name, team := range routing.Teams {
+		if len(team.Roles) == 0 && len(team.Users) == 0 {
+			v.addf("tenancy.defaults.alert_routing.teams.%s needs roles or users", name)
+		}
+	}
+	for i, route := range routing.Routes {
+		if _, ok := routing.Teams[route.Team]; !ok {
+			v.addf("tenancy.defaults.alert_routing.routes[%d].team must name a team (got %q)", i, route.Team)
+		}
+	}
+	if _, ok := routing.Teams[routing.DefaultTeam]; routing.DefaultTeam != "" && !ok {
+		v.addf("tenancy.defaults.alert_routing.default_team must name a team (got %q)", routing.DefaultTeam)
+	}
+	if residency := c.Residency; len(residency.Regions) > 0 {
+		known := make(map[string]bool, len(residency.Regions))
+		for i, region := range residency.Regions {
+			if region == "" || region == "*" || known[region] {
+				v.addf("residency.regions[%d] must be a unique region code (got %q)", i, region)
+			}
+			known[region] = true
+		}
+		if !known[residency.DefaultRegion] {
+			v.addf("residency.default_region must be one of residency.regions (got %q)", residency.DefaultRegion)
+		}
+	} else if residency.DefaultRegion != "" {
+		v.addf("residency.default_region requires residency.regions")
+	}
+	if c.Cache.SyncInterval < 0 {
+		v.addf("cache.sync_interval must not be negative (got %s)", c.Cache.SyncInterval)
+	}
+	for name, flag := range c.Features {
+		if flag.Percentage < 0 || flag.Percentage > 100 {
+			v.addf("features.%s.percentage must be between 0 and 100 (got %d)", name, flag.Percentage)
+		}
+	}
+
+	return v.err()
+}
+
+// CheckDependencies dials Postgres, Redis and every Kafka broker in
+// parallel and returns a *ValidationError naming each one that could not be
+// reached within timeout. It only proves the port is open, not that
+// credentials work.
+func (c *Config) CheckDependencies(timeout time.Duration) error {
+	type dependency struct{ name, address string }
+	dependencies := []dependency{
+		{"database.postgres", net.JoinHostPort(c.Database.Postgres.Host, strconv.Itoa(c.Database.Postgres.Port))},
+		{"database.redis", net.JoinHostPort(c.Database.Redis.Host, strconv.Itoa(c.Database.Redis.Port))},
+	}
+	for _, broker := range c.Kafka.Brokers {
+		dependencies = append(dependencies, dependency{"kafka.brokers", broker})
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+		v  validator
+	)
+	for _, dep := range dependencies {
+		wg.Add(1)
+		go func(name, address string) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				mu.Lock()
+				v.addf("%s at %s is unreachable: %v", name, address, err)
+				mu.Unlock()
+				return
+			}
+			conn.Close()
+		}(dep.name, dep.address)
+	}
+	wg.Wait()
+
+	sort.Strings(v.problems)
+	return v.err()
+}
@@ -0,0 +1,130 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+	"github.com/bhanukaranwal/urbanzen/pkg/events"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// recordAlert stores an alert so it can be acknowledged and resolved, and
+// notifies the team the tenant routes it to. A device keeps at most one
+// open alert of each type; repeats while it is open (an offline device on
+// every health check) are not stored or notified again.
+func (s *Service) recordAlert(tenantID, alertType, severity, title, message, deviceID string, metadata interface{}) {
+	if tenantID == "" {
+		tenantID = "default"
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		encoded = []byte("{}")
+	}
+
+	var alertID string
+	err = s.db.QueryRow(`
+		INSERT INTO alerts (tenant_id, type, severity, title, message, device_id, metadata)
+		SELECT $1, $2, $3, $4, $5, $6, $7
+		WHERE NOT EXISTS (
+			SELECT 1 FROM alerts WHERE device_id = $6 AND type = $2 AND NOT resolved
+		)
+		RETURNING id
+	`, tenantID, alertType, severity, title, message, deviceID, encoded).Scan(&alertID)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to record alert", "error", err, "device_id", deviceID, "type", alertType)
+		return
+	}
+
+	s.routeAlert(tenantID, alertID, alertType, severity, title, message, deviceID)
+}
+
+// routeAlert notifies each member of the team the tenant's alert routing
+// picks for the alert. Critical alerts go out as high priority.
+func (s *Service) routeAlert(tenantID, alertID, alertType, severity, title, message, deviceID string) {
+	ctx := context.Background()
+
+	tenantConfig, err := s.tenants.Resolve(ctx, tenantID)
+	if err != nil {
+		s.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", tenantID)
+		return
+	}
+
+	alert := tenant.Alert{Type: alertType, Severity: severity}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT type, COALESCE(ward, ''), COALESCE(zone, '') FROM devices WHERE id = $1
+	`, deviceID).Scan(&alert.DeviceType, &alert.Ward, &alert.Zone)
+	if err != nil && err != sql.ErrNoRows {
+		s.logger.Error("Failed to load device for alert routing", "error", err, "device_id", deviceID)
+		return
+	}
+
+	teamName, ok := tenantConfig.AlertRouting.Route(alert)
+	if !ok {
+		return
+	}
+	team := tenantConfig.AlertRouting.Teams[teamName]
+
+	recipients, err := s.teamMembers(ctx, tenantID, team)
+	if err != nil {
+		s.logger.Error("Failed to load alert team", "error", err, "tenant_id", tenantID, "team", teamName)
+		return
+	}
+	if len(recipients) == 0 {
+		s.logger.Warn("Alert team has no active members", "tenant_id", tenantID, "team", teamName, "alert_id", alertID)
+		return
+	}
+
+	priority := "normal"
+	if severity == "critical" {
+		priority = "high"
+	}
+	for _, userID := range recipients {
+		err := events.Publish(ctx, s.bus, events.Notification{
+			ID:       uuid.New().String(),
+			TenantID: tenantID,
+			UserID:   userID,
+			Type:     "alert",
+			Title:    title,
+			Message:  message,
+			Priority: priority,
+			DedupKey: "alert:" + alertID,
+			Metadata: map[string]interface{}{
+				"alert_id":   alertID,
+				"alert_type": alertType,
+				"severity":   severity,
+				"device_id":  deviceID,
+				"team":       teamName,
+			},
+		})
+		metrics.NotificationPublishes.WithLabelValues("device", metrics.Result(err)).Inc()
+	}
+}
+
+// teamMembers returns the IDs of the tenant's active users in the team.
+func (s *Service) teamMembers(ctx context.Context, tenantID string, team tenant.AlertTeam) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id::text FROM users
+		WHERE tenant_id = $1 AND is_active AND (role = ANY($2) OR id::text = ANY($3))
+	`, tenantID, pq.Array(team.Roles), pq.Array(team.Users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		members = append(members, userID)
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,115 @@
+package device
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+const (
+	defaultAnomalyWindow = 15 * time.Minute
+	maxAnomalyWindow     = 24 * time.Hour
+	maxAnomalyReadings   = 500
+)
+
+type anomalyReading struct {
+	Timestamp  time.Time              `json:"timestamp"`
+	Metrics    map[string]interface{} `json:"metrics"`
+	Triggering bool                   `json:"triggering,omitempty"`
+}
+
+// GetAnomalyTelemetry returns the device's raw telemetry within window
+// (default 15m) either side of the reading that raised an anomaly, with
+// that reading marked. Types sampled without store_raw keep no raw
+// telemetry, so there is none to return for them. Anomalies on devices in
+// regions the caller may not reach are refused.
+func (s *Service) GetAnomalyTelemetry(c *gin.Context) {
+	anomalyID := c.Param("id")
+	if _, err := uuid.Parse(anomalyID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Anomaly not found"})
+		return
+	}
+
+	window := defaultAnomalyWindow
+	if raw := c.Query("window"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 || parsed > maxAnomalyWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a duration up to 24h, such as 15m"})
+			return
+		}
+		window = parsed
+	}
+
+	ctx := c.Request.Context()
+	var deviceID, metric, region string
+	var at time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT an.device_id, COALESCE(an.metric, ''), an.timestamp, COALESCE(d.region, '')
+		FROM anomalies an
+		JOIN devices d ON d.id = an.device_id
+		WHERE an.id = $1 AND d.tenant_id = $2
+	`, anomalyID, middleware.TenantID(c)).Scan(&deviceID, &metric, &at, &region)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Anomaly not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load anomaly", "error", err, "anomaly_id", anomalyID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomaly telemetry"})
+		return
+	}
+	if !s.regions.Permits(c, region) {
+		s.regions.Deny(c, "device", deviceID, region)
+		return
+	}
+
+	from, to := at.Add(-window), at.Add(window)
+	rows, err := s.tsdb.QueryContext(ctx, `
+		SELECT timestamp, metrics
+		FROM device_telemetry
+		WHERE device_id = $1 AND timestamp >= $2 AND timestamp <= $3
+		ORDER BY timestamp
+		LIMIT $4
+	`, deviceID, from, to, maxAnomalyReadings)
+	if err != nil {
+		s.logger.Error("Failed to load anomaly telemetry", "error", err, "anomaly_id", anomalyID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomaly telemetry"})
+		return
+	}
+	defer rows.Close()
+
+	readings := []anomalyReading{}
+	for rows.Next() {
+		var reading anomalyReading
+		var metrics []byte
+		if err := rows.Scan(&reading.Timestamp, &metrics); err != nil {
+			s.logger.Error("Failed to scan telemetry", "error", err, "anomaly_id", anomalyID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomaly telemetry"})
+			return
+		}
+		json.Unmarshal(metrics, &reading.Metrics)
+		reading.Triggering = reading.Timestamp.Equal(at)
+		readings = append(readings, reading)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("Failed to load anomaly telemetry", "error", err, "anomaly_id", anomalyID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomaly telemetry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"anomaly_id": anomalyID,
+		"device_id":  deviceID,
+		"metric":     metric,
+		"timestamp":  at,
+		"from":       from,
+		"to":         to,
+		"readings":   readings,
+		"truncated":  len(readings) == maxAnomalyReadings,
+	})
+}
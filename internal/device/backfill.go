@@ -0,0 +1,443 @@
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/telemetryschema"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+const (
+	BackfillRunning   = "running"
+	BackfillCompleted = "completed"
+	BackfillFailed    = "failed"
+
+	backfillBatchSize  = 1000
+	backfillReadBuffer = 64 << 10
+	backfillListLimit  = 20
+
+	// Only the first rejected lines are kept on the backfill; the rest are
+	// counted
+	maxBackfillErrors = 100
+
+	// A running backfill not updated for this long lost its upload, and no
+	// longer blocks the tenant from starting another
+	backfillStaleAfter = 10 * time.Minute
+)
+
+// Backfill is an upload of historical telemetry, such as meter data
+// migrated from a legacy system. Its counts are saved after every batch,
+// so it can be watched while the upload runs.
+type Backfill struct {
+	ID          string          `json:"id"`
+	TenantID    string          `json:"tenant_id"`
+	Status      string          `json:"status"`
+	LinesRead   int64           `json:"lines_read"`
+	Inserted    int64           `json:"inserted"`
+	Duplicates  int64           `json:"duplicates"`
+	Invalid     int64           `json:"invalid"`
+	Errors      []BackfillError `json:"errors"`
+	Error       string          `json:"error,omitempty"`
+	CreatedBy   string          `json:"created_by"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// BackfillError is a line of the upload that was rejected.
+type BackfillError struct {
+	Line     int64  `json:"line"`
+	DeviceID string `json:"device_id,omitempty"`
+	Error    string `json:"error"`
+}
+
+func (b *Backfill) reject(deviceID, reason string) {
+	b.Invalid++
+	if len(b.Errors) < maxBackfillErrors {
+		b.Errors = append(b.Errors, BackfillError{Line: b.LinesRead, DeviceID: deviceID, Error: reason})
+	}
+}
+
+const backfillColumns = `
+	id, tenant_id, status, lines_read, inserted, duplicates, invalid, errors, COALESCE(error, ''),
+	created_by::text, created_at, updated_at, completed_at
+`
+
+func scanBackfill(row rowScanner) (*Backfill, error) {
+	var backfill Backfill
+	var errorsJSON []byte
+	err := row.Scan(
+		&backfill.ID,
+		&backfill.TenantID,
+		&backfill.Status,
+		&backfill.LinesRead,
+		&backfill.Inserted,
+		&backfill.Duplicates,
+		&backfill.Invalid,
+		&errorsJSON,
+		&backfill.Error,
+		&backfill.CreatedBy,
+		&backfill.CreatedAt,
+		&backfill.UpdatedAt,
+		&backfill.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(errorsJSON, &backfill.Errors); err != nil {
+		return nil, err
+	}
+	return &backfill, nil
+}
+
+// StartBackfill stores historical telemetry streamed in the request body
+// as one JSON reading per line, keeping the readings' own timestamps.
+// Readings are validated like live ones but only stored: they raise no
+// anomalies or subscription alerts and don't change device status.
+// Readings already stored, by live ingestion or an earlier backfill, are
+// skipped, so a failed upload can simply be sent again.
+func (s *Service) StartBackfill(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE telemetry_backfills SET status = $1, error = $2, completed_at = NOW()
+		WHERE tenant_id = $3 AND status = $4 AND updated_at < $5
+	`, BackfillFailed, "Upload interrupted", tenantID, BackfillRunning, time.Now().Add(-backfillStaleAfter))
+	if err != nil {
+		s.logger.Warn("Failed to expire stale backfills", "error", err, "tenant_id", tenantID)
+	}
+
+	backfill, err := scanBackfill(s.db.QueryRowContext(ctx, `
+		INSERT INTO telemetry_backfills (tenant_id, status, created_by)
+		VALUES ($1, $2, $3)
+		RETURNING `+backfillColumns,
+		tenantID, BackfillRunning, c.GetString("user_id"),
+	))
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A backfill is already in progress for this tenant"})
+			return
+		}
+		s.logger.Error("Failed to create backfill", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start backfill"})
+		return
+	}
+
+	s.logger.Info("Starting telemetry backfill", "backfill_id", backfill.ID, "tenant_id", tenantID,
+		"user_id", backfill.CreatedBy)
+
+	reader := bufio.NewReaderSize(c.Request.Body, backfillReadBuffer)
+	var batch []*models.DeviceData
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			// The last line may be cut short; complete lines before it are
+			// still stored
+			if err := s.storeBackfillBatch(backfill, batch); err != nil {
+				s.failBackfill(c, backfill, err)
+				return
+			}
+			status, message := http.StatusBadRequest, "Upload interrupted"
+			if middleware.BodyTooLarge(readErr) {
+				status, message = http.StatusRequestEntityTooLarge, "Upload exceeds the size limit, split it and send the rest separately"
+			}
+			backfill.Status, backfill.Error = BackfillFailed, message
+			s.saveBackfill(backfill)
+			s.logger.Warn("Telemetry backfill upload failed", "error", readErr, "backfill_id", backfill.ID,
+				"lines", backfill.LinesRead)
+			c.JSON(status, gin.H{"error": message, "backfill": backfill})
+			return
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			backfill.LinesRead++
+
+			var data models.DeviceData
+			if err := json.Unmarshal(line, &data); err != nil {
+				backfill.reject("", "line is not a valid JSON reading")
+			} else if reason, err := s.prepareBackfillReading(&data, tenantID, backfill.ID); err != nil {
+				s.failBackfill(c, backfill, err)
+				return
+			} else if reason != "" {
+				backfill.reject(data.DeviceID, reason)
+			} else {
+				batch = append(batch, &data)
+			}
+		}
+
+		if len(batch) >= backfillBatchSize || readErr == io.EOF {
+			if err := s.storeBackfillBatch(backfill, batch); err != nil {
+				s.failBackfill(c, backfill, err)
+				return
+			}
+			batch = nil
+
+			if readErr == io.EOF {
+				break
+			}
+			s.saveBackfill(backfill)
+		}
+	}
+
+	backfill.Status = BackfillCompleted
+	s.saveBackfill(backfill)
+	s.logger.Info("Telemetry backfill completed", "backfill_id", backfill.ID, "lines", backfill.LinesRead,
+		"inserted", backfill.Inserted, "duplicates", backfill.Duplicates, "invalid", backfill.Invalid)
+
+	c.JSON(http.StatusOK, gin.H{"backfill": backfill})
+}
+
+// ListBackfills returns the tenant's most recent backfills, newest first,
+// so a running upload can be found and watched.
+func (s *Service) ListBackfills(c *gin.Context) {
+	rows, err := s.db.QueryContext(c.Request.Context(), `
+		SELECT `+backfillColumns+`
+		FROM telemetry_backfills
+		WHERE tenant_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, middleware.TenantID(c), backfillListLimit)
+	if err != nil {
+		s.logger.Error("Failed to list backfills", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve backfills"})
+		return
+	}
+	defer rows.Close()
+
+	backfills := []*Backfill{}
+	for rows.Next() {
+		backfill, err := scanBackfill(rows)
+		if err != nil {
+			s.logger.Error("Failed to scan backfill", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve backfills"})
+			return
+		}
+		backfills = append(backfills, backfill)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"backfills": backfills})
+}
+
+// GetBackfill reports a backfill's progress, or its outcome once done.
+func (s *Service) GetBackfill(c *gin.Context) {
+	backfill, err := scanBackfill(s.db.QueryRowContext(c.Request.Context(),
+		`SELECT `+backfillColumns+` FROM telemetry_backfills WHERE id::text = $1 AND tenant_id = $2`,
+		c.Param("id"), middleware.TenantID(c),
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backfill not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load backfill", "error", err, "backfill_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve backfill"})
+		return
+	}
+
+	c.JSON(http.StatusOK, backfill)
+}
+
+// prepareBackfillReading checks a reading the way live ingestion does and
+// converts it to canonical units. It returns why the reading was rejected,
+// or an error if the check itself failed.
+func (s *Service) prepareBackfillReading(data *models.DeviceData, tenantID, backfillID string) (string, error) {
+	if err := s.validateDeviceData(data); err != nil {
+		return err.Error(), nil
+	}
+	if data.Timestamp.After(time.Now()) {
+		return "timestamp is in the future", nil
+	}
+
+	device, err := s.resolveDevice(data.DeviceID)
+	if err == sql.ErrNoRows || (err == nil && device.tenantID != tenantID) {
+		return "device is not registered in this tenant", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	data.TenantID = device.tenantID
+	data.DeviceType = device.deviceType
+
+	// Stored timestamps have microsecond precision, and duplicates are
+	// matched against them
+	data.Timestamp = data.Timestamp.Truncate(time.Microsecond)
+
+	if err := s.normalizeUnits(data); err != nil {
+		return err.Error(), nil
+	}
+	if err := s.checkTelemetrySchema(data); err != nil {
+		if _, ok := err.(*telemetryschema.ReadingError); ok {
+			return err.Error(), nil
+		}
+		return "", err
+	}
+
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	data.Metadata["backfill_id"] = backfillID
+	return "", nil
+}
+
+type readingKey struct {
+	deviceID string
+	micros   int64
+}
+
+func keyOf(data *models.DeviceData) readingKey {
+	return readingKey{deviceID: data.DeviceID, micros: data.Timestamp.UnixMicro()}
+}
+
+// storeBackfillBatch stores the readings not stored before, raw and
+// aggregated as their type's sampling policy says. Each new reading is
+// recorded in backfilled_readings before it is stored; if storing fails,
+// the records of readings not yet stored are removed so a retry stores
+// them.
+func (s *Service) storeBackfillBatch(backfill *Backfill, batch []*models.DeviceData) error {
+	seen := make(map[readingKey]bool, len(batch))
+	var unique []*models.DeviceData
+	for _, data := range batch {
+		if seen[keyOf(data)] {
+			backfill.Duplicates++
+			continue
+		}
+		seen[keyOf(data)] = true
+		unique = append(unique, data)
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	claimed, err := s.claimBackfilled(unique)
+	if err != nil {
+		return err
+	}
+
+	samples := newSampler()
+	for i, data := range unique {
+		if !claimed[keyOf(data)] {
+			backfill.Duplicates++
+			continue
+		}
+
+		deviceType, err := s.deviceType(data.DeviceType)
+		if err == nil && (deviceType.Sampling == nil || deviceType.Sampling.StoreRaw) {
+			err = s.storeDeviceData(data)
+		}
+		if err != nil {
+			s.releaseBackfilled(unique[i:], claimed)
+			s.writeSamples(samples.closed(time.Time{}))
+			return err
+		}
+
+		if policy := deviceType.Sampling; policy != nil {
+			samples.add(data, policy.IntervalSeconds, s.tenantLocation(data.TenantID))
+		}
+		backfill.Inserted++
+	}
+
+	if failed := s.writeSamples(samples.closed(time.Time{})); failed > 0 {
+		return fmt.Errorf("failed to store %d telemetry aggregates", failed)
+	}
+	return nil
+}
+
+// claimBackfilled records the readings in backfilled_readings and returns
+// those that weren't already there or in raw telemetry.
+func (s *Service) claimBackfilled(readings []*models.DeviceData) (map[readingKey]bool, error) {
+	deviceIDs, timestamps := readingArrays(readings)
+	rows, err := s.tsdb.Query(`
+		INSERT INTO backfilled_readings (device_id, timestamp)
+		SELECT r.device_id, r.timestamp
+		FROM unnest($1::text[], $2::timestamptz[]) AS r (device_id, timestamp)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM device_telemetry t WHERE t.device_id = r.device_id AND t.timestamp = r.timestamp
+		)
+		ON CONFLICT DO NOTHING
+		RETURNING device_id, timestamp
+	`, pq.Array(deviceIDs), pq.Array(timestamps))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := make(map[readingKey]bool, len(readings))
+	for rows.Next() {
+		var deviceID string
+		var at time.Time
+		if err := rows.Scan(&deviceID, &at); err != nil {
+			return nil, err
+		}
+		claimed[readingKey{deviceID: deviceID, micros: at.UnixMicro()}] = true
+	}
+	return claimed, rows.Err()
+}
+
+func (s *Service) releaseBackfilled(readings []*models.DeviceData, claimed map[readingKey]bool) {
+	var unstored []*models.DeviceData
+	for _, data := range readings {
+		if claimed[keyOf(data)] {
+			unstored = append(unstored, data)
+		}
+	}
+
+	deviceIDs, timestamps := readingArrays(unstored)
+	_, err := s.tsdb.Exec(`
+		DELETE FROM backfilled_readings b
+		USING unnest($1::text[], $2::timestamptz[]) AS r (device_id, timestamp)
+		WHERE b.device_id = r.device_id AND b.timestamp = r.timestamp
+	`, pq.Array(deviceIDs), pq.Array(timestamps))
+	if err != nil {
+		// Sending these readings again will skip them as duplicates
+		s.logger.Error("Failed to release backfilled readings", "error", err, "readings", len(unstored))
+	}
+}
+
+func readingArrays(readings []*models.DeviceData) ([]string, []string) {
+	deviceIDs := make([]string, len(readings))
+	timestamps := make([]string, len(readings))
+	for i, data := range readings {
+		deviceIDs[i] = data.DeviceID
+		timestamps[i] = data.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return deviceIDs, timestamps
+}
+
+// saveBackfill writes the backfill's counts and status. It doesn't use the
+// request's context, so the outcome of an upload the client abandoned is
+// still recorded.
+func (s *Service) saveBackfill(backfill *Backfill) {
+	errorsJSON, _ := json.Marshal(backfill.Errors)
+	_, err := s.db.Exec(`
+		UPDATE telemetry_backfills
+		SET status = $2, lines_read = $3, inserted = $4, duplicates = $5, invalid = $6, errors = $7,
+			error = NULLIF($8, ''), updated_at = NOW(),
+			completed_at = CASE WHEN $2 <> 'running' THEN NOW() END
+		WHERE id = $1
+	`, backfill.ID, backfill.Status, backfill.LinesRead, backfill.Inserted, backfill.Duplicates,
+		backfill.Invalid, errorsJSON, backfill.Error)
+	if err != nil {
+		s.logger.Error("Failed to save backfill progress", "error", err, "backfill_id", backfill.ID)
+	}
+}
+
+func (s *Service) failBackfill(c *gin.Context, backfill *Backfill, err error) {
+	s.logger.Error("Telemetry backfill failed", "error", err, "backfill_id", backfill.ID,
+		"lines", backfill.LinesRead)
+
+	backfill.Status, backfill.Error = BackfillFailed, "Failed to store readings, send the upload again to continue"
+	s.saveBackfill(backfill)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": backfill.Error, "backfill": backfill})
+}
@@ -0,0 +1,89 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/baseline"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+const baselinePollInterval = time.Minute
+
+// learnBaselines relearns due devices' baselines, one device at a time, for
+// as long as any are due.
+func (s *Service) learnBaselines(ctx context.Context) {
+	ticker := time.NewTicker(baselinePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				learned, err := s.baselines.LearnNext(ctx)
+				if err != nil {
+					s.logger.Error("Failed to learn device baselines", "error", err)
+					break
+				}
+				if !learned {
+					break
+				}
+			}
+		}
+	}
+}
+
+// checkBaselines raises an anomaly for the metric whose reading strays
+// furthest from the device's baseline, if that is past the z-score
+// threshold. Metrics with too little history aren't judged.
+func (s *Service) checkBaselines(data *models.DeviceData) {
+	baselines, err := s.baselines.ForDevice(context.Background(), data.DeviceID)
+	if err != nil {
+		s.logger.Error("Failed to load device baselines", "error", err, "device_id", data.DeviceID)
+		return
+	}
+	if len(baselines) == 0 {
+		return
+	}
+
+	settings := s.baselines.Settings()
+	var worst *baseline.Baseline
+	var worstZ, worstValue float64
+	for metric, value := range data.Metrics {
+		numeric, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		learned, exists := baselines[metric]
+		if !exists {
+			continue
+		}
+		z, ok := learned.ZScore(numeric, data.Timestamp, settings)
+		if ok && math.Abs(z) > math.Abs(worstZ) {
+			worst, worstZ, worstValue = learned, z, numeric
+		}
+	}
+	if worst == nil || math.Abs(worstZ) < settings.ZThreshold {
+		return
+	}
+
+	severity := "warning"
+	if math.Abs(worstZ) >= settings.CriticalZThreshold {
+		severity = "critical"
+	}
+
+	s.handleAnomaly(&models.Anomaly{
+		DeviceID: data.DeviceID,
+		Type:     baseline.AnomalyType,
+		Severity: severity,
+		Description: fmt.Sprintf("%s reading %g is %.1f standard deviations from its baseline",
+			worst.Metric, worstValue, worstZ),
+		Timestamp: data.Timestamp,
+		Metric:    worst.Metric,
+		Value:     worstValue,
+	})
+}
@@ -0,0 +1,52 @@
+package device
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+// checkCommand validates a command against the capabilities of the device
+// type it is for. It returns a *devicetype.CommandError for a command the
+// type doesn't accept.
+func (s *Service) checkCommand(deviceType, command string, parameters map[string]interface{}) error {
+	definition, err := s.deviceType(deviceType)
+	if err != nil {
+		return err
+	}
+	return definition.CheckCommand(command, parameters)
+}
+
+// checkScheduleCommand validates a schedule's command when its target
+// fixes the device type. Schedules targeting a ward or zone may reach
+// several types, so their devices are checked at each run instead.
+func (s *Service) checkScheduleCommand(ctx context.Context, schedule *models.CommandSchedule) error {
+	deviceType := schedule.DeviceType
+	if schedule.DeviceID != "" {
+		device, err := s.resolveDevice(schedule.DeviceID)
+		if err != nil {
+			return err
+		}
+		deviceType = device.deviceType
+	}
+	if deviceType == "" {
+		return nil
+	}
+	return s.checkCommand(deviceType, schedule.Command, schedule.Parameters)
+}
+
+// invalidCommand writes a 400 listing the violations if err is a
+// *devicetype.CommandError, and reports whether it was.
+func invalidCommand(c *gin.Context, err error) bool {
+	commandErr, ok := err.(*devicetype.CommandError)
+	if ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid command",
+			"violations": commandErr.Violations,
+		})
+	}
+	return ok
+}
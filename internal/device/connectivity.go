@@ -0,0 +1,35 @@
+package device
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/devicestatus"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+// publishConnectivityChange emits a device.connectivity_changed event for
+// integrators. It is called only when the status cache reports an actual
+// transition, so a device that stays offline across health checks doesn't
+// repeat it.
+func (s *Service) publishConnectivityChange(tenantID, deviceID string, change *devicestatus.ConnectivityChange, lastSeen time.Time) {
+	event := models.ConnectivityChangedEvent{
+		Type:      models.EventConnectivityChanged,
+		DeviceID:  deviceID,
+		TenantID:  tenantID,
+		OldStatus: change.From,
+		NewStatus: change.To,
+		LastSeen:  lastSeen,
+		Timestamp: time.Now().UTC(),
+	}
+	message, _ := json.Marshal(event)
+
+	topic := s.config.Kafka.Topics.DeviceEvents
+	if topic == "" {
+		topic = "device-events"
+	}
+	if err := s.producer.ProduceMessage(topic, deviceID, message); err != nil {
+		s.logger.Error("Failed to publish connectivity change", "error", err, "device_id", deviceID,
+			"from", change.From, "to", change.To)
+	}
+}
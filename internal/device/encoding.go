@@ -0,0 +1,131 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/rpc/telemetryv1"
+)
+
+// ContentTypeProtobuf marks a telemetryv1.TelemetryBatch request body.
+const ContentTypeProtobuf = "application/x-protobuf"
+
+var errUnsupportedContentType = errors.New("unsupported content type")
+
+// DecodeBatch decodes a batch of readings sent with contentType: JSON
+// {"readings": [...]} or a protobuf TelemetryBatch.
+func DecodeBatch(contentType string, payload []byte) ([]*models.DeviceData, error) {
+	switch contentType {
+	case ContentTypeProtobuf, "application/protobuf":
+		return decodeProtobufBatch(payload)
+	case "application/json":
+		return decodeJSONBatch(payload)
+	default:
+		return nil, errUnsupportedContentType
+	}
+}
+
+// EncodeProtobufBatch encodes readings as a TelemetryBatch. Metrics that
+// aren't numbers have no place in the protobuf schema and are dropped.
+func EncodeProtobufBatch(readings []*models.DeviceData) ([]byte, error) {
+	batch := &telemetryv1.TelemetryBatch{Readings: make([]*telemetryv1.Reading, len(readings))}
+	for i, data := range readings {
+		reading, err := readingToProto(data)
+		if err != nil {
+			return nil, err
+		}
+		batch.Readings[i] = reading
+	}
+	return proto.Marshal(batch)
+}
+
+// decodeMessage decodes a Kafka telemetry message: a single JSON
+// DeviceData, or a protobuf TelemetryBatch from the protobuf topic.
+func decodeMessage(value []byte, protobuf bool) ([]*models.DeviceData, error) {
+	if protobuf {
+		return decodeProtobufBatch(value)
+	}
+
+	var data models.DeviceData
+	if err := json.Unmarshal(value, &data); err != nil {
+		return nil, err
+	}
+	return []*models.DeviceData{&data}, nil
+}
+
+func decodeJSONBatch(payload []byte) ([]*models.DeviceData, error) {
+	var batch struct {
+		Readings []*models.DeviceData `json:"readings"`
+	}
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return nil, err
+	}
+	return batch.Readings, nil
+}
+
+func decodeProtobufBatch(payload []byte) ([]*models.DeviceData, error) {
+	var batch telemetryv1.TelemetryBatch
+	if err := proto.Unmarshal(payload, &batch); err != nil {
+		return nil, err
+	}
+
+	readings := make([]*models.DeviceData, len(batch.GetReadings()))
+	for i, reading := range batch.GetReadings() {
+		readings[i] = readingFromProto(reading)
+	}
+	return readings, nil
+}
+
+// readingFromProto converts to the model the JSON path decodes into, so
+// both encodings share the rest of the pipeline. tenant_id is dropped;
+// readings belong to the tenant their device is registered under.
+func readingFromProto(reading *telemetryv1.Reading) *models.DeviceData {
+	data := &models.DeviceData{
+		DeviceID:   reading.GetDeviceId(),
+		DeviceType: reading.GetDeviceType(),
+		Location: models.Location{
+			Latitude:  reading.GetLatitude(),
+			Longitude: reading.GetLongitude(),
+		},
+		Metrics:  make(map[string]interface{}, len(reading.GetMetrics())),
+		Metadata: reading.GetMetadata().AsMap(),
+	}
+	if reading.GetTimestampMs() != 0 {
+		data.Timestamp = time.UnixMilli(reading.GetTimestampMs()).UTC()
+	}
+	for name, value := range reading.GetMetrics() {
+		data.Metrics[name] = value
+	}
+	return data
+}
+
+func readingToProto(data *models.DeviceData) (*telemetryv1.Reading, error) {
+	reading := &telemetryv1.Reading{
+		DeviceId:   data.DeviceID,
+		TenantId:   data.TenantID,
+		DeviceType: data.DeviceType,
+		Latitude:   data.Location.Latitude,
+		Longitude:  data.Location.Longitude,
+		Metrics:    make(map[string]float64, len(data.Metrics)),
+	}
+	if !data.Timestamp.IsZero() {
+		reading.TimestampMs = data.Timestamp.UnixMilli()
+	}
+	for name, value := range data.Metrics {
+		if numeric, ok := value.(float64); ok {
+			reading.Metrics[name] = numeric
+		}
+	}
+	if len(data.Metadata) > 0 {
+		metadata, err := structpb.NewStruct(data.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		reading.Metadata = metadata
+	}
+	return reading, nil
+}
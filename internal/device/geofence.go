@@ -0,0 +1,42 @@
+package device
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bhanukaranwal/urbanzen/internal/geofence"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+// checkGeofence raises an anomaly when a reading takes a fenced device
+// outside its area, and logs its return. Readings without a position fix,
+// reported as 0,0, are skipped.
+func (s *Service) checkGeofence(data *models.DeviceData) {
+	if data.Location.Latitude == 0 && data.Location.Longitude == 0 {
+		return
+	}
+
+	crossing, err := s.fences.Check(context.Background(), data.DeviceID, data.Location, data.Timestamp)
+	if err != nil {
+		s.logger.Error("Failed to check geofence", "error", err, "device_id", data.DeviceID)
+		return
+	}
+	if crossing == nil {
+		return
+	}
+
+	if !crossing.Exited {
+		s.logger.Info("Device returned inside its geofence", "device_id", data.DeviceID)
+		return
+	}
+
+	s.handleAnomaly(&models.Anomaly{
+		DeviceID: data.DeviceID,
+		Type:     geofence.AnomalyType,
+		Severity: crossing.Severity,
+		Description: fmt.Sprintf("Reported %.0f m outside its geofence at %.5f, %.5f",
+			crossing.DistanceMeters, data.Location.Latitude, data.Location.Longitude),
+		Timestamp: data.Timestamp,
+		Value:     crossing.DistanceMeters,
+	})
+}
@@ -0,0 +1,200 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceaccess"
+	"github.com/bhanukaranwal/urbanzen/internal/devicelifecycle"
+	"github.com/bhanukaranwal/urbanzen/internal/devicestatus"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/rpc"
+	"github.com/bhanukaranwal/urbanzen/internal/rpc/devicev1"
+)
+
+// GRPCServer serves the internal device API defined in
+// api/proto/urbanzen/device/v1. Like the HTTP API it is for operators;
+// services call it with an operator's or service account's credentials.
+type GRPCServer struct {
+	devicev1.UnimplementedDeviceServiceServer
+	service *Service
+	access  *deviceaccess.Store
+}
+
+func NewGRPCServer(service *Service, access *deviceaccess.Store) *GRPCServer {
+	return &GRPCServer{service: service, access: access}
+}
+
+// IngestTelemetry queues a reading exactly as POST /telemetry does. There
+// are no device credentials over gRPC, so only service accounts and admins
+// may call it, and the reading takes its device's registered tenant.
+func (g *GRPCServer) IngestTelemetry(ctx context.Context, req *devicev1.IngestTelemetryRequest) (*devicev1.IngestTelemetryResponse, error) {
+	if err := requireRole(ctx, middleware.ServiceRole); err != nil {
+		return nil, err
+	}
+
+	data := req.GetData()
+	if data.GetDeviceId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "data.device_id is required")
+	}
+
+	message := models.DeviceData{
+		DeviceID:   data.GetDeviceId(),
+		DeviceType: data.GetDeviceType(),
+		Location: models.Location{
+			Latitude:  data.GetLocation().GetLatitude(),
+			Longitude: data.GetLocation().GetLongitude(),
+		},
+		Metrics:  data.GetMetrics().AsMap(),
+		Metadata: data.GetMetadata().AsMap(),
+	}
+	if data.GetTimestamp() != nil {
+		message.Timestamp = data.GetTimestamp().AsTime()
+	}
+	g.service.claimTenant(&message)
+
+	if !g.service.enqueue(&message) {
+		return nil, status.Error(codes.ResourceExhausted, "ingestion queue is full, retry later")
+	}
+	return &devicev1.IngestTelemetryResponse{}, nil
+}
+
+// SendCommand publishes a command for one of the caller's tenant's devices,
+// once it has been checked against the device type's capabilities and
+// the caller's device assignments. The command is recorded in the command
+// history before it is published, as the HTTP API's are.
+// A dry run makes the same checks and returns the same response, with any
+// warnings, but publishes nothing, so operators can check a disruptive
+// command before it reaches hardware.
+func (g *GRPCServer) SendCommand(ctx context.Context, req *devicev1.SendCommandRequest) (*devicev1.SendCommandResponse, error) {
+	if err := requireRole(ctx, "operator"); err != nil {
+		return nil, err
+	}
+	if req.GetDeviceId() == "" || req.GetCommand() == "" {
+		return nil, status.Error(codes.InvalidArgument, "device_id and command are required")
+	}
+
+	identity := rpc.IdentityFrom(ctx)
+	if deviceaccess.Scoped(identity.Role) {
+		assigned, err := g.access.Assigned(ctx, identity.UserID, req.GetDeviceId())
+		if err != nil {
+			g.service.logger.Error("Failed to check device access", "error", err, "device_id", req.GetDeviceId())
+			return nil, status.Error(codes.Internal, "failed to check device access")
+		}
+		if !assigned {
+			return nil, status.Error(codes.PermissionDenied, "access denied")
+		}
+	}
+
+	var deviceType, deviceStatus string
+	err := g.service.db.QueryRowContext(ctx, `
+		SELECT type, status FROM devices WHERE id = $1 AND tenant_id = $2
+	`, req.GetDeviceId(), identity.TenantID).Scan(&deviceType, &deviceStatus)
+	if err == sql.ErrNoRows {
+		return nil, status.Error(codes.NotFound, "device not found")
+	}
+	if err != nil {
+		g.service.logger.Error("Failed to look up device", "error", err, "device_id", req.GetDeviceId())
+		return nil, status.Error(codes.Internal, "failed to send command")
+	}
+
+	err = g.service.checkCommand(deviceType, req.GetCommand(), req.GetParameters().AsMap())
+	if commandErr, ok := err.(*devicetype.CommandError); ok {
+		return nil, status.Error(codes.InvalidArgument, commandErr.Error())
+	}
+	if err != nil {
+		g.service.logger.Error("Failed to check command", "error", err, "device_id", req.GetDeviceId())
+		return nil, status.Error(codes.Internal, "failed to send command")
+	}
+
+	connectivity, warnings := g.service.commandWarnings(ctx, req.GetDeviceId(), deviceStatus)
+	response := &devicev1.SendCommandResponse{
+		DeviceId:     req.GetDeviceId(),
+		Command:      req.GetCommand(),
+		DryRun:       req.GetDryRun(),
+		Parameters:   req.GetParameters(),
+		DeviceStatus: deviceStatus,
+		Connectivity: connectivity,
+		Warnings:     warnings,
+	}
+	if req.GetDryRun() {
+		g.service.logger.Info("Command dry run", "device_id", req.GetDeviceId(), "command", req.GetCommand(),
+			"user_id", identity.UserID, "warnings", len(warnings))
+		return response, nil
+	}
+
+	command := models.DeviceCommand{
+		DeviceID:   req.GetDeviceId(),
+		Command:    req.GetCommand(),
+		Parameters: req.GetParameters().AsMap(),
+		Status:     CommandPending,
+		IssuedBy:   identity.UserID,
+	}
+	if err := g.service.recordCommand(ctx, &command); err != nil {
+		g.service.logger.Error("Failed to record command", "error", err, "device_id", command.DeviceID)
+		return nil, status.Error(codes.Internal, "failed to send command")
+	}
+	message, _ := json.Marshal(command)
+
+	topic := g.service.config.Kafka.Topics.Commands
+	if topic == "" {
+		topic = "device-commands"
+	}
+	if err := g.service.producer.ProduceMessage(topic, command.DeviceID, message); err != nil {
+		g.service.logger.Error("Failed to publish command", "error", err, "device_id", command.DeviceID)
+		if err := g.service.setCommandStatus(ctx, command.ID, CommandFailed); err != nil {
+			g.service.logger.Error("Failed to mark command failed", "error", err, "command_id", command.ID)
+		}
+		return nil, status.Error(codes.Unavailable, "failed to send command")
+	}
+
+	response.IssuedAt = timestamppb.New(command.Timestamp)
+	return response, nil
+}
+
+// commandWarnings returns the device's last known connectivity and the
+// reasons a command sent to it now may not take effect.
+func (s *Service) commandWarnings(ctx context.Context, deviceID, deviceStatus string) (string, []string) {
+	var warnings []string
+	switch deviceStatus {
+	case devicelifecycle.Decommissioned:
+		warnings = append(warnings, "device is decommissioned and will never receive the command")
+	case devicelifecycle.Maintenance:
+		warnings = append(warnings, "device is in maintenance; field staff may be working on it")
+	case devicelifecycle.Provisioned, devicelifecycle.Installed:
+		warnings = append(warnings, fmt.Sprintf("device is %s and not yet in service", deviceStatus))
+	}
+
+	latest, err := s.statuses.GetLatestStatus(ctx, deviceID)
+	if err != nil {
+		s.logger.Warn("Failed to load device connectivity", "error", err, "device_id", deviceID)
+		return devicestatus.ConnectivityUnknown, append(warnings, "device connectivity is unknown")
+	}
+
+	switch connectivity := latest.Connectivity; connectivity {
+	case devicestatus.ConnectivityOnline:
+	case devicestatus.ConnectivityUnknown:
+		warnings = append(warnings, "device connectivity is unknown")
+	default:
+		warnings = append(warnings, fmt.Sprintf("device is %s; it will get the command only when it reconnects", connectivity))
+	}
+	return latest.Connectivity, warnings
+}
+
+func requireRole(ctx context.Context, role string) error {
+	identity := rpc.IdentityFrom(ctx)
+	if identity == nil {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if !identity.HasRole(role) {
+		return status.Error(codes.PermissionDenied, "insufficient privileges")
+	}
+	return nil
+}
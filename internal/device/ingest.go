@@ -0,0 +1,264 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+const (
+	defaultQueueCapacity = 10000
+	defaultWorkers       = 4
+	defaultRetryAfter    = 5 * time.Second
+	defaultMaxBatchSize  = 1000
+)
+
+var ingestRejected = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "urbanzen_ingest_rejected_total",
+	Help: "Telemetry messages rejected because the ingestion queue was full.",
+})
+
+var ingestDeviceMismatch = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "urbanzen_ingest_device_mismatch_total",
+	Help: "Telemetry requests refused because a reading named a device other than the authenticated one.",
+})
+
+func registerQueueMetrics(queues []chan *models.DeviceData) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "urbanzen_ingest_queue_depth",
+			Help: "Telemetry messages waiting to be processed.",
+		}, func() float64 {
+			depth := 0
+			for _, queue := range queues {
+				depth += len(queue)
+			}
+			return float64(depth)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "urbanzen_ingest_queue_capacity",
+			Help: "Maximum telemetry messages the ingestion queues hold.",
+		}, func() float64 {
+			capacity := 0
+			for _, queue := range queues {
+				capacity += cap(queue)
+			}
+			return float64(capacity)
+		}),
+	)
+}
+
+// IngestTelemetry accepts a telemetry message over HTTP for devices and
+// integrations that can't publish to Kafka. When the ingestion queue is full
+// the request is refused with 503 and a Retry-After hint.
+func (s *Service) IngestTelemetry(c *gin.Context) {
+	var data models.DeviceData
+	if !middleware.BindJSON(c, &data) {
+		return
+	}
+	if !s.claimDevice(c, &data) {
+		return
+	}
+
+	if !s.enqueue(&data) {
+		s.queueFull(c, gin.H{"error": "Ingestion queue is full, retry later"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Telemetry accepted"})
+}
+
+// IngestBatch accepts many readings in one request: JSON
+// {"readings": [...]} or, with Content-Type application/x-protobuf, a
+// telemetryv1.TelemetryBatch, which is far smaller and cheaper to parse.
+// Readings are queued in order. If the queue fills part way through, the
+// response is 503 with the number accepted and the client should resend
+// the rest.
+func (s *Service) IngestBatch(c *gin.Context) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if middleware.BodyTooLarge(err) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Telemetry batch is too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	readings, err := DecodeBatch(c.ContentType(), payload)
+	if err == errUnsupportedContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "Content-Type must be application/json or " + ContentTypeProtobuf,
+		})
+		return
+	}
+	if err != nil && c.ContentType() == "application/json" {
+		middleware.BindError(c, payload, err)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body is not a valid telemetry batch"})
+		return
+	}
+
+	maxBatch := s.config.Devices.Ingestion.MaxBatchSize
+	if maxBatch <= 0 {
+		maxBatch = defaultMaxBatchSize
+	}
+	if len(readings) == 0 || len(readings) > maxBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Batch must contain between 1 and %d readings", maxBatch)})
+		return
+	}
+	if !s.claimDevice(c, readings...) {
+		return
+	}
+
+	accepted := 0
+	for _, data := range readings {
+		if !s.enqueue(data) {
+			break
+		}
+		accepted++
+	}
+
+	if accepted < len(readings) {
+		// enqueue counted the first rejection; count the readings not tried
+		untried := float64(len(readings) - accepted - 1)
+		ingestRejected.Add(untried)
+		metrics.IngestMessages.WithLabelValues(metrics.IngestRejected).Add(untried)
+		s.queueFull(c, gin.H{
+			"error":    "Ingestion queue is full, resend the readings after the accepted ones",
+			"accepted": accepted,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Telemetry accepted", "accepted": accepted})
+}
+
+// claimDevice ties readings to the device whose credential authenticated
+// the request, filling in an omitted device_id and the device's tenant. If
+// any reading names another device the whole request is refused with 403,
+// since a device reporting for others is spoofing or misconfigured.
+// Service and admin callers, which the routes only admit for backfills and
+// integrations, may send readings for any device. The tenant_id a reading
+// is sent with is never kept.
+func (s *Service) claimDevice(c *gin.Context, readings ...*models.DeviceData) bool {
+	deviceID := middleware.DeviceID(c)
+	if deviceID == "" {
+		for _, data := range readings {
+			s.claimTenant(data)
+		}
+		return true
+	}
+
+	for _, data := range readings {
+		if data.DeviceID == "" {
+			data.DeviceID = deviceID
+		}
+		if data.DeviceID != deviceID {
+			ingestDeviceMismatch.Inc()
+			s.logger.Warn("Telemetry device does not match credential",
+				"device_id", deviceID, "claimed_device_id", data.DeviceID, "credential_id", c.GetString("credential_id"))
+			c.JSON(http.StatusForbidden, gin.H{"error": "Telemetry device_id does not match the device credential"})
+			return false
+		}
+		data.TenantID = middleware.TenantID(c)
+	}
+	return true
+}
+
+// claimTenant replaces the tenant a reading claims with the one its device
+// is registered under. A device that isn't registered is left without one;
+// processing rejects its readings.
+func (s *Service) claimTenant(data *models.DeviceData) {
+	data.TenantID = ""
+	if device, err := s.resolveDevice(data.DeviceID); err == nil {
+		data.TenantID = device.tenantID
+	}
+}
+
+// queueFull answers 503 with a Retry-After hint.
+func (s *Service) queueFull(c *gin.Context, body gin.H) {
+	retryAfter := s.config.Devices.Ingestion.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	c.JSON(http.StatusServiceUnavailable, body)
+}
+
+// QueueLoad is the share in use of the fullest ingestion queue, for load
+// shedding: that queue's devices are the first to be turned away.
+func (s *Service) QueueLoad() float64 {
+	load := 0.0
+	for _, queue := range s.queues {
+		if share := float64(len(queue)) / float64(cap(queue)); share > load {
+			load = share
+		}
+	}
+	return load
+}
+
+// enqueue hands a message to the processors without blocking. It reports
+// false if its device's queue is full.
+func (s *Service) enqueue(data *models.DeviceData) bool {
+	select {
+	case s.queueFor(data.DeviceID) <- data:
+		return true
+	default:
+		ingestRejected.Inc()
+		metrics.IngestMessages.WithLabelValues(metrics.IngestRejected).Inc()
+		return false
+	}
+}
+
+// queueFor picks a device's queue by hashing its ID, as kafka.ProcessBatch
+// picks lanes by message key, so one worker sees all of a device's
+// readings in the order they arrived. Totalizer and breach window checks
+// depend on that order.
+func (s *Service) queueFor(deviceID string) chan *models.DeviceData {
+	hash := fnv.New32a()
+	hash.Write([]byte(deviceID))
+	return s.queues[hash.Sum32()%uint32(len(s.queues))]
+}
+
+// processQueue processes a queue's messages until ctx is cancelled, then
+// finishes what is left in it so accepted readings aren't lost on
+// shutdown.
+func (s *Service) processQueue(ctx context.Context, queue chan *models.DeviceData) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case data := <-queue:
+					metrics.IngestMessages.WithLabelValues(s.processDeviceMessage(data)).Inc()
+				default:
+					return
+				}
+			}
+		case data := <-queue:
+			metrics.IngestMessages.WithLabelValues(s.processDeviceMessage(data)).Inc()
+		}
+	}
+}
+
+func ingestionWorkers(cfg *config.Config) int {
+	if cfg.Devices.Ingestion.Workers > 0 {
+		return cfg.Devices.Ingestion.Workers
+	}
+	return defaultWorkers
+}
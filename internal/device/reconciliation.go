@@ -0,0 +1,582 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceconfig"
+	"github.com/bhanukaranwal/urbanzen/internal/devicelifecycle"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+const (
+	ReconciliationRunning   = "running"
+	ReconciliationCompleted = "completed"
+	ReconciliationFailed    = "failed"
+
+	// Telemetry arrived from an ID that isn't registered
+	FindingUnregistered = "unregistered"
+	// Telemetry is stored for a device no longer registered
+	FindingOrphaned = "orphaned"
+	// A registered device has sent nothing for silent_after
+	FindingSilent = "silent"
+	// An unregistered ID was registered automatically
+	FindingRegistered = "registered"
+
+	// Each replica saves the unregistered IDs it rejected this often
+	unregisteredFlushInterval = time.Minute
+
+	// Distinct unregistered IDs tallied between flushes; messages from
+	// further IDs are still rejected but not tallied
+	maxUnregisteredTallied = 10000
+
+	// Findings of each kind kept per run; the run's counts cover them all
+	maxReconciliationFindings = 1000
+
+	// A running reconciliation not finished in this long died with its
+	// replica, and no longer blocks the next
+	reconciliationStaleAfter = time.Hour
+)
+
+// Reconciliation is the outcome of comparing the device registry against
+// telemetry, as seen by one tenant.
+type Reconciliation struct {
+	ID           string     `json:"id"`
+	StartedAt    time.Time  `json:"started_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+	Unregistered []*Finding `json:"unregistered"`
+	Orphaned     []*Finding `json:"orphaned"`
+	Silent       []*Finding `json:"silent"`
+	Registered   []*Finding `json:"registered"`
+}
+
+// Finding is a device the registry and telemetry disagree about. For
+// unregistered IDs, the type is the one their messages claimed.
+type Finding struct {
+	DeviceID   string     `json:"device_id"`
+	DeviceType string     `json:"device_type,omitempty"`
+	LastSeen   *time.Time `json:"last_seen"`
+	Messages   int64      `json:"messages,omitempty"`
+
+	kind     string
+	tenantID string
+}
+
+// unregisteredTally counts the messages rejected from each unregistered
+// ID since the last flush.
+type unregisteredTally struct {
+	mu      sync.Mutex
+	devices map[string]*unregisteredSighting
+}
+
+type unregisteredSighting struct {
+	tenantID   string
+	deviceType string
+	firstSeen  time.Time
+	lastSeen   time.Time
+	messages   int64
+}
+
+func (t *unregisteredTally) add(data *models.DeviceData) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if sighting, ok := t.devices[data.DeviceID]; ok {
+		sighting.lastSeen = now
+		sighting.messages++
+		return
+	}
+	if t.devices == nil {
+		t.devices = make(map[string]*unregisteredSighting)
+	}
+	if len(t.devices) >= maxUnregisteredTallied {
+		return
+	}
+	t.devices[data.DeviceID] = &unregisteredSighting{
+		tenantID:   data.TenantID,
+		deviceType: data.DeviceType,
+		firstSeen:  now,
+		lastSeen:   now,
+		messages:   1,
+	}
+}
+
+// take returns the tally and starts a new one.
+func (t *unregisteredTally) take() map[string]*unregisteredSighting {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	devices := t.devices
+	t.devices = nil
+	return devices
+}
+
+// runReconciliation saves this replica's tally of unregistered IDs every
+// minute, and reconciles the registry whenever a run is due. Any replica
+// may run it; the run's row keeps two from running at once.
+func (s *Service) runReconciliation(ctx context.Context) {
+	ticker := time.NewTicker(unregisteredFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Storage closes after processing, so the last tally can still
+			// be saved
+			s.flushUnregistered(context.Background())
+			return
+		case <-ticker.C:
+			s.flushUnregistered(ctx)
+			if err := s.reconcile(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Error("Failed to reconcile device registry", "error", err)
+			}
+		}
+	}
+}
+
+func (s *Service) flushUnregistered(ctx context.Context) {
+	devices := s.unregistered.take()
+	if len(devices) == 0 {
+		return
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to save unregistered devices", "error", err)
+		return
+	}
+	defer tx.Rollback()
+
+	for deviceID, sighting := range devices {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO unregistered_telemetry (device_id, tenant_id, device_type, first_seen, last_seen, messages)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6)
+			ON CONFLICT (device_id) DO UPDATE SET
+				tenant_id = COALESCE(EXCLUDED.tenant_id, unregistered_telemetry.tenant_id),
+				device_type = COALESCE(EXCLUDED.device_type, unregistered_telemetry.device_type),
+				first_seen = LEAST(unregistered_telemetry.first_seen, EXCLUDED.first_seen),
+				last_seen = GREATEST(unregistered_telemetry.last_seen, EXCLUDED.last_seen),
+				messages = unregistered_telemetry.messages + EXCLUDED.messages
+		`, deviceID, sighting.tenantID, sighting.deviceType, sighting.firstSeen, sighting.lastSeen, sighting.messages)
+		if err != nil {
+			s.logger.Error("Failed to save unregistered devices", "error", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to save unregistered devices", "error", err)
+	}
+}
+
+// reconcile runs a reconciliation if none has started within the
+// interval.
+func (s *Service) reconcile(ctx context.Context) error {
+	id, err := s.startReconciliation(ctx)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	findings, err := s.findDiscrepancies(ctx)
+	if err == nil {
+		err = s.completeReconciliation(ctx, id, findings)
+	}
+	if err != nil {
+		if _, failErr := s.db.ExecContext(context.Background(), `
+			UPDATE device_reconciliations SET status = $2, error = $3, completed_at = NOW() WHERE id = $1
+		`, id, ReconciliationFailed, err.Error()); failErr != nil {
+			s.logger.Error("Failed to record reconciliation failure", "error", failErr, "reconciliation_id", id)
+		}
+		return err
+	}
+	return nil
+}
+
+// startReconciliation claims the next run, or returns sql.ErrNoRows if one
+// is running or ran within the interval.
+func (s *Service) startReconciliation(ctx context.Context) (string, error) {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE device_reconciliations
+		SET status = $1, error = 'abandoned', completed_at = NOW()
+		WHERE status = $2 AND started_at < $3
+	`, ReconciliationFailed, ReconciliationRunning, time.Now().Add(-reconciliationStaleAfter)); err != nil {
+		return "", err
+	}
+
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO device_reconciliations (status)
+		SELECT $1::text
+		WHERE NOT EXISTS (SELECT 1 FROM device_reconciliations WHERE started_at > $2)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`, ReconciliationRunning, time.Now().Add(-s.config.Devices.Reconciliation.Interval)).Scan(&id)
+	return id, err
+}
+
+type registryEntry struct {
+	tenantID   string
+	deviceType string
+	status     string
+	createdAt  time.Time
+}
+
+type telemetrySighting struct {
+	tenantID   string
+	deviceType string
+	lastSeen   time.Time
+}
+
+// findDiscrepancies compares the registry with the telemetry received
+// within the lookback, and registers unregistered IDs that qualify.
+func (s *Service) findDiscrepancies(ctx context.Context) ([]*Finding, error) {
+	settings := s.config.Devices.Reconciliation
+	since := time.Now().Add(-settings.Lookback)
+	silentSince := time.Now().Add(-settings.SilentAfter)
+
+	registry, err := s.loadRegistry(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.tsdb.QueryContext(ctx, `
+		SELECT device_id, tenant_id, MAX(device_type), MAX(timestamp)
+		FROM device_telemetry
+		WHERE timestamp > $1
+		GROUP BY device_id, tenant_id
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	telemetry := make(map[string]telemetrySighting)
+	for rows.Next() {
+		var deviceID string
+		var sighting telemetrySighting
+		if err := rows.Scan(&deviceID, &sighting.tenantID, &sighting.deviceType, &sighting.lastSeen); err != nil {
+			return nil, err
+		}
+		if previous, ok := telemetry[deviceID]; !ok || sighting.lastSeen.After(previous.lastSeen) {
+			telemetry[deviceID] = sighting
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var findings []*Finding
+	for deviceID, sighting := range telemetry {
+		if _, ok := registry[deviceID]; !ok {
+			lastSeen := sighting.lastSeen
+			findings = append(findings, &Finding{
+				DeviceID:   deviceID,
+				DeviceType: sighting.deviceType,
+				LastSeen:   &lastSeen,
+				kind:       FindingOrphaned,
+				tenantID:   sighting.tenantID,
+			})
+		}
+	}
+
+	for deviceID, device := range registry {
+		if device.status == devicelifecycle.Decommissioned || device.createdAt.After(silentSince) {
+			continue
+		}
+		finding := &Finding{
+			DeviceID:   deviceID,
+			DeviceType: device.deviceType,
+			kind:       FindingSilent,
+			tenantID:   device.tenantID,
+		}
+		if sighting, ok := telemetry[deviceID]; ok {
+			if sighting.lastSeen.After(silentSince) {
+				continue
+			}
+			finding.LastSeen = &sighting.lastSeen
+		}
+		findings = append(findings, finding)
+	}
+
+	unregistered, err := s.reconcileUnregistered(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	return append(findings, unregistered...), nil
+}
+
+func (s *Service) loadRegistry(ctx context.Context) (map[string]registryEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, tenant_id, type, status, created_at FROM devices`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	registry := make(map[string]registryEntry)
+	for rows.Next() {
+		var deviceID string
+		var device registryEntry
+		if err := rows.Scan(&deviceID, &device.tenantID, &device.deviceType, &device.status, &device.createdAt); err != nil {
+			return nil, err
+		}
+		registry[deviceID] = device
+	}
+	return registry, rows.Err()
+}
+
+// reconcileUnregistered reports the unregistered IDs seen within the
+// lookback, registering those that qualify. IDs registered since, or not
+// seen within the lookback, are forgotten.
+func (s *Service) reconcileUnregistered(ctx context.Context, since time.Time) ([]*Finding, error) {
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM unregistered_telemetry u
+		WHERE u.last_seen <= $1 OR EXISTS (SELECT 1 FROM devices d WHERE d.id = u.device_id)
+	`, since); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT device_id, COALESCE(tenant_id, ''), COALESCE(device_type, ''), first_seen, last_seen, messages
+		FROM unregistered_telemetry
+	`)
+	if err != nil {
+		return nil, err
+	}
+	devices := make(map[string]*unregisteredSighting)
+	for rows.Next() {
+		var deviceID string
+		var sighting unregisteredSighting
+		if err := rows.Scan(&deviceID, &sighting.tenantID, &sighting.deviceType, &sighting.firstSeen,
+			&sighting.lastSeen, &sighting.messages); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		devices[deviceID] = &sighting
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	findings := make([]*Finding, 0, len(devices))
+	for deviceID, sighting := range devices {
+		finding := &Finding{
+			DeviceID:   deviceID,
+			DeviceType: sighting.deviceType,
+			LastSeen:   &sighting.lastSeen,
+			Messages:   sighting.messages,
+			kind:       FindingUnregistered,
+			tenantID:   sighting.tenantID,
+		}
+
+		registered, err := s.autoRegister(ctx, deviceID, sighting)
+		if err != nil {
+			s.logger.Error("Failed to register device automatically", "error", err, "device_id", deviceID)
+		}
+		if registered {
+			finding.kind = FindingRegistered
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
+
+// autoRegister registers an unregistered ID if auto-registration is on,
+// the ID has one of the configured prefixes, and the tenant and type its
+// messages claimed exist. The device gets the type's default
+// configuration and starts out provisioned.
+func (s *Service) autoRegister(ctx context.Context, deviceID string, sighting *unregisteredSighting) (bool, error) {
+	settings := s.config.Devices.Reconciliation.AutoRegister
+	if !settings.Enabled || sighting.tenantID == "" || sighting.deviceType == "" || !hasAnyPrefix(deviceID, settings.Prefixes) {
+		return false, nil
+	}
+
+	var tenantExists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tenants WHERE id = $1)`,
+		sighting.tenantID).Scan(&tenantExists); err != nil || !tenantExists {
+		return false, err
+	}
+
+	deviceType, err := s.types.Get(ctx, sighting.deviceType)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	configuration, err := deviceType.Configure(nil)
+	if err != nil {
+		// The type needs settings only an operator can choose
+		return false, nil
+	}
+
+	configurationJSON, _ := json.Marshal(configuration)
+	metadataJSON, _ := json.Marshal(map[string]interface{}{
+		"auto_registered": true,
+		"first_seen":      sighting.firstSeen,
+	})
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO devices (id, tenant_id, name, type, status, configuration, metadata)
+		VALUES ($1, $2, $1, $3, $4, $5, $6)
+		ON CONFLICT (id) DO NOTHING
+	`, deviceID, sighting.tenantID, sighting.deviceType, devicelifecycle.Provisioned, configurationJSON, metadataJSON)
+	if err != nil {
+		return false, err
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 1 {
+		if err := deviceconfig.Record(ctx, tx, deviceID, configurationJSON, ""); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM unregistered_telemetry WHERE device_id = $1`, deviceID); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	s.logger.Info("Registered device automatically", "device_id", deviceID, "tenant_id", sighting.tenantID,
+		"type", sighting.deviceType)
+	return true, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// completeReconciliation saves the run's counts and up to
+// maxReconciliationFindings findings of each kind.
+func (s *Service) completeReconciliation(ctx context.Context, id string, findings []*Finding) error {
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].kind != findings[j].kind {
+			return findings[i].kind < findings[j].kind
+		}
+		return findings[i].DeviceID < findings[j].DeviceID
+	})
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	counts := make(map[string]int)
+	for _, finding := range findings {
+		counts[finding.kind]++
+		if counts[finding.kind] > maxReconciliationFindings {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO device_reconciliation_findings
+				(reconciliation_id, kind, device_id, tenant_id, device_type, last_seen, messages)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, NULLIF($7, 0))
+		`, id, finding.kind, finding.DeviceID, finding.tenantID, finding.DeviceType, finding.LastSeen, finding.Messages); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE device_reconciliations
+		SET status = $2, unregistered = $3, orphaned = $4, silent = $5, registered = $6, completed_at = NOW()
+		WHERE id = $1
+	`, id, ReconciliationCompleted, counts[FindingUnregistered], counts[FindingOrphaned],
+		counts[FindingSilent], counts[FindingRegistered]); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.Info("Device registry reconciled", "reconciliation_id", id,
+		"unregistered", counts[FindingUnregistered], "orphaned", counts[FindingOrphaned],
+		"silent", counts[FindingSilent], "registered", counts[FindingRegistered])
+	return nil
+}
+
+// GetReconciliation returns the tenant's findings from the latest
+// completed reconciliation.
+func (s *Service) GetReconciliation(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	reconciliation := &Reconciliation{
+		Unregistered: []*Finding{},
+		Orphaned:     []*Finding{},
+		Silent:       []*Finding{},
+		Registered:   []*Finding{},
+	}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, started_at, completed_at
+		FROM device_reconciliations
+		WHERE status = $1
+		ORDER BY started_at DESC
+		LIMIT 1
+	`, ReconciliationCompleted).Scan(&reconciliation.ID, &reconciliation.StartedAt, &reconciliation.CompletedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No reconciliation has completed yet"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load reconciliation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reconciliation"})
+		return
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT kind, device_id, COALESCE(device_type, ''), last_seen, COALESCE(messages, 0)
+		FROM device_reconciliation_findings
+		WHERE reconciliation_id = $1 AND tenant_id = $2
+		ORDER BY kind, device_id
+	`, reconciliation.ID, middleware.TenantID(c))
+	if err != nil {
+		s.logger.Error("Failed to load reconciliation findings", "error", err, "reconciliation_id", reconciliation.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reconciliation"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		finding := &Finding{}
+		if err := rows.Scan(&finding.kind, &finding.DeviceID, &finding.DeviceType, &finding.LastSeen, &finding.Messages); err != nil {
+			s.logger.Error("Failed to scan reconciliation finding", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reconciliation"})
+			return
+		}
+		switch finding.kind {
+		case FindingUnregistered:
+			reconciliation.Unregistered = append(reconciliation.Unregistered, finding)
+		case FindingOrphaned:
+			reconciliation.Orphaned = append(reconciliation.Orphaned, finding)
+		case FindingSilent:
+			reconciliation.Silent = append(reconciliation.Silent, finding)
+		case FindingRegistered:
+			reconciliation.Registered = append(reconciliation.Registered, finding)
+		}
+	}
+
+	c.JSON(http.StatusOK, reconciliation)
+}
@@ -0,0 +1,348 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+const (
+	ReplayPending   = "pending"
+	ReplayRunning   = "running"
+	ReplayCompleted = "completed"
+	ReplayFailed    = "failed"
+	ReplayCancelled = "cancelled"
+
+	defaultReplayBatchSize     = 500
+	defaultReplayRowsPerSecond = 1000
+	replayPollInterval         = 5 * time.Second
+
+	// A running job whose instance stopped heartbeating is picked up again
+	// from its cursor
+	replayHeartbeatTimeout = 5 * time.Minute
+)
+
+// ReplayJob re-runs a tenant's historical telemetry through the current
+// anomaly detectors. Progress is checkpointed after every batch, so a job
+// resumes where it stopped.
+type ReplayJob struct {
+	ID             string     `json:"id"`
+	TenantID       string     `json:"tenant_id"`
+	DeviceID       string     `json:"device_id,omitempty"`
+	DeviceType     string     `json:"device_type,omitempty"`
+	From           time.Time  `json:"from"`
+	To             time.Time  `json:"to"`
+	Status         string     `json:"status"`
+	Cursor         time.Time  `json:"cursor"`
+	CursorDevice   string     `json:"cursor_device_id"`
+	RowsProcessed  int64      `json:"rows_processed"`
+	AnomaliesFound int        `json:"anomalies_found"`
+	Error          string     `json:"error,omitempty"`
+	CreatedBy      string     `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+const replayColumns = `
+	id, tenant_id, COALESCE(device_id, ''), COALESCE(device_type, ''), range_start, range_end, status,
+	cursor_timestamp, cursor_device_id, rows_processed, anomalies_found, COALESCE(error, ''),
+	created_by::text, created_at, completed_at
+`
+
+func scanReplayJob(row rowScanner) (*ReplayJob, error) {
+	var job ReplayJob
+	err := row.Scan(
+		&job.ID,
+		&job.TenantID,
+		&job.DeviceID,
+		&job.DeviceType,
+		&job.From,
+		&job.To,
+		&job.Status,
+		&job.Cursor,
+		&job.CursorDevice,
+		&job.RowsProcessed,
+		&job.AnomaliesFound,
+		&job.Error,
+		&job.CreatedBy,
+		&job.CreatedAt,
+		&job.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// StartReplay queues a replay of the tenant's telemetry over a time range.
+// Raw telemetry is only read; anomalies that were already recorded are not
+// raised again.
+func (s *Service) StartReplay(c *gin.Context) {
+	var req struct {
+		From       time.Time `json:"from" binding:"required"`
+		To         time.Time `json:"to" binding:"required"`
+		DeviceID   string    `json:"device_id"`
+		DeviceType string    `json:"device_type"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	if !req.From.Before(req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	if req.To.After(time.Now()) {
+		req.To = time.Now()
+	}
+
+	row := s.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO replay_jobs (tenant_id, device_id, device_type, range_start, range_end, status, cursor_timestamp, created_by)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, $4, $7)
+		RETURNING `+replayColumns,
+		middleware.TenantID(c), req.DeviceID, req.DeviceType, req.From, req.To, ReplayPending, c.GetString("user_id"),
+	)
+	job, err := scanReplayJob(row)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A replay is already in progress for this tenant"})
+			return
+		}
+		s.logger.Error("Failed to create replay job", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start replay"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"replay":  job,
+		"message": "Replay queued",
+	})
+}
+
+func (s *Service) GetReplay(c *gin.Context) {
+	job, err := s.loadReplayJob(c.Request.Context(), middleware.TenantID(c), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Replay not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load replay job", "error", err, "replay_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve replay"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelReplay stops a replay after its current batch. It can be resumed
+// later from the same point.
+func (s *Service) CancelReplay(c *gin.Context) {
+	s.setReplayStatus(c, []string{ReplayPending, ReplayRunning}, ReplayCancelled)
+}
+
+// ResumeReplay requeues a cancelled or failed replay from its last
+// checkpoint.
+func (s *Service) ResumeReplay(c *gin.Context) {
+	s.setReplayStatus(c, []string{ReplayCancelled, ReplayFailed}, ReplayPending)
+}
+
+func (s *Service) setReplayStatus(c *gin.Context, from []string, to string) {
+	tenantID := middleware.TenantID(c)
+
+	result, err := s.db.ExecContext(c.Request.Context(), `
+		UPDATE replay_jobs SET status = $1, error = NULL
+		WHERE id::text = $2 AND tenant_id = $3 AND status = ANY($4)
+	`, to, c.Param("id"), tenantID, pq.Array(from))
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another replay is already in progress for this tenant"})
+			return
+		}
+		s.logger.Error("Failed to update replay job", "error", err, "replay_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update replay"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Replay not found or not in a state that allows this"})
+		return
+	}
+
+	job, err := s.loadReplayJob(c.Request.Context(), tenantID, c.Param("id"))
+	if err != nil {
+		s.logger.Error("Failed to load replay job", "error", err, "replay_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update replay"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (s *Service) loadReplayJob(ctx context.Context, tenantID, jobID string) (*ReplayJob, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+replayColumns+` FROM replay_jobs WHERE id::text = $1 AND tenant_id = $2`,
+		jobID, tenantID,
+	)
+	return scanReplayJob(row)
+}
+
+func (s *Service) runReplays(ctx context.Context) {
+	ticker := time.NewTicker(replayPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			job, err := s.claimReplayJob(ctx)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				s.logger.Error("Failed to claim replay job", "error", err)
+				continue
+			}
+			s.runReplay(ctx, job)
+		}
+	}
+}
+
+func (s *Service) claimReplayJob(ctx context.Context) (*ReplayJob, error) {
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE replay_jobs SET status = $1, heartbeat_at = NOW()
+		WHERE id = (
+			SELECT id FROM replay_jobs
+			WHERE status = $2 OR (status = $1 AND heartbeat_at < NOW() - $3 * INTERVAL '1 second')
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+replayColumns,
+		ReplayRunning, ReplayPending, replayHeartbeatTimeout.Seconds(),
+	)
+	return scanReplayJob(row)
+}
+
+// runReplay works through the job in batches, pacing itself to the
+// configured rows per second so a replay never starves live ingestion.
+func (s *Service) runReplay(ctx context.Context, job *ReplayJob) {
+	batchSize := s.config.Devices.Replay.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultReplayBatchSize
+	}
+	rowsPerSecond := s.config.Devices.Replay.RowsPerSecond
+	if rowsPerSecond <= 0 {
+		rowsPerSecond = defaultReplayRowsPerSecond
+	}
+
+	s.logger.Info("Starting telemetry replay", "replay_id", job.ID, "tenant_id", job.TenantID, "from", job.Cursor, "to", job.To)
+
+	for {
+		started := time.Now()
+
+		rows, anomalies, err := s.replayBatch(job, batchSize)
+		if err != nil {
+			s.logger.Error("Telemetry replay failed", "error", err, "replay_id", job.ID)
+			s.db.Exec(`UPDATE replay_jobs SET status = $1, error = $2 WHERE id = $3 AND status = $4`,
+				ReplayFailed, err.Error(), job.ID, ReplayRunning)
+			return
+		}
+
+		job.RowsProcessed += int64(rows)
+		job.AnomaliesFound += anomalies
+
+		status := ReplayRunning
+		if rows < batchSize {
+			status = ReplayCompleted
+		}
+
+		// Checkpoint; a cancel issued meanwhile leaves the status untouched
+		// and ends the run
+		result, err := s.db.Exec(`
+			UPDATE replay_jobs
+			SET status = $1, cursor_timestamp = $2, cursor_device_id = $3, rows_processed = $4, anomalies_found = $5,
+				heartbeat_at = NOW(), completed_at = CASE WHEN $1 = 'completed' THEN NOW() END
+			WHERE id = $6 AND status = $7
+		`, status, job.Cursor, job.CursorDevice, job.RowsProcessed, job.AnomaliesFound, job.ID, ReplayRunning)
+		if err != nil {
+			s.logger.Error("Failed to checkpoint replay", "error", err, "replay_id", job.ID)
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			s.logger.Info("Telemetry replay cancelled", "replay_id", job.ID)
+			return
+		}
+
+		if status == ReplayCompleted {
+			s.logger.Info("Telemetry replay completed", "replay_id", job.ID,
+				"rows", job.RowsProcessed, "anomalies", job.AnomaliesFound)
+			return
+		}
+
+		pace := time.Duration(rows) * time.Second / time.Duration(rowsPerSecond)
+		select {
+		case <-ctx.Done():
+			// Hand the job back so another instance, or this one after a
+			// restart, continues from the checkpoint
+			s.db.Exec(`UPDATE replay_jobs SET status = $1 WHERE id = $2 AND status = $3`, ReplayPending, job.ID, ReplayRunning)
+			return
+		case <-time.After(pace - time.Since(started)):
+		}
+	}
+}
+
+// replayBatch runs the next batch after the job's cursor through anomaly
+// detection and advances the cursor.
+func (s *Service) replayBatch(job *ReplayJob, batchSize int) (int, int, error) {
+	rows, err := s.tsdb.Query(`
+		SELECT device_id, device_type, timestamp, metrics
+		FROM device_telemetry
+		WHERE tenant_id = $1 AND timestamp < $2
+			AND ($3 = '' OR device_id = $3)
+			AND ($4 = '' OR device_type = $4)
+			AND (timestamp, device_id) > ($5, $6)
+		ORDER BY timestamp, device_id
+		LIMIT $7
+	`, job.TenantID, job.To, job.DeviceID, job.DeviceType, job.Cursor, job.CursorDevice, batchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	count, anomalies := 0, 0
+	for rows.Next() {
+		data := models.DeviceData{TenantID: job.TenantID}
+		var metrics []byte
+		if err := rows.Scan(&data.DeviceID, &data.DeviceType, &data.Timestamp, &metrics); err != nil {
+			return count, anomalies, err
+		}
+		count++
+		job.Cursor, job.CursorDevice = data.Timestamp, data.DeviceID
+
+		if err := json.Unmarshal(metrics, &data.Metrics); err != nil {
+			continue
+		}
+
+		anomaly := s.detectAnomaly(&data, "replay:"+job.ID)
+		if anomaly == nil {
+			continue
+		}
+		stored, err := s.storeAnomaly(anomaly)
+		if err != nil {
+			return count, anomalies, err
+		}
+		if stored {
+			anomalies++
+			s.publishAnomalyAlert(anomaly, true)
+		}
+	}
+
+	return count, anomalies, rows.Err()
+}
@@ -0,0 +1,174 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/timebucket"
+)
+
+const (
+	sampleFlushInterval = 10 * time.Second
+
+	// Readings arriving a little late still land in their window before it
+	// is written
+	sampleLateness = 30 * time.Second
+)
+
+type windowKey struct {
+	deviceID string
+	bucket   time.Time
+}
+
+type sampleWindow struct {
+	tenantID   string
+	deviceType string
+	interval   int
+	metrics    map[string]*metricAggregate
+}
+
+type metricAggregate struct {
+	min, max, sum float64
+	count         int
+	last          float64
+	lastAt        time.Time
+}
+
+func (a *metricAggregate) add(value float64, at time.Time) {
+	if a.count == 0 || value < a.min {
+		a.min = value
+	}
+	if a.count == 0 || value > a.max {
+		a.max = value
+	}
+	a.sum += value
+	a.count++
+	if !at.Before(a.lastAt) {
+		a.last, a.lastAt = value, at
+	}
+}
+
+// sampler accumulates readings into per-device, fixed-interval windows
+// until they are flushed.
+type sampler struct {
+	mu      sync.Mutex
+	windows map[windowKey]*sampleWindow
+}
+
+func newSampler() *sampler {
+	return &sampler{windows: make(map[windowKey]*sampleWindow)}
+}
+
+// add folds a reading into its window. Windows are aligned to the tenant's
+// local midnight, so daily aggregates match its calendar days.
+func (sp *sampler) add(data *models.DeviceData, intervalSeconds int, loc *time.Location) {
+	interval := time.Duration(intervalSeconds) * time.Second
+	key := windowKey{deviceID: data.DeviceID, bucket: timebucket.Start(data.Timestamp, interval, loc)}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	window, ok := sp.windows[key]
+	if !ok {
+		window = &sampleWindow{
+			tenantID:   data.TenantID,
+			deviceType: data.DeviceType,
+			interval:   intervalSeconds,
+			metrics:    make(map[string]*metricAggregate),
+		}
+		sp.windows[key] = window
+	}
+
+	for metric, value := range data.Metrics {
+		numeric, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		aggregate, ok := window.metrics[metric]
+		if !ok {
+			aggregate = &metricAggregate{}
+			window.metrics[metric] = aggregate
+		}
+		aggregate.add(numeric, data.Timestamp)
+	}
+}
+
+// closed removes and returns the windows that ended before cutoff, or all
+// of them when cutoff is zero.
+func (sp *sampler) closed(cutoff time.Time) map[windowKey]*sampleWindow {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	closed := make(map[windowKey]*sampleWindow)
+	for key, window := range sp.windows {
+		end := key.bucket.Add(time.Duration(window.interval) * time.Second)
+		if cutoff.IsZero() || end.Before(cutoff) {
+			closed[key] = window
+			delete(sp.windows, key)
+		}
+	}
+	return closed
+}
+
+func (s *Service) flushSamples(ctx context.Context) {
+	ticker := time.NewTicker(sampleFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Start writes out the partial windows once ingestion has
+			// drained
+			return
+		case <-ticker.C:
+			s.writeSamples(s.sampler.closed(time.Now().Add(-sampleLateness)))
+		}
+	}
+}
+
+// writeSamples stores windows as aggregate rows. A bucket written more than
+// once (late readings, several instances) is merged rather than replaced.
+// Rows that fail are logged, and their number returned.
+func (s *Service) writeSamples(windows map[windowKey]*sampleWindow) int {
+	query := `
+		INSERT INTO device_telemetry_aggregates (device_id, tenant_id, device_type, bucket, interval_seconds, metric,
+			min_value, max_value, sum_value, sample_count, last_value, last_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (device_id, metric, bucket) DO UPDATE SET
+			min_value = LEAST(device_telemetry_aggregates.min_value, EXCLUDED.min_value),
+			max_value = GREATEST(device_telemetry_aggregates.max_value, EXCLUDED.max_value),
+			sum_value = device_telemetry_aggregates.sum_value + EXCLUDED.sum_value,
+			sample_count = device_telemetry_aggregates.sample_count + EXCLUDED.sample_count,
+			last_value = CASE WHEN EXCLUDED.last_at >= device_telemetry_aggregates.last_at
+				THEN EXCLUDED.last_value ELSE device_telemetry_aggregates.last_value END,
+			last_at = GREATEST(device_telemetry_aggregates.last_at, EXCLUDED.last_at)
+	`
+
+	failed := 0
+	for key, window := range windows {
+		for metric, aggregate := range window.metrics {
+			_, err := s.tsdb.Exec(query,
+				key.deviceID,
+				window.tenantID,
+				window.deviceType,
+				key.bucket,
+				window.interval,
+				metric,
+				aggregate.min,
+				aggregate.max,
+				aggregate.sum,
+				aggregate.count,
+				aggregate.last,
+				aggregate.lastAt,
+			)
+			if err != nil {
+				failed++
+				s.logger.Error("Failed to store telemetry aggregate", "error", err,
+					"device_id", key.deviceID, "metric", metric, "bucket", key.bucket)
+			}
+		}
+	}
+	return failed
+}
@@ -0,0 +1,516 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/devicelifecycle"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/cron"
+)
+
+const (
+	// MisfireFireOnce runs a schedule once when it was missed (for example
+	// while the service was down); MisfireSkip waits for the next slot.
+	MisfireFireOnce = "fire_once"
+	MisfireSkip     = "skip"
+
+	schedulePollInterval = 30 * time.Second
+	misfireThreshold     = 2 * time.Minute
+
+	// A run still marked in progress after this long is assumed to have
+	// died with its instance and may be picked up again
+	scheduleRunTimeout = time.Hour
+)
+
+const scheduleColumns = `
+	id, tenant_id, name, cron, timezone, command, parameters,
+	COALESCE(device_id, ''), COALESCE(device_type, ''), COALESCE(ward, ''), COALESCE(zone, ''),
+	misfire_policy, enabled, next_run_at, last_run_at, created_by::text, created_at, updated_at
+`
+
+type scheduleRequest struct {
+	Name          string                 `json:"name" binding:"required"`
+	Cron          string                 `json:"cron" binding:"required"`
+	Timezone      string                 `json:"timezone"`
+	Command       string                 `json:"command" binding:"required"`
+	Parameters    map[string]interface{} `json:"parameters"`
+	DeviceID      string                 `json:"device_id"`
+	DeviceType    string                 `json:"device_type"`
+	Ward          string                 `json:"ward"`
+	Zone          string                 `json:"zone"`
+	MisfirePolicy string                 `json:"misfire_policy"`
+	Enabled       *bool                  `json:"enabled"`
+}
+
+// schedule validates the request and computes its first run.
+func (r *scheduleRequest) schedule() (*models.CommandSchedule, error) {
+	if r.DeviceID == "" && r.DeviceType == "" && r.Ward == "" && r.Zone == "" {
+		return nil, fmt.Errorf("a device_id or a device_type/ward/zone group is required")
+	}
+	if r.MisfirePolicy == "" {
+		r.MisfirePolicy = MisfireFireOnce
+	}
+	if r.MisfirePolicy != MisfireFireOnce && r.MisfirePolicy != MisfireSkip {
+		return nil, fmt.Errorf("misfire_policy must be %s or %s", MisfireFireOnce, MisfireSkip)
+	}
+	if r.Timezone == "" {
+		r.Timezone = "UTC"
+	}
+	if r.Parameters == nil {
+		r.Parameters = map[string]interface{}{}
+	}
+
+	schedule := &models.CommandSchedule{
+		Name:          r.Name,
+		Cron:          r.Cron,
+		Timezone:      r.Timezone,
+		Command:       r.Command,
+		Parameters:    r.Parameters,
+		DeviceID:      r.DeviceID,
+		DeviceType:    r.DeviceType,
+		Ward:          r.Ward,
+		Zone:          r.Zone,
+		MisfirePolicy: r.MisfirePolicy,
+		Enabled:       r.Enabled == nil || *r.Enabled,
+	}
+
+	next, err := nextRun(schedule.Cron, schedule.Timezone, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	schedule.NextRunAt = next
+	return schedule, nil
+}
+
+// nextRun returns the schedule's first slot after t, or nil if it never
+// fires again.
+func nextRun(expr, timezone string, t time.Time) (*time.Time, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", timezone)
+	}
+	parsed, err := cron.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	next := parsed.Next(t.In(location))
+	if next.IsZero() {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+func (s *Service) ListSchedules(c *gin.Context) {
+	rows, err := s.db.QueryContext(c.Request.Context(),
+		`SELECT `+scheduleColumns+` FROM command_schedules WHERE tenant_id = $1 ORDER BY name`,
+		middleware.TenantID(c),
+	)
+	if err != nil {
+		s.logger.Error("Failed to list schedules", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list schedules"})
+		return
+	}
+	defer rows.Close()
+
+	schedules := []*models.CommandSchedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			s.logger.Error("Failed to scan schedule", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list schedules"})
+			return
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+func (s *Service) GetSchedule(c *gin.Context) {
+	schedule, err := s.loadSchedule(c.Request.Context(), middleware.TenantID(c), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load schedule", "error", err, "schedule_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (s *Service) CreateSchedule(c *gin.Context) {
+	var req scheduleRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	schedule, err := req.schedule()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	schedule.TenantID = middleware.TenantID(c)
+	schedule.CreatedBy = c.GetString("user_id")
+
+	if schedule.DeviceID != "" && !s.deviceInTenant(c.Request.Context(), schedule.TenantID, schedule.DeviceID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device not found"})
+		return
+	}
+	if err := s.checkScheduleCommand(c.Request.Context(), schedule); err != nil {
+		if !invalidCommand(c, err) {
+			s.logger.Error("Failed to check schedule command", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		}
+		return
+	}
+
+	parameters, _ := json.Marshal(schedule.Parameters)
+	err = s.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO command_schedules (tenant_id, name, cron, timezone, command, parameters,
+			device_id, device_type, ward, zone, misfire_policy, enabled, next_run_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''), $11, $12, $13, $14)
+		RETURNING id, created_at, updated_at
+	`,
+		schedule.TenantID,
+		schedule.Name,
+		schedule.Cron,
+		schedule.Timezone,
+		schedule.Command,
+		parameters,
+		schedule.DeviceID,
+		schedule.DeviceType,
+		schedule.Ward,
+		schedule.Zone,
+		schedule.MisfirePolicy,
+		schedule.Enabled,
+		schedule.NextRunAt,
+		schedule.CreatedBy,
+	).Scan(&schedule.ID, &schedule.CreatedAt, &schedule.UpdatedAt)
+	if err != nil {
+		s.logger.Error("Failed to create schedule", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"schedule": schedule,
+		"message":  "Schedule created successfully",
+	})
+}
+
+// UpdateSchedule replaces a schedule's definition. The next run is
+// recomputed from now, so a changed cron expression takes effect at once.
+func (s *Service) UpdateSchedule(c *gin.Context) {
+	var req scheduleRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	schedule, err := req.schedule()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantID := middleware.TenantID(c)
+
+	if schedule.DeviceID != "" && !s.deviceInTenant(c.Request.Context(), tenantID, schedule.DeviceID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device not found"})
+		return
+	}
+	if err := s.checkScheduleCommand(c.Request.Context(), schedule); err != nil {
+		if !invalidCommand(c, err) {
+			s.logger.Error("Failed to check schedule command", "error", err, "schedule_id", c.Param("id"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		}
+		return
+	}
+
+	parameters, _ := json.Marshal(schedule.Parameters)
+	result, err := s.db.ExecContext(c.Request.Context(), `
+		UPDATE command_schedules
+		SET name = $1, cron = $2, timezone = $3, command = $4, parameters = $5,
+			device_id = NULLIF($6, ''), device_type = NULLIF($7, ''), ward = NULLIF($8, ''), zone = NULLIF($9, ''),
+			misfire_policy = $10, enabled = $11, next_run_at = $12
+		WHERE id::text = $13 AND tenant_id = $14
+	`,
+		schedule.Name,
+		schedule.Cron,
+		schedule.Timezone,
+		schedule.Command,
+		parameters,
+		schedule.DeviceID,
+		schedule.DeviceType,
+		schedule.Ward,
+		schedule.Zone,
+		schedule.MisfirePolicy,
+		schedule.Enabled,
+		schedule.NextRunAt,
+		c.Param("id"),
+		tenantID,
+	)
+	if err != nil {
+		s.logger.Error("Failed to update schedule", "error", err, "schedule_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	updated, err := s.loadSchedule(c.Request.Context(), tenantID, c.Param("id"))
+	if err != nil {
+		s.logger.Error("Failed to reload schedule", "error", err, "schedule_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedule": updated,
+		"message":  "Schedule updated successfully",
+	})
+}
+
+func (s *Service) DeleteSchedule(c *gin.Context) {
+	result, err := s.db.ExecContext(c.Request.Context(),
+		`DELETE FROM command_schedules WHERE id::text = $1 AND tenant_id = $2`,
+		c.Param("id"), middleware.TenantID(c),
+	)
+	if err != nil {
+		s.logger.Error("Failed to delete schedule", "error", err, "schedule_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+func (s *Service) deviceInTenant(ctx context.Context, tenantID, deviceID string) bool {
+	var exists bool
+	s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM devices WHERE id = $1 AND tenant_id = $2)`,
+		deviceID, tenantID,
+	).Scan(&exists)
+	return exists
+}
+
+func (s *Service) loadSchedule(ctx context.Context, tenantID, scheduleID string) (*models.CommandSchedule, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+scheduleColumns+` FROM command_schedules WHERE id::text = $1 AND tenant_id = $2`,
+		scheduleID, tenantID,
+	)
+	return scanSchedule(row)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSchedule(row rowScanner) (*models.CommandSchedule, error) {
+	var schedule models.CommandSchedule
+	var parameters []byte
+
+	if err := row.Scan(
+		&schedule.ID,
+		&schedule.TenantID,
+		&schedule.Name,
+		&schedule.Cron,
+		&schedule.Timezone,
+		&schedule.Command,
+		&parameters,
+		&schedule.DeviceID,
+		&schedule.DeviceType,
+		&schedule.Ward,
+		&schedule.Zone,
+		&schedule.MisfirePolicy,
+		&schedule.Enabled,
+		&schedule.NextRunAt,
+		&schedule.LastRunAt,
+		&schedule.CreatedBy,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(parameters, &schedule.Parameters); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// runSchedules fires due schedules. A schedule is claimed by marking it
+// running, so a slow run is never overlapped by its next slot and several
+// instances never fire the same slot twice.
+func (s *Service) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(schedulePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				ran, err := s.runDueSchedule(ctx)
+				if err != nil {
+					s.logger.Error("Failed to run command schedule", "error", err)
+					break
+				}
+				if !ran {
+					break
+				}
+			}
+		}
+	}
+}
+
+func (s *Service) runDueSchedule(ctx context.Context) (bool, error) {
+	schedule, err := s.claimDueSchedule(ctx)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	misfired := now.Sub(*schedule.NextRunAt) > misfireThreshold
+	fire := !misfired || schedule.MisfirePolicy == MisfireFireOnce
+
+	if misfired {
+		s.logger.Warn("Command schedule misfired", "schedule_id", schedule.ID,
+			"due", *schedule.NextRunAt, "policy", schedule.MisfirePolicy)
+	}
+
+	if fire {
+		dispatched, failed, err := s.dispatchScheduledCommand(ctx, schedule)
+		if err != nil {
+			s.logger.Error("Failed to dispatch scheduled command", "error", err, "schedule_id", schedule.ID)
+		} else {
+			s.logger.Info("Dispatched scheduled command", "schedule_id", schedule.ID,
+				"command", schedule.Command, "devices", dispatched, "failed", failed)
+		}
+	}
+
+	// Missed slots are never replayed one by one; the schedule resumes from now
+	next, err := nextRun(schedule.Cron, schedule.Timezone, now)
+	if err != nil {
+		s.logger.Error("Failed to compute next schedule run", "error", err, "schedule_id", schedule.ID)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE command_schedules
+		SET running_since = NULL, next_run_at = $1, last_run_at = CASE WHEN $2 THEN NOW() ELSE last_run_at END
+		WHERE id = $3
+	`, next, fire, schedule.ID)
+	return true, err
+}
+
+func (s *Service) claimDueSchedule(ctx context.Context) (*models.CommandSchedule, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+		SELECT `+scheduleColumns+` FROM command_schedules
+		WHERE enabled AND next_run_at <= NOW()
+			AND (running_since IS NULL OR running_since < NOW() - $1 * INTERVAL '1 second')
+		ORDER BY next_run_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, scheduleRunTimeout.Seconds())
+	schedule, err := scanSchedule(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE command_schedules SET running_since = NOW() WHERE id = $1`, schedule.ID); err != nil {
+		return nil, err
+	}
+
+	return schedule, tx.Commit()
+}
+
+// dispatchScheduledCommand publishes the command for every active device
+// the schedule targets. Devices being installed, serviced or retired are
+// left alone, and devices whose type doesn't accept the command count as
+// failed.
+func (s *Service) dispatchScheduledCommand(ctx context.Context, schedule *models.CommandSchedule) (int, int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id FROM devices
+		WHERE tenant_id = $1 AND status = $2
+			AND ($3 = '' OR id = $3)
+			AND ($4 = '' OR type = $4)
+			AND ($5 = '' OR ward = $5)
+			AND ($6 = '' OR zone = $6)
+	`, schedule.TenantID, devicelifecycle.Active, schedule.DeviceID, schedule.DeviceType, schedule.Ward, schedule.Zone)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	var deviceIDs []string
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return 0, 0, err
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	topic := s.config.Kafka.Topics.Commands
+	if topic == "" {
+		topic = "device-commands"
+	}
+
+	dispatched, failed := 0, 0
+	for _, deviceID := range deviceIDs {
+		device, err := s.resolveDevice(deviceID)
+		if err == nil {
+			err = s.checkCommand(device.deviceType, schedule.Command, schedule.Parameters)
+		}
+		if err != nil {
+			s.logger.Error("Not sending scheduled command", "error", err, "device_id", deviceID, "schedule_id", schedule.ID)
+			failed++
+			continue
+		}
+
+		message, _ := json.Marshal(models.DeviceCommand{
+			DeviceID:   deviceID,
+			Command:    schedule.Command,
+			Parameters: schedule.Parameters,
+			Status:     CommandPending,
+			Timestamp:  time.Now(),
+			IssuedBy:   schedule.CreatedBy,
+		})
+		if err := s.producer.ProduceMessage(topic, deviceID, message); err != nil {
+			s.logger.Error("Failed to publish scheduled command", "error", err, "device_id", deviceID)
+			failed++
+			continue
+		}
+		dispatched++
+	}
+
+	return dispatched, failed, nil
+}
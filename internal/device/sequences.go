@@ -0,0 +1,231 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+const (
+	CommandPending  = "pending"
+	CommandExecuted = "executed"
+	CommandFailed   = "failed"
+	CommandSkipped  = "skipped"
+
+	SequencePending   = "pending"
+	SequenceRunning   = "running"
+	SequenceCompleted = "completed"
+	SequenceFailed    = "failed"
+
+	sequencePollInterval = time.Second
+)
+
+func (s *Service) createSequence(ctx context.Context, tenantID, deviceID, template, actorID string, commands []models.DeviceCommand) (*models.CommandSequence, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sequence := &models.CommandSequence{
+		TenantID:   tenantID,
+		DeviceID:   deviceID,
+		Template:   template,
+		Status:     SequencePending,
+		TotalSteps: len(commands),
+		CreatedBy:  actorID,
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO command_sequences (tenant_id, device_id, template, status, total_steps, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, tenantID, deviceID, template, SequencePending, len(commands), actorID).Scan(&sequence.ID, &sequence.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range commands {
+		commands[i].SequenceID = sequence.ID
+		parameters, _ := json.Marshal(commands[i].Parameters)
+
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO device_commands (device_id, command, parameters, status, sequence_id, step, issued_by)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid)
+			RETURNING id, timestamp
+		`, deviceID, commands[i].Command, parameters, commands[i].Status, sequence.ID, commands[i].Step, actorID).Scan(&commands[i].ID, &commands[i].Timestamp)
+		commands[i].IssuedBy = actorID
+		if err != nil {
+			return nil, err
+		}
+	}
+	sequence.Steps = commands
+
+	return sequence, tx.Commit()
+}
+
+func (s *Service) loadSequence(ctx context.Context, tenantID, deviceID, sequenceID string) (*models.CommandSequence, error) {
+	var sequence models.CommandSequence
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, tenant_id, device_id, template, status, current_step, total_steps, created_by::text, created_at, completed_at
+		FROM command_sequences
+		WHERE id::text = $1 AND device_id = $2 AND tenant_id = $3
+	`, sequenceID, deviceID, tenantID).Scan(
+		&sequence.ID,
+		&sequence.TenantID,
+		&sequence.DeviceID,
+		&sequence.Template,
+		&sequence.Status,
+		&sequence.CurrentStep,
+		&sequence.TotalSteps,
+		&sequence.CreatedBy,
+		&sequence.CreatedAt,
+		&sequence.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, command, parameters, step, status, timestamp
+		FROM device_commands
+		WHERE sequence_id = $1
+		ORDER BY step
+	`, sequence.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		command := models.DeviceCommand{DeviceID: sequence.DeviceID, SequenceID: sequence.ID}
+		var parameters []byte
+		if err := rows.Scan(&command.ID, &command.Command, &parameters, &command.Step, &command.Status, &command.Timestamp); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(parameters, &command.Parameters)
+		sequence.Steps = append(sequence.Steps, command)
+	}
+
+	return &sequence, rows.Err()
+}
+
+// runCommandSequences advances due sequences one step at a time. Progress
+// lives in the database, so waits survive restarts and several instances
+// can share the work.
+func (s *Service) runCommandSequences(ctx context.Context) {
+	ticker := time.NewTicker(sequencePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				advanced, err := s.advanceSequence(ctx)
+				if err != nil {
+					s.logger.Error("Failed to advance command sequence", "error", err)
+					break
+				}
+				if !advanced {
+					break
+				}
+			}
+		}
+	}
+}
+
+// advanceSequence runs the next step of one due sequence. It reports false
+// when nothing is due.
+func (s *Service) advanceSequence(ctx context.Context) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var sequenceID string
+	var currentStep, totalSteps int
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, current_step, total_steps FROM command_sequences
+		WHERE status IN ($1, $2) AND next_step_at <= NOW()
+		ORDER BY next_step_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, SequencePending, SequenceRunning).Scan(&sequenceID, &currentStep, &totalSteps)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var command models.DeviceCommand
+	var parameters []byte
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, device_id, command, parameters FROM device_commands
+		WHERE sequence_id = $1 AND step = $2
+	`, sequenceID, currentStep+1).Scan(&command.ID, &command.DeviceID, &command.Command, &parameters)
+	if err != nil {
+		return false, err
+	}
+	json.Unmarshal(parameters, &command.Parameters)
+
+	delay := time.Duration(0)
+	status := CommandExecuted
+	if command.Command == CommandWait {
+		seconds, _ := command.Parameters["seconds"].(float64)
+		delay = time.Duration(seconds * float64(time.Second))
+	} else if err := s.dispatchCommand(&command); err != nil {
+		s.logger.Error("Command sequence step failed", "error", err, "sequence_id", sequenceID, "step", currentStep+1)
+		status = CommandFailed
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE device_commands SET status = $1, timestamp = NOW() WHERE id = $2`,
+		status, command.ID,
+	); err != nil {
+		return false, err
+	}
+
+	if status == CommandFailed {
+		// Later steps assume the earlier ones took effect, so they never run
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE device_commands SET status = $1 WHERE sequence_id = $2 AND step > $3`,
+			CommandSkipped, sequenceID, currentStep+1,
+		); err != nil {
+			return false, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE command_sequences SET status = $1, current_step = $2, completed_at = NOW()
+			WHERE id = $3
+		`, SequenceFailed, currentStep+1, sequenceID)
+	} else if currentStep+1 == totalSteps {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE command_sequences SET status = $1, current_step = $2, completed_at = NOW()
+			WHERE id = $3
+		`, SequenceCompleted, totalSteps, sequenceID)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE command_sequences SET status = $1, current_step = $2, next_step_at = NOW() + $3 * INTERVAL '1 second'
+			WHERE id = $4
+		`, SequenceRunning, currentStep+1, delay.Seconds(), sequenceID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// dispatchCommand delivers one command to its device.
+func (s *Service) dispatchCommand(command *models.DeviceCommand) error {
+	// In a real implementation, this would send the command to the actual
+	// device; sequence steps are recorded by the caller
+	s.logger.Info("Dispatching device command", "device_id", command.DeviceID, "command", command.Command)
+	return nil
+}
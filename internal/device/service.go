@@ -2,14 +2,31 @@ package device
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 	
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
 	"github.com/bhanukaranwal/urbanzen/pkg/logger"
 	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/events"
 	"github.com/bhanukaranwal/urbanzen/pkg/kafka"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+	"github.com/bhanukaranwal/urbanzen/internal/baseline"
+	"github.com/bhanukaranwal/urbanzen/internal/breachwindow"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/internal/devicestatus"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/flags"
+	"github.com/bhanukaranwal/urbanzen/internal/geofence"
 	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/residency"
+	"github.com/bhanukaranwal/urbanzen/internal/subscription"
+	"github.com/bhanukaranwal/urbanzen/internal/telemetryschema"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+	"github.com/bhanukaranwal/urbanzen/internal/totalizer"
 )
 
 type Service struct {
@@ -17,38 +34,171 @@ type Service struct {
 	tsdb     *database.TimescaleDB
 	producer *kafka.Producer
 	consumer *kafka.Consumer
+	bus      *events.Bus
+	
+	// Reads device-commands; each consume loop has its own consumer
+	commands *kafka.Consumer
+	tenants  *tenant.Store
+	statuses *devicestatus.Store
+	types    *devicetype.Store
+	config   *config.Config
 	logger   logger.Logger
+	
+	// Last reading of each cumulative register, for reset detection
+	totalizers *totalizer.Tracker
+	
+	// Recent threshold breaches, for thresholds with a window
+	windows *breachwindow.Windows
+	
+	// Areas mobile devices must stay within
+	fences *geofence.Checker
+	
+	// Learned per-device baselines for the z-score detector
+	baselines *baseline.Store
+	
+	// Rollouts of detectors still being tuned
+	flags *flags.Service
+	
+	// Versioned metric contracts readings are checked against
+	schemas *telemetryschema.Store
+	
+	// Users' alerts on device metrics
+	subscriptions *subscription.Checker
+	
+	// Regions callers may reach, for endpoints that return telemetry
+	regions *residency.Store
+	
+	// Bounded hand-off between HTTP intake and the processors, one queue
+	// per worker, sharded by device so each device's readings are
+	// processed in order. Kafka batches are processed as they are polled.
+	queues []chan *models.DeviceData
+	
+	// device ID -> registeredDevice
+	devices *cache.Cache[string, registeredDevice]
+	
+	// Open downsampling windows for types with a sampling policy
+	sampler *sampler
+	
+	// Unregistered IDs rejected since the last reconciliation flush
+	unregistered unregisteredTally
+	
+	// Background work started by Start
+	workers sync.WaitGroup
 }
 
 func NewService(db *database.PostgresDB, tsdb *database.TimescaleDB, 
-	producer *kafka.Producer, consumer *kafka.Consumer, log logger.Logger) *Service {
+	producer *kafka.Producer, consumer, commands *kafka.Consumer, tenants *tenant.Store,
+	statuses *devicestatus.Store, types *devicetype.Store, totalizers *totalizer.Tracker,
+	windows *breachwindow.Windows, fences *geofence.Checker, baselines *baseline.Store, schemas *telemetryschema.Store,
+	subscriptions *subscription.Checker, regions *residency.Store, featureFlags *flags.Service, cfg *config.Config,
+	log logger.Logger) *Service {
+	capacity := cfg.Devices.Ingestion.QueueCapacity
+	if capacity <= 0 {
+		capacity = defaultQueueCapacity
+	}
+	
+	workers := ingestionWorkers(cfg)
+	queues := make([]chan *models.DeviceData, workers)
+	for i := range queues {
+		queues[i] = make(chan *models.DeviceData, (capacity+workers-1)/workers)
+	}
+	
 	return &Service{
 		db:       db,
 		tsdb:     tsdb,
 		producer: producer,
 		consumer: consumer,
+		commands: commands,
+		bus:      events.New(producer, cfg, log),
+		tenants:  tenants,
+		statuses: statuses,
+		types:    types,
+		config:   cfg,
 		logger:   log,
+		queues:   queues,
+		sampler:  newSampler(),
+		devices:  cache.New[string, registeredDevice](devicesCacheName, devicesCacheTTL, devicesCacheMaxEntries),
+		
+		totalizers: totalizers,
+		windows:    windows,
+		fences:     fences,
+		baselines:  baselines,
+		flags:      featureFlags,
+		schemas:    schemas,
+		
+		subscriptions: subscriptions,
+		regions:       regions,
 	}
 }
 
+// SyncWith clears the service's registered-device cache when another
+// process reports a change to the devices it holds.
+func (s *Service) SyncWith(syncer *cache.Syncer) {
+	syncer.Watch(s.devices)
+}
+
 func (s *Service) Start(ctx context.Context) error {
+	registerQueueMetrics(s.queues)
+	
+	// Start ingestion workers, one per queue
+	for _, queue := range s.queues {
+		queue := queue
+		s.run(ctx, func(ctx context.Context) { s.processQueue(ctx, queue) })
+	}
+	
 	// Start consuming device data
-	go s.consumeDeviceData(ctx)
+	s.run(ctx, s.consumeDeviceData)
 	
 	// Start device health monitoring
-	go s.monitorDeviceHealth(ctx)
+	s.run(ctx, s.monitorDeviceHealth)
 	
 	// Start command processing
-	go s.processCommands(ctx)
+	s.run(ctx, s.processCommands)
+	s.run(ctx, s.runCommandSequences)
+	s.run(ctx, s.runSchedules)
+	
+	// Start telemetry replays
+	s.run(ctx, s.runReplays)
+	
+	// Start flushing downsampled telemetry
+	s.run(ctx, s.flushSamples)
+	
+	if s.config.Devices.Baselines.Enabled {
+		s.run(ctx, s.learnBaselines)
+	}
+	
+	// Start reconciling the registry against telemetry
+	s.run(ctx, s.runReconciliation)
 	
 	s.logger.Info("Device service started")
 	
+	// Queued readings are processed before Start returns
 	<-ctx.Done()
+	s.workers.Wait()
+	
+	// Write out partial windows; later readings for the same bucket merge
+	// into the stored row
+	s.writeSamples(s.sampler.closed(time.Time{}))
+	
+	s.logger.Info("Device service stopped")
 	return nil
 }
 
+// run starts background work that Start waits for before returning.
+func (s *Service) run(ctx context.Context, work func(context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		work(ctx)
+	}()
+}
+
 func (s *Service) consumeDeviceData(ctx context.Context) {
-	topics := []string{"device-data", "device-telemetry"}
+	protobufTopic := s.config.Kafka.Topics.DeviceDataProtobuf
+	if protobufTopic == "" {
+		protobufTopic = "device-telemetry-pb"
+	}
+	topics := []string{"device-data", "device-telemetry", protobufTopic}
 	
 	for {
 		select {
@@ -60,42 +210,131 @@ func (s *Service) consumeDeviceData(ctx context.Context) {
 				s.logger.Error("Failed to consume messages", "error", err)
 				continue
 			}
+			if len(messages) == 0 {
+				continue
+			}
 			
-			for _, msg := range messages {
-				s.processDeviceMessage(msg)
+			// Messages are keyed by device, so each device's readings are
+			// processed in order. The next poll waits for the whole batch,
+			// which throttles consumption to the processing rate. Messages
+			// that fail are read again; undecodable ones are dead-lettered.
+			err = s.consumer.HandleBatch(messages, s.config.Kafka.Consumer.Concurrency, s.producer, func(msg *kafka.Message) error {
+				return s.processKafkaMessage(msg, protobufTopic)
+			})
+			if err != nil {
+				s.logger.Error("Failed to commit consumed offsets", "error", err)
 			}
 		}
 	}
 }
 
-func (s *Service) processDeviceMessage(msg *kafka.Message) {
-	var deviceData models.DeviceData
-	if err := json.Unmarshal(msg.Value, &deviceData); err != nil {
-		s.logger.Error("Failed to unmarshal device data", "error", err)
-		return
+// processKafkaMessage handles every reading in a message. It fails if any
+// reading couldn't be stored, so the message is read again; the readings
+// already stored are then dropped as duplicates.
+func (s *Service) processKafkaMessage(msg *kafka.Message, protobufTopic string) error {
+	readings, err := decodeMessage(msg.Value, msg.Topic == protobufTopic)
+	if err != nil {
+		s.logger.Error("Failed to decode device data", "error", err, "topic", msg.Topic)
+		metrics.IngestMessages.WithLabelValues(metrics.IngestInvalid).Inc()
+		return kafka.Poison(err)
+	}
+	
+	failed := 0
+	for _, data := range readings {
+		metrics.ObserveLag("device-service", msg.Topic, data.Timestamp)
+		outcome := s.processDeviceMessage(data)
+		metrics.IngestMessages.WithLabelValues(outcome).Inc()
+		if outcome == metrics.IngestFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d readings failed", failed, len(readings))
 	}
+	return nil
+}
+
+// processDeviceMessage handles one decoded telemetry message and returns
+// its outcome for the ingestion SLO metrics.
+func (s *Service) processDeviceMessage(message *models.DeviceData) string {
+	deviceData := *message
 	
 	// Validate device data
 	if err := s.validateDeviceData(&deviceData); err != nil {
 		s.logger.Error("Invalid device data", "error", err, "device_id", deviceData.DeviceID)
-		return
+		return metrics.IngestInvalid
 	}
 	
-	// Store in TimescaleDB
-	if err := s.storeDeviceData(&deviceData); err != nil {
-		s.logger.Error("Failed to store device data", "error", err)
-		return
+	// Telemetry is attributed to the tenant, type and region the device is
+	// registered under, never to whatever the payload claims
+	device, err := s.resolveDevice(deviceData.DeviceID)
+	if err == sql.ErrNoRows {
+		s.logger.Error("Rejecting data from unregistered device", "device_id", deviceData.DeviceID)
+		s.unregistered.add(&deviceData)
+		return metrics.IngestInvalid
+	}
+	if err != nil {
+		s.logger.Error("Failed to resolve device", "error", err, "device_id", deviceData.DeviceID)
+		return metrics.IngestFailed
+	}
+	deviceData.TenantID = device.tenantID
+	deviceData.DeviceType = device.deviceType
+	deviceData.Region = device.region
+	
+	if err := s.normalizeUnits(&deviceData); err != nil {
+		s.logger.Error("Rejecting data with unconvertible units", "error", err, "device_id", deviceData.DeviceID)
+		return metrics.IngestInvalid
 	}
 	
+	if err := s.checkTelemetrySchema(&deviceData); err != nil {
+		if _, ok := err.(*telemetryschema.ReadingError); ok {
+			s.logger.Error("Rejecting data that breaks the telemetry schema", "error", err, "device_id", deviceData.DeviceID)
+			return metrics.IngestInvalid
+		}
+		s.logger.Error("Failed to load telemetry schema", "error", err, "type", deviceData.DeviceType)
+		return metrics.IngestFailed
+	}
+	
+	// Store in TimescaleDB, downsampled if the type has a sampling policy
+	deviceType, err := s.deviceType(deviceData.DeviceType)
+	if err != nil {
+		s.logger.Error("Failed to load device type", "error", err, "type", deviceData.DeviceType)
+		return metrics.IngestFailed
+	}
+	if policy := deviceType.Sampling; policy != nil {
+		s.sampler.add(&deviceData, policy.IntervalSeconds, s.tenantLocation(deviceData.TenantID))
+	}
+	if deviceType.Sampling == nil || deviceType.Sampling.StoreRaw {
+		if err := s.storeDeviceData(&deviceData); err != nil {
+			s.logger.Error("Failed to store device data", "error", err)
+			return metrics.IngestFailed
+		}
+	}
+	
+	s.updateLatestStatus(&deviceData)
+	
+	if len(deviceType.Totalizers) > 0 {
+		s.checkTotalizers(&deviceData, deviceType.Totalizers)
+	}
+	
+	s.checkGeofence(&deviceData)
+	s.checkSubscriptions(&deviceData)
+	
 	// Process analytics
 	s.processAnalytics(&deviceData)
 	
 	// Check for anomalies
-	if anomaly := s.detectAnomaly(&deviceData); anomaly != nil {
+	if anomaly := s.detectAnomaly(&deviceData, breachwindow.Live); anomaly != nil {
 		s.handleAnomaly(anomaly)
 	}
+	// Baseline judging is rolled out per tenant behind baseline_anomalies
+	flagged := flags.WithTarget(context.Background(), deviceData.TenantID, deviceData.DeviceID)
+	if s.config.Devices.Baselines.Enabled && s.flags.Enabled(flagged, "baseline_anomalies") {
+		s.checkBaselines(&deviceData)
+	}
 	
 	s.logger.Debug("Processed device data", "device_id", deviceData.DeviceID)
+	return metrics.IngestProcessed
 }
 
 func (s *Service) validateDeviceData(data *models.DeviceData) error {
@@ -116,8 +355,8 @@ func (s *Service) validateDeviceData(data *models.DeviceData) error {
 
 func (s *Service) storeDeviceData(data *models.DeviceData) error {
 	query := `
-		INSERT INTO device_telemetry (device_id, timestamp, device_type, location, metrics, metadata)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO device_telemetry (device_id, tenant_id, timestamp, device_type, location, metrics, metadata, region)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
 	`
 	
 	metricsJSON, _ := json.Marshal(data.Metrics)
@@ -125,20 +364,86 @@ func (s *Service) storeDeviceData(data *models.DeviceData) error {
 	
 	_, err := s.tsdb.Exec(query, 
 		data.DeviceID, 
+		data.TenantID, 
 		data.Timestamp, 
 		data.DeviceType, 
 		fmt.Sprintf("POINT(%f %f)", data.Location.Longitude, data.Location.Latitude),
 		metricsJSON,
 		metadataJSON,
+		data.Region,
 	)
 	
 	return err
 }
 
+// Registered devices are cached by ID. Nothing moves a device to another
+// tenant, type or region today; whatever does must bump devicesCacheName
+// through a cache.Syncer, or running services see the move only once
+// devicesCacheTTL passes.
+const (
+	devicesCacheName       = "devices"
+	devicesCacheTTL        = 5 * time.Minute
+	devicesCacheMaxEntries = 100000
+)
+
+type registeredDevice struct {
+	tenantID   string
+	deviceType string
+	region     string
+}
+
+func (s *Service) resolveDevice(deviceID string) (registeredDevice, error) {
+	return s.devices.Load(deviceID, func() (registeredDevice, error) {
+		var device registeredDevice
+		err := s.db.QueryRow(`SELECT tenant_id, type, COALESCE(region, '') FROM devices WHERE id = $1`, deviceID).Scan(
+			&device.tenantID, &device.deviceType, &device.region)
+		return device, err
+	})
+}
+
+// updateLatestStatus refreshes the device's cached status so dashboards can
+// read it without querying telemetry.
+func (s *Service) updateLatestStatus(data *models.DeviceData) {
+	update := &devicestatus.Update{
+		Connectivity: devicestatus.ConnectivityOnline,
+		LastSeen:     data.Timestamp,
+		Battery:      numericField(data, "battery_level"),
+		Signal:       numericField(data, "signal_strength"),
+		Metrics:      make(map[string]float64, len(data.Metrics)),
+	}
+	
+	for metric, value := range data.Metrics {
+		if numeric, ok := value.(float64); ok {
+			update.Metrics[metric] = numeric
+		}
+	}
+	
+	change, err := s.statuses.Update(context.Background(), data.DeviceID, update)
+	if err != nil {
+		s.logger.Error("Failed to update device status", "error", err, "device_id", data.DeviceID)
+		return
+	}
+	if change != nil {
+		s.publishConnectivityChange(data.TenantID, data.DeviceID, change, data.Timestamp)
+	}
+}
+
+// numericField reads a device health value, which firmware reports either
+// as a metric or in the message metadata.
+func numericField(data *models.DeviceData, name string) *float64 {
+	for _, values := range []map[string]interface{}{data.Metrics, data.Metadata} {
+		if numeric, ok := values[name].(float64); ok {
+			return &numeric
+		}
+	}
+	return nil
+}
+
 func (s *Service) processAnalytics(data *models.DeviceData) {
 	// Send to analytics service for processing
 	analyticsData := map[string]interface{}{
 		"device_id":   data.DeviceID,
+		"tenant_id":   data.TenantID,
 		"device_type": data.DeviceType,
 		"timestamp":   data.Timestamp,
 		"metrics":     data.Metrics,
@@ -149,53 +454,80 @@ func (s *Service) processAnalytics(data *models.DeviceData) {
 	s.producer.ProduceMessage("analytics-data", data.DeviceID, message)
 }
 
-func (s *Service) detectAnomaly(data *models.DeviceData) *models.Anomaly {
-	// Simple anomaly detection based on thresholds
+// tenantLocation returns the time zone the tenant's rollups align to,
+// falling back to UTC if its config can't be resolved.
+func (s *Service) tenantLocation(tenantID string) *time.Location {
+	tenantConfig, err := s.tenants.Resolve(context.Background(), tenantID)
+	if err != nil {
+		s.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", tenantID)
+		return time.UTC
+	}
+	return tenantConfig.Location()
+}
+
+// detectAnomaly checks a reading against the tenant's thresholds. Windowed
+// thresholds count recent breaches in scope: breachwindow.Live for
+// incoming telemetry, or a replay's own scope. Every windowed metric is
+// recorded, even once an anomaly has been found, so windows stay complete.
+func (s *Service) detectAnomaly(data *models.DeviceData, scope string) *models.Anomaly {
+	// Thresholds come from the tenant's config so each city can tune them
+	tenantConfig, err := s.tenants.Resolve(context.Background(), data.TenantID)
+	if err != nil {
+		s.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", data.TenantID)
+		return nil
+	}
+	
+	var anomaly *models.Anomaly
 	for metric, value := range data.Metrics {
-		switch data.DeviceType {
-		case "water_sensor":
-			if metric == "flow_rate" && value.(float64) > 1000 {
-				return &models.Anomaly{
-					DeviceID:    data.DeviceID,
-					Type:        "high_flow_rate",
-					Severity:    "critical",
-					Description: "Extremely high water flow rate detected",
-					Timestamp:   time.Now(),
-					Value:       value,
-				}
+		threshold, exists := tenantConfig.Threshold(data.DeviceType, metric)
+		if !exists {
+			continue
+		}
+		
+		numeric, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		
+		breached := numeric > threshold.Max
+		if needed, window := threshold.Required(); window > 1 {
+			breaches, err := s.windows.Record(context.Background(), scope, data.DeviceID, metric, window, breached)
+			if err != nil {
+				// Judge the sample alone: noisier, but nothing is missed
+				metrics.RedisFallbacks.WithLabelValues("anomaly_window").Inc()
+				s.logger.Warn("Evaluating threshold without its window", "error", err, "device_id", data.DeviceID, "metric", metric)
+			} else {
+				breached = breached && breaches >= needed
 			}
-		case "electricity_meter":
-			if metric == "current" && value.(float64) > 100 {
-				return &models.Anomaly{
-					DeviceID:    data.DeviceID,
-					Type:        "high_current",
-					Severity:    "warning",
-					Description: "High electrical current detected",
-					Timestamp:   time.Now(),
-					Value:       value,
-				}
+		}
+		
+		if breached && anomaly == nil {
+			anomaly = &models.Anomaly{
+				DeviceID:    data.DeviceID,
+				Type:        threshold.Type,
+				Severity:    threshold.Severity,
+				Description: threshold.Description,
+				Timestamp:   data.Timestamp,
+				Metric:      metric,
+				Value:       value,
 			}
 		}
 	}
 	
-	return nil
+	return anomaly
 }
 
 func (s *Service) handleAnomaly(anomaly *models.Anomaly) {
 	// Store anomaly
-	s.storeAnomaly(anomaly)
-	
-	// Send alert
-	alert := map[string]interface{}{
-		"type":        "anomaly_detected",
-		"device_id":   anomaly.DeviceID,
-		"severity":    anomaly.Severity,
-		"description": anomaly.Description,
-		"timestamp":   anomaly.Timestamp,
+	stored, err := s.storeAnomaly(anomaly)
+	if err != nil {
+		s.logger.Error("Failed to store anomaly", "error", err, "device_id", anomaly.DeviceID)
+	} else if !stored {
+		// Already recorded from an earlier delivery of the same reading
+		return
 	}
 	
-	message, _ := json.Marshal(alert)
-	s.producer.ProduceMessage("alerts", anomaly.DeviceID, message)
+	s.publishAnomalyAlert(anomaly, false)
 	
 	s.logger.Warn("Anomaly detected", 
 		"device_id", anomaly.DeviceID,
@@ -204,13 +536,40 @@ func (s *Service) handleAnomaly(anomaly *models.Anomaly) {
 	)
 }
 
-func (s *Service) storeAnomaly(anomaly *models.Anomaly) error {
+func (s *Service) publishAnomalyAlert(anomaly *models.Anomaly, replayed bool) {
+	device, _ := s.resolveDevice(anomaly.DeviceID)
+	events.Publish(context.Background(), s.bus, events.AnomalyAlert{
+		Type:        events.AlertAnomalyDetected,
+		DeviceID:    anomaly.DeviceID,
+		TenantID:    device.tenantID,
+		Severity:    anomaly.Severity,
+		Description: anomaly.Description,
+		Timestamp:   anomaly.Timestamp,
+		Metric:      anomaly.Metric,
+		Value:       anomaly.Value,
+		Replayed:    replayed,
+	})
+	
+	s.recordAlert(device.tenantID, events.AlertAnomalyDetected, anomaly.Severity, "Anomaly detected",
+		anomaly.Description, anomaly.DeviceID, map[string]interface{}{
+			"anomaly_type": anomaly.Type,
+			"metric":       anomaly.Metric,
+			"value":        anomaly.Value,
+			"timestamp":    anomaly.Timestamp,
+		})
+}
+
+// storeAnomaly records an anomaly once per device, type, metric and
+// reading time.
+// It reports false if the anomaly was already recorded.
+func (s *Service) storeAnomaly(anomaly *models.Anomaly) (bool, error) {
 	query := `
-		INSERT INTO anomalies (device_id, type, severity, description, timestamp, value, metadata)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO anomalies (device_id, type, severity, description, timestamp, value, metadata, metric)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+		ON CONFLICT (device_id, type, COALESCE(metric, ''), timestamp) DO NOTHING
 	`
 	
-	_, err := s.db.Exec(query,
+	result, err := s.db.Exec(query,
 		anomaly.DeviceID,
 		anomaly.Type,
 		anomaly.Severity,
@@ -218,9 +577,14 @@ func (s *Service) storeAnomaly(anomaly *models.Anomaly) error {
 		anomaly.Timestamp,
 		anomaly.Value,
 		"{}",
+		anomaly.Metric,
 	)
+	if err != nil {
+		return false, err
+	}
 	
-	return err
+	affected, err := result.RowsAffected()
+	return affected > 0, err
 }
 
 func (s *Service) monitorDeviceHealth(ctx context.Context) {
@@ -232,46 +596,136 @@ func (s *Service) monitorDeviceHealth(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			s.checkDeviceHealth()
+			s.checkDeviceHealth(ctx)
 		}
 	}
 }
 
-func (s *Service) checkDeviceHealth() {
-	// Check for devices that haven't sent data recently
+type deviceHealth struct {
+	tenantID     string
+	lastSeen     time.Time
+	offline      bool
+	connectivity string
+}
+
+func (s *Service) checkDeviceHealth(ctx context.Context) {
+	// Refresh connectivity for every reporting device and alert on the
+	// ones that haven't sent data recently
 	query := `
-		SELECT device_id, MAX(timestamp) as last_seen
+		SELECT device_id, tenant_id, MAX(timestamp) as last_seen
 		FROM device_telemetry
-		GROUP BY device_id
-		HAVING MAX(timestamp) < NOW() - INTERVAL '10 minutes'
+		GROUP BY device_id, tenant_id
 	`
+	offlineAfter := time.Now().Add(-10 * time.Minute)
 	
-	rows, err := s.tsdb.Query(query)
+	rows, err := s.tsdb.QueryContext(ctx, query)
 	if err != nil {
 		s.logger.Error("Failed to check device health", "error", err)
 		return
 	}
 	defer rows.Close()
 	
+	health := make(map[string]*deviceHealth)
 	for rows.Next() {
 		var deviceID string
-		var lastSeen time.Time
+		device := &deviceHealth{connectivity: devicestatus.ConnectivityOnline}
 		
-		if err := rows.Scan(&deviceID, &lastSeen); err != nil {
+		if err := rows.Scan(&deviceID, &device.tenantID, &device.lastSeen); err != nil {
+			continue
+		}
+		
+		if device.lastSeen.Before(offlineAfter) {
+			device.offline = true
+			device.connectivity = devicestatus.ConnectivityOffline
+		}
+		health[deviceID] = device
+	}
+	
+	parents, err := s.loadParents(ctx)
+	if err != nil {
+		// Shutting down; without the topology, children of an offline
+		// gateway would each raise their own alert
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Error("Failed to load device topology", "error", err)
+		parents = map[string]string{}
+	}
+	
+	// A device behind an offline gateway can't be reached, so it is marked
+	// unreachable instead of raising an alert of its own
+	unreachableChildren := make(map[string]int)
+	for deviceID, device := range health {
+		if gateway := offlineAncestor(deviceID, parents, health); gateway != "" {
+			device.connectivity = devicestatus.ConnectivityUnreachable
+			unreachableChildren[gateway]++
+		}
+	}
+	
+	for deviceID, device := range health {
+		update := &devicestatus.Update{
+			Connectivity: device.connectivity,
+			LastSeen:     device.lastSeen,
+		}
+		change, err := s.statuses.Update(ctx, deviceID, update)
+		if err != nil {
+			s.logger.Error("Failed to cache device status", "error", err, "device_id", deviceID)
+		} else if change != nil {
+			s.publishConnectivityChange(device.tenantID, deviceID, change, device.lastSeen)
+		}
+		
+		if device.connectivity != devicestatus.ConnectivityOffline {
 			continue
 		}
 		
 		// Send offline alert
-		alert := map[string]interface{}{
-			"type":      "device_offline",
-			"device_id": deviceID,
-			"last_seen": lastSeen,
-			"severity":  "warning",
+		alert := events.OfflineAlert{
+			Type:                events.AlertDeviceOffline,
+			DeviceID:            deviceID,
+			TenantID:            device.tenantID,
+			LastSeen:            device.lastSeen,
+			Severity:            "warning",
+			UnreachableChildren: unreachableChildren[deviceID],
 		}
+		events.Publish(ctx, s.bus, alert)
 		
-		message, _ := json.Marshal(alert)
-		s.producer.ProduceMessage("alerts", deviceID, message)
+		s.recordAlert(device.tenantID, events.AlertDeviceOffline, "warning", "Device offline",
+			fmt.Sprintf("Device %s has not reported since %s", deviceID, device.lastSeen.Format(time.RFC3339)),
+			deviceID, alert)
+	}
+}
+
+// loadParents maps each child device to its gateway.
+func (s *Service) loadParents(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, parent_device_id FROM devices WHERE parent_device_id IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	parents := make(map[string]string)
+	for rows.Next() {
+		var deviceID, parentID string
+		if err := rows.Scan(&deviceID, &parentID); err != nil {
+			return nil, err
+		}
+		parents[deviceID] = parentID
 	}
+	return parents, rows.Err()
+}
+
+// offlineAncestor returns the nearest gateway above the device that is
+// offline, or "" if its whole chain is reachable.
+func offlineAncestor(deviceID string, parents map[string]string, health map[string]*deviceHealth) string {
+	visited := map[string]bool{deviceID: true}
+	
+	for parentID, ok := parents[deviceID]; ok && !visited[parentID]; parentID, ok = parents[parentID] {
+		if parent, reported := health[parentID]; reported && parent.offline {
+			return parentID
+		}
+		visited[parentID] = true
+	}
+	return ""
 }
 
 func (s *Service) processCommands(ctx context.Context) {
@@ -280,41 +734,75 @@ func (s *Service) processCommands(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		default:
-			messages, err := s.consumer.ConsumeMessages([]string{"device-commands"}, time.Second*5)
-			if err != nil {
+			messages, err := s.commands.ConsumeMessages([]string{"device-commands"}, time.Second*5)
+			if err != nil || len(messages) == 0 {
 				continue
 			}
 			
-			for _, msg := range messages {
-				s.processDeviceCommand(msg)
+			err = s.commands.HandleBatch(messages, s.config.Kafka.Consumer.Concurrency, s.producer, s.processDeviceCommand)
+			if err != nil {
+				s.logger.Error("Failed to commit consumed offsets", "error", err)
 			}
 		}
 	}
 }
 
-func (s *Service) processDeviceCommand(msg *kafka.Message) {
+func (s *Service) processDeviceCommand(msg *kafka.Message) error {
 	var command models.DeviceCommand
 	if err := json.Unmarshal(msg.Value, &command); err != nil {
 		s.logger.Error("Failed to unmarshal device command", "error", err)
-		return
+		return kafka.Poison(err)
 	}
+	metrics.ObserveLag("device-service", msg.Topic, command.Timestamp)
 	
 	// Validate and execute command
 	if err := s.executeCommand(&command); err != nil {
 		s.logger.Error("Failed to execute command", "error", err, "device_id", command.DeviceID)
-		return
+		return err
+	}
+	if !command.Timestamp.IsZero() {
+		metrics.CommandAckLatency.Observe(time.Since(command.Timestamp).Seconds())
 	}
 	
 	s.logger.Info("Command executed", "device_id", command.DeviceID, "command", command.Command)
+	return nil
+}
+
+// recordCommand adds a pending command to the command history before it
+// is published, filling in its ID and issue time. The published command
+// carries the ID, so executeCommand updates this row rather than adding
+// another.
+func (s *Service) recordCommand(ctx context.Context, command *models.DeviceCommand) error {
+	parametersJSON, _ := json.Marshal(command.Parameters)
+	
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO device_commands (device_id, command, parameters, status, issued_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid)
+		RETURNING id, timestamp
+	`, command.DeviceID, command.Command, parametersJSON, command.Status, command.IssuedBy).Scan(&command.ID, &command.Timestamp)
+}
+
+// setCommandStatus moves a recorded command to status.
+func (s *Service) setCommandStatus(ctx context.Context, commandID, status string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE device_commands SET status = $1, timestamp = NOW() WHERE id = $2`,
+		status, commandID,
+	)
+	return err
 }
 
 func (s *Service) executeCommand(command *models.DeviceCommand) error {
 	// In a real implementation, this would send the command to the actual device
 	// For now, we'll just log it and store the command history
 	
+	// Commands recorded when they were issued only change status
+	if command.ID != "" {
+		return s.setCommandStatus(context.Background(), command.ID, CommandExecuted)
+	}
+	
 	query := `
-		INSERT INTO device_commands (device_id, command, parameters, timestamp, status)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO device_commands (device_id, command, parameters, timestamp, status, issued_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid)
 	`
 	
 	parametersJSON, _ := json.Marshal(command.Parameters)
@@ -324,7 +812,8 @@ func (s *Service) executeCommand(command *models.DeviceCommand) error {
 		command.Command,
 		parametersJSON,
 		time.Now(),
-		"executed",
+		CommandExecuted,
+		command.IssuedBy,
 	)
 	
 	return err
@@ -0,0 +1,69 @@
+package device
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/subscription"
+	"github.com/bhanukaranwal/urbanzen/pkg/events"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// checkSubscriptions notifies users whose metric subscriptions a reading,
+// already in canonical units, started or stopped meeting. Recoveries are
+// only notified when the subscription asks for them.
+func (s *Service) checkSubscriptions(data *models.DeviceData) {
+	changes, err := s.subscriptions.Check(context.Background(), data.DeviceID, data.Metrics, data.Timestamp)
+	if err != nil {
+		s.logger.Error("Failed to check metric subscriptions", "error", err, "device_id", data.DeviceID)
+		return
+	}
+
+	for i := range changes {
+		change := &changes[i]
+		if !change.Triggered && !change.NotifyRecovery {
+			continue
+		}
+		s.notifySubscriber(change, data)
+	}
+}
+
+// notifySubscriber hands a subscription's notification to the
+// notification service over Kafka. Alerts go out as high priority so a
+// digest never delays them; the dedup key collapses a value flapping
+// around the threshold.
+func (s *Service) notifySubscriber(change *subscription.Change, data *models.DeviceData) {
+	priority, notificationType := "high", "metric_subscription"
+	reportedAt := data.Timestamp.Format("2006-01-02 15:04 MST")
+	title := fmt.Sprintf("%s on %s is %s", change.Metric, change.DeviceID, change.Limit())
+	message := fmt.Sprintf("%s on %s reported %s at %s, which is %s.",
+		change.Metric, change.DeviceID, change.DisplayValue(), reportedAt, change.Limit())
+	if !change.Triggered {
+		priority, notificationType = "normal", "metric_subscription_recovered"
+		title = fmt.Sprintf("%s on %s is back to normal", change.Metric, change.DeviceID)
+		message = fmt.Sprintf("%s on %s reported %s at %s, so it is no longer %s.",
+			change.Metric, change.DeviceID, change.DisplayValue(), reportedAt, change.Limit())
+	}
+
+	err := events.Publish(context.Background(), s.bus, events.Notification{
+		ID:       uuid.New().String(),
+		TenantID: change.TenantID,
+		UserID:   change.UserID,
+		Type:     notificationType,
+		Title:    title,
+		Message:  message,
+		Priority: priority,
+		DedupKey: "subscription:" + change.ID,
+		Metadata: map[string]interface{}{
+			"subscription_id": change.ID,
+			"device_id":       change.DeviceID,
+			"metric":          change.Metric,
+			"value":           change.Value,
+			"unit":            change.CanonicalUnit,
+			"timestamp":       data.Timestamp,
+		},
+	})
+	metrics.NotificationPublishes.WithLabelValues("device", metrics.Result(err)).Inc()
+}
@@ -0,0 +1,40 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/telemetryschema"
+)
+
+// checkTelemetrySchema tests a reading, already in canonical units,
+// against the latest schema of its type, and records the version it passed
+// under metadata "schema_version". Types without a schema accept any
+// metrics. It returns a *telemetryschema.ReadingError for a reading that
+// breaks the schema.
+func (s *Service) checkTelemetrySchema(data *models.DeviceData) error {
+	schema, err := s.telemetrySchema(data.DeviceType)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	if err := schema.Check(data.Metrics); err != nil {
+		return err
+	}
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	data.Metadata["schema_version"] = schema.Version
+	return nil
+}
+
+// telemetrySchema returns the schema readings of a type are checked
+// against, or nil if it has none. The store caches schemas.
+func (s *Service) telemetrySchema(deviceType string) (*telemetryschema.Schema, error) {
+	schema, err := s.schemas.Latest(context.Background(), deviceType)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return schema, err
+}
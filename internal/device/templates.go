@@ -0,0 +1,356 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// CommandWait pauses a sequence for the step's "seconds" parameter instead
+// of being sent to the device.
+const CommandWait = "wait"
+
+var placeholder = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+
+// CommandTemplate is a named multi-step command sequence for a device type.
+// String values in step parameters may reference template parameters as
+// {{name}}.
+type CommandTemplate struct {
+	DeviceType  string              `json:"device_type"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Parameters  []TemplateParameter `json:"parameters"`
+	Steps       []TemplateStep      `json:"steps"`
+}
+
+type TemplateParameter struct {
+	Name     string      `json:"name"`
+	Required bool        `json:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+}
+
+type TemplateStep struct {
+	Command    string                 `json:"command"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+func (t *CommandTemplate) validate() error {
+	if len(t.Steps) == 0 {
+		return fmt.Errorf("template must have at least one step")
+	}
+
+	declared := make(map[string]bool, len(t.Parameters))
+	for _, param := range t.Parameters {
+		if param.Name == "" {
+			return fmt.Errorf("template parameters must be named")
+		}
+		declared[param.Name] = true
+	}
+
+	for i, step := range t.Steps {
+		if step.Command == "" {
+			return fmt.Errorf("step %d has no command", i+1)
+		}
+		if step.Command == CommandWait {
+			if _, ok := step.Parameters["seconds"]; !ok {
+				return fmt.Errorf("step %d: wait requires a seconds parameter", i+1)
+			}
+		}
+		encoded, _ := json.Marshal(step.Parameters)
+		for _, match := range placeholder.FindAllStringSubmatch(string(encoded), -1) {
+			if !declared[match[1]] {
+				return fmt.Errorf("step %d references undeclared parameter %s", i+1, match[1])
+			}
+		}
+	}
+
+	return nil
+}
+
+// expand turns the template into the ordered commands for one device,
+// substituting the supplied parameter values.
+func (t *CommandTemplate) expand(deviceID string, values map[string]interface{}) ([]models.DeviceCommand, error) {
+	params := make(map[string]interface{}, len(t.Parameters))
+	var missing []string
+	for _, param := range t.Parameters {
+		value, ok := values[param.Name]
+		switch {
+		case ok:
+			params[param.Name] = value
+		case param.Default != nil:
+			params[param.Name] = param.Default
+		case param.Required:
+			missing = append(missing, param.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required parameters: %s", strings.Join(missing, ", "))
+	}
+
+	commands := make([]models.DeviceCommand, 0, len(t.Steps))
+	for i, step := range t.Steps {
+		parameters, err := substitute(step.Parameters, params)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		resolved, _ := parameters.(map[string]interface{})
+		if resolved == nil {
+			resolved = map[string]interface{}{}
+		}
+
+		if step.Command == CommandWait {
+			if _, ok := resolved["seconds"].(float64); !ok {
+				return nil, fmt.Errorf("step %d: wait seconds must be a number", i+1)
+			}
+		}
+
+		commands = append(commands, models.DeviceCommand{
+			DeviceID:   deviceID,
+			Command:    step.Command,
+			Parameters: resolved,
+			Step:       i + 1,
+			Status:     CommandPending,
+		})
+	}
+
+	return commands, nil
+}
+
+// substitute replaces placeholders throughout a parameter value. A string
+// that is exactly one placeholder takes the parameter's value and type;
+// placeholders inside longer strings are interpolated as text.
+func substitute(value interface{}, params map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if match := placeholder.FindStringSubmatch(v); match != nil && match[0] == v {
+			resolved, ok := params[match[1]]
+			if !ok {
+				return nil, fmt.Errorf("no value for parameter %s", match[1])
+			}
+			return resolved, nil
+		}
+		var err error
+		result := placeholder.ReplaceAllStringFunc(v, func(token string) string {
+			name := placeholder.FindStringSubmatch(token)[1]
+			resolved, ok := params[name]
+			if !ok {
+				err = fmt.Errorf("no value for parameter %s", name)
+				return token
+			}
+			return fmt.Sprint(resolved)
+		})
+		return result, err
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved, err := substitute(item, params)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = resolved
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := substitute(item, params)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = resolved
+		}
+		return result, nil
+	default:
+		return value, nil
+	}
+}
+
+func (s *Service) getTemplate(ctx context.Context, deviceType, name string) (*CommandTemplate, error) {
+	template := CommandTemplate{DeviceType: deviceType, Name: name}
+	var parameters, steps []byte
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT description, parameters, steps FROM command_templates
+		WHERE device_type = $1 AND name = $2
+	`, deviceType, name).Scan(&template.Description, &parameters, &steps)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(parameters, &template.Parameters); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(steps, &template.Steps); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// InvokeCommandTemplate expands a template for the device and queues it as
+// a command sequence. The sequence runs in the background; its progress is
+// available from GetCommandSequence.
+func (s *Service) InvokeCommandTemplate(c *gin.Context) {
+	var req struct {
+		Parameters map[string]interface{} `json:"parameters"`
+	}
+	if !middleware.BindOptionalJSON(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+	deviceID := c.Param("id")
+
+	var deviceType string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT type FROM devices WHERE id = $1 AND tenant_id = $2`,
+		deviceID, tenantID,
+	).Scan(&deviceType)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up device", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start command sequence"})
+		return
+	}
+
+	template, err := s.getTemplate(ctx, deviceType, c.Param("name"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No such command template for " + deviceType})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load command template", "error", err, "template", c.Param("name"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start command sequence"})
+		return
+	}
+
+	commands, err := template.expand(deviceID, req.Parameters)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, command := range commands {
+		if command.Command == CommandWait {
+			continue
+		}
+		if err := s.checkCommand(deviceType, command.Command, command.Parameters); err != nil {
+			if !invalidCommand(c, err) {
+				s.logger.Error("Failed to check command", "error", err, "device_id", deviceID)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start command sequence"})
+			}
+			return
+		}
+	}
+
+	sequence, err := s.createSequence(ctx, tenantID, deviceID, template.Name, c.GetString("user_id"), commands)
+	if err != nil {
+		s.logger.Error("Failed to create command sequence", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start command sequence"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"sequence": sequence,
+		"message":  "Command sequence started",
+	})
+}
+
+func (s *Service) GetCommandSequence(c *gin.Context) {
+	sequence, err := s.loadSequence(c.Request.Context(), middleware.TenantID(c), c.Param("id"), c.Param("sequenceId"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Command sequence not found"})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to load command sequence", "error", err, "sequence_id", c.Param("sequenceId"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve command sequence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sequence)
+}
+
+func (s *Service) ListCommandTemplates(c *gin.Context) {
+	rows, err := s.db.QueryContext(c.Request.Context(), `
+		SELECT name, description, parameters, steps FROM command_templates
+		WHERE device_type = $1
+		ORDER BY name
+	`, c.Param("type"))
+	if err != nil {
+		s.logger.Error("Failed to list command templates", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list command templates"})
+		return
+	}
+	defer rows.Close()
+
+	templates := []CommandTemplate{}
+	for rows.Next() {
+		template := CommandTemplate{DeviceType: c.Param("type")}
+		var parameters, steps []byte
+		if err := rows.Scan(&template.Name, &template.Description, &parameters, &steps); err != nil {
+			s.logger.Error("Failed to scan command template", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list command templates"})
+			return
+		}
+		json.Unmarshal(parameters, &template.Parameters)
+		json.Unmarshal(steps, &template.Steps)
+		templates = append(templates, template)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+// SaveCommandTemplate creates or replaces a template. Sequences already
+// started keep the steps they were expanded with.
+func (s *Service) SaveCommandTemplate(c *gin.Context) {
+	var template CommandTemplate
+	if !middleware.BindJSON(c, &template) {
+		return
+	}
+	template.DeviceType = c.Param("type")
+	template.Name = c.Param("name")
+	if template.Parameters == nil {
+		template.Parameters = []TemplateParameter{}
+	}
+
+	if err := template.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	parameters, _ := json.Marshal(template.Parameters)
+	steps, _ := json.Marshal(template.Steps)
+
+	_, err := s.db.ExecContext(c.Request.Context(), `
+		INSERT INTO command_templates (device_type, name, description, parameters, steps, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (device_type, name)
+		DO UPDATE SET description = $3, parameters = $4, steps = $5, updated_by = $6, updated_at = NOW()
+	`, template.DeviceType, template.Name, template.Description, parameters, steps, c.GetString("user_id"))
+	if err != nil {
+		if database.IsForeignKeyViolation(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown device type"})
+			return
+		}
+		s.logger.Error("Failed to save command template", "error", err, "template", template.Name)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save command template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"template": template,
+		"message":  "Command template saved successfully",
+	})
+}
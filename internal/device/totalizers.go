@@ -0,0 +1,58 @@
+package device
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/totalizer"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// checkTotalizers raises an anomaly when one of the type's cumulative
+// registers reads lower than it did last time. Rollovers past the
+// register's maximum are expected and only logged. Without Redis the check
+// is skipped rather than holding up ingestion.
+func (s *Service) checkTotalizers(data *models.DeviceData, totalizers devicetype.Totalizers) {
+	for metric, spec := range totalizers {
+		value, ok := data.Metrics[metric].(float64)
+		if !ok {
+			continue
+		}
+
+		previous, found, err := s.totalizers.Observe(context.Background(), data.DeviceID, metric, data.Timestamp, value)
+		if err != nil {
+			metrics.RedisFallbacks.WithLabelValues("totalizer").Inc()
+			s.logger.Warn("Skipping totalizer check", "error", err, "device_id", data.DeviceID, "metric", metric)
+			continue
+		}
+		if !found {
+			continue
+		}
+
+		anomaly := &models.Anomaly{
+			DeviceID:  data.DeviceID,
+			Timestamp: data.Timestamp,
+			Metric:    metric,
+			Value:     value,
+		}
+		switch outcome := totalizer.Classify(spec, previous, value); outcome {
+		case totalizer.OK:
+			continue
+		case totalizer.Rollover:
+			s.logger.Info("Totalizer rolled over", "device_id", data.DeviceID, "metric", metric,
+				"previous", previous, "value", value)
+			continue
+		case totalizer.Reset:
+			anomaly.Type = totalizer.Reset
+			anomaly.Severity = "warning"
+			anomaly.Description = fmt.Sprintf("%s was reset from %g to %g", metric, previous, value)
+		default:
+			anomaly.Type = totalizer.Tamper
+			anomaly.Severity = "critical"
+			anomaly.Description = fmt.Sprintf("%s dropped from %g to %g, possible tampering", metric, previous, value)
+		}
+		s.handleAnomaly(anomaly)
+	}
+}
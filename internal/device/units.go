@@ -0,0 +1,68 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+// normalizeUnits converts the message's metrics to the canonical units of
+// the device's type. Devices declare what they report in metadata "units";
+// the units converted from are kept under "original_units".
+func (s *Service) normalizeUnits(data *models.DeviceData) error {
+	reported := reportedUnits(data.Metadata)
+	if len(reported) == 0 {
+		return nil
+	}
+
+	deviceType, err := s.deviceType(data.DeviceType)
+	if err != nil {
+		return err
+	}
+	canonical := deviceType.MetricUnits
+
+	original, err := canonical.Normalize(data.Metrics, reported)
+	if err != nil {
+		return err
+	}
+
+	for metric := range data.Metrics {
+		if unit, ok := canonical[metric]; ok {
+			reported[metric] = unit
+		}
+	}
+	data.Metadata["units"] = reported
+	if len(original) > 0 {
+		data.Metadata["original_units"] = original
+	}
+
+	return nil
+}
+
+// deviceType returns the registered definition of a type. An unregistered
+// type is treated as one with no units or sampling policy. The store
+// caches definitions, so this stays off the database per message.
+func (s *Service) deviceType(name string) (*devicetype.DeviceType, error) {
+	definition, err := s.types.Get(context.Background(), name)
+	if err == sql.ErrNoRows {
+		return &devicetype.DeviceType{Name: name}, nil
+	}
+	return definition, err
+}
+
+func reportedUnits(metadata map[string]interface{}) map[string]string {
+	declared, ok := metadata["units"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	units := make(map[string]string, len(declared))
+	for metric, unit := range declared {
+		if name, ok := unit.(string); ok && name != "" {
+			units[metric] = name
+		}
+	}
+	return units
+}
@@ -0,0 +1,145 @@
+// Package deviceaccess limits citizens to the devices assigned to them.
+// Staff roles (operator, admin and super_admin) see every device in their
+// tenant.
+package deviceaccess
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// Scoped reports whether a user with role only sees assigned devices.
+func Scoped(role string) bool {
+	return role != "operator" && role != "admin" && role != "super_admin"
+}
+
+// AssignedTo returns the user whose assignments limit the request, or nil
+// when the caller may see every device.
+func AssignedTo(c *gin.Context) *string {
+	if !Scoped(c.GetString("role")) {
+		return nil
+	}
+	userID := c.GetString("user_id")
+	return &userID
+}
+
+type Store struct {
+	db *database.PostgresDB
+}
+
+func NewStore(db *database.PostgresDB) *Store {
+	return &Store{db: db}
+}
+
+// Assigned reports whether the device is assigned to the user.
+func (s *Store) Assigned(ctx context.Context, userID, deviceID string) (bool, error) {
+	var assigned bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM device_assignments WHERE user_id::text = $1 AND device_id = $2)
+	`, userID, deviceID).Scan(&assigned)
+	return assigned, err
+}
+
+// Assign gives a user access to a device. Both must belong to the tenant;
+// if either doesn't, it returns sql.ErrNoRows. Assigning twice is a no-op.
+func (s *Store) Assign(ctx context.Context, tenantID, deviceID, userID, actorID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO device_assignments (device_id, user_id, tenant_id, assigned_by)
+		SELECT d.id, u.id, d.tenant_id, NULLIF($4, '')::uuid
+		FROM devices d, users u
+		WHERE d.id = $1 AND u.id::text = $2 AND d.tenant_id = $3 AND u.tenant_id = $3
+		ON CONFLICT (device_id, user_id) DO NOTHING
+	`, deviceID, userID, tenantID, actorID)
+	if err != nil {
+		return err
+	}
+
+	if inserted, _ := result.RowsAffected(); inserted > 0 {
+		return nil
+	}
+
+	// Nothing inserted: either already assigned or nothing matched
+	var exists bool
+	err = s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM device_assignments WHERE device_id = $1 AND user_id::text = $2 AND tenant_id = $3)
+	`, deviceID, userID, tenantID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Unassign removes a user's access to a device, returning sql.ErrNoRows if
+// the device wasn't assigned to them.
+func (s *Store) Unassign(ctx context.Context, tenantID, deviceID, userID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM device_assignments WHERE device_id = $1 AND user_id::text = $2 AND tenant_id = $3
+	`, deviceID, userID, tenantID)
+	if err != nil {
+		return err
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ForDevice lists the users a device is assigned to.
+func (s *Store) ForDevice(ctx context.Context, tenantID, deviceID string) ([]models.DeviceAssignment, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT a.device_id, a.user_id, u.username, COALESCE(a.assigned_by::text, ''), a.assigned_at
+		FROM device_assignments a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.device_id = $1 AND a.tenant_id = $2
+		ORDER BY a.assigned_at
+	`, deviceID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []models.DeviceAssignment{}
+	for rows.Next() {
+		var assignment models.DeviceAssignment
+		if err := rows.Scan(&assignment.DeviceID, &assignment.UserID, &assignment.Username,
+			&assignment.AssignedBy, &assignment.AssignedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
+
+// RequireDevice rejects requests from scoped users for a device, named by
+// the route parameter param, that isn't assigned to them.
+func (s *Store) RequireDevice(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := AssignedTo(c)
+		if userID == nil {
+			c.Next()
+			return
+		}
+
+		assigned, err := s.Assigned(c.Request.Context(), *userID, c.Param(param))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check device access"})
+			c.Abort()
+			return
+		}
+		if !assigned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
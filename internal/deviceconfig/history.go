@@ -0,0 +1,91 @@
+// Package deviceconfig keeps every version of each device's configuration,
+// so the configuration in force at a past time can be shown in disputes
+// and audits.
+package deviceconfig
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrBeforeHistory is returned for a time before history began for the
+// device, when its configuration is unknown.
+var ErrBeforeHistory = errors.New("configuration history does not go back that far")
+
+// Version is a device's configuration from ChangedAt until the next
+// version.
+type Version struct {
+	Configuration map[string]interface{} `json:"configuration"`
+	ChangedBy     string                 `json:"changed_by,omitempty"`
+	ChangedAt     time.Time              `json:"changed_at"`
+
+	// Baseline is set on the version in force when history began; it may
+	// have applied since before ChangedAt
+	Baseline bool `json:"baseline"`
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Record saves a new version of the device's configuration. Call it in the
+// transaction that writes devices.configuration.
+func Record(ctx context.Context, db execer, deviceID string, configuration []byte, actorID string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO device_configuration_history (device_id, configuration, changed_by)
+		VALUES ($1, $2, NULLIF($3, '')::uuid)
+	`, deviceID, configuration, actorID)
+	return err
+}
+
+// AsOf returns the version in force at the given time. It returns
+// sql.ErrNoRows if the device didn't exist yet, and ErrBeforeHistory if
+// the time is before history began.
+func AsOf(ctx context.Context, db querier, deviceID string, at time.Time) (*Version, error) {
+	var version Version
+	var configuration []byte
+	err := db.QueryRowContext(ctx, `
+		SELECT configuration, COALESCE(changed_by::text, ''), changed_at, baseline
+		FROM device_configuration_history
+		WHERE device_id = $1 AND changed_at <= $2
+		ORDER BY changed_at DESC
+		LIMIT 1
+	`, deviceID, at).Scan(&configuration, &version.ChangedBy, &version.ChangedAt, &version.Baseline)
+	if err == sql.ErrNoRows {
+		return nil, before(ctx, db, deviceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(configuration, &version.Configuration); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// before tells a time before the device existed from one before history
+// began.
+func before(ctx context.Context, db querier, deviceID string) error {
+	var baseline bool
+	err := db.QueryRowContext(ctx, `
+		SELECT baseline FROM device_configuration_history
+		WHERE device_id = $1
+		ORDER BY changed_at
+		LIMIT 1
+	`, deviceID).Scan(&baseline)
+	if err != nil {
+		return err
+	}
+	if baseline {
+		return ErrBeforeHistory
+	}
+	return sql.ErrNoRows
+}
@@ -0,0 +1,130 @@
+package devicecred
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+)
+
+// CertificateAuthority signs device client certificates. The device ID is
+// the certificate's common name, but identity comes from the fingerprint
+// recorded at issue, so revoking the credential revokes the certificate.
+type CertificateAuthority struct {
+	cert     *x509.Certificate
+	pem      string
+	key      interface{}
+	lifetime time.Duration
+}
+
+// LoadCA reads devices.credentials.ca_cert_file and ca_key_file. It
+// returns nil if neither is set, in which case only API keys are issued.
+func LoadCA(cfg *config.Config) (*CertificateAuthority, error) {
+	creds := cfg.Devices.Credentials
+	if creds.CACertFile == "" && creds.CAKeyFile == "" {
+		return nil, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(creds.CACertFile, creds.CAKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load device CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse device CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("device CA certificate %s is not a CA", creds.CACertFile)
+	}
+
+	return &CertificateAuthority{
+		cert:     cert,
+		pem:      string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		key:      pair.PrivateKey,
+		lifetime: creds.CertificateLifetime,
+	}, nil
+}
+
+// Pool returns the CA as a pool for verifying client certificates.
+func (ca *CertificateAuthority) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue creates a key pair and a client certificate for the device and
+// returns both PEM encoded, with the certificate's fingerprint.
+func (ca *CertificateAuthority) issue(deviceID string) (certPEM, keyPEM, fingerprint string, expiresAt time.Time, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", "", time.Time{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt = now.Add(ca.lifetime)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: deviceID},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     expiresAt,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return "", "", "", time.Time{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", "", time.Time{}, err
+	}
+
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM, fingerprint256(der), expiresAt, nil
+}
+
+func fingerprint256(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadServerTLS builds the TLS config for serving ingestion: the server's
+// own certificate, and client certificates requested but optional so users
+// can still authenticate with tokens. It returns nil if
+// devices.credentials.tls_cert_file is unset.
+func LoadServerTLS(cfg *config.Config, ca *CertificateAuthority) (*tls.Config, error) {
+	creds := cfg.Devices.Credentials
+	if creds.TLSCertFile == "" {
+		return nil, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(creds.TLSCertFile, creds.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ingestion TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if ca != nil {
+		tlsConfig.ClientCAs = ca.Pool()
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
@@ -0,0 +1,285 @@
+// Package devicecred issues per-device credentials and resolves them back
+// to the device, so telemetry can be tied to the device that sent it.
+// A credential is an API key, sent as a bearer token, or a client
+// certificate signed by the device CA. Only a SHA-256 hash of each key and
+// the fingerprint of each certificate are stored; the secret is returned
+// once, at issue.
+package devicecred
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/internal/devicelifecycle"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// Credential kinds
+const (
+	KindAPIKey      = "api_key"
+	KindCertificate = "certificate"
+)
+
+var (
+	ErrUnknownKind          = errors.New("credential kind must be api_key or certificate")
+	ErrCertificatesDisabled = errors.New("device certificates are not enabled")
+	errInvalidCredential    = errors.New("invalid device credential")
+)
+
+// authCacheTTL bounds how long a successful authentication is reused
+// without a database round trip. Devices send telemetry far more often
+// than that, so revocation takes effect within this long.
+const authCacheTTL = 30 * time.Second
+
+// Credential describes a credential without its secret.
+type Credential struct {
+	ID         string     `json:"id"`
+	DeviceID   string     `json:"device_id"`
+	Kind       string     `json:"kind"`
+	Prefix     string     `json:"prefix"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Issued is a new credential with its secret, which can't be retrieved
+// again. APIKey is set for api_key credentials; Certificate, PrivateKey and
+// CACertificate for certificates.
+type Issued struct {
+	Credential    *Credential `json:"credential"`
+	APIKey        string      `json:"api_key,omitempty"`
+	Certificate   string      `json:"certificate,omitempty"`
+	PrivateKey    string      `json:"private_key,omitempty"`
+	CACertificate string      `json:"ca_certificate,omitempty"`
+}
+
+// querier is satisfied by both the database and a transaction, so a
+// credential can be issued in the transaction that creates its device.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type Store struct {
+	db    *database.PostgresDB
+	ca    *CertificateAuthority
+	grace time.Duration
+
+	mu    sync.Mutex
+	cache     map[string]cachedIdentity // by secret hash
+	nextSweep time.Time
+}
+
+type cachedIdentity struct {
+	identity *middleware.DeviceIdentity
+	expires  time.Time
+}
+
+// NewStore returns a store that signs certificates with ca, which may be
+// nil if only API keys are issued.
+func NewStore(db *database.PostgresDB, ca *CertificateAuthority, cfg *config.Config) *Store {
+	return &Store{
+		db:    db,
+		ca:    ca,
+		grace: cfg.Devices.Credentials.RotationGrace,
+		cache: make(map[string]cachedIdentity),
+	}
+}
+
+// Issue creates a credential for a device in the tenant. It returns
+// sql.ErrNoRows if there is no such device or it is decommissioned.
+func (s *Store) Issue(ctx context.Context, tenantID, deviceID, kind, actorID string) (*Issued, error) {
+	return s.issue(ctx, s.db, tenantID, deviceID, kind, actorID)
+}
+
+// IssueTx is Issue within tx, for devices created in the same transaction.
+func (s *Store) IssueTx(ctx context.Context, tx *sql.Tx, tenantID, deviceID, kind, actorID string) (*Issued, error) {
+	return s.issue(ctx, tx, tenantID, deviceID, kind, actorID)
+}
+
+func (s *Store) issue(ctx context.Context, q querier, tenantID, deviceID, kind, actorID string) (*Issued, error) {
+	issued := &Issued{Credential: &Credential{DeviceID: deviceID, Kind: kind}}
+	var secretHash string
+
+	switch kind {
+	case KindAPIKey:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		issued.APIKey = middleware.DeviceKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+		issued.Credential.Prefix = issued.APIKey[:len(middleware.DeviceKeyPrefix)+6]
+		secretHash = hashKey(issued.APIKey)
+	case KindCertificate:
+		if s.ca == nil {
+			return nil, ErrCertificatesDisabled
+		}
+		certPEM, keyPEM, fingerprint, expiresAt, err := s.ca.issue(deviceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign device certificate: %w", err)
+		}
+		issued.Certificate = certPEM
+		issued.PrivateKey = keyPEM
+		issued.CACertificate = s.ca.pem
+		issued.Credential.Prefix = "sha256:" + fingerprint[:16]
+		issued.Credential.ExpiresAt = &expiresAt
+		secretHash = fingerprint
+	default:
+		return nil, ErrUnknownKind
+	}
+
+	err := q.QueryRowContext(ctx, `
+		INSERT INTO device_credentials (device_id, tenant_id, kind, secret_hash, prefix, expires_at, created_by)
+		SELECT d.id, d.tenant_id, $3, $4, $5, $6, NULLIF($7, '')::uuid
+		FROM devices d
+		WHERE d.id = $1 AND d.tenant_id = $2 AND d.status <> $8
+		RETURNING id, created_at
+	`, deviceID, tenantID, kind, secretHash, issued.Credential.Prefix, issued.Credential.ExpiresAt, actorID,
+		devicelifecycle.Decommissioned,
+	).Scan(&issued.Credential.ID, &issued.Credential.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+// Rotate issues a new credential of kind and lets the device's other active
+// credentials of that kind expire after devices.credentials.rotation_grace,
+// giving the device time to switch over.
+func (s *Store) Rotate(ctx context.Context, tenantID, deviceID, kind, actorID string) (*Issued, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	issued, err := s.issue(ctx, tx, tenantID, deviceID, kind, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE device_credentials
+		SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), NOW() + $4 * INTERVAL '1 second')
+		WHERE device_id = $1 AND tenant_id = $2 AND kind = $3 AND id <> $5 AND revoked_at IS NULL
+	`, deviceID, tenantID, kind, s.grace.Seconds(), issued.Credential.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+// List returns the device's credentials, including revoked and expired ones.
+func (s *Store) List(ctx context.Context, tenantID, deviceID string) ([]*Credential, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, device_id, kind, prefix, expires_at, last_used_at, revoked_at, created_at
+		FROM device_credentials
+		WHERE device_id = $1 AND tenant_id = $2
+		ORDER BY created_at DESC
+	`, deviceID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []*Credential{}
+	for rows.Next() {
+		credential := &Credential{}
+		err := rows.Scan(&credential.ID, &credential.DeviceID, &credential.Kind, &credential.Prefix,
+			&credential.ExpiresAt, &credential.LastUsedAt, &credential.RevokedAt, &credential.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+// Revoke revokes one of the device's credentials immediately. It returns
+// sql.ErrNoRows if the device has no such active credential.
+func (s *Store) Revoke(ctx context.Context, tenantID, deviceID, credentialID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE device_credentials SET revoked_at = NOW()
+		WHERE id::text = $1 AND device_id = $2 AND tenant_id = $3 AND revoked_at IS NULL
+	`, credentialID, deviceID, tenantID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AuthenticateKey resolves a device API key.
+func (s *Store) AuthenticateKey(ctx context.Context, key string) (*middleware.DeviceIdentity, error) {
+	return s.authenticate(ctx, KindAPIKey, hashKey(key))
+}
+
+// AuthenticateCertificate resolves a client certificate that TLS has
+// already verified against the device CA.
+func (s *Store) AuthenticateCertificate(ctx context.Context, cert *x509.Certificate) (*middleware.DeviceIdentity, error) {
+	return s.authenticate(ctx, KindCertificate, fingerprint256(cert.Raw))
+}
+
+func (s *Store) authenticate(ctx context.Context, kind, secretHash string) (*middleware.DeviceIdentity, error) {
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.cache[secretHash]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.identity, nil
+	}
+
+	identity := &middleware.DeviceIdentity{}
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE device_credentials c SET last_used_at = NOW()
+		FROM devices d
+		WHERE c.secret_hash = $1
+			AND c.kind = $2
+			AND c.revoked_at IS NULL
+			AND (c.expires_at IS NULL OR c.expires_at > NOW())
+			AND d.id = c.device_id
+			AND d.status <> $3
+		RETURNING c.id, c.device_id, c.tenant_id
+	`, secretHash, kind, devicelifecycle.Decommissioned).Scan(&identity.CredentialID, &identity.DeviceID, &identity.TenantID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errInvalidCredential
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if now.After(s.nextSweep) {
+		for hash, entry := range s.cache {
+			if now.After(entry.expires) {
+				delete(s.cache, hash)
+			}
+		}
+		s.nextSweep = now.Add(authCacheTTL)
+	}
+	s.cache[secretHash] = cachedIdentity{identity: identity, expires: now.Add(authCacheTTL)}
+	s.mu.Unlock()
+	return identity, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,112 @@
+package devicelifecycle
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	Provisioned    = "provisioned"
+	Installed      = "installed"
+	Active         = "active"
+	Maintenance    = "maintenance"
+	Decommissioned = "decommissioned"
+)
+
+// transitions lists the states each state may move to. Decommissioned is
+// final: a retired device is re-registered, never revived.
+var transitions = map[string][]string{
+	Provisioned:    {Installed, Decommissioned},
+	Installed:      {Active, Maintenance, Decommissioned},
+	Active:         {Maintenance, Decommissioned},
+	Maintenance:    {Active, Decommissioned},
+	Decommissioned: {},
+}
+
+// TransitionError reports an illegal status change and what would have been
+// allowed instead.
+type TransitionError struct {
+	From    string
+	To      string
+	Allowed []string
+}
+
+func (e *TransitionError) Error() string {
+	if len(e.Allowed) == 0 {
+		return fmt.Sprintf("cannot change status from %s to %s: %s is a final state", e.From, e.To, e.From)
+	}
+	return fmt.Sprintf("cannot change status from %s to %s; allowed: %s", e.From, e.To, strings.Join(e.Allowed, ", "))
+}
+
+type HistoryEntry struct {
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Reason     string    `json:"reason,omitempty"`
+	ChangedBy  string    `json:"changed_by"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+func Valid(state string) bool {
+	_, exists := transitions[state]
+	return exists
+}
+
+// Next returns the states a device in the given state may move to.
+func Next(state string) []string {
+	return append([]string{}, transitions[state]...)
+}
+
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves a device to a new status within the caller's
+// transaction and records the change. The caller must have locked the
+// device row so from is current.
+func Transition(ctx context.Context, tx *sql.Tx, deviceID, from, to, reason, actorID string) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{From: from, To: to, Allowed: Next(from)}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE devices SET status = $1 WHERE id = $2`, to, deviceID); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO device_status_history (device_id, from_status, to_status, reason, changed_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	`, deviceID, from, to, reason, actorID)
+	return err
+}
+
+// History returns a device's status changes, most recent first.
+func History(ctx context.Context, db *sql.DB, deviceID string) ([]HistoryEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT from_status, to_status, COALESCE(reason, ''), changed_by::text, changed_at
+		FROM device_status_history
+		WHERE device_id = $1
+		ORDER BY changed_at DESC
+	`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []HistoryEntry{}
+	for rows.Next() {
+		var entry HistoryEntry
+		if err := rows.Scan(&entry.FromStatus, &entry.ToStatus, &entry.Reason, &entry.ChangedBy, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
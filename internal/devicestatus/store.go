@@ -0,0 +1,227 @@
+package devicestatus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+const (
+	ConnectivityOnline  = "online"
+	ConnectivityOffline = "offline"
+	ConnectivityUnknown = "unknown"
+	// The device may be fine but its gateway is offline
+	ConnectivityUnreachable = "unreachable"
+
+	// Entries outlive several health-check cycles so a missed run doesn't
+	// blank the map, but stale devices eventually drop out
+	statusTTL = 24 * time.Hour
+
+	fieldStatus       = "status"
+	fieldConnectivity = "connectivity"
+	fieldLastSeen     = "last_seen"
+	fieldBattery      = "battery"
+	fieldSignal       = "signal"
+	fieldUpdatedAt    = "updated_at"
+	metricPrefix      = "metric:"
+)
+
+// Status is the compact per-device view served to dashboards and maps.
+type Status struct {
+	DeviceID     string    `json:"id"`
+	Status       string    `json:"status"`
+	Connectivity string    `json:"connectivity"`
+	LastSeen     time.Time `json:"last_seen,omitempty"`
+	Battery      *float64  `json:"battery,omitempty"`
+	Signal       *float64  `json:"signal,omitempty"`
+}
+
+// LatestStatus is a single device's status along with the most recent value
+// it reported for each metric.
+type LatestStatus struct {
+	Status
+	Metrics   map[string]float64 `json:"metrics"`
+	UpdatedAt time.Time          `json:"updated_at,omitempty"`
+}
+
+// Update is a partial status change. Only non-zero fields are written, so
+// telemetry and the health monitor can each maintain the fields they own.
+type Update struct {
+	Status       string
+	Connectivity string
+	LastSeen     time.Time
+	Battery      *float64
+	Signal       *float64
+	Metrics      map[string]float64
+}
+
+// Store keeps the latest status of each device in a Redis hash so status
+// reads never have to aggregate raw telemetry.
+type Store struct {
+	redis *database.RedisClient
+}
+
+func NewStore(redis *database.RedisClient) *Store {
+	return &Store{redis: redis}
+}
+
+// ConnectivityChange is a device's connectivity moving from one state to
+// another. From is ConnectivityUnknown when the device had no cached
+// status, as on its first report or after its entry expired.
+type ConnectivityChange struct {
+	From string
+	To   string
+}
+
+// updateScript writes the fields and refreshes the TTL, returning the
+// previous connectivity so concurrent writers can't both see the same
+// transition.
+const updateScript = `
+local previous = redis.call('HGET', KEYS[1], ARGV[2])
+redis.call('HSET', KEYS[1], unpack(ARGV, 3))
+redis.call('EXPIRE', KEYS[1], ARGV[1])
+return previous
+`
+
+// Update applies a partial status change. If it sets a connectivity that
+// differs from the cached one it returns the change, otherwise nil.
+func (s *Store) Update(ctx context.Context, deviceID string, update *Update) (*ConnectivityChange, error) {
+	args := []interface{}{
+		int(statusTTL.Seconds()), fieldConnectivity,
+		fieldUpdatedAt, time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	if update.Status != "" {
+		args = append(args, fieldStatus, update.Status)
+	}
+	if update.Connectivity != "" {
+		args = append(args, fieldConnectivity, update.Connectivity)
+	}
+	if !update.LastSeen.IsZero() {
+		args = append(args, fieldLastSeen, update.LastSeen.UTC().Format(time.RFC3339Nano))
+	}
+	if update.Battery != nil {
+		args = append(args, fieldBattery, *update.Battery)
+	}
+	if update.Signal != nil {
+		args = append(args, fieldSignal, *update.Signal)
+	}
+	for name, value := range update.Metrics {
+		args = append(args, metricPrefix+name, value)
+	}
+
+	reply, err := s.redis.Eval(ctx, updateScript, []string{key(deviceID)}, args...)
+	if err != nil && !database.IsMiss(err) {
+		return nil, err
+	}
+
+	previous, _ := reply.(string)
+	if previous == "" {
+		previous = ConnectivityUnknown
+	}
+	if update.Connectivity == "" || update.Connectivity == previous {
+		return nil, nil
+	}
+	return &ConnectivityChange{From: previous, To: update.Connectivity}, nil
+}
+
+// GetLatestStatus returns the full cached status of one device, including
+// its last metric values. A device with no cached entry is reported with
+// unknown connectivity.
+func (s *Store) GetLatestStatus(ctx context.Context, deviceID string) (*LatestStatus, error) {
+	fields, err := s.redis.HGetAll(ctx, key(deviceID))
+	if err != nil {
+		return nil, err
+	}
+
+	latest := &LatestStatus{
+		Status:  parseStatus(deviceID, fields),
+		Metrics: make(map[string]float64),
+	}
+
+	for field, value := range fields {
+		if !strings.HasPrefix(field, metricPrefix) {
+			continue
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			latest.Metrics[strings.TrimPrefix(field, metricPrefix)] = v
+		}
+	}
+	latest.UpdatedAt, _ = time.Parse(time.RFC3339Nano, fields[fieldUpdatedAt])
+
+	return latest, nil
+}
+
+// GetMany returns statuses in the same order as deviceIDs. Devices with no
+// cached entry are reported with unknown connectivity.
+func (s *Store) GetMany(ctx context.Context, deviceIDs []string) ([]Status, error) {
+	if len(deviceIDs) == 0 {
+		return []Status{}, nil
+	}
+
+	keys := make([]string, len(deviceIDs))
+	for i, id := range deviceIDs {
+		keys[i] = key(id)
+	}
+
+	hashes, err := s.redis.HGetAllMany(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(deviceIDs))
+	for i, fields := range hashes {
+		statuses[i] = parseStatus(deviceIDs[i], fields)
+	}
+
+	return statuses, nil
+}
+
+// Unknown returns placeholder statuses, in the same order as deviceIDs, for
+// when the cache can't be read. Callers serve them rather than failing so
+// the registry data around them stays available while Redis is down.
+func Unknown(deviceIDs []string) []Status {
+	statuses := make([]Status, len(deviceIDs))
+	for i, id := range deviceIDs {
+		statuses[i] = parseStatus(id, nil)
+	}
+	return statuses
+}
+
+func parseStatus(deviceID string, fields map[string]string) Status {
+	status := Status{
+		DeviceID:     deviceID,
+		Status:       fields[fieldStatus],
+		Connectivity: fields[fieldConnectivity],
+	}
+
+	if status.Connectivity == "" {
+		status.Connectivity = ConnectivityUnknown
+	}
+	if lastSeen, err := time.Parse(time.RFC3339Nano, fields[fieldLastSeen]); err == nil {
+		status.LastSeen = lastSeen
+	}
+	status.Battery = parseOptionalFloat(fields[fieldBattery])
+	status.Signal = parseOptionalFloat(fields[fieldSignal])
+
+	return status
+}
+
+func parseOptionalFloat(value string) *float64 {
+	if value == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+func key(deviceID string) string {
+	return fmt.Sprintf("device_status:%s", deviceID)
+}
@@ -0,0 +1,93 @@
+package devicetype
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConfigureCommand pushes a device's configuration to it. Every type
+// accepts it, with parameters checked against the type's ConfigSchema.
+const ConfigureCommand = "configure"
+
+var fieldTypes = map[string]bool{"number": true, "integer": true, "string": true, "boolean": true, "object": true}
+
+// Capabilities maps each command a type's devices accept to the schema of
+// its parameters. A type that lists none accepts any command, as every
+// type did before capabilities were declared.
+type Capabilities map[string]Schema
+
+// CommandError lists everything wrong with a command for a device type.
+type CommandError struct {
+	Violations []string
+}
+
+func (e *CommandError) Error() string {
+	return "invalid command: " + strings.Join(e.Violations, "; ")
+}
+
+func (c Capabilities) Validate() error {
+	var violations []string
+	for command, schema := range c {
+		if strings.TrimSpace(command) == "" {
+			violations = append(violations, "capabilities: command names must not be empty")
+		}
+		if command == ConfigureCommand {
+			violations = append(violations, fmt.Sprintf("capabilities.%s: is always accepted and checked against config_schema", command))
+		}
+		for name, field := range schema {
+			if !fieldTypes[field.Type] {
+				violations = append(violations, fmt.Sprintf("capabilities.%s.%s.type: must be number, integer, string, boolean or object", command, name))
+			}
+			if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+				violations = append(violations, fmt.Sprintf("capabilities.%s.%s: min must not exceed max", command, name))
+			}
+		}
+	}
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// CheckCommand returns a *CommandError if the type's devices don't accept
+// the command or its parameters break the command's schema.
+func (t *DeviceType) CheckCommand(command string, parameters map[string]interface{}) error {
+	if command == ConfigureCommand {
+		return commandError(t.ConfigSchema.Validate(parameters))
+	}
+	if len(t.Capabilities) == 0 {
+		return nil
+	}
+
+	schema, supported := t.Capabilities[command]
+	if !supported {
+		return &CommandError{Violations: []string{
+			fmt.Sprintf("%s devices don't support %s (supported: %s)", t.Name, command, strings.Join(t.Capabilities.commands(), ", ")),
+		}}
+	}
+
+	// An empty schema declares a command without parameters, where an empty
+	// config_schema accepts anything
+	if len(schema) == 0 && len(parameters) > 0 {
+		return &CommandError{Violations: []string{fmt.Sprintf("%s takes no parameters", command)}}
+	}
+	return commandError(schema.Validate(parameters))
+}
+
+func commandError(err error) error {
+	if validationErr, ok := err.(*ValidationError); ok {
+		return &CommandError{Violations: validationErr.Violations}
+	}
+	return err
+}
+
+func (c Capabilities) commands() []string {
+	commands := make([]string, 0, len(c))
+	for command := range c {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
@@ -0,0 +1,67 @@
+package devicetype
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MetadataError lists everything wrong with a device's metadata for its
+// type.
+type MetadataError struct {
+	Violations []string
+}
+
+func (e *MetadataError) Error() string {
+	return "invalid metadata: " + strings.Join(e.Violations, "; ")
+}
+
+// CheckMetadata returns a *MetadataError if the device's metadata breaks
+// the type's metadata schema. Unlike configuration, metadata stays
+// free-form: keys the schema doesn't define are allowed, and only the keys
+// it does define are checked. Required keys must be present and not null or
+// blank.
+func (t *DeviceType) CheckMetadata(metadata map[string]interface{}) error {
+	var violations []string
+
+	for key, field := range t.MetadataSchema {
+		value, exists := metadata[key]
+		if !exists || value == nil {
+			if field.Required {
+				violations = append(violations, fmt.Sprintf("%s: is required", key))
+			}
+			continue
+		}
+		if text, ok := value.(string); ok && field.Required && strings.TrimSpace(text) == "" {
+			violations = append(violations, fmt.Sprintf("%s: is required", key))
+			continue
+		}
+		if problem := field.check(value); problem != "" {
+			violations = append(violations, fmt.Sprintf("%s: %s", key, problem))
+		}
+	}
+
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return &MetadataError{Violations: violations}
+	}
+	return nil
+}
+
+// validateMetadataSchema rejects rules with a type CheckMetadata doesn't
+// know, which would otherwise accept any value.
+func (t *DeviceType) validateMetadataSchema() error {
+	var violations []string
+	for key, field := range t.MetadataSchema {
+		switch field.Type {
+		case "number", "integer", "string", "boolean", "object", "date":
+		default:
+			violations = append(violations, fmt.Sprintf("metadata_schema.%s: unknown type %q", key, field.Type))
+		}
+	}
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package devicetype
+
+import "fmt"
+
+const maxSamplingInterval = 3600
+
+// SamplingPolicy downsamples a type's telemetry at ingestion into
+// fixed-interval aggregates (min, max, average, last) per metric. Without
+// StoreRaw only the aggregates are kept; removing the policy restores raw
+// storage.
+type SamplingPolicy struct {
+	IntervalSeconds int  `json:"interval_seconds"`
+	StoreRaw        bool `json:"store_raw"`
+}
+
+func (p *SamplingPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.IntervalSeconds < 1 || p.IntervalSeconds > maxSamplingInterval {
+		return &ValidationError{Violations: []string{
+			fmt.Sprintf("sampling.interval_seconds: must be between 1 and %d", maxSamplingInterval),
+		}}
+	}
+	return nil
+}
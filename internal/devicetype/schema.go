@@ -0,0 +1,138 @@
+package devicetype
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Field describes one configuration key a device type accepts.
+type Field struct {
+	Type     string        `json:"type"`
+	Required bool          `json:"required,omitempty"`
+	Min      *float64      `json:"min,omitempty"`
+	Max      *float64      `json:"max,omitempty"`
+	Enum     []interface{} `json:"enum,omitempty"`
+}
+
+// Schema maps configuration keys to their rules. An empty schema accepts
+// any configuration.
+type Schema map[string]Field
+
+// ValidationError lists every problem with a configuration, so operators can
+// fix them all in one pass.
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Violations, "; ")
+}
+
+// Validate checks a configuration against the schema. Keys the schema
+// doesn't define are rejected, which catches misspelt settings early.
+func (s Schema) Validate(configuration map[string]interface{}) error {
+	if len(s) == 0 {
+		return nil
+	}
+
+	var violations []string
+
+	for key, value := range configuration {
+		field, exists := s[key]
+		if !exists {
+			violations = append(violations, fmt.Sprintf("%s: unknown setting", key))
+			continue
+		}
+		if problem := field.check(value); problem != "" {
+			violations = append(violations, fmt.Sprintf("%s: %s", key, problem))
+		}
+	}
+
+	for key, field := range s {
+		if _, exists := configuration[key]; field.Required && !exists {
+			violations = append(violations, fmt.Sprintf("%s: is required", key))
+		}
+	}
+
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+func (f Field) check(value interface{}) string {
+	switch f.Type {
+	case "number", "integer":
+		number, ok := value.(float64)
+		if !ok {
+			return "must be a number"
+		}
+		if f.Type == "integer" && number != float64(int64(number)) {
+			return "must be a whole number"
+		}
+		if f.Min != nil && number < *f.Min {
+			return fmt.Sprintf("must be at least %v", *f.Min)
+		}
+		if f.Max != nil && number > *f.Max {
+			return fmt.Sprintf("must be at most %v", *f.Max)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return "must be a string"
+		}
+	case "date":
+		text, ok := value.(string)
+		if !ok {
+			return "must be a date (YYYY-MM-DD)"
+		}
+		if _, err := time.Parse("2006-01-02", text); err != nil {
+			return "must be a date (YYYY-MM-DD)"
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return "must be true or false"
+		}
+	case "object":
+		if _, ok := value.(map[string]interface{}); !ok {
+			return "must be an object"
+		}
+	}
+
+	if len(f.Enum) > 0 {
+		for _, allowed := range f.Enum {
+			if allowed == value {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %v", f.Enum)
+	}
+
+	return ""
+}
+
+// Merge layers overrides onto a copy of the defaults. Nested objects merge
+// key by key; any other value replaces the default.
+func Merge(defaults, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults)+len(overrides))
+	for key, value := range defaults {
+		if nested, ok := value.(map[string]interface{}); ok {
+			value = Merge(nested, nil)
+		}
+		merged[key] = value
+	}
+
+	for key, value := range overrides {
+		base, baseIsMap := merged[key].(map[string]interface{})
+		override, overrideIsMap := value.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			merged[key] = Merge(base, override)
+			continue
+		}
+		merged[key] = value
+	}
+
+	return merged
+}
@@ -0,0 +1,242 @@
+package devicetype
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// Types change rarely but are read for every message and every device
+// write, so Get caches them. Saves drop the cached copy at once; other
+// processes see them on their next sync, or within cacheTTL without one.
+const (
+	cacheName       = "device_types"
+	cacheTTL        = 5 * time.Minute
+	cacheMaxEntries = 1000
+)
+
+// DeviceType holds what every device of a type shares: the configuration a
+// new device starts with, the rules its configuration and metadata must
+// satisfy and the commands its devices accept.
+type DeviceType struct {
+	Name                 string                 `json:"name"`
+	Description          string                 `json:"description"`
+	DefaultConfiguration map[string]interface{} `json:"default_configuration"`
+	ConfigSchema         Schema                 `json:"config_schema"`
+	MetadataSchema       Schema                 `json:"metadata_schema"`
+	MetricUnits          MetricUnits            `json:"metric_units"`
+	Sampling             *SamplingPolicy        `json:"sampling"`
+	Totalizers           Totalizers             `json:"totalizers"`
+	Capabilities         Capabilities           `json:"capabilities"`
+	UpdatedBy            string                 `json:"updated_by,omitempty"`
+	UpdatedAt            time.Time              `json:"updated_at"`
+}
+
+// Configure merges a device's configuration overrides onto the type's
+// defaults and validates the result.
+func (t *DeviceType) Configure(overrides map[string]interface{}) (map[string]interface{}, error) {
+	configuration := Merge(t.DefaultConfiguration, overrides)
+	if err := t.ConfigSchema.Validate(configuration); err != nil {
+		return nil, err
+	}
+	return configuration, nil
+}
+
+type Store struct {
+	db     *database.PostgresDB
+	syncer *cache.Syncer
+
+	// name -> type, or nil if it isn't registered
+	cache *cache.Cache[string, *DeviceType]
+}
+
+func NewStore(db *database.PostgresDB) *Store {
+	return &Store{
+		db:    db,
+		cache: cache.New[string, *DeviceType](cacheName, cacheTTL, cacheMaxEntries),
+	}
+}
+
+// SyncWith shares the store's cache invalidations with other processes.
+func (s *Store) SyncWith(syncer *cache.Syncer) {
+	s.syncer = syncer
+	syncer.Watch(s.cache)
+}
+
+// Get returns a device type, or sql.ErrNoRows if it isn't registered. The
+// type is shared with other callers and must not be modified.
+func (s *Store) Get(ctx context.Context, name string) (*DeviceType, error) {
+	deviceType, err := s.cache.Load(name, func() (*DeviceType, error) {
+		row := s.db.QueryRowContext(ctx, `
+			SELECT name, description, default_configuration, config_schema, metadata_schema, metric_units, sampling, totalizers,
+				capabilities, COALESCE(updated_by::text, ''), updated_at
+			FROM device_types
+			WHERE name = $1
+		`, name)
+		deviceType, err := scanDeviceType(row)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return deviceType, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if deviceType == nil {
+		return nil, sql.ErrNoRows
+	}
+	return deviceType, nil
+}
+
+func (s *Store) List(ctx context.Context) ([]*DeviceType, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT name, description, default_configuration, config_schema, metadata_schema, metric_units, sampling, totalizers,
+			capabilities, COALESCE(updated_by::text, ''), updated_at
+		FROM device_types
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := []*DeviceType{}
+	for rows.Next() {
+		deviceType, err := scanDeviceType(rows)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, deviceType)
+	}
+	return types, rows.Err()
+}
+
+// Save creates or replaces a device type after checking its own defaults
+// satisfy its schema, its metadata rules and metric units are known.
+func (s *Store) Save(ctx context.Context, deviceType *DeviceType, actorID string) error {
+	if err := deviceType.ConfigSchema.Validate(deviceType.DefaultConfiguration); err != nil {
+		return err
+	}
+	if err := deviceType.validateMetadataSchema(); err != nil {
+		return err
+	}
+	if err := deviceType.MetricUnits.Validate(); err != nil {
+		return err
+	}
+	if err := deviceType.Sampling.Validate(); err != nil {
+		return err
+	}
+	if err := deviceType.Totalizers.Validate(); err != nil {
+		return err
+	}
+	if err := deviceType.Capabilities.Validate(); err != nil {
+		return err
+	}
+
+	defaults, err := json.Marshal(deviceType.DefaultConfiguration)
+	if err != nil {
+		return err
+	}
+	schema, err := json.Marshal(deviceType.ConfigSchema)
+	if err != nil {
+		return err
+	}
+	metadataSchema, err := json.Marshal(deviceType.MetadataSchema)
+	if err != nil {
+		return err
+	}
+	metricUnits, err := json.Marshal(deviceType.MetricUnits)
+	if err != nil {
+		return err
+	}
+	totalizers, err := json.Marshal(deviceType.Totalizers)
+	if err != nil {
+		return err
+	}
+	capabilities, err := json.Marshal(deviceType.Capabilities)
+	if err != nil {
+		return err
+	}
+	var sampling []byte
+	if deviceType.Sampling != nil {
+		if sampling, err = json.Marshal(deviceType.Sampling); err != nil {
+			return err
+		}
+	}
+
+	deviceType.UpdatedBy = actorID
+	deviceType.UpdatedAt = time.Now()
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO device_types (name, description, default_configuration, config_schema, metadata_schema, metric_units,
+			sampling, totalizers, capabilities, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (name)
+		DO UPDATE SET description = $2, default_configuration = $3, config_schema = $4, metadata_schema = $5,
+			metric_units = $6, sampling = $7, totalizers = $8, capabilities = $9, updated_by = $10, updated_at = $11
+	`, deviceType.Name, deviceType.Description, defaults, schema, metadataSchema, metricUnits, sampling, totalizers,
+		capabilities, actorID, deviceType.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	s.cache.Delete(deviceType.Name)
+	s.syncer.Changed(ctx, cacheName)
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeviceType(row rowScanner) (*DeviceType, error) {
+	var deviceType DeviceType
+	var defaults, schema, metadataSchema, metricUnits, sampling, totalizers, capabilities []byte
+
+	if err := row.Scan(
+		&deviceType.Name,
+		&deviceType.Description,
+		&defaults,
+		&schema,
+		&metadataSchema,
+		&metricUnits,
+		&sampling,
+		&totalizers,
+		&capabilities,
+		&deviceType.UpdatedBy,
+		&deviceType.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(defaults, &deviceType.DefaultConfiguration); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(schema, &deviceType.ConfigSchema); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metadataSchema, &deviceType.MetadataSchema); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metricUnits, &deviceType.MetricUnits); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(totalizers, &deviceType.Totalizers); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(capabilities, &deviceType.Capabilities); err != nil {
+		return nil, err
+	}
+	if sampling != nil {
+		deviceType.Sampling = &SamplingPolicy{}
+		if err := json.Unmarshal(sampling, deviceType.Sampling); err != nil {
+			return nil, err
+		}
+	}
+
+	return &deviceType, nil
+}
@@ -0,0 +1,36 @@
+package devicetype
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Totalizer describes a cumulative metric, such as a meter's energy or
+// volume register, which should only ever increase. RolloverAt is the
+// value the register wraps to zero at, 0 if it never wraps; Tolerance is
+// the largest decrease treated as measurement noise.
+type Totalizer struct {
+	RolloverAt float64 `json:"rollover_at,omitempty"`
+	Tolerance  float64 `json:"tolerance,omitempty"`
+}
+
+// Totalizers maps a metric to its totalizer settings. Metrics not listed
+// may go up and down freely.
+type Totalizers map[string]Totalizer
+
+func (t Totalizers) Validate() error {
+	var violations []string
+	for metric, totalizer := range t {
+		if totalizer.RolloverAt < 0 {
+			violations = append(violations, fmt.Sprintf("totalizers.%s.rollover_at: must not be negative", metric))
+		}
+		if totalizer.Tolerance < 0 {
+			violations = append(violations, fmt.Sprintf("totalizers.%s.tolerance: must not be negative", metric))
+		}
+	}
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
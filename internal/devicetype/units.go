@@ -0,0 +1,55 @@
+package devicetype
+
+import (
+	"fmt"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/units"
+)
+
+// MetricUnits maps a metric to the canonical unit it is stored in, so
+// consumption math never mixes units across devices of a type.
+type MetricUnits map[string]string
+
+func (m MetricUnits) Validate() error {
+	var violations []string
+	for metric, unit := range m {
+		if !units.Default.Known(unit) {
+			violations = append(violations, fmt.Sprintf("%s: unknown unit %q", metric, unit))
+		}
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// Normalize converts metrics reported in the given units to their canonical
+// units in place and returns the units it converted from. Metrics reported
+// without a unit are taken to already be canonical.
+func (m MetricUnits) Normalize(metrics map[string]interface{}, reported map[string]string) (map[string]string, error) {
+	original := make(map[string]string)
+
+	for metric, unit := range reported {
+		canonical, ok := m[metric]
+		if !ok || unit == canonical {
+			continue
+		}
+		value, exists := metrics[metric]
+		if !exists {
+			continue
+		}
+		number, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("metric %s has unit %s but is not numeric", metric, unit)
+		}
+
+		converted, err := units.Default.Convert(number, unit, canonical)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", metric, err)
+		}
+		metrics[metric] = converted
+		original[metric] = unit
+	}
+
+	return original, nil
+}
@@ -0,0 +1,206 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+const (
+	redisKey = "feature_flags"
+	cacheTTL = 30 * time.Second
+)
+
+// Flag controls a feature. A flag is on for a request when it is enabled,
+// the tenant is targeted (or no tenants are listed), and the subject falls
+// inside the rollout percentage.
+type Flag struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Enabled     bool      `json:"enabled"`
+	Percentage  int       `json:"percentage"`
+	Tenants     []string  `json:"tenants,omitempty"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+}
+
+type Service struct {
+	redis    *database.RedisClient
+	defaults map[string]Flag
+	logger   logger.Logger
+
+	mu       sync.RWMutex
+	cached   map[string]Flag
+	cachedAt time.Time
+}
+
+type targetKey struct{}
+
+type target struct {
+	tenantID string
+	subject  string
+}
+
+// WithTarget attaches the tenant and a stable subject (user or device ID)
+// used for tenant targeting and percentage bucketing.
+func WithTarget(ctx context.Context, tenantID, subject string) context.Context {
+	return context.WithValue(ctx, targetKey{}, target{tenantID: tenantID, subject: subject})
+}
+
+func New(redis *database.RedisClient, cfg *config.Config, log logger.Logger) *Service {
+	defaults := make(map[string]Flag)
+	for name, f := range cfg.Features {
+		defaults[name] = Flag{
+			Name:        name,
+			Description: f.Description,
+			Enabled:     f.Enabled,
+			Percentage:  f.Percentage,
+			Tenants:     f.Tenants,
+		}
+	}
+
+	return &Service{
+		redis:    redis,
+		defaults: defaults,
+		logger:   log,
+	}
+}
+
+// Enabled reports whether the named feature is on for the request's target.
+// Unknown flags are off.
+func (s *Service) Enabled(ctx context.Context, name string) bool {
+	flag, exists := s.flags(ctx)[name]
+	if !exists || !flag.Enabled {
+		return false
+	}
+
+	t, _ := ctx.Value(targetKey{}).(target)
+
+	if len(flag.Tenants) > 0 {
+		targeted := false
+		for _, tenantID := range flag.Tenants {
+			if tenantID == t.tenantID {
+				targeted = true
+				break
+			}
+		}
+		if !targeted {
+			return false
+		}
+	}
+
+	if flag.Percentage >= 100 {
+		return true
+	}
+	if flag.Percentage <= 0 || t.subject == "" {
+		return false
+	}
+
+	return bucket(name, t.subject) < flag.Percentage
+}
+
+func (s *Service) List(ctx context.Context) []Flag {
+	all := s.flags(ctx)
+	result := make([]Flag, 0, len(all))
+	for _, flag := range all {
+		result = append(result, flag)
+	}
+	return result
+}
+
+// Set stores a runtime override for a flag; it takes precedence over the
+// config default until changed again.
+func (s *Service) Set(ctx context.Context, flag Flag, actorID string) error {
+	if flag.Name == "" {
+		return fmt.Errorf("flag name is required")
+	}
+	if flag.Percentage < 0 || flag.Percentage > 100 {
+		return fmt.Errorf("percentage must be between 0 and 100")
+	}
+
+	flag.UpdatedBy = actorID
+	flag.UpdatedAt = time.Now()
+
+	value, err := json.Marshal(flag)
+	if err != nil {
+		return err
+	}
+
+	if err := s.redis.HSet(ctx, redisKey, flag.Name, string(value)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.cached = nil
+	s.mu.Unlock()
+
+	s.logger.Info("Feature flag updated",
+		"flag", flag.Name,
+		"enabled", flag.Enabled,
+		"percentage", flag.Percentage,
+		"updated_by", actorID,
+	)
+	return nil
+}
+
+// flags returns config defaults merged with Redis overrides, cached briefly.
+// If Redis is unreachable the defaults (or last good snapshot) are used.
+func (s *Service) flags(ctx context.Context) map[string]Flag {
+	s.mu.RLock()
+	if s.cached != nil && time.Since(s.cachedAt) < cacheTTL {
+		cached := s.cached
+		s.mu.RUnlock()
+		return cached
+	}
+	s.mu.RUnlock()
+
+	merged := make(map[string]Flag, len(s.defaults))
+	for name, flag := range s.defaults {
+		merged[name] = flag
+	}
+
+	overrides, err := s.redis.HGetAll(ctx, redisKey)
+	if err != nil {
+		s.logger.Warn("Failed to load feature flags from Redis", "error", err)
+		metrics.RedisFallbacks.WithLabelValues("feature_flags").Inc()
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.cached != nil {
+			return s.cached
+		}
+		return merged
+	}
+
+	for name, raw := range overrides {
+		var flag Flag
+		if err := json.Unmarshal([]byte(raw), &flag); err != nil {
+			s.logger.Error("Invalid feature flag in Redis", "error", err, "flag", name)
+			continue
+		}
+		flag.Name = name
+		merged[name] = flag
+	}
+
+	s.mu.Lock()
+	s.cached = merged
+	s.cachedAt = time.Now()
+	s.mu.Unlock()
+
+	return merged
+}
+
+// bucket maps a subject to a stable 0-99 bucket per flag, so raising the
+// percentage only ever adds subjects.
+func bucket(name, subject string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + subject))
+	return int(h.Sum32() % 100)
+}
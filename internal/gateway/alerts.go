@@ -0,0 +1,307 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/pagination"
+)
+
+const maxBulkAlertIDs = 1000
+
+type alertAction string
+
+const (
+	alertAcknowledge alertAction = "acknowledge"
+	alertResolve     alertAction = "resolve"
+)
+
+// alertFilter selects alerts within the caller's tenant. Ward, zone and tag
+// match through the alert's device. Regions limits the caller to alerts on
+// devices in the regions they may reach, and AssignedTo, when set, to
+// alerts on devices assigned to that user; neither is taken from the
+// request.
+type alertFilter struct {
+	DeviceID string     `json:"device_id" form:"device_id"`
+	Type     string     `json:"type" form:"type"`
+	Ward     string     `json:"ward" form:"ward"`
+	Zone     string     `json:"zone" form:"zone"`
+	Tag      string     `json:"tag" form:"tag"`
+	From     *time.Time `json:"from" form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       *time.Time `json:"to" form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+
+	Regions    []string `json:"-" form:"-"`
+	AssignedTo *string  `json:"-" form:"-"`
+}
+
+func (f *alertFilter) empty() bool {
+	return f.DeviceID == "" && f.Type == "" && f.Ward == "" && f.Zone == "" && f.Tag == "" && f.From == nil && f.To == nil
+}
+
+// conditions returns the WHERE clause for the filter over alerts aliased
+// as "a", using parameters $1 to $10.
+func (f *alertFilter) conditions(tenantID string) (string, []interface{}) {
+	where := `
+		a.tenant_id = $1
+		AND ($2 = '' OR a.device_id = $2)
+		AND ($3 = '' OR a.type = $3)
+		AND ($4 = '' OR a.device_id IN (SELECT id FROM devices WHERE tenant_id = $1 AND ward = $4))
+		AND ($5 = '' OR a.device_id IN (SELECT id FROM devices WHERE tenant_id = $1 AND zone = $5))
+		AND ($6::timestamptz IS NULL OR a.created_at >= $6)
+		AND ($7::timestamptz IS NULL OR a.created_at < $7)
+		AND ($8 = '' OR a.device_id IN (SELECT id FROM devices WHERE tenant_id = $1 AND tags @> ARRAY[$8::text]))
+		AND ` + alertInRegions("$9") + `
+		AND ($10::text IS NULL OR a.device_id IN (SELECT device_id FROM device_assignments WHERE user_id::text = $10))
+	`
+	return where, []interface{}{tenantID, f.DeviceID, f.Type, f.Ward, f.Zone, f.From, f.To, strings.ToLower(f.Tag),
+		pq.Array(f.Regions), f.AssignedTo}
+}
+
+// alertInRegions matches alerts on devices held in the regions bound to
+// param, or every alert when it is NULL. Alerts without a device belong to
+// the whole tenant and always match. $1 must be the tenant.
+func alertInRegions(param string) string {
+	return fmt.Sprintf(`(%[1]s::text[] IS NULL OR a.device_id IS NULL OR a.device_id IN (
+			SELECT id FROM devices WHERE tenant_id = $1 AND COALESCE(region, '') = ANY(%[1]s)))`, param)
+}
+
+type bulkAlertRequest struct {
+	AlertIDs []string    `json:"alert_ids"`
+	Filter   alertFilter `json:"filter"`
+}
+
+type alertActionResult struct {
+	Action  alertAction `json:"action"`
+	Matched int         `json:"matched"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+}
+
+// ListAlerts returns the tenant's alerts, newest first. status narrows to
+// open (unacknowledged), acknowledged or resolved alerts.
+func (g *Gateway) ListAlerts(c *gin.Context) {
+	page, limit, err := pagination.Parse(c, g.config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var filter alertFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Regions = g.regions.Scope(c)
+
+	where, args := filter.conditions(middleware.TenantID(c))
+	switch c.Query("status") {
+	case "":
+	case "open":
+		where += " AND NOT a.acknowledged AND NOT a.resolved"
+	case "acknowledged":
+		where += " AND a.acknowledged AND NOT a.resolved"
+	case "resolved":
+		where += " AND a.resolved"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, acknowledged or resolved"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var total int
+	if err := g.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM alerts a WHERE `+where, args...).Scan(&total); err != nil {
+		g.logger.Error("Failed to count alerts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alerts"})
+		return
+	}
+
+	pages := pagination.New(page, limit, total)
+	rows, err := g.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT a.id, a.tenant_id, a.type, a.severity, a.title, a.message, a.device_id, a.user_id,
+			a.acknowledged, a.acknowledged_by, a.acknowledged_at, a.resolved, a.resolved_by, a.resolved_at,
+			a.metadata, a.created_at, a.updated_at
+		FROM alerts a
+		WHERE %s
+		ORDER BY a.created_at DESC, a.id
+		LIMIT %d OFFSET %d
+	`, where, limit, pages.Offset()), args...)
+	if err != nil {
+		g.logger.Error("Failed to list alerts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alerts"})
+		return
+	}
+	defer rows.Close()
+
+	alerts := []models.Alert{}
+	for rows.Next() {
+		var alert models.Alert
+		var deviceID sql.NullString
+		var metadata []byte
+		err := rows.Scan(&alert.ID, &alert.TenantID, &alert.Type, &alert.Severity, &alert.Title, &alert.Message,
+			&deviceID, &alert.UserID, &alert.Acknowledged, &alert.AcknowledgedBy, &alert.AcknowledgedAt,
+			&alert.Resolved, &alert.ResolvedBy, &alert.ResolvedAt, &metadata, &alert.CreatedAt, &alert.UpdatedAt)
+		if err != nil {
+			g.logger.Error("Failed to scan alert", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alerts"})
+			return
+		}
+		alert.DeviceID = deviceID.String
+		if len(metadata) > 0 {
+			json.Unmarshal(metadata, &alert.Metadata)
+		}
+		alerts = append(alerts, alert)
+	}
+	if err := rows.Err(); err != nil {
+		g.logger.Error("Failed to list alerts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alerts"})
+		return
+	}
+
+	pages.Write(c)
+	c.JSON(http.StatusOK, gin.H{
+		"alerts":     alerts,
+		"pagination": pages,
+	})
+}
+
+func (g *Gateway) AcknowledgeAlert(c *gin.Context) {
+	g.updateAlert(c, alertAcknowledge)
+}
+
+func (g *Gateway) ResolveAlert(c *gin.Context) {
+	g.updateAlert(c, alertResolve)
+}
+
+func (g *Gateway) updateAlert(c *gin.Context, action alertAction) {
+	alertID := c.Param("id")
+	if _, err := uuid.Parse(alertID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+
+	result, err := g.applyAlertAction(c.Request.Context(), middleware.TenantID(c), c.GetString("user_id"),
+		action, &bulkAlertRequest{AlertIDs: []string{alertID}, Filter: alertFilter{Regions: g.regions.Scope(c)}})
+	if err != nil {
+		g.logger.Error("Failed to update alert", "error", err, "alert_id", alertID, "action", action)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert"})
+		return
+	}
+	if result.Matched == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// BulkAcknowledgeAlerts acknowledges every open alert selected by explicit
+// IDs or a filter, e.g. after a known outage.
+func (g *Gateway) BulkAcknowledgeAlerts(c *gin.Context) {
+	g.bulkUpdateAlerts(c, alertAcknowledge)
+}
+
+// BulkResolveAlerts resolves every alert selected by explicit IDs or a
+// filter. Alerts resolved without an acknowledgement count as acknowledged
+// by the same user.
+func (g *Gateway) BulkResolveAlerts(c *gin.Context) {
+	g.bulkUpdateAlerts(c, alertResolve)
+}
+
+func (g *Gateway) bulkUpdateAlerts(c *gin.Context, action alertAction) {
+	var req bulkAlertRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	// An empty request would otherwise match every alert in the tenant
+	if len(req.AlertIDs) == 0 && req.Filter.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "alert_ids or a filter is required"})
+		return
+	}
+	if len(req.AlertIDs) > maxBulkAlertIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d alerts can be updated at once", maxBulkAlertIDs)})
+		return
+	}
+	for _, id := range req.AlertIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid alert ID %q", id)})
+			return
+		}
+	}
+
+	req.Filter.Regions = g.regions.Scope(c)
+
+	result, err := g.applyAlertAction(c.Request.Context(), middleware.TenantID(c), c.GetString("user_id"), action, &req)
+	if err != nil {
+		g.logger.Error("Failed to update alerts", "error", err, "action", action)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alerts"})
+		return
+	}
+
+	g.logger.Info("Bulk alert update", "action", action, "user_id", c.GetString("user_id"),
+		"matched", result.Matched, "updated", result.Updated)
+	c.JSON(http.StatusOK, result)
+}
+
+// applyAlertAction locks the selected alerts and updates those the action
+// still applies to in a single statement, so counts and changes agree even
+// under concurrent updates. Explicit IDs take precedence over the filter,
+// though still only reach the filter's regions.
+func (g *Gateway) applyAlertAction(ctx context.Context, tenantID, userID string, action alertAction, req *bulkAlertRequest) (*alertActionResult, error) {
+	where, args := req.Filter.conditions(tenantID)
+	if len(req.AlertIDs) > 0 {
+		where = `a.tenant_id = $1 AND a.id = ANY($2::uuid[]) AND ` + alertInRegions("$3")
+		args = []interface{}{tenantID, pq.Array(req.AlertIDs), pq.Array(req.Filter.Regions)}
+	}
+
+	var actor *uuid.UUID
+	if id, err := uuid.Parse(userID); err == nil {
+		actor = &id
+	}
+	args = append(args, actor)
+	actorParam := fmt.Sprintf("$%d::uuid", len(args))
+
+	var pending, set string
+	switch action {
+	case alertAcknowledge:
+		pending = "NOT acknowledged AND NOT resolved"
+		set = fmt.Sprintf("acknowledged = true, acknowledged_by = %s, acknowledged_at = NOW()", actorParam)
+	case alertResolve:
+		pending = "NOT resolved"
+		set = fmt.Sprintf(`resolved = true, resolved_by = %[1]s, resolved_at = NOW(),
+			acknowledged = true,
+			acknowledged_by = COALESCE(acknowledged_by, %[1]s),
+			acknowledged_at = COALESCE(acknowledged_at, NOW())`, actorParam)
+	default:
+		return nil, fmt.Errorf("unknown alert action %q", action)
+	}
+
+	query := fmt.Sprintf(`
+		WITH matched AS (
+			SELECT a.id, a.acknowledged, a.resolved FROM alerts a WHERE %s FOR UPDATE
+		), updated AS (
+			UPDATE alerts SET %s
+			WHERE id IN (SELECT id FROM matched WHERE %s)
+			RETURNING id
+		)
+		SELECT (SELECT COUNT(*) FROM matched), (SELECT COUNT(*) FROM updated)
+	`, where, set, pending)
+
+	result := &alertActionResult{Action: action}
+	if err := g.db.QueryRowContext(ctx, query, args...).Scan(&result.Matched, &result.Updated); err != nil {
+		return nil, err
+	}
+	result.Skipped = result.Matched - result.Updated
+
+	return result, nil
+}
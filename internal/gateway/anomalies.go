@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/pagination"
+)
+
+// anomalyFilter selects anomalies within the caller's tenant. From and To
+// bound the time of the reading that raised the anomaly. Regions limits
+// the caller to devices in the regions they may reach.
+type anomalyFilter struct {
+	DeviceID string     `form:"device_id"`
+	Type     string     `form:"type"`
+	Severity string     `form:"severity"`
+	From     *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Resolved *bool      `form:"resolved"`
+
+	Regions []string `form:"-"`
+}
+
+// conditions returns the WHERE clause for the filter over anomalies
+// aliased as "an" joined to their devices as "d", using parameters $1 to
+// $8. Anomalies have no tenant of their own; it is the device's.
+func (f *anomalyFilter) conditions(tenantID string) (string, []interface{}) {
+	where := `
+		d.tenant_id = $1
+		AND ($2 = '' OR an.device_id = $2)
+		AND ($3 = '' OR an.type = $3)
+		AND ($4 = '' OR an.severity = $4)
+		AND ($5::timestamptz IS NULL OR an.timestamp >= $5)
+		AND ($6::timestamptz IS NULL OR an.timestamp < $6)
+		AND ($7::boolean IS NULL OR (an.resolved_at IS NOT NULL) = $7)
+		AND ($8::text[] IS NULL OR COALESCE(d.region, '') = ANY($8))
+	`
+	return where, []interface{}{tenantID, f.DeviceID, f.Type, f.Severity, f.From, f.To, f.Resolved, pq.Array(f.Regions)}
+}
+
+// anomalyDevice is the context shown with an anomaly so it can be read
+// without looking the device up.
+type anomalyDevice struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Ward string `json:"ward,omitempty"`
+	Zone string `json:"zone,omitempty"`
+}
+
+// anomalyLinks point to related resources. Telemetry is served by the
+// device service, which holds the telemetry store.
+type anomalyLinks struct {
+	Telemetry string `json:"telemetry"`
+}
+
+type anomalyRecord struct {
+	models.Anomaly
+	Device anomalyDevice `json:"device"`
+	Links  anomalyLinks  `json:"links"`
+}
+
+const anomalyColumns = `
+	an.id, an.device_id, an.type, an.severity, COALESCE(an.description, ''), an.timestamp,
+	COALESCE(an.metric, ''), an.value,
+	an.resolved_at, an.resolved_by::text, an.created_at,
+	d.name, d.type, COALESCE(d.ward, ''), COALESCE(d.zone, '')
+`
+
+func scanAnomaly(row rowScanner) (*anomalyRecord, error) {
+	var record anomalyRecord
+	var value sql.NullFloat64
+	err := row.Scan(&record.ID, &record.DeviceID, &record.Type, &record.Severity, &record.Description,
+		&record.Timestamp, &record.Metric, &value, &record.ResolvedAt, &record.ResolvedBy, &record.CreatedAt,
+		&record.Device.Name, &record.Device.Type, &record.Device.Ward, &record.Device.Zone)
+	if err != nil {
+		return nil, err
+	}
+	if value.Valid {
+		record.Value = value.Float64
+	}
+	record.Links.Telemetry = "/api/v1/anomalies/" + record.ID + "/telemetry"
+	return &record, nil
+}
+
+// ListAnomalies returns the tenant's anomalies, most recent reading first.
+func (g *Gateway) ListAnomalies(c *gin.Context) {
+	page, limit, err := pagination.Parse(c, g.config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var filter anomalyFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Regions = g.regions.Scope(c)
+	where, args := filter.conditions(middleware.TenantID(c))
+
+	ctx := c.Request.Context()
+	var total int
+	err = g.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM anomalies an JOIN devices d ON d.id = an.device_id WHERE `+where,
+		args...).Scan(&total)
+	if err != nil {
+		g.logger.Error("Failed to count anomalies", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomalies"})
+		return
+	}
+
+	pages := pagination.New(page, limit, total)
+	rows, err := g.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM anomalies an
+		JOIN devices d ON d.id = an.device_id
+		WHERE %s
+		ORDER BY an.timestamp DESC, an.id
+		LIMIT %d OFFSET %d
+	`, anomalyColumns, where, limit, pages.Offset()), args...)
+	if err != nil {
+		g.logger.Error("Failed to list anomalies", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomalies"})
+		return
+	}
+	defer rows.Close()
+
+	anomalies := []*anomalyRecord{}
+	for rows.Next() {
+		record, err := scanAnomaly(rows)
+		if err != nil {
+			g.logger.Error("Failed to scan anomaly", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomalies"})
+			return
+		}
+		anomalies = append(anomalies, record)
+	}
+	if err := rows.Err(); err != nil {
+		g.logger.Error("Failed to list anomalies", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomalies"})
+		return
+	}
+
+	pages.Write(c)
+	c.JSON(http.StatusOK, gin.H{
+		"anomalies":  anomalies,
+		"pagination": pages,
+	})
+}
+
+func (g *Gateway) GetAnomaly(c *gin.Context) {
+	anomalyID := c.Param("id")
+	if _, err := uuid.Parse(anomalyID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Anomaly not found"})
+		return
+	}
+
+	row := g.db.QueryRowContext(c.Request.Context(), `
+		SELECT `+anomalyColumns+`
+		FROM anomalies an
+		JOIN devices d ON d.id = an.device_id
+		WHERE an.id = $1 AND d.tenant_id = $2 AND ($3::text[] IS NULL OR COALESCE(d.region, '') = ANY($3))
+	`, anomalyID, middleware.TenantID(c), pq.Array(g.regions.Scope(c)))
+	record, err := scanAnomaly(row)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Anomaly not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load anomaly", "error", err, "anomaly_id", anomalyID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anomaly"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"anomaly": record})
+}
+
+// ResolveAnomaly marks an anomaly as reviewed. Resolving twice is a no-op.
+func (g *Gateway) ResolveAnomaly(c *gin.Context) {
+	anomalyID := c.Param("id")
+	if _, err := uuid.Parse(anomalyID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Anomaly not found"})
+		return
+	}
+
+	var resolvedAt time.Time
+	err := g.db.QueryRowContext(c.Request.Context(), `
+		UPDATE anomalies an
+		SET resolved_at = COALESCE(an.resolved_at, NOW()),
+			resolved_by = COALESCE(an.resolved_by, NULLIF($3, '')::uuid)
+		FROM devices d
+		WHERE an.id = $1 AND d.id = an.device_id AND d.tenant_id = $2
+			AND ($4::text[] IS NULL OR COALESCE(d.region, '') = ANY($4))
+		RETURNING an.resolved_at
+	`, anomalyID, middleware.TenantID(c), c.GetString("user_id"), pq.Array(g.regions.Scope(c))).Scan(&resolvedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Anomaly not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to resolve anomaly", "error", err, "anomaly_id", anomalyID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve anomaly"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":          anomalyID,
+		"resolved_at": resolvedAt,
+		"message":     "Anomaly resolved",
+	})
+}
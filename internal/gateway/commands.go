@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/pagination"
+)
+
+// maxCommandExport caps a CSV export; larger ones must be narrowed by time
+// range or filters.
+const maxCommandExport = 50000
+
+// commandFilter selects commands sent to devices in the caller's tenant.
+// Type is the command name and Actor the ID of the user who issued it.
+type commandFilter struct {
+	DeviceID string     `form:"device_id"`
+	Type     string     `form:"type"`
+	Status   string     `form:"status"`
+	Actor    string     `form:"actor"`
+	From     *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// conditions returns the WHERE clause for the filter over device_commands
+// aliased as "dc" joined to their devices as "d", using parameters $1 to $7.
+func (f *commandFilter) conditions(tenantID string) (string, []interface{}) {
+	where := `
+		d.tenant_id = $1
+		AND ($2 = '' OR dc.device_id = $2)
+		AND ($3 = '' OR dc.command = $3)
+		AND ($4 = '' OR dc.status = $4)
+		AND ($5 = '' OR dc.issued_by::text = $5)
+		AND ($6::timestamptz IS NULL OR dc.timestamp >= $6)
+		AND ($7::timestamptz IS NULL OR dc.timestamp < $7)
+	`
+	return where, []interface{}{tenantID, f.DeviceID, f.Type, f.Status, f.Actor, f.From, f.To}
+}
+
+// commandRecord is a command with the names an auditor needs to read it.
+type commandRecord struct {
+	models.DeviceCommand
+	DeviceName       string `json:"device_name"`
+	IssuedByUsername string `json:"issued_by_username,omitempty"`
+}
+
+const commandColumns = `
+	dc.id, dc.device_id, dc.command, COALESCE(dc.parameters, '{}'), COALESCE(dc.sequence_id::text, ''),
+	COALESCE(dc.step, 0), dc.status, dc.timestamp, COALESCE(dc.issued_by::text, ''), COALESCE(u.username, ''),
+	d.name
+`
+
+const commandTables = `
+	device_commands dc
+	JOIN devices d ON d.id = dc.device_id
+	LEFT JOIN users u ON u.id = dc.issued_by
+`
+
+func scanCommand(row rowScanner) (*commandRecord, error) {
+	var record commandRecord
+	var parameters []byte
+	err := row.Scan(&record.ID, &record.DeviceID, &record.Command, &parameters, &record.SequenceID,
+		&record.Step, &record.Status, &record.Timestamp, &record.IssuedBy, &record.IssuedByUsername,
+		&record.DeviceName)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(parameters, &record.Parameters); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ListCommands returns the commands sent to the tenant's devices, newest
+// first. With format=csv every matching command is exported instead of a
+// page.
+func (g *Gateway) ListCommands(c *gin.Context) {
+	var filter commandFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	g.listCommands(c, &filter)
+}
+
+// ListDeviceCommands is ListCommands for one device.
+func (g *Gateway) ListDeviceCommands(c *gin.Context) {
+	var filter commandFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.DeviceID = c.Param("id")
+
+	_, err := g.loadDevice(c.Request.Context(), middleware.TenantID(c), filter.DeviceID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load device", "error", err, "device_id", filter.DeviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve commands"})
+		return
+	}
+
+	g.listCommands(c, &filter)
+}
+
+func (g *Gateway) listCommands(c *gin.Context, filter *commandFilter) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	page, limit, err := pagination.Parse(c, g.config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	where, args := filter.conditions(middleware.TenantID(c))
+
+	ctx := c.Request.Context()
+	var total int
+	err = g.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+commandTables+` WHERE `+where, args...).Scan(&total)
+	if err != nil {
+		g.logger.Error("Failed to count commands", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve commands"})
+		return
+	}
+
+	if format == "csv" {
+		if total > maxCommandExport {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("%d commands match; narrow the filters to export at most %d", total, maxCommandExport),
+			})
+			return
+		}
+		g.exportCommands(c, where, args)
+		return
+	}
+
+	pages := pagination.New(page, limit, total)
+	rows, err := g.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE %s
+		ORDER BY dc.timestamp DESC, dc.id
+		LIMIT %d OFFSET %d
+	`, commandColumns, commandTables, where, limit, pages.Offset()), args...)
+	if err != nil {
+		g.logger.Error("Failed to list commands", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve commands"})
+		return
+	}
+	defer rows.Close()
+
+	commands := []*commandRecord{}
+	for rows.Next() {
+		record, err := scanCommand(rows)
+		if err != nil {
+			g.logger.Error("Failed to scan command", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve commands"})
+			return
+		}
+		commands = append(commands, record)
+	}
+	if err := rows.Err(); err != nil {
+		g.logger.Error("Failed to list commands", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve commands"})
+		return
+	}
+
+	pages.Write(c)
+	c.JSON(http.StatusOK, gin.H{
+		"commands":   commands,
+		"pagination": pages,
+	})
+}
+
+// exportCommands streams matching commands as CSV, oldest first so the
+// file reads as a log.
+func (g *Gateway) exportCommands(c *gin.Context, where string, args []interface{}) {
+	rows, err := g.db.QueryContext(c.Request.Context(), fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE %s
+		ORDER BY dc.timestamp, dc.id
+	`, commandColumns, commandTables, where), args...)
+	if err != nil {
+		g.logger.Error("Failed to export commands", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export commands"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="commands-%s.csv"`, time.Now().UTC().Format("20060102-150405")))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "timestamp", "device_id", "device_name", "command", "parameters", "status",
+		"issued_by", "issued_by_username", "sequence_id", "step"})
+	for rows.Next() {
+		record, err := scanCommand(rows)
+		if err != nil {
+			// Headers are already sent; a truncated file is all we can signal
+			g.logger.Error("Failed to scan command for export", "error", err)
+			break
+		}
+
+		parameters, _ := json.Marshal(record.Parameters)
+		step := ""
+		if record.SequenceID != "" {
+			step = strconv.Itoa(record.Step)
+		}
+		w.Write([]string{record.ID, record.Timestamp.UTC().Format(time.RFC3339), record.DeviceID,
+			record.DeviceName, record.Command, string(parameters), record.Status, record.IssuedBy,
+			record.IssuedByUsername, record.SequenceID, step})
+	}
+	if err := rows.Err(); err != nil {
+		g.logger.Error("Failed to export commands", "error", err)
+	}
+	w.Flush()
+
+	g.logger.Info("Commands exported", "user_id", c.GetString("user_id"))
+}
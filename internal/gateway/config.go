@@ -0,0 +1,19 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetEffectiveConfig returns the configuration this gateway loaded, after
+// defaults and environment overrides, with credentials redacted. It covers
+// every tenant, so only super admins may read it.
+func (g *Gateway) GetEffectiveConfig(c *gin.Context) {
+	if c.GetString("role") != "super_admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient privileges"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"config": g.config.Redacted()})
+}
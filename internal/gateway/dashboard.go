@@ -0,0 +1,276 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceaccess"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
+	"github.com/bhanukaranwal/urbanzen/pkg/httpcache"
+)
+
+// The summary is polled by every open dashboard, so it is computed at most
+// once per scope in this window. Nothing invalidates it; it is as stale as
+// the TTL at worst. A summary is shared by everyone in its scope, so it is
+// computed under its own timeout rather than the request that missed.
+const (
+	dashboardSummaryTTL        = 30 * time.Second
+	dashboardSummaryMaxEntries = 1000
+	dashboardSummaryTimeout    = 20 * time.Second
+)
+
+func newDashboardSummaries() *cache.Cache[string, *dashboardSummary] {
+	return cache.New[string, *dashboardSummary]("dashboard_summaries", dashboardSummaryTTL, dashboardSummaryMaxEntries)
+}
+
+type dashboardSummary struct {
+	Ward        string                        `json:"ward,omitempty"`
+	Devices     deviceStatusCounts            `json:"devices"`
+	Alerts      activeAlertCounts             `json:"alerts"`
+	Consumption map[string]utilityConsumption `json:"consumption"`
+	Collection  collectionSummary             `json:"collection"`
+	GeneratedAt time.Time                     `json:"generated_at"`
+}
+
+type deviceStatusCounts struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+}
+
+// activeAlertCounts counts unresolved alerts, acknowledged or not.
+type activeAlertCounts struct {
+	Total      int            `json:"total"`
+	BySeverity map[string]int `json:"by_severity"`
+}
+
+// utilityConsumption is what a utility's meters used since midnight in the
+// tenant's time zone.
+type utilityConsumption struct {
+	Consumption float64   `json:"consumption"`
+	Unit        string    `json:"unit,omitempty"`
+	Devices     int       `json:"devices"`
+	From        time.Time `json:"from"`
+}
+
+// collectionSummary covers bills falling due from the start of the month
+// through today. Rate is nil when none did.
+type collectionSummary struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Bills     int      `json:"bills"`
+	Billed    float64  `json:"billed"`
+	Collected float64  `json:"collected"`
+	Rate      *float64 `json:"rate"`
+}
+
+// GetDashboardSummary returns the aggregates the main dashboard opens with
+// in one response: devices by status, active alerts by severity, today's
+// water and electricity consumption and this month's bill collection. ward
+// narrows everything to one ward; the caller's regions and device
+// assignments always apply.
+func (g *Gateway) GetDashboardSummary(c *gin.Context) {
+	scope := summaryScope{
+		tenantID:   middleware.TenantID(c),
+		ward:       c.Query("ward"),
+		regions:    g.regions.Scope(c),
+		assignedTo: deviceaccess.AssignedTo(c),
+	}
+
+	summary, err := g.summaries.Load(scope.key(), func() (*dashboardSummary, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), dashboardSummaryTimeout)
+		defer cancel()
+		return g.dashboardSummary(ctx, scope)
+	})
+	if err != nil {
+		g.logger.Error("Failed to compute dashboard summary", "error", err, "tenant_id", scope.tenantID, "ward", scope.ward)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve dashboard summary"})
+		return
+	}
+
+	httpcache.JSON(c, http.StatusOK, summary, httpcache.Status)
+}
+
+// summaryScope is everything a summary is narrowed by. regions and
+// assignedTo come from the caller, never the request.
+type summaryScope struct {
+	tenantID   string
+	ward       string
+	regions    []string
+	assignedTo *string
+}
+
+// key identifies the scope in the summary cache. A nil regions (every
+// region) is kept apart from an empty one, and a nil assignedTo (every
+// device) from any user.
+func (s summaryScope) key() string {
+	regions := "*"
+	if s.regions != nil {
+		sorted := append([]string(nil), s.regions...)
+		sort.Strings(sorted)
+		regions = "[" + strings.Join(sorted, ",") + "]"
+	}
+	assigned := "*"
+	if s.assignedTo != nil {
+		assigned = "user:" + *s.assignedTo
+	}
+	return s.tenantID + "\x00" + s.ward + "\x00" + regions + "\x00" + assigned
+}
+
+// devices returns the conditions selecting the devices in scope.
+func (s summaryScope) devices() *deviceConditions {
+	return newDeviceConditions(s.tenantID).
+		equal(deviceWardColumn, s.ward).
+		assignedTo(s.assignedTo).
+		inRegions(s.regions)
+}
+
+func (g *Gateway) dashboardSummary(ctx context.Context, scope summaryScope) (*dashboardSummary, error) {
+	tenantID, ward := scope.tenantID, scope.ward
+	location := time.UTC
+	if tenantConfig, err := g.tenants.Resolve(ctx, tenantID); err == nil {
+		location = tenantConfig.Location()
+	} else {
+		g.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", tenantID)
+	}
+	now := time.Now().In(location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+
+	summary := &dashboardSummary{Ward: ward, GeneratedAt: now}
+
+	devices, err := g.countDevicesByStatus(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	summary.Devices = devices
+
+	alerts, err := g.countActiveAlerts(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	summary.Alerts = alerts
+
+	summary.Consumption = make(map[string]utilityConsumption, 2)
+	for utility, meter := range map[string]utilityMeter{"water": waterMeter, "electricity": electricityMeter} {
+		used, err := g.consumptionSince(ctx, scope, meter, midnight, now)
+		if err != nil {
+			return nil, err
+		}
+		summary.Consumption[utility] = used
+	}
+
+	collection, err := g.collectionSince(ctx, scope, midnight.AddDate(0, 0, 1-midnight.Day()), midnight)
+	if err != nil {
+		return nil, err
+	}
+	summary.Collection = collection
+
+	return summary, nil
+}
+
+func (g *Gateway) countDevicesByStatus(ctx context.Context, scope summaryScope) (deviceStatusCounts, error) {
+	counts := deviceStatusCounts{ByStatus: map[string]int{}}
+
+	where, args := scope.devices().where()
+	rows, err := g.db.QueryContext(ctx, `
+		SELECT d.status, COUNT(*) FROM devices d WHERE `+where+` GROUP BY d.status
+	`, args...)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		counts.ByStatus[status] = count
+		counts.Total += count
+	}
+	return counts, rows.Err()
+}
+
+func (g *Gateway) countActiveAlerts(ctx context.Context, scope summaryScope) (activeAlertCounts, error) {
+	counts := activeAlertCounts{BySeverity: map[string]int{}}
+
+	filter := alertFilter{Ward: scope.ward, Regions: scope.regions, AssignedTo: scope.assignedTo}
+	where, args := filter.conditions(scope.tenantID)
+	rows, err := g.db.QueryContext(ctx, `
+		SELECT a.severity, COUNT(*) FROM alerts a WHERE `+where+` AND NOT a.resolved GROUP BY a.severity
+	`, args...)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var severity string
+		var count int
+		if err := rows.Scan(&severity, &count); err != nil {
+			return counts, err
+		}
+		counts.BySeverity[severity] = count
+		counts.Total += count
+	}
+	return counts, rows.Err()
+}
+
+// consumptionSince totals what a utility's meters in scope used between
+// from and to.
+func (g *Gateway) consumptionSince(ctx context.Context, scope summaryScope, meter utilityMeter,
+	from, to time.Time) (utilityConsumption, error) {
+	used := utilityConsumption{Unit: g.metricUnit(ctx, meter.deviceType, meter.metric), From: from}
+
+	where, args := scope.devices().equal(deviceTypeColumn, meter.deviceType).where()
+	var deviceIDs []string
+	if err := g.db.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(d.id), '{}') FROM devices d WHERE `+where, args...).Scan(pq.Array(&deviceIDs)); err != nil {
+		return used, err
+	}
+	used.Devices = len(deviceIDs)
+	if len(deviceIDs) == 0 {
+		return used, nil
+	}
+
+	total, err := g.meterConsumption(ctx, deviceIDs, meter.metric, from, to)
+	if err != nil {
+		return used, err
+	}
+	used.Consumption = total
+	return used, nil
+}
+
+// collectionSince sums the bills in scope falling due from from through to,
+// and how much of them has been paid. Bills are scoped through their meter;
+// bills without one are counted only when no ward is asked for and the
+// caller isn't limited to assigned devices.
+func (g *Gateway) collectionSince(ctx context.Context, scope summaryScope, from, to time.Time) (collectionSummary, error) {
+	collection := collectionSummary{From: from.Format("2006-01-02"), To: to.Format("2006-01-02")}
+
+	err := g.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(b.amount), 0), COALESCE(SUM(b.amount_paid), 0)
+		FROM bills b
+		WHERE b.tenant_id = $1 AND b.due_date >= $2::date AND b.due_date <= $3::date
+			AND ($4 = '' OR b.device_id IN (SELECT id FROM devices WHERE tenant_id = $1 AND ward = $4))
+			AND ($5::text[] IS NULL OR b.device_id IS NULL OR b.device_id IN (
+				SELECT id FROM devices WHERE tenant_id = $1 AND COALESCE(region, '') = ANY($5)))
+			AND ($6::text IS NULL OR b.device_id IN (SELECT device_id FROM device_assignments WHERE user_id::text = $6))
+	`, scope.tenantID, collection.From, collection.To, scope.ward, pq.Array(scope.regions), scope.assignedTo).Scan(
+		&collection.Bills, &collection.Billed, &collection.Collected)
+	if err != nil {
+		return collection, err
+	}
+
+	if collection.Billed > 0 {
+		rate := collection.Collected / collection.Billed
+		collection.Rate = &rate
+	}
+	return collection, nil
+}
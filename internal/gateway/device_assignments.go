@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+// ListDeviceAssignments returns the users who can see a device's data.
+func (g *Gateway) ListDeviceAssignments(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	assignments, err := g.access.ForDevice(c.Request.Context(), middleware.TenantID(c), deviceID)
+	if err != nil {
+		g.logger.Error("Failed to list device assignments", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device assignments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":   deviceID,
+		"assignments": assignments,
+	})
+}
+
+// AssignDevice gives a user, typically the citizen the meter belongs to,
+// access to a device's data.
+func (g *Gateway) AssignDevice(c *gin.Context) {
+	deviceID, userID := c.Param("id"), c.Param("user_id")
+
+	err := g.access.Assign(c.Request.Context(), middleware.TenantID(c), deviceID, userID, c.GetString("user_id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device or user not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to assign device", "error", err, "device_id", deviceID, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign device"})
+		return
+	}
+
+	g.logger.Info("Device assigned", "device_id", deviceID, "user_id", userID, "assigned_by", c.GetString("user_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"user_id":   userID,
+		"message":   "Device assigned successfully",
+	})
+}
+
+// UnassignDevice removes a user's access to a device's data.
+func (g *Gateway) UnassignDevice(c *gin.Context) {
+	deviceID, userID := c.Param("id"), c.Param("user_id")
+
+	err := g.access.Unassign(c.Request.Context(), middleware.TenantID(c), deviceID, userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device is not assigned to this user"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to unassign device", "error", err, "device_id", deviceID, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unassign device"})
+		return
+	}
+
+	g.logger.Info("Device unassigned", "device_id", deviceID, "user_id", userID, "removed_by", c.GetString("user_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"user_id":   userID,
+		"message":   "Device unassigned successfully",
+	})
+}
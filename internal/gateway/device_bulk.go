@@ -0,0 +1,363 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceconfig"
+	"github.com/bhanukaranwal/urbanzen/internal/devicelifecycle"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+// Per-device outcomes of a bulk update
+const (
+	bulkUpdated   = "updated"
+	bulkUnchanged = "unchanged"
+	bulkFailed    = "failed"
+)
+
+type bulkDeviceUpdate struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	// Merged key by key into each device's configuration
+	Configuration map[string]interface{} `json:"configuration"`
+	// An empty string removes the devices from their ward
+	Ward *string `json:"ward"`
+}
+
+func (u *bulkDeviceUpdate) empty() bool {
+	return u.Status == "" && len(u.Configuration) == 0 && u.Ward == nil
+}
+
+type bulkDeviceUpdateRequest struct {
+	DeviceIDs []string         `json:"device_ids"`
+	Filter    deviceFilter     `json:"filter"`
+	Update    bulkDeviceUpdate `json:"update"`
+	// Send a configure command to each device whose configuration changed
+	DispatchConfig bool `json:"dispatch_config"`
+	Confirm        bool `json:"confirm"`
+}
+
+type bulkDeviceResult struct {
+	DeviceID   string   `json:"device_id"`
+	Result     string   `json:"result"`
+	Changed    []string `json:"changed,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Dispatched bool     `json:"command_dispatched,omitempty"`
+}
+
+// bulkTarget is a device locked for a bulk update.
+type bulkTarget struct {
+	id            string
+	deviceType    string
+	status        string
+	ward          string
+	configuration map[string]interface{}
+}
+
+// BulkUpdateDevices applies one partial update to many devices, selected by
+// explicit IDs or by a filter. The update is all or nothing: if any device
+// can't take it, for example because its lifecycle forbids the new status or
+// the merged configuration breaks its type's schema, nothing is changed and
+// the per-device report says why. Configure commands are only sent once the
+// changes are committed.
+func (g *Gateway) BulkUpdateDevices(c *gin.Context) {
+	var req bulkDeviceUpdateRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	if err := req.Filter.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.DeviceIDs) == 0 && req.Filter.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_ids or a filter is required"})
+		return
+	}
+	if req.Update.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "update must set status, configuration or ward"})
+		return
+	}
+	if req.Update.Status != "" && !devicelifecycle.Valid(req.Update.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown device status: " + req.Update.Status})
+		return
+	}
+	if req.DispatchConfig && len(req.Update.Configuration) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dispatch_config requires a configuration update"})
+		return
+	}
+
+	maxDevices := g.config.Devices.BulkUpdate.MaxDevices
+	if len(req.DeviceIDs) > maxDevices {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d devices can be updated at once", maxDevices)})
+		return
+	}
+
+	req.Filter.Regions = g.regions.Scope(c)
+
+	ctx := c.Request.Context()
+
+	tx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		g.logger.Error("Failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update devices"})
+		return
+	}
+	defer tx.Rollback()
+
+	targets, err := lockBulkTargets(ctx, tx, middleware.TenantID(c), &req, maxDevices)
+	if err != nil {
+		g.logger.Error("Failed to lock devices for bulk update", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update devices"})
+		return
+	}
+
+	if len(targets) > maxDevices {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Filter matches more than %d devices, narrow it down", maxDevices)})
+		return
+	}
+	if confirmAbove := g.config.Devices.BulkUpdate.ConfirmAbove; len(targets) > confirmAbove && !req.Confirm {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   fmt.Sprintf("Update affects %d devices, resend with \"confirm\": true to apply it", len(targets)),
+			"matched": len(targets),
+		})
+		return
+	}
+
+	results, configured, err := g.applyBulkUpdate(ctx, tx, c.GetString("user_id"), &req, targets)
+	if err != nil {
+		g.logger.Error("Failed to apply bulk device update", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update devices"})
+		return
+	}
+
+	summary := gin.H{
+		"applied":   false,
+		"matched":   len(targets),
+		"updated":   countResults(results, bulkUpdated),
+		"unchanged": countResults(results, bulkUnchanged),
+		"failed":    countResults(results, bulkFailed),
+		"results":   results,
+	}
+	if countResults(results, bulkFailed) > 0 {
+		summary["error"] = "No devices were updated because some could not take the update"
+		c.JSON(http.StatusUnprocessableEntity, summary)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		g.logger.Error("Failed to commit bulk device update", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update devices"})
+		return
+	}
+	summary["applied"] = true
+
+	if req.DispatchConfig {
+		summary["commands_dispatched"] = g.dispatchConfiguration(results, configured, c.GetString("user_id"))
+	}
+
+	g.logger.Info("Bulk device update", "user_id", c.GetString("user_id"),
+		"matched", len(targets), "updated", summary["updated"])
+	c.JSON(http.StatusOK, summary)
+}
+
+// lockBulkTargets locks the devices the request selects, in ID order so
+// concurrent bulk updates can't deadlock. It fetches one row past limit so
+// callers can detect an oversized filter. Explicit IDs take precedence
+// over the filter; IDs that don't match a device in the tenant, or are in
+// a region the caller may not reach, come back with an empty type so they
+// can be reported.
+func lockBulkTargets(ctx context.Context, tx *sql.Tx, tenantID string, req *bulkDeviceUpdateRequest, limit int) ([]bulkTarget, error) {
+	where, args := req.Filter.conditions(tenantID)
+	if len(req.DeviceIDs) > 0 {
+		where, args = newDeviceConditions(tenantID).ids(req.DeviceIDs).inRegions(req.Filter.Regions).where()
+	}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT d.id, d.type, d.status, COALESCE(d.ward, ''), COALESCE(d.configuration, '{}')
+		FROM devices d
+		WHERE %s
+		ORDER BY d.id
+		LIMIT %d
+		FOR UPDATE
+	`, where, limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	var targets []bulkTarget
+	for rows.Next() {
+		var target bulkTarget
+		var configurationJSON []byte
+		if err := rows.Scan(&target.id, &target.deviceType, &target.status, &target.ward, &configurationJSON); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(configurationJSON, &target.configuration); err != nil {
+			return nil, err
+		}
+		found[target.id] = true
+		targets = append(targets, target)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range req.DeviceIDs {
+		if !found[id] {
+			found[id] = true
+			targets = append(targets, bulkTarget{id: id})
+		}
+	}
+
+	return targets, nil
+}
+
+// applyBulkUpdate updates each target within tx and reports the outcome per
+// device, along with the new configuration of every device whose
+// configuration changed. A device the update can't apply to is reported as
+// failed and the caller must roll back.
+func (g *Gateway) applyBulkUpdate(ctx context.Context, tx *sql.Tx, actorID string, req *bulkDeviceUpdateRequest,
+	targets []bulkTarget) ([]bulkDeviceResult, map[string]map[string]interface{}, error) {
+	update := &req.Update
+	types := make(map[string]*devicetype.DeviceType)
+	configured := make(map[string]map[string]interface{})
+	results := make([]bulkDeviceResult, 0, len(targets))
+
+	for _, target := range targets {
+		result := bulkDeviceResult{DeviceID: target.id, Result: bulkUnchanged}
+		fail := func(reason string) {
+			result.Result = bulkFailed
+			result.Error = reason
+			results = append(results, result)
+		}
+
+		if target.deviceType == "" {
+			fail("Device not found")
+			continue
+		}
+
+		if update.Status != "" && update.Status != target.status && !devicelifecycle.CanTransition(target.status, update.Status) {
+			fail(fmt.Sprintf("Cannot change status from %s to %s", target.status, update.Status))
+			continue
+		}
+
+		var configuration map[string]interface{}
+		if len(update.Configuration) > 0 {
+			deviceType, ok := types[target.deviceType]
+			if !ok {
+				var err error
+				deviceType, err = g.types.Get(ctx, target.deviceType)
+				if err != nil {
+					return nil, nil, fmt.Errorf("loading device type %s: %w", target.deviceType, err)
+				}
+				types[target.deviceType] = deviceType
+			}
+
+			merged := devicetype.Merge(target.configuration, update.Configuration)
+			if err := deviceType.ConfigSchema.Validate(merged); err != nil {
+				fail(err.Error())
+				continue
+			}
+			if !jsonEqual(merged, target.configuration) {
+				configuration = merged
+			}
+		}
+
+		if configuration != nil {
+			configurationJSON, _ := json.Marshal(configuration)
+			if _, err := tx.ExecContext(ctx, `UPDATE devices SET configuration = $1 WHERE id = $2`, configurationJSON, target.id); err != nil {
+				return nil, nil, fmt.Errorf("updating configuration of %s: %w", target.id, err)
+			}
+			if err := deviceconfig.Record(ctx, tx, target.id, configurationJSON, actorID); err != nil {
+				return nil, nil, fmt.Errorf("recording configuration of %s: %w", target.id, err)
+			}
+			configured[target.id] = configuration
+			result.Changed = append(result.Changed, "configuration")
+		}
+
+		if update.Ward != nil && *update.Ward != target.ward {
+			if _, err := tx.ExecContext(ctx, `UPDATE devices SET ward = NULLIF($1, '') WHERE id = $2`, *update.Ward, target.id); err != nil {
+				return nil, nil, fmt.Errorf("updating ward of %s: %w", target.id, err)
+			}
+			result.Changed = append(result.Changed, "ward")
+		}
+
+		if update.Status != "" && update.Status != target.status {
+			err := devicelifecycle.Transition(ctx, tx, target.id, target.status, update.Status, update.Reason, actorID)
+			if err != nil {
+				return nil, nil, fmt.Errorf("changing status of %s: %w", target.id, err)
+			}
+			result.Changed = append(result.Changed, "status")
+		}
+
+		if len(result.Changed) > 0 {
+			result.Result = bulkUpdated
+		}
+		results = append(results, result)
+	}
+
+	return results, configured, nil
+}
+
+// dispatchConfiguration sends each reconfigured device its new
+// configuration and marks the results it reached. Failures are logged
+// rather than returned because the registry change is already committed;
+// the report shows which devices still need the command.
+func (g *Gateway) dispatchConfiguration(results []bulkDeviceResult, configured map[string]map[string]interface{}, actorID string) int {
+	topic := g.config.Kafka.Topics.Commands
+	if topic == "" {
+		topic = "device-commands"
+	}
+
+	dispatched := 0
+	for i := range results {
+		configuration, ok := configured[results[i].DeviceID]
+		if !ok {
+			continue
+		}
+
+		message, _ := json.Marshal(models.DeviceCommand{
+			DeviceID:   results[i].DeviceID,
+			Command:    devicetype.ConfigureCommand,
+			Parameters: configuration,
+			Status:     "pending",
+			Timestamp:  time.Now(),
+			IssuedBy:   actorID,
+		})
+		if err := g.producer.ProduceMessage(topic, results[i].DeviceID, message); err != nil {
+			g.logger.Error("Failed to dispatch configuration", "error", err, "device_id", results[i].DeviceID)
+			continue
+		}
+		results[i].Dispatched = true
+		dispatched++
+	}
+
+	return dispatched
+}
+
+func countResults(results []bulkDeviceResult, outcome string) int {
+	count := 0
+	for _, result := range results {
+		if result.Result == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// jsonEqual compares two configurations as they would be stored.
+func jsonEqual(a, b map[string]interface{}) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceconfig"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+type asOfQuery struct {
+	AsOf *time.Time `form:"as_of" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// at is the requested time, or now if none was given.
+func (q *asOfQuery) at() time.Time {
+	if q.AsOf == nil {
+		return time.Now()
+	}
+	return *q.AsOf
+}
+
+// GetDeviceConfig returns a device's configuration as it was at the
+// as_of time, or as it is now, with when and by whom that version was set.
+func (g *Gateway) GetDeviceConfig(c *gin.Context) {
+	var query asOfQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	at := query.at()
+
+	ctx := c.Request.Context()
+	device, err := g.loadDevice(ctx, middleware.TenantID(c), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load device", "error", err, "device_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device configuration"})
+		return
+	}
+
+	version, err := deviceconfig.AsOf(ctx, g.db, device.ID, at)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device was not registered at that time"})
+		return
+	}
+	if err == deviceconfig.ErrBeforeHistory {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Configuration history does not go back to that time"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load device configuration history", "error", err, "device_id", device.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device configuration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": device.ID,
+		"as_of":     at,
+		"version":   version,
+	})
+}
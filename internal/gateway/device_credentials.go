@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/devicecred"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+type deviceCredentialRequest struct {
+	Kind string `json:"kind" binding:"required"`
+}
+
+// ListDeviceCredentials returns a device's credentials without secrets.
+func (g *Gateway) ListDeviceCredentials(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	credentials, err := g.credentials.List(c.Request.Context(), middleware.TenantID(c), deviceID)
+	if err != nil {
+		g.logger.Error("Failed to list device credentials", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device credentials"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":   deviceID,
+		"credentials": credentials,
+	})
+}
+
+// IssueDeviceCredential adds a credential alongside the device's existing
+// ones. The secret is only ever returned in this response.
+func (g *Gateway) IssueDeviceCredential(c *gin.Context) {
+	g.issueDeviceCredential(c, false)
+}
+
+// RotateDeviceCredential issues a new credential and lets the device's
+// others of the same kind expire after devices.credentials.rotation_grace.
+func (g *Gateway) RotateDeviceCredential(c *gin.Context) {
+	g.issueDeviceCredential(c, true)
+}
+
+func (g *Gateway) issueDeviceCredential(c *gin.Context, rotate bool) {
+	var req deviceCredentialRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	ctx, tenantID, deviceID, actorID := c.Request.Context(), middleware.TenantID(c), c.Param("id"), c.GetString("user_id")
+	issue := g.credentials.Issue
+	if rotate {
+		issue = g.credentials.Rotate
+	}
+
+	issued, err := issue(ctx, tenantID, deviceID, req.Kind, actorID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found or decommissioned"})
+		return
+	}
+	if err == devicecred.ErrUnknownKind || err == devicecred.ErrCertificatesDisabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to issue device credential", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue device credential"})
+		return
+	}
+
+	g.logger.Info("Device credential issued", "device_id", deviceID, "credential_id", issued.Credential.ID,
+		"kind", issued.Credential.Kind, "rotate", rotate, "issued_by", actorID)
+	c.JSON(http.StatusCreated, issued)
+}
+
+// RevokeDeviceCredential revokes a credential immediately. Services that
+// have recently authenticated it may accept it for a further 30 seconds.
+func (g *Gateway) RevokeDeviceCredential(c *gin.Context) {
+	deviceID, credentialID := c.Param("id"), c.Param("credential_id")
+
+	err := g.credentials.Revoke(c.Request.Context(), middleware.TenantID(c), deviceID, credentialID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device credential not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to revoke device credential", "error", err, "device_id", deviceID, "credential_id", credentialID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device credential"})
+		return
+	}
+
+	g.logger.Info("Device credential revoked", "device_id", deviceID, "credential_id", credentialID,
+		"revoked_by", c.GetString("user_id"))
+	c.JSON(http.StatusOK, gin.H{"message": "Device credential revoked"})
+}
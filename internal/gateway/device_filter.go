@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// deviceFilter selects devices within the caller's tenant. A device must
+// carry every listed tag to match. Search matches part of a device's name
+// or ID, and BBox is "min_lon,min_lat,max_lon,max_lat". AssignedTo, when
+// set, limits a citizen to their own devices, and Regions the caller to
+// the regions they may reach; neither is ever taken from the request.
+type deviceFilter struct {
+	Type   string   `json:"type" form:"type"`
+	Ward   string   `json:"ward" form:"ward"`
+	Zone   string   `json:"zone" form:"zone"`
+	Status string   `json:"status" form:"status"`
+	Tags   []string `json:"tags" form:"tag"`
+	Search string   `json:"q" form:"q"`
+	BBox   string   `json:"bbox" form:"bbox"`
+
+	AssignedTo *string  `json:"-" form:"-"`
+	Regions    []string `json:"-" form:"-"`
+
+	// box is BBox parsed by normalize
+	box *boundingBox
+}
+
+// boundingBox is an area in WGS84 degrees.
+type boundingBox struct {
+	MinLongitude, MinLatitude, MaxLongitude, MaxLatitude float64
+}
+
+func (f *deviceFilter) empty() bool {
+	return f.Type == "" && f.Ward == "" && f.Zone == "" && f.Status == "" && len(f.Tags) == 0 &&
+		f.Search == "" && f.BBox == ""
+}
+
+// normalize puts the filter's tags in the form they are stored in and
+// parses its bounding box.
+func (f *deviceFilter) normalize() error {
+	tags, err := normalizeTags(f.Tags)
+	if err != nil {
+		return err
+	}
+	f.Tags = tags
+	f.Search = strings.TrimSpace(f.Search)
+
+	f.box = nil
+	if f.BBox != "" {
+		box, err := parseBoundingBox(f.BBox)
+		if err != nil {
+			return err
+		}
+		f.box = box
+	}
+	return nil
+}
+
+// conditions returns the WHERE clause for the filter over devices aliased
+// as "d" and the arguments it binds.
+func (f *deviceFilter) conditions(tenantID string) (string, []interface{}) {
+	return newDeviceConditions(tenantID).
+		equal(deviceTypeColumn, f.Type).
+		equal(deviceWardColumn, f.Ward).
+		equal(deviceZoneColumn, f.Zone).
+		equal(deviceStatusColumn, f.Status).
+		tags(f.Tags).
+		search(f.Search).
+		within(f.box).
+		assignedTo(f.AssignedTo).
+		inRegions(f.Regions).
+		where()
+}
+
+func parseBoundingBox(value string) (*boundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox must be min_lon,min_lat,max_lon,max_lat")
+	}
+
+	var corners [4]float64
+	for i, part := range parts {
+		corner, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bbox must be min_lon,min_lat,max_lon,max_lat")
+		}
+		corners[i] = corner
+	}
+
+	box := &boundingBox{MinLongitude: corners[0], MinLatitude: corners[1], MaxLongitude: corners[2], MaxLatitude: corners[3]}
+	switch {
+	case box.MinLongitude < -180 || box.MaxLongitude > 180 || box.MinLatitude < -90 || box.MaxLatitude > 90:
+		return nil, fmt.Errorf("bbox longitudes must be within ±180 and latitudes within ±90")
+	case box.MinLongitude >= box.MaxLongitude || box.MinLatitude >= box.MaxLatitude:
+		return nil, fmt.Errorf("bbox minimums must be less than its maximums")
+	}
+	return box, nil
+}
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/geofence"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+type geofenceRequest struct {
+	Center       *models.Location  `json:"center"`
+	RadiusMeters float64           `json:"radius_m"`
+	Polygon      []models.Location `json:"polygon"`
+	Severity     string            `json:"severity"`
+	Enabled      *bool             `json:"enabled"`
+}
+
+func (g *Gateway) GetDeviceGeofence(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	fence, err := g.fences.Get(c.Request.Context(), middleware.TenantID(c), deviceID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device has no geofence"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load geofence", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve geofence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"geofence": fence})
+}
+
+// SaveDeviceGeofence sets the area a device must stay within, as a center
+// and radius in meters or a polygon. Fences are enabled unless the request
+// says otherwise; ingestion picks up changes within a minute.
+func (g *Gateway) SaveDeviceGeofence(c *gin.Context) {
+	var req geofenceRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	fence := &geofence.Fence{
+		DeviceID:     c.Param("id"),
+		Center:       req.Center,
+		RadiusMeters: req.RadiusMeters,
+		Polygon:      req.Polygon,
+		Severity:     req.Severity,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+	}
+	if fence.Severity == "" {
+		fence.Severity = "warning"
+	}
+
+	ctx, tenantID := c.Request.Context(), middleware.TenantID(c)
+	err := g.fences.Save(ctx, tenantID, fence, c.GetString("user_id"))
+	if validationErr, ok := err.(*geofence.ValidationError); ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid geofence",
+			"violations": validationErr.Violations,
+		})
+		return
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to save geofence", "error", err, "device_id", fence.DeviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save geofence"})
+		return
+	}
+
+	saved, err := g.fences.Get(ctx, tenantID, fence.DeviceID)
+	if err != nil {
+		g.logger.Error("Failed to load saved geofence", "error", err, "device_id", fence.DeviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve geofence"})
+		return
+	}
+
+	g.logger.Info("Geofence saved", "device_id", fence.DeviceID, "enabled", fence.Enabled, "updated_by", c.GetString("user_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"geofence": saved,
+		"message":  "Geofence saved successfully",
+	})
+}
+
+func (g *Gateway) DeleteDeviceGeofence(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	err := g.fences.Delete(c.Request.Context(), middleware.TenantID(c), deviceID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device has no geofence"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to delete geofence", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete geofence"})
+		return
+	}
+
+	g.logger.Info("Geofence removed", "device_id", deviceID, "removed_by", c.GetString("user_id"))
+	c.JSON(http.StatusOK, gin.H{"message": "Geofence removed"})
+}
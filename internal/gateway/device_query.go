@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// deviceColumn is a column of devices aliased as "d" that conditions may
+// compare. Only the constants below exist, so a column name can never come
+// from a request.
+type deviceColumn string
+
+const (
+	deviceTypeColumn   deviceColumn = "d.type"
+	deviceWardColumn   deviceColumn = "d.ward"
+	deviceZoneColumn   deviceColumn = "d.zone"
+	deviceStatusColumn deviceColumn = "d.status"
+)
+
+// deviceConditions builds a WHERE clause over devices aliased as "d". Each
+// method adds one condition, binding its values as numbered parameters, so
+// callers compose filters without writing placeholders or splicing values
+// into SQL. Conditions are ANDed and always start with the tenant.
+type deviceConditions struct {
+	clauses []string
+	args    []interface{}
+}
+
+func newDeviceConditions(tenantID string) *deviceConditions {
+	q := &deviceConditions{}
+	q.add("d.tenant_id = " + q.param(tenantID))
+	return q
+}
+
+// param binds a value and returns its placeholder.
+func (q *deviceConditions) param(value interface{}) string {
+	q.args = append(q.args, value)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+func (q *deviceConditions) add(clause string) *deviceConditions {
+	q.clauses = append(q.clauses, clause)
+	return q
+}
+
+// equal matches column against value, unless value is empty.
+func (q *deviceConditions) equal(column deviceColumn, value string) *deviceConditions {
+	if value == "" {
+		return q
+	}
+	return q.add(string(column) + " = " + q.param(value))
+}
+
+// ids limits the devices to the given IDs.
+func (q *deviceConditions) ids(ids []string) *deviceConditions {
+	return q.add("d.id = ANY(" + q.param(pq.Array(ids)) + ")")
+}
+
+// tags requires devices to carry every tag, unless there are none.
+func (q *deviceConditions) tags(tags []string) *deviceConditions {
+	if len(tags) == 0 {
+		return q
+	}
+	return q.add("d.tags @> " + q.param(pq.Array(tags)) + "::text[]")
+}
+
+// search matches devices whose name or ID contains text, ignoring case,
+// unless text is empty. LIKE wildcards in text match literally.
+func (q *deviceConditions) search(text string) *deviceConditions {
+	if text == "" {
+		return q
+	}
+	pattern := q.param("%" + likeEscaper.Replace(text) + "%")
+	return q.add("(d.name ILIKE " + pattern + " OR d.id ILIKE " + pattern + ")")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// within matches devices located inside box, unless it is nil.
+func (q *deviceConditions) within(box *boundingBox) *deviceConditions {
+	if box == nil {
+		return q
+	}
+	return q.add("ST_Intersects(d.location, ST_MakeEnvelope(" + q.param(box.MinLongitude) + ", " +
+		q.param(box.MinLatitude) + ", " + q.param(box.MaxLongitude) + ", " + q.param(box.MaxLatitude) + ", 4326)::geography)")
+}
+
+// assignedTo limits the devices to those assigned to userID, unless it is
+// nil.
+func (q *deviceConditions) assignedTo(userID *string) *deviceConditions {
+	if userID == nil {
+		return q
+	}
+	return q.add("d.id IN (SELECT device_id FROM device_assignments WHERE user_id::text = " + q.param(*userID) + ")")
+}
+
+// inRegions limits the devices to those held in regions, where "" matches
+// untagged devices, unless regions is nil.
+func (q *deviceConditions) inRegions(regions []string) *deviceConditions {
+	if regions == nil {
+		return q
+	}
+	return q.add("COALESCE(d.region, '') = ANY(" + q.param(pq.Array(regions)) + ")")
+}
+
+// where returns the clause and the arguments it binds, in order.
+func (q *deviceConditions) where() (string, []interface{}) {
+	return strings.Join(q.clauses, "\n\t\tAND "), q.args
+}
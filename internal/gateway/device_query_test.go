@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestDeviceConditions(t *testing.T) {
+	user := "user-1"
+
+	tests := []struct {
+		name  string
+		build func(q *deviceConditions) *deviceConditions
+		where string
+		args  []interface{}
+	}{
+		{
+			name:  "tenant only",
+			build: func(q *deviceConditions) *deviceConditions { return q },
+			where: "d.tenant_id = $1",
+			args:  []interface{}{"tenant-a"},
+		},
+		{
+			name: "empty filters add nothing",
+			build: func(q *deviceConditions) *deviceConditions {
+				return q.equal(deviceWardColumn, "").inRegions(nil).assignedTo(nil)
+			},
+			where: "d.tenant_id = $1",
+			args:  []interface{}{"tenant-a"},
+		},
+		{
+			name: "equal",
+			build: func(q *deviceConditions) *deviceConditions {
+				return q.equal(deviceTypeColumn, "water_meter").equal(deviceWardColumn, "ward-7")
+			},
+			where: "d.tenant_id = $1\n\t\tAND d.type = $2\n\t\tAND d.ward = $3",
+			args:  []interface{}{"tenant-a", "water_meter", "ward-7"},
+		},
+		{
+			name: "empty equal keeps numbering",
+			build: func(q *deviceConditions) *deviceConditions {
+				return q.equal(deviceTypeColumn, "").equal(deviceStatusColumn, "active")
+			},
+			where: "d.tenant_id = $1\n\t\tAND d.status = $2",
+			args:  []interface{}{"tenant-a", "active"},
+		},
+		{
+			name: "in regions",
+			build: func(q *deviceConditions) *deviceConditions {
+				return q.inRegions([]string{"in-north", ""})
+			},
+			where: "d.tenant_id = $1\n\t\tAND COALESCE(d.region, '') = ANY($2)",
+			args:  []interface{}{"tenant-a", pq.Array([]string{"in-north", ""})},
+		},
+		{
+			name: "no regions matches nothing",
+			build: func(q *deviceConditions) *deviceConditions {
+				return q.inRegions([]string{})
+			},
+			where: "d.tenant_id = $1\n\t\tAND COALESCE(d.region, '') = ANY($2)",
+			args:  []interface{}{"tenant-a", pq.Array([]string{})},
+		},
+		{
+			name: "assigned to",
+			build: func(q *deviceConditions) *deviceConditions {
+				return q.assignedTo(&user)
+			},
+			where: "d.tenant_id = $1\n\t\tAND d.id IN (SELECT device_id FROM device_assignments WHERE user_id::text = $2)",
+			args:  []interface{}{"tenant-a", "user-1"},
+		},
+		{
+			name: "combined",
+			build: func(q *deviceConditions) *deviceConditions {
+				return q.equal(deviceTypeColumn, "water_meter").
+					equal(deviceWardColumn, "ward-7").
+					assignedTo(&user).
+					inRegions([]string{"in-south"})
+			},
+			where: "d.tenant_id = $1\n\t\tAND d.type = $2\n\t\tAND d.ward = $3" +
+				"\n\t\tAND d.id IN (SELECT device_id FROM device_assignments WHERE user_id::text = $4)" +
+				"\n\t\tAND COALESCE(d.region, '') = ANY($5)",
+			args: []interface{}{"tenant-a", "water_meter", "ward-7", "user-1", pq.Array([]string{"in-south"})},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := tt.build(newDeviceConditions("tenant-a")).where()
+			if where != tt.where {
+				t.Errorf("where =\n%s\nwant\n%s", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
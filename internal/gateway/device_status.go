@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceaccess"
+	"github.com/bhanukaranwal/urbanzen/internal/devicestatus"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+const defaultBulkStatusMax = 5000
+
+type bulkStatusRequest struct {
+	DeviceIDs []string `json:"device_ids"`
+	Ward      string   `json:"ward"`
+	Zone      string   `json:"zone"`
+	Tags      []string `json:"tags"`
+}
+
+// BulkDeviceStatus returns the compact status of many devices at once for
+// map and dashboard views. Devices are selected either by explicit IDs or by
+// a ward, zone and tag filter, always within the caller's tenant. Citizens
+// only get the devices assigned to them.
+func (g *Gateway) BulkDeviceStatus(c *gin.Context) {
+	var req bulkStatusRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	if len(req.DeviceIDs) == 0 && req.Ward == "" && req.Zone == "" && len(req.Tags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_ids or a ward, zone or tag filter is required"})
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Tags = tags
+
+	limit := g.bulkStatusMax()
+	if len(req.DeviceIDs) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d devices can be requested at once", limit)})
+		return
+	}
+
+	registry, err := g.lookupDeviceStatuses(c, &req, limit)
+	if err != nil {
+		g.logger.Error("Failed to look up devices for bulk status", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device status"})
+		return
+	}
+
+	if len(registry) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Filter matches more than %d devices, narrow it down", limit)})
+		return
+	}
+
+	deviceIDs := make([]string, 0, len(registry))
+	for _, device := range registry {
+		deviceIDs = append(deviceIDs, device.id)
+	}
+
+	// Without the cache connectivity is unknown, but lifecycle statuses
+	// still come from the registry
+	statuses, err := g.statuses.GetMany(c.Request.Context(), deviceIDs)
+	degraded := err != nil
+	if degraded {
+		g.logger.Warn("Device status cache unavailable, serving unknown connectivity", "error", err)
+		metrics.RedisFallbacks.WithLabelValues("device_status").Inc()
+		statuses = devicestatus.Unknown(deviceIDs)
+	}
+
+	// The registry owns the lifecycle status; the cache owns connectivity
+	for i := range statuses {
+		statuses[i].Status = registry[i].status
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statuses": statuses,
+		"count":    len(statuses),
+		"degraded": degraded,
+	})
+}
+
+// GetDeviceStatus returns the latest cached status of one device, including
+// its most recent metric values.
+func (g *Gateway) GetDeviceStatus(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var registryStatus string
+	err := g.db.QueryRowContext(c.Request.Context(),
+		`SELECT status FROM devices WHERE id = $1 AND tenant_id = $2`,
+		deviceID, middleware.TenantID(c),
+	).Scan(&registryStatus)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to look up device", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device status"})
+		return
+	}
+
+	latest, err := g.statuses.GetLatestStatus(c.Request.Context(), deviceID)
+	degraded := err != nil
+	if degraded {
+		g.logger.Warn("Device status cache unavailable, serving unknown connectivity", "error", err, "device_id", deviceID)
+		metrics.RedisFallbacks.WithLabelValues("device_status").Inc()
+		latest = &devicestatus.LatestStatus{
+			Status:  devicestatus.Unknown([]string{deviceID})[0],
+			Metrics: map[string]float64{},
+		}
+	}
+	latest.Status.Status = registryStatus
+
+	c.JSON(http.StatusOK, struct {
+		*devicestatus.LatestStatus
+		Degraded bool `json:"degraded"`
+	}{latest, degraded})
+}
+
+type registeredDevice struct {
+	id     string
+	status string
+}
+
+// lookupDeviceStatuses resolves the request to devices registered to the
+// caller's tenant. It fetches one row past limit so callers can detect an
+// oversized filter. Unknown, foreign, unassigned or out-of-region device
+// IDs are silently dropped.
+func (g *Gateway) lookupDeviceStatuses(c *gin.Context, req *bulkStatusRequest, limit int) ([]registeredDevice, error) {
+	tenantID := middleware.TenantID(c)
+	assignedTo := deviceaccess.AssignedTo(c)
+
+	conditions := newDeviceConditions(tenantID)
+	if len(req.DeviceIDs) > 0 {
+		conditions.ids(req.DeviceIDs)
+	} else {
+		conditions.equal(deviceWardColumn, req.Ward).equal(deviceZoneColumn, req.Zone).tags(req.Tags)
+	}
+	where, args := conditions.assignedTo(assignedTo).inRegions(g.regions.Scope(c)).where()
+
+	// Explicit IDs are already bounded by the request limit
+	query := `SELECT d.id, d.status FROM devices d WHERE ` + where + ` ORDER BY d.id`
+	if len(req.DeviceIDs) == 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit+1)
+	}
+
+	rows, err := g.db.QueryContext(c.Request.Context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []registeredDevice
+	for rows.Next() {
+		var device registeredDevice
+		if err := rows.Scan(&device.id, &device.status); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+func (g *Gateway) bulkStatusMax() int {
+	if g.config.Devices.BulkStatusMax > 0 {
+		return g.config.Devices.BulkStatusMax
+	}
+	return defaultBulkStatusMax
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+const maxDeviceTags = 32
+
+// Tags are lowercase slugs such as "pilot" or "flood-prone"
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// normalizeTags lowercases and de-duplicates tags, keeping their order, and
+// rejects any that aren't valid slugs.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q: use up to 50 letters, digits, '-' or '_'", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+type deviceTagsRequest struct {
+	Tags []string `json:"tags" binding:"required,min=1"`
+}
+
+// AddDeviceTags adds tags to a device. Tags it already has are left alone.
+func (g *Gateway) AddDeviceTags(c *gin.Context) {
+	var req deviceTagsRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	deviceID := c.Param("id")
+	ctx := c.Request.Context()
+
+	tx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		g.logger.Error("Failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device tags"})
+		return
+	}
+	defer tx.Rollback()
+
+	var current []string
+	err = tx.QueryRowContext(ctx, `
+		SELECT tags FROM devices WHERE id = $1 AND tenant_id = $2 FOR UPDATE
+	`, deviceID, middleware.TenantID(c)).Scan(pq.Array(&current))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to lock device", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device tags"})
+		return
+	}
+
+	updated, _ := normalizeTags(append(current, tags...))
+	if len(updated) > maxDeviceTags {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A device can have at most %d tags", maxDeviceTags)})
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE devices SET tags = $1 WHERE id = $2`, pq.Array(updated), deviceID); err != nil {
+		g.logger.Error("Failed to tag device", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device tags"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		g.logger.Error("Failed to commit device tags", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":   deviceID,
+		"tags": updated,
+	})
+}
+
+// RemoveDeviceTag removes one tag from a device. Removing a tag the device
+// doesn't have succeeds.
+func (g *Gateway) RemoveDeviceTag(c *gin.Context) {
+	deviceID := c.Param("id")
+	tag := strings.ToLower(c.Param("tag"))
+
+	var updated []string
+	err := g.db.QueryRowContext(c.Request.Context(), `
+		UPDATE devices SET tags = array_remove(tags, $3)
+		WHERE id = $1 AND tenant_id = $2
+		RETURNING tags
+	`, deviceID, middleware.TenantID(c), tag).Scan(pq.Array(&updated))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to untag device", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":   deviceID,
+		"tags": updated,
+	})
+}
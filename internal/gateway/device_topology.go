@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/devicestatus"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+var (
+	errUnknownParent = errors.New("parent device not found")
+	errParentCycle   = errors.New("parent device is this device or one of its children")
+)
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type childDevice struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Status       string `json:"status"`
+	Connectivity string `json:"connectivity"`
+}
+
+// ListDeviceChildren returns the devices reporting through a gateway.
+func (g *Gateway) ListDeviceChildren(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+	deviceID := c.Param("id")
+
+	var exists bool
+	err := g.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM devices WHERE id = $1 AND tenant_id = $2)`,
+		deviceID, tenantID,
+	).Scan(&exists)
+	if err != nil {
+		g.logger.Error("Failed to look up device", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve child devices"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	rows, err := g.db.QueryContext(ctx, `
+		SELECT id, name, type, status FROM devices
+		WHERE parent_device_id = $1 AND tenant_id = $2
+		ORDER BY id
+	`, deviceID, tenantID)
+	if err != nil {
+		g.logger.Error("Failed to list child devices", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve child devices"})
+		return
+	}
+	defer rows.Close()
+
+	children := []childDevice{}
+	var childIDs []string
+	for rows.Next() {
+		var child childDevice
+		if err := rows.Scan(&child.ID, &child.Name, &child.Type, &child.Status); err != nil {
+			g.logger.Error("Failed to scan child device", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve child devices"})
+			return
+		}
+		children = append(children, child)
+		childIDs = append(childIDs, child.ID)
+	}
+	if err := rows.Err(); err != nil {
+		g.logger.Error("Failed to list child devices", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve child devices"})
+		return
+	}
+
+	statuses, err := g.statuses.GetMany(ctx, childIDs)
+	degraded := err != nil
+	if degraded {
+		g.logger.Warn("Device status cache unavailable, serving unknown connectivity", "error", err)
+		metrics.RedisFallbacks.WithLabelValues("device_status").Inc()
+		statuses = devicestatus.Unknown(childIDs)
+	}
+	for i := range children {
+		children[i].Connectivity = statuses[i].Connectivity
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"children":  children,
+		"count":     len(children),
+		"degraded":  degraded,
+	})
+}
+
+// validateParent checks that parentID is a device of the same tenant and
+// that attaching deviceID beneath it would not create a cycle.
+func validateParent(ctx context.Context, q rowQueryer, tenantID, deviceID, parentID string) error {
+	if parentID == deviceID {
+		return errParentCycle
+	}
+
+	var chainLength, cycles int
+	err := q.QueryRowContext(ctx, `
+		WITH RECURSIVE ancestors (id, parent_device_id) AS (
+			SELECT id, parent_device_id FROM devices WHERE id = $1 AND tenant_id = $2
+			UNION
+			SELECT d.id, d.parent_device_id FROM devices d
+			JOIN ancestors a ON d.id = a.parent_device_id
+		)
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE id = $3) FROM ancestors
+	`, parentID, tenantID, deviceID).Scan(&chainLength, &cycles)
+	if err != nil {
+		return err
+	}
+
+	if chainLength == 0 {
+		return errUnknownParent
+	}
+	if cycles > 0 {
+		return errParentCycle
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+func (g *Gateway) ListDeviceTypes(c *gin.Context) {
+	types, err := g.types.List(c.Request.Context())
+	if err != nil {
+		g.logger.Error("Failed to list device types", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list device types"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"device_types": types})
+}
+
+// SaveDeviceType creates or replaces a device type's defaults, schema,
+// canonical metric units, sampling policy, totalizers and command
+// capabilities.
+// Existing devices keep their configuration; only new devices pick up the
+// change.
+func (g *Gateway) SaveDeviceType(c *gin.Context) {
+	var deviceType devicetype.DeviceType
+	if !middleware.BindJSON(c, &deviceType) {
+		return
+	}
+	deviceType.Name = c.Param("type")
+	if deviceType.DefaultConfiguration == nil {
+		deviceType.DefaultConfiguration = map[string]interface{}{}
+	}
+	if deviceType.ConfigSchema == nil {
+		deviceType.ConfigSchema = devicetype.Schema{}
+	}
+	if deviceType.MetricUnits == nil {
+		deviceType.MetricUnits = devicetype.MetricUnits{}
+	}
+	if deviceType.Totalizers == nil {
+		deviceType.Totalizers = devicetype.Totalizers{}
+	}
+	if deviceType.Capabilities == nil {
+		deviceType.Capabilities = devicetype.Capabilities{}
+	}
+
+	if err := g.types.Save(c.Request.Context(), &deviceType, c.GetString("user_id")); err != nil {
+		if validationErr, ok := err.(*devicetype.ValidationError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Invalid device type definition",
+				"violations": validationErr.Violations,
+			})
+			return
+		}
+		g.logger.Error("Failed to save device type", "error", err, "type", deviceType.Name)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save device type"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_type": deviceType,
+		"message":     "Device type saved successfully",
+	})
+}
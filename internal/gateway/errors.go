@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// writeConstraintError answers a constraint violation with 409 (duplicate)
+// or 400 (bad reference or value), naming the offending field. It reports
+// false, writing nothing, for any other error.
+func writeConstraintError(c *gin.Context, err error, resource string) bool {
+	constraintErr, ok := database.AsConstraintError(err)
+	if !ok {
+		return false
+	}
+
+	field := constraintErr.Field()
+	response := gin.H{"error": fmt.Sprintf("Invalid %s", resource)}
+	if field != "" {
+		response["field"] = field
+	}
+
+	switch constraintErr.Kind {
+	case database.UniqueViolation:
+		response["error"] = fmt.Sprintf("A %s with these values already exists", resource)
+		if field != "" {
+			response["error"] = fmt.Sprintf("A %s with this %s already exists", resource, field)
+		}
+		c.JSON(http.StatusConflict, response)
+	case database.ForeignKeyViolation:
+		if field != "" {
+			response["error"] = fmt.Sprintf("Referenced %s does not exist", field)
+		}
+		c.JSON(http.StatusBadRequest, response)
+	default:
+		if field != "" {
+			response["error"] = fmt.Sprintf("Invalid %s %s", resource, field)
+		}
+		c.JSON(http.StatusBadRequest, response)
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/notifyprefs"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// Cached preferences are deleted in batches of this many keys
+const preferenceInvalidationBatch = 500
+
+// preferenceAudience selects active users in the caller's tenant. Every
+// field given must match; erased users never do.
+type preferenceAudience struct {
+	Role    string   `json:"role"`
+	Ward    string   `json:"ward"`
+	UserIDs []string `json:"user_ids"`
+}
+
+func (a *preferenceAudience) empty() bool {
+	return a.Role == "" && a.Ward == "" && len(a.UserIDs) == 0
+}
+
+// conditions returns the WHERE clause for the audience over users aliased
+// as "u", using parameters $1 to $4.
+func (a *preferenceAudience) conditions(tenantID string) (string, []interface{}) {
+	where := `
+		u.tenant_id = $1 AND u.is_active AND u.erased_at IS NULL
+		AND ($2 = '' OR u.role = $2)
+		AND ($3 = '' OR u.ward = $3)
+		AND (COALESCE(cardinality($4::text[]), 0) = 0 OR u.id::text = ANY($4::text[]))
+	`
+	return where, []interface{}{tenantID, a.Role, a.Ward, pq.Array(a.UserIDs)}
+}
+
+type bulkPreferencesRequest struct {
+	Audience preferenceAudience `json:"audience"`
+	// Preferences turns channels on or off; channels not named keep each
+	// user's own setting
+	Preferences map[string]bool `json:"preferences"`
+	Confirm     bool            `json:"confirm"`
+}
+
+// BulkUpdateNotificationPreferences turns notification channels on or off
+// for every user in an audience, such as all citizens in a ward. Updates
+// reaching more than notifications.bulk_preferences.confirm_above users
+// must be confirmed. The notification service's cached preferences for
+// the affected users are dropped so the change applies to the next
+// notification.
+func (g *Gateway) BulkUpdateNotificationPreferences(c *gin.Context) {
+	var req bulkPreferencesRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	if req.Audience.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "audience must set role, ward or user_ids"})
+		return
+	}
+	if len(req.Preferences) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "preferences must set at least one channel"})
+		return
+	}
+	for channel := range req.Preferences {
+		if !notifyprefs.Channels[channel] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown channel %q, use email, sms or push", channel)})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	where, args := req.Audience.conditions(middleware.TenantID(c))
+
+	tx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		g.logger.Error("Failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+	defer tx.Rollback()
+
+	var matched int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users u WHERE `+where, args...).Scan(&matched); err != nil {
+		g.logger.Error("Failed to count preference audience", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+	if confirmAbove := g.config.Notifications.BulkPreferences.ConfirmAbove; matched > confirmAbove && !req.Confirm {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   fmt.Sprintf("Update reaches %d users, resend with \"confirm\": true to apply it", matched),
+			"matched": matched,
+		})
+		return
+	}
+
+	patch, err := json.Marshal(req.Preferences)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE users u
+		SET notification_preferences = COALESCE(u.notification_preferences, '{}') || $5::jsonb, updated_at = NOW()
+		WHERE `+where+`
+		RETURNING u.id
+	`, append(args, patch)...)
+	if err != nil {
+		g.logger.Error("Failed to update notification preferences", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			g.logger.Error("Failed to update notification preferences", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+			return
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		g.logger.Error("Failed to update notification preferences", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		g.logger.Error("Failed to commit notification preferences", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	invalidated := g.invalidatePreferences(c, userIDs)
+
+	g.logger.Info("Bulk notification preference update", "user_id", c.GetString("user_id"),
+		"role", req.Audience.Role, "ward", req.Audience.Ward, "user_ids", len(req.Audience.UserIDs),
+		"preferences", req.Preferences, "updated", len(userIDs))
+	c.JSON(http.StatusOK, gin.H{
+		"matched":           matched,
+		"updated":           len(userIDs),
+		"preferences":       req.Preferences,
+		"cache_invalidated": invalidated,
+	})
+}
+
+// invalidatePreferences drops the notification service's cached
+// preferences for the users. It reports false if Redis failed, in which
+// case the old preferences may be used until the cache expires within an
+// hour.
+func (g *Gateway) invalidatePreferences(c *gin.Context, userIDs []string) bool {
+	for start := 0; start < len(userIDs); start += preferenceInvalidationBatch {
+		end := start + preferenceInvalidationBatch
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+
+		keys := make([]string, 0, end-start)
+		for _, userID := range userIDs[start:end] {
+			keys = append(keys, notifyprefs.CacheKey(userID))
+		}
+		if err := g.redis.Del(c.Request.Context(), keys...); err != nil {
+			metrics.RedisFallbacks.WithLabelValues("notification_preferences").Inc()
+			g.logger.Warn("Failed to invalidate cached notification preferences", "error", err, "users", len(userIDs))
+			return false
+		}
+	}
+	return true
+}
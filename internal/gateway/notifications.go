@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+type notificationDigestRequest struct {
+	Digest string `json:"digest" binding:"required,oneof=immediate hourly daily"`
+}
+
+type alternateContactsRequest struct {
+	UserIDs []string `json:"user_ids" binding:"max=5,dive,uuid"`
+}
+
+type resendNotificationRequest struct {
+	Channel string `json:"channel" binding:"omitempty,oneof=email sms push"`
+}
+
+// GetNotificationDigest returns how the caller receives non-urgent
+// notifications: immediately, or batched hourly or daily.
+func (g *Gateway) GetNotificationDigest(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var digest string
+	err := g.db.QueryRowContext(c.Request.Context(), `SELECT notification_digest FROM users WHERE id = $1`,
+		userID).Scan(&digest)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		g.logger.Error("Failed to get notification digest", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notification digest"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"digest": digest})
+}
+
+// UpdateNotificationDigest sets the caller's digest preference. Emergency
+// and high priority notifications are always sent immediately.
+func (g *Gateway) UpdateNotificationDigest(c *gin.Context) {
+	var req notificationDigestRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	userID := c.GetString("user_id")
+	result, err := g.db.ExecContext(c.Request.Context(), `
+		UPDATE users SET notification_digest = $1, updated_at = NOW() WHERE id = $2
+	`, req.Digest, userID)
+	if err != nil {
+		g.logger.Error("Failed to update notification digest", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification digest"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"digest": req.Digest})
+}
+
+// GetAlternateContacts lists the users who also receive the caller's
+// emergency notifications while no channel can reach the caller.
+func (g *Gateway) GetAlternateContacts(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var contacts []string
+	err := g.db.QueryRowContext(c.Request.Context(), `
+		SELECT alternate_contact_ids::text[] FROM users WHERE id::text = $1 AND tenant_id = $2
+	`, userID, middleware.TenantID(c)).Scan(pq.Array(&contacts))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		g.logger.Error("Failed to get alternate contacts", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alternate contacts"})
+		return
+	}
+
+	if contacts == nil {
+		contacts = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"user_ids": contacts})
+}
+
+// UpdateAlternateContacts replaces the caller's alternate contacts. They
+// must be other active users in the caller's tenant.
+func (g *Gateway) UpdateAlternateContacts(c *gin.Context) {
+	var req alternateContactsRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	userID := c.GetString("user_id")
+	tenantID := middleware.TenantID(c)
+	ctx := c.Request.Context()
+
+	contacts := make([]string, 0, len(req.UserIDs))
+	seen := make(map[string]bool, len(req.UserIDs))
+	for _, contact := range req.UserIDs {
+		contact = strings.ToLower(contact)
+		if contact == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You can't be your own alternate contact"})
+			return
+		}
+		if !seen[contact] {
+			seen[contact] = true
+			contacts = append(contacts, contact)
+		}
+	}
+
+	var found int
+	err := g.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM users
+		WHERE id::text = ANY($1) AND tenant_id = $2 AND is_active AND erased_at IS NULL
+	`, pq.Array(contacts), tenantID).Scan(&found)
+	if err != nil {
+		g.logger.Error("Failed to check alternate contacts", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alternate contacts"})
+		return
+	}
+	if found != len(contacts) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Alternate contacts must be active users in your organization"})
+		return
+	}
+
+	result, err := g.db.ExecContext(ctx, `
+		UPDATE users SET alternate_contact_ids = $1::uuid[], updated_at = NOW()
+		WHERE id::text = $2 AND tenant_id = $3
+	`, pq.Array(contacts), userID, tenantID)
+	if err != nil {
+		g.logger.Error("Failed to update alternate contacts", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alternate contacts"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_ids": contacts})
+}
+
+// ResendNotification re-dispatches a stored notification, optionally to a
+// single channel. The resend is a new notification linked to the original,
+// so both keep their own delivery status; the notification service's
+// scheduler sends it within a minute.
+func (g *Gateway) ResendNotification(c *gin.Context) {
+	notificationID := c.Param("id")
+	if _, err := uuid.Parse(notificationID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	// The body is optional; without one the original routing applies
+	var req resendNotificationRequest
+	if !middleware.BindOptionalJSON(c, &req) {
+		return
+	}
+
+	channels := []string{}
+	if req.Channel != "" {
+		channels = append(channels, req.Channel)
+	}
+	channelsJSON, _ := json.Marshal(channels)
+	actorID := c.GetString("user_id")
+
+	var resendID string
+	var createdAt time.Time
+	err := g.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO notifications (user_id, tenant_id, type, title, message, priority, channels,
+			metadata, scheduled_at, status, resend_of)
+		SELECT user_id, tenant_id, type, title, message, priority, $3::jsonb,
+			COALESCE(metadata, '{}') || jsonb_build_object('resent_by', $4::text), NOW(), 'pending', id
+		FROM notifications
+		WHERE id = $1 AND tenant_id = $2
+		RETURNING id, created_at
+	`, notificationID, middleware.TenantID(c), string(channelsJSON), actorID).Scan(&resendID, &createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
+		g.logger.Error("Failed to queue notification resend", "error", err, "notification_id", notificationID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resend notification"})
+		return
+	}
+
+	g.logger.Info("Notification resend requested", "notification_id", notificationID, "resend_id", resendID,
+		"channel", req.Channel, "user_id", actorID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":         resendID,
+		"resend_of":  notificationID,
+		"channels":   channels,
+		"created_at": createdAt,
+	})
+}
@@ -0,0 +1,25 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlatformStatus serves the public status page: each service's state,
+// version and last heartbeat, composed from the heartbeats the services
+// write to Redis. The response may be up to 10 seconds old.
+func (g *Gateway) PlatformStatus(c *gin.Context) {
+	summary, err := g.health.Status(c.Request.Context())
+	if err != nil {
+		g.logger.Error("Failed to read service heartbeats", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "unknown",
+			"error":  "Platform status is temporarily unavailable",
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=10")
+	c.JSON(http.StatusOK, summary)
+}
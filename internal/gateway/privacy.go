@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/privacy"
+)
+
+// ExportUserData returns a zip archive of everything held about a user,
+// for data subject access requests. Users may export their own data;
+// admins may export anyone's in their tenant.
+func (g *Gateway) ExportUserData(c *gin.Context) {
+	userID := c.Param("id")
+	if !g.selfOrAdmin(c, userID) {
+		return
+	}
+
+	export, err := g.privacy.Export(c.Request.Context(), middleware.TenantID(c), userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to export user data", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export user data"})
+		return
+	}
+
+	var archive bytes.Buffer
+	if err := privacy.WriteArchive(&archive, export); err != nil {
+		g.logger.Error("Failed to write data export", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export user data"})
+		return
+	}
+
+	g.logger.Info("User data exported", "user_id", userID, "exported_by", c.GetString("user_id"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="urbanzen-data-%s.zip"`, userID))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// EraseUser anonymises a user on request: their profile is blanked and
+// deactivated and their personal records deleted, while bills and payments
+// are kept for the utility's records. Users may erase their own account
+// from a login session; admins may erase anyone in their tenant.
+func (g *Gateway) EraseUser(c *gin.Context) {
+	userID := c.Param("id")
+	if !g.selfOrAdmin(c, userID) || !g.sessionOnly(c) {
+		return
+	}
+
+	actorID := c.GetString("user_id")
+	erasure, err := g.privacy.Erase(c.Request.Context(), middleware.TenantID(c), userID, actorID)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case err == privacy.ErrErased:
+		c.JSON(http.StatusConflict, gin.H{"error": "User has already been erased"})
+		return
+	case err == privacy.ErrProtected:
+		c.JSON(http.StatusConflict, gin.H{"error": "Super admin accounts must be demoted before they can be erased"})
+		return
+	case err != nil:
+		g.logger.Error("Failed to erase user", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase user"})
+		return
+	}
+
+	g.logger.Info("User erased", "user_id", userID, "erased_by", actorID)
+	c.JSON(http.StatusOK, gin.H{
+		"erasure": erasure,
+		"message": "Personal data erased; billing records were retained",
+	})
+}
+
+// selfOrAdmin rejects requests about another user unless the caller is an
+// admin.
+func (g *Gateway) selfOrAdmin(c *gin.Context, userID string) bool {
+	role := c.GetString("role")
+	if c.GetString("user_id") == userID || role == "admin" || role == "super_admin" {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient privileges"})
+	return false
+}
@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/residency"
+)
+
+// SetUserRegions moves a user to a region, the default if empty, and sets
+// the other regions they may reach ("*" for all). Admins can only grant
+// regions they reach themselves. The user's current access tokens keep
+// their old regions until they are refreshed.
+func (g *Gateway) SetUserRegions(c *gin.Context) {
+	var req struct {
+		Region         string   `json:"region"`
+		AllowedRegions []string `json:"allowed_regions"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	if req.AllowedRegions == nil {
+		req.AllowedRegions = []string{}
+	}
+	if !g.regions.Enabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Data residency is not enabled"})
+		return
+	}
+
+	region, err := g.regions.Region(req.Region)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := g.regions.Grants(req.AllowedRegions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.Param("id")
+	for _, granted := range append([]string{region}, req.AllowedRegions...) {
+		if granted == residency.AllRegions && g.regions.Scope(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only users who reach every region may grant every region"})
+			return
+		}
+		if granted != residency.AllRegions && !g.regions.Permits(c, granted) {
+			g.regions.Deny(c, "user", userID, granted)
+			return
+		}
+	}
+
+	err = g.regions.SetUserRegions(c.Request.Context(), middleware.TenantID(c), userID, region, req.AllowedRegions)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to update user regions", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user regions"})
+		return
+	}
+
+	g.logger.Info("User regions updated", "user_id", userID, "region", region,
+		"allowed_regions", req.AllowedRegions, "updated_by", c.GetString("user_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":         userID,
+		"region":          region,
+		"allowed_regions": req.AllowedRegions,
+	})
+}
@@ -1,69 +1,251 @@
 package gateway
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/auth"
 	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceaccess"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceconfig"
+	"github.com/bhanukaranwal/urbanzen/internal/devicecred"
+	"github.com/bhanukaranwal/urbanzen/internal/devicelifecycle"
+	"github.com/bhanukaranwal/urbanzen/internal/devicestatus"
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/internal/flags"
+	"github.com/bhanukaranwal/urbanzen/internal/geofence"
 	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/privacy"
+	"github.com/bhanukaranwal/urbanzen/internal/residency"
+	"github.com/bhanukaranwal/urbanzen/internal/subscription"
+	"github.com/bhanukaranwal/urbanzen/internal/telemetryschema"
+	"github.com/bhanukaranwal/urbanzen/internal/tenant"
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/fieldset"
+	"github.com/bhanukaranwal/urbanzen/pkg/heartbeat"
+	"github.com/bhanukaranwal/urbanzen/pkg/httpcache"
+	"github.com/bhanukaranwal/urbanzen/pkg/kafka"
 	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/pagination"
 )
 
 type Gateway struct {
-	config *config.Config
-	logger logger.Logger
+	config   *config.Config
+	db       *database.PostgresDB
+	tsdb     *database.PostgresDB
+	redis    *database.RedisClient
+	auth     *auth.Service
+	tenants  *tenant.Store
+	flags    *flags.Service
+	statuses *devicestatus.Store
+	types    *devicetype.Store
+	tokens   *auth.TokenStore
+	access   *deviceaccess.Store
+	producer *kafka.Producer
+	logger   logger.Logger
+
+	credentials *devicecred.Store
+	privacy     *privacy.Store
+	fences      *geofence.Store
+	health      *heartbeat.Monitor
+	schemas     *telemetryschema.Store
+
+	subscriptions *subscription.Store
+	regions       *residency.Store
+
+	// scope key -> dashboard summary
+	summaries *cache.Cache[string, *dashboardSummary]
 }
 
-func New(cfg *config.Config, log logger.Logger) *Gateway {
+func New(cfg *config.Config, db, tsdb *database.PostgresDB, redis *database.RedisClient, authService *auth.Service, tenants *tenant.Store,
+	featureFlags *flags.Service, statuses *devicestatus.Store, deviceTypes *devicetype.Store, tokens *auth.TokenStore,
+	access *deviceaccess.Store, credentials *devicecred.Store, privacyStore *privacy.Store, fences *geofence.Store, health *heartbeat.Monitor,
+	schemas *telemetryschema.Store, subscriptions *subscription.Store, regions *residency.Store, producer *kafka.Producer,
+	log logger.Logger) *Gateway {
 	return &Gateway{
-		config: cfg,
-		logger: log,
+		config:   cfg,
+		db:       db,
+		tsdb:     tsdb,
+		redis:    redis,
+		auth:     authService,
+		tenants:  tenants,
+		flags:    featureFlags,
+		statuses: statuses,
+		types:    deviceTypes,
+		tokens:   tokens,
+		access:   access,
+		producer: producer,
+		logger:   log,
+
+		credentials: credentials,
+		privacy:     privacyStore,
+		fences:      fences,
+		health:      health,
+		schemas:     schemas,
+
+		subscriptions: subscriptions,
+		regions:       regions,
+
+		summaries: newDashboardSummaries(),
 	}
 }
 
 func (g *Gateway) Login(c *gin.Context) {
-	var loginReq struct {
-		Username string `json:"username" binding:"required"`
-		Password string `json:"password" binding:"required"`
+	var loginReq auth.LoginRequest
+	if !middleware.BindJSON(c, &loginReq) {
+		return
 	}
 
-	if err := c.ShouldBindJSON(&loginReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	loginReq.IPAddress = c.ClientIP()
+	loginReq.UserAgent = c.Request.UserAgent()
+
+	resp, err := g.auth.Login(c.Request.Context(), &loginReq)
+	if err != nil {
+		g.loginFailed(c, err)
 		return
 	}
 
-	// TODO: Implement actual user authentication
-	// For now, return a mock response
-	if loginReq.Username == "admin" && loginReq.Password == "admin123" {
-		token, err := middleware.GenerateToken("1", loginReq.Username, "admin", g.config)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+	c.JSON(http.StatusOK, resp)
+}
+
+// loginFailed answers a refused login, by password or the second factor
+// of a single sign-on.
+func (g *Gateway) loginFailed(c *gin.Context, err error) {
+	if errors.Is(err, auth.ErrSessionStoreUnavailable) {
+		g.logger.Error("Session store unavailable during login", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": auth.ErrSessionStoreUnavailable.Error()})
+		return
+	}
+	var throttled *auth.ThrottleError
+	if errors.As(err, &throttled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": throttled.Error()})
+		return
+	}
+	if errors.Is(err, auth.ErrCaptchaRequired) || errors.Is(err, auth.ErrCaptchaInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "captcha_required": true})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+}
+
+func (g *Gateway) Register(c *gin.Context) {
+	var req auth.RegisterRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	req.TenantID = middleware.TenantID(c)
+
+	userID, err := g.auth.Register(c.Request.Context(), &req)
+	if err != nil {
+		if policyErr, ok := err.(*auth.PasswordPolicyError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Password does not meet policy",
+				"violations": policyErr.Violations,
+			})
 			return
 		}
+		g.logger.Error("Failed to register user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"token": token,
-			"user": gin.H{
-				"id":       "1",
-				"username": loginReq.Username,
-				"role":     "admin",
-			},
-		})
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      userID,
+		"message": "User registered successfully",
+	})
+}
+
+func (g *Gateway) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 
-	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	if err := g.auth.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		g.logger.Error("Failed to request password reset", "error", err)
+	}
+
+	// Same response either way so the endpoint can't be used to probe accounts
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a reset link has been sent"})
+}
+
+func (g *Gateway) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	if err := g.auth.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+		if policyErr, ok := err.(*auth.PasswordPolicyError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Password does not meet policy",
+				"violations": policyErr.Violations,
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
 }
 
 func (g *Gateway) Logout(c *gin.Context) {
-	// TODO: Implement token blacklisting
+	sessionID := c.GetString("session_id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token has no session"})
+		return
+	}
+
+	if err := g.auth.Logout(c.Request.Context(), sessionID); err != nil {
+		g.logger.Error("Failed to logout", "error", err, "session_id", sessionID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
 func (g *Gateway) RefreshToken(c *gin.Context) {
-	// TODO: Implement token refresh logic
-	c.JSON(http.StatusOK, gin.H{"message": "Token refreshed"})
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	resp, err := g.auth.RefreshToken(c.Request.Context(), req.RefreshToken)
+	if errors.Is(err, auth.ErrSessionStoreUnavailable) {
+		g.logger.Error("Session store unavailable during refresh", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": auth.ErrSessionStoreUnavailable.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 func (g *Gateway) GetProfile(c *gin.Context) {
@@ -72,172 +254,656 @@ func (g *Gateway) GetProfile(c *gin.Context) {
 	role, _ := c.Get("role")
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":       userID,
-		"username": username,
-		"role":     role,
+		"id":        userID,
+		"username":  username,
+		"role":      role,
+		"tenant_id": middleware.TenantID(c),
 	})
 }
 
-func (g *Gateway) ListDevices(c *gin.Context) {
-	// Parse query parameters
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	deviceType := c.Query("type")
-
-	// TODO: Implement actual device listing from database
-	devices := []gin.H{
-		{
-			"id":        "device-001",
-			"name":      "Water Sensor #1",
-			"type":      "water_sensor",
-			"status":    "active",
-			"location":  gin.H{"latitude": 28.6139, "longitude": 77.2090},
-			"last_seen": "2024-01-15T10:30:00Z",
-		},
-		{
-			"id":        "device-002",
-			"name":      "Smart Meter #1",
-			"type":      "electricity_meter",
-			"status":    "active",
-			"location":  gin.H{"latitude": 28.6129, "longitude": 77.2080},
-			"last_seen": "2024-01-15T10:29:00Z",
-		},
-	}
-
-	// Filter by type if specified
-	if deviceType != "" {
-		filtered := []gin.H{}
-		for _, device := range devices {
-			if device["type"] == deviceType {
-				filtered = append(filtered, device)
-			}
+func (g *Gateway) UnlockUser(c *gin.Context) {
+	userID := c.Param("id")
+	actorID := c.GetString("user_id")
+
+	if err := g.auth.UnlockAccount(c.Request.Context(), middleware.TenantID(c), userID, actorID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
 		}
-		devices = filtered
+		g.logger.Error("Failed to unlock account", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"devices": devices,
-		"pagination": gin.H{
-			"page":  page,
-			"limit": limit,
-			"total": len(devices),
-		},
+		"id":      userID,
+		"message": "Account unlocked successfully",
 	})
 }
 
-func (g *Gateway) CreateDevice(c *gin.Context) {
-	var device struct {
-		Name     string  `json:"name" binding:"required"`
-		Type     string  `json:"type" binding:"required"`
-		Latitude float64 `json:"latitude" binding:"required"`
-		Longitude float64 `json:"longitude" binding:"required"`
+func (g *Gateway) GetTenantConfig(c *gin.Context) {
+	tenantID := middleware.TenantID(c)
+
+	effective, err := g.tenants.Resolve(c.Request.Context(), tenantID)
+	if err != nil {
+		g.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", tenantID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tenant configuration"})
+		return
+	}
+
+	overrides, err := g.tenants.Overrides(c.Request.Context(), tenantID)
+	if err != nil {
+		g.logger.Error("Failed to load tenant overrides", "error", err, "tenant_id", tenantID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tenant configuration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"effective": effective,
+		"overrides": overrides,
+	})
+}
+
+func (g *Gateway) UpdateTenantConfig(c *gin.Context) {
+	tenantID := middleware.TenantID(c)
+
+	var overrides map[string]interface{}
+	if !middleware.BindJSON(c, &overrides) {
+		return
 	}
 
-	if err := c.ShouldBindJSON(&device); err != nil {
+	if err := g.tenants.SetOverrides(c.Request.Context(), tenantID, overrides, c.GetString("user_id")); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// TODO: Implement actual device creation
-	c.JSON(http.StatusCreated, gin.H{
-		"id":       "device-new-001",
-		"name":     device.Name,
-		"type":     device.Type,
-		"status":   "active",
-		"location": gin.H{"latitude": device.Latitude, "longitude": device.Longitude},
-		"message":  "Device created successfully",
+	effective, _ := g.tenants.Resolve(c.Request.Context(), tenantID)
+	c.JSON(http.StatusOK, gin.H{
+		"effective": effective,
+		"message":   "Tenant configuration updated successfully",
 	})
 }
 
-func (g *Gateway) GetDevice(c *gin.Context) {
-	deviceID := c.Param("id")
+func (g *Gateway) ListFeatureFlags(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"flags": g.flags.List(c.Request.Context())})
+}
+
+func (g *Gateway) UpdateFeatureFlag(c *gin.Context) {
+	var flag flags.Flag
+	if !middleware.BindJSON(c, &flag) {
+		return
+	}
+	flag.Name = c.Param("name")
+
+	if err := g.flags.Set(c.Request.Context(), flag, c.GetString("user_id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// TODO: Implement actual device retrieval
 	c.JSON(http.StatusOK, gin.H{
-		"id":        deviceID,
-		"name":      "Water Sensor #1",
-		"type":      "water_sensor",
-		"status":    "active",
-		"location":  gin.H{"latitude": 28.6139, "longitude": 77.2090},
-		"last_seen": "2024-01-15T10:30:00Z",
-		"metrics": gin.H{
-			"flow_rate": 25.5,
-			"pressure":  3.2,
-			"ph_level":  7.1,
-		},
+		"flag":    flag,
+		"message": "Feature flag updated successfully",
 	})
 }
 
+// ListDevices returns the tenant's devices, filtered by type, ward, zone,
+// status and tags. Repeat tag to require several, e.g. ?tag=pilot&tag=vip.
+// Citizens only see the devices assigned to them, and everyone only those
+// in regions they may reach.
+func (g *Gateway) ListDevices(c *gin.Context) {
+	page, limit, err := pagination.Parse(c, g.config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var filter deviceFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := filter.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields, err := fieldset.Parse(c, models.Device{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.AssignedTo = deviceaccess.AssignedTo(c)
+	filter.Regions = g.regions.Scope(c)
+
+	ctx := c.Request.Context()
+	where, args := filter.conditions(middleware.TenantID(c))
+
+	var total int
+	if err := g.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM devices d WHERE `+where, args...).Scan(&total); err != nil {
+		g.logger.Error("Failed to count devices", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve devices"})
+		return
+	}
+
+	pages := pagination.New(page, limit, total)
+	rows, err := g.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM devices d
+		WHERE %s
+		ORDER BY d.name, d.id
+		LIMIT %d OFFSET %d
+	`, deviceColumns, where, limit, pages.Offset()), args...)
+	if err != nil {
+		g.logger.Error("Failed to list devices", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve devices"})
+		return
+	}
+	defer rows.Close()
+
+	devices := []models.Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			g.logger.Error("Failed to scan device", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve devices"})
+			return
+		}
+		devices = append(devices, *device)
+	}
+	if err := rows.Err(); err != nil {
+		g.logger.Error("Failed to list devices", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve devices"})
+		return
+	}
+
+	selected, err := fields.Select(devices)
+	if err != nil {
+		g.logger.Error("Failed to select device fields", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve devices"})
+		return
+	}
+
+	pages.Write(c)
+	httpcache.JSON(c, http.StatusOK, gin.H{
+		"devices":    selected,
+		"pagination": pages,
+	}, httpcache.Status)
+}
+
+func (g *Gateway) CreateDevice(c *gin.Context) {
+	var req struct {
+		ID            string                 `json:"id"`
+		Name          string                 `json:"name" binding:"required"`
+		Type          string                 `json:"type" binding:"required"`
+		Latitude      float64                `json:"latitude" binding:"required"`
+		Longitude     float64                `json:"longitude" binding:"required"`
+		Ward          string                 `json:"ward"`
+		Zone          string                 `json:"zone"`
+		ParentID      string                 `json:"parent_device_id"`
+		Configuration map[string]interface{} `json:"configuration"`
+		Metadata      map[string]interface{} `json:"metadata"`
+		Tags          []string               `json:"tags"`
+		// Region the device's data is held in, the default if empty. It
+		// can't be changed once the device is registered.
+		Region string `json:"region"`
+		// Credential is issued with the device: api_key (the default),
+		// certificate or none
+		Credential string `json:"credential"`
+	}
+
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	if req.Credential == "" {
+		req.Credential = devicecred.KindAPIKey
+	}
+	if req.Credential != devicecred.KindAPIKey && req.Credential != devicecred.KindCertificate && req.Credential != "none" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "credential must be api_key, certificate or none"})
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(tags) > maxDeviceTags {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A device can have at most %d tags", maxDeviceTags)})
+		return
+	}
+
+	region, err := g.regions.Region(req.Region)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !g.regions.Permits(c, region) {
+		g.regions.Deny(c, "device", req.ID, region)
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	deviceType, err := g.types.Get(ctx, req.Type)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown device type"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load device type", "error", err, "type", req.Type)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+		return
+	}
+
+	// New devices start from the type's standard settings
+	configuration, err := deviceType.Configure(req.Configuration)
+	if err != nil {
+		if validationErr, ok := err.(*devicetype.ValidationError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Invalid device configuration",
+				"violations": validationErr.Violations,
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkDeviceMetadata(c, deviceType, req.Metadata) {
+		return
+	}
+
+	device := models.Device{
+		ID:            req.ID,
+		TenantID:      middleware.TenantID(c),
+		Name:          req.Name,
+		Type:          req.Type,
+		Location:      models.Location{Latitude: req.Latitude, Longitude: req.Longitude},
+		Ward:          req.Ward,
+		Zone:          req.Zone,
+		Region:        region,
+		ParentID:      req.ParentID,
+		Status:        devicelifecycle.Provisioned,
+		Configuration: configuration,
+		Metadata:      req.Metadata,
+		Tags:          tags,
+	}
+	if device.ID == "" {
+		device.ID = uuid.New().String()
+	}
+	if device.Metadata == nil {
+		device.Metadata = map[string]interface{}{}
+	}
+
+	if device.ParentID != "" {
+		if err := validateParent(ctx, g.db, device.TenantID, device.ID, device.ParentID); err != nil {
+			if err == errUnknownParent || err == errParentCycle {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			g.logger.Error("Failed to validate parent device", "error", err, "parent_device_id", device.ParentID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+			return
+		}
+	}
+
+	configurationJSON, _ := json.Marshal(device.Configuration)
+	metadataJSON, _ := json.Marshal(device.Metadata)
+
+	tx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		g.logger.Error("Failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO devices (id, tenant_id, name, type, location, ward, zone, parent_device_id, status, configuration, metadata, tags, region)
+		VALUES ($1, $2, $3, $4, ST_SetSRID(ST_MakePoint($5, $6), 4326)::geography, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12, $13, NULLIF($14, ''))
+		RETURNING created_at, updated_at
+	`,
+		device.ID,
+		device.TenantID,
+		device.Name,
+		device.Type,
+		device.Location.Longitude,
+		device.Location.Latitude,
+		device.Ward,
+		device.Zone,
+		device.ParentID,
+		device.Status,
+		configurationJSON,
+		metadataJSON,
+		pq.Array(device.Tags),
+		device.Region,
+	).Scan(&device.CreatedAt, &device.UpdatedAt)
+	if err != nil {
+		if writeConstraintError(c, err, "device") {
+			return
+		}
+		g.logger.Error("Failed to create device", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+		return
+	}
+
+	if err := deviceconfig.Record(ctx, tx, device.ID, configurationJSON, c.GetString("user_id")); err != nil {
+		g.logger.Error("Failed to record device configuration", "error", err, "device_id", device.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+		return
+	}
+
+	// The device gets its identity in the same transaction, so it never
+	// exists without one
+	var credential *devicecred.Issued
+	if req.Credential != "none" {
+		credential, err = g.credentials.IssueTx(ctx, tx, device.TenantID, device.ID, req.Credential, c.GetString("user_id"))
+		if err == devicecred.ErrCertificatesDisabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			g.logger.Error("Failed to issue device credential", "error", err, "device_id", device.ID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		g.logger.Error("Failed to commit device", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+		return
+	}
+
+	response := gin.H{
+		"device":  device,
+		"message": "Device created successfully",
+	}
+	if credential != nil {
+		// The secret is only ever returned here
+		response["credential"] = credential
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+func (g *Gateway) GetDevice(c *gin.Context) {
+	fields, err := fieldset.Parse(c, models.Device{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	device, err := g.loadDevice(ctx, middleware.TenantID(c), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load device", "error", err, "device_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device"})
+		return
+	}
+
+	selected, err := fields.Select(device)
+	if err != nil {
+		g.logger.Error("Failed to select device fields", "error", err, "device_id", device.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device"})
+		return
+	}
+
+	response := gin.H{
+		"device":              selected,
+		"allowed_transitions": devicelifecycle.Next(device.Status),
+	}
+
+	// Live readings are best effort; the registry record is authoritative
+	if latest, err := g.statuses.GetLatestStatus(ctx, device.ID); err == nil {
+		response["connectivity"] = latest.Connectivity
+		response["last_seen"] = latest.LastSeen
+		response["metrics"] = latest.Metrics
+	} else {
+		g.logger.Warn("Failed to load cached device status", "error", err, "device_id", device.ID)
+	}
+
+	httpcache.JSON(c, http.StatusOK, response, httpcache.Registry)
+}
+
 func (g *Gateway) UpdateDevice(c *gin.Context) {
 	deviceID := c.Param("id")
 
 	var updateReq struct {
 		Name   string `json:"name"`
 		Status string `json:"status"`
+		Reason string `json:"reason"`
+		// An empty string detaches the device from its gateway
+		ParentID *string `json:"parent_device_id"`
+		// Replaces the device's metadata when present
+		Metadata map[string]interface{} `json:"metadata"`
 	}
 
-	if err := c.ShouldBindJSON(&updateReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !middleware.BindJSON(c, &updateReq) {
+		return
+	}
+
+	if updateReq.Status != "" && !devicelifecycle.Valid(updateReq.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown device status: " + updateReq.Status})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	tx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		g.logger.Error("Failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+	defer tx.Rollback()
+
+	var currentStatus, deviceTypeName string
+	err = tx.QueryRowContext(ctx, `
+		SELECT status, type FROM devices WHERE id = $1 AND tenant_id = $2 FOR UPDATE
+	`, deviceID, middleware.TenantID(c)).Scan(&currentStatus, &deviceTypeName)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to lock device", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+
+	if updateReq.Name != "" {
+		if _, err := tx.ExecContext(ctx, `UPDATE devices SET name = $1 WHERE id = $2`, updateReq.Name, deviceID); err != nil {
+			g.logger.Error("Failed to update device name", "error", err, "device_id", deviceID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+			return
+		}
+	}
+
+	if updateReq.Metadata != nil {
+		deviceType, err := g.types.Get(ctx, deviceTypeName)
+		if err != nil && err != sql.ErrNoRows {
+			g.logger.Error("Failed to load device type", "error", err, "type", deviceTypeName)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+			return
+		}
+		// Devices of an unregistered type have no metadata rules
+		if deviceType != nil && !checkDeviceMetadata(c, deviceType, updateReq.Metadata) {
+			return
+		}
+
+		metadataJSON, err := json.Marshal(updateReq.Metadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid metadata"})
+			return
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE devices SET metadata = $1 WHERE id = $2`, metadataJSON, deviceID); err != nil {
+			g.logger.Error("Failed to update device metadata", "error", err, "device_id", deviceID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+			return
+		}
+	}
+
+	if updateReq.ParentID != nil {
+		if *updateReq.ParentID != "" {
+			if err := validateParent(ctx, tx, middleware.TenantID(c), deviceID, *updateReq.ParentID); err != nil {
+				if err == errUnknownParent || err == errParentCycle {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				g.logger.Error("Failed to validate parent device", "error", err, "device_id", deviceID)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+				return
+			}
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE devices SET parent_device_id = NULLIF($1, '') WHERE id = $2`, *updateReq.ParentID, deviceID)
+		if err != nil {
+			// The parent may have been deleted since it was validated
+			if writeConstraintError(c, err, "device") {
+				return
+			}
+			g.logger.Error("Failed to update parent device", "error", err, "device_id", deviceID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+			return
+		}
+	}
+
+	if updateReq.Status != "" && updateReq.Status != currentStatus {
+		err := devicelifecycle.Transition(ctx, tx, deviceID, currentStatus, updateReq.Status, updateReq.Reason, c.GetString("user_id"))
+		if transitionErr, ok := err.(*devicelifecycle.TransitionError); ok {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":               transitionErr.Error(),
+				"current_status":      currentStatus,
+				"allowed_transitions": transitionErr.Allowed,
+			})
+			return
+		}
+		if err != nil {
+			g.logger.Error("Failed to change device status", "error", err, "device_id", deviceID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+			return
+		}
+		currentStatus = updateReq.Status
+	}
+
+	if err := tx.Commit(); err != nil {
+		g.logger.Error("Failed to commit device update", "error", err, "device_id", deviceID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
 		return
 	}
 
-	// TODO: Implement actual device update
 	c.JSON(http.StatusOK, gin.H{
-		"id":      deviceID,
-		"message": "Device updated successfully",
+		"id":                  deviceID,
+		"status":              currentStatus,
+		"allowed_transitions": devicelifecycle.Next(currentStatus),
+		"message":             "Device updated successfully",
 	})
 }
 
-func (g *Gateway) DeleteDevice(c *gin.Context) {
-	deviceID := c.Param("id")
+// checkDeviceMetadata writes a 400 listing every violation and returns false
+// if the metadata breaks the device type's metadata rules.
+func checkDeviceMetadata(c *gin.Context, deviceType *devicetype.DeviceType, metadata map[string]interface{}) bool {
+	err := deviceType.CheckMetadata(metadata)
+	if metadataErr, ok := err.(*devicetype.MetadataError); ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid device metadata",
+			"violations": metadataErr.Violations,
+		})
+		return false
+	}
+	return true
+}
+
+func (g *Gateway) GetDeviceHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	device, err := g.loadDevice(ctx, middleware.TenantID(c), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load device", "error", err, "device_id", c.Param("id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device history"})
+		return
+	}
+
+	history, err := devicelifecycle.History(ctx, g.db.DB, device.ID)
+	if err != nil {
+		g.logger.Error("Failed to load device history", "error", err, "device_id", device.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device history"})
+		return
+	}
 
-	// TODO: Implement actual device deletion
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Device " + deviceID + " deleted successfully",
+		"device_id": device.ID,
+		"status":    device.Status,
+		"history":   history,
 	})
 }
 
-func (g *Gateway) GetWaterConsumption(c *gin.Context) {
-	// TODO: Implement actual water consumption data
-	c.JSON(http.StatusOK, gin.H{
-		"daily_consumption":   245.5,
-		"monthly_consumption": 7250.0,
-		"unit":               "liters",
-		"last_updated":       "2024-01-15T10:30:00Z",
-	})
+// deviceColumns are the devices columns, aliased as "d", that scanDevice
+// reads.
+const deviceColumns = `d.id, d.tenant_id, d.name, d.type,
+	COALESCE(ST_Y(d.location::geometry), 0), COALESCE(ST_X(d.location::geometry), 0),
+	COALESCE(d.ward, ''), COALESCE(d.zone, ''), COALESCE(d.region, ''), COALESCE(d.parent_device_id, ''), d.status,
+	COALESCE(d.configuration, '{}'), COALESCE(d.metadata, '{}'), d.tags, d.created_at, d.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (g *Gateway) GetWaterQuality(c *gin.Context) {
-	// TODO: Implement actual water quality data
-	c.JSON(http.StatusOK, gin.H{
-		"ph_level":     7.1,
-		"turbidity":    1.2,
-		"chlorine":     0.5,
-		"quality_index": 85,
-		"status":       "good",
-		"last_updated": "2024-01-15T10:30:00Z",
-	})
+func (g *Gateway) loadDevice(ctx context.Context, tenantID, deviceID string) (*models.Device, error) {
+	return scanDevice(g.db.QueryRowContext(ctx, `
+		SELECT `+deviceColumns+`
+		FROM devices d
+		WHERE d.id = $1 AND d.tenant_id = $2
+	`, deviceID, tenantID))
 }
 
-func (g *Gateway) GetElectricityConsumption(c *gin.Context) {
-	// TODO: Implement actual electricity consumption data
-	c.JSON(http.StatusOK, gin.H{
-		"daily_consumption":   15.5,
-		"monthly_consumption": 450.0,
-		"unit":               "kWh",
-		"current_load":       2.3,
-		"last_updated":       "2024-01-15T10:30:00Z",
-	})
+func scanDevice(row rowScanner) (*models.Device, error) {
+	var device models.Device
+	var configurationJSON, metadataJSON []byte
+
+	err := row.Scan(
+		&device.ID,
+		&device.TenantID,
+		&device.Name,
+		&device.Type,
+		&device.Location.Latitude,
+		&device.Location.Longitude,
+		&device.Ward,
+		&device.Zone,
+		&device.Region,
+		&device.ParentID,
+		&device.Status,
+		&configurationJSON,
+		&metadataJSON,
+		pq.Array(&device.Tags),
+		&device.CreatedAt,
+		&device.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(configurationJSON, &device.Configuration); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metadataJSON, &device.Metadata); err != nil {
+		return nil, err
+	}
+
+	return &device, nil
 }
 
-func (g *Gateway) GetGridStatus(c *gin.Context) {
-	// TODO: Implement actual grid status data
+func (g *Gateway) DeleteDevice(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	// TODO: Implement actual device deletion
 	c.JSON(http.StatusOK, gin.H{
-		"status":       "stable",
-		"load":         78.5,
-		"voltage":      230.2,
-		"frequency":    50.1,
-		"outages":      0,
-		"last_updated": "2024-01-15T10:30:00Z",
+		"message": "Device " + deviceID + " deleted successfully",
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/auth"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+// The cookie tying a sign-in's state to the browser that started it, so a
+// callback with someone else's state is refused
+const (
+	ssoStateCookie     = "sso_state"
+	ssoStateCookiePath = "/api/v1/auth/sso/"
+)
+
+func ssoStateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListSSOProviders names the single sign-on providers a login page can
+// offer.
+func (g *Gateway) ListSSOProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": g.auth.SSOProviders()})
+}
+
+// BeginSSO sends the browser to the provider to sign in.
+func (g *Gateway) BeginSSO(c *gin.Context) {
+	provider := c.Param("provider")
+
+	location, state, err := g.auth.BeginSSO(c.Request.Context(), provider)
+	if err != nil {
+		g.ssoFailed(c, provider, err)
+		return
+	}
+
+	// Lax, so the cookie comes back on the provider's top-level redirect
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, ssoStateHash(state), int(auth.SSOStateTTL.Seconds()), ssoStateCookiePath, "",
+		g.config.Environment == "production", true)
+	c.Redirect(http.StatusFound, location)
+}
+
+// CompleteSSO handles the provider's redirect back after signing in. The
+// browser is sent on to the provider's frontend URL with the tokens in the
+// fragment, which never reaches a server, or is given them as JSON if the
+// provider has none. If the user must still give an MFA or confirmation
+// code, it gets an sso_token to finish with at VerifySSO instead.
+func (g *Gateway) CompleteSSO(c *gin.Context) {
+	provider := c.Param("provider")
+
+	bound, _ := c.Cookie(ssoStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, "", -1, ssoStateCookiePath, "", g.config.Environment == "production", true)
+
+	if refusal := c.Query("error"); refusal != "" {
+		g.ssoFailed(c, provider, &auth.SSODeniedError{Reason: "sign-in was cancelled or refused by the provider: " + refusal})
+		return
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" || subtle.ConstantTimeCompare([]byte(bound), []byte(ssoStateHash(state))) != 1 {
+		g.ssoFailed(c, provider, auth.ErrSSOStateInvalid)
+		return
+	}
+
+	resp, err := g.auth.CompleteSSO(c.Request.Context(), provider, state, code, &auth.LoginRequest{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		g.ssoFailed(c, provider, err)
+		return
+	}
+
+	g.ssoSignedIn(c, provider, resp)
+}
+
+// VerifySSO finishes a single sign-on that was held for an MFA or
+// confirmation code.
+func (g *Gateway) VerifySSO(c *gin.Context) {
+	var req struct {
+		Token            string `json:"sso_token" binding:"required"`
+		MFACode          string `json:"mfa_code"`
+		ConfirmationCode string `json:"confirmation_code"`
+		CaptchaToken     string `json:"captcha_token"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	resp, err := g.auth.VerifySSO(c.Request.Context(), req.Token, &auth.LoginRequest{
+		MFACode:          req.MFACode,
+		ConfirmationCode: req.ConfirmationCode,
+		CaptchaToken:     req.CaptchaToken,
+		IPAddress:        c.ClientIP(),
+		UserAgent:        c.Request.UserAgent(),
+	})
+	var denied *auth.SSODeniedError
+	switch {
+	case errors.Is(err, auth.ErrSSOStateInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.As(err, &denied):
+		c.JSON(http.StatusForbidden, gin.H{"error": denied.Reason})
+	case err != nil:
+		g.loginFailed(c, err)
+	default:
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// ssoSignedIn hands a completed sign-in's tokens to the browser.
+func (g *Gateway) ssoSignedIn(c *gin.Context, provider string, resp *auth.LoginResponse) {
+	frontend := g.auth.SSOFrontendURL(provider)
+	if frontend == "" {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	fragment := url.Values{
+		"access_token":       {resp.AccessToken},
+		"refresh_token":      {resp.RefreshToken},
+		"expires_in":         {strconv.FormatInt(resp.ExpiresIn, 10)},
+		"refresh_expires_in": {strconv.FormatInt(resp.RefreshExpiresIn, 10)},
+	}
+	c.Redirect(http.StatusFound, frontend+"#"+fragment.Encode())
+}
+
+// ssoFailed reports a failed sign-in, to the provider's frontend URL in
+// the fragment if it has one. A sign-in held for a second factor is
+// reported with its sso_token.
+func (g *Gateway) ssoFailed(c *gin.Context, provider string, err error) {
+	status := http.StatusInternalServerError
+	message := "Sign-in failed"
+
+	fields := url.Values{}
+
+	var denied *auth.SSODeniedError
+	var challenge *auth.SSOChallengeError
+	switch {
+	case errors.Is(err, auth.ErrUnknownProvider):
+		status, message = http.StatusNotFound, err.Error()
+	case errors.Is(err, auth.ErrSSOStateInvalid):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, auth.ErrSessionStoreUnavailable):
+		g.logger.Error("Session store unavailable during SSO", "error", err, "provider", provider)
+		status, message = http.StatusServiceUnavailable, auth.ErrSessionStoreUnavailable.Error()
+	case errors.Is(err, auth.ErrIdentityProvider):
+		g.logger.Error("SSO provider failed", "error", err, "provider", provider)
+		status, message = http.StatusBadGateway, auth.ErrIdentityProvider.Error()
+	case errors.As(err, &denied):
+		status, message = http.StatusForbidden, denied.Reason
+	case errors.As(err, &challenge):
+		status, message = http.StatusUnauthorized, challenge.Reason
+		fields.Set("sso_token", challenge.Token)
+	default:
+		g.logger.Error("Failed to complete SSO", "error", err, "provider", provider)
+	}
+
+	fields.Set("error", message)
+	if frontend := g.auth.SSOFrontendURL(provider); frontend != "" {
+		c.Redirect(http.StatusFound, frontend+"#"+fields.Encode())
+		return
+	}
+	body := gin.H{}
+	for key := range fields {
+		body[key] = fields.Get(key)
+	}
+	c.JSON(status, body)
+}
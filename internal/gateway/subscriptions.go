@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceaccess"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/subscription"
+)
+
+type subscriptionRequest struct {
+	DeviceID       string  `json:"device_id"`
+	Metric         string  `json:"metric"`
+	Operator       string  `json:"operator"`
+	Threshold      float64 `json:"threshold"`
+	Unit           string  `json:"unit"`
+	NotifyRecovery bool    `json:"notify_recovery"`
+	Enabled        *bool   `json:"enabled"`
+}
+
+// ListSubscriptions returns the caller's metric subscriptions.
+func (g *Gateway) ListSubscriptions(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	subscriptions, err := g.subscriptions.List(c.Request.Context(), middleware.TenantID(c), userID)
+	if err != nil {
+		g.logger.Error("Failed to list subscriptions", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subscriptions})
+}
+
+func (g *Gateway) GetSubscription(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	found, err := g.subscriptions.Get(c.Request.Context(), middleware.TenantID(c), userID, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load subscription", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscription": found})
+}
+
+// CreateSubscription asks for a notification when a device metric goes
+// below or above a threshold. Citizens may only watch devices assigned to
+// them. Ingestion picks up new subscriptions within a minute.
+func (g *Gateway) CreateSubscription(c *gin.Context) {
+	sub, ok := g.bindSubscription(c)
+	if !ok {
+		return
+	}
+
+	err := g.subscriptions.Create(c.Request.Context(), middleware.TenantID(c), sub)
+	if !g.subscriptionSaved(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"subscription": sub,
+		"message":      "Subscription created successfully",
+	})
+}
+
+// UpdateSubscription replaces a subscription. It is re-armed, so a
+// condition that already holds notifies again.
+func (g *Gateway) UpdateSubscription(c *gin.Context) {
+	sub, ok := g.bindSubscription(c)
+	if !ok {
+		return
+	}
+	sub.ID = c.Param("id")
+
+	err := g.subscriptions.Update(c.Request.Context(), middleware.TenantID(c), sub)
+	if !g.subscriptionSaved(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscription": sub,
+		"message":      "Subscription updated successfully",
+	})
+}
+
+func (g *Gateway) DeleteSubscription(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	err := g.subscriptions.Delete(c.Request.Context(), middleware.TenantID(c), userID, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to delete subscription", "error", err, "user_id", userID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Subscription deleted successfully"})
+}
+
+// bindSubscription reads a subscription for the caller from the request,
+// answering 404 for a device a citizen isn't assigned.
+func (g *Gateway) bindSubscription(c *gin.Context) (*subscription.Subscription, bool) {
+	var req subscriptionRequest
+	if !middleware.BindJSON(c, &req) {
+		return nil, false
+	}
+
+	userID := c.GetString("user_id")
+	if deviceaccess.Scoped(c.GetString("role")) && req.DeviceID != "" {
+		assigned, err := g.access.Assigned(c.Request.Context(), userID, req.DeviceID)
+		if err != nil {
+			g.logger.Error("Failed to check device access", "error", err, "device_id", req.DeviceID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save subscription"})
+			return nil, false
+		}
+		if !assigned {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return nil, false
+		}
+	}
+
+	return &subscription.Subscription{
+		UserID:         userID,
+		DeviceID:       req.DeviceID,
+		Metric:         req.Metric,
+		Operator:       req.Operator,
+		Threshold:      req.Threshold,
+		Unit:           req.Unit,
+		NotifyRecovery: req.NotifyRecovery,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}, true
+}
+
+// subscriptionSaved answers a failed save, reporting whether it succeeded.
+func (g *Gateway) subscriptionSaved(c *gin.Context, err error) bool {
+	if validationErr, ok := err.(*subscription.ValidationError); ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid subscription",
+			"violations": validationErr.Violations,
+		})
+		return false
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription or device not found"})
+		return false
+	}
+	if err != nil {
+		g.logger.Error("Failed to save subscription", "error", err, "user_id", c.GetString("user_id"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save subscription"})
+		return false
+	}
+	return true
+}
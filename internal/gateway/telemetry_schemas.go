@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/internal/telemetryschema"
+)
+
+func (g *Gateway) ListTelemetrySchemas(c *gin.Context) {
+	deviceType := c.Param("type")
+
+	schemas, err := g.schemas.List(c.Request.Context(), deviceType)
+	if err != nil {
+		g.logger.Error("Failed to list telemetry schemas", "error", err, "type", deviceType)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list telemetry schemas"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"telemetry_schemas": schemas})
+}
+
+// GetTelemetrySchema returns one version of a type's schema, or the one in
+// force for version "latest".
+func (g *Gateway) GetTelemetrySchema(c *gin.Context) {
+	deviceType := c.Param("type")
+	ctx := c.Request.Context()
+
+	var schema *telemetryschema.Schema
+	var err error
+	if c.Param("version") == "latest" {
+		schema, err = g.schemas.Latest(ctx, deviceType)
+	} else {
+		version, ok := schemaVersion(c)
+		if !ok {
+			return
+		}
+		schema, err = g.schemas.Get(ctx, deviceType, version)
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Telemetry schema not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to load telemetry schema", "error", err, "type", deviceType)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve telemetry schema"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"telemetry_schema": schema})
+}
+
+// CreateTelemetrySchema adds the next version of a type's schema, which
+// ingestion enforces from then on. Versions are never edited; changing a
+// schema means adding a version. The response lists what the new version
+// rejects that the previous one accepted. The device service picks up the
+// change within 5 minutes.
+func (g *Gateway) CreateTelemetrySchema(c *gin.Context) {
+	var req struct {
+		Metrics map[string]telemetryschema.Metric `json:"metrics"`
+		Strict  bool                              `json:"strict"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	deviceType := c.Param("type")
+	ctx := c.Request.Context()
+
+	previous, err := g.schemas.Latest(ctx, deviceType)
+	if err != nil && err != sql.ErrNoRows {
+		g.logger.Error("Failed to load telemetry schema", "error", err, "type", deviceType)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save telemetry schema"})
+		return
+	}
+
+	schema := &telemetryschema.Schema{DeviceType: deviceType, Metrics: req.Metrics, Strict: req.Strict}
+	err = g.schemas.Create(ctx, schema, c.GetString("user_id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device type not found"})
+		return
+	}
+	if validationErr, ok := err.(*telemetryschema.ValidationError); ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Invalid telemetry schema",
+			"violations": validationErr.Violations,
+		})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to save telemetry schema", "error", err, "type", deviceType)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save telemetry schema"})
+		return
+	}
+
+	response := gin.H{
+		"telemetry_schema": schema,
+		"message":          "Telemetry schema saved successfully",
+	}
+	if previous != nil {
+		if changes := telemetryschema.Changes(previous, schema); len(changes) > 0 {
+			response["breaking_changes"] = changes
+		}
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// DeleteTelemetrySchema removes a version. Deleting the latest puts the
+// version before it back in force, or stops checking readings if it was
+// the only one.
+func (g *Gateway) DeleteTelemetrySchema(c *gin.Context) {
+	version, ok := schemaVersion(c)
+	if !ok {
+		return
+	}
+	deviceType := c.Param("type")
+
+	err := g.schemas.Delete(c.Request.Context(), deviceType, version)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Telemetry schema not found"})
+		return
+	}
+	if err != nil {
+		g.logger.Error("Failed to delete telemetry schema", "error", err, "type", deviceType, "version", version)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete telemetry schema"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Telemetry schema deleted successfully"})
+}
+
+func schemaVersion(c *gin.Context) (int, bool) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version must be a positive integer or latest"})
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,77 @@
+package gateway
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/auth"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+// CreateAccessToken issues a personal access token for the caller. The
+// token is only ever returned in this response.
+func (g *Gateway) CreateAccessToken(c *gin.Context) {
+	if !g.sessionOnly(c) {
+		return
+	}
+
+	var req auth.CreateTokenRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	userID := c.GetString("user_id")
+	token, created, err := g.tokens.Create(c.Request.Context(), userID, middleware.TenantID(c), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.logger.Info("Personal access token created", "user_id", userID, "token_id", created.ID, "scopes", created.Scopes)
+	c.JSON(http.StatusCreated, gin.H{
+		"token":        token,
+		"access_token": created,
+	})
+}
+
+func (g *Gateway) ListAccessTokens(c *gin.Context) {
+	tokens, err := g.tokens.List(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		g.logger.Error("Failed to list access tokens", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve access tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"access_tokens": tokens})
+}
+
+func (g *Gateway) RevokeAccessToken(c *gin.Context) {
+	if !g.sessionOnly(c) {
+		return
+	}
+
+	userID, tokenID := c.GetString("user_id"), c.Param("id")
+	if err := g.tokens.Revoke(c.Request.Context(), userID, tokenID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Access token not found"})
+			return
+		}
+		g.logger.Error("Failed to revoke access token", "error", err, "token_id", tokenID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access token"})
+		return
+	}
+
+	g.logger.Info("Personal access token revoked", "user_id", userID, "token_id", tokenID)
+	c.JSON(http.StatusOK, gin.H{"message": "Access token revoked"})
+}
+
+// sessionOnly rejects requests made with a personal access token, so a
+// leaked token can't be used to mint longer-lived ones.
+func (g *Gateway) sessionOnly(c *gin.Context) bool {
+	if middleware.TokenID(c) != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access tokens can only be managed from a login session"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/auth"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+)
+
+// ImportUsers creates accounts in bulk from a CSV file, sent either as the
+// "file" field of a multipart form or as a text/csv body. Rows are created
+// independently, so the response reports each row's outcome and is 200
+// even when some rows failed. Imports can grant admin, so only super
+// admins may run them.
+func (g *Gateway) ImportUsers(c *gin.Context) {
+	if c.GetString("role") != "super_admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient privileges"})
+		return
+	}
+
+	var file io.Reader = c.Request.Body
+	if c.ContentType() == "multipart/form-data" {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Multipart uploads need a \"file\" field"})
+			return
+		}
+		upload, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+			return
+		}
+		defer upload.Close()
+		file = upload
+	}
+
+	tenantID := middleware.TenantID(c)
+	results, err := g.auth.ImportUsers(c.Request.Context(), tenantID, file)
+	if err != nil {
+		if importErr, ok := err.(*auth.ImportError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": importErr.Error()})
+			return
+		}
+		g.logger.Error("Failed to import users", "error", err, "tenant_id", tenantID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import users"})
+		return
+	}
+
+	summary := map[string]int{}
+	for _, result := range results {
+		summary[result.Status]++
+	}
+
+	g.logger.Info("Users imported",
+		"tenant_id", tenantID,
+		"imported_by", c.GetString("user_id"),
+		"rows", len(results),
+		"created", summary[auth.ImportCreated],
+	)
+	c.JSON(http.StatusOK, gin.H{
+		"summary": summary,
+		"results": results,
+	})
+}
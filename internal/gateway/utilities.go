@@ -0,0 +1,387 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/deviceaccess"
+	"github.com/bhanukaranwal/urbanzen/internal/middleware"
+	"github.com/bhanukaranwal/urbanzen/pkg/httpcache"
+)
+
+const (
+	defaultUtilityRange = 24 * time.Hour
+	maxUtilityRange     = 366 * 24 * time.Hour
+
+	// Hourly series are limited so a response stays a dashboard's worth
+	maxHourlyRange = 31 * 24 * time.Hour
+)
+
+// utilityMeter is the device type and cumulative metric a utility's
+// consumption is read from, the same meters budgets are tracked against.
+type utilityMeter struct {
+	deviceType string
+	metric     string
+}
+
+var (
+	waterMeter       = utilityMeter{deviceType: "water_sensor", metric: "volume"}
+	electricityMeter = utilityMeter{deviceType: "electricity_meter", metric: "energy"}
+)
+
+// Quality readings reported by water sensors. Sensors without a probe for
+// one simply don't report it.
+var waterQualityMetrics = []string{"ph", "turbidity", "chlorine", "pressure", "temperature"}
+
+var utilityIntervals = map[string]bool{"hour": true, "day": true, "month": true}
+
+// utilityRange is the period a utilities endpoint reports on. Without from
+// and to it is the last 24 hours. Interval buckets consumption series and
+// follows the tenant's calendar.
+type utilityRange struct {
+	From     *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Interval string     `form:"interval"`
+}
+
+// bind reads and checks the range, filling in defaults. It writes a 400 and
+// returns false when the range is invalid.
+func (r *utilityRange) bind(c *gin.Context) bool {
+	if err := c.ShouldBindQuery(r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if r.To == nil {
+		now := time.Now()
+		r.To = &now
+	}
+	if r.From == nil {
+		from := r.To.Add(-defaultUtilityRange)
+		r.From = &from
+	}
+	if r.Interval == "" {
+		r.Interval = "day"
+	}
+
+	span := r.To.Sub(*r.From)
+	switch {
+	case span <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+	case span > maxUtilityRange:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The range may span at most 366 days"})
+	case !utilityIntervals[r.Interval]:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be hour, day or month"})
+	case r.Interval == "hour" && span > maxHourlyRange:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Hourly series may span at most 31 days"})
+	default:
+		return true
+	}
+	return false
+}
+
+type consumptionPoint struct {
+	Period      time.Time `json:"period"`
+	Consumption float64   `json:"consumption"`
+}
+
+// metricSummary describes one metric across a utility's meters.
+type metricSummary struct {
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Unit    string  `json:"unit,omitempty"`
+	Devices int     `json:"devices"`
+}
+
+// GetWaterConsumption reports the water used by the caller's meters over a
+// range, in total and per interval.
+func (g *Gateway) GetWaterConsumption(c *gin.Context) {
+	g.getConsumption(c, "water", waterMeter)
+}
+
+// GetElectricityConsumption reports the energy used by the caller's meters
+// over a range, in total and per interval, with their current load.
+func (g *Gateway) GetElectricityConsumption(c *gin.Context) {
+	g.getConsumption(c, "electricity", electricityMeter)
+}
+
+func (g *Gateway) getConsumption(c *gin.Context, utility string, meter utilityMeter) {
+	var period utilityRange
+	if !period.bind(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+
+	deviceIDs, err := g.utilityMeters(ctx, tenantID, meter.deviceType, deviceaccess.AssignedTo(c), g.regions.Scope(c))
+	if err != nil {
+		g.logger.Error("Failed to load meters", "error", err, "utility", utility)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve consumption"})
+		return
+	}
+
+	response := gin.H{
+		"utility":     utility,
+		"unit":        g.metricUnit(ctx, meter.deviceType, meter.metric),
+		"from":        period.From,
+		"to":          period.To,
+		"interval":    period.Interval,
+		"devices":     len(deviceIDs),
+		"consumption": 0.0,
+		"series":      []consumptionPoint{},
+	}
+	if len(deviceIDs) == 0 {
+		httpcache.JSON(c, http.StatusOK, response, httpcache.Status)
+		return
+	}
+
+	total, series, err := g.consumption(ctx, tenantID, deviceIDs, meter.metric, period)
+	if err != nil {
+		g.logger.Error("Failed to compute consumption", "error", err, "utility", utility)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve consumption"})
+		return
+	}
+	response["consumption"] = total
+	response["series"] = series
+
+	if meter == electricityMeter {
+		var load float64
+		err := g.tsdb.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(last_value), 0) FROM (
+				SELECT DISTINCT ON (device_id) last_value
+				FROM device_telemetry_aggregates
+				WHERE device_id = ANY($1) AND metric = 'power' AND bucket >= $2 AND bucket < $3
+				ORDER BY device_id, bucket DESC
+			) latest
+		`, pq.Array(deviceIDs), period.From, period.To).Scan(&load)
+		if err != nil {
+			g.logger.Error("Failed to compute current load", "error", err)
+		} else {
+			response["current_load"] = load
+			response["load_unit"] = g.metricUnit(ctx, meter.deviceType, "power")
+		}
+	}
+
+	httpcache.JSON(c, http.StatusOK, response, httpcache.Status)
+}
+
+// consumption reads how far each meter's cumulative reading advanced over
+// the range from the telemetry aggregates, as billing does for budgets. The
+// series buckets the same by interval in the tenant's time zone; usage
+// between a meter's last reading in one period and its first in the next
+// is in the total but neither period.
+func (g *Gateway) consumption(ctx context.Context, tenantID string, deviceIDs []string, metric string,
+	period utilityRange) (float64, []consumptionPoint, error) {
+	total, err := g.meterConsumption(ctx, deviceIDs, metric, *period.From, *period.To)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	timezone := "UTC"
+	if tenantConfig, err := g.tenants.Resolve(ctx, tenantID); err == nil {
+		timezone = tenantConfig.Location().String()
+	} else {
+		g.logger.Error("Failed to resolve tenant config", "error", err, "tenant_id", tenantID)
+	}
+
+	rows, err := g.tsdb.QueryContext(ctx, `
+		SELECT period AT TIME ZONE $6, SUM(used) FROM (
+			SELECT date_trunc($5, bucket AT TIME ZONE $6) AS period, MAX(max_value) - MIN(min_value) AS used
+			FROM device_telemetry_aggregates
+			WHERE device_id = ANY($1) AND metric = $2 AND bucket >= $3 AND bucket < $4
+			GROUP BY device_id, period
+		) per_device
+		GROUP BY period
+		ORDER BY period
+	`, pq.Array(deviceIDs), metric, period.From, period.To, period.Interval, timezone)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	series := []consumptionPoint{}
+	for rows.Next() {
+		var point consumptionPoint
+		if err := rows.Scan(&point.Period, &point.Consumption); err != nil {
+			return 0, nil, err
+		}
+		series = append(series, point)
+	}
+	return total, series, rows.Err()
+}
+
+// meterConsumption totals how far the meters' cumulative metric advanced
+// between from and to.
+func (g *Gateway) meterConsumption(ctx context.Context, deviceIDs []string, metric string, from, to time.Time) (float64, error) {
+	var total float64
+	err := g.tsdb.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(used), 0) FROM (
+			SELECT MAX(max_value) - MIN(min_value) AS used
+			FROM device_telemetry_aggregates
+			WHERE device_id = ANY($1) AND metric = $2 AND bucket >= $3 AND bucket < $4
+			GROUP BY device_id
+		) per_device
+	`, pq.Array(deviceIDs), metric, from, to).Scan(&total)
+	return total, err
+}
+
+// GetWaterQuality summarizes the quality readings of the caller's water
+// sensors over a range.
+func (g *Gateway) GetWaterQuality(c *gin.Context) {
+	var period utilityRange
+	if !period.bind(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	deviceIDs, err := g.utilityMeters(ctx, middleware.TenantID(c), waterMeter.deviceType, deviceaccess.AssignedTo(c), g.regions.Scope(c))
+	if err != nil {
+		g.logger.Error("Failed to load meters", "error", err, "utility", "water")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve water quality"})
+		return
+	}
+
+	metrics, err := g.summarizeMetrics(ctx, waterMeter.deviceType, deviceIDs, waterQualityMetrics, period)
+	if err != nil {
+		g.logger.Error("Failed to summarize water quality", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve water quality"})
+		return
+	}
+
+	httpcache.JSON(c, http.StatusOK, gin.H{
+		"from":    period.From,
+		"to":      period.To,
+		"devices": len(deviceIDs),
+		"metrics": metrics,
+	}, httpcache.Status)
+}
+
+// GetGridStatus summarizes supply voltage and frequency at the tenant's
+// electricity meters over a range, with how many of them reported in it.
+// Citizens see only their own meters.
+func (g *Gateway) GetGridStatus(c *gin.Context) {
+	var period utilityRange
+	if !period.bind(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	deviceIDs, err := g.utilityMeters(ctx, middleware.TenantID(c), electricityMeter.deviceType, deviceaccess.AssignedTo(c), g.regions.Scope(c))
+	if err != nil {
+		g.logger.Error("Failed to load meters", "error", err, "utility", "electricity")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve grid status"})
+		return
+	}
+
+	metrics, err := g.summarizeMetrics(ctx, electricityMeter.deviceType, deviceIDs, []string{"voltage", "frequency"}, period)
+	if err != nil {
+		g.logger.Error("Failed to summarize grid status", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve grid status"})
+		return
+	}
+
+	reporting := 0
+	if len(deviceIDs) > 0 {
+		err := g.tsdb.QueryRowContext(ctx, `
+			SELECT COUNT(DISTINCT device_id) FROM device_telemetry_aggregates
+			WHERE device_id = ANY($1) AND bucket >= $2 AND bucket < $3
+		`, pq.Array(deviceIDs), period.From, period.To).Scan(&reporting)
+		if err != nil {
+			g.logger.Error("Failed to count reporting meters", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve grid status"})
+			return
+		}
+	}
+
+	httpcache.JSON(c, http.StatusOK, gin.H{
+		"from":      period.From,
+		"to":        period.To,
+		"devices":   len(deviceIDs),
+		"reporting": reporting,
+		"metrics":   metrics,
+	}, httpcache.Status)
+}
+
+// summarizeMetrics returns each of the named metrics the devices reported
+// in the range. Metrics nobody reported are left out.
+func (g *Gateway) summarizeMetrics(ctx context.Context, deviceType string, deviceIDs, names []string,
+	period utilityRange) (map[string]metricSummary, error) {
+	summaries := make(map[string]metricSummary)
+	if len(deviceIDs) == 0 {
+		return summaries, nil
+	}
+
+	rows, err := g.tsdb.QueryContext(ctx, `
+		SELECT metric, SUM(sum_value) / SUM(sample_count), MIN(min_value), MAX(max_value), COUNT(DISTINCT device_id)
+		FROM device_telemetry_aggregates
+		WHERE device_id = ANY($1) AND metric = ANY($2) AND bucket >= $3 AND bucket < $4
+		GROUP BY metric
+	`, pq.Array(deviceIDs), pq.Array(names), period.From, period.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var metric string
+		var summary metricSummary
+		if err := rows.Scan(&metric, &summary.Average, &summary.Min, &summary.Max, &summary.Devices); err != nil {
+			return nil, err
+		}
+		summaries[metric] = summary
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for metric, summary := range summaries {
+		summary.Unit = g.metricUnit(ctx, deviceType, metric)
+		summaries[metric] = summary
+	}
+	return summaries, nil
+}
+
+// utilityMeters returns the tenant's devices of a type, limited to those
+// assigned to assignedTo when it is set and to those in regions unless it
+// is nil.
+func (g *Gateway) utilityMeters(ctx context.Context, tenantID, deviceType string, assignedTo *string, regions []string) ([]string, error) {
+	rows, err := g.db.QueryContext(ctx, `
+		SELECT d.id FROM devices d
+		WHERE d.tenant_id = $1 AND d.type = $2
+			AND ($3::text IS NULL OR EXISTS (
+				SELECT 1 FROM device_assignments a WHERE a.device_id = d.id AND a.user_id::text = $3
+			))
+			AND ($4::text[] IS NULL OR COALESCE(d.region, '') = ANY($4))
+	`, tenantID, deviceType, assignedTo, pq.Array(regions))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deviceIDs []string
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, err
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	return deviceIDs, rows.Err()
+}
+
+// metricUnit is the unit a type's metric is stored in, or "" when the type
+// doesn't declare one.
+func (g *Gateway) metricUnit(ctx context.Context, deviceType, metric string) string {
+	registered, err := g.types.Get(ctx, deviceType)
+	if err != nil {
+		g.logger.Warn("Failed to load device type", "error", err, "type", deviceType)
+		return ""
+	}
+	return registered.MetricUnits[metric]
+}
+
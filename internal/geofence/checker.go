@@ -0,0 +1,95 @@
+package geofence
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// Which devices have an enabled fence is cached for this long, so readings
+// from the many devices without one cost no query. Fence changes made
+// through the API reach ingestion within this time.
+const fencedCacheTTL = time.Minute
+
+// Crossing is a reading that moved a device across its fence.
+type Crossing struct {
+	// Exited is true for the first reading outside the fence and false
+	// for the first reading back inside
+	Exited   bool
+	Severity string
+
+	// DistanceMeters is how far outside the fence the reading was
+	DistanceMeters float64
+}
+
+// Checker tests readings against fences for the device service.
+type Checker struct {
+	db *database.PostgresDB
+
+	// device ID -> fencedEntry
+	fenced sync.Map
+}
+
+type fencedEntry struct {
+	fenced  bool
+	expires time.Time
+}
+
+func NewChecker(db *database.PostgresDB) *Checker {
+	return &Checker{db: db}
+}
+
+// Check tests a reading's location against the device's fence. It returns
+// a Crossing when the reading takes the device out of, or back into, its
+// fence, and nil when nothing changed or the device has no enabled fence.
+// The state change is made in the database, so when several instances see
+// readings from the same device only one reports the crossing.
+func (c *Checker) Check(ctx context.Context, deviceID string, location models.Location, at time.Time) (*Crossing, error) {
+	fenced, err := c.hasFence(ctx, deviceID)
+	if err != nil || !fenced {
+		return nil, err
+	}
+
+	var crossing Crossing
+	err = c.db.QueryRowContext(ctx, `
+		WITH reading AS (
+			SELECT ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography AS point
+		)
+		UPDATE device_geofences g
+		SET outside_since = CASE WHEN ST_Covers(g.area, reading.point) THEN NULL ELSE $4 END
+		FROM reading
+		WHERE g.device_id = $1 AND g.enabled
+			AND ST_Covers(g.area, reading.point) = (g.outside_since IS NOT NULL)
+		RETURNING g.outside_since IS NOT NULL, g.severity, ST_Distance(g.area, reading.point)
+	`, deviceID, location.Longitude, location.Latitude, at).Scan(&crossing.Exited, &crossing.Severity, &crossing.DistanceMeters)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &crossing, nil
+}
+
+func (c *Checker) hasFence(ctx context.Context, deviceID string) (bool, error) {
+	if cached, ok := c.fenced.Load(deviceID); ok {
+		if entry := cached.(fencedEntry); time.Now().Before(entry.expires) {
+			return entry.fenced, nil
+		}
+	}
+
+	var fenced bool
+	err := c.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM device_geofences WHERE device_id = $1 AND enabled)
+	`, deviceID).Scan(&fenced)
+	if err != nil {
+		return false, err
+	}
+
+	c.fenced.Store(deviceID, fencedEntry{fenced: fenced, expires: time.Now().Add(fencedCacheTTL)})
+	return fenced, nil
+}
@@ -0,0 +1,133 @@
+// Package geofence keeps mobile devices, such as inspection units and
+// portable sensors, within an assigned area. A fence is a circle or a
+// polygon stored in PostGIS; the first reading a device reports outside
+// its fence raises an anomaly.
+package geofence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+// AnomalyType is the type of anomaly raised when a device leaves its fence.
+const AnomalyType = "geofence_exit"
+
+const (
+	maxRadiusMeters = 100000
+	maxVertices     = 500
+)
+
+var severities = map[string]bool{"warning": true, "critical": true}
+
+// Fence is the area a device must stay within: either Center and
+// RadiusMeters, or Polygon. OutsideSince is set while the device's latest
+// reading is outside it.
+type Fence struct {
+	DeviceID     string            `json:"device_id"`
+	Center       *models.Location  `json:"center,omitempty"`
+	RadiusMeters float64           `json:"radius_m,omitempty"`
+	Polygon      []models.Location `json:"polygon,omitempty"`
+	Severity     string            `json:"severity"`
+	Enabled      bool              `json:"enabled"`
+	OutsideSince *time.Time        `json:"outside_since,omitempty"`
+	UpdatedBy    string            `json:"updated_by,omitempty"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// ValidationError lists everything wrong with a fence definition.
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid geofence: " + strings.Join(e.Violations, "; ")
+}
+
+// Validate checks the fence is one well-formed shape. Whether a polygon's
+// edges cross is left to PostGIS when it is saved.
+func (f *Fence) Validate() error {
+	var violations []string
+
+	switch {
+	case f.Center != nil && len(f.Polygon) > 0:
+		violations = append(violations, "give either center and radius_m or polygon, not both")
+	case f.Center != nil:
+		if !validLocation(*f.Center) {
+			violations = append(violations, "center must have a latitude within ±90 and a longitude within ±180")
+		}
+		if f.RadiusMeters <= 0 || f.RadiusMeters > maxRadiusMeters {
+			violations = append(violations, fmt.Sprintf("radius_m must be greater than 0 and at most %d", maxRadiusMeters))
+		}
+	case len(f.Polygon) > 0:
+		if f.RadiusMeters != 0 {
+			violations = append(violations, "radius_m only applies to a center")
+		}
+		vertices := f.vertices()
+		if len(vertices) < 3 {
+			violations = append(violations, "polygon needs at least 3 vertices")
+		}
+		if len(vertices) > maxVertices {
+			violations = append(violations, fmt.Sprintf("polygon may have at most %d vertices", maxVertices))
+		}
+		for i, vertex := range vertices {
+			if !validLocation(vertex) {
+				violations = append(violations, fmt.Sprintf("polygon vertex %d must have a latitude within ±90 and a longitude within ±180", i))
+			}
+		}
+	default:
+		violations = append(violations, "give either center and radius_m or polygon")
+	}
+
+	if !severities[f.Severity] {
+		violations = append(violations, fmt.Sprintf("severity must be warning or critical (got %q)", f.Severity))
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// vertices returns the polygon without its closing vertex, whether or not
+// the caller repeated the first point at the end.
+func (f *Fence) vertices() []models.Location {
+	vertices := f.Polygon
+	if n := len(vertices); n > 1 && vertices[0] == vertices[n-1] {
+		vertices = vertices[:n-1]
+	}
+	return vertices
+}
+
+func validLocation(l models.Location) bool {
+	return l.Latitude >= -90 && l.Latitude <= 90 && l.Longitude >= -180 && l.Longitude <= 180
+}
+
+// centerWKT and polygonWKT render the shape for ST_GeogFromText, or ""
+// when the fence isn't that shape.
+func (f *Fence) centerWKT() string {
+	if f.Center == nil {
+		return ""
+	}
+	return "POINT(" + coordinate(*f.Center) + ")"
+}
+
+func (f *Fence) polygonWKT() string {
+	if len(f.Polygon) == 0 {
+		return ""
+	}
+	vertices := f.vertices()
+	ring := make([]string, 0, len(vertices)+1)
+	for _, vertex := range vertices {
+		ring = append(ring, coordinate(vertex))
+	}
+	ring = append(ring, coordinate(vertices[0]))
+	return "POLYGON((" + strings.Join(ring, ", ") + "))"
+}
+
+func coordinate(l models.Location) string {
+	return strconv.FormatFloat(l.Longitude, 'f', -1, 64) + " " + strconv.FormatFloat(l.Latitude, 'f', -1, 64)
+}
@@ -0,0 +1,131 @@
+package geofence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// Store manages fences for the API.
+type Store struct {
+	db *database.PostgresDB
+}
+
+func NewStore(db *database.PostgresDB) *Store {
+	return &Store{db: db}
+}
+
+// Get returns a device's fence, or sql.ErrNoRows if it has none.
+func (s *Store) Get(ctx context.Context, tenantID, deviceID string) (*Fence, error) {
+	var fence Fence
+	var center, polygon sql.NullString
+	var radius sql.NullFloat64
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT device_id, ST_AsGeoJSON(center), radius_m, ST_AsGeoJSON(polygon), severity, enabled,
+			outside_since, COALESCE(updated_by::text, ''), updated_at
+		FROM device_geofences
+		WHERE device_id = $1 AND tenant_id = $2
+	`, deviceID, tenantID).Scan(&fence.DeviceID, &center, &radius, &polygon, &fence.Severity,
+		&fence.Enabled, &fence.OutsideSince, &fence.UpdatedBy, &fence.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if center.Valid {
+		var point struct {
+			Coordinates [2]float64 `json:"coordinates"`
+		}
+		if err := json.Unmarshal([]byte(center.String), &point); err != nil {
+			return nil, err
+		}
+		fence.Center = &models.Location{Longitude: point.Coordinates[0], Latitude: point.Coordinates[1]}
+		fence.RadiusMeters = radius.Float64
+	}
+	if polygon.Valid {
+		var shape struct {
+			Coordinates [][][2]float64 `json:"coordinates"`
+		}
+		if err := json.Unmarshal([]byte(polygon.String), &shape); err != nil {
+			return nil, err
+		}
+		if len(shape.Coordinates) > 0 {
+			ring := shape.Coordinates[0]
+			// GeoJSON rings repeat the first vertex at the end
+			for _, vertex := range ring[:len(ring)-1] {
+				fence.Polygon = append(fence.Polygon, models.Location{Longitude: vertex[0], Latitude: vertex[1]})
+			}
+		}
+	}
+	return &fence, nil
+}
+
+// Save creates or replaces a device's fence. It returns sql.ErrNoRows if
+// the device isn't in the tenant. Replacing a fence clears any exit in
+// progress, so a device still outside the new fence raises a fresh anomaly.
+func (s *Store) Save(ctx context.Context, tenantID string, fence *Fence, actorID string) error {
+	if err := fence.Validate(); err != nil {
+		return err
+	}
+
+	polygon := fence.polygonWKT()
+	if polygon != "" {
+		var valid bool
+		err := s.db.QueryRowContext(ctx, `SELECT ST_IsValid(ST_GeomFromText($1, 4326))`, polygon).Scan(&valid)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return &ValidationError{Violations: []string{"polygon edges must not cross each other"}}
+		}
+	}
+
+	var radius *float64
+	if fence.Center != nil {
+		radius = &fence.RadiusMeters
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO device_geofences (device_id, tenant_id, center, radius_m, polygon, area, severity, enabled, updated_by, updated_at)
+		SELECT d.id, d.tenant_id, shape.center, $4, shape.polygon,
+			COALESCE(shape.polygon, ST_Buffer(shape.center, $4::double precision, 'quad_segs=32')),
+			$6, $7, NULLIF($8, '')::uuid, NOW()
+		FROM devices d,
+			(SELECT ST_GeogFromText(NULLIF($3, '')) AS center, ST_GeogFromText(NULLIF($5, '')) AS polygon) shape
+		WHERE d.id = $1 AND d.tenant_id = $2
+		ON CONFLICT (device_id) DO UPDATE SET
+			center = EXCLUDED.center,
+			radius_m = EXCLUDED.radius_m,
+			polygon = EXCLUDED.polygon,
+			area = EXCLUDED.area,
+			severity = EXCLUDED.severity,
+			enabled = EXCLUDED.enabled,
+			outside_since = NULL,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+	`, fence.DeviceID, tenantID, fence.centerWKT(), radius, polygon, fence.Severity, fence.Enabled, actorID)
+	if err != nil {
+		return err
+	}
+	if saved, _ := result.RowsAffected(); saved == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete removes a device's fence, returning sql.ErrNoRows if it has none.
+func (s *Store) Delete(ctx context.Context, tenantID, deviceID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM device_geofences WHERE device_id = $1 AND tenant_id = $2
+	`, deviceID, tenantID)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -1,9 +1,9 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -11,13 +11,58 @@ import (
 )
 
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID    string   `json:"user_id"`
+	Username  string   `json:"username"`
+	Role      string   `json:"role"`
+	TenantID  string   `json:"tenant_id"`
+	SessionID string   `json:"session_id"`
+	Regions   []string `json:"regions,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func AuthRequired(cfg *config.Config) gin.HandlerFunc {
+// PersonalAccessTokenPrefix marks bearer tokens that are personal access
+// tokens rather than JWTs.
+const PersonalAccessTokenPrefix = "uzp_"
+
+// Personal access token scopes
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// TokenIdentity is the user a personal access token acts for.
+type TokenIdentity struct {
+	TokenID  string
+	UserID   string
+	Username string
+	Role     string
+	TenantID string
+	Scopes   []string
+	Regions  []string
+}
+
+// HasScope reports whether the token grants scope. Write implies read.
+func (t *TokenIdentity) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope || (scope == ScopeRead && granted == ScopeWrite) {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenAuthenticator resolves a personal access token, failing if it is
+// unknown, expired or revoked, and checks that a session JWT's session
+// hasn't been logged out.
+type TokenAuthenticator interface {
+	Authenticate(ctx context.Context, token string) (*TokenIdentity, error)
+	SessionValid(ctx context.Context, sessionID, userID string) bool
+}
+
+// AuthRequiredOrToken accepts either a session JWT whose session is still
+// live or a personal access token. Token requests are limited by scope:
+// reads need read, anything else needs write.
+func AuthRequiredOrToken(cfg *config.Config, tokens TokenAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -28,26 +73,88 @@ func AuthRequired(cfg *config.Config) gin.HandlerFunc {
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 		
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWT.Secret), nil
-		})
+		if strings.HasPrefix(tokenString, PersonalAccessTokenPrefix) {
+			authenticateToken(c, tokens, tokenString)
+			return
+		}
 
-		if err != nil || !token.Valid {
+		claims, err := ParseToken(cfg, tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
+		// A signed token outlives a logout; the session record doesn't
+		if !tokens.SessionValid(c.Request.Context(), claims.SessionID, claims.UserID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+			c.Abort()
+			return
+		}
 
 		// Set user info in context
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("session_id", claims.SessionID)
+		c.Set("tenant_id", claims.TenantID)
+		c.Set("regions", claims.Regions)
 
 		c.Next()
 	}
 }
 
+// ParseToken validates a session JWT's signature and expiry and returns its
+// claims. Only the HMAC method tokens are issued with is accepted. It does
+// not check the session; AuthRequiredOrToken does.
+func ParseToken(cfg *config.Config, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(cfg.JWT.Secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+func authenticateToken(c *gin.Context, tokens TokenAuthenticator, tokenString string) {
+	identity, err := tokens.Authenticate(c.Request.Context(), tokenString)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
+
+	required := ScopeWrite
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		required = ScopeRead
+	}
+	if !identity.HasScope(required) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + required + " scope"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", identity.UserID)
+	c.Set("username", identity.Username)
+	c.Set("role", identity.Role)
+	c.Set("tenant_id", identity.TenantID)
+	c.Set("token_id", identity.TokenID)
+	c.Set("regions", identity.Regions)
+
+	c.Next()
+}
+
+// TokenID returns the personal access token that authenticated the request,
+// or "" for session (JWT) requests.
+func TokenID(c *gin.Context) string {
+	return c.GetString("token_id")
+}
+
 func RequireRole(role string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userRole, exists := c.Get("role")
@@ -57,7 +164,7 @@ func RequireRole(role string) gin.HandlerFunc {
 			return
 		}
 
-		if userRole != role && userRole != "admin" {
+		if userRole != role && userRole != "admin" && userRole != "super_admin" {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient privileges"})
 			c.Abort()
 			return
@@ -65,21 +172,4 @@ func RequireRole(role string) gin.HandlerFunc {
 
 		c.Next()
 	}
-}
-
-func GenerateToken(userID, username, role string, cfg *config.Config) (string, error) {
-	expirationTime := time.Now().Add(cfg.JWT.ExpiresIn)
-	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "urbanzen",
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(cfg.JWT.Secret))
 }
\ No newline at end of file
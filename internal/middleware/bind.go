@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report validation failures by JSON field name, as clients send them
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// BindJSON decodes the request body into obj and checks its binding tags,
+// like ShouldBindJSON. On failure it writes the error response and returns
+// false; see BindError for the responses.
+func BindJSON(c *gin.Context, obj interface{}) bool {
+	return bindJSON(c, obj, false)
+}
+
+// BindOptionalJSON is BindJSON for requests where the body may be left
+// out, in which case obj is left as it is.
+func BindOptionalJSON(c *gin.Context, obj interface{}) bool {
+	return bindJSON(c, obj, true)
+}
+
+func bindJSON(c *gin.Context, obj interface{}, optional bool) bool {
+	var body []byte
+	var err error
+	if c.Request.Body != nil {
+		body, err = io.ReadAll(c.Request.Body)
+	}
+	if err == nil {
+		if optional && len(bytes.TrimSpace(body)) == 0 {
+			return true
+		}
+		err = DecodeJSON(body, obj)
+	}
+	if err != nil {
+		BindError(c, body, err)
+		return false
+	}
+	return true
+}
+
+// DecodeJSON decodes body into obj and checks its binding tags.
+func DecodeJSON(body []byte, obj interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return errEmptyBody
+	}
+	if err := json.Unmarshal(body, obj); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+var errEmptyBody = errors.New("request body is empty")
+
+// BindError answers a request whose body failed to read, decode or
+// validate:
+//
+//   - over the body limit: 413 with max_bytes
+//   - not JSON: 400 with the line, column and byte offset of the error
+//   - a value of the wrong type: 400 with the field and expected type
+//   - failing validation: 400 with the rule each field broke under fields
+func BindError(c *gin.Context, body []byte, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var validationErrs validator.ValidationErrors
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":     fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit),
+			"max_bytes": maxBytesErr.Limit,
+		})
+	case err == errEmptyBody:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body is empty"})
+	case errors.As(err, &syntaxErr):
+		line, column := position(body, syntaxErr.Offset-1)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  fmt.Sprintf("Malformed JSON at line %d, column %d: %s", line, column, syntaxErr.Error()),
+			"line":   line,
+			"column": column,
+			"offset": syntaxErr.Offset,
+		})
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    fmt.Sprintf("%s must be %s, not %s", field, jsonType(typeErr.Type), typeErr.Value),
+			"field":    field,
+			"expected": jsonType(typeErr.Type),
+		})
+	case errors.As(err, &validationErrs):
+		fields := make(map[string]string, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			rule := fieldErr.Tag()
+			if fieldErr.Param() != "" {
+				rule += "=" + fieldErr.Param()
+			}
+			fields[validationPath(fieldErr)] = rule
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Request failed validation",
+			"fields": fields,
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// position converts the index of a byte in body into a 1-based line and
+// column.
+func position(body []byte, index int64) (int, int) {
+	if index < 0 {
+		index = 0
+	}
+	if index > int64(len(body)) {
+		index = int64(len(body))
+	}
+	before := body[:index]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// validationPath is the field's path without the top-level struct name.
+func validationPath(fieldErr validator.FieldError) string {
+	path := fieldErr.Namespace()
+	if i := strings.IndexByte(path, '.'); i >= 0 {
+		return path[i+1:]
+	}
+	return fieldErr.Field()
+}
+
+// jsonType names a Go type the way JSON clients know it.
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
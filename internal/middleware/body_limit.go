@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+)
+
+const defaultMaxBodyBytes = 1 << 20
+
+// BodyLimit rejects request bodies over the configured size with 413. The
+// limit is security.max_body_bytes unless security.body_limits has an entry
+// for the matched route pattern.
+//
+// Requests declaring a larger Content-Length are refused up front. Bodies
+// without one (chunked) are cut off once the limit is read, so the handler
+// fails to decode them instead of buffering the whole payload.
+func BodyLimit(cfg *config.Config) gin.HandlerFunc {
+	defaultLimit := cfg.Security.MaxBodyBytes
+	if defaultLimit <= 0 {
+		defaultLimit = defaultMaxBodyBytes
+	}
+
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		limit := defaultLimit
+		// Viper lowercases map keys, so route patterns are matched that way
+		if routeLimit, ok := cfg.Security.BodyLimits[strings.ToLower(c.FullPath())]; ok && routeLimit > 0 {
+			limit = routeLimit
+		}
+
+		if c.Request.ContentLength > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error":     fmt.Sprintf("Request body exceeds %d bytes", limit),
+				"max_bytes": limit,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// BodyTooLarge reports whether err came from reading past the body limit,
+// for handlers that read the body themselves.
+func BodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
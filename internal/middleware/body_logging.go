@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+const redacted = "[REDACTED]"
+
+// Field names are compared lowercased with "_" and "-" removed, so
+// "api_key", "apiKey" and "X-Api-Key" are all "apikey". Names containing
+// one of sensitiveSubstrings are redacted; sensitiveNames are too short to
+// match inside other words and must match exactly.
+var (
+	sensitiveSubstrings = []string{
+		"password", "secret", "token", "apikey", "authorization", "credential", "privatekey",
+		"cardnumber", "accountnumber", "iban",
+	}
+	sensitiveNames = map[string]bool{
+		"passwd": true, "otp": true, "pin": true, "cvv": true, "cvc": true, "cookie": true,
+		"pan": true, "upi": true, "vpa": true, "expiry": true,
+	}
+)
+
+// BodyLogging logs the request and response bodies of the route patterns
+// in debug.body_logging.routes, for debugging integrations. It is a no-op
+// unless debug.body_logging.enabled is set, which config validation
+// refuses in production without allow_in_production.
+//
+// Only JSON and form bodies are logged, with the values of sensitive
+// fields replaced by [REDACTED]; other bodies, and bodies over max_bytes,
+// are logged by size alone. The request body is recorded as the handler
+// reads it, so it is never buffered twice or read past the body limit.
+func BodyLogging(cfg *config.Config, log logger.Logger) gin.HandlerFunc {
+	settings := cfg.Debug.BodyLogging
+	if !settings.Enabled {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	// Route patterns are matched lowercased, as in BodyLimit
+	routes := make(map[string]bool, len(settings.Routes))
+	for _, route := range settings.Routes {
+		routes[strings.ToLower(route)] = true
+	}
+	extra := make(map[string]bool, len(settings.RedactFields))
+	for _, field := range settings.RedactFields {
+		extra[normalizeField(field)] = true
+	}
+	log.Warn("Request and response body logging is enabled", "routes", settings.Routes)
+
+	return func(c *gin.Context) {
+		if !routes[strings.ToLower(c.FullPath())] {
+			c.Next()
+			return
+		}
+
+		request := &cappedBuffer{max: settings.MaxBytes}
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = &teeBody{Reader: io.TeeReader(c.Request.Body, request), Closer: c.Request.Body}
+		}
+		recorder := &bodyRecorder{ResponseWriter: c.Writer, body: cappedBuffer{max: settings.MaxBytes}}
+		c.Writer = recorder
+
+		c.Next()
+
+		log.Info(
+			"Request and response bodies",
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"status", recorder.Status(),
+			"request_body", loggableBody(request, c.ContentType(), extra),
+			"response_body", loggableBody(&recorder.body, recorder.Header().Get("Content-Type"), extra),
+		)
+	}
+}
+
+// cappedBuffer keeps the first max bytes written to it and counts the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	max  int
+	size int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.size += len(p)
+	if room := b.max - b.Buffer.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) truncated() bool {
+	return b.size > b.max
+}
+
+type teeBody struct {
+	io.Reader
+	io.Closer
+}
+
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body cappedBuffer
+}
+
+func (w *bodyRecorder) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.Write([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// loggableBody renders a recorded body for the log with sensitive fields
+// redacted, or describes it when it can't be redacted safely.
+func loggableBody(body *cappedBuffer, contentType string, extra map[string]bool) string {
+	if body.size == 0 {
+		return ""
+	}
+	if body.truncated() {
+		return fmt.Sprintf("[%d bytes omitted: over debug.body_logging.max_bytes]", body.size)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		decoder := json.NewDecoder(bytes.NewReader(body.Bytes()))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Sprintf("[%d bytes omitted: invalid JSON]", body.size)
+		}
+		encoded, err := json.Marshal(redactJSON(value, extra))
+		if err != nil {
+			return fmt.Sprintf("[%d bytes omitted: invalid JSON]", body.size)
+		}
+		return string(encoded)
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(body.String())
+		if err != nil {
+			return fmt.Sprintf("[%d bytes omitted: invalid form]", body.size)
+		}
+		for field := range values {
+			if sensitiveField(field, extra) {
+				values[field] = []string{redacted}
+			}
+		}
+		return values.Encode()
+	default:
+		return fmt.Sprintf("[%d bytes of %q omitted]", body.size, mediaType)
+	}
+}
+
+// redactJSON replaces the values of sensitive fields, at any depth, with
+// [REDACTED].
+func redactJSON(value interface{}, extra map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for field, nested := range v {
+			if sensitiveField(field, extra) {
+				v[field] = redacted
+			} else {
+				v[field] = redactJSON(nested, extra)
+			}
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = redactJSON(nested, extra)
+		}
+	}
+	return value
+}
+
+func sensitiveField(field string, extra map[string]bool) bool {
+	name := normalizeField(field)
+	if sensitiveNames[name] || extra[name] {
+		return true
+	}
+	for _, substring := range sensitiveSubstrings {
+		if strings.Contains(name, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeField(field string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(field))
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+)
+
+// DeviceKeyPrefix marks bearer tokens that are device API keys.
+const DeviceKeyPrefix = "uzd_"
+
+// DeviceRole is the role of requests authenticated with a device
+// credential. No user role grants it, so RequireRole never admits devices.
+const DeviceRole = "device"
+
+// ServiceRole is for service accounts that send telemetry on behalf of
+// devices, such as backfills and integrations. Admins have it too, as
+// they have every role.
+const ServiceRole = "service"
+
+// DeviceIdentity is the device a credential belongs to.
+type DeviceIdentity struct {
+	CredentialID string
+	DeviceID     string
+	TenantID     string
+}
+
+// DeviceAuthenticator resolves device credentials, failing if they are
+// unknown, expired or revoked, or the device is decommissioned.
+type DeviceAuthenticator interface {
+	AuthenticateKey(ctx context.Context, key string) (*DeviceIdentity, error)
+	AuthenticateCertificate(ctx context.Context, cert *x509.Certificate) (*DeviceIdentity, error)
+}
+
+// AuthRequiredOrDevice accepts a device credential, either an API key
+// bearer token or a verified TLS client certificate, and otherwise
+// authenticates as AuthRequiredOrToken does.
+func AuthRequiredOrDevice(cfg *config.Config, tokens TokenAuthenticator, devices DeviceAuthenticator) gin.HandlerFunc {
+	users := AuthRequiredOrToken(cfg, tokens)
+	return func(c *gin.Context) {
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+		var identity *DeviceIdentity
+		var err error
+		switch {
+		case strings.HasPrefix(tokenString, DeviceKeyPrefix):
+			identity, err = devices.AuthenticateKey(c.Request.Context(), tokenString)
+		case tokenString == "" && c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0:
+			identity, err = devices.AuthenticateCertificate(c.Request.Context(), c.Request.TLS.VerifiedChains[0][0])
+		default:
+			users(c)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid device credential"})
+			c.Abort()
+			return
+		}
+
+		c.Set("role", DeviceRole)
+		c.Set("tenant_id", identity.TenantID)
+		c.Set("device_id", identity.DeviceID)
+		c.Set("credential_id", identity.CredentialID)
+
+		c.Next()
+	}
+}
+
+// DeviceID returns the device whose credential authenticated the request,
+// or "" for user requests.
+func DeviceID(c *gin.Context) string {
+	return c.GetString("device_id")
+}
+
+// RequireRoleOrDevice admits requests authenticated with a device
+// credential and users with role, as RequireRole does.
+func RequireRoleOrDevice(role string) gin.HandlerFunc {
+	users := RequireRole(role)
+	return func(c *gin.Context) {
+		if DeviceID(c) != "" {
+			c.Next()
+			return
+		}
+		users(c)
+	}
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EnvelopeHeader opts a request into the standard response envelope, as an
+// alternative to an "envelope=true" parameter on the Accept media type.
+const EnvelopeHeader = "X-Response-Envelope"
+
+// Keys handlers use for response metadata rather than the resource itself
+var envelopeMetaKeys = []string{"pagination", "count", "message"}
+
+// ResponseEnvelope is the uniform response shape for clients that opt in.
+type ResponseEnvelope struct {
+	Data   interface{}            `json:"data"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+	Errors []EnvelopeError        `json:"errors,omitempty"`
+}
+
+type EnvelopeError struct {
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// EnvelopeRequested reports whether the client asked for the envelope.
+func EnvelopeRequested(c *gin.Context) bool {
+	if enabled, err := strconv.ParseBool(c.GetHeader(EnvelopeHeader)); err == nil {
+		return enabled
+	}
+
+	for _, accepted := range strings.Split(c.GetHeader("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if enabled, err := strconv.ParseBool(params["envelope"]); err == nil && enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// Envelope rewrites JSON responses into ResponseEnvelope for clients that
+// opt in. Handlers keep writing their bare shapes, which stay the default.
+func Envelope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Representations differ by opt-in, so caches must key on it
+		c.Writer.Header().Add("Vary", "Accept, "+EnvelopeHeader)
+
+		if !EnvelopeRequested(c) {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		buffered := &bufferedWriter{ResponseWriter: original, status: http.StatusOK}
+		c.Writer = buffered
+		// On a panic, recovery must write to the real response
+		defer func() { c.Writer = original }()
+		c.Next()
+		c.Writer = original
+
+		body := buffered.body.Bytes()
+		contentType, _, _ := mime.ParseMediaType(original.Header().Get("Content-Type"))
+		if contentType == "application/json" && len(body) > 0 {
+			if wrapped, err := json.Marshal(WrapResponse(buffered.status, body)); err == nil {
+				body = wrapped
+			}
+		}
+
+		original.Header().Del("Content-Length")
+		original.WriteHeader(buffered.status)
+		original.Write(body)
+	}
+}
+
+// WrapResponse converts a bare JSON response body into the envelope. Error
+// responses ({"error": ...}) become errors; pagination, counts and messages
+// move to meta; a single remaining field is unwrapped into data.
+func WrapResponse(status int, body []byte) ResponseEnvelope {
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return ResponseEnvelope{Data: json.RawMessage(body)}
+	}
+
+	object, ok := decoded.(map[string]interface{})
+	if !ok {
+		return ResponseEnvelope{Data: decoded}
+	}
+
+	if status >= http.StatusBadRequest {
+		message, _ := object["error"].(string)
+		if message == "" {
+			message = http.StatusText(status)
+		}
+		delete(object, "error")
+
+		envelopeError := EnvelopeError{Message: message}
+		if len(object) > 0 {
+			envelopeError.Details = object
+		}
+		return ResponseEnvelope{Errors: []EnvelopeError{envelopeError}}
+	}
+
+	envelope := ResponseEnvelope{}
+	for _, key := range envelopeMetaKeys {
+		if value, exists := object[key]; exists {
+			if envelope.Meta == nil {
+				envelope.Meta = make(map[string]interface{})
+			}
+			envelope.Meta[key] = value
+			delete(object, key)
+		}
+	}
+
+	envelope.Data = object
+	if len(object) == 1 {
+		for _, value := range object {
+			envelope.Data = value
+		}
+	}
+	return envelope
+}
+
+// bufferedWriter holds the response so it can be rewritten once the
+// handler has finished.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+const (
+	defaultShedThreshold  = 0.9
+	defaultShedRetryAfter = 5 * time.Second
+)
+
+// LoadSignal reports how close a resource is to saturation, from 0 (idle)
+// to 1 (full).
+type LoadSignal func() float64
+
+// PoolLoad is the share of a database pool's connections in use.
+func PoolLoad(db *sql.DB) LoadSignal {
+	return func() float64 {
+		stats := db.Stats()
+		if stats.MaxOpenConnections <= 0 {
+			return 0
+		}
+		return float64(stats.InUse) / float64(stats.MaxOpenConnections)
+	}
+}
+
+// LoadShedding refuses requests with 503 and Retry-After while any signal
+// is at or over load_shedding.threshold, so an overloaded service keeps
+// serving the requests it accepts instead of slowing down for all of them.
+// Routes in load_shedding.critical_routes, such as health checks, are
+// always served.
+func LoadShedding(cfg *config.Config, signals map[string]LoadSignal) gin.HandlerFunc {
+	settings := cfg.LoadShedding
+	if !settings.Enabled || len(signals) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	threshold := settings.Threshold
+	if threshold <= 0 {
+		threshold = defaultShedThreshold
+	}
+	retryAfter := settings.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultShedRetryAfter
+	}
+	critical := make(map[string]bool, len(settings.CriticalRoutes))
+	for _, route := range settings.CriticalRoutes {
+		critical[route] = true
+	}
+
+	return func(c *gin.Context) {
+		if critical[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		for name, signal := range signals {
+			if signal() < threshold {
+				continue
+			}
+
+			metrics.RequestsShed.WithLabelValues(name).Inc()
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service is overloaded, retry later"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
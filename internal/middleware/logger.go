@@ -1,8 +1,6 @@
 package middleware
 
 import (
-    "time"
-    
     "github.com/gin-gonic/gin"
     "github.com/bhanukaranwal/UrbanZen/pkg/logger"
 )
@@ -19,14 +17,4 @@ func Logger(log logger.Logger) gin.HandlerFunc {
         )
         return ""
     })
-}
-
-func Security() gin.HandlerFunc {
-    return func(c *gin.Context) {
-        c.Header("X-Frame-Options", "DENY")
-        c.Header("X-Content-Type-Options", "nosniff")
-        c.Header("X-XSS-Protection", "1; mode=block")
-        c.Header("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-        c.Next()
-    }
 }
\ No newline at end of file
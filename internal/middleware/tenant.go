@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const TenantHeader = "X-Tenant-ID"
+
+// Tenant resolves the tenant (city) for the request. For authenticated
+// requests the JWT claim is authoritative and a conflicting header is
+// rejected; only super admins may act on another tenant via the header.
+// Unauthenticated requests (login, registration) fall back to the header.
+func Tenant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claimTenant := c.GetString("tenant_id")
+		headerTenant := c.GetHeader(TenantHeader)
+
+		tenantID := claimTenant
+		if headerTenant != "" && headerTenant != claimTenant {
+			if claimTenant != "" && c.GetString("role") != "super_admin" {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Cross-tenant access denied"})
+				c.Abort()
+				return
+			}
+			tenantID = headerTenant
+		}
+
+		if tenantID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tenant could not be resolved"})
+			c.Abort()
+			return
+		}
+
+		c.Set("tenant_id", tenantID)
+		c.Next()
+	}
+}
+
+// TenantID returns the tenant resolved for the request.
+func TenantID(c *gin.Context) string {
+	return c.GetString("tenant_id")
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+)
+
+type Bill struct {
+	ID          string    `json:"id" db:"id"`
+	TenantID    string    `json:"tenant_id" db:"tenant_id"`
+	UserID      string    `json:"user_id" db:"user_id"`
+	DeviceID    string    `json:"device_id,omitempty" db:"device_id"`
+	UtilityType string    `json:"utility_type" db:"utility_type"`
+	PeriodStart time.Time `json:"period_start" db:"period_start"`
+	PeriodEnd   time.Time `json:"period_end" db:"period_end"`
+	Consumption float64   `json:"consumption" db:"consumption"`
+	Amount      float64   `json:"amount" db:"amount"`
+	AmountPaid  float64   `json:"amount_paid" db:"amount_paid"`
+	LateFees    float64   `json:"late_fees" db:"late_fees"`
+	DueDate     time.Time `json:"due_date" db:"due_date"`
+	Status      string    `json:"status" db:"status"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+
+	// EstimatedConsumption is the part of Consumption estimated for time
+	// the meter didn't report, by EstimationMethod. Zero when the whole
+	// period was metered.
+	EstimatedConsumption float64 `json:"estimated_consumption" db:"estimated_consumption"`
+	EstimationMethod     string  `json:"estimation_method,omitempty" db:"estimation_method"`
+}
+
+type BillDispute struct {
+	ID         string     `json:"id" db:"id"`
+	TenantID   string     `json:"tenant_id" db:"tenant_id"`
+	BillID     string     `json:"bill_id" db:"bill_id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	Reason     string     `json:"reason" db:"reason"`
+	Status     string     `json:"status" db:"status"`
+	Resolution string     `json:"resolution,omitempty" db:"resolution"`
+	AnomalyIDs []string   `json:"anomaly_ids,omitempty" db:"anomaly_ids"`
+	ReviewedBy string     `json:"reviewed_by,omitempty" db:"reviewed_by"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// BillAdjustment records a change to a bill's amount after generation. A
+// negative amount is a credit.
+type BillAdjustment struct {
+	ID        string    `json:"id" db:"id"`
+	BillID    string    `json:"bill_id" db:"bill_id"`
+	DisputeID string    `json:"dispute_id,omitempty" db:"dispute_id"`
+	Amount    float64   `json:"amount" db:"amount"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedBy string    `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type Payment struct {
+	ID            string    `json:"id" db:"id"`
+	BillID        string    `json:"bill_id" db:"bill_id"`
+	UserID        string    `json:"user_id" db:"user_id"`
+	Amount        float64   `json:"amount" db:"amount"`
+	PaymentMethod string    `json:"payment_method" db:"payment_method"`
+	TransactionID string    `json:"transaction_id" db:"transaction_id"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+type PaymentPlan struct {
+	ID           string        `json:"id" db:"id"`
+	BillID       string        `json:"bill_id" db:"bill_id"`
+	UserID       string        `json:"user_id" db:"user_id"`
+	Status       string        `json:"status" db:"status"`
+	Installments []Installment `json:"installments"`
+	CreatedAt    time.Time     `json:"created_at" db:"created_at"`
+}
+
+type Installment struct {
+	ID         string     `json:"id" db:"id"`
+	Sequence   int        `json:"sequence" db:"sequence"`
+	DueDate    time.Time  `json:"due_date" db:"due_date"`
+	Amount     float64    `json:"amount" db:"amount"`
+	AmountPaid float64    `json:"amount_paid" db:"amount_paid"`
+	Status     string     `json:"status" db:"status"`
+	RemindedAt *time.Time `json:"reminded_at,omitempty" db:"reminded_at"`
+}
+
+// ConsumptionBudget is a citizen's monthly consumption limit for one
+// utility, with the percentages of it they want to be told about.
+type ConsumptionBudget struct {
+	ID           string    `json:"id" db:"id"`
+	TenantID     string    `json:"tenant_id" db:"tenant_id"`
+	UserID       string    `json:"user_id" db:"user_id"`
+	UtilityType  string    `json:"utility_type" db:"utility_type"`
+	MonthlyLimit float64   `json:"monthly_limit" db:"monthly_limit"`
+	Thresholds   []int64   `json:"thresholds" db:"thresholds"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -6,19 +6,37 @@ import (
 )
 
 type Device struct {
-	ID          string                 `json:"id" db:"id"`
-	Name        string                 `json:"name" db:"name"`
-	Type        string                 `json:"type" db:"type"`
-	Location    Location               `json:"location" db:"location"`
-	Status      string                 `json:"status" db:"status"`
-	LastSeen    time.Time              `json:"last_seen" db:"last_seen"`
-	Metadata    map[string]interface{} `json:"metadata" db:"metadata"`
-	CreatedAt   time.Time              `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time              `json:"updated_at" db:"updated_at"`
+	ID            string                 `json:"id" db:"id"`
+	TenantID      string                 `json:"tenant_id" db:"tenant_id"`
+	Name          string                 `json:"name" db:"name"`
+	Type          string                 `json:"type" db:"type"`
+	Location      Location               `json:"location" db:"location"`
+	Ward          string                 `json:"ward,omitempty" db:"ward"`
+	Zone          string                 `json:"zone,omitempty" db:"zone"`
+	Region        string                 `json:"region,omitempty" db:"region"`
+	ParentID      string                 `json:"parent_device_id,omitempty" db:"parent_device_id"`
+	Tags          []string               `json:"tags" db:"tags"`
+	Status        string                 `json:"status" db:"status"`
+	LastSeen      time.Time              `json:"last_seen" db:"last_seen"`
+	Configuration map[string]interface{} `json:"configuration" db:"configuration"`
+	Metadata      map[string]interface{} `json:"metadata" db:"metadata"`
+	CreatedAt     time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at" db:"updated_at"`
+}
+
+// DeviceAssignment gives a user access to a device's data.
+type DeviceAssignment struct {
+	DeviceID   string    `json:"device_id" db:"device_id"`
+	UserID     string    `json:"user_id" db:"user_id"`
+	Username   string    `json:"username,omitempty" db:"username"`
+	AssignedBy string    `json:"assigned_by,omitempty" db:"assigned_by"`
+	AssignedAt time.Time `json:"assigned_at" db:"assigned_at"`
 }
 
 type DeviceData struct {
 	DeviceID    string                 `json:"device_id"`
+	TenantID    string                 `json:"tenant_id,omitempty"`
+	Region      string                 `json:"region,omitempty"`
 	DeviceType  string                 `json:"device_type"`
 	Timestamp   time.Time              `json:"timestamp"`
 	Location    Location               `json:"location"`
@@ -26,6 +44,89 @@ type DeviceData struct {
 	Metadata    map[string]interface{} `json:"metadata"`
 }
 
+// Anomaly is raised by one reading: Metric's Value in the telemetry sample
+// at Timestamp.
+type Anomaly struct {
+	ID          string      `json:"id,omitempty" db:"id"`
+	DeviceID    string      `json:"device_id" db:"device_id"`
+	Type        string      `json:"type" db:"type"`
+	Severity    string      `json:"severity" db:"severity"`
+	Description string      `json:"description" db:"description"`
+	Timestamp   time.Time   `json:"timestamp" db:"timestamp"`
+	Metric      string      `json:"metric,omitempty" db:"metric"`
+	Value       interface{} `json:"value" db:"value"`
+	ResolvedAt  *time.Time  `json:"resolved_at,omitempty" db:"resolved_at"`
+	ResolvedBy  *string     `json:"resolved_by,omitempty" db:"resolved_by"`
+	CreatedAt   *time.Time  `json:"created_at,omitempty" db:"created_at"`
+}
+
+type DeviceCommand struct {
+	ID         string                 `json:"id,omitempty" db:"id"`
+	DeviceID   string                 `json:"device_id" db:"device_id"`
+	Command    string                 `json:"command" db:"command"`
+	Parameters map[string]interface{} `json:"parameters" db:"parameters"`
+	SequenceID string                 `json:"sequence_id,omitempty" db:"sequence_id"`
+	Step       int                    `json:"step,omitempty" db:"step"`
+	Status     string                 `json:"status" db:"status"`
+	Timestamp  time.Time              `json:"timestamp" db:"timestamp"`
+	IssuedBy   string                 `json:"issued_by,omitempty" db:"issued_by"`
+}
+
+// Device event types published on kafka.topics.device_events
+const (
+	EventConnectivityChanged = "device.connectivity_changed"
+)
+
+// ConnectivityChangedEvent is published when a device's connectivity moves
+// from one state to another. It is keyed by device ID, so a device's
+// events stay in order.
+type ConnectivityChangedEvent struct {
+	Type      string    `json:"type"`
+	DeviceID  string    `json:"device_id"`
+	TenantID  string    `json:"tenant_id"`
+	OldStatus string    `json:"old_status"`
+	NewStatus string    `json:"new_status"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type CommandSequence struct {
+	ID          string          `json:"id" db:"id"`
+	TenantID    string          `json:"tenant_id" db:"tenant_id"`
+	DeviceID    string          `json:"device_id" db:"device_id"`
+	Template    string          `json:"template" db:"template"`
+	Status      string          `json:"status" db:"status"`
+	CurrentStep int             `json:"current_step" db:"current_step"`
+	TotalSteps  int             `json:"total_steps" db:"total_steps"`
+	Steps       []DeviceCommand `json:"steps,omitempty"`
+	CreatedBy   string          `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// CommandSchedule sends a command on a cron schedule to one device or to
+// every active device matching a group filter.
+type CommandSchedule struct {
+	ID            string                 `json:"id" db:"id"`
+	TenantID      string                 `json:"tenant_id" db:"tenant_id"`
+	Name          string                 `json:"name" db:"name"`
+	Cron          string                 `json:"cron" db:"cron"`
+	Timezone      string                 `json:"timezone" db:"timezone"`
+	Command       string                 `json:"command" db:"command"`
+	Parameters    map[string]interface{} `json:"parameters" db:"parameters"`
+	DeviceID      string                 `json:"device_id,omitempty" db:"device_id"`
+	DeviceType    string                 `json:"device_type,omitempty" db:"device_type"`
+	Ward          string                 `json:"ward,omitempty" db:"ward"`
+	Zone          string                 `json:"zone,omitempty" db:"zone"`
+	MisfirePolicy string                 `json:"misfire_policy" db:"misfire_policy"`
+	Enabled       bool                   `json:"enabled" db:"enabled"`
+	NextRunAt     *time.Time             `json:"next_run_at,omitempty" db:"next_run_at"`
+	LastRunAt     *time.Time             `json:"last_run_at,omitempty" db:"last_run_at"`
+	CreatedBy     string                 `json:"created_by" db:"created_by"`
+	CreatedAt     time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at" db:"updated_at"`
+}
+
 type Location struct {
 	Latitude  float64 `json:"latitude"`
 	Longitude float64 `json:"longitude"`
@@ -33,6 +134,7 @@ type Location struct {
 
 type User struct {
 	ID                  uuid.UUID              `json:"id" db:"id"`
+	TenantID            string                 `json:"tenant_id" db:"tenant_id"`
 	Username            string                 `json:"username" db:"username"`
 	Email               string                 `json:"email" db:"email"`
 	PasswordHash        string                 `json:"-" db:"password_hash"`
@@ -41,15 +143,22 @@ type User struct {
 	Role                string                 `json:"role" db:"role"`
 	Phone               string                 `json:"phone" db:"phone"`
 	Address             string                 `json:"address" db:"address"`
+	Ward                string                 `json:"ward,omitempty" db:"ward"`
+	Region              string                 `json:"region,omitempty" db:"region"`
 	IsActive            bool                   `json:"is_active" db:"is_active"`
 	EmailVerified       bool                   `json:"email_verified" db:"email_verified"`
 	NotificationPrefs   map[string]interface{} `json:"notification_preferences" db:"notification_preferences"`
+	NotificationDigest  string                 `json:"notification_digest" db:"notification_digest"`
+	FailedLoginAttempts int                    `json:"-" db:"failed_login_attempts"`
+	LockedUntil         *time.Time             `json:"locked_until,omitempty" db:"locked_until"`
+	ErasedAt            *time.Time             `json:"erased_at,omitempty" db:"erased_at"`
 	CreatedAt           time.Time              `json:"created_at" db:"created_at"`
 	UpdatedAt           time.Time              `json:"updated_at" db:"updated_at"`
 }
 
 type Alert struct {
 	ID          uuid.UUID              `json:"id" db:"id"`
+	TenantID    string                 `json:"tenant_id" db:"tenant_id"`
 	Type        string                 `json:"type" db:"type"`
 	Severity    string                 `json:"severity" db:"severity"`
 	Title       string                 `json:"title" db:"title"`
@@ -57,7 +166,11 @@ type Alert struct {
 	DeviceID    string                 `json:"device_id,omitempty" db:"device_id"`
 	UserID      *uuid.UUID             `json:"user_id,omitempty" db:"user_id"`
 	Acknowledged bool                  `json:"acknowledged" db:"acknowledged"`
+	AcknowledgedBy *uuid.UUID          `json:"acknowledged_by,omitempty" db:"acknowledged_by"`
+	AcknowledgedAt *time.Time          `json:"acknowledged_at,omitempty" db:"acknowledged_at"`
 	Resolved    bool                   `json:"resolved" db:"resolved"`
+	ResolvedBy  *uuid.UUID             `json:"resolved_by,omitempty" db:"resolved_by"`
+	ResolvedAt  *time.Time             `json:"resolved_at,omitempty" db:"resolved_at"`
 	Metadata    map[string]interface{} `json:"metadata" db:"metadata"`
 	CreatedAt   time.Time              `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time              `json:"updated_at" db:"updated_at"`
@@ -65,6 +178,7 @@ type Alert struct {
 
 type Notification struct {
 	ID          uuid.UUID              `json:"id" db:"id"`
+	TenantID    string                 `json:"tenant_id,omitempty" db:"tenant_id"`
 	UserID      uuid.UUID              `json:"user_id" db:"user_id"`
 	Type        string                 `json:"type" db:"type"`
 	Title       string                 `json:"title" db:"title"`
@@ -72,8 +186,13 @@ type Notification struct {
 	Priority    string                 `json:"priority" db:"priority"`
 	Channels    []string               `json:"channels" db:"channels"`
 	ScheduledAt *time.Time             `json:"scheduled_at,omitempty" db:"scheduled_at"`
+	ResendOf    *uuid.UUID             `json:"resend_of,omitempty" db:"resend_of"`
 	Status      string                 `json:"status" db:"status"`
 	Metadata    map[string]interface{} `json:"metadata" db:"metadata"`
 	CreatedAt   time.Time              `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time              `json:"updated_at" db:"updated_at"`
+	
+	// DedupKey identifies the condition being notified about; repeats for
+	// the same user, type and key within the dedup window are dropped
+	DedupKey    string                 `json:"dedup_key,omitempty" db:"-"`
 }
\ No newline at end of file
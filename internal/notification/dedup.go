@@ -0,0 +1,43 @@
+package notification
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// isDuplicate reports whether the same notification was already accepted
+// for the user within the dedup window, claiming the slot if not. The key
+// is (user, type, dedup key); without a caller-supplied key the title and
+// message stand in, so identical notifications still collapse.
+//
+// Emergency notifications bypass dedup unless include_emergency is set,
+// and Redis failures let the notification through rather than drop it.
+func (s *Service) isDuplicate(ctx context.Context, notification *models.Notification) bool {
+	settings := s.config.Notifications.Dedup
+	if settings.Window <= 0 {
+		return false
+	}
+	if notification.Priority == "emergency" && !settings.IncludeEmergency {
+		return false
+	}
+
+	dedupKey := notification.DedupKey
+	if dedupKey == "" {
+		sum := sha256.Sum256([]byte(notification.Title + "\n" + notification.Message))
+		dedupKey = hex.EncodeToString(sum[:16])
+	}
+	key := fmt.Sprintf("notification_dedup:%s:%s:%s", notification.UserID, notification.Type, dedupKey)
+
+	claimed, err := s.redis.SetNX(ctx, key, notification.ID.String(), settings.Window).Result()
+	if err != nil {
+		s.logger.Warn("Failed to check notification dedup", "error", err, "key", key)
+		metrics.RedisFallbacks.WithLabelValues("notification_dedup").Inc()
+		return false
+	}
+	return !claimed
+}
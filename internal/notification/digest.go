@@ -0,0 +1,197 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+const (
+	digestImmediate = "immediate"
+	digestHourly    = "hourly"
+	digestDaily     = "daily"
+
+	statusQueuedDigest = "queued_digest"
+	statusDigested     = "digested"
+
+	// Entries listed in a digest message; the rest are only counted
+	maxDigestEntries = 20
+)
+
+type digestEntry struct {
+	ID        string
+	Type      string
+	Title     string
+	Message   string
+	CreatedAt time.Time
+}
+
+// queueForDigest holds back a regular notification for a user who prefers
+// digests, reporting whether it did. Anything going wrong delivers the
+// notification immediately instead.
+func (s *Service) queueForDigest(ctx context.Context, notification *models.Notification) bool {
+	var mode string
+	err := s.db.QueryRowContext(ctx, `SELECT notification_digest FROM users WHERE id = $1`,
+		notification.UserID).Scan(&mode)
+	if err != nil {
+		s.logger.Warn("Failed to get digest preference", "error", err, "user_id", notification.UserID)
+		return false
+	}
+	if mode == digestImmediate {
+		return false
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE notifications SET status = $1, updated_at = NOW() WHERE id = $2
+	`, statusQueuedDigest, notification.ID)
+	if err != nil {
+		s.logger.Error("Failed to queue notification for digest", "error", err, "notification_id", notification.ID)
+		return false
+	}
+
+	notification.Status = statusQueuedDigest
+	return true
+}
+
+// sendDueDigests sends a digest to every user whose oldest queued
+// notification has waited a full digest period. Users who switched back to
+// immediate delivery get what is still queued straight away.
+func (s *Service) sendDueDigests(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT n.user_id, u.notification_digest
+		FROM notifications n
+		JOIN users u ON u.id = n.user_id
+		WHERE n.status = $1
+		GROUP BY n.user_id, u.notification_digest
+		HAVING MIN(n.created_at) <= NOW() - CASE u.notification_digest
+			WHEN 'hourly' THEN INTERVAL '1 hour'
+			WHEN 'daily' THEN INTERVAL '1 day'
+			ELSE INTERVAL '0'
+		END
+		LIMIT 100
+	`, statusQueuedDigest)
+	if err != nil {
+		s.logger.Error("Failed to query due digests", "error", err)
+		return
+	}
+
+	type dueDigest struct {
+		userID uuid.UUID
+		mode   string
+	}
+	var due []dueDigest
+	for rows.Next() {
+		var d dueDigest
+		if err := rows.Scan(&d.userID, &d.mode); err != nil {
+			s.logger.Error("Failed to scan due digest", "error", err)
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		s.sendDigest(ctx, d.userID, d.mode)
+	}
+}
+
+// sendDigest claims the user's queued notifications, so concurrent runs
+// can't send them twice, and delivers them as one notification. If the
+// digest can't be stored the claimed notifications are queued again.
+func (s *Service) sendDigest(ctx context.Context, userID uuid.UUID, mode string) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE notifications SET status = $1, updated_at = NOW()
+		WHERE user_id = $2 AND status = $3
+		RETURNING id, type, title, message, created_at
+	`, statusDigested, userID, statusQueuedDigest)
+	if err != nil {
+		s.logger.Error("Failed to claim digest notifications", "error", err, "user_id", userID)
+		return
+	}
+
+	var entries []digestEntry
+	for rows.Next() {
+		var entry digestEntry
+		if err := rows.Scan(&entry.ID, &entry.Type, &entry.Title, &entry.Message, &entry.CreatedAt); err != nil {
+			s.logger.Error("Failed to scan digest notification", "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if len(entries) == 0 {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+
+	digest := buildDigest(userID, mode, entries, ids)
+	if err := s.storeNotification(ctx, digest); err != nil {
+		s.logger.Error("Failed to store digest", "error", err, "user_id", userID)
+		// Uncancellable, or the claimed notifications would be stuck
+		_, err := s.db.Exec(`UPDATE notifications SET status = $1 WHERE id = ANY($2)`,
+			statusQueuedDigest, pq.Array(ids))
+		if err != nil {
+			s.logger.Error("Failed to requeue digest notifications", "error", err, "user_id", userID)
+		}
+		return
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE notifications SET digest_id = $1 WHERE id = ANY($2)`,
+		digest.ID, pq.Array(ids))
+	if err != nil {
+		s.logger.Warn("Failed to link notifications to digest", "error", err, "digest_id", digest.ID)
+	}
+
+	if !s.sendToPreferredChannels(ctx, digest) {
+		s.hold(ctx, digest)
+		return
+	}
+	s.logger.Info("Digest sent", "user_id", userID, "mode", mode, "notifications", len(entries))
+}
+
+func buildDigest(userID uuid.UUID, mode string, entries []digestEntry, ids []string) *models.Notification {
+	period := "update"
+	switch mode {
+	case digestHourly:
+		period = "hourly digest"
+	case digestDaily:
+		period = "daily digest"
+	}
+
+	var message strings.Builder
+	for i, entry := range entries {
+		if i == maxDigestEntries {
+			fmt.Fprintf(&message, "...and %d more\n", len(entries)-maxDigestEntries)
+			break
+		}
+		fmt.Fprintf(&message, "- %s: %s\n", entry.Title, entry.Message)
+	}
+
+	return &models.Notification{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Type:     "digest",
+		Title:    fmt.Sprintf("Your %s: %d notifications", period, len(entries)),
+		Message:  strings.TrimRight(message.String(), "\n"),
+		Priority: "normal",
+		Metadata: map[string]interface{}{
+			"digest_mode":      mode,
+			"notification_ids": ids,
+			"since":            entries[0].CreatedAt,
+		},
+	}
+}
@@ -0,0 +1,191 @@
+package notification
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/retry"
+)
+
+const (
+	defaultHealthInterval   = 30 * time.Second
+	defaultHealthWindow     = 5 * time.Minute
+	defaultFailureThreshold = 0.5
+	defaultMinSamples       = 5
+	healthPingTimeout       = 5 * time.Second
+)
+
+// Pinger is implemented by providers that offer a cheap liveness check.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type sendOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// monitoredChannel reports a channel unavailable while it is degraded: its
+// last ping failed, or too many recent sends failed transiently. Failures
+// age out of the window, so a channel skipped for being degraded recovers
+// on its own even without pings.
+type monitoredChannel struct {
+	name    string
+	channel NotificationChannel
+	pinger  Pinger
+	logger  logger.Logger
+
+	interval         time.Duration
+	window           time.Duration
+	failureThreshold float64
+	minSamples       int
+
+	// recovered is signalled, without blocking, when the channel recovers
+	recovered chan<- struct{}
+
+	mu       sync.Mutex
+	outcomes []sendOutcome
+	pingErr  error
+	pingedAt time.Time
+	degraded bool
+}
+
+// monitor wraps channel (the provider, possibly decorated) with health
+// tracking. The provider is pinged directly if it supports it.
+func monitor(name string, provider, channel NotificationChannel, cfg *config.Config, log logger.Logger) *monitoredChannel {
+	settings := cfg.Notifications.Health
+	m := &monitoredChannel{
+		name:             name,
+		channel:          channel,
+		logger:           log,
+		interval:         settings.Interval,
+		window:           settings.Window,
+		failureThreshold: settings.FailureThreshold,
+		minSamples:       settings.MinSamples,
+	}
+	if pinger, ok := provider.(Pinger); ok {
+		m.pinger = pinger
+	}
+
+	if m.interval <= 0 {
+		m.interval = defaultHealthInterval
+	}
+	if m.window <= 0 {
+		m.window = defaultHealthWindow
+	}
+	if m.failureThreshold <= 0 || m.failureThreshold > 1 {
+		m.failureThreshold = defaultFailureThreshold
+	}
+	if m.minSamples <= 0 {
+		m.minSamples = defaultMinSamples
+	}
+	return m
+}
+
+func (m *monitoredChannel) Send(ctx context.Context, notification *models.Notification) error {
+	err := m.channel.Send(ctx, notification)
+
+	// Rejections (bad recipient, cancellation) say nothing about the provider
+	if err == nil || retry.Retryable(err) {
+		m.mu.Lock()
+		m.outcomes = append(m.outcomes, sendOutcome{at: time.Now(), failed: err != nil})
+		m.mu.Unlock()
+	}
+	return err
+}
+
+func (m *monitoredChannel) IsAvailable() bool {
+	return m.channel.IsAvailable() && m.healthy(time.Now())
+}
+
+func (m *monitoredChannel) healthy(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop outcomes that have left the window
+	keep := 0
+	for keep < len(m.outcomes) && now.Sub(m.outcomes[keep].at) > m.window {
+		keep++
+	}
+	m.outcomes = m.outcomes[keep:]
+
+	failures := 0
+	for _, outcome := range m.outcomes {
+		if outcome.failed {
+			failures++
+		}
+	}
+
+	// A ping result is trusted for two intervals, so a stalled checker
+	// doesn't pin the channel down
+	pingFailed := m.pingErr != nil && now.Sub(m.pingedAt) < 2*m.interval
+	ratioFailed := len(m.outcomes) >= m.minSamples &&
+		float64(failures)/float64(len(m.outcomes)) >= m.failureThreshold
+
+	degraded := pingFailed || ratioFailed
+	if degraded != m.degraded {
+		m.degraded = degraded
+		if degraded {
+			m.logger.Warn("Notification channel degraded",
+				"channel", m.name,
+				"ping_error", m.pingErr,
+				"recent_failures", failures,
+				"recent_sends", len(m.outcomes),
+			)
+		} else {
+			m.logger.Info("Notification channel recovered", "channel", m.name)
+			if m.recovered != nil {
+				select {
+				case m.recovered <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+	return !degraded
+}
+
+// check pings the provider, if it can be pinged, and re-evaluates health.
+func (m *monitoredChannel) check(ctx context.Context) {
+	if m.pinger != nil {
+		pingCtx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+		err := m.pinger.Ping(pingCtx)
+		cancel()
+
+		m.mu.Lock()
+		m.pingErr, m.pingedAt = err, time.Now()
+		m.mu.Unlock()
+	}
+	m.healthy(time.Now())
+}
+
+// monitorChannels keeps channel health current between sends.
+func (s *Service) monitorChannels(ctx context.Context) {
+	var monitored []*monitoredChannel
+	interval := defaultHealthInterval
+	for _, channel := range s.channels {
+		if m, ok := channel.(*monitoredChannel); ok {
+			monitored = append(monitored, m)
+			interval = m.interval
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, m := range monitored {
+			m.check(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+const (
+	statusHeld          = "held"
+	statusUndeliverable = "undeliverable"
+
+	// Held notifications are released in batches of this many
+	heldReleaseBatch = 100
+
+	// priorityRank orders notifications most urgent first
+	priorityRank = `CASE priority WHEN 'emergency' THEN 0 WHEN 'high' THEN 1 ELSE 2 END`
+)
+
+func rankPriority(priority string) int {
+	switch priority {
+	case "emergency":
+		return 0
+	case "high":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// hold sets aside a notification no channel could take, so it is sent
+// when one recovers rather than dropped. A held emergency is also copied
+// to the user's alternate contacts.
+func (s *Service) hold(ctx context.Context, notification *models.Notification) {
+	// Uncancellable, or a notification caught by shutdown would be lost
+	_, err := s.db.Exec(`
+		UPDATE notifications SET status = $1, held_at = COALESCE(held_at, NOW()), updated_at = NOW()
+		WHERE id = $2
+	`, statusHeld, notification.ID)
+	if err != nil {
+		s.logger.Error("Failed to hold notification", "error", err, "notification_id", notification.ID)
+		return
+	}
+
+	notification.Status = statusHeld
+	metrics.NotificationHolds.WithLabelValues(notification.Priority, "held").Inc()
+	s.logger.Warn("Holding notification until a channel is available",
+		"notification_id", notification.ID, "user_id", notification.UserID, "priority", notification.Priority)
+
+	if notification.Priority == "emergency" {
+		s.escalate(ctx, notification)
+	}
+}
+
+// hasHeld reports whether the user has notifications held. Errors report
+// false, sending the notification rather than holding it.
+func (s *Service) hasHeld(ctx context.Context, userID string) bool {
+	var held bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM notifications WHERE user_id::text = $1 AND status = $2)
+	`, userID, statusHeld).Scan(&held)
+	if err != nil {
+		s.logger.Warn("Failed to check for held notifications", "error", err, "user_id", userID)
+		return false
+	}
+	return held
+}
+
+// escalate sends a copy of a held emergency to each of the user's active
+// alternate contacts. Copies are never escalated further.
+func (s *Service) escalate(ctx context.Context, notification *models.Notification) {
+	if _, escalated := notification.Metadata["escalated_from"]; escalated {
+		return
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.id
+		FROM users u
+		JOIN users c ON c.id = ANY(u.alternate_contact_ids)
+		WHERE u.id = $1 AND c.tenant_id = u.tenant_id AND c.is_active AND c.erased_at IS NULL
+	`, notification.UserID)
+	if err != nil {
+		s.logger.Error("Failed to get alternate contacts", "error", err, "user_id", notification.UserID)
+		return
+	}
+	var contacts []uuid.UUID
+	for rows.Next() {
+		var contact uuid.UUID
+		if err := rows.Scan(&contact); err != nil {
+			s.logger.Error("Failed to scan alternate contact", "error", err)
+			continue
+		}
+		contacts = append(contacts, contact)
+	}
+	rows.Close()
+
+	for _, contact := range contacts {
+		metadata := make(map[string]interface{}, len(notification.Metadata)+2)
+		for key, value := range notification.Metadata {
+			metadata[key] = value
+		}
+		metadata["escalated_from"] = notification.ID.String()
+		metadata["on_behalf_of"] = notification.UserID.String()
+
+		escalation := &models.Notification{
+			ID:       uuid.New(),
+			TenantID: notification.TenantID,
+			UserID:   contact,
+			Type:     notification.Type,
+			Title:    notification.Title,
+			Message:  notification.Message,
+			Priority: notification.Priority,
+			Metadata: metadata,
+		}
+		if err := s.storeNotification(ctx, escalation); err != nil {
+			s.logger.Error("Failed to store escalated notification", "error", err,
+				"notification_id", notification.ID, "contact_id", contact)
+			continue
+		}
+		metrics.NotificationEscalations.Inc()
+		s.logger.Info("Emergency notification escalated to alternate contact",
+			"notification_id", notification.ID, "escalation_id", escalation.ID, "contact_id", contact)
+
+		if !s.processEmergencyNotification(ctx, escalation) {
+			s.hold(ctx, escalation)
+		}
+	}
+}
+
+// releaseHeldOnRecovery releases held notifications whenever a channel
+// recovers, and every minute while any channel is available, which covers
+// channels that recover without being marked degraded and users whose own
+// channels were the ones down.
+func (s *Service) releaseHeldOnRecovery(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.recovered:
+		case <-ticker.C:
+		}
+
+		s.expireHeld(ctx)
+		if s.anyChannelAvailable() {
+			s.releaseHeld(ctx)
+		}
+	}
+}
+
+func (s *Service) anyChannelAvailable() bool {
+	for _, channel := range s.channels {
+		if channel.IsAvailable() {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseHeld sends held notifications, most urgent and then oldest first.
+// A user's notifications stay in order: once one of them is still held,
+// the rest of theirs in the batch are held back with it.
+func (s *Service) releaseHeld(ctx context.Context) {
+	for ctx.Err() == nil {
+		batch, err := s.claimHeld(ctx)
+		if err != nil {
+			s.logger.Error("Failed to claim held notifications", "error", err)
+			return
+		}
+
+		released := 0
+		blocked := make(map[uuid.UUID]bool)
+		for _, notification := range batch {
+			if !blocked[notification.UserID] && s.sendNow(ctx, notification) {
+				released++
+				metrics.NotificationHolds.WithLabelValues(notification.Priority, "released").Inc()
+				continue
+			}
+			blocked[notification.UserID] = true
+			s.updateNotificationStatus(notification.ID.String(), statusHeld)
+		}
+
+		if released > 0 {
+			s.logger.Info("Released held notifications", "released", released, "still_held", len(batch)-released)
+		}
+		// Stop at the end of the queue, or when nothing more can be sent
+		if len(batch) < heldReleaseBatch || released == 0 {
+			return
+		}
+	}
+}
+
+// claimHeld marks the next batch of held notifications as processing, so
+// concurrent releases can't send them twice, and returns them in release
+// order.
+func (s *Service) claimHeld(ctx context.Context) ([]*models.Notification, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE notifications SET status = 'processing', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM notifications
+			WHERE status = $1
+			ORDER BY `+priorityRank+`, created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, COALESCE(tenant_id, ''), type, title, message, priority, channels, metadata,
+			resend_of, created_at
+	`, statusHeld, heldReleaseBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []*models.Notification
+	for rows.Next() {
+		var notification models.Notification
+		var channelsJSON, metadataJSON string
+		err := rows.Scan(
+			&notification.ID,
+			&notification.UserID,
+			&notification.TenantID,
+			&notification.Type,
+			&notification.Title,
+			&notification.Message,
+			&notification.Priority,
+			&channelsJSON,
+			&metadataJSON,
+			&notification.ResendOf,
+			&notification.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(channelsJSON), &notification.Channels)
+		json.Unmarshal([]byte(metadataJSON), &notification.Metadata)
+		batch = append(batch, &notification)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(batch, func(i, j int) bool {
+		if ri, rj := rankPriority(batch[i].Priority), rankPriority(batch[j].Priority); ri != rj {
+			return ri < rj
+		}
+		return batch[i].CreatedAt.Before(batch[j].CreatedAt)
+	})
+	return batch, nil
+}
+
+// expireHeld marks notifications held longer than notifications.hold.max_age
+// undeliverable, logging each so none disappears unnoticed.
+func (s *Service) expireHeld(ctx context.Context) {
+	maxAge := s.config.Notifications.Hold.MaxAge
+	if maxAge <= 0 {
+		return
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE notifications SET status = $1, updated_at = NOW()
+		WHERE status = $2 AND held_at < $3
+		RETURNING id, user_id, priority, held_at
+	`, statusUndeliverable, statusHeld, time.Now().Add(-maxAge))
+	if err != nil {
+		s.logger.Error("Failed to expire held notifications", "error", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, userID uuid.UUID
+		var priority string
+		var heldAt time.Time
+		if err := rows.Scan(&id, &userID, &priority, &heldAt); err != nil {
+			s.logger.Error("Failed to scan expired notification", "error", err)
+			continue
+		}
+		metrics.NotificationHolds.WithLabelValues(priority, "undeliverable").Inc()
+		s.logger.Error("Held notification expired undelivered",
+			"notification_id", id, "user_id", userID, "priority", priority, "held_at", heldAt)
+	}
+}
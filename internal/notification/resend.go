@@ -0,0 +1,39 @@
+package notification
+
+import (
+	"context"
+
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+)
+
+// processResend delivers a resend requested by support. A resend naming
+// channels goes to exactly those; otherwise it is routed like the original
+// would be, except that it never waits for a digest, and is held if no
+// channel can take it.
+func (s *Service) processResend(ctx context.Context, notification *models.Notification) {
+	if len(notification.Channels) == 0 {
+		if !s.sendNow(ctx, notification) {
+			s.hold(ctx, notification)
+		}
+		return
+	}
+
+	for _, channel := range notification.Channels {
+		svc, exists := s.channels[channel]
+		if !exists || !svc.IsAvailable() {
+			s.logger.Warn("Resend channel unavailable", "channel", channel, "notification_id", notification.ID)
+			s.updateDeliveryStatus(notification.ID, channel, "failed")
+			continue
+		}
+
+		if err := svc.Send(ctx, notification); err != nil {
+			s.logger.Error("Failed to resend notification",
+				"channel", channel, "error", err, "notification_id", notification.ID)
+			s.updateDeliveryStatus(notification.ID, channel, "failed")
+		} else {
+			s.updateDeliveryStatus(notification.ID, channel, "delivered")
+		}
+	}
+
+	s.logger.Info("Notification resent", "notification_id", notification.ID, "resend_of", notification.ResendOf)
+}
@@ -0,0 +1,51 @@
+package notification
+
+import (
+	"context"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/retry"
+)
+
+// retryingChannel retries transient provider failures within a single send,
+// before the notification falls back to the slower failed-delivery retry.
+type retryingChannel struct {
+	name    string
+	channel NotificationChannel
+	policy  retry.Policy
+}
+
+func withRetry(name string, channel NotificationChannel, cfg *config.Config, log logger.Logger) NotificationChannel {
+	settings := cfg.Notifications.Retry
+	return &retryingChannel{
+		name:    name,
+		channel: channel,
+		policy: retry.Policy{
+			MaxAttempts:    settings.MaxAttempts,
+			InitialBackoff: settings.InitialBackoff,
+			MaxBackoff:     settings.MaxBackoff,
+			Jitter:         settings.Jitter,
+			OnRetry: func(attempt int, err error, wait time.Duration) {
+				log.Warn("Retrying notification send",
+					"channel", name,
+					"attempt", attempt,
+					"wait", wait,
+					"error", err,
+				)
+			},
+		},
+	}
+}
+
+func (c *retryingChannel) Send(ctx context.Context, notification *models.Notification) error {
+	return retry.Do(ctx, c.policy, func(ctx context.Context) error {
+		return c.channel.Send(ctx, notification)
+	})
+}
+
+func (c *retryingChannel) IsAvailable() bool {
+	return c.channel.IsAvailable()
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 	
 	"github.com/bhanukaranwal/urbanzen/internal/config"
 	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/notifyprefs"
 	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/events"
 	"github.com/bhanukaranwal/urbanzen/pkg/kafka"
 	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
 	"github.com/bhanukaranwal/urbanzen/pkg/notification/email"
 	"github.com/bhanukaranwal/urbanzen/pkg/notification/sms"
 	"github.com/bhanukaranwal/urbanzen/pkg/notification/push"
@@ -20,12 +24,17 @@ type Service struct {
 	db          *database.PostgresDB
 	redis       *database.RedisDB
 	consumer    *kafka.Consumer
+	bus         *events.Bus
 	config      *config.Config
 	logger      logger.Logger
 	emailSvc    *email.Service
 	smsSvc      *sms.Service
 	pushSvc     *push.Service
 	channels    map[string]NotificationChannel
+	recovered   chan struct{}
+	
+	// Background work started by Start
+	workers     sync.WaitGroup
 }
 
 type NotificationChannel interface {
@@ -33,105 +42,147 @@ type NotificationChannel interface {
 	IsAvailable() bool
 }
 
-func NewService(db *database.PostgresDB, redis *database.RedisDB, 
+func NewService(db *database.PostgresDB, redis *database.RedisDB, producer *kafka.Producer,
 	consumer *kafka.Consumer, cfg *config.Config, log logger.Logger) *Service {
 	
 	emailSvc := email.NewService(cfg.ExternalAPIs.EmailService, log)
 	smsSvc := sms.NewService(cfg.ExternalAPIs.SMSGateway, log)
 	pushSvc := push.NewService(cfg.Notifications.PushNotifications, log)
 	
-	channels := map[string]NotificationChannel{
+	providers := map[string]NotificationChannel{
 		"email": emailSvc,
 		"sms":   smsSvc,
 		"push":  pushSvc,
 	}
 	
+	recovered := make(chan struct{}, 1)
+	channels := make(map[string]NotificationChannel, len(providers))
+	for name, provider := range providers {
+		m := monitor(name, provider, withRetry(name, provider, cfg, log), cfg, log)
+		m.recovered = recovered
+		channels[name] = m
+	}
+	
 	return &Service{
 		db:       db,
 		redis:    redis,
 		consumer: consumer,
+		bus:      events.New(producer, cfg, log),
 		config:   cfg,
 		logger:   log,
 		emailSvc: emailSvc,
 		smsSvc:   smsSvc,
 		pushSvc:  pushSvc,
 		channels: channels,
+		recovered: recovered,
 	}
 }
 
 func (s *Service) Start(ctx context.Context) error {
 	// Start consuming notification requests
-	go s.consumeNotifications(ctx)
+	s.run(ctx, s.consumeNotifications)
 	
 	// Start notification scheduler
-	go s.startScheduler(ctx)
+	s.run(ctx, s.startScheduler)
 	
 	// Start delivery status processor
-	go s.processDeliveryStatus(ctx)
+	s.run(ctx, s.processDeliveryStatus)
+	
+	// Keep provider health current for channel selection
+	s.run(ctx, s.monitorChannels)
+	
+	// Send held notifications once a channel recovers
+	s.run(ctx, s.releaseHeldOnRecovery)
 	
 	s.logger.Info("Notification service started")
 	
+	// Sends in progress finish before Start returns
 	<-ctx.Done()
+	s.workers.Wait()
+	
+	s.logger.Info("Notification service stopped")
 	return nil
 }
 
+// run starts background work that Start waits for before returning.
+func (s *Service) run(ctx context.Context, work func(context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		work(ctx)
+	}()
+}
+
 func (s *Service) consumeNotifications(ctx context.Context) {
-	topics := []string{"user-notifications", "system-alerts", "emergency-alerts"}
-	
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			messages, err := s.consumer.ConsumeMessages(topics, time.Second*5)
-			if err != nil {
-				s.logger.Error("Failed to consume messages", "error", err)
-				continue
-			}
-			
-			for _, msg := range messages {
-				s.processNotificationMessage(ctx, msg)
-			}
-		}
-	}
+	events.Subscribe(ctx, s.bus, s.consumer, s.processNotificationMessage,
+		events.Notifications, events.SystemAlerts, events.EmergencyAlerts)
 }
 
-func (s *Service) processNotificationMessage(ctx context.Context, msg *kafka.Message) {
-	var notification models.Notification
-	if err := json.Unmarshal(msg.Value, &notification); err != nil {
-		s.logger.Error("Failed to unmarshal notification", "error", err)
-		return
-	}
+func (s *Service) processNotificationMessage(ctx context.Context, topic string, notification models.Notification) error {
+	metrics.ObserveLag("notification-service", topic, notification.CreatedAt)
 	
 	// Validate notification
 	if err := s.validateNotification(&notification); err != nil {
-		s.logger.Error("Invalid notification", "error", err)
-		return
+		return kafka.Poison(fmt.Errorf("invalid notification: %w", err))
+	}
+	
+	if s.isDuplicate(ctx, &notification) {
+		s.logger.Debug("Dropping duplicate notification",
+			"user_id", notification.UserID, "type", notification.Type, "dedup_key", notification.DedupKey)
+		return nil
 	}
 	
 	// Store notification
-	if err := s.storeNotification(&notification); err != nil {
-		s.logger.Error("Failed to store notification", "error", err)
+	if err := s.storeNotification(ctx, &notification); err != nil {
+		return fmt.Errorf("storing notification: %w", err)
+	}
+	
+	s.deliver(ctx, &notification)
+	return nil
+}
+
+// deliver routes a new notification by priority, holding it if no channel
+// could take it. Apart from emergencies, it also waits behind any of the
+// user's notifications already held, so they arrive in order.
+func (s *Service) deliver(ctx context.Context, notification *models.Notification) {
+	if notification.Priority != "emergency" && s.hasHeld(ctx, notification.UserID.String()) {
+		s.hold(ctx, notification)
 		return
 	}
 	
-	// Process notification based on priority and type
+	var reached bool
+	switch notification.Priority {
+	case "emergency", "high":
+		reached = s.sendNow(ctx, notification)
+	default:
+		reached = s.processRegularNotification(ctx, notification)
+	}
+	if !reached {
+		s.hold(ctx, notification)
+	}
+}
+
+// sendNow routes a notification by priority without waiting for a digest,
+// reporting whether a channel was available to take it.
+func (s *Service) sendNow(ctx context.Context, notification *models.Notification) bool {
 	switch notification.Priority {
 	case "emergency":
-		s.processEmergencyNotification(ctx, &notification)
+		return s.processEmergencyNotification(ctx, notification)
 	case "high":
-		s.processHighPriorityNotification(ctx, &notification)
+		return s.processHighPriorityNotification(ctx, notification)
 	default:
-		s.processRegularNotification(ctx, &notification)
+		return s.sendToPreferredChannels(ctx, notification)
 	}
 }
 
-func (s *Service) processEmergencyNotification(ctx context.Context, notification *models.Notification) {
+func (s *Service) processEmergencyNotification(ctx context.Context, notification *models.Notification) bool {
 	// Emergency notifications are sent immediately via all available channels
 	channels := []string{"push", "sms", "email"}
 	
+	reached := false
 	for _, channel := range channels {
 		if svc, exists := s.channels[channel]; exists && svc.IsAvailable() {
+			reached = true
 			go func(ch string, svc NotificationChannel) {
 				if err := svc.Send(ctx, notification); err != nil {
 					s.logger.Error("Failed to send emergency notification", 
@@ -142,49 +193,71 @@ func (s *Service) processEmergencyNotification(ctx context.Context, notification
 			}(channel, svc)
 		}
 	}
+	return reached
 }
 
-func (s *Service) processHighPriorityNotification(ctx context.Context, notification *models.Notification) {
+func (s *Service) processHighPriorityNotification(ctx context.Context, notification *models.Notification) bool {
 	// High priority notifications are sent via push and SMS first
 	preferredChannels := []string{"push", "sms"}
 	
+	reached := false
 	for _, channel := range preferredChannels {
 		if svc, exists := s.channels[channel]; exists && svc.IsAvailable() {
+			reached = true
 			if err := svc.Send(ctx, notification); err != nil {
 				s.logger.Error("Failed to send high priority notification", 
 					"channel", channel, "error", err)
 				continue
 			}
 			s.updateDeliveryStatus(notification.ID, channel, "delivered")
-			return // Send via one channel successfully
+			return true // Send via one channel successfully
 		}
 	}
 	
 	// Fallback to email if other channels fail
 	if emailSvc, exists := s.channels["email"]; exists && emailSvc.IsAvailable() {
+		reached = true
 		if err := emailSvc.Send(ctx, notification); err != nil {
 			s.logger.Error("Failed to send notification via email fallback", "error", err)
 		} else {
 			s.updateDeliveryStatus(notification.ID, "email", "delivered")
 		}
 	}
+	return reached
+}
+
+func (s *Service) processRegularNotification(ctx context.Context, notification *models.Notification) bool {
+	// Users who prefer digests get regular notifications batched
+	if s.queueForDigest(ctx, notification) {
+		return true
+	}
+	
+	return s.sendToPreferredChannels(ctx, notification)
 }
 
-func (s *Service) processRegularNotification(ctx context.Context, notification *models.Notification) {
+// sendToPreferredChannels reports false only if the user wants at least
+// one channel and none of those is available.
+func (s *Service) sendToPreferredChannels(ctx context.Context, notification *models.Notification) bool {
 	// Regular notifications follow user preferences
-	userPrefs, err := s.getUserNotificationPreferences(notification.UserID)
+	userPrefs, err := s.getUserNotificationPreferences(ctx, notification.UserID)
 	if err != nil {
 		s.logger.Error("Failed to get user preferences", "error", err, "user_id", notification.UserID)
 		// Default to email
 		userPrefs = map[string]bool{"email": true}
 	}
 	
+	wanted, reached := false, false
 	for channel, enabled := range userPrefs {
 		if !enabled {
 			continue
 		}
 		
-		if svc, exists := s.channels[channel]; exists && svc.IsAvailable() {
+		svc, exists := s.channels[channel]
+		if exists {
+			wanted = true
+		}
+		if exists && svc.IsAvailable() {
+			reached = true
 			if err := svc.Send(ctx, notification); err != nil {
 				s.logger.Error("Failed to send notification", 
 					"channel", channel, "error", err)
@@ -194,19 +267,21 @@ func (s *Service) processRegularNotification(ctx context.Context, notification *
 			}
 		}
 	}
+	return reached || !wanted
 }
 
-func (s *Service) storeNotification(notification *models.Notification) error {
+func (s *Service) storeNotification(ctx context.Context, notification *models.Notification) error {
 	query := `
 		INSERT INTO notifications (id, user_id, type, title, message, priority, channels, 
-			metadata, scheduled_at, created_at, status)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			metadata, scheduled_at, created_at, status, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11,
+			COALESCE(NULLIF($12, ''), (SELECT tenant_id FROM users WHERE id = $2)))
 	`
 	
 	channelsJSON, _ := json.Marshal(notification.Channels)
 	metadataJSON, _ := json.Marshal(notification.Metadata)
 	
-	_, err := s.db.Exec(query,
+	_, err := s.db.ExecContext(ctx, query,
 		notification.ID,
 		notification.UserID,
 		notification.Type,
@@ -218,19 +293,25 @@ func (s *Service) storeNotification(notification *models.Notification) error {
 		notification.ScheduledAt,
 		time.Now(),
 		"pending",
+		notification.TenantID,
 	)
 	
 	return err
 }
 
-func (s *Service) getUserNotificationPreferences(userID string) (map[string]bool, error) {
+func (s *Service) getUserNotificationPreferences(ctx context.Context, userID string) (map[string]bool, error) {
 	// Try to get from cache first
-	cacheKey := fmt.Sprintf("user_prefs:%s", userID)
-	if cached, err := s.redis.Get(cacheKey); err == nil {
+	cacheKey := notifyprefs.CacheKey(userID)
+	// Any cache failure falls through to the database; if that fails too
+	// the caller defaults to email, so preferences fail open
+	cached, err := s.redis.Get(cacheKey)
+	if err == nil {
 		var prefs map[string]bool
 		if json.Unmarshal([]byte(cached), &prefs) == nil {
 			return prefs, nil
 		}
+	} else if !database.IsMiss(err) {
+		metrics.RedisFallbacks.WithLabelValues("notification_preferences").Inc()
 	}
 	
 	// Get from database
@@ -241,7 +322,7 @@ func (s *Service) getUserNotificationPreferences(userID string) (map[string]bool
 	`
 	
 	var prefsJSON string
-	err := s.db.QueryRow(query, userID).Scan(&prefsJSON)
+	err = s.db.QueryRowContext(ctx, query, userID).Scan(&prefsJSON)
 	if err != nil {
 		return nil, err
 	}
@@ -251,13 +332,15 @@ func (s *Service) getUserNotificationPreferences(userID string) (map[string]bool
 		return nil, err
 	}
 	
-	// Cache for 1 hour
 	prefsBytes, _ := json.Marshal(prefs)
-	s.redis.SetEX(cacheKey, string(prefsBytes), time.Hour)
+	s.redis.SetEX(cacheKey, string(prefsBytes), notifyprefs.CacheTTL)
 	
 	return prefs, nil
 }
 
+// updateDeliveryStatus records a send outcome. It deliberately ignores
+// cancellation: a delivery that isn't recorded during shutdown would be
+// sent again.
 func (s *Service) updateDeliveryStatus(notificationID, channel, status string) {
 	query := `
 		INSERT INTO notification_delivery_status (notification_id, channel, status, attempted_at)
@@ -266,6 +349,12 @@ func (s *Service) updateDeliveryStatus(notificationID, channel, status string) {
 		DO UPDATE SET status = $2, attempted_at = $4
 	`
 	
+	result := metrics.ResultSuccess
+	if status == "failed" {
+		result = metrics.ResultFailure
+	}
+	metrics.NotificationDeliveries.WithLabelValues(channel, result).Inc()
+	
 	_, err := s.db.Exec(query, notificationID, channel, status, time.Now())
 	if err != nil {
 		s.logger.Error("Failed to update delivery status", "error", err)
@@ -282,20 +371,21 @@ func (s *Service) startScheduler(ctx context.Context) {
 			return
 		case <-ticker.C:
 			s.processScheduledNotifications(ctx)
+			s.sendDueDigests(ctx)
 		}
 	}
 }
 
 func (s *Service) processScheduledNotifications(ctx context.Context) {
 	query := `
-		SELECT id, user_id, type, title, message, priority, channels, metadata
+		SELECT id, user_id, type, title, message, priority, channels, metadata, resend_of
 		FROM notifications
 		WHERE scheduled_at <= NOW() AND status = 'pending'
-		ORDER BY priority DESC, scheduled_at ASC
+		ORDER BY `+priorityRank+`, scheduled_at ASC
 		LIMIT 100
 	`
 	
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		s.logger.Error("Failed to query scheduled notifications", "error", err)
 		return
@@ -315,6 +405,7 @@ func (s *Service) processScheduledNotifications(ctx context.Context) {
 			&notification.Priority,
 			&channelsJSON,
 			&metadataJSON,
+			&notification.ResendOf,
 		)
 		
 		if err != nil {
@@ -326,17 +417,17 @@ func (s *Service) processScheduledNotifications(ctx context.Context) {
 		json.Unmarshal([]byte(metadataJSON), &notification.Metadata)
 		
 		// Process the notification
-		switch notification.Priority {
-		case "emergency":
-			s.processEmergencyNotification(ctx, &notification)
-		case "high":
-			s.processHighPriorityNotification(ctx, &notification)
-		default:
-			s.processRegularNotification(ctx, &notification)
+		if notification.ResendOf != nil {
+			s.processResend(ctx, &notification)
+		} else {
+			s.deliver(ctx, &notification)
 		}
 		
-		// Update status to processing
-		s.updateNotificationStatus(notification.ID, "processing")
+		// Update status to processing, unless it was queued for a digest
+		// or held
+		if notification.Status != statusQueuedDigest && notification.Status != statusHeld {
+			s.updateNotificationStatus(notification.ID, "processing")
+		}
 	}
 }
 
@@ -391,11 +482,11 @@ func (s *Service) retryFailedNotifications(ctx context.Context) {
 		WHERE nds.status = 'failed' 
 		AND nds.attempted_at < NOW() - INTERVAL '5 minutes'
 		AND n.created_at > NOW() - INTERVAL '24 hours'
-		ORDER BY n.priority DESC, n.created_at ASC
+		ORDER BY `+priorityRank+`, n.created_at ASC
 		LIMIT 50
 	`
 	
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		s.logger.Error("Failed to query failed notifications", "error", err)
 		return
@@ -0,0 +1,23 @@
+// Package notifyprefs holds what the notification service and the gateway
+// share about users' channel preferences: which channels there are and
+// where the notification service caches each user's choices.
+package notifyprefs
+
+import (
+	"fmt"
+	"time"
+)
+
+// CacheTTL is how long the notification service caches a user's
+// preferences, and so how long a change can take to apply if its cache
+// entry can't be deleted.
+const CacheTTL = time.Hour
+
+// Channels are the channels a user can turn on or off.
+var Channels = map[string]bool{"email": true, "sms": true, "push": true}
+
+// CacheKey is where a user's preferences are cached. Anything that changes
+// them must delete it.
+func CacheKey(userID string) string {
+	return fmt.Sprintf("user_prefs:%s", userID)
+}
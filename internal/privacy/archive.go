@@ -0,0 +1,60 @@
+package privacy
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const archiveReadme = `This archive holds the personal data UrbanZen stores about you, as of %s.
+
+profile.json        your account details
+notifications.json  notifications sent to you
+bills.json          your utility bills
+payments.json       payments you made against them
+disputes.json       bill disputes you raised
+devices.json        meters and devices assigned to you
+budgets.json        consumption budgets you set
+logins.json         sign-in history, including IP addresses
+`
+
+// WriteArchive writes the export as a zip of JSON files, one per kind of
+// record, with a README describing them.
+func WriteArchive(w io.Writer, export *Export) error {
+	archive := zip.NewWriter(w)
+
+	readme, err := archive.Create("README.txt")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(readme, archiveReadme, export.GeneratedAt.Format("2006-01-02 15:04 MST")); err != nil {
+		return err
+	}
+
+	for _, file := range []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"notifications.json", export.Notifications},
+		{"bills.json", export.Bills},
+		{"payments.json", export.Payments},
+		{"disputes.json", export.Disputes},
+		{"devices.json", export.Devices},
+		{"budgets.json", export.Budgets},
+		{"logins.json", export.Logins},
+	} {
+		out, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+
+	return archive.Close()
+}
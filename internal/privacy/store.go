@@ -0,0 +1,425 @@
+// Package privacy answers data subject requests: exporting everything held
+// about a user, and erasing their personal data while keeping the billing
+// records a utility must retain.
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/models"
+	"github.com/bhanukaranwal/urbanzen/internal/subscription"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+var (
+	// ErrErased is returned when erasing a user who already has been.
+	ErrErased = errors.New("user has already been erased")
+
+	// ErrProtected is returned when erasing a super admin. They must be
+	// demoted first so a tenant can't lose its last platform operator by
+	// accident.
+	ErrProtected = errors.New("super admin accounts can't be erased")
+)
+
+// erasedReason replaces the free text citizens wrote in bill disputes.
+const erasedReason = "[erased]"
+
+// Export is everything held about one user, as of GeneratedAt.
+type Export struct {
+	GeneratedAt   time.Time                    `json:"generated_at"`
+	Profile       *models.User                 `json:"profile"`
+	Notifications []models.Notification        `json:"notifications"`
+	Bills         []models.Bill                `json:"bills"`
+	Payments      []models.Payment             `json:"payments"`
+	Disputes      []models.BillDispute         `json:"disputes"`
+	Devices       []models.DeviceAssignment    `json:"devices"`
+	Budgets       []models.ConsumptionBudget   `json:"budgets"`
+	Subscriptions []*subscription.Subscription `json:"subscriptions"`
+	Logins        []Login                      `json:"logins"`
+	Identities    []Identity                   `json:"identities"`
+}
+
+// Login is one sign-in attempt from the user's login history.
+type Login struct {
+	IPAddress  string    `json:"ip_address,omitempty"`
+	UserAgent  string    `json:"user_agent"`
+	Country    string    `json:"country,omitempty"`
+	City       string    `json:"city,omitempty"`
+	Success    bool      `json:"success"`
+	Suspicious bool      `json:"suspicious"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Identity is an external account the user signs in with.
+type Identity struct {
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// Erasure counts what erasing a user removed or redacted, by kind.
+type Erasure struct {
+	UserID            string `json:"user_id"`
+	Notifications     int64  `json:"notifications"`
+	Logins            int64  `json:"logins"`
+	AccessTokens      int64  `json:"access_tokens"`
+	DeviceAssignments int64  `json:"device_assignments"`
+	Budgets           int64  `json:"budgets"`
+	Subscriptions     int64  `json:"subscriptions"`
+	Identities        int64  `json:"identities"`
+	DisputesRedacted  int64  `json:"disputes_redacted"`
+}
+
+type Store struct {
+	db *database.PostgresDB
+}
+
+func NewStore(db *database.PostgresDB) *Store {
+	return &Store{db: db}
+}
+
+// Export gathers a user's data. It returns sql.ErrNoRows if the user isn't
+// in the tenant.
+func (s *Store) Export(ctx context.Context, tenantID, userID string) (*Export, error) {
+	profile, err := s.profile(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{GeneratedAt: time.Now().UTC(), Profile: profile}
+	for _, load := range []func(context.Context, string, *Export) error{
+		s.notifications,
+		s.bills,
+		s.payments,
+		s.disputes,
+		s.devices,
+		s.budgets,
+		s.subscriptions,
+		s.logins,
+		s.identities,
+	} {
+		if err := load(ctx, userID, export); err != nil {
+			return nil, err
+		}
+	}
+	return export, nil
+}
+
+func (s *Store) profile(ctx context.Context, tenantID, userID string) (*models.User, error) {
+	var user models.User
+	var phone, address, ward sql.NullString
+	var prefs []byte
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, tenant_id, username, email, first_name, last_name, role, phone, address, ward,
+			COALESCE(region, ''), is_active, email_verified, notification_preferences, notification_digest,
+			created_at, updated_at, erased_at
+		FROM users
+		WHERE id::text = $1 AND tenant_id = $2
+	`, userID, tenantID).Scan(
+		&user.ID, &user.TenantID, &user.Username, &user.Email, &user.FirstName, &user.LastName,
+		&user.Role, &phone, &address, &ward, &user.Region, &user.IsActive, &user.EmailVerified, &prefs,
+		&user.NotificationDigest, &user.CreatedAt, &user.UpdatedAt, &user.ErasedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Phone, user.Address, user.Ward = phone.String, address.String, ward.String
+	json.Unmarshal(prefs, &user.NotificationPrefs)
+	return &user, nil
+}
+
+func (s *Store) notifications(ctx context.Context, userID string, export *Export) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, user_id, type, title, message, priority, channels, scheduled_at,
+			resend_of, status, metadata, created_at, updated_at
+		FROM notifications
+		WHERE user_id::text = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	export.Notifications = []models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		var channels, metadata []byte
+		if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &n.Type, &n.Title, &n.Message, &n.Priority,
+			&channels, &n.ScheduledAt, &n.ResendOf, &n.Status, &metadata, &n.CreatedAt, &n.UpdatedAt); err != nil {
+			return err
+		}
+		json.Unmarshal(channels, &n.Channels)
+		json.Unmarshal(metadata, &n.Metadata)
+		export.Notifications = append(export.Notifications, n)
+	}
+	return rows.Err()
+}
+
+func (s *Store) bills(ctx context.Context, userID string, export *Export) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, user_id, COALESCE(device_id, ''), utility_type, period_start, period_end,
+			consumption, amount, amount_paid, late_fees, due_date, status, created_at, updated_at,
+			estimated_consumption, COALESCE(estimation_method, '')
+		FROM bills
+		WHERE user_id::text = $1
+		ORDER BY period_start
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	export.Bills = []models.Bill{}
+	for rows.Next() {
+		var b models.Bill
+		if err := rows.Scan(&b.ID, &b.TenantID, &b.UserID, &b.DeviceID, &b.UtilityType, &b.PeriodStart,
+			&b.PeriodEnd, &b.Consumption, &b.Amount, &b.AmountPaid, &b.LateFees, &b.DueDate, &b.Status,
+			&b.CreatedAt, &b.UpdatedAt, &b.EstimatedConsumption, &b.EstimationMethod); err != nil {
+			return err
+		}
+		export.Bills = append(export.Bills, b)
+	}
+	return rows.Err()
+}
+
+func (s *Store) payments(ctx context.Context, userID string, export *Export) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, bill_id, user_id, amount, payment_method, transaction_id, created_at
+		FROM payments
+		WHERE user_id::text = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	export.Payments = []models.Payment{}
+	for rows.Next() {
+		var p models.Payment
+		if err := rows.Scan(&p.ID, &p.BillID, &p.UserID, &p.Amount, &p.PaymentMethod,
+			&p.TransactionID, &p.CreatedAt); err != nil {
+			return err
+		}
+		export.Payments = append(export.Payments, p)
+	}
+	return rows.Err()
+}
+
+func (s *Store) disputes(ctx context.Context, userID string, export *Export) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, bill_id, user_id, reason, status, COALESCE(resolution, ''),
+			resolved_at, created_at, updated_at
+		FROM bill_disputes
+		WHERE user_id::text = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	export.Disputes = []models.BillDispute{}
+	for rows.Next() {
+		var d models.BillDispute
+		if err := rows.Scan(&d.ID, &d.TenantID, &d.BillID, &d.UserID, &d.Reason, &d.Status,
+			&d.Resolution, &d.ResolvedAt, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return err
+		}
+		export.Disputes = append(export.Disputes, d)
+	}
+	return rows.Err()
+}
+
+func (s *Store) devices(ctx context.Context, userID string, export *Export) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT device_id, user_id, assigned_at
+		FROM device_assignments
+		WHERE user_id::text = $1
+		ORDER BY assigned_at
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	export.Devices = []models.DeviceAssignment{}
+	for rows.Next() {
+		var a models.DeviceAssignment
+		if err := rows.Scan(&a.DeviceID, &a.UserID, &a.AssignedAt); err != nil {
+			return err
+		}
+		export.Devices = append(export.Devices, a)
+	}
+	return rows.Err()
+}
+
+func (s *Store) budgets(ctx context.Context, userID string, export *Export) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, user_id, utility_type, monthly_limit, thresholds, created_at, updated_at
+		FROM consumption_budgets
+		WHERE user_id::text = $1
+		ORDER BY utility_type
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	export.Budgets = []models.ConsumptionBudget{}
+	for rows.Next() {
+		var b models.ConsumptionBudget
+		if err := rows.Scan(&b.ID, &b.TenantID, &b.UserID, &b.UtilityType, &b.MonthlyLimit,
+			pq.Array(&b.Thresholds), &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return err
+		}
+		export.Budgets = append(export.Budgets, b)
+	}
+	return rows.Err()
+}
+
+func (s *Store) subscriptions(ctx context.Context, userID string, export *Export) error {
+	subscriptions, err := subscription.NewStore(s.db).List(ctx, export.Profile.TenantID, userID)
+	if err != nil {
+		return err
+	}
+	export.Subscriptions = subscriptions
+	return nil
+}
+
+func (s *Store) logins(ctx context.Context, userID string, export *Export) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(host(ip_address), ''), user_agent, country, city, success, suspicious, created_at
+		FROM login_history
+		WHERE user_id::text = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	export.Logins = []Login{}
+	for rows.Next() {
+		var l Login
+		if err := rows.Scan(&l.IPAddress, &l.UserAgent, &l.Country, &l.City, &l.Success,
+			&l.Suspicious, &l.CreatedAt); err != nil {
+			return err
+		}
+		export.Logins = append(export.Logins, l)
+	}
+	return rows.Err()
+}
+
+func (s *Store) identities(ctx context.Context, userID string, export *Export) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT provider, subject, COALESCE(email, ''), created_at, last_login_at
+		FROM user_identities
+		WHERE user_id::text = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	export.Identities = []Identity{}
+	for rows.Next() {
+		var identity Identity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt,
+			&identity.LastLoginAt); err != nil {
+			return err
+		}
+		export.Identities = append(export.Identities, identity)
+	}
+	return rows.Err()
+}
+
+// Erase removes a user's personal data. The user row stays, blanked and
+// deactivated, because bills, payments and audit columns reference it;
+// those records are kept as they are. Notifications, login history, access
+// tokens, device assignments, budgets and linked sign-in identities are
+// deleted, and the free text of
+// the user's bill disputes is redacted. It returns sql.ErrNoRows if the
+// user isn't in the tenant.
+func (s *Store) Erase(ctx context.Context, tenantID, userID, actorID string) (*Erasure, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var role string
+	var erasedAt *time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT role, erased_at FROM users WHERE id::text = $1 AND tenant_id = $2 FOR UPDATE
+	`, userID, tenantID).Scan(&role, &erasedAt)
+	if err != nil {
+		return nil, err
+	}
+	if erasedAt != nil {
+		return nil, ErrErased
+	}
+	if role == "super_admin" {
+		return nil, ErrProtected
+	}
+
+	// The username and email stay unique, and the address can't receive mail
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET username = 'erased-' || id, email = 'erased-' || id || '@erased.invalid',
+			password_hash = '!', first_name = '', last_name = '', phone = NULL, address = NULL,
+			ward = NULL, is_active = false, email_verified = false, notification_preferences = '{}',
+			failed_login_attempts = 0, locked_until = NULL, alternate_contact_ids = '{}',
+			erased_at = NOW(), erased_by = NULLIF($2, '')::uuid
+		WHERE id::text = $1
+	`, userID, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nobody's emergencies should go to an erased user
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET alternate_contact_ids = array_remove(alternate_contact_ids, $1::uuid)
+		WHERE $1::uuid = ANY(alternate_contact_ids)
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	erasure := &Erasure{UserID: userID}
+	for _, step := range []struct {
+		query string
+		count *int64
+	}{
+		{`DELETE FROM notifications WHERE user_id::text = $1`, &erasure.Notifications},
+		{`DELETE FROM login_history WHERE user_id::text = $1`, &erasure.Logins},
+		{`DELETE FROM personal_access_tokens WHERE user_id::text = $1`, &erasure.AccessTokens},
+		{`DELETE FROM device_assignments WHERE user_id::text = $1`, &erasure.DeviceAssignments},
+		{`DELETE FROM consumption_budgets WHERE user_id::text = $1`, &erasure.Budgets},
+		{`DELETE FROM metric_subscriptions WHERE user_id::text = $1`, &erasure.Subscriptions},
+		{`DELETE FROM user_identities WHERE user_id::text = $1`, &erasure.Identities},
+		{`UPDATE bill_disputes SET reason = '` + erasedReason + `' WHERE user_id::text = $1`, &erasure.DisputesRedacted},
+	} {
+		result, err := tx.ExecContext(ctx, step.query, userID)
+		if err != nil {
+			return nil, err
+		}
+		*step.count, _ = result.RowsAffected()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return erasure, nil
+}
@@ -0,0 +1,227 @@
+// Package residency keeps data within the region (jurisdiction) it belongs
+// to. Devices, their telemetry and users are tagged with a region, and
+// callers only reach records in their own region and those an admin
+// granted them. It complements tenancy: a tenant may span regions, and a
+// super admin acting on another tenant is still held to their regions.
+package residency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+// AllRegions, granted to a user, lets them reach every region.
+const AllRegions = "*"
+
+// ErrUnknownRegion is returned for a region that isn't configured.
+var ErrUnknownRegion = errors.New("unknown region")
+
+type Store struct {
+	db            *database.PostgresDB
+	regions       map[string]bool
+	defaultRegion string
+	logger        logger.Logger
+}
+
+func NewStore(db *database.PostgresDB, cfg *config.Config, log logger.Logger) *Store {
+	regions := make(map[string]bool, len(cfg.Residency.Regions))
+	for _, region := range cfg.Residency.Regions {
+		regions[region] = true
+	}
+	return &Store{
+		db:            db,
+		regions:       regions,
+		defaultRegion: cfg.Residency.DefaultRegion,
+		logger:        log,
+	}
+}
+
+// Enabled reports whether regions are configured and enforced.
+func (s *Store) Enabled() bool {
+	return len(s.regions) > 0
+}
+
+// Default is the region of records not tagged with one.
+func (s *Store) Default() string {
+	return s.defaultRegion
+}
+
+// Region returns the region to tag a new record with: region, or the
+// default if it is empty. It is "" when residency is off.
+func (s *Store) Region(region string) (string, error) {
+	if !s.Enabled() {
+		if region != "" {
+			return "", fmt.Errorf("%w %q: no regions are configured", ErrUnknownRegion, region)
+		}
+		return "", nil
+	}
+	if region == "" {
+		return s.defaultRegion, nil
+	}
+	if !s.regions[region] {
+		return "", fmt.Errorf("%w %q", ErrUnknownRegion, region)
+	}
+	return region, nil
+}
+
+// Grants checks the regions an admin grants a user are configured.
+func (s *Store) Grants(regions []string) error {
+	for _, region := range regions {
+		if region == AllRegions {
+			continue
+		}
+		if _, err := s.Region(region); err != nil || region == "" {
+			return fmt.Errorf("%w %q", ErrUnknownRegion, region)
+		}
+	}
+	return nil
+}
+
+// Scope returns the regions the caller may reach, or nil when they may
+// reach every region. Callers without a region of their own belong to the
+// default one. When the default is in scope so is "", which untagged
+// records carry, so queries compare COALESCE(region, '') against it.
+func (s *Store) Scope(c *gin.Context) []string {
+	if !s.Enabled() {
+		return nil
+	}
+
+	granted := c.GetStringSlice("regions")
+	if len(granted) == 0 {
+		granted = []string{""}
+	}
+	scope := make([]string, 0, len(granted)+1)
+	for _, region := range granted {
+		switch region {
+		case AllRegions:
+			return nil
+		case "", s.defaultRegion:
+			scope = append(scope, s.defaultRegion, "")
+		default:
+			scope = append(scope, region)
+		}
+	}
+	return scope
+}
+
+// Permits reports whether the caller may reach a record in region, where
+// "" is the default region.
+func (s *Store) Permits(c *gin.Context, region string) bool {
+	scope := s.Scope(c)
+	if scope == nil {
+		return true
+	}
+	for _, allowed := range scope {
+		if allowed == region {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceRegion returns the region a device's data is held in, "" for the
+// default, or sql.ErrNoRows if the tenant has no such device.
+func (s *Store) DeviceRegion(ctx context.Context, tenantID, deviceID string) (string, error) {
+	var region string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(region, '') FROM devices WHERE id = $1 AND tenant_id = $2
+	`, deviceID, tenantID).Scan(&region)
+	return region, err
+}
+
+// UserRegion returns the region a user's data is held in, "" for the
+// default, or sql.ErrNoRows if the tenant has no such user.
+func (s *Store) UserRegion(ctx context.Context, tenantID, userID string) (string, error) {
+	var region string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(region, '') FROM users WHERE id::text = $1 AND tenant_id = $2
+	`, userID, tenantID).Scan(&region)
+	return region, err
+}
+
+// SetUserRegions moves a user to region ("" for the default) and replaces
+// the other regions they may reach, returning sql.ErrNoRows if the tenant
+// has no such user. The change applies to tokens issued afterwards.
+func (s *Store) SetUserRegions(ctx context.Context, tenantID, userID, region string, allowed []string) error {
+	if allowed == nil {
+		allowed = []string{}
+	}
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE users SET region = NULLIF($3, ''), allowed_regions = $4, updated_at = NOW()
+		WHERE id::text = $1 AND tenant_id = $2
+	`, userID, tenantID, region, pq.Array(allowed))
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RequireDevice rejects requests for a device, named by the route
+// parameter param, held in a region the caller may not reach. Routes
+// without the parameter, and devices that don't exist, are left to the
+// handler.
+func (s *Store) RequireDevice(param string) gin.HandlerFunc {
+	return s.require(param, "device", s.DeviceRegion)
+}
+
+// RequireUser rejects requests for a user, named by the route parameter
+// param, held in a region the caller may not reach.
+func (s *Store) RequireUser(param string) gin.HandlerFunc {
+	return s.require(param, "user", s.UserRegion)
+}
+
+func (s *Store) require(param, kind string, lookup func(ctx context.Context, tenantID, id string) (string, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param(param)
+		if id == "" || s.Scope(c) == nil {
+			c.Next()
+			return
+		}
+
+		region, err := lookup(c.Request.Context(), c.GetString("tenant_id"), id)
+		if err == sql.ErrNoRows {
+			c.Next()
+			return
+		}
+		if err != nil {
+			s.logger.Error("Failed to check region", "error", err, kind+"_id", id)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check region"})
+			c.Abort()
+			return
+		}
+		if !s.Permits(c, region) {
+			s.Deny(c, kind, id, region)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Deny rejects a request for a record held in region and logs the attempt,
+// since cross-region access is a compliance event.
+func (s *Store) Deny(c *gin.Context, kind, id, region string) {
+	if region == "" {
+		region = s.defaultRegion
+	}
+	s.logger.Warn("Cross-region access denied",
+		"user_id", c.GetString("user_id"),
+		kind+"_id", id,
+		"region", region,
+		"path", c.FullPath(),
+	)
+	c.JSON(http.StatusForbidden, gin.H{"error": "Cross-region access denied"})
+	c.Abort()
+}
@@ -0,0 +1,161 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: urbanzen/device/v1/device.proto
+
+// Internal device-service API for other UrbanZen services. External
+// clients keep using the HTTP API.
+
+package devicev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	DeviceService_IngestTelemetry_FullMethodName = "/urbanzen.device.v1.DeviceService/IngestTelemetry"
+	DeviceService_SendCommand_FullMethodName     = "/urbanzen.device.v1.DeviceService/SendCommand"
+)
+
+// DeviceServiceClient is the client API for DeviceService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DeviceServiceClient interface {
+	// IngestTelemetry queues one reading for processing, like
+	// POST /api/v1/telemetry. It fails with RESOURCE_EXHAUSTED when the
+	// ingestion queue is full; callers should back off and retry.
+	IngestTelemetry(ctx context.Context, in *IngestTelemetryRequest, opts ...grpc.CallOption) (*IngestTelemetryResponse, error)
+	// SendCommand publishes a command to one device in the caller's tenant.
+	// With dry_run it runs the same checks and reports what would be sent,
+	// without sending anything.
+	SendCommand(ctx context.Context, in *SendCommandRequest, opts ...grpc.CallOption) (*SendCommandResponse, error)
+}
+
+type deviceServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDeviceServiceClient(cc grpc.ClientConnInterface) DeviceServiceClient {
+	return &deviceServiceClient{cc}
+}
+
+func (c *deviceServiceClient) IngestTelemetry(ctx context.Context, in *IngestTelemetryRequest, opts ...grpc.CallOption) (*IngestTelemetryResponse, error) {
+	out := new(IngestTelemetryResponse)
+	err := c.cc.Invoke(ctx, DeviceService_IngestTelemetry_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) SendCommand(ctx context.Context, in *SendCommandRequest, opts ...grpc.CallOption) (*SendCommandResponse, error) {
+	out := new(SendCommandResponse)
+	err := c.cc.Invoke(ctx, DeviceService_SendCommand_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeviceServiceServer is the server API for DeviceService service.
+// All implementations must embed UnimplementedDeviceServiceServer
+// for forward compatibility
+type DeviceServiceServer interface {
+	// IngestTelemetry queues one reading for processing, like
+	// POST /api/v1/telemetry. It fails with RESOURCE_EXHAUSTED when the
+	// ingestion queue is full; callers should back off and retry.
+	IngestTelemetry(context.Context, *IngestTelemetryRequest) (*IngestTelemetryResponse, error)
+	// SendCommand publishes a command to one device in the caller's tenant.
+	// With dry_run it runs the same checks and reports what would be sent,
+	// without sending anything.
+	SendCommand(context.Context, *SendCommandRequest) (*SendCommandResponse, error)
+	mustEmbedUnimplementedDeviceServiceServer()
+}
+
+// UnimplementedDeviceServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDeviceServiceServer struct {
+}
+
+func (UnimplementedDeviceServiceServer) IngestTelemetry(context.Context, *IngestTelemetryRequest) (*IngestTelemetryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IngestTelemetry not implemented")
+}
+func (UnimplementedDeviceServiceServer) SendCommand(context.Context, *SendCommandRequest) (*SendCommandResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendCommand not implemented")
+}
+func (UnimplementedDeviceServiceServer) mustEmbedUnimplementedDeviceServiceServer() {}
+
+// UnsafeDeviceServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DeviceServiceServer will
+// result in compilation errors.
+type UnsafeDeviceServiceServer interface {
+	mustEmbedUnimplementedDeviceServiceServer()
+}
+
+func RegisterDeviceServiceServer(s grpc.ServiceRegistrar, srv DeviceServiceServer) {
+	s.RegisterService(&DeviceService_ServiceDesc, srv)
+}
+
+func _DeviceService_IngestTelemetry_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IngestTelemetryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).IngestTelemetry(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_IngestTelemetry_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).IngestTelemetry(ctx, req.(*IngestTelemetryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_SendCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendCommandRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).SendCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_SendCommand_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).SendCommand(ctx, req.(*SendCommandRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DeviceService_ServiceDesc is the grpc.ServiceDesc for DeviceService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DeviceService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "urbanzen.device.v1.DeviceService",
+	HandlerType: (*DeviceServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "IngestTelemetry",
+			Handler:    _DeviceService_IngestTelemetry_Handler,
+		},
+		{
+			MethodName: "SendCommand",
+			Handler:    _DeviceService_SendCommand_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "urbanzen/device/v1/device.proto",
+}
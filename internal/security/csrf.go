@@ -0,0 +1,106 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultCSRFCookieName = "csrf_token"
+	defaultCSRFHeaderName = "X-CSRF-Token"
+	csrfContextKey        = "csrf_token"
+	csrfTokenTTL          = 12 * time.Hour
+)
+
+// CSRF implements the double-submit-cookie pattern: safe requests receive a
+// token cookie, and state-changing requests must echo it back in a header.
+// Requests authenticated with a bearer token are skipped since browsers never
+// attach the Authorization header on their own.
+func (m *Middleware) CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cookie, _ := c.Cookie(m.config.CSRFCookieName)
+
+		if isSafeMethod(c.Request.Method) {
+			if cookie == "" {
+				token, err := generateCSRFToken()
+				if err != nil {
+					m.logger.Error("Failed to generate CSRF token", "error", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSRF token"})
+					c.Abort()
+					return
+				}
+				m.setCSRFCookie(c, token)
+				cookie = token
+			}
+			c.Set(csrfContextKey, cookie)
+			c.Next()
+			return
+		}
+
+		if hasBearerToken(c.Request) || m.isCSRFExempt(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader(m.config.CSRFHeaderName)
+		if cookie == "" || header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(cookie)) != 1 {
+			m.logger.Warn("CSRF validation failed", "path", c.Request.URL.Path, "ip", c.ClientIP())
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// CSRFToken returns the token issued for the current request, so handlers can
+// hand it to clients that cannot read cookies directly.
+func CSRFToken(c *gin.Context) string {
+	return c.GetString(csrfContextKey)
+}
+
+func (m *Middleware) setCSRFCookie(c *gin.Context, token string) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	// Not HttpOnly: the frontend has to read it to echo it back in the header
+	c.SetCookie(m.config.CSRFCookieName, token, int(csrfTokenTTL.Seconds()), "/", "", m.config.CSRFCookieSecure, false)
+}
+
+func (m *Middleware) isCSRFExempt(path string) bool {
+	for _, exempt := range m.config.CSRFExemptPaths {
+		if path == exempt {
+			return true
+		}
+		// A trailing "*" exempts everything under the prefix
+		if strings.HasSuffix(exempt, "*") && strings.HasPrefix(path, strings.TrimSuffix(exempt, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func generateCSRFToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+func hasBearerToken(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
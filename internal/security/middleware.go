@@ -1,16 +1,13 @@
 package security
 
 import (
-	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
-	
+
 	"github.com/gin-gonic/gin"
-	"github.com/bhanukaranwal/urbanzen/internal/auth"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
 	"github.com/bhanukaranwal/urbanzen/pkg/logger"
 )
 
@@ -24,7 +21,208 @@ type SecurityConfig struct {
 	EnableHSTS          bool
 	EnableContentTypes  bool
 	EnableXSSProtection bool
+
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	FrameOptions          string
+	ContentSecurityPolicy string
+	CSRFCookieName        string
+	CSRFHeaderName        string
+	CSRFCookieSecure      bool
+	CSRFExemptPaths       []string
 }
 
 type Middleware struct {
-	config     *
+	config *SecurityConfig
+	logger logger.Logger
+}
+
+// NewConfig builds the security settings for a service from the shared
+// application config, applying any per-service overrides on top.
+func NewConfig(cfg *config.Config, service string) *SecurityConfig {
+	sec := cfg.Security
+
+	sc := &SecurityConfig{
+		EnableCSRF:            sec.EnableCSRF,
+		EnableRateLimit:       sec.RateLimitPerMin > 0,
+		RateLimitPerMinute:    sec.RateLimitPerMin,
+		EnableCORS:            len(sec.CORSOrigins) > 0,
+		AllowedOrigins:        sec.CORSOrigins,
+		RequireHTTPS:          sec.RequireHTTPS,
+		EnableHSTS:            sec.HSTS.Enabled,
+		EnableContentTypes:    true,
+		EnableXSSProtection:   true,
+		HSTSMaxAge:            sec.HSTS.MaxAge,
+		HSTSIncludeSubdomains: sec.HSTS.IncludeSubdomains,
+		HSTSPreload:           sec.HSTS.Preload,
+		FrameOptions:          sec.FrameOptions,
+		ContentSecurityPolicy: sec.ContentSecurityPolicy,
+		CSRFCookieName:        defaultCSRFCookieName,
+		CSRFHeaderName:        defaultCSRFHeaderName,
+		CSRFCookieSecure:      cfg.Environment == "production",
+		CSRFExemptPaths:       sec.CSRFExemptPaths,
+	}
+
+	if override, exists := sec.Services[service]; exists {
+		if override.CORSOrigins != nil {
+			sc.AllowedOrigins = override.CORSOrigins
+			sc.EnableCORS = len(override.CORSOrigins) > 0
+		}
+		if override.EnableCSRF != nil {
+			sc.EnableCSRF = *override.EnableCSRF
+		}
+		if override.RequireHTTPS != nil {
+			sc.RequireHTTPS = *override.RequireHTTPS
+		}
+		if override.EnableHSTS != nil {
+			sc.EnableHSTS = *override.EnableHSTS
+		}
+		if override.FrameOptions != "" {
+			sc.FrameOptions = override.FrameOptions
+		}
+		if override.ContentSecurityPolicy != "" {
+			sc.ContentSecurityPolicy = override.ContentSecurityPolicy
+		}
+	}
+
+	if sc.FrameOptions == "" {
+		sc.FrameOptions = "DENY"
+	}
+
+	return sc
+}
+
+func NewMiddleware(cfg *SecurityConfig, log logger.Logger) *Middleware {
+	return &Middleware{
+		config: cfg,
+		logger: log,
+	}
+}
+
+// Apply registers every protection enabled in the config on the router.
+func (m *Middleware) Apply(router *gin.Engine) {
+	if m.config.RequireHTTPS {
+		router.Use(m.HTTPSRedirect())
+	}
+	router.Use(m.Headers())
+	if m.config.EnableCORS {
+		router.Use(m.CORS())
+	}
+	if m.config.EnableCSRF {
+		router.Use(m.CSRF())
+	}
+}
+
+func (m *Middleware) Headers() gin.HandlerFunc {
+	hsts := m.hstsValue()
+
+	return func(c *gin.Context) {
+		c.Header("X-Frame-Options", m.config.FrameOptions)
+
+		if m.config.EnableContentTypes {
+			c.Header("X-Content-Type-Options", "nosniff")
+		}
+
+		if m.config.EnableXSSProtection {
+			c.Header("X-XSS-Protection", "1; mode=block")
+		}
+
+		if m.config.ContentSecurityPolicy != "" {
+			c.Header("Content-Security-Policy", m.config.ContentSecurityPolicy)
+		}
+
+		// HSTS is only meaningful over TLS; browsers ignore it on plain HTTP
+		if hsts != "" && isHTTPS(c.Request) {
+			c.Header("Strict-Transport-Security", hsts)
+		}
+
+		c.Next()
+	}
+}
+
+func (m *Middleware) HTTPSRedirect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isHTTPS(c.Request) {
+			c.Next()
+			return
+		}
+
+		// Let health probes through so orchestrators can reach the service directly
+		if c.Request.URL.Path == "/health" {
+			c.Next()
+			return
+		}
+
+		target := "https://" + c.Request.Host + c.Request.URL.RequestURI()
+		c.Redirect(http.StatusPermanentRedirect, target)
+		c.Abort()
+	}
+}
+
+// CORS lets the configured origins call the API from a browser. Only origins
+// listed exactly may send credentials; "*" opens the API to any site, but
+// without cookies or the CSRF token, since echoing an arbitrary origin with
+// credentials would let any site act as the logged-in user.
+func (m *Middleware) CORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.Request.Header.Get("Origin")
+
+		// Envelope varies responses too; add rather than replace
+		c.Writer.Header().Add("Vary", "Origin")
+
+		wildcard := false
+		for _, allowedOrigin := range m.config.AllowedOrigins {
+			if origin != "" && origin == allowedOrigin {
+				c.Header("Access-Control-Allow-Origin", origin)
+				c.Header("Access-Control-Allow-Credentials", "true")
+				wildcard = false
+				break
+			}
+			if allowedOrigin == "*" {
+				wildcard = true
+			}
+		}
+		if wildcard {
+			c.Header("Access-Control-Allow-Origin", "*")
+		}
+
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, accept, origin, Cache-Control, X-Requested-With, "+m.config.CSRFHeaderName)
+		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (m *Middleware) hstsValue() string {
+	if !m.config.EnableHSTS {
+		return ""
+	}
+
+	maxAge := m.config.HSTSMaxAge
+	if maxAge <= 0 {
+		maxAge = 365 * 24 * time.Hour
+	}
+
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if m.config.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if m.config.HSTSPreload {
+		value += "; preload"
+	}
+
+	return value
+}
+
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
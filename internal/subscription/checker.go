@@ -0,0 +1,139 @@
+package subscription
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/units"
+)
+
+// Which devices have enabled subscriptions is cached for this long, so
+// readings from devices nobody watches cost no query. Subscription
+// changes reach ingestion within this time.
+const subscribedCacheTTL = time.Minute
+
+// condition is true for a reading r that meets subscription s
+const condition = `CASE s.operator WHEN 'below' THEN r.value < s.canonical_threshold ELSE r.value > s.canonical_threshold END`
+
+// Change is a reading that started or ended a subscription's condition.
+type Change struct {
+	Subscription
+	TenantID string
+
+	// Triggered is true when the condition started to hold and false when
+	// it stopped
+	Triggered bool
+
+	// Value is the reading, in CanonicalUnit
+	Value         float64
+	CanonicalUnit string
+}
+
+// DisplayValue returns the reading in the unit the user gave the
+// threshold in, falling back to the canonical unit.
+func (c *Change) DisplayValue() string {
+	if c.Unit != "" && c.Unit != c.CanonicalUnit {
+		if value, err := units.Default.Convert(c.Value, c.CanonicalUnit, c.Unit); err == nil {
+			return formatValue(value, c.Unit)
+		}
+	}
+	return formatValue(c.Value, c.CanonicalUnit)
+}
+
+// Checker tests readings against subscriptions for the device service.
+type Checker struct {
+	db *database.PostgresDB
+
+	// device ID -> subscribedEntry
+	subscribed sync.Map
+}
+
+type subscribedEntry struct {
+	subscribed bool
+	expires    time.Time
+}
+
+func NewChecker(db *database.PostgresDB) *Checker {
+	return &Checker{db: db}
+}
+
+// Check tests a reading's numeric metrics, in canonical units, against the
+// device's enabled subscriptions and returns those whose condition started
+// or stopped holding. The state change is made in the database, so when
+// several instances see readings from the same device only one reports
+// it. Subscriptions whose user is no longer active, or is a citizen no
+// longer assigned the device, are left alone.
+func (c *Checker) Check(ctx context.Context, deviceID string, metrics map[string]interface{}, at time.Time) ([]Change, error) {
+	subscribed, err := c.hasSubscriptions(ctx, deviceID)
+	if err != nil || !subscribed {
+		return nil, err
+	}
+
+	var names []string
+	var values []float64
+	for name, value := range metrics {
+		if numeric, ok := value.(float64); ok {
+			names = append(names, name)
+			values = append(values, numeric)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		WITH r (metric, value) AS (
+			SELECT * FROM unnest($2::text[], $3::double precision[])
+		)
+		UPDATE metric_subscriptions s
+		SET triggered_at = CASE WHEN `+condition+` THEN $4::timestamptz END
+		FROM r, users u
+		WHERE s.device_id = $1 AND s.metric = r.metric AND s.enabled
+			AND (`+condition+`) = (s.triggered_at IS NULL)
+			AND u.id = s.user_id AND u.is_active AND u.erased_at IS NULL
+			AND (u.role IN ('operator', 'admin', 'super_admin') OR EXISTS (
+				SELECT 1 FROM device_assignments a WHERE a.user_id = u.id AND a.device_id = s.device_id
+			))
+		RETURNING s.id, s.tenant_id, s.user_id, s.device_id, s.metric, s.operator, s.threshold, s.unit,
+			s.notify_recovery, s.triggered_at IS NOT NULL, r.value, s.canonical_unit
+	`, deviceID, pq.Array(names), pq.Array(values), at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []Change
+	for rows.Next() {
+		var change Change
+		err := rows.Scan(&change.ID, &change.TenantID, &change.UserID, &change.DeviceID, &change.Metric,
+			&change.Operator, &change.Threshold, &change.Unit, &change.NotifyRecovery, &change.Triggered,
+			&change.Value, &change.CanonicalUnit)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+func (c *Checker) hasSubscriptions(ctx context.Context, deviceID string) (bool, error) {
+	if cached, ok := c.subscribed.Load(deviceID); ok {
+		if entry := cached.(subscribedEntry); time.Now().Before(entry.expires) {
+			return entry.subscribed, nil
+		}
+	}
+
+	var subscribed bool
+	err := c.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM metric_subscriptions WHERE device_id = $1 AND enabled)
+	`, deviceID).Scan(&subscribed)
+	if err != nil {
+		return false, err
+	}
+
+	c.subscribed.Store(deviceID, subscribedEntry{subscribed: subscribed, expires: time.Now().Add(subscribedCacheTTL)})
+	return subscribed, nil
+}
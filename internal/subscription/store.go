@@ -0,0 +1,178 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/units"
+)
+
+// Store manages subscriptions for the API. Every call is limited to one
+// user's subscriptions in their tenant.
+type Store struct {
+	db *database.PostgresDB
+}
+
+func NewStore(db *database.PostgresDB) *Store {
+	return &Store{db: db}
+}
+
+const subscriptionColumns = `id, user_id, device_id, metric, operator, threshold, unit, notify_recovery, enabled,
+	triggered_at, created_at, updated_at`
+
+// List returns the user's subscriptions, oldest first.
+func (s *Store) List(ctx context.Context, tenantID, userID string) ([]*Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM metric_subscriptions
+		WHERE tenant_id = $1 AND user_id::text = $2
+		ORDER BY created_at
+	`, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []*Subscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// Get returns one of the user's subscriptions, or sql.ErrNoRows.
+func (s *Store) Get(ctx context.Context, tenantID, userID, id string) (*Subscription, error) {
+	return scanSubscription(s.db.QueryRowContext(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM metric_subscriptions
+		WHERE id::text = $1 AND tenant_id = $2 AND user_id::text = $3
+	`, id, tenantID, userID))
+}
+
+// Create saves a new subscription for its user, setting its ID and
+// timestamps. It returns sql.ErrNoRows if the device isn't in the tenant.
+func (s *Store) Create(ctx context.Context, tenantID string, subscription *Subscription) error {
+	canonical, err := s.canonical(ctx, tenantID, subscription)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM metric_subscriptions WHERE user_id::text = $1
+	`, subscription.UserID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count >= MaxPerUser {
+		return &ValidationError{Violations: []string{fmt.Sprintf("you may have at most %d subscriptions", MaxPerUser)}}
+	}
+
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO metric_subscriptions (tenant_id, user_id, device_id, metric, operator, threshold, unit,
+			canonical_threshold, canonical_unit, notify_recovery, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, updated_at
+	`, tenantID, subscription.UserID, subscription.DeviceID, subscription.Metric, subscription.Operator,
+		subscription.Threshold, subscription.Unit, canonical.threshold, canonical.unit,
+		subscription.NotifyRecovery, subscription.Enabled).Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt)
+}
+
+// Update replaces one of the user's subscriptions, returning sql.ErrNoRows
+// if it or the device doesn't exist. An updated subscription is re-armed,
+// so a condition that still holds notifies again.
+func (s *Store) Update(ctx context.Context, tenantID string, subscription *Subscription) error {
+	canonical, err := s.canonical(ctx, tenantID, subscription)
+	if err != nil {
+		return err
+	}
+
+	return s.db.QueryRowContext(ctx, `
+		UPDATE metric_subscriptions
+		SET device_id = $4, metric = $5, operator = $6, threshold = $7, unit = $8,
+			canonical_threshold = $9, canonical_unit = $10, notify_recovery = $11, enabled = $12,
+			triggered_at = NULL, updated_at = NOW()
+		WHERE id::text = $1 AND tenant_id = $2 AND user_id::text = $3
+		RETURNING created_at, updated_at
+	`, subscription.ID, tenantID, subscription.UserID, subscription.DeviceID, subscription.Metric,
+		subscription.Operator, subscription.Threshold, subscription.Unit, canonical.threshold, canonical.unit,
+		subscription.NotifyRecovery, subscription.Enabled).Scan(&subscription.CreatedAt, &subscription.UpdatedAt)
+}
+
+// Delete removes one of the user's subscriptions, returning sql.ErrNoRows
+// if it doesn't exist.
+func (s *Store) Delete(ctx context.Context, tenantID, userID, id string) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM metric_subscriptions WHERE id::text = $1 AND tenant_id = $2 AND user_id::text = $3
+	`, id, tenantID, userID)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+type canonicalThreshold struct {
+	threshold float64
+	unit      string
+}
+
+// canonical validates the subscription and converts its threshold to the
+// unit readings of its metric are stored in, which becomes its unit if it
+// had none. It returns sql.ErrNoRows if the device isn't in the tenant.
+func (s *Store) canonical(ctx context.Context, tenantID string, subscription *Subscription) (canonicalThreshold, error) {
+	if err := subscription.Validate(); err != nil {
+		return canonicalThreshold{}, err
+	}
+
+	var canonicalUnit string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(t.metric_units->>$3, '')
+		FROM devices d
+		LEFT JOIN device_types t ON t.name = d.type
+		WHERE d.id = $1 AND d.tenant_id = $2
+	`, subscription.DeviceID, tenantID, subscription.Metric).Scan(&canonicalUnit)
+	if err != nil {
+		return canonicalThreshold{}, err
+	}
+
+	if subscription.Unit == "" || subscription.Unit == canonicalUnit {
+		subscription.Unit = canonicalUnit
+		return canonicalThreshold{threshold: subscription.Threshold, unit: canonicalUnit}, nil
+	}
+	if canonicalUnit == "" {
+		return canonicalThreshold{}, &ValidationError{Violations: []string{
+			fmt.Sprintf("%s has no declared unit on this device's type, so leave unit out", subscription.Metric),
+		}}
+	}
+	threshold, err := units.Default.Convert(subscription.Threshold, subscription.Unit, canonicalUnit)
+	if err != nil {
+		return canonicalThreshold{}, &ValidationError{Violations: []string{
+			fmt.Sprintf("unit: %v", err),
+		}}
+	}
+	return canonicalThreshold{threshold: threshold, unit: canonicalUnit}, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(row rowScanner) (*Subscription, error) {
+	var subscription Subscription
+	err := row.Scan(&subscription.ID, &subscription.UserID, &subscription.DeviceID, &subscription.Metric,
+		&subscription.Operator, &subscription.Threshold, &subscription.Unit, &subscription.NotifyRecovery,
+		&subscription.Enabled, &subscription.TriggeredAt, &subscription.CreatedAt, &subscription.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
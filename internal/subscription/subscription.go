@@ -0,0 +1,87 @@
+// Package subscription lets users ask to be notified when a device metric
+// crosses a threshold, such as their water pressure dropping below 1 bar.
+// It is separate from operator anomaly detection: each subscription
+// belongs to one user and only ever notifies them.
+package subscription
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxPerUser caps how many subscriptions one user may have
+	MaxPerUser = 50
+
+	maxMetricLength = 100
+)
+
+var operators = map[string]bool{"below": true, "above": true}
+
+// Subscription notifies its user when Metric on DeviceID goes below or
+// above Threshold, given in Unit. Unit defaults to the metric's canonical
+// unit. It fires once per crossing: TriggeredAt is set while the condition
+// holds and cleared when it no longer does.
+type Subscription struct {
+	ID             string     `json:"id"`
+	UserID         string     `json:"user_id"`
+	DeviceID       string     `json:"device_id"`
+	Metric         string     `json:"metric"`
+	Operator       string     `json:"operator"`
+	Threshold      float64    `json:"threshold"`
+	Unit           string     `json:"unit,omitempty"`
+	NotifyRecovery bool       `json:"notify_recovery"`
+	Enabled        bool       `json:"enabled"`
+	TriggeredAt    *time.Time `json:"triggered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ValidationError lists everything wrong with a subscription.
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid subscription: " + strings.Join(e.Violations, "; ")
+}
+
+// Validate checks the fields that don't depend on the device. Whether the
+// unit suits the metric is checked when the subscription is saved.
+func (s *Subscription) Validate() error {
+	var violations []string
+
+	if s.DeviceID == "" {
+		violations = append(violations, "device_id is required")
+	}
+	if s.Metric == "" || len(s.Metric) > maxMetricLength {
+		violations = append(violations, fmt.Sprintf("metric is required and may be at most %d characters", maxMetricLength))
+	}
+	if !operators[s.Operator] {
+		violations = append(violations, fmt.Sprintf("operator must be below or above (got %q)", s.Operator))
+	}
+	if math.IsNaN(s.Threshold) || math.IsInf(s.Threshold, 0) {
+		violations = append(violations, "threshold must be a number")
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// Limit describes the condition without the metric, such as "below 1 bar".
+func (s *Subscription) Limit() string {
+	return s.Operator + " " + formatValue(s.Threshold, s.Unit)
+}
+
+func formatValue(value float64, unit string) string {
+	formatted := strconv.FormatFloat(math.Round(value*1000)/1000, 'f', -1, 64)
+	if unit != "" {
+		formatted += " " + unit
+	}
+	return formatted
+}
@@ -0,0 +1,200 @@
+// Package telemetryschema keeps a versioned contract for the metrics each
+// device type reports: which metrics, their types, units and valid ranges.
+// Versions are immutable. A type's latest version is the one ingestion
+// checks readings against, so a schema evolves by adding a version and is
+// rolled back by deleting one.
+package telemetryschema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/pkg/units"
+)
+
+const maxMetrics = 200
+
+var metricTypes = map[string]bool{"number": true, "integer": true, "boolean": true, "string": true}
+
+// Metric is what a schema expects of one metric. Min and Max apply to
+// numbers and are in Unit.
+type Metric struct {
+	Type     string   `json:"type"`
+	Unit     string   `json:"unit,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Required bool     `json:"required,omitempty"`
+}
+
+// Schema is one version of a device type's telemetry contract. Strict
+// rejects readings carrying metrics the schema doesn't list; otherwise
+// they pass unchecked.
+type Schema struct {
+	DeviceType string            `json:"device_type"`
+	Version    int               `json:"version"`
+	Metrics    map[string]Metric `json:"metrics"`
+	Strict     bool              `json:"strict"`
+	CreatedBy  string            `json:"created_by,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// ValidationError lists everything wrong with a schema definition.
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid telemetry schema: " + strings.Join(e.Violations, "; ")
+}
+
+// ReadingError lists every way a reading breaks the schema it was
+// checked against.
+type ReadingError struct {
+	Version    int
+	Violations []string
+}
+
+func (e *ReadingError) Error() string {
+	return fmt.Sprintf("reading does not match telemetry schema version %d: %s", e.Version, strings.Join(e.Violations, "; "))
+}
+
+// Validate checks the definition. Readings are converted to the canonical
+// units of their type before they are checked, so a metric's unit must be
+// the canonical one when the type declares it.
+func (s *Schema) Validate(canonical devicetype.MetricUnits) error {
+	var violations []string
+
+	if len(s.Metrics) == 0 {
+		violations = append(violations, "metrics must list at least one metric")
+	}
+	if len(s.Metrics) > maxMetrics {
+		violations = append(violations, fmt.Sprintf("metrics may list at most %d metrics", maxMetrics))
+	}
+
+	for name, metric := range s.Metrics {
+		if !metricTypes[metric.Type] {
+			violations = append(violations, fmt.Sprintf("%s: type must be number, integer, boolean or string (got %q)", name, metric.Type))
+		}
+		if metric.Unit != "" {
+			if !units.Default.Known(metric.Unit) {
+				violations = append(violations, fmt.Sprintf("%s: unknown unit %q", name, metric.Unit))
+			} else if unit, ok := canonical[name]; ok && unit != metric.Unit {
+				violations = append(violations, fmt.Sprintf("%s: unit must be the type's canonical unit %q (got %q)", name, unit, metric.Unit))
+			}
+		}
+		numeric := metric.Type == "number" || metric.Type == "integer"
+		if !numeric && (metric.Min != nil || metric.Max != nil) {
+			violations = append(violations, fmt.Sprintf("%s: min and max only apply to numbers", name))
+		}
+		if metric.Min != nil && metric.Max != nil && *metric.Min > *metric.Max {
+			violations = append(violations, fmt.Sprintf("%s: min must not be greater than max", name))
+		}
+	}
+
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// Check tests a reading's metrics against the schema.
+func (s *Schema) Check(metrics map[string]interface{}) error {
+	var violations []string
+
+	for name, value := range metrics {
+		metric, ok := s.Metrics[name]
+		if !ok {
+			if s.Strict {
+				violations = append(violations, fmt.Sprintf("%s: not in the schema", name))
+			}
+			continue
+		}
+		if problem := metric.check(value); problem != "" {
+			violations = append(violations, fmt.Sprintf("%s: %s", name, problem))
+		}
+	}
+	for name, metric := range s.Metrics {
+		if _, ok := metrics[name]; metric.Required && !ok {
+			violations = append(violations, fmt.Sprintf("%s: is required", name))
+		}
+	}
+
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return &ReadingError{Version: s.Version, Violations: violations}
+	}
+	return nil
+}
+
+func (m Metric) check(value interface{}) string {
+	switch m.Type {
+	case "number", "integer":
+		number, ok := value.(float64)
+		if !ok {
+			return "must be a number"
+		}
+		if m.Type == "integer" && number != float64(int64(number)) {
+			return "must be a whole number"
+		}
+		if m.Min != nil && number < *m.Min {
+			return fmt.Sprintf("must be at least %v", *m.Min)
+		}
+		if m.Max != nil && number > *m.Max {
+			return fmt.Sprintf("must be at most %v", *m.Max)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return "must be true or false"
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return "must be a string"
+		}
+	}
+	return ""
+}
+
+// Changes lists what a new version would reject that the previous one
+// accepted, so admins see the effect on devices in the field before they
+// rely on it. It returns nil when the new version accepts everything the
+// previous one did.
+func Changes(previous, next *Schema) []string {
+	var changes []string
+
+	if next.Strict && !previous.Strict {
+		changes = append(changes, "metrics not in the schema are now rejected")
+	}
+	for name, before := range previous.Metrics {
+		after, ok := next.Metrics[name]
+		if !ok {
+			if next.Strict {
+				changes = append(changes, fmt.Sprintf("%s: removed, and now rejected", name))
+			}
+			continue
+		}
+		if after.Type != before.Type && !(before.Type == "integer" && after.Type == "number") {
+			changes = append(changes, fmt.Sprintf("%s: type changed from %s to %s", name, before.Type, after.Type))
+		}
+		if after.Min != nil && (before.Min == nil || *after.Min > *before.Min) {
+			changes = append(changes, fmt.Sprintf("%s: min raised to %v", name, *after.Min))
+		}
+		if after.Max != nil && (before.Max == nil || *after.Max < *before.Max) {
+			changes = append(changes, fmt.Sprintf("%s: max lowered to %v", name, *after.Max))
+		}
+		if after.Required && !before.Required {
+			changes = append(changes, fmt.Sprintf("%s: now required", name))
+		}
+	}
+	for name, after := range next.Metrics {
+		if _, ok := previous.Metrics[name]; !ok && after.Required {
+			changes = append(changes, fmt.Sprintf("%s: new and required", name))
+		}
+	}
+
+	sort.Strings(changes)
+	return changes
+}
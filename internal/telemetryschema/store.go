@@ -0,0 +1,193 @@
+package telemetryschema
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// Every reading is checked against its type's latest schema, so Latest is
+// cached the way device types are.
+const (
+	cacheName       = "telemetry_schemas"
+	cacheTTL        = 5 * time.Minute
+	cacheMaxEntries = 1000
+)
+
+type Store struct {
+	db     *database.PostgresDB
+	syncer *cache.Syncer
+
+	// device type -> latest schema, or nil if the type has none
+	latest *cache.Cache[string, *Schema]
+}
+
+func NewStore(db *database.PostgresDB) *Store {
+	return &Store{
+		db:     db,
+		latest: cache.New[string, *Schema](cacheName, cacheTTL, cacheMaxEntries),
+	}
+}
+
+// SyncWith shares the store's cache invalidations with other processes.
+func (s *Store) SyncWith(syncer *cache.Syncer) {
+	s.syncer = syncer
+	syncer.Watch(s.latest)
+}
+
+const schemaColumns = `device_type, version, metrics, strict, COALESCE(created_by::text, ''), created_at`
+
+// List returns a type's schema versions, newest first.
+func (s *Store) List(ctx context.Context, deviceType string) ([]*Schema, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+schemaColumns+`
+		FROM telemetry_schemas
+		WHERE device_type = $1
+		ORDER BY version DESC
+	`, deviceType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schemas := []*Schema{}
+	for rows.Next() {
+		schema, err := scanSchema(rows)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, rows.Err()
+}
+
+// Get returns one version of a type's schema, or sql.ErrNoRows.
+func (s *Store) Get(ctx context.Context, deviceType string, version int) (*Schema, error) {
+	return scanSchema(s.db.QueryRowContext(ctx, `
+		SELECT `+schemaColumns+`
+		FROM telemetry_schemas
+		WHERE device_type = $1 AND version = $2
+	`, deviceType, version))
+}
+
+// Latest returns the version of a type's schema readings are checked
+// against, or sql.ErrNoRows if the type has none. The schema is shared with
+// other callers and must not be modified.
+func (s *Store) Latest(ctx context.Context, deviceType string) (*Schema, error) {
+	schema, err := s.latest.Load(deviceType, func() (*Schema, error) {
+		schema, err := scanSchema(s.db.QueryRowContext(ctx, `
+			SELECT `+schemaColumns+`
+			FROM telemetry_schemas
+			WHERE device_type = $1
+			ORDER BY version DESC
+			LIMIT 1
+		`, deviceType))
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return schema, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return nil, sql.ErrNoRows
+	}
+	return schema, nil
+}
+
+// changed drops a type's cached schema here and in other processes.
+func (s *Store) changed(ctx context.Context, deviceType string) {
+	s.latest.Delete(deviceType)
+	s.syncer.Changed(ctx, cacheName)
+}
+
+// Create validates the schema against its type and saves it as the type's
+// next version, setting Version. It returns sql.ErrNoRows if the type isn't
+// registered.
+func (s *Store) Create(ctx context.Context, schema *Schema, actorID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the type serializes version numbers and holds its units
+	// still while the schema is checked against them
+	var metricUnitsJSON []byte
+	err = tx.QueryRowContext(ctx, `
+		SELECT metric_units FROM device_types WHERE name = $1 FOR UPDATE
+	`, schema.DeviceType).Scan(&metricUnitsJSON)
+	if err != nil {
+		return err
+	}
+	var canonical devicetype.MetricUnits
+	if err := json.Unmarshal(metricUnitsJSON, &canonical); err != nil {
+		return err
+	}
+	if err := schema.Validate(canonical); err != nil {
+		return err
+	}
+
+	metrics, err := json.Marshal(schema.Metrics)
+	if err != nil {
+		return err
+	}
+
+	schema.CreatedBy = actorID
+	schema.CreatedAt = time.Now()
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO telemetry_schemas (device_type, version, metrics, strict, created_by, created_at)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, NULLIF($4, '')::uuid, $5
+		FROM telemetry_schemas
+		WHERE device_type = $1
+		RETURNING version
+	`, schema.DeviceType, metrics, schema.Strict, actorID, schema.CreatedAt).Scan(&schema.Version)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.changed(ctx, schema.DeviceType)
+	return nil
+}
+
+// Delete removes one version, returning sql.ErrNoRows if it doesn't exist.
+// Deleting the latest version puts the one before it back in force.
+func (s *Store) Delete(ctx context.Context, deviceType string, version int) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM telemetry_schemas WHERE device_type = $1 AND version = $2
+	`, deviceType, version)
+	if err != nil {
+		return err
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return sql.ErrNoRows
+	}
+	s.changed(ctx, deviceType)
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSchema(row rowScanner) (*Schema, error) {
+	var schema Schema
+	var metrics []byte
+	if err := row.Scan(&schema.DeviceType, &schema.Version, &metrics, &schema.Strict,
+		&schema.CreatedBy, &schema.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metrics, &schema.Metrics); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
@@ -0,0 +1,92 @@
+package tenant
+
+import "fmt"
+
+// AlertRouting decides which team is notified of a new alert, so a leak
+// in one ward reaches that ward's water team rather than everyone. Routes
+// are tried in order and the first match wins; alerts no route matches go
+// to DefaultTeam, or to no one if it's empty.
+type AlertRouting struct {
+	DefaultTeam string               `json:"default_team"`
+	Teams       map[string]AlertTeam `json:"teams"`
+	Routes      []AlertRoute         `json:"routes"`
+}
+
+// AlertTeam is the tenant's active users with any of Roles, plus Users by
+// ID. Members are notified on their own preferred channels.
+type AlertTeam struct {
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+// AlertRoute sends alerts matching every criterion set to Team. An empty
+// criterion matches anything.
+type AlertRoute struct {
+	AlertTypes  []string `json:"alert_types,omitempty"`
+	DeviceTypes []string `json:"device_types,omitempty"`
+	Severities  []string `json:"severities,omitempty"`
+	Wards       []string `json:"wards,omitempty"`
+	Zones       []string `json:"zones,omitempty"`
+	Team        string   `json:"team"`
+}
+
+// Alert is what routes are matched against. Ward and zone are the
+// device's.
+type Alert struct {
+	Type       string
+	DeviceType string
+	Severity   string
+	Ward       string
+	Zone       string
+}
+
+// Route returns the name of the team to notify of an alert, and false if
+// no one is to be notified.
+func (r AlertRouting) Route(alert Alert) (string, bool) {
+	for _, route := range r.Routes {
+		if route.matches(alert) {
+			return route.Team, true
+		}
+	}
+	return r.DefaultTeam, r.DefaultTeam != ""
+}
+
+func (r AlertRoute) matches(alert Alert) bool {
+	return matchesAny(r.AlertTypes, alert.Type) &&
+		matchesAny(r.DeviceTypes, alert.DeviceType) &&
+		matchesAny(r.Severities, alert.Severity) &&
+		matchesAny(r.Wards, alert.Ward) &&
+		matchesAny(r.Zones, alert.Zone)
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAlertRouting checks every route, and the default, names a team
+// with members.
+func (c *Config) validateAlertRouting() error {
+	routing := c.AlertRouting
+	for name, team := range routing.Teams {
+		if len(team.Roles) == 0 && len(team.Users) == 0 {
+			return fmt.Errorf("alert_routing.teams.%s: needs roles or users", name)
+		}
+	}
+	for i, route := range routing.Routes {
+		if _, ok := routing.Teams[route.Team]; !ok {
+			return fmt.Errorf("alert_routing.routes[%d]: unknown team %q", i, route.Team)
+		}
+	}
+	if _, ok := routing.Teams[routing.DefaultTeam]; routing.DefaultTeam != "" && !ok {
+		return fmt.Errorf("alert_routing.default_team: unknown team %q", routing.DefaultTeam)
+	}
+	return nil
+}
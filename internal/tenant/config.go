@@ -0,0 +1,246 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/timebucket"
+)
+
+// Config is the effective configuration for a single tenant: the deployment
+// defaults with that tenant's overrides applied on top.
+type Config struct {
+	TenantID   string                          `json:"tenant_id"`
+	Timezone   string                          `json:"timezone"`
+	Tariffs    map[string]Tariff               `json:"tariffs"`
+	Thresholds map[string]map[string]Threshold `json:"thresholds"`
+	Templates  map[string]Template             `json:"templates"`
+	Branding   Branding                        `json:"branding"`
+
+	AlertRouting AlertRouting `json:"alert_routing"`
+}
+
+type Tariff struct {
+	Unit         string  `json:"unit"`
+	RatePerUnit  float64 `json:"rate_per_unit"`
+	FixedCharge  float64 `json:"fixed_charge"`
+	TaxRate      float64 `json:"tax_rate"`
+	DueDays      int     `json:"due_days"`
+	ReminderDays []int   `json:"reminder_days"`
+	LateFee      LateFee `json:"late_fee"`
+}
+
+// LateFee is charged once a bill is GraceDays past due, then again every
+// RepeatDays (if set) up to MaxApplications times. Rate is a fraction of
+// the unpaid charges, not counting earlier late fees, added to the flat
+// amount.
+type LateFee struct {
+	Flat            float64 `json:"flat"`
+	Rate            float64 `json:"rate"`
+	GraceDays       int     `json:"grace_days"`
+	RepeatDays      int     `json:"repeat_days"`
+	MaxApplications int     `json:"max_applications"`
+}
+
+// MaxThresholdWindow caps how many recent samples a threshold considers.
+const MaxThresholdWindow = 100
+
+// Threshold raises an anomaly when a metric exceeds Max. With Window set,
+// it only does so once Breaches of the last Window samples have exceeded
+// it; Breaches defaults to the whole window. Without Window every breaching
+// sample raises one.
+type Threshold struct {
+	Max         float64 `json:"max"`
+	Type        string  `json:"type"`
+	Severity    string  `json:"severity"`
+	Description string  `json:"description"`
+	Window      int     `json:"window,omitempty"`
+	Breaches    int     `json:"breaches,omitempty"`
+}
+
+// Required returns N and M: the breaches needed among the last M samples.
+func (t Threshold) Required() (breaches, window int) {
+	if t.Window <= 1 {
+		return 1, 1
+	}
+	if t.Breaches <= 0 {
+		return t.Window, t.Window
+	}
+	return t.Breaches, t.Window
+}
+
+func (t Threshold) validate() error {
+	if t.Window < 0 || t.Window > MaxThresholdWindow {
+		return fmt.Errorf("window must be between 1 and %d", MaxThresholdWindow)
+	}
+	if t.Breaches < 0 || t.Breaches > t.Window {
+		return fmt.Errorf("breaches must be between 1 and window")
+	}
+	return nil
+}
+
+// validateThresholds checks every threshold's evaluation window.
+func (c *Config) validateThresholds() error {
+	for deviceType, metrics := range c.Thresholds {
+		for metric, threshold := range metrics {
+			if err := threshold.validate(); err != nil {
+				return fmt.Errorf("thresholds.%s.%s: %w", deviceType, metric, err)
+			}
+		}
+	}
+	return nil
+}
+
+type Template struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+type Branding struct {
+	DisplayName  string `json:"display_name"`
+	LogoURL      string `json:"logo_url"`
+	PrimaryColor string `json:"primary_color"`
+	SupportEmail string `json:"support_email"`
+}
+
+// Threshold returns the threshold for a device type and metric, if any.
+func (c *Config) Threshold(deviceType, metric string) (Threshold, bool) {
+	metrics, exists := c.Thresholds[deviceType]
+	if !exists {
+		return Threshold{}, false
+	}
+	threshold, exists := metrics[metric]
+	return threshold, exists
+}
+
+// Location returns the tenant's time zone, which daily and monthly rollups
+// align to. Overrides are validated when set, so the UTC fallback only
+// covers a zone that has since disappeared from the system database.
+func (c *Config) Location() *time.Location {
+	location, err := timebucket.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+func baseConfig(cfg *config.Config) *Config {
+	base := &Config{
+		Timezone:   cfg.Tenancy.Defaults.Timezone,
+		Tariffs:    map[string]Tariff{},
+		Thresholds: map[string]map[string]Threshold{},
+		Templates:  map[string]Template{},
+		Branding: Branding{
+			DisplayName:  cfg.Tenancy.Defaults.Branding.DisplayName,
+			LogoURL:      cfg.Tenancy.Defaults.Branding.LogoURL,
+			PrimaryColor: cfg.Tenancy.Defaults.Branding.PrimaryColor,
+			SupportEmail: cfg.Tenancy.Defaults.Branding.SupportEmail,
+		},
+	}
+
+	for utility, t := range cfg.Tenancy.Defaults.Tariffs {
+		base.Tariffs[utility] = Tariff{
+			Unit:         t.Unit,
+			RatePerUnit:  t.RatePerUnit,
+			FixedCharge:  t.FixedCharge,
+			TaxRate:      t.TaxRate,
+			DueDays:      t.DueDays,
+			ReminderDays: t.ReminderDays,
+			LateFee: LateFee{
+				Flat:            t.LateFee.Flat,
+				Rate:            t.LateFee.Rate,
+				GraceDays:       t.LateFee.GraceDays,
+				RepeatDays:      t.LateFee.RepeatDays,
+				MaxApplications: t.LateFee.MaxApplications,
+			},
+		}
+	}
+
+	for deviceType, metrics := range cfg.Tenancy.Defaults.Thresholds {
+		base.Thresholds[deviceType] = map[string]Threshold{}
+		for metric, t := range metrics {
+			base.Thresholds[deviceType][metric] = Threshold{
+				Max:         t.Max,
+				Type:        t.Type,
+				Severity:    t.Severity,
+				Description: t.Description,
+				Window:      t.Window,
+				Breaches:    t.Breaches,
+			}
+		}
+	}
+
+	for name, t := range cfg.Tenancy.Defaults.Templates {
+		base.Templates[name] = Template{Title: t.Title, Message: t.Message}
+	}
+
+	routing := cfg.Tenancy.Defaults.AlertRouting
+	base.AlertRouting = AlertRouting{
+		DefaultTeam: routing.DefaultTeam,
+		Teams:       map[string]AlertTeam{},
+		Routes:      []AlertRoute{},
+	}
+	for name, team := range routing.Teams {
+		base.AlertRouting.Teams[name] = AlertTeam{Roles: team.Roles, Users: team.Users}
+	}
+	for _, route := range routing.Routes {
+		base.AlertRouting.Routes = append(base.AlertRouting.Routes, AlertRoute{
+			AlertTypes:  route.AlertTypes,
+			DeviceTypes: route.DeviceTypes,
+			Severities:  route.Severities,
+			Wards:       route.Wards,
+			Zones:       route.Zones,
+			Team:        route.Team,
+		})
+	}
+
+	return base
+}
+
+// overlay deep-merges a tenant's JSON overrides onto the base config. Nested
+// objects merge key by key; any other value replaces the base value.
+func overlay(base *Config, overrides []byte) (*Config, error) {
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged map[string]interface{}
+	if err := json.Unmarshal(baseJSON, &merged); err != nil {
+		return nil, err
+	}
+
+	var patch map[string]interface{}
+	if err := json.Unmarshal(overrides, &patch); err != nil {
+		return nil, err
+	}
+
+	mergeMaps(merged, patch)
+
+	mergedJSON, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	var result Config
+	if err := json.Unmarshal(mergedJSON, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for key, srcValue := range src {
+		srcMap, srcIsMap := srcValue.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			mergeMaps(dstMap, srcMap)
+			continue
+		}
+		dst[key] = srcValue
+	}
+}
@@ -0,0 +1,237 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/cache"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/timebucket"
+)
+
+// ErrBeforeHistory is returned by ResolveAt for a time before the tenant's
+// configuration history began, when its overrides are unknown.
+var ErrBeforeHistory = errors.New("configuration history does not go back that far")
+
+// ErrInvalidOverrides wraps the reason overrides were rejected.
+var ErrInvalidOverrides = errors.New("invalid overrides")
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const (
+	cacheName       = "tenant_configs"
+	cacheMaxEntries = 10000
+)
+
+// Store resolves per-tenant configuration, caching the merged result so hot
+// paths like anomaly detection don't hit the database for every message.
+type Store struct {
+	db     *database.PostgresDB
+	base   *Config
+	logger logger.Logger
+	syncer *cache.Syncer
+
+	// tenant ID -> merged config, tariffs and alert routing included
+	cache *cache.Cache[string, *Config]
+}
+
+func NewStore(db *database.PostgresDB, cfg *config.Config, log logger.Logger) *Store {
+	ttl := cfg.Tenancy.CacheTTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+
+	return &Store{
+		db:     db,
+		base:   baseConfig(cfg),
+		logger: log,
+		cache:  cache.New[string, *Config](cacheName, ttl, cacheMaxEntries),
+	}
+}
+
+// SyncWith shares the store's cache invalidations with other processes, so
+// a config or tariff change made through one service reaches the others.
+func (s *Store) SyncWith(syncer *cache.Syncer) {
+	s.syncer = syncer
+	syncer.Watch(s.cache)
+}
+
+// Resolve returns the effective config for a tenant. Tenants without
+// overrides get the deployment defaults.
+func (s *Store) Resolve(ctx context.Context, tenantID string) (*Config, error) {
+	return s.cache.Load(tenantID, func() (*Config, error) {
+		return s.resolve(ctx, tenantID)
+	})
+}
+
+func (s *Store) resolve(ctx context.Context, tenantID string) (*Config, error) {
+	overrides, err := s.loadOverrides(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := &Config{}
+	*resolved = *s.base
+	if overrides != nil {
+		resolved, err = overlay(s.base, overrides)
+		if err != nil {
+			return nil, fmt.Errorf("invalid overrides for tenant %s: %w", tenantID, err)
+		}
+	}
+	resolved.TenantID = tenantID
+	return resolved, nil
+}
+
+// ResolveAt returns the config a tenant had at a past time, built from the
+// overrides in force then. Only overrides are versioned: deployment
+// defaults are today's. It returns ErrBeforeHistory for a time before
+// history began for the tenant.
+func (s *Store) ResolveAt(ctx context.Context, tenantID string, at time.Time) (*Config, error) {
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT overrides FROM tenant_config_history
+		WHERE tenant_id = $1 AND changed_at <= $2
+		ORDER BY changed_at DESC
+		LIMIT 1
+	`, tenantID, at).Scan(&raw)
+	if err == sql.ErrNoRows {
+		// Before its first version the tenant had no overrides, unless
+		// that version is the one recorded when history began
+		var baseline bool
+		err = s.db.QueryRowContext(ctx, `
+			SELECT baseline FROM tenant_config_history WHERE tenant_id = $1 ORDER BY changed_at LIMIT 1
+		`, tenantID).Scan(&baseline)
+		if err == nil && baseline {
+			return nil, ErrBeforeHistory
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		raw = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	resolved := &Config{}
+	*resolved = *s.base
+	if raw != nil {
+		resolved, err = overlay(s.base, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid overrides for tenant %s: %w", tenantID, err)
+		}
+	}
+	resolved.TenantID = tenantID
+	return resolved, nil
+}
+
+// Overrides returns the raw overrides stored for a tenant.
+func (s *Store) Overrides(ctx context.Context, tenantID string) (map[string]interface{}, error) {
+	raw, err := s.loadOverrides(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]interface{}{}
+	if raw != nil {
+		if err := json.Unmarshal(raw, &overrides); err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+// SetOverrides replaces a tenant's overrides after checking they merge
+// cleanly onto the base config, and drops the cached copy.
+func (s *Store) SetOverrides(ctx context.Context, tenantID string, overrides map[string]interface{}, actorID string) error {
+	if _, err := s.saveOverrides(ctx, s.db, tenantID, overrides, actorID, time.Now()); err != nil {
+		return err
+	}
+
+	s.Invalidate(tenantID)
+	s.logger.Info("Tenant configuration updated", "tenant_id", tenantID, "updated_by", actorID)
+	return nil
+}
+
+// saveOverrides validates and stores a tenant's overrides as the version
+// in force from the given time, returning the merged config.
+func (s *Store) saveOverrides(ctx context.Context, db execer, tenantID string, overrides map[string]interface{},
+	actorID string, at time.Time) (*Config, error) {
+	raw, err := json.Marshal(overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := overlay(s.base, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOverrides, err)
+	}
+	if _, err := timebucket.LoadLocation(merged.Timezone); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOverrides, err)
+	}
+	if err := merged.validateThresholds(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOverrides, err)
+	}
+	if err := merged.validateAlertRouting(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOverrides, err)
+	}
+
+	// Every version is kept, so ResolveAt can look up past tariffs
+	query := `
+		WITH saved AS (
+			INSERT INTO tenant_configs (tenant_id, overrides, updated_by, updated_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (tenant_id)
+			DO UPDATE SET overrides = $2, updated_by = $3, updated_at = $4
+			RETURNING tenant_id, overrides, updated_by, updated_at
+		)
+		INSERT INTO tenant_config_history (tenant_id, overrides, changed_by, changed_at)
+		SELECT tenant_id, overrides, updated_by, updated_at FROM saved
+	`
+
+	if _, err := db.ExecContext(ctx, query, tenantID, raw, actorID, at); err != nil {
+		return nil, err
+	}
+	merged.TenantID = tenantID
+	return merged, nil
+}
+
+// Invalidate drops a tenant's cached config here and in other processes.
+func (s *Store) Invalidate(tenantID string) {
+	s.cache.Delete(tenantID)
+	s.syncer.Changed(context.Background(), cacheName)
+}
+
+func (s *Store) loadOverrides(ctx context.Context, tenantID string) ([]byte, error) {
+	return readOverrides(ctx, s.db, tenantID, false)
+}
+
+// readOverrides reads a tenant's raw overrides, or nil if it has none.
+// With lock, the row stays locked until the transaction ends.
+func readOverrides(ctx context.Context, db querier, tenantID string, lock bool) ([]byte, error) {
+	query := `SELECT overrides FROM tenant_configs WHERE tenant_id = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	var raw []byte
+	err := db.QueryRowContext(ctx, query, tenantID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
@@ -0,0 +1,469 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tariff change statuses
+const (
+	TariffScheduled  = "scheduled"
+	TariffApplied    = "applied"
+	TariffCancelled  = "cancelled"
+	TariffRolledBack = "rolled_back"
+	TariffFailed     = "failed"
+)
+
+var (
+	ErrTariffChangeNotFound = errors.New("tariff change not found")
+	ErrNotScheduled         = errors.New("only a scheduled tariff change can be cancelled")
+	ErrNotLatest            = errors.New("only the latest applied tariff change can be rolled back")
+)
+
+// TariffChange is one change to a tenant's tariffs, with who made it, why
+// and from when it applies. Tariffs holds the change as submitted, merged
+// onto the tariffs in force when it is applied; a rollback's Tariffs
+// replace them instead. Diff is filled in once the change is applied.
+type TariffChange struct {
+	ID           string                 `json:"id"`
+	Status       string                 `json:"status"`
+	Tariffs      map[string]interface{} `json:"tariffs"`
+	Reason       string                 `json:"reason"`
+	EffectiveAt  time.Time              `json:"effective_at"`
+	CreatedBy    string                 `json:"created_by"`
+	CreatedAt    time.Time              `json:"created_at"`
+	AppliedAt    *time.Time             `json:"applied_at,omitempty"`
+	Diff         []TariffDiff           `json:"diff,omitempty"`
+	RollbackOf   string                 `json:"rollback_of,omitempty"`
+	RolledBackBy string                 `json:"rolled_back_by,omitempty"`
+	CancelledBy  string                 `json:"cancelled_by,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+// TariffDiff is a tariff field a change altered. Field is a path such as
+// late_fee.rate.
+type TariffDiff struct {
+	Utility string      `json:"utility"`
+	Field   string      `json:"field"`
+	Before  interface{} `json:"before"`
+	After   interface{} `json:"after"`
+}
+
+// Utilities lists the utilities whose tariffs the change altered.
+func (c *TariffChange) Utilities() []string {
+	var utilities []string
+	seen := map[string]bool{}
+	for _, diff := range c.Diff {
+		if !seen[diff.Utility] {
+			seen[diff.Utility] = true
+			utilities = append(utilities, diff.Utility)
+		}
+	}
+	return utilities
+}
+
+const tariffChangeColumns = `id, status, tariffs, reason, effective_at, created_by::text, created_at, applied_at,
+	COALESCE(tariffs_before, 'null'), COALESCE(tariffs_after, 'null'), COALESCE(rollback_of::text, ''),
+	COALESCE(rolled_back_by::text, ''), COALESCE(cancelled_by::text, ''), COALESCE(error, '')`
+
+// ChangeTariffs records a change to a tenant's tariffs. A change effective
+// now or earlier (or with no effective time) is applied at once; a later
+// one is scheduled for ApplyDueTariffChanges. Either way it must merge
+// cleanly onto the current overrides.
+func (s *Store) ChangeTariffs(ctx context.Context, tenantID string, tariffs map[string]interface{},
+	reason string, effectiveAt time.Time, actorID string) (*TariffChange, error) {
+	overrides, err := s.Overrides(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.mergeTariffs(overrides, tariffs, false); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	immediate := !effectiveAt.After(now)
+	if immediate {
+		effectiveAt = now
+	}
+
+	return s.recordTariffChange(ctx, tenantID, tariffs, reason, effectiveAt, actorID, "", immediate)
+}
+
+// recordTariffChange inserts a change, applying it in the same transaction
+// if immediate.
+func (s *Store) recordTariffChange(ctx context.Context, tenantID string, tariffs map[string]interface{},
+	reason string, effectiveAt time.Time, actorID, rollbackOf string, immediate bool) (*TariffChange, error) {
+	encoded, err := json.Marshal(tariffs)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO tariff_changes (tenant_id, tariffs, reason, effective_at, created_by, rollback_of)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid)
+		RETURNING id
+	`, tenantID, encoded, reason, effectiveAt, actorID, rollbackOf).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	if immediate {
+		change, err := scanTariffChange(tx.QueryRowContext(ctx,
+			`SELECT `+tariffChangeColumns+` FROM tariff_changes WHERE id = $1`, id))
+		if err != nil {
+			return nil, err
+		}
+		if err := s.applyTariffChange(ctx, tx, tenantID, change); err != nil {
+			return nil, err
+		}
+	}
+	if rollbackOf != "" {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE tariff_changes SET status = $2, rolled_back_by = $3 WHERE id = $1
+		`, rollbackOf, TariffRolledBack, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if immediate {
+		s.Invalidate(tenantID)
+		s.logger.Info("Tariffs changed", "tenant_id", tenantID, "tariff_change_id", id, "changed_by", actorID)
+	}
+	return s.TariffChange(ctx, tenantID, id)
+}
+
+// applyTariffChange writes a change into the tenant's overrides as the
+// version in force from its effective time, so bills for periods ending
+// after it use the new tariffs even if it was applied late.
+func (s *Store) applyTariffChange(ctx context.Context, tx *sql.Tx, tenantID string, change *TariffChange) error {
+	raw, err := readOverrides(ctx, tx, tenantID, true)
+	if err != nil {
+		return err
+	}
+	overrides := map[string]interface{}{}
+	if raw != nil {
+		if err := json.Unmarshal(raw, &overrides); err != nil {
+			return err
+		}
+	}
+
+	before, err := overlay(s.base, orEmpty(raw))
+	if err != nil {
+		return err
+	}
+	previous, err := json.Marshal(overrides["tariffs"])
+	if err != nil {
+		return err
+	}
+
+	overrides, err = s.mergeTariffs(overrides, change.Tariffs, change.RollbackOf != "")
+	if err != nil {
+		return err
+	}
+	after, err := s.saveOverrides(ctx, tx, tenantID, overrides, change.CreatedBy, change.EffectiveAt)
+	if err != nil {
+		return err
+	}
+
+	beforeJSON, _ := json.Marshal(before.Tariffs)
+	afterJSON, _ := json.Marshal(after.Tariffs)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE tariff_changes
+		SET status = $2, applied_at = NOW(), previous_tariffs = $3, tariffs_before = $4, tariffs_after = $5
+		WHERE id = $1
+	`, change.ID, TariffApplied, previous, beforeJSON, afterJSON)
+	return err
+}
+
+// mergeTariffs returns the overrides with tariffs merged into, or with
+// replace in place of, their tariffs, after checking the result is valid.
+func (s *Store) mergeTariffs(overrides, tariffs map[string]interface{}, replace bool) (map[string]interface{}, error) {
+	// Work on a copy so a rejected change leaves overrides untouched
+	encoded, err := json.Marshal(overrides)
+	if err != nil {
+		return nil, err
+	}
+	merged := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &merged); err != nil {
+		return nil, err
+	}
+
+	existing, _ := merged["tariffs"].(map[string]interface{})
+	if existing == nil || replace {
+		existing = map[string]interface{}{}
+	}
+	mergeMaps(existing, tariffs)
+	merged["tariffs"] = existing
+
+	encoded, err = json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := overlay(s.base, encoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOverrides, err)
+	}
+	return merged, nil
+}
+
+// ApplyDueTariffChanges applies scheduled changes whose effective time has
+// come, oldest first. Replicas share the work. A change that no longer
+// merges cleanly is marked failed.
+func (s *Store) ApplyDueTariffChanges(ctx context.Context) error {
+	for ctx.Err() == nil {
+		found, err := s.applyNextTariffChange(ctx)
+		if err != nil || !found {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *Store) applyNextTariffChange(ctx context.Context) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var tenantID string
+	change, err := scanTariffChange(tx.QueryRowContext(ctx, `
+		SELECT `+tariffChangeColumns+`, tenant_id
+		FROM tariff_changes
+		WHERE status = $1 AND effective_at <= NOW()
+		ORDER BY effective_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, TariffScheduled), &tenantID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = s.applyTariffChange(ctx, tx, tenantID, change)
+	if errors.Is(err, ErrInvalidOverrides) {
+		tx.Rollback()
+		s.logger.Error("Scheduled tariff change no longer applies", "error", err, "tenant_id", tenantID,
+			"tariff_change_id", change.ID)
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE tariff_changes SET status = $2, error = $3 WHERE id = $1 AND status = $4
+		`, change.ID, TariffFailed, err.Error(), TariffScheduled)
+		return true, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	s.Invalidate(tenantID)
+	s.logger.Info("Scheduled tariff change applied", "tenant_id", tenantID, "tariff_change_id", change.ID,
+		"effective_at", change.EffectiveAt)
+	return true, nil
+}
+
+// CancelTariffChange cancels a change that hasn't been applied yet.
+func (s *Store) CancelTariffChange(ctx context.Context, tenantID, id, actorID string) (*TariffChange, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE tariff_changes SET status = $3, cancelled_by = $4
+		WHERE id::text = $1 AND tenant_id = $2 AND status = $5
+	`, id, tenantID, TariffCancelled, actorID, TariffScheduled)
+	if err != nil {
+		return nil, err
+	}
+	if cancelled, _ := result.RowsAffected(); cancelled == 0 {
+		if _, err := s.TariffChange(ctx, tenantID, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotScheduled
+	}
+	return s.TariffChange(ctx, tenantID, id)
+}
+
+// RollbackTariffChange restores the tariffs a change replaced, as a new
+// change effective now. Only the tenant's latest applied change can be
+// rolled back, so later changes are never silently undone; callers check
+// no bills were generated against it first.
+func (s *Store) RollbackTariffChange(ctx context.Context, tenantID, id, reason, actorID string) (*TariffChange, error) {
+	var status, latestID string
+	var previous []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT status, COALESCE(previous_tariffs, 'null'), (
+			SELECT id::text FROM tariff_changes
+			WHERE tenant_id = $2 AND status IN ($3, $4)
+			ORDER BY applied_at DESC
+			LIMIT 1
+		)
+		FROM tariff_changes
+		WHERE id::text = $1 AND tenant_id = $2
+	`, id, tenantID, TariffApplied, TariffRolledBack).Scan(&status, &previous, &latestID)
+	if err == sql.ErrNoRows {
+		return nil, ErrTariffChangeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != TariffApplied || latestID != id {
+		return nil, ErrNotLatest
+	}
+
+	var tariffs map[string]interface{}
+	if err := json.Unmarshal(previous, &tariffs); err != nil {
+		return nil, err
+	}
+	if tariffs == nil {
+		// The tenant had no tariff overrides before the change
+		tariffs = map[string]interface{}{}
+	}
+	return s.recordTariffChange(ctx, tenantID, tariffs, reason, time.Now(), actorID, id, true)
+}
+
+// TariffChange returns one of the tenant's tariff changes, or
+// ErrTariffChangeNotFound.
+func (s *Store) TariffChange(ctx context.Context, tenantID, id string) (*TariffChange, error) {
+	change, err := scanTariffChange(s.db.QueryRowContext(ctx,
+		`SELECT `+tariffChangeColumns+` FROM tariff_changes WHERE id::text = $1 AND tenant_id = $2`,
+		id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTariffChangeNotFound
+	}
+	return change, err
+}
+
+// TariffHistory returns the tenant's latest tariff changes, newest first,
+// including scheduled ones.
+func (s *Store) TariffHistory(ctx context.Context, tenantID string, limit int) ([]*TariffChange, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+tariffChangeColumns+`
+		FROM tariff_changes
+		WHERE tenant_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, tenantID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*TariffChange{}
+	for rows.Next() {
+		change, err := scanTariffChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTariffChange(row rowScanner, extra ...interface{}) (*TariffChange, error) {
+	var change TariffChange
+	var tariffs, before, after []byte
+	dest := append([]interface{}{
+		&change.ID, &change.Status, &tariffs, &change.Reason, &change.EffectiveAt, &change.CreatedBy,
+		&change.CreatedAt, &change.AppliedAt, &before, &after, &change.RollbackOf, &change.RolledBackBy,
+		&change.CancelledBy, &change.Error,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(tariffs, &change.Tariffs); err != nil {
+		return nil, err
+	}
+	if change.AppliedAt != nil {
+		var beforeTariffs, afterTariffs map[string]interface{}
+		if err := json.Unmarshal(before, &beforeTariffs); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(after, &afterTariffs); err != nil {
+			return nil, err
+		}
+		change.Diff = diffTariffs(beforeTariffs, afterTariffs)
+	}
+	return &change, nil
+}
+
+// diffTariffs lists the fields that differ between two sets of effective
+// tariffs, by utility and field.
+func diffTariffs(before, after map[string]interface{}) []TariffDiff {
+	beforeFields, afterFields := map[string]interface{}{}, map[string]interface{}{}
+	flatten("", before, beforeFields)
+	flatten("", after, afterFields)
+
+	paths := map[string]bool{}
+	for path := range beforeFields {
+		paths[path] = true
+	}
+	for path := range afterFields {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	diffs := []TariffDiff{}
+	for _, path := range sorted {
+		if reflect.DeepEqual(beforeFields[path], afterFields[path]) {
+			continue
+		}
+		utility, field := path, ""
+		if i := strings.IndexByte(path, '.'); i >= 0 {
+			utility, field = path[:i], path[i+1:]
+		}
+		diffs = append(diffs, TariffDiff{
+			Utility: utility,
+			Field:   field,
+			Before:  beforeFields[path],
+			After:   afterFields[path],
+		})
+	}
+	return diffs
+}
+
+// flatten collects the leaf values of nested objects by dotted path.
+func flatten(prefix string, value map[string]interface{}, fields map[string]interface{}) {
+	for key, v := range value {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(path, nested, fields)
+			continue
+		}
+		fields[path] = v
+	}
+}
+
+func orEmpty(raw []byte) []byte {
+	if raw == nil {
+		return []byte("{}")
+	}
+	return raw
+}
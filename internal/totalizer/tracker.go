@@ -0,0 +1,101 @@
+// Package totalizer watches cumulative meter registers for decreases. A
+// register should only ever count up, so a drop means the meter wrapped
+// around, was reset or replaced, or has been tampered with.
+package totalizer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/devicetype"
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+// Outcomes of comparing a reading with the previous one
+const (
+	OK       = "ok"
+	Rollover = "rollover"        // the register wrapped past RolloverAt
+	Reset    = "meter_reset"     // the register dropped to zero
+	Tamper   = "possible_tamper" // the register dropped unexpectedly
+)
+
+// stateTTL keeps a device's last reading across long outages; a meter
+// silent for longer starts afresh.
+const stateTTL = 90 * 24 * time.Hour
+
+// maxRolloverFraction bounds the consumption a rollover may imply, as a
+// fraction of the register's range. A register a few units short of
+// wrapping that then reads a few units is a rollover; one that drops from
+// halfway to zero is not.
+const maxRolloverFraction = 0.1
+
+// observeScript stores a reading unless a later one is already stored, and
+// returns what was stored before as "timestamp_ms:value". Readings that
+// arrive out of order are thus compared with nothing rather than with a
+// later value.
+const observeScript = `
+local previous = redis.call('GET', KEYS[1])
+if previous then
+	local at = tonumber(string.match(previous, '^(%d+):'))
+	if at and at >= tonumber(ARGV[1]) then
+		return 'stale'
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1] .. ':' .. ARGV[2], 'EX', ARGV[3])
+return previous
+`
+
+// Tracker keeps each device's last totalizer reading in Redis.
+type Tracker struct {
+	redis *database.RedisClient
+}
+
+func NewTracker(redis *database.RedisClient) *Tracker {
+	return &Tracker{redis: redis}
+}
+
+// Observe records a reading and returns the reading it replaced. ok is
+// false if there was none, or if the reading is older than the one stored.
+func (t *Tracker) Observe(ctx context.Context, deviceID, metric string, at time.Time, value float64) (previous float64, ok bool, err error) {
+	reply, err := t.redis.Eval(ctx, observeScript, []string{key(deviceID, metric)},
+		at.UnixMilli(), strconv.FormatFloat(value, 'g', -1, 64), int(stateTTL.Seconds()))
+	if err != nil && !database.IsMiss(err) {
+		return 0, false, err
+	}
+
+	stored, _ := reply.(string)
+	if stored == "" || stored == "stale" {
+		return 0, false, nil
+	}
+	_, raw, found := strings.Cut(stored, ":")
+	if !found {
+		return 0, false, fmt.Errorf("malformed totalizer state %q", stored)
+	}
+	previous, err = strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("malformed totalizer state %q", stored)
+	}
+	return previous, true, nil
+}
+
+// Classify compares a reading with the previous one from the same register.
+func Classify(spec devicetype.Totalizer, previous, current float64) string {
+	if previous-current <= spec.Tolerance {
+		return OK
+	}
+	if spec.RolloverAt > 0 && previous <= spec.RolloverAt &&
+		spec.RolloverAt-previous+current <= spec.RolloverAt*maxRolloverFraction {
+		return Rollover
+	}
+	if current <= spec.Tolerance {
+		return Reset
+	}
+	return Tamper
+}
+
+func key(deviceID, metric string) string {
+	return fmt.Sprintf("device_totalizer:%s:%s", deviceID, metric)
+}
@@ -0,0 +1,18 @@
+-- Login history for anomaly detection (new IP/device, impossible travel)
+CREATE TABLE login_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL,
+    ip_address INET,
+    user_agent TEXT NOT NULL DEFAULT '',
+    country VARCHAR(100) NOT NULL DEFAULT '',
+    city VARCHAR(255) NOT NULL DEFAULT '',
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    suspicious BOOLEAN DEFAULT false,
+    reasons JSONB DEFAULT '[]',
+    success BOOLEAN NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_login_history_user_created ON login_history(user_id, created_at DESC);
@@ -0,0 +1,4 @@
+-- Persist account lockout state so it survives a Redis flush
+ALTER TABLE users
+    ADD COLUMN failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN locked_until TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,5 @@
+ALTER TABLE notifications DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE alerts DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE devices DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,26 @@
+-- Tenants (municipalities) sharing a deployment
+CREATE TABLE tenants (
+    id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Existing rows are assigned to a default tenant
+INSERT INTO tenants (id, name) VALUES ('default', 'Default Municipality');
+
+ALTER TABLE users ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' REFERENCES tenants(id);
+ALTER TABLE devices ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' REFERENCES tenants(id);
+ALTER TABLE alerts ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' REFERENCES tenants(id);
+ALTER TABLE notifications ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' REFERENCES tenants(id);
+
+CREATE INDEX idx_users_tenant ON users(tenant_id);
+CREATE INDEX idx_devices_tenant ON devices(tenant_id);
+CREATE INDEX idx_alerts_tenant ON alerts(tenant_id);
+CREATE INDEX idx_notifications_tenant ON notifications(tenant_id);
+
+CREATE TRIGGER update_tenants_updated_at
+    BEFORE UPDATE ON tenants
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_trigger();
@@ -0,0 +1,8 @@
+-- Per-tenant overrides layered onto the deployment defaults
+CREATE TABLE tenant_configs (
+    tenant_id VARCHAR(64) PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    overrides JSONB NOT NULL DEFAULT '{}',
+    updated_by UUID,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    FOREIGN KEY (updated_by) REFERENCES users(id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE devices
+    DROP COLUMN IF EXISTS zone,
+    DROP COLUMN IF EXISTS ward;
@@ -0,0 +1,7 @@
+-- Administrative grouping used for map overviews and bulk operations
+ALTER TABLE devices
+    ADD COLUMN ward VARCHAR(100),
+    ADD COLUMN zone VARCHAR(100);
+
+CREATE INDEX idx_devices_tenant_ward ON devices(tenant_id, ward);
+CREATE INDEX idx_devices_tenant_zone ON devices(tenant_id, zone);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS bill_adjustments;
+DROP TABLE IF EXISTS bill_disputes;
+DROP TABLE IF EXISTS bills;
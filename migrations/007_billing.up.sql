@@ -0,0 +1,64 @@
+-- Utility bills, one per meter per billing period
+CREATE TABLE bills (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' REFERENCES tenants(id),
+    user_id UUID NOT NULL REFERENCES users(id),
+    device_id VARCHAR(255) REFERENCES devices(id),
+    utility_type VARCHAR(50) NOT NULL,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    consumption DECIMAL(14, 3) NOT NULL DEFAULT 0,
+    amount DECIMAL(12, 2) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_bills_tenant_user ON bills(tenant_id, user_id);
+CREATE INDEX idx_bills_status ON bills(status);
+
+-- Citizen disputes against a bill
+CREATE TABLE bill_disputes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    bill_id UUID NOT NULL REFERENCES bills(id),
+    user_id UUID NOT NULL REFERENCES users(id),
+    reason TEXT NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'open',
+    resolution TEXT,
+    anomaly_ids JSONB DEFAULT '[]',
+    reviewed_by UUID REFERENCES users(id),
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_bill_disputes_tenant_status ON bill_disputes(tenant_id, status);
+CREATE INDEX idx_bill_disputes_bill ON bill_disputes(bill_id);
+
+-- Only one dispute per bill may be in progress at a time
+CREATE UNIQUE INDEX idx_bill_disputes_active ON bill_disputes(bill_id)
+    WHERE status IN ('open', 'under_review');
+
+-- Post-generation changes to a bill's amount; negative amounts are credits
+CREATE TABLE bill_adjustments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    bill_id UUID NOT NULL REFERENCES bills(id),
+    dispute_id UUID REFERENCES bill_disputes(id),
+    amount DECIMAL(12, 2) NOT NULL,
+    reason TEXT NOT NULL,
+    created_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_bill_adjustments_bill ON bill_adjustments(bill_id);
+
+CREATE TRIGGER update_bills_updated_at
+    BEFORE UPDATE ON bills
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_trigger();
+
+CREATE TRIGGER update_bill_disputes_updated_at
+    BEFORE UPDATE ON bill_disputes
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_trigger();
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS payment_plan_installments;
+DROP TABLE IF EXISTS payment_plans;
+DROP TABLE IF EXISTS payments;
+ALTER TABLE bills DROP COLUMN IF EXISTS amount_paid;
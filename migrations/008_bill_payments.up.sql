@@ -0,0 +1,48 @@
+-- Bills can be settled in several payments
+ALTER TABLE bills ADD COLUMN amount_paid DECIMAL(12, 2) NOT NULL DEFAULT 0;
+
+CREATE TABLE payments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    bill_id UUID NOT NULL REFERENCES bills(id),
+    user_id UUID NOT NULL REFERENCES users(id),
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    payment_method VARCHAR(50) NOT NULL,
+    -- Provider reference; unique so a retried callback can't be applied twice
+    transaction_id VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_payments_bill ON payments(bill_id);
+
+-- Installment plans agreed for a bill
+CREATE TABLE payment_plans (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    bill_id UUID NOT NULL REFERENCES bills(id),
+    user_id UUID NOT NULL REFERENCES users(id),
+    status VARCHAR(50) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_payment_plans_active ON payment_plans(bill_id) WHERE status = 'active';
+
+CREATE TABLE payment_plan_installments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    plan_id UUID NOT NULL REFERENCES payment_plans(id) ON DELETE CASCADE,
+    sequence INTEGER NOT NULL,
+    due_date DATE NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    amount_paid DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    reminded_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (plan_id, sequence)
+);
+
+CREATE INDEX idx_installments_due ON payment_plan_installments(due_date) WHERE status = 'pending';
+
+CREATE TRIGGER update_payment_plans_updated_at
+    BEFORE UPDATE ON payment_plans
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_trigger();
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS bill_reminders;
+DROP TABLE IF EXISTS bill_late_fees;
+ALTER TABLE bills
+    DROP COLUMN IF EXISTS late_fees,
+    DROP COLUMN IF EXISTS due_date;
@@ -0,0 +1,27 @@
+ALTER TABLE bills
+    ADD COLUMN due_date DATE,
+    ADD COLUMN late_fees DECIMAL(12, 2) NOT NULL DEFAULT 0;
+
+-- Existing bills get the default payment window
+UPDATE bills SET due_date = period_end + 15 WHERE due_date IS NULL;
+ALTER TABLE bills ALTER COLUMN due_date SET NOT NULL;
+
+CREATE INDEX idx_bills_due_date ON bills(due_date) WHERE status IN ('pending', 'partially_paid');
+
+-- One row per late fee charged; the sequence makes each charge idempotent
+CREATE TABLE bill_late_fees (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    bill_id UUID NOT NULL REFERENCES bills(id),
+    sequence INTEGER NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (bill_id, sequence)
+);
+
+-- Due-date reminders already sent, keyed by days relative to the due date
+CREATE TABLE bill_reminders (
+    bill_id UUID NOT NULL REFERENCES bills(id),
+    offset_days INTEGER NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (bill_id, offset_days)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS fk_devices_type;
+ALTER TABLE devices DROP COLUMN IF EXISTS configuration;
+DROP TABLE IF EXISTS device_types;
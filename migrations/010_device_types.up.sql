@@ -0,0 +1,37 @@
+-- Per-type defaults and configuration rules applied when devices are created
+CREATE TABLE device_types (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    default_configuration JSONB NOT NULL DEFAULT '{}',
+    config_schema JSONB NOT NULL DEFAULT '{}',
+    updated_by UUID REFERENCES users(id),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE devices ADD COLUMN configuration JSONB NOT NULL DEFAULT '{}';
+
+INSERT INTO device_types (name, description, default_configuration, config_schema) VALUES
+('water_sensor', 'Water flow and quality sensor',
+    '{"measurement_interval_seconds": 60, "reporting_interval_seconds": 300, "flow_rate_max": 1000, "pressure_min": 1.0}',
+    '{"measurement_interval_seconds": {"type": "integer", "required": true, "min": 10, "max": 3600},
+      "reporting_interval_seconds": {"type": "integer", "required": true, "min": 60, "max": 86400},
+      "flow_rate_max": {"type": "number", "min": 0},
+      "pressure_min": {"type": "number", "min": 0}}'),
+('electricity_meter', 'Smart electricity meter',
+    '{"measurement_interval_seconds": 60, "reporting_interval_seconds": 900, "current_max": 100, "voltage_nominal": 230}',
+    '{"measurement_interval_seconds": {"type": "integer", "required": true, "min": 10, "max": 3600},
+      "reporting_interval_seconds": {"type": "integer", "required": true, "min": 60, "max": 86400},
+      "current_max": {"type": "number", "min": 0},
+      "voltage_nominal": {"type": "number", "enum": [110, 120, 220, 230, 240]}}'),
+('traffic_camera', 'Traffic monitoring camera',
+    '{"frame_rate": 15, "resolution": "1080p", "reporting_interval_seconds": 60}',
+    '{"frame_rate": {"type": "integer", "required": true, "min": 1, "max": 60},
+      "resolution": {"type": "string", "enum": ["720p", "1080p", "4k"]},
+      "reporting_interval_seconds": {"type": "integer", "required": true, "min": 10, "max": 3600}}');
+
+-- Types already in use get a registry entry without defaults
+INSERT INTO device_types (name)
+SELECT DISTINCT type FROM devices
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE devices ADD CONSTRAINT fk_devices_type FOREIGN KEY (type) REFERENCES device_types(name);
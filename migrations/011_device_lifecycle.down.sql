@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS device_status_history;
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS chk_devices_status;
+ALTER TABLE devices ALTER COLUMN status DROP NOT NULL;
+ALTER TABLE devices ALTER COLUMN status SET DEFAULT 'active';
@@ -0,0 +1,20 @@
+-- Statuses outside the lifecycle predate it; treat those devices as in service
+UPDATE devices SET status = 'active'
+WHERE status IS NULL OR status NOT IN ('provisioned', 'installed', 'active', 'maintenance', 'decommissioned');
+
+ALTER TABLE devices ALTER COLUMN status SET DEFAULT 'provisioned';
+ALTER TABLE devices ALTER COLUMN status SET NOT NULL;
+ALTER TABLE devices ADD CONSTRAINT chk_devices_status
+    CHECK (status IN ('provisioned', 'installed', 'active', 'maintenance', 'decommissioned'));
+
+CREATE TABLE device_status_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id),
+    from_status VARCHAR(50) NOT NULL,
+    to_status VARCHAR(50) NOT NULL,
+    reason TEXT,
+    changed_by UUID NOT NULL REFERENCES users(id),
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_device_status_history_device ON device_status_history(device_id, changed_at DESC);
@@ -0,0 +1,8 @@
+-- Canonical unit per metric; telemetry is converted to these before storage
+ALTER TABLE device_types ADD COLUMN metric_units JSONB NOT NULL DEFAULT '{}';
+
+UPDATE device_types SET metric_units = '{"flow_rate": "L/min", "volume": "L", "pressure": "bar", "temperature": "°C"}'
+WHERE name = 'water_sensor';
+
+UPDATE device_types SET metric_units = '{"energy": "kWh", "power": "kW", "voltage": "V", "current": "A"}'
+WHERE name = 'electricity_meter';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_devices_parent;
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS chk_devices_parent_not_self;
+ALTER TABLE devices DROP COLUMN IF EXISTS parent_device_id;
@@ -0,0 +1,5 @@
+-- Sub-meters and sensors reporting through a gateway device
+ALTER TABLE devices ADD COLUMN parent_device_id VARCHAR(255) REFERENCES devices(id);
+ALTER TABLE devices ADD CONSTRAINT chk_devices_parent_not_self CHECK (parent_device_id <> id);
+
+CREATE INDEX idx_devices_parent ON devices(parent_device_id) WHERE parent_device_id IS NOT NULL;
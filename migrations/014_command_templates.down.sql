@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_device_commands_sequence_step;
+ALTER TABLE device_commands DROP COLUMN IF EXISTS step;
+ALTER TABLE device_commands DROP COLUMN IF EXISTS sequence_id;
+DROP TABLE IF EXISTS command_sequences;
+DROP TABLE IF EXISTS command_templates;
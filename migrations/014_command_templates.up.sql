@@ -0,0 +1,51 @@
+-- Command history; earlier deployments may already have this table
+CREATE TABLE IF NOT EXISTS device_commands (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id),
+    command VARCHAR(100) NOT NULL,
+    parameters JSONB DEFAULT '{}',
+    timestamp TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    status VARCHAR(50) NOT NULL
+);
+
+-- Reusable multi-step command sequences per device type
+CREATE TABLE command_templates (
+    device_type VARCHAR(100) NOT NULL REFERENCES device_types(name),
+    name VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    parameters JSONB NOT NULL DEFAULT '[]',
+    steps JSONB NOT NULL,
+    updated_by UUID REFERENCES users(id),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (device_type, name)
+);
+
+CREATE TABLE command_sequences (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id),
+    template VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    current_step INTEGER NOT NULL DEFAULT 0,
+    total_steps INTEGER NOT NULL,
+    next_step_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_command_sequences_due ON command_sequences(next_step_at) WHERE status IN ('pending', 'running');
+CREATE INDEX idx_command_sequences_device ON command_sequences(device_id, created_at DESC);
+
+ALTER TABLE device_commands ADD COLUMN sequence_id UUID REFERENCES command_sequences(id);
+ALTER TABLE device_commands ADD COLUMN step INTEGER;
+
+CREATE UNIQUE INDEX idx_device_commands_sequence_step ON device_commands(sequence_id, step) WHERE sequence_id IS NOT NULL;
+
+INSERT INTO command_templates (device_type, name, description, parameters, steps) VALUES
+('water_sensor', 'calibrate', 'Recalibrate the flow sensor against a reference reading',
+    '[{"name": "reference_flow", "required": true}, {"name": "settle_seconds", "default": 30}]',
+    '[{"command": "set_mode", "parameters": {"mode": "calibration"}},
+      {"command": "wait", "parameters": {"seconds": "{{settle_seconds}}"}},
+      {"command": "read_calibration", "parameters": {"reference_flow": "{{reference_flow}}"}},
+      {"command": "set_mode", "parameters": {"mode": "normal"}}]');
@@ -0,0 +1,33 @@
+-- Recurring commands for one device or a group of devices
+CREATE TABLE command_schedules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    name VARCHAR(255) NOT NULL,
+    cron VARCHAR(100) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    command VARCHAR(100) NOT NULL,
+    parameters JSONB NOT NULL DEFAULT '{}',
+    device_id VARCHAR(255) REFERENCES devices(id),
+    device_type VARCHAR(100) REFERENCES device_types(name),
+    ward VARCHAR(100),
+    zone VARCHAR(100),
+    misfire_policy VARCHAR(20) NOT NULL DEFAULT 'fire_once',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    next_run_at TIMESTAMP WITH TIME ZONE,
+    last_run_at TIMESTAMP WITH TIME ZONE,
+    running_since TIMESTAMP WITH TIME ZONE,
+    created_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT chk_command_schedules_target
+        CHECK (device_id IS NOT NULL OR device_type IS NOT NULL OR ward IS NOT NULL OR zone IS NOT NULL),
+    CONSTRAINT chk_command_schedules_misfire CHECK (misfire_policy IN ('fire_once', 'skip'))
+);
+
+CREATE INDEX idx_command_schedules_tenant ON command_schedules(tenant_id);
+CREATE INDEX idx_command_schedules_due ON command_schedules(next_run_at) WHERE enabled;
+
+CREATE TRIGGER update_command_schedules_updated_at
+    BEFORE UPDATE ON command_schedules
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_trigger();
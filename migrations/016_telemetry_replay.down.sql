@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS replay_jobs;
+DROP INDEX IF EXISTS idx_anomalies_reading;
@@ -0,0 +1,48 @@
+-- Anomalies raised by device processing; earlier deployments may already have this table
+CREATE TABLE IF NOT EXISTS anomalies (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id),
+    type VARCHAR(100) NOT NULL,
+    severity VARCHAR(20) NOT NULL,
+    description TEXT,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+    value DOUBLE PRECISION,
+    metadata JSONB DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- One anomaly per reading, so redelivered or replayed telemetry never raises it twice
+DELETE FROM anomalies a USING anomalies b
+WHERE a.ctid > b.ctid AND a.device_id = b.device_id AND a.type = b.type AND a.timestamp = b.timestamp;
+
+CREATE UNIQUE INDEX idx_anomalies_reading ON anomalies(device_id, type, timestamp);
+
+-- Reprocessing of historical telemetry through the current detectors
+CREATE TABLE replay_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    device_id VARCHAR(255),
+    device_type VARCHAR(100),
+    range_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    range_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    cursor_timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+    cursor_device_id VARCHAR(255) NOT NULL DEFAULT '',
+    rows_processed BIGINT NOT NULL DEFAULT 0,
+    anomalies_found INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    heartbeat_at TIMESTAMP WITH TIME ZONE,
+    created_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT chk_replay_jobs_range CHECK (range_start < range_end)
+);
+
+-- A tenant replays one range at a time
+CREATE UNIQUE INDEX idx_replay_jobs_active ON replay_jobs(tenant_id) WHERE status IN ('pending', 'running');
+
+CREATE TRIGGER update_replay_jobs_updated_at
+    BEFORE UPDATE ON replay_jobs
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_trigger();
@@ -0,0 +1 @@
+ALTER TABLE device_types DROP COLUMN IF EXISTS sampling;
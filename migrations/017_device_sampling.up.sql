@@ -0,0 +1,2 @@
+-- Optional per-type downsampling of telemetry at ingestion; NULL stores raw readings
+ALTER TABLE device_types ADD COLUMN sampling JSONB;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_alerts_device;
+DROP INDEX IF EXISTS idx_alerts_tenant_open;
+ALTER TABLE alerts DROP COLUMN IF EXISTS resolved_by;
@@ -0,0 +1,4 @@
+ALTER TABLE alerts ADD COLUMN resolved_by UUID REFERENCES users(id);
+
+CREATE INDEX idx_alerts_tenant_open ON alerts(tenant_id, created_at DESC) WHERE NOT resolved;
+CREATE INDEX idx_alerts_device ON alerts(device_id, type) WHERE NOT resolved;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_notifications_digest_queue;
+ALTER TABLE notifications DROP COLUMN IF EXISTS digest_id;
+ALTER TABLE users DROP COLUMN IF EXISTS notification_digest;
@@ -0,0 +1,9 @@
+-- Users may receive non-urgent notifications as a periodic digest instead
+-- of one message each
+ALTER TABLE users ADD COLUMN notification_digest VARCHAR(16) NOT NULL DEFAULT 'immediate'
+    CHECK (notification_digest IN ('immediate', 'hourly', 'daily'));
+
+-- Queued notifications point at the digest that delivered them
+ALTER TABLE notifications ADD COLUMN digest_id UUID REFERENCES notifications(id);
+
+CREATE INDEX idx_notifications_digest_queue ON notifications(user_id, created_at) WHERE status = 'queued_digest';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_notifications_resend_of;
+ALTER TABLE notifications DROP COLUMN IF EXISTS resend_of;
@@ -0,0 +1,4 @@
+-- Resends requested by support are new notifications linked to the original
+ALTER TABLE notifications ADD COLUMN resend_of UUID REFERENCES notifications(id);
+
+CREATE INDEX idx_notifications_resend_of ON notifications(resend_of) WHERE resend_of IS NOT NULL;
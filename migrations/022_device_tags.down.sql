@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_devices_tags;
+ALTER TABLE devices DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,4 @@
+-- Free-form labels for grouping devices beyond ward and zone
+ALTER TABLE devices ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_devices_tags ON devices USING GIN(tags);
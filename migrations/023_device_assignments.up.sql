@@ -0,0 +1,11 @@
+-- Devices a citizen may see, such as the meters at their address
+CREATE TABLE device_assignments (
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    assigned_by UUID REFERENCES users(id),
+    assigned_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_id, user_id)
+);
+
+CREATE INDEX idx_device_assignments_user ON device_assignments(user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS consumption_budget_alerts;
+DROP TABLE IF EXISTS consumption_budgets;
@@ -0,0 +1,24 @@
+-- Monthly consumption budgets citizens set per utility
+CREATE TABLE consumption_budgets (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    utility_type VARCHAR(50) NOT NULL,
+    monthly_limit DECIMAL(14, 3) NOT NULL CHECK (monthly_limit > 0),
+    thresholds INTEGER[] NOT NULL DEFAULT '{80,100}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (user_id, utility_type)
+);
+
+-- Thresholds already notified, so each fires at most once a month. The
+-- limit in force is kept so a raised budget can re-arm a threshold.
+CREATE TABLE consumption_budget_alerts (
+    budget_id UUID NOT NULL REFERENCES consumption_budgets(id) ON DELETE CASCADE,
+    period_start DATE NOT NULL,
+    threshold INTEGER NOT NULL,
+    monthly_limit DECIMAL(14, 3) NOT NULL,
+    consumption DECIMAL(14, 3) NOT NULL,
+    notified_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (budget_id, period_start, threshold)
+);
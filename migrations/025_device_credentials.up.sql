@@ -0,0 +1,18 @@
+-- Per-device identities for ingestion: API keys and client certificates.
+-- Only a SHA-256 hash of each key, or the certificate's SHA-256
+-- fingerprint, is stored.
+CREATE TABLE device_credentials (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('api_key', 'certificate')),
+    secret_hash CHAR(64) NOT NULL UNIQUE,
+    prefix VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_device_credentials_device ON device_credentials(device_id) WHERE revoked_at IS NULL;
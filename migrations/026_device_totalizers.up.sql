@@ -0,0 +1,8 @@
+-- Cumulative metrics per type, checked for resets and tampering at ingestion
+ALTER TABLE device_types ADD COLUMN totalizers JSONB NOT NULL DEFAULT '{}';
+
+UPDATE device_types SET totalizers = '{"volume": {"tolerance": 0.5}}'
+WHERE name = 'water_sensor';
+
+UPDATE device_types SET totalizers = '{"energy": {"rollover_at": 100000, "tolerance": 0.01}}'
+WHERE name = 'electricity_meter';
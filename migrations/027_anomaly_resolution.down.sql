@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_anomalies_open;
+DROP INDEX IF EXISTS idx_anomalies_timestamp;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS resolved_by;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS resolved_at;
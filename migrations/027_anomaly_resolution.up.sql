@@ -0,0 +1,6 @@
+-- Anomalies are reviewed and resolved by operators, like alerts
+ALTER TABLE anomalies ADD COLUMN resolved_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE anomalies ADD COLUMN resolved_by UUID REFERENCES users(id);
+
+CREATE INDEX idx_anomalies_timestamp ON anomalies(timestamp DESC);
+CREATE INDEX idx_anomalies_open ON anomalies(device_id, timestamp DESC) WHERE resolved_at IS NULL;
@@ -0,0 +1,3 @@
+-- The metric whose reading raised the anomaly. The anomaly's timestamp is
+-- that reading's, so the two locate the exact telemetry sample.
+ALTER TABLE anomalies ADD COLUMN metric VARCHAR(100);
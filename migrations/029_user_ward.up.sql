@@ -0,0 +1,3 @@
+-- The ward a user lives in or is responsible for, set by bulk import or an
+-- admin. Free text so each city can use its own ward codes.
+ALTER TABLE users ADD COLUMN ward VARCHAR(100);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS erased_by,
+    DROP COLUMN IF EXISTS erased_at;
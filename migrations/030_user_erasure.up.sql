@@ -0,0 +1,5 @@
+-- Users erased on request keep their row, with personal fields blanked, so
+-- bills and payments still reference it. These record who erased it and when.
+ALTER TABLE users
+    ADD COLUMN erased_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN erased_by UUID REFERENCES users(id);
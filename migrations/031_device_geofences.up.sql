@@ -0,0 +1,22 @@
+-- Areas mobile devices must stay within. A fence is either a circle
+-- (center and radius) or a polygon; area is the shape readings are tested
+-- against, derived from whichever was given.
+CREATE TABLE device_geofences (
+    device_id VARCHAR(255) PRIMARY KEY REFERENCES devices(id) ON DELETE CASCADE,
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    center GEOGRAPHY(POINT, 4326),
+    radius_m DOUBLE PRECISION,
+    polygon GEOGRAPHY(POLYGON, 4326),
+    area GEOGRAPHY NOT NULL,
+    severity VARCHAR(50) NOT NULL DEFAULT 'warning',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    -- Set from the first reading outside the fence until one is back inside,
+    -- so leaving raises one anomaly rather than one per reading
+    outside_since TIMESTAMP WITH TIME ZONE,
+    updated_by UUID REFERENCES users(id),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((center IS NOT NULL AND radius_m > 0 AND polygon IS NULL)
+        OR (polygon IS NOT NULL AND center IS NULL AND radius_m IS NULL))
+);
+
+CREATE INDEX idx_device_geofences_tenant ON device_geofences(tenant_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS device_baseline_runs;
+DROP TABLE IF EXISTS device_baselines;
@@ -0,0 +1,22 @@
+-- What each device's metrics normally look like, learned from its history.
+-- hourly holds 24 {mean, stddev, samples} entries, one per UTC hour of day.
+CREATE TABLE device_baselines (
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    metric VARCHAR(100) NOT NULL,
+    mean DOUBLE PRECISION NOT NULL,
+    stddev DOUBLE PRECISION NOT NULL,
+    samples BIGINT NOT NULL,
+    hourly JSONB NOT NULL,
+    learned_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_id, metric)
+);
+
+-- When each device's baselines were last learned, so instances share the
+-- work and a slow run is never started twice
+CREATE TABLE device_baseline_runs (
+    device_id VARCHAR(255) PRIMARY KEY REFERENCES devices(id) ON DELETE CASCADE,
+    learned_at TIMESTAMP WITH TIME ZONE,
+    running_since TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_device_baseline_runs_learned ON device_baseline_runs(learned_at);
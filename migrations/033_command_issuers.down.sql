@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_device_commands_time;
+DROP INDEX IF EXISTS idx_device_commands_device_time;
+ALTER TABLE device_commands DROP COLUMN IF EXISTS issued_by;
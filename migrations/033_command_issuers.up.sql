@@ -0,0 +1,6 @@
+-- Who issued each command, taken from their token when it was sent.
+-- Scheduled commands are attributed to the schedule's creator.
+ALTER TABLE device_commands ADD COLUMN issued_by UUID REFERENCES users(id);
+
+CREATE INDEX idx_device_commands_device_time ON device_commands(device_id, timestamp DESC);
+CREATE INDEX idx_device_commands_time ON device_commands(timestamp DESC);
@@ -0,0 +1,3 @@
+-- Commands each type's devices accept, with a schema for each command's
+-- parameters. Empty accepts any command.
+ALTER TABLE device_types ADD COLUMN capabilities JSONB NOT NULL DEFAULT '{}';
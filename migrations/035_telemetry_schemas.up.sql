@@ -0,0 +1,12 @@
+-- Versioned contracts for the metrics each device type reports. Versions
+-- are never edited; the highest version of a type is the one ingestion
+-- enforces.
+CREATE TABLE telemetry_schemas (
+    device_type VARCHAR(100) NOT NULL REFERENCES device_types(name) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    metrics JSONB NOT NULL,
+    strict BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_type, version)
+);
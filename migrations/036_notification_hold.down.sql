@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS alternate_contact_ids;
+DROP INDEX IF EXISTS idx_notifications_held;
+ALTER TABLE notifications DROP COLUMN IF EXISTS held_at;
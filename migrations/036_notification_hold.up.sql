@@ -0,0 +1,9 @@
+-- Notifications no channel could take are held until one recovers. held_at
+-- is when that first happened, so held notifications can expire.
+ALTER TABLE notifications ADD COLUMN held_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_notifications_held ON notifications(user_id, created_at) WHERE status = 'held';
+
+-- Users who also receive a user's emergency notifications while they are
+-- held
+ALTER TABLE users ADD COLUMN alternate_contact_ids UUID[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,24 @@
+-- Alerts users set on a device metric, such as water pressure below 1 bar.
+-- The threshold is kept as entered and in the metric's canonical unit,
+-- which readings are compared in. triggered_at is set while the condition
+-- holds, so each crossing notifies once.
+CREATE TABLE metric_subscriptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    metric VARCHAR(100) NOT NULL,
+    operator VARCHAR(8) NOT NULL CHECK (operator IN ('below', 'above')),
+    threshold DOUBLE PRECISION NOT NULL,
+    unit VARCHAR(32) NOT NULL DEFAULT '',
+    canonical_threshold DOUBLE PRECISION NOT NULL,
+    canonical_unit VARCHAR(32) NOT NULL DEFAULT '',
+    notify_recovery BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    triggered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_metric_subscriptions_device ON metric_subscriptions(device_id, metric) WHERE enabled;
+CREATE INDEX idx_metric_subscriptions_user ON metric_subscriptions(user_id);
@@ -0,0 +1,26 @@
+-- Uploads of historical telemetry, such as meter data migrated from a
+-- legacy system. Counts are updated after every batch, so a running
+-- backfill reports its progress.
+CREATE TABLE telemetry_backfills (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    lines_read BIGINT NOT NULL DEFAULT 0,
+    inserted BIGINT NOT NULL DEFAULT 0,
+    duplicates BIGINT NOT NULL DEFAULT 0,
+    invalid BIGINT NOT NULL DEFAULT 0,
+    errors JSONB NOT NULL DEFAULT '[]',
+    error TEXT,
+    created_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- A tenant uploads one backfill at a time
+CREATE UNIQUE INDEX idx_telemetry_backfills_running ON telemetry_backfills(tenant_id) WHERE status = 'running';
+
+CREATE TRIGGER update_telemetry_backfills_updated_at
+    BEFORE UPDATE ON telemetry_backfills
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_trigger();
@@ -0,0 +1,3 @@
+ALTER TABLE bills
+    DROP COLUMN IF EXISTS estimation_method,
+    DROP COLUMN IF EXISTS estimated_consumption;
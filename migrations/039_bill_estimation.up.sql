@@ -0,0 +1,6 @@
+-- The part of a bill's consumption estimated for time its meter didn't
+-- report, and how. consumption includes it, so the metered part is
+-- consumption - estimated_consumption.
+ALTER TABLE bills
+    ADD COLUMN estimated_consumption DECIMAL(14, 3) NOT NULL DEFAULT 0,
+    ADD COLUMN estimation_method VARCHAR(20);
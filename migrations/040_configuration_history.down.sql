@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tenant_config_history;
+DROP TABLE IF EXISTS device_configuration_history;
@@ -0,0 +1,33 @@
+-- Every version of a device's configuration and a tenant's overrides
+-- (which hold its tariffs), so the state in force at any time can be
+-- looked up for disputes and audits. A version applies from changed_at
+-- until the next one.
+CREATE TABLE device_configuration_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    device_id VARCHAR(255) NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    configuration JSONB NOT NULL DEFAULT '{}',
+    changed_by UUID REFERENCES users(id),
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    -- The version in force when history began; what came before is unknown
+    baseline BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX idx_device_configuration_history_device ON device_configuration_history(device_id, changed_at DESC);
+
+CREATE TABLE tenant_config_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    overrides JSONB NOT NULL DEFAULT '{}',
+    changed_by UUID REFERENCES users(id),
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    baseline BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX idx_tenant_config_history_tenant ON tenant_config_history(tenant_id, changed_at DESC);
+
+INSERT INTO device_configuration_history (device_id, configuration, changed_at, baseline)
+SELECT id, COALESCE(configuration, '{}'), NOW(), TRUE FROM devices;
+
+-- Overrides are known to have been in force since their last update
+INSERT INTO tenant_config_history (tenant_id, overrides, changed_by, changed_at, baseline)
+SELECT tenant_id, overrides, updated_by, COALESCE(updated_at, NOW()), TRUE FROM tenant_configs;
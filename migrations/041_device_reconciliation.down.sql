@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS device_reconciliation_findings;
+DROP TABLE IF EXISTS device_reconciliations;
+DROP TABLE IF EXISTS unregistered_telemetry;
@@ -0,0 +1,42 @@
+-- Telemetry from device IDs that aren't registered is rejected; each
+-- replica tallies the IDs it rejects here so reconciliation can report
+-- them. tenant_id and device_type are what the messages claimed.
+CREATE TABLE unregistered_telemetry (
+    device_id VARCHAR(255) PRIMARY KEY,
+    tenant_id VARCHAR(64),
+    device_type VARCHAR(100),
+    first_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    messages BIGINT NOT NULL DEFAULT 0
+);
+
+-- Periodic comparisons of the device registry against telemetry
+CREATE TABLE device_reconciliations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    unregistered INTEGER NOT NULL DEFAULT 0,
+    orphaned INTEGER NOT NULL DEFAULT 0,
+    silent INTEGER NOT NULL DEFAULT 0,
+    registered INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- One replica reconciles at a time
+CREATE UNIQUE INDEX idx_device_reconciliations_running ON device_reconciliations(status) WHERE status = 'running';
+CREATE INDEX idx_device_reconciliations_started ON device_reconciliations(started_at DESC);
+
+CREATE TABLE device_reconciliation_findings (
+    reconciliation_id UUID NOT NULL REFERENCES device_reconciliations(id) ON DELETE CASCADE,
+    -- unregistered, orphaned, silent or registered
+    kind VARCHAR(20) NOT NULL,
+    device_id VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(64),
+    device_type VARCHAR(100),
+    last_seen TIMESTAMP WITH TIME ZONE,
+    messages BIGINT,
+    PRIMARY KEY (reconciliation_id, kind, device_id)
+);
+
+CREATE INDEX idx_device_reconciliation_findings_tenant ON device_reconciliation_findings(reconciliation_id, tenant_id);
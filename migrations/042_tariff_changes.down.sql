@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tariff_changes;
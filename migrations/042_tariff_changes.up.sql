@@ -0,0 +1,29 @@
+-- Every change made to a tenant's tariffs through the rate endpoints, with
+-- who made it and why. A change with a future effective_at is scheduled
+-- and applied when it comes due; applying one writes the tenant's
+-- overrides, so tenant_config_history holds the version in force from
+-- effective_at.
+CREATE TABLE tariff_changes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'scheduled',
+    -- The change as submitted
+    tariffs JSONB NOT NULL,
+    reason TEXT NOT NULL,
+    effective_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    applied_at TIMESTAMP WITH TIME ZONE,
+    -- The tenant's tariff overrides before the change, restored by a rollback
+    previous_tariffs JSONB,
+    -- Effective tariffs either side of the change, for its diff
+    tariffs_before JSONB,
+    tariffs_after JSONB,
+    rollback_of UUID REFERENCES tariff_changes(id),
+    rolled_back_by UUID REFERENCES tariff_changes(id),
+    cancelled_by UUID REFERENCES users(id),
+    error TEXT
+);
+
+CREATE INDEX idx_tariff_changes_tenant ON tariff_changes(tenant_id, created_at DESC);
+CREATE INDEX idx_tariff_changes_due ON tariff_changes(effective_at) WHERE status = 'scheduled';
@@ -0,0 +1,3 @@
+-- Rules each type's device metadata must satisfy, such as keys every
+-- device must carry. Empty leaves metadata free-form.
+ALTER TABLE device_types ADD COLUMN metadata_schema JSONB NOT NULL DEFAULT '{}';
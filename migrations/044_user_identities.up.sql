@@ -0,0 +1,14 @@
+-- External identities users sign in with through single sign-on, by the
+-- provider's name in auth.sso and the identity's subject there
+CREATE TABLE user_identities (
+    provider VARCHAR(100) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id VARCHAR(64) NOT NULL REFERENCES tenants(id),
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user ON user_identities(user_id);
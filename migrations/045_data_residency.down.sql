@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_devices_region;
+ALTER TABLE users DROP COLUMN IF EXISTS allowed_regions;
+ALTER TABLE users DROP COLUMN IF EXISTS region;
+ALTER TABLE devices DROP COLUMN IF EXISTS region;
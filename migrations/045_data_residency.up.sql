@@ -0,0 +1,10 @@
+-- The region (jurisdiction) a device's or user's data must stay in. NULL
+-- is the configured default region. A device's region is fixed when it is
+-- registered, since its telemetry is tagged with it.
+ALTER TABLE devices ADD COLUMN region VARCHAR(50);
+ALTER TABLE users ADD COLUMN region VARCHAR(50);
+
+-- Regions besides their own a user may reach; '*' is every region
+ALTER TABLE users ADD COLUMN allowed_regions TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_devices_region ON devices(tenant_id, region);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_anomalies_reading;
+DELETE FROM anomalies a USING anomalies b
+WHERE a.ctid > b.ctid AND a.device_id = b.device_id AND a.type = b.type AND a.timestamp = b.timestamp;
+CREATE UNIQUE INDEX idx_anomalies_reading ON anomalies(device_id, type, timestamp);
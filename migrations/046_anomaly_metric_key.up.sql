@@ -0,0 +1,4 @@
+-- Anomalies of one type on different metrics of the same reading are
+-- separate anomalies. Those without a metric still count once per reading.
+DROP INDEX IF EXISTS idx_anomalies_reading;
+CREATE UNIQUE INDEX idx_anomalies_reading ON anomalies(device_id, type, COALESCE(metric, ''), timestamp);
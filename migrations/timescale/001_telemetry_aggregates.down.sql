@@ -0,0 +1,2 @@
+SELECT remove_retention_policy('device_telemetry', if_exists => true);
+DROP TABLE IF EXISTS device_telemetry_aggregates;
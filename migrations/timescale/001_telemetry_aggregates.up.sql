@@ -0,0 +1,26 @@
+-- Applied to the TimescaleDB telemetry database, not the main database.
+
+-- Fixed-interval aggregates written by ingestion for types with a sampling policy
+CREATE TABLE device_telemetry_aggregates (
+    device_id VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(64) NOT NULL,
+    device_type VARCHAR(100) NOT NULL,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    interval_seconds INTEGER NOT NULL,
+    metric VARCHAR(100) NOT NULL,
+    min_value DOUBLE PRECISION NOT NULL,
+    max_value DOUBLE PRECISION NOT NULL,
+    sum_value DOUBLE PRECISION NOT NULL,
+    sample_count INTEGER NOT NULL,
+    last_value DOUBLE PRECISION NOT NULL,
+    last_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (device_id, metric, bucket)
+);
+
+SELECT create_hypertable('device_telemetry_aggregates', 'bucket', chunk_time_interval => INTERVAL '7 days');
+
+CREATE INDEX idx_telemetry_aggregates_tenant ON device_telemetry_aggregates(tenant_id, bucket DESC);
+
+-- Raw readings are kept briefly; aggregates are the long-term record
+SELECT add_retention_policy('device_telemetry', INTERVAL '30 days', if_not_exists => true);
+SELECT add_retention_policy('device_telemetry_aggregates', INTERVAL '5 years', if_not_exists => true);
@@ -0,0 +1,13 @@
+-- Applied to the TimescaleDB telemetry database, not the main database.
+
+-- Every reading a backfill has stored, so uploading the same data again
+-- never stores or aggregates it twice. Kept as long as the aggregates.
+CREATE TABLE backfilled_readings (
+    device_id VARCHAR(255) NOT NULL,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (device_id, timestamp)
+);
+
+SELECT create_hypertable('backfilled_readings', 'timestamp', chunk_time_interval => INTERVAL '30 days');
+
+SELECT add_retention_policy('backfilled_readings', INTERVAL '5 years', if_not_exists => true);
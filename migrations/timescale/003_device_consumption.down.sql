@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS consumption_watermarks;
+DROP TABLE IF EXISTS device_consumption;
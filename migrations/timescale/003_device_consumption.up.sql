@@ -0,0 +1,39 @@
+-- Applied to the TimescaleDB telemetry database, not the main database.
+
+-- Consumption per device, metric and billing.consumption.period, derived
+-- from raw telemetry by the billing service. Interpolated is the part
+-- spread over a gap in reporting; unmetered_seconds the part of the bucket
+-- a flow could not be integrated over.
+CREATE TABLE device_consumption (
+    device_id VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(64) NOT NULL,
+    metric VARCHAR(100) NOT NULL,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    consumption DOUBLE PRECISION NOT NULL DEFAULT 0,
+    interpolated DOUBLE PRECISION NOT NULL DEFAULT 0,
+    readings INTEGER NOT NULL DEFAULT 0,
+    resets INTEGER NOT NULL DEFAULT 0,
+    rollovers INTEGER NOT NULL DEFAULT 0,
+    unmetered_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    PRIMARY KEY (device_id, metric, period_start)
+);
+
+SELECT create_hypertable('device_consumption', 'period_start', chunk_time_interval => INTERVAL '30 days');
+
+CREATE INDEX idx_device_consumption_tenant ON device_consumption(tenant_id, period_start DESC);
+
+SELECT add_retention_policy('device_consumption', INTERVAL '5 years', if_not_exists => true);
+
+-- How far each device's metric has been materialized, and the reading the
+-- next run continues from. A NULL materialized_until starts from the
+-- device's first reading.
+CREATE TABLE consumption_watermarks (
+    device_id VARCHAR(255) NOT NULL,
+    metric VARCHAR(100) NOT NULL,
+    tenant_id VARCHAR(64) NOT NULL,
+    materialized_until TIMESTAMP WITH TIME ZONE,
+    last_value DOUBLE PRECISION,
+    last_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_id, metric)
+);
@@ -0,0 +1 @@
+ALTER TABLE device_telemetry DROP COLUMN IF EXISTS region;
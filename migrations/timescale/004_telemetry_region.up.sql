@@ -0,0 +1,3 @@
+-- Raw telemetry carries its device's region so it can be kept, queried and
+-- exported per jurisdiction. NULL is the configured default region.
+ALTER TABLE device_telemetry ADD COLUMN region VARCHAR(50);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_device_telemetry_tenant;
+ALTER TABLE device_telemetry DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,18 @@
+-- Raw telemetry carries its device's tenant, written by the device service
+-- on ingest, so it can be scoped without a lookup in the main database.
+ALTER TABLE device_telemetry ADD COLUMN tenant_id VARCHAR(64);
+
+-- Backfill when the devices table shares this database. Deployments that
+-- keep TimescaleDB separate backfill by hand; see docs/OPERATIONS.md.
+DO $$
+BEGIN
+    IF to_regclass('devices') IS NOT NULL THEN
+        UPDATE device_telemetry t
+        SET tenant_id = d.tenant_id
+        FROM devices d
+        WHERE d.id = t.device_id AND t.tenant_id IS NULL;
+    END IF;
+END
+$$;
+
+CREATE INDEX idx_device_telemetry_tenant ON device_telemetry(tenant_id, timestamp DESC);
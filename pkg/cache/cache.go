@@ -0,0 +1,162 @@
+// Package cache keeps rarely changing reference data, such as device types
+// and tenant configuration, in process memory so hot paths skip both the
+// database and Redis. Entries expire after a TTL and the least recently
+// used are evicted beyond a size bound. Lookups and evictions are counted
+// per cache in Prometheus.
+//
+// Writers drop the entries they change from their own process; a Syncer
+// passes the change on to other processes.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// Clearer is a cache a Syncer can empty when another process changes its
+// data.
+type Clearer interface {
+	Name() string
+	Clear()
+}
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// Cache maps keys to values for up to ttl, holding at most maxEntries. It
+// is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	name       string
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[K]*list.Element
+	// recency orders entries, most recently used first
+	recency *list.List
+	// epoch goes up on every invalidation, so a load that started before
+	// one doesn't store what it read
+	epoch uint64
+}
+
+// New returns an empty cache. name labels its metrics and, for a synced
+// cache, its generation in Redis.
+func New[K comparable, V any](name string, ttl time.Duration, maxEntries int) *Cache[K, V] {
+	return &Cache[K, V]{
+		name:       name,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[K]*list.Element),
+		recency:    list.New(),
+	}
+}
+
+func (c *Cache[K, V]) Name() string {
+	return c.name
+}
+
+// Get returns the cached value for key, if there is one that hasn't
+// expired.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		cached := element.Value.(*entry[K, V])
+		if time.Now().Before(cached.expiresAt) {
+			c.recency.MoveToFront(element)
+			metrics.CacheLookups.WithLabelValues(c.name, "hit").Inc()
+			return cached.value, true
+		}
+		c.remove(element, "expired")
+	}
+
+	metrics.CacheLookups.WithLabelValues(c.name, "miss").Inc()
+	var zero V
+	return zero, false
+}
+
+// Load returns the cached value for key, or calls load and caches what it
+// returns. Errors are not cached. Concurrent misses may each call load.
+func (c *Cache[K, V]) Load(key K, load func() (V, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+
+	c.mu.Lock()
+	epoch := c.epoch
+	c.mu.Unlock()
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.epoch == epoch {
+		c.set(key, value)
+	}
+	return value, nil
+}
+
+// Set caches value for key.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value)
+}
+
+// Delete drops key, so the next read loads it afresh.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	if element, ok := c.entries[key]; ok {
+		c.remove(element, "invalidated")
+	}
+}
+
+// Clear drops every entry.
+func (c *Cache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	if len(c.entries) > 0 {
+		metrics.CacheEvictions.WithLabelValues(c.name, "invalidated").Add(float64(len(c.entries)))
+	}
+	c.entries = make(map[K]*list.Element)
+	c.recency.Init()
+	metrics.CacheEntries.WithLabelValues(c.name).Set(0)
+}
+
+func (c *Cache[K, V]) set(key K, value V) {
+	expiresAt := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		cached := element.Value.(*entry[K, V])
+		cached.value, cached.expiresAt = value, expiresAt
+		c.recency.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.recency.PushFront(&entry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	for c.maxEntries > 0 && len(c.entries) > c.maxEntries {
+		c.remove(c.recency.Back(), "capacity")
+	}
+	metrics.CacheEntries.WithLabelValues(c.name).Set(float64(len(c.entries)))
+}
+
+func (c *Cache[K, V]) remove(element *list.Element, reason string) {
+	c.recency.Remove(element)
+	delete(c.entries, element.Value.(*entry[K, V]).key)
+	metrics.CacheEvictions.WithLabelValues(c.name, reason).Inc()
+	metrics.CacheEntries.WithLabelValues(c.name).Set(float64(len(c.entries)))
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// Syncer passes invalidations between processes. Each synced cache has a
+// generation counter in Redis that writers bump after a change, and every
+// process polls the counters, clearing a cache whose counter moved. Only
+// the poll touches Redis, never a lookup.
+//
+// A nil Syncer is valid and keeps invalidations within the process; other
+// processes then see changes when their entries expire.
+type Syncer struct {
+	redis    *database.RedisClient
+	interval time.Duration
+	logger   logger.Logger
+
+	mu     sync.Mutex
+	caches map[string][]Clearer
+	// seen is the generation of each cache at the last poll
+	seen map[string]string
+}
+
+func NewSyncer(redis *database.RedisClient, interval time.Duration, log logger.Logger) *Syncer {
+	return &Syncer{
+		redis:    redis,
+		interval: interval,
+		logger:   log,
+		caches:   make(map[string][]Clearer),
+		seen:     make(map[string]string),
+	}
+}
+
+func generationKey(name string) string {
+	return "cache_generation:" + name
+}
+
+// Watch clears cache whenever another process reports a change to it.
+func (s *Syncer) Watch(cache Clearer) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.caches[cache.Name()] = append(s.caches[cache.Name()], cache)
+}
+
+// Changed tells other processes the data behind the named cache changed.
+// Callers drop their own entries first. If Redis fails, other processes
+// only see the change once their entries expire.
+func (s *Syncer) Changed(ctx context.Context, name string) {
+	if s == nil {
+		return
+	}
+	if _, err := s.redis.Incr(ctx, generationKey(name)); err != nil {
+		metrics.RedisFallbacks.WithLabelValues("cache_sync").Inc()
+		s.logger.Warn("Failed to publish cache invalidation", "error", err, "cache", name)
+	}
+}
+
+// Run polls the watched caches' generations every interval until ctx is
+// done. Caches are cleared on the first poll, as they may have been filled
+// before it with data changed since.
+func (s *Syncer) Run(ctx context.Context) {
+	if s == nil || s.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Syncer) poll(ctx context.Context) {
+	s.mu.Lock()
+	watched := make(map[string][]Clearer, len(s.caches))
+	for name, caches := range s.caches {
+		watched[name] = caches
+	}
+	s.mu.Unlock()
+
+	for name, caches := range watched {
+		generation, err := s.redis.Get(ctx, generationKey(name))
+		if err != nil && !database.IsMiss(err) {
+			metrics.RedisFallbacks.WithLabelValues("cache_sync").Inc()
+			s.logger.Debug("Failed to read cache generation", "error", err, "cache", name)
+			continue
+		}
+
+		s.mu.Lock()
+		previous, known := s.seen[name]
+		s.seen[name] = generation
+		s.mu.Unlock()
+
+		if known && previous == generation {
+			continue
+		}
+		for _, cache := range caches {
+			cache.Clear()
+		}
+		if known {
+			s.logger.Debug("Cleared cache changed by another process", "cache", name)
+		}
+	}
+}
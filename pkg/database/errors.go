@@ -0,0 +1,96 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ConstraintKind is the kind of integrity constraint a statement violated.
+type ConstraintKind string
+
+const (
+	UniqueViolation     ConstraintKind = "unique"
+	ForeignKeyViolation ConstraintKind = "foreign_key"
+	NotNullViolation    ConstraintKind = "not_null"
+	CheckViolation      ConstraintKind = "check"
+)
+
+// Postgres SQLSTATE codes for integrity constraint violations
+var constraintCodes = map[pq.ErrorCode]ConstraintKind{
+	"23505": UniqueViolation,
+	"23503": ForeignKeyViolation,
+	"23502": NotNullViolation,
+	"23514": CheckViolation,
+}
+
+// Key details read like `Key (device_id, metric)=(a, b) already exists.`
+var detailKeyPattern = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+// ConstraintError is a constraint violation reported by Postgres, with the
+// offending columns when the server names them.
+type ConstraintError struct {
+	Kind       ConstraintKind
+	Table      string
+	Constraint string
+	Columns    []string
+	Err        *pq.Error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%s constraint %q violated: %s", e.Kind, e.Constraint, e.Err.Message)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// Field names the offending column(s) for client-facing messages, or "" if
+// Postgres didn't say (check constraints usually don't).
+func (e *ConstraintError) Field() string {
+	return strings.Join(e.Columns, ", ")
+}
+
+// AsConstraintError classifies err as a constraint violation. It returns
+// false for any other error, including other Postgres errors.
+func AsConstraintError(err error) (*ConstraintError, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return nil, false
+	}
+
+	kind, ok := constraintCodes[pqErr.Code]
+	if !ok {
+		return nil, false
+	}
+
+	constraintErr := &ConstraintError{
+		Kind:       kind,
+		Table:      pqErr.Table,
+		Constraint: pqErr.Constraint,
+		Err:        pqErr,
+	}
+	if pqErr.Column != "" {
+		constraintErr.Columns = []string{pqErr.Column}
+	} else if match := detailKeyPattern.FindStringSubmatch(pqErr.Detail); match != nil {
+		for _, column := range strings.Split(match[1], ",") {
+			constraintErr.Columns = append(constraintErr.Columns, strings.TrimSpace(column))
+		}
+	}
+	return constraintErr, true
+}
+
+// IsUniqueViolation reports whether err is a unique constraint violation.
+func IsUniqueViolation(err error) bool {
+	constraintErr, ok := AsConstraintError(err)
+	return ok && constraintErr.Kind == UniqueViolation
+}
+
+// IsForeignKeyViolation reports whether err is a foreign key violation.
+func IsForeignKeyViolation(err error) bool {
+	constraintErr, ok := AsConstraintError(err)
+	return ok && constraintErr.Kind == ForeignKeyViolation
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -20,6 +21,11 @@ func NewRedis(cfg *config.Config) (*RedisDB, error) {
 		DB:       cfg.Database.Redis.DB,
 		PoolSize: 20,
 		MinIdleConns: 5,
+		// Fail fast when Redis is unreachable so callers can fall back
+		// instead of stalling requests on the default timeouts
+		DialTimeout:  time.Second,
+		ReadTimeout:  500 * time.Millisecond,
+		WriteTimeout: 500 * time.Millisecond,
 	})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -40,4 +46,98 @@ func (r *RedisDB) SetEX(key, value string, expiration time.Duration) error {
 func (r *RedisDB) Get(key string) (string, error) {
 	ctx := context.Background()
 	return r.Client.Get(ctx, key).Result()
-}
\ No newline at end of file
+}
+
+// IsMiss reports whether err means the key doesn't exist, as opposed to
+// Redis failing or being unreachable. Features that can run without Redis
+// treat misses as data and other errors as a reason to fall back.
+func IsMiss(err error) bool {
+	return errors.Is(err, redis.Nil)
+}
+
+// RedisClient is a context-aware wrapper around the Redis connection that
+// returns plain values and errors instead of command objects.
+type RedisClient struct {
+	client *redis.Client
+}
+
+func NewRedisClient(cfg *config.Config) (*RedisClient, error) {
+	rdb, err := NewRedis(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return WrapRedis(rdb), nil
+}
+
+// WrapRedis shares a RedisDB connection with code written for RedisClient.
+func WrapRedis(rdb *RedisDB) *RedisClient {
+	return &RedisClient{client: rdb.Client}
+}
+
+func (r *RedisClient) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *RedisClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return r.client.Set(ctx, key, value, expiration).Err()
+}
+
+func (r *RedisClient) Get(ctx context.Context, key string) (string, error) {
+	return r.client.Get(ctx, key).Result()
+}
+
+func (r *RedisClient) Del(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, keys...).Err()
+}
+
+func (r *RedisClient) Incr(ctx context.Context, key string) (int64, error) {
+	return r.client.Incr(ctx, key).Result()
+}
+
+func (r *RedisClient) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return r.client.Expire(ctx, key, expiration).Err()
+}
+
+// Eval runs a Lua script atomically. A nil reply is returned as an error
+// that IsMiss recognises.
+func (r *RedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return r.client.Eval(ctx, script, keys, args...).Result()
+}
+
+func (r *RedisClient) Close() error {
+	return r.client.Close()
+}
+
+// HSet accepts field/value pairs or a map[string]interface{}, as HSET does.
+func (r *RedisClient) HSet(ctx context.Context, key string, values ...interface{}) error {
+	return r.client.HSet(ctx, key, values...).Err()
+}
+
+func (r *RedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return r.client.HGetAll(ctx, key).Result()
+}
+
+func (r *RedisClient) HDel(ctx context.Context, key string, fields ...string) error {
+	return r.client.HDel(ctx, key, fields...).Err()
+}
+
+// HGetAllMany fetches several hashes in one round trip. Missing keys yield
+// empty maps, in the same position as their key.
+func (r *RedisClient) HGetAllMany(ctx context.Context, keys ...string) ([]map[string]string, error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HGetAll(ctx, key)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	results := make([]map[string]string, len(cmds))
+	for i, cmd := range cmds {
+		results[i] = cmd.Val()
+	}
+	return results, nil
+}
@@ -0,0 +1,176 @@
+// Package events publishes and consumes typed events over Kafka. Each kind
+// of event has one topic and a schema version, kept here rather than at
+// every call site, and the bus does the JSON encoding and error reporting.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/kafka"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+const pollTimeout = 5 * time.Second
+
+// Kind is a type of event. Version is the schema version publishers write;
+// it goes up when a change would break consumers, and consumers skip
+// events with a version newer than they know.
+type Kind struct {
+	Name    string
+	Version int
+	topic   func(cfg *config.Config) string
+}
+
+// Event is implemented by the types published on the bus.
+type Event interface {
+	EventKind() Kind
+
+	// PartitionKey orders events: those with the same key are consumed in
+	// the order they were published
+	PartitionKey() string
+}
+
+type Bus struct {
+	producer *kafka.Producer
+	config   *config.Config
+	logger   logger.Logger
+}
+
+// New returns a bus publishing through producer, which may be nil for one
+// that only subscribes.
+func New(producer *kafka.Producer, cfg *config.Config, log logger.Logger) *Bus {
+	return &Bus{
+		producer: producer,
+		config:   cfg,
+		logger:   log,
+	}
+}
+
+// deadLetters is where Subscribe sends events it can't handle, or nil for
+// a bus without a producer. A nil *kafka.Producer mustn't be passed on as
+// a non-nil interface.
+func (b *Bus) deadLetters() kafka.DeadLetters {
+	if b.producer == nil {
+		return nil
+	}
+	return b.producer
+}
+
+// Topic is the topic events of the kind are published to.
+func (b *Bus) Topic(kind Kind) string {
+	return kind.topic(b.config)
+}
+
+// Publish sends an event to its kind's topic. Failures are logged here, so
+// callers that can't do anything about them may ignore the error.
+func Publish[T Event](ctx context.Context, b *Bus, event T) error {
+	kind := event.EventKind()
+	topic := b.Topic(kind)
+
+	err := ctx.Err()
+	if err == nil {
+		var payload []byte
+		payload, err = encode(event, kind.Version)
+		if err == nil {
+			err = b.producer.ProduceMessage(topic, event.PartitionKey(), payload)
+		}
+	}
+	if err != nil {
+		b.logger.Error("Failed to publish event", "error", err, "kind", kind.Name, "topic", topic, "key", event.PartitionKey())
+		return fmt.Errorf("publishing %s: %w", kind.Name, err)
+	}
+	return nil
+}
+
+// Subscribe consumes events of the given kinds, decoded as T, until ctx is
+// cancelled, passing each to handle with the topic it came from. Each
+// polled batch is handled kafka.consumer.concurrency events at a time,
+// those with the same partition key in order, and committed once done.
+// An event handle fails on is retried and, if it still fails, read again
+// by a later poll. Events that can't be decoded or have a newer schema
+// version, and those handle fails on with kafka.Poison, are logged and
+// sent to the topic's dead-letter topic (dropped if the bus has no
+// producer).
+func Subscribe[T any](ctx context.Context, b *Bus, consumer *kafka.Consumer,
+	handle func(ctx context.Context, topic string, event T) error, kinds ...Kind) {
+	topics := make([]string, len(kinds))
+	versions := make(map[string]Kind, len(kinds))
+	for i, kind := range kinds {
+		topics[i] = b.Topic(kind)
+		versions[topics[i]] = kind
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		messages, err := consumer.ConsumeMessages(topics, pollTimeout)
+		if err != nil {
+			b.logger.Error("Failed to consume events", "error", err, "topics", topics)
+			continue
+		}
+
+		if len(messages) == 0 {
+			continue
+		}
+
+		err = consumer.HandleBatch(messages, b.config.Kafka.Consumer.Concurrency, b.deadLetters(), func(msg *kafka.Message) error {
+			kind := versions[msg.Topic]
+
+			var event T
+			if err := decode(msg.Value, kind, &event); err != nil {
+				b.logger.Error("Failed to decode event", "error", err, "kind", kind.Name, "topic", msg.Topic)
+				return kafka.Poison(err)
+			}
+			if err := handle(ctx, msg.Topic, event); err != nil {
+				b.logger.Error("Failed to handle event", "error", err, "kind", kind.Name, "topic", msg.Topic)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			b.logger.Error("Failed to commit consumed events", "error", err, "topics", topics)
+		}
+	}
+}
+
+// encode writes the event as a JSON object with its schema_version added.
+func encode(event interface{}, version int) ([]byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 2 || payload[0] != '{' {
+		return nil, fmt.Errorf("event must encode as a JSON object")
+	}
+
+	field := `"schema_version":` + strconv.Itoa(version)
+	if !bytes.Equal(payload, []byte("{}")) {
+		field += ","
+	}
+	return append([]byte("{"+field), payload[1:]...), nil
+}
+
+// decode reads an event into out. Events from before schema versions were
+// added have none and count as version 1.
+func decode(payload []byte, kind Kind, out interface{}) error {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(payload, &header); err != nil {
+		return err
+	}
+	if header.SchemaVersion > kind.Version {
+		return fmt.Errorf("schema version %d is newer than %d", header.SchemaVersion, kind.Version)
+	}
+	return json.Unmarshal(payload, out)
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+)
+
+var (
+	// Alerts carries device alerts for monitoring integrations
+	Alerts = Kind{Name: "alert", Version: 1, topic: func(*config.Config) string {
+		return "alerts"
+	}}
+
+	// Notifications carries notifications for the notification service to
+	// deliver to a user
+	Notifications = Kind{Name: "notification", Version: 1, topic: func(cfg *config.Config) string {
+		return cfg.Kafka.Topics.Notifications
+	}}
+
+	// SystemAlerts and EmergencyAlerts carry notifications too, published
+	// by operators' tooling outside these services
+	SystemAlerts = Kind{Name: "notification", Version: 1, topic: func(*config.Config) string {
+		return "system-alerts"
+	}}
+	EmergencyAlerts = Kind{Name: "notification", Version: 1, topic: func(*config.Config) string {
+		return "emergency-alerts"
+	}}
+)
+
+// Alert types
+const (
+	AlertAnomalyDetected = "anomaly_detected"
+	AlertDeviceOffline   = "device_offline"
+)
+
+// AnomalyAlert reports an anomaly detected in a device's telemetry.
+type AnomalyAlert struct {
+	Type        string      `json:"type"`
+	DeviceID    string      `json:"device_id"`
+	TenantID    string      `json:"tenant_id"`
+	Severity    string      `json:"severity"`
+	Description string      `json:"description"`
+	Timestamp   time.Time   `json:"timestamp"`
+	Metric      string      `json:"metric"`
+	Value       interface{} `json:"value"`
+
+	// Replayed is set when the anomaly was found reprocessing stored
+	// telemetry rather than as it arrived
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+func (a AnomalyAlert) EventKind() Kind      { return Alerts }
+func (a AnomalyAlert) PartitionKey() string { return a.DeviceID }
+
+// OfflineAlert reports a device that has stopped reporting.
+type OfflineAlert struct {
+	Type     string    `json:"type"`
+	DeviceID string    `json:"device_id"`
+	TenantID string    `json:"tenant_id"`
+	LastSeen time.Time `json:"last_seen"`
+	Severity string    `json:"severity"`
+
+	// UnreachableChildren counts devices behind this gateway that can't
+	// report while it's offline
+	UnreachableChildren int `json:"unreachable_children,omitempty"`
+}
+
+func (a OfflineAlert) EventKind() Kind      { return Alerts }
+func (a OfflineAlert) PartitionKey() string { return a.DeviceID }
+
+// Notification asks the notification service to deliver a message to a
+// user. It is read as models.Notification.
+type Notification struct {
+	ID       string                 `json:"id"`
+	TenantID string                 `json:"tenant_id,omitempty"`
+	UserID   string                 `json:"user_id"`
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority string                 `json:"priority"`
+	Channels []string               `json:"channels,omitempty"`
+	Metadata map[string]interface{} `json:"metadata"`
+
+	// DedupKey identifies the condition being notified about, so repeats
+	// within the dedup window are dropped
+	DedupKey string `json:"dedup_key,omitempty"`
+}
+
+func (n Notification) EventKind() Kind      { return Notifications }
+func (n Notification) PartitionKey() string { return n.UserID }
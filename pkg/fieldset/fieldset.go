@@ -0,0 +1,123 @@
+// Package fieldset trims responses to the fields a client asks for with
+// ?fields=name,status, so dashboards needn't download configuration and
+// metadata they don't show.
+package fieldset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Fields is the set of top-level JSON fields requested. A nil Fields
+// selects everything.
+type Fields map[string]bool
+
+// Parse reads the fields query parameter, a comma-separated list of the
+// JSON field names of model, a struct or pointer to one. The id field is
+// always included so results can still be told apart. It returns nil if
+// the parameter is absent, and an error naming the valid fields if one
+// isn't.
+func Parse(c *gin.Context, model interface{}) (Fields, error) {
+	value, ok := c.GetQuery("fields")
+	if !ok {
+		return nil, nil
+	}
+
+	valid := names(reflect.TypeOf(model))
+	fields := Fields{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !valid[name] {
+			return nil, fmt.Errorf("unknown field %q; fields are %s", name, strings.Join(sorted(valid), ", "))
+		}
+		fields[name] = true
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("fields must list at least one field")
+	}
+	if valid["id"] {
+		fields["id"] = true
+	}
+	return fields, nil
+}
+
+// Select returns v, an object or array of objects, with only the requested
+// fields, ready to be written as JSON. With nil Fields it returns v as is.
+func (f Fields) Select(v interface{}) (interface{}, error) {
+	if f == nil {
+		return v, nil
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(encoded), []byte("[")) {
+		var objects []map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &objects); err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			f.trim(object)
+		}
+		return objects, nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &object); err != nil {
+		return nil, err
+	}
+	f.trim(object)
+	return object, nil
+}
+
+func (f Fields) trim(object map[string]json.RawMessage) {
+	for name := range object {
+		if !f[name] {
+			delete(object, name)
+		}
+	}
+}
+
+// names are the JSON names of a struct type's exported fields.
+func names(t reflect.Type) map[string]bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	valid := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		valid[name] = true
+	}
+	return valid
+}
+
+func sorted(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for name := range set {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
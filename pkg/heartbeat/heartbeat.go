@@ -0,0 +1,123 @@
+// Package heartbeat has every service report that it is running and
+// whether its dependencies answer, so the gateway can publish the real
+// state of the platform rather than assume it is healthy.
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+const (
+	// key is a Redis hash of the latest Beat from each instance, by
+	// "service/instance"
+	key = "service_heartbeats"
+
+	Interval = 15 * time.Second
+
+	// An instance that misses this many intervals is counted as gone
+	staleAfter = 3 * Interval
+
+	// Beats of instances gone this long are deleted
+	pruneAfter = time.Hour
+
+	checkTimeout = 5 * time.Second
+)
+
+// Check reports whether a dependency, such as a database, answers.
+type Check func(ctx context.Context) error
+
+// Beat is one instance's latest report.
+type Beat struct {
+	Service   string    `json:"service"`
+	Instance  string    `json:"instance"`
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"started_at"`
+	At        time.Time `json:"at"`
+
+	// Failing names the checks that failed. Their errors are only logged,
+	// as the status page is public.
+	Failing []string `json:"failing,omitempty"`
+}
+
+// Reporter writes an instance's beat every Interval until stopped.
+type Reporter struct {
+	redis  *database.RedisClient
+	beat   Beat
+	checks map[string]Check
+	logger logger.Logger
+}
+
+func NewReporter(redis *database.RedisClient, service, version string, log logger.Logger) *Reporter {
+	host, _ := os.Hostname()
+	return &Reporter{
+		redis: redis,
+		beat: Beat{
+			Service:   service,
+			Instance:  fmt.Sprintf("%s-%d", host, os.Getpid()),
+			Version:   version,
+			StartedAt: time.Now(),
+		},
+		checks: make(map[string]Check),
+		logger: log,
+	}
+}
+
+// AddCheck adds a dependency check run before each beat. An instance with
+// a failing check reports itself degraded.
+func (r *Reporter) AddCheck(name string, check Check) {
+	r.checks[name] = check
+}
+
+// Run reports at once and then every Interval. When ctx ends the
+// instance's beat is removed, so a clean shutdown isn't shown as an outage
+// of that instance.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	r.report(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			cleanup, cancel := context.WithTimeout(context.Background(), checkTimeout)
+			r.redis.HDel(cleanup, key, r.field())
+			cancel()
+			return
+		case <-ticker.C:
+			r.report(ctx)
+		}
+	}
+}
+
+func (r *Reporter) field() string {
+	return r.beat.Service + "/" + r.beat.Instance
+}
+
+func (r *Reporter) report(ctx context.Context) {
+	beat := r.beat
+	beat.At = time.Now()
+
+	for name, check := range r.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			r.logger.Warn("Health check failed", "check", name, "error", err)
+			beat.Failing = append(beat.Failing, name)
+		}
+	}
+	sort.Strings(beat.Failing)
+
+	data, _ := json.Marshal(beat)
+	if err := r.redis.HSet(ctx, key, r.field(), string(data)); err != nil {
+		r.logger.Warn("Failed to write heartbeat", "error", err)
+	}
+}
@@ -0,0 +1,126 @@
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/database"
+)
+
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusDown        = "down"
+)
+
+// Services are always listed, so a service that never started shows as
+// down instead of being missing from the page.
+var Services = []string{"api-gateway", "device-service", "billing-service", "notification-service"}
+
+// summaryTTL is how long the monitor reuses a summary, so a popular status
+// page costs one Redis read every few seconds.
+const summaryTTL = 10 * time.Second
+
+var severity = map[string]int{StatusOperational: 0, StatusDegraded: 1, StatusDown: 2}
+
+// ServiceStatus is one service's state on the status page. Version and
+// LastHeartbeat come from its most recent beat.
+type ServiceStatus struct {
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	Version       string     `json:"version,omitempty"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	Instances     int        `json:"instances"`
+}
+
+// Summary is the state of the whole platform: the worst of its services.
+type Summary struct {
+	Status    string          `json:"status"`
+	Services  []ServiceStatus `json:"services"`
+	CheckedAt time.Time       `json:"checked_at"`
+}
+
+// Monitor composes instances' beats into a Summary.
+type Monitor struct {
+	redis *database.RedisClient
+
+	mu      sync.Mutex
+	summary *Summary
+}
+
+func NewMonitor(redis *database.RedisClient) *Monitor {
+	return &Monitor{redis: redis}
+}
+
+// Status returns the platform's state, at most summaryTTL old.
+func (m *Monitor) Status(ctx context.Context) (*Summary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.summary != nil && time.Since(m.summary.CheckedAt) < summaryTTL {
+		return m.summary, nil
+	}
+
+	fields, err := m.redis.HGetAll(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var beats []Beat
+	var gone []string
+	for field, value := range fields {
+		var beat Beat
+		if err := json.Unmarshal([]byte(value), &beat); err != nil || now.Sub(beat.At) > pruneAfter {
+			gone = append(gone, field)
+			continue
+		}
+		beats = append(beats, beat)
+	}
+	if len(gone) > 0 {
+		m.redis.HDel(ctx, key, gone...)
+	}
+
+	m.summary = summarize(beats, now)
+	return m.summary, nil
+}
+
+// summarize rates each service: operational when every live instance's
+// checks pass, degraded when some fail, and down with no live instance.
+func summarize(beats []Beat, now time.Time) *Summary {
+	byService := make(map[string][]Beat)
+	for _, beat := range beats {
+		byService[beat.Service] = append(byService[beat.Service], beat)
+	}
+
+	summary := &Summary{Status: StatusOperational, CheckedAt: now}
+	for _, name := range Services {
+		service := ServiceStatus{Name: name, Status: StatusDown}
+		failing := false
+		for _, beat := range byService[name] {
+			if service.LastHeartbeat == nil || beat.At.After(*service.LastHeartbeat) {
+				at := beat.At
+				service.LastHeartbeat = &at
+				service.Version = beat.Version
+			}
+			if now.Sub(beat.At) <= staleAfter {
+				service.Instances++
+				failing = failing || len(beat.Failing) > 0
+			}
+		}
+
+		switch {
+		case service.Instances > 0 && failing:
+			service.Status = StatusDegraded
+		case service.Instances > 0:
+			service.Status = StatusOperational
+		}
+		if severity[service.Status] > severity[summary.Status] {
+			summary.Status = service.Status
+		}
+		summary.Services = append(summary.Services, service)
+	}
+	return summary
+}
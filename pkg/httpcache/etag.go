@@ -0,0 +1,67 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cache-Control policies for the different kinds of GET endpoints. Responses
+// are per-user so they are never cacheable by shared proxies.
+const (
+	// Registry data such as device details changes rarely
+	Registry = "private, max-age=60, must-revalidate"
+	// Lists and live status are polled by dashboards and must revalidate
+	Status = "private, no-cache"
+)
+
+// JSON writes obj as JSON with a content-hash ETag, answering 304 Not Modified
+// when the client's If-None-Match already matches. Only successful responses
+// carry an ETag.
+func JSON(c *gin.Context, status int, obj interface{}, cacheControl string) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode response"})
+		return
+	}
+
+	if status != http.StatusOK {
+		c.Data(status, "application/json; charset=utf-8", body)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cacheControl)
+
+	if matches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(status, "application/json; charset=utf-8", body)
+}
+
+func matches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		// If-None-Match uses weak comparison
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+const adminTimeout = 30 * time.Second
+
+// EnsureTopics creates whichever of the named topics don't exist yet, laid
+// out as configured under kafka.topic_defaults and kafka.topic_overrides.
+// It does nothing unless kafka.auto_create_topics is set. Existing topics
+// are never changed, and a topic another service creates concurrently
+// counts as success.
+func EnsureTopics(ctx context.Context, cfg *config.Config, log logger.Logger, topics ...string) error {
+	if !cfg.Kafka.AutoCreateTopics || len(topics) == 0 {
+		return nil
+	}
+
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{
+		"bootstrap.servers": strings.Join(cfg.Kafka.Brokers, ","),
+	})
+	if err != nil {
+		return fmt.Errorf("creating Kafka admin client: %w", err)
+	}
+	defer admin.Close()
+
+	metadata, err := admin.GetMetadata(nil, true, int(adminTimeout/time.Millisecond))
+	if err != nil {
+		return fmt.Errorf("reading Kafka metadata: %w", err)
+	}
+
+	var specs []kafka.TopicSpecification
+	requested := make(map[string]kafka.TopicSpecification, len(topics))
+	for _, name := range topics {
+		if _, duplicate := requested[name]; duplicate {
+			continue
+		}
+		if _, exists := metadata.Topics[name]; exists {
+			continue
+		}
+
+		topic := cfg.Topic(name)
+		spec := kafka.TopicSpecification{
+			Topic:             name,
+			NumPartitions:     topic.Partitions,
+			ReplicationFactor: topic.ReplicationFactor,
+		}
+		if topic.Retention > 0 {
+			spec.Config = map[string]string{
+				"retention.ms": strconv.FormatInt(topic.Retention.Milliseconds(), 10),
+			}
+		}
+		specs = append(specs, spec)
+		requested[name] = spec
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+
+	results, err := admin.CreateTopics(ctx, specs, kafka.SetAdminOperationTimeout(adminTimeout))
+	if err != nil {
+		return fmt.Errorf("creating Kafka topics: %w", err)
+	}
+
+	var failed []string
+	for _, result := range results {
+		switch result.Error.Code() {
+		case kafka.ErrNoError:
+			log.Info("Created Kafka topic",
+				"topic", result.Topic,
+				"partitions", requested[result.Topic].NumPartitions,
+				"replication_factor", requested[result.Topic].ReplicationFactor,
+			)
+		case kafka.ErrTopicAlreadyExists:
+		default:
+			failed = append(failed, fmt.Sprintf("%s: %v", result.Topic, result.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("creating Kafka topics: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
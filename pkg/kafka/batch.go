@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// ProcessBatch handles a polled batch with up to concurrency workers and
+// returns once every message is handled, so the caller can then commit the
+// batch's offsets. Messages with the same key go to the same worker in the
+// order they were polled; keyless messages are spread across workers with
+// no ordering between them.
+//
+// It returns the messages that weren't handled: those handle failed on,
+// and any after them from the same partition on the same worker, which
+// are left alone so a key's messages are never handled out of order.
+func ProcessBatch(messages []*Message, concurrency int, handle func(msg *Message) error) []*Message {
+	if concurrency > len(messages) {
+		concurrency = len(messages)
+	}
+	if concurrency <= 1 {
+		return processLane(messages, handle)
+	}
+
+	lanes := make([][]*Message, concurrency)
+	for i, msg := range messages {
+		lane := i % concurrency
+		if len(msg.Key) > 0 {
+			hash := fnv.New32a()
+			hash.Write(msg.Key)
+			lane = int(hash.Sum32() % uint32(concurrency))
+		}
+		lanes[lane] = append(lanes[lane], msg)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		unhandled []*Message
+	)
+	for _, lane := range lanes {
+		if len(lane) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(lane []*Message) {
+			defer wg.Done()
+			if failed := processLane(lane, handle); len(failed) > 0 {
+				mu.Lock()
+				unhandled = append(unhandled, failed...)
+				mu.Unlock()
+			}
+		}(lane)
+	}
+	wg.Wait()
+	return unhandled
+}
+
+// processLane handles one worker's messages in order, stopping at the
+// first failure in each partition.
+func processLane(lane []*Message, handle func(msg *Message) error) []*Message {
+	var unhandled []*Message
+	stopped := make(map[partitionKey]bool)
+	for _, msg := range lane {
+		key := partitionKey{msg.Topic, msg.Partition}
+		if stopped[key] {
+			unhandled = append(unhandled, msg)
+			continue
+		}
+		if err := handle(msg); err != nil {
+			stopped[key] = true
+			unhandled = append(unhandled, msg)
+		}
+	}
+	return unhandled
+}
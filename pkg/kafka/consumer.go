@@ -0,0 +1,309 @@
+package kafka
+
+import (
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// The most messages one ConsumeMessages call returns
+const maxPollMessages = 500
+
+// How long a rewind waits for the client to move a partition's position
+const seekTimeout = 5 * time.Second
+
+// Consumer reads topics as a member of a consumer group. Offsets are
+// committed only by Commit, so a consume loop commits a batch once every
+// message in it is handled, and a batch interrupted by a crash is read
+// again (at least once). A Consumer serves a single consume loop; give
+// each loop its own.
+//
+// Rebalances keep the same guarantee. They happen during ConsumeMessages,
+// when the loop has finished with everything it was given before, so
+// losing partitions commits what was handed out and drops what the call
+// has polled from them but not yet returned; their next owner reads it.
+// Nothing is polled between a revoke and the assignment that follows it.
+//
+// HandleBatch extends this to messages that fail: their partition is
+// committed only up to the first failure and rewound to it, so it is read
+// again by the next poll.
+type Consumer struct {
+	client client
+	topics []string
+
+	// Next offset to commit for each partition, for messages returned
+	// since the last commit
+	offsets map[partitionKey]kafka.TopicPartition
+
+	// The batch ConsumeMessages is gathering, and its offsets
+	batch  []*Message
+	polled map[partitionKey]kafka.TopicPartition
+
+	// Rewinds the client hasn't taken yet. Nothing is polled until it has,
+	// as it would hand out messages past a failure.
+	seeks map[partitionKey]kafka.TopicPartition
+}
+
+// client is the part of kafka.Consumer a Consumer uses.
+type client interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	Poll(timeoutMs int) kafka.Event
+	CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	Assign(partitions []kafka.TopicPartition) error
+	Unassign() error
+	Seek(partition kafka.TopicPartition, timeoutMs int) error
+	Close() error
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+func keyOf(partition kafka.TopicPartition) partitionKey {
+	topic := ""
+	if partition.Topic != nil {
+		topic = *partition.Topic
+	}
+	return partitionKey{topic, partition.Partition}
+}
+
+func NewConsumer(brokers []string, group string) (*Consumer, error) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  strings.Join(brokers, ","),
+		"group.id":           group,
+		"enable.auto.commit": false,
+		// A new group starts from the oldest retained message rather than
+		// skipping what was published before it first joined
+		"auto.offset.reset": "earliest",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newConsumer(consumer), nil
+}
+
+func newConsumer(client client) *Consumer {
+	return &Consumer{
+		client:  client,
+		offsets: make(map[partitionKey]kafka.TopicPartition),
+		seeks:   make(map[partitionKey]kafka.TopicPartition),
+	}
+}
+
+// ConsumeMessages returns the messages available on topics, waiting up to
+// timeout for the first. It returns an empty batch if none arrive in time.
+// The first call, and any call naming different topics, subscribes to
+// them.
+func (c *Consumer) ConsumeMessages(topics []string, timeout time.Duration) ([]*Message, error) {
+	if !sameTopics(c.topics, topics) {
+		if err := c.client.SubscribeTopics(topics, c.rebalanced); err != nil {
+			return nil, err
+		}
+		c.topics = append([]string(nil), topics...)
+	}
+	if err := c.seek(); err != nil {
+		return nil, err
+	}
+
+	c.batch, c.polled = nil, make(map[partitionKey]kafka.TopicPartition)
+	deadline := time.Now().Add(timeout)
+	for len(c.batch) < maxPollMessages {
+		// Once something has arrived, take only what is already fetched
+		wait := time.Until(deadline)
+		if len(c.batch) > 0 || wait < 0 {
+			wait = 0
+		}
+
+		switch event := c.client.Poll(int(wait / time.Millisecond)).(type) {
+		case nil:
+			if len(c.batch) > 0 || !time.Now().Before(deadline) {
+				return c.handOut(), nil
+			}
+		case *kafka.Message:
+			c.received(event)
+		case kafka.Error:
+			// Anything else is retried by the client itself
+			if event.IsFatal() {
+				return c.handOut(), event
+			}
+		}
+	}
+	return c.handOut(), nil
+}
+
+// received adds a polled message to the batch.
+func (c *Consumer) received(message *kafka.Message) {
+	partition := message.TopicPartition
+	key := keyOf(partition)
+
+	next := partition
+	next.Offset = partition.Offset + 1
+	c.polled[key] = next
+
+	c.batch = append(c.batch, &Message{
+		Topic:     key.topic,
+		Partition: partition.Partition,
+		Offset:    int64(partition.Offset),
+		Key:       message.Key,
+		Value:     message.Value,
+	})
+}
+
+// handOut returns the gathered batch, recording its offsets for Commit.
+func (c *Consumer) handOut() []*Message {
+	for key, offset := range c.polled {
+		c.offsets[key] = offset
+	}
+	batch := c.batch
+	c.batch, c.polled = nil, nil
+	return batch
+}
+
+// rebalanced is called from Poll when the group's partitions move.
+func (c *Consumer) rebalanced(_ *kafka.Consumer, event kafka.Event) error {
+	switch event := event.(type) {
+	case kafka.AssignedPartitions:
+		metrics.KafkaRebalances.WithLabelValues("assigned", "ok").Add(float64(len(event.Partitions)))
+		return c.client.Assign(event.Partitions)
+	case kafka.RevokedPartitions:
+		return c.revoked(event.Partitions)
+	}
+	return nil
+}
+
+// revoked gives up partitions: it commits what was handed out from them
+// while they are still ours, and drops the part of the batch in progress
+// that came from them. Commit must not be sent offsets for partitions we
+// no longer own, so they are forgotten even if their commit fails; their
+// next owner then reads those messages again.
+func (c *Consumer) revoked(partitions []kafka.TopicPartition) error {
+	lost := make(map[partitionKey]bool, len(partitions))
+	for _, partition := range partitions {
+		lost[keyOf(partition)] = true
+	}
+
+	kept := c.batch[:0]
+	for _, message := range c.batch {
+		if !lost[partitionKey{message.Topic, message.Partition}] {
+			kept = append(kept, message)
+		}
+	}
+	c.batch = kept
+	for key := range lost {
+		delete(c.polled, key)
+		delete(c.seeks, key)
+	}
+
+	var handed []kafka.TopicPartition
+	for key, offset := range c.offsets {
+		if lost[key] {
+			handed = append(handed, offset)
+			delete(c.offsets, key)
+		}
+	}
+
+	result := "ok"
+	var err error
+	if len(handed) > 0 {
+		if _, err = c.client.CommitOffsets(handed); err != nil {
+			result = "error"
+		}
+	}
+	metrics.KafkaRebalances.WithLabelValues("revoked", result).Add(float64(len(partitions)))
+
+	if unassignErr := c.client.Unassign(); err == nil {
+		err = unassignErr
+	}
+	return err
+}
+
+// Commit commits the offsets of every message returned since the last
+// commit, or, for a partition HandleBatch rewound, up to the message it
+// rewound to. Call it once they have all been handled.
+func (c *Consumer) Commit() error {
+	if len(c.offsets) == 0 {
+		return nil
+	}
+
+	offsets := make([]kafka.TopicPartition, 0, len(c.offsets))
+	for _, offset := range c.offsets {
+		offsets = append(offsets, offset)
+	}
+	if _, err := c.client.CommitOffsets(offsets); err != nil {
+		return err
+	}
+	c.offsets = make(map[partitionKey]kafka.TopicPartition)
+	return nil
+}
+
+// HandleBatch processes a batch returned by ConsumeMessages as
+// ProcessBatch does, then commits it. A message handle fails on is tried
+// up to three times. If it still fails, its partition is committed only
+// up to it and rewound to it, so the next poll returns it, and anything
+// after it, again. A message handle reports with Poison is not retried
+// but sent to its topic's dead-letter topic through deadLetters, or
+// dropped if deadLetters is nil, and the batch moves past it.
+//
+// The error is from rewinding or committing; handle's own errors are for
+// it to log.
+func (c *Consumer) HandleBatch(messages []*Message, concurrency int, deadLetters DeadLetters, handle func(msg *Message) error) error {
+	failed := ProcessBatch(messages, concurrency, func(msg *Message) error {
+		return attempt(msg, deadLetters, handle)
+	})
+
+	err := c.rewind(failed)
+	if commitErr := c.Commit(); err == nil {
+		err = commitErr
+	}
+	return err
+}
+
+// rewind moves each partition with failed messages back to the earliest
+// of them, for both the next poll and the next commit.
+func (c *Consumer) rewind(failed []*Message) error {
+	for _, msg := range failed {
+		key := partitionKey{msg.Topic, msg.Partition}
+		if seek, ok := c.seeks[key]; ok && int64(seek.Offset) <= msg.Offset {
+			continue
+		}
+		topic := msg.Topic
+		c.seeks[key] = kafka.TopicPartition{Topic: &topic, Partition: msg.Partition, Offset: kafka.Offset(msg.Offset)}
+	}
+	for key, seek := range c.seeks {
+		c.offsets[key] = seek
+		metrics.KafkaRewinds.WithLabelValues(key.topic).Inc()
+	}
+	return c.seek()
+}
+
+// seek hands the client the rewinds it hasn't taken yet.
+func (c *Consumer) seek() error {
+	for key, partition := range c.seeks {
+		if err := c.client.Seek(partition, int(seekTimeout/time.Millisecond)); err != nil {
+			return err
+		}
+		delete(c.seeks, key)
+	}
+	return nil
+}
+
+// Close leaves the consumer group, so its partitions are reassigned
+// without waiting for the session to time out.
+func (c *Consumer) Close() {
+	c.client.Close()
+}
+
+func sameTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,338 @@
+package kafka
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// fakeClient plays back a script of poll results. Rebalance events are
+// passed to the subscriber's callback, as librdkafka does from Poll.
+type fakeClient struct {
+	script   []kafka.Event
+	callback kafka.RebalanceCb
+
+	commitErrs []error
+	commits    [][]kafka.TopicPartition
+	assigned   [][]kafka.TopicPartition
+	unassigned int
+
+	seekErrs []error
+	seeks    []kafka.TopicPartition
+}
+
+func (f *fakeClient) SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error {
+	f.callback = rebalanceCb
+	return nil
+}
+
+func (f *fakeClient) Poll(timeoutMs int) kafka.Event {
+	if len(f.script) == 0 {
+		return nil
+	}
+	event := f.script[0]
+	f.script = f.script[1:]
+
+	switch event.(type) {
+	case kafka.AssignedPartitions, kafka.RevokedPartitions:
+		f.callback(nil, event)
+		return nil
+	}
+	return event
+}
+
+func (f *fakeClient) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	f.commits = append(f.commits, offsets)
+	if len(f.commitErrs) > 0 {
+		err := f.commitErrs[0]
+		f.commitErrs = f.commitErrs[1:]
+		return nil, err
+	}
+	return offsets, nil
+}
+
+func (f *fakeClient) Assign(partitions []kafka.TopicPartition) error {
+	f.assigned = append(f.assigned, partitions)
+	return nil
+}
+
+func (f *fakeClient) Unassign() error {
+	f.unassigned++
+	return nil
+}
+
+func (f *fakeClient) Seek(partition kafka.TopicPartition, timeoutMs int) error {
+	f.seeks = append(f.seeks, partition)
+	if len(f.seekErrs) > 0 {
+		err := f.seekErrs[0]
+		f.seekErrs = f.seekErrs[1:]
+		return err
+	}
+	return nil
+}
+
+func (f *fakeClient) Close() error {
+	return nil
+}
+
+var testTopic = "device-data"
+
+func partition(number int32) kafka.TopicPartition {
+	return kafka.TopicPartition{Topic: &testTopic, Partition: number}
+}
+
+func message(number int32, offset int64) *kafka.Message {
+	tp := partition(number)
+	tp.Offset = kafka.Offset(offset)
+	return &kafka.Message{TopicPartition: tp}
+}
+
+type position struct {
+	partition int32
+	offset    int64
+}
+
+func positions(offsets []kafka.TopicPartition) []position {
+	result := make([]position, 0, len(offsets))
+	for _, offset := range offsets {
+		result = append(result, position{offset.Partition, int64(offset.Offset)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].partition < result[j].partition })
+	return result
+}
+
+func received(messages []*Message) []position {
+	result := make([]position, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, position{message.Partition, message.Offset})
+	}
+	return result
+}
+
+func TestConsumerRebalance(t *testing.T) {
+	fake := &fakeClient{
+		script: []kafka.Event{
+			kafka.AssignedPartitions{Partitions: []kafka.TopicPartition{partition(0), partition(1)}},
+			message(0, 10),
+			message(1, 20),
+			nil,
+		},
+		commitErrs: []error{errors.New("coordinator unavailable")},
+	}
+	consumer := newConsumer(fake)
+	topics := []string{testTopic}
+
+	batch, err := consumer.ConsumeMessages(topics, time.Second)
+	if err != nil {
+		t.Fatalf("first poll: %v", err)
+	}
+	if got, want := received(batch), []position{{0, 10}, {1, 20}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first batch = %v, want %v", got, want)
+	}
+	if len(fake.assigned) != 1 {
+		t.Fatalf("assigned %d times, want 1", len(fake.assigned))
+	}
+
+	// The batch is handled but its commit fails, so its offsets are still
+	// outstanding when the group rebalances during the next poll
+	if err := consumer.Commit(); err == nil {
+		t.Fatal("commit succeeded, want the scripted failure")
+	}
+
+	fake.script = []kafka.Event{
+		message(0, 11),
+		message(1, 21),
+		kafka.RevokedPartitions{Partitions: []kafka.TopicPartition{partition(0), partition(1)}},
+		kafka.AssignedPartitions{Partitions: []kafka.TopicPartition{partition(0)}},
+		message(0, 11),
+		nil,
+	}
+	batch, err = consumer.ConsumeMessages(topics, time.Second)
+	if err != nil {
+		t.Fatalf("second poll: %v", err)
+	}
+
+	// The handed-out batch is committed before the partitions go
+	if len(fake.commits) != 2 {
+		t.Fatalf("%d commits, want 2", len(fake.commits))
+	}
+	if got, want := positions(fake.commits[1]), []position{{0, 11}, {1, 21}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committed on revoke %v, want %v", got, want)
+	}
+	if fake.unassigned != 1 {
+		t.Errorf("unassigned %d times, want 1", fake.unassigned)
+	}
+	if len(fake.assigned) != 2 || !reflect.DeepEqual(positions(fake.assigned[1]), []position{{0, 0}}) {
+		t.Errorf("assignments = %v, want partition 0 reassigned", fake.assigned)
+	}
+
+	// What was polled before the revoke is left to the new owner; only
+	// the redelivery after reassignment is returned
+	if got, want := received(batch), []position{{0, 11}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("second batch = %v, want %v", got, want)
+	}
+
+	// Partition 1 is no longer ours, so it isn't committed again
+	if err := consumer.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if got, want := positions(fake.commits[2]), []position{{0, 12}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committed %v, want %v", got, want)
+	}
+}
+
+func TestConsumerRevokeWithNothingHandedOut(t *testing.T) {
+	fake := &fakeClient{
+		script: []kafka.Event{
+			kafka.AssignedPartitions{Partitions: []kafka.TopicPartition{partition(0)}},
+			message(0, 5),
+			kafka.RevokedPartitions{Partitions: []kafka.TopicPartition{partition(0)}},
+			nil,
+		},
+	}
+	consumer := newConsumer(fake)
+
+	batch, err := consumer.ConsumeMessages([]string{testTopic}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(batch) != 0 {
+		t.Errorf("batch = %v, want the revoked partition's message dropped", received(batch))
+	}
+	if len(fake.commits) != 0 {
+		t.Errorf("committed %v on revoke, want nothing as nothing was handed out", fake.commits)
+	}
+	if err := consumer.Commit(); err != nil || len(fake.commits) != 0 {
+		t.Errorf("commit after revoke sent %v (err %v), want nothing", fake.commits, err)
+	}
+}
+
+// fakeDeadLetters records what is dead-lettered, failing while err is set.
+type fakeDeadLetters struct {
+	err    error
+	topics []string
+}
+
+func (f *fakeDeadLetters) ProduceMessage(topic, key string, value []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.topics = append(f.topics, topic)
+	return nil
+}
+
+func TestHandleBatchRewindsFailedPartition(t *testing.T) {
+	retryBackoff = 0
+	fake := &fakeClient{
+		script: []kafka.Event{
+			kafka.AssignedPartitions{Partitions: []kafka.TopicPartition{partition(0), partition(1)}},
+			message(0, 10),
+			message(0, 11),
+			message(0, 12),
+			message(1, 20),
+			nil,
+		},
+		seekErrs: []error{errors.New("partition busy")},
+	}
+	consumer := newConsumer(fake)
+
+	batch, err := consumer.ConsumeMessages([]string{testTopic}, time.Second)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	attempts := make(map[int64]int)
+	err = consumer.HandleBatch(batch, 1, nil, func(msg *Message) error {
+		attempts[msg.Offset]++
+		if msg.Partition == 0 && msg.Offset == 11 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("HandleBatch succeeded, want the scripted seek failure")
+	}
+
+	if attempts[11] != handleAttempts {
+		t.Errorf("failing message tried %d times, want %d", attempts[11], handleAttempts)
+	}
+	if attempts[12] != 0 {
+		t.Errorf("message after the failure handled %d times, want it left for the rewind", attempts[12])
+	}
+
+	// The failed message's offset isn't committed; the other partition is
+	if len(fake.commits) != 1 {
+		t.Fatalf("%d commits, want 1", len(fake.commits))
+	}
+	if got, want := positions(fake.commits[0]), []position{{0, 11}, {1, 21}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committed %v, want %v", got, want)
+	}
+
+	// The seek failed, so the next poll retries it before reading anything
+	fake.script = []kafka.Event{message(0, 11), nil}
+	batch, err = consumer.ConsumeMessages([]string{testTopic}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("poll after rewind: %v", err)
+	}
+	if got, want := positions(fake.seeks), []position{{0, 11}, {0, 11}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("seeks = %v, want partition 0 rewound to 11 and retried", got)
+	}
+	if got, want := received(batch), []position{{0, 11}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batch after rewind = %v, want %v", got, want)
+	}
+}
+
+func TestHandleBatchDeadLettersPoison(t *testing.T) {
+	retryBackoff = 0
+	fake := &fakeClient{
+		script: []kafka.Event{
+			kafka.AssignedPartitions{Partitions: []kafka.TopicPartition{partition(0)}},
+			message(0, 5),
+			nil,
+		},
+	}
+	consumer := newConsumer(fake)
+	deadLetters := &fakeDeadLetters{err: errors.New("broker down")}
+
+	attempts := 0
+	handle := func(msg *Message) error {
+		attempts++
+		return Poison(errors.New("not JSON"))
+	}
+
+	batch, err := consumer.ConsumeMessages([]string{testTopic}, time.Second)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	// A poison message that can't be dead-lettered is read again
+	if err := consumer.HandleBatch(batch, 1, deadLetters, handle); err != nil {
+		t.Fatalf("HandleBatch: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("poison message tried %d times, want 1", attempts)
+	}
+	if got, want := positions(fake.commits[0]), []position{{0, 5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committed %v, want %v", got, want)
+	}
+
+	deadLetters.err = nil
+	fake.script = []kafka.Event{message(0, 5), nil}
+	batch, err = consumer.ConsumeMessages([]string{testTopic}, time.Second)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if err := consumer.HandleBatch(batch, 1, deadLetters, handle); err != nil {
+		t.Fatalf("HandleBatch: %v", err)
+	}
+	if !reflect.DeepEqual(deadLetters.topics, []string{"device-data.dlq"}) {
+		t.Errorf("dead-lettered to %v, want device-data.dlq", deadLetters.topics)
+	}
+	if got, want := positions(fake.commits[1]), []position{{0, 6}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committed %v, want %v", got, want)
+	}
+}
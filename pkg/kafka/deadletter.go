@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/metrics"
+)
+
+// How many times HandleBatch tries a message before giving up on it for
+// the batch, and how much longer it waits before each retry
+const handleAttempts = 3
+
+var retryBackoff = 200 * time.Millisecond
+
+// DeadLetters receives messages no retry can handle. *Producer is one.
+type DeadLetters interface {
+	ProduceMessage(topic, key string, value []byte) error
+}
+
+// DeadLetterTopic is where messages from topic that can't be handled go.
+func DeadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+type poisonError struct {
+	err error
+}
+
+func (e poisonError) Error() string { return e.err.Error() }
+func (e poisonError) Unwrap() error { return e.err }
+
+// Poison marks a handler error as one retrying won't fix, such as a
+// payload that doesn't decode. HandleBatch dead-letters the message
+// instead of reading it again.
+func Poison(err error) error {
+	if err == nil {
+		return nil
+	}
+	return poisonError{err}
+}
+
+// IsPoison reports whether err, or an error it wraps, was marked by Poison.
+func IsPoison(err error) bool {
+	var poison poisonError
+	return errors.As(err, &poison)
+}
+
+// attempt handles a message, retrying failures, and dead-letters it if
+// handle reports it as poison. The message counts as handled once it is
+// dead-lettered; if that fails it is read again like any other failure.
+func attempt(msg *Message, deadLetters DeadLetters, handle func(msg *Message) error) error {
+	var err error
+	for try := 1; try <= handleAttempts; try++ {
+		if err = handle(msg); err == nil {
+			return nil
+		}
+		if IsPoison(err) {
+			return deadLetter(msg, deadLetters)
+		}
+		if try < handleAttempts {
+			time.Sleep(time.Duration(try) * retryBackoff)
+		}
+	}
+	return err
+}
+
+func deadLetter(msg *Message, deadLetters DeadLetters) error {
+	if deadLetters == nil {
+		metrics.KafkaDeadLetters.WithLabelValues(msg.Topic, "dropped").Inc()
+		return nil
+	}
+	if err := deadLetters.ProduceMessage(DeadLetterTopic(msg.Topic), string(msg.Key), msg.Value); err != nil {
+		metrics.KafkaDeadLetters.WithLabelValues(msg.Topic, "error").Inc()
+		return err
+	}
+	metrics.KafkaDeadLetters.WithLabelValues(msg.Topic, "ok").Inc()
+	return nil
+}
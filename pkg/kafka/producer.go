@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// How long Close waits for queued messages to be delivered
+const flushTimeout = 10 * time.Second
+
+// Message is one record read from or written to a topic.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+}
+
+// Producer publishes messages for the services. It is safe for concurrent
+// use.
+type Producer struct {
+	producer *kafka.Producer
+}
+
+func NewProducer(brokers []string) (*Producer, error) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": strings.Join(brokers, ","),
+		// Every in-sync replica has the message before it counts as sent,
+		// and retries never duplicate or reorder it
+		"acks":               "all",
+		"enable.idempotence": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Producer{producer: producer}, nil
+}
+
+// ProduceMessage publishes value to topic and waits until the brokers have
+// it. Messages with the same key go to the same partition, so consumers
+// see them in the order they were produced.
+func (p *Producer) ProduceMessage(topic, key string, value []byte) error {
+	message := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          value,
+	}
+	if key != "" {
+		message.Key = []byte(key)
+	}
+
+	delivered := make(chan kafka.Event, 1)
+	if err := p.producer.Produce(message, delivered); err != nil {
+		return err
+	}
+	report, ok := (<-delivered).(*kafka.Message)
+	if !ok {
+		return kafka.NewError(kafka.ErrUnknown, "unexpected delivery report", false)
+	}
+	return report.TopicPartition.Error
+}
+
+// Close waits up to flushTimeout for messages still queued to be delivered
+// and releases the producer.
+func (p *Producer) Close() {
+	p.producer.Flush(int(flushTimeout / time.Millisecond))
+	p.producer.Close()
+}
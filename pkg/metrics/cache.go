@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// CacheLookups counts reads of the in-process reference data caches.
+var CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_cache_lookups_total",
+	Help: "In-process cache lookups, by cache and result (hit or miss).",
+}, []string{"cache", "result"})
+
+// CacheEvictions counts entries dropped before being read again: expired,
+// pushed out by the size bound, or invalidated after a change.
+var CacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_cache_evictions_total",
+	Help: "In-process cache entries dropped, by cache and reason (expired, capacity or invalidated).",
+}, []string{"cache", "reason"})
+
+// CacheEntries is the number of entries each in-process cache holds.
+var CacheEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "urbanzen_cache_entries",
+	Help: "Entries held by each in-process cache.",
+}, []string{"cache"})
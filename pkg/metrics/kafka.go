@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// KafkaRebalances counts partitions assigned to and revoked from this
+// process's consumers. A revoke whose commit failed has result "error":
+// the partitions' next owner reads those messages again.
+var KafkaRebalances = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_kafka_rebalances_total",
+	Help: "Consumer group rebalances seen by this process, by event and result.",
+}, []string{"event", "result"})
+
+// KafkaDeadLetters counts messages consumers gave up on as poison, by the
+// topic they came from. Result "error" means the dead-letter topic
+// couldn't take the message, so it is read again; "dropped" means the
+// consumer had nowhere to send it.
+var KafkaDeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_kafka_dead_letters_total",
+	Help: "Poison messages sent to dead-letter topics, by source topic and result.",
+}, []string{"topic", "result"})
+
+// KafkaRewinds counts partitions a consumer rewound to a message it
+// failed to handle, so it is read again rather than committed.
+var KafkaRewinds = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_kafka_rewinds_total",
+	Help: "Partitions rewound to a message that failed handling, by topic.",
+}, []string{"topic"})
@@ -0,0 +1,13 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RequestsShed counts requests refused by load shedding, by the load
+// signal that was over the threshold.
+var RequestsShed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_requests_shed_total",
+	Help: "Requests refused with 503 because the service was overloaded, by load signal.",
+}, []string{"signal"})
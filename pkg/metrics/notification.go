@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// NotificationHolds follows notifications no channel could take: held when
+// first set aside, released when sent after a recovery, and undeliverable
+// when they expire still held.
+var NotificationHolds = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_notification_holds_total",
+	Help: "Notifications held for lack of an available channel, by priority and outcome (held, released or undeliverable).",
+}, []string{"priority", "outcome"})
+
+// NotificationEscalations counts copies of held emergency notifications
+// sent to users' alternate contacts.
+var NotificationEscalations = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "urbanzen_notification_escalations_total",
+	Help: "Copies of held emergency notifications created for alternate contacts.",
+})
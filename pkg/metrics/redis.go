@@ -0,0 +1,14 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RedisFallbacks counts operations a feature served without Redis because
+// it failed or was unreachable. docs/OPERATIONS.md describes what each
+// feature does in that case.
+var RedisFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_redis_fallbacks_total",
+	Help: "Operations served by a fallback because Redis was unavailable, by feature.",
+}, []string{"feature"})
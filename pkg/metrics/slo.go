@@ -0,0 +1,87 @@
+// Package metrics defines the service-level metrics operators alert on.
+// Each is incremented at one place per service so rates are comparable
+// across instances; monitoring/alert_rules.yml holds the matching alerts.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Ingestion outcomes
+const (
+	IngestProcessed = "processed" // stored and analysed
+	IngestInvalid   = "invalid"   // malformed, unknown device or bad units: the sender's fault
+	IngestFailed    = "failed"    // a lookup or write on our side failed
+	IngestRejected  = "rejected"  // refused by back-pressure, the sender should retry
+)
+
+// Delivery and publish outcomes
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// IngestMessages counts telemetry messages by outcome. The ingestion error
+// rate is failed over all results; invalid messages are excluded because
+// they point at a misbehaving device rather than the platform.
+var IngestMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_slo_ingest_messages_total",
+	Help: "Telemetry messages by outcome: processed, invalid, failed or rejected.",
+}, []string{"result"})
+
+// NotificationDeliveries counts send attempts per channel after provider
+// retries, so one notification failing over from push to email counts a
+// push failure and an email success. The notification failure rate is
+// failures over all attempts.
+var NotificationDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_slo_notification_deliveries_total",
+	Help: "Notification send attempts by channel and result (success or failure).",
+}, []string{"channel", "result"})
+
+// NotificationPublishes counts notifications services hand to Kafka for
+// the notification service. A failure here means the user is never
+// notified, so it belongs in the notification failure rate too.
+var NotificationPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "urbanzen_slo_notification_publishes_total",
+	Help: "Notifications published for delivery by source service and result (success or failure).",
+}, []string{"source", "result"})
+
+// CommandAckLatency is the time from a command being issued to the device
+// service recording it as executed.
+var CommandAckLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "urbanzen_slo_command_ack_latency_seconds",
+	Help:    "Seconds from a device command being issued to it being acknowledged as executed.",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+})
+
+// ConsumerLag is how old an event is when a consumer picks it up, measured
+// from the timestamp in the event itself. It includes time spent queued in
+// Kafka and, for telemetry, any device clock skew; a steady climb means the
+// consumer is falling behind.
+var ConsumerLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "urbanzen_slo_consumer_lag_seconds",
+	Help:    "Seconds between an event's own timestamp and its consumption, by consumer and topic.",
+	Buckets: []float64{0.1, 0.5, 1, 5, 15, 30, 60, 300, 900, 3600},
+}, []string{"consumer", "topic"})
+
+// Result maps an error to a success or failure label.
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// ObserveLag records the age of an event with the given timestamp. Events
+// without one, or stamped in the future, are skipped.
+func ObserveLag(consumer, topic string, eventTime time.Time) {
+	if eventTime.IsZero() {
+		return
+	}
+	if age := time.Since(eventTime); age >= 0 {
+		ConsumerLag.WithLabelValues(consumer, topic).Observe(age.Seconds())
+	}
+}
@@ -0,0 +1,119 @@
+package pagination
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+)
+
+const (
+	defaultLimit    = 20
+	defaultMaxLimit = 100
+)
+
+// Pagination is the metadata returned alongside every paginated list.
+type Pagination struct {
+	Page       int `json:"page"`
+	Limit      int `json:"limit"`
+	TotalCount int `json:"total_count"`
+	TotalPages int `json:"total_pages"`
+}
+
+func New(page, limit, totalCount int) Pagination {
+	totalPages := 0
+	if limit > 0 {
+		totalPages = (totalCount + limit - 1) / limit
+	}
+
+	return Pagination{
+		Page:       page,
+		Limit:      limit,
+		TotalCount: totalCount,
+		TotalPages: totalPages,
+	}
+}
+
+// Parse reads the page and limit query parameters. Page defaults to 1 and
+// limit to pagination.default_limit; the error, for a 400, says which is
+// not a positive integer or that limit is over pagination.max_limit.
+func Parse(c *gin.Context, cfg *config.Config) (page, limit int, err error) {
+	maxLimit := cfg.Pagination.MaxLimit
+	if maxLimit <= 0 {
+		maxLimit = defaultMaxLimit
+	}
+	limit = cfg.Pagination.DefaultLimit
+	if limit <= 0 || limit > maxLimit {
+		limit = min(defaultLimit, maxLimit)
+	}
+
+	page = 1
+	if value := c.Query("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("page must be a positive integer")
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer")
+		}
+		if limit > maxLimit {
+			return 0, 0, fmt.Errorf("limit must be at most %d", maxLimit)
+		}
+	}
+	return page, limit, nil
+}
+
+// Offset is the number of rows to skip for the current page.
+func (p Pagination) Offset() int {
+	if p.Page < 1 {
+		return 0
+	}
+	return (p.Page - 1) * p.Limit
+}
+
+// Write sets the RFC 5988 Link and X-Total-Count headers for the response.
+// Links are relative to the request so they survive proxies and rewrites.
+func (p Pagination) Write(c *gin.Context) {
+	c.Header("X-Total-Count", strconv.Itoa(p.TotalCount))
+
+	if link := p.linkHeader(c.Request.URL); link != "" {
+		c.Header("Link", link)
+	}
+}
+
+func (p Pagination) linkHeader(requestURL *url.URL) string {
+	if p.TotalPages == 0 {
+		return ""
+	}
+
+	var links []string
+	add := func(rel string, page int) {
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, pageURL(requestURL, page, p.Limit), rel))
+	}
+
+	add("first", 1)
+	if p.Page > 1 {
+		add("prev", min(p.Page-1, p.TotalPages))
+	}
+	if p.Page < p.TotalPages {
+		add("next", p.Page+1)
+	}
+	add("last", p.TotalPages)
+
+	return strings.Join(links, ", ")
+}
+
+func pageURL(requestURL *url.URL, page, limit int) string {
+	query := requestURL.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("limit", strconv.Itoa(limit))
+
+	u := url.URL{Path: requestURL.Path, RawQuery: query.Encode()}
+	return u.String()
+}
@@ -0,0 +1,156 @@
+// Package retry runs calls to external providers with exponential backoff,
+// retrying only failures that are likely to be transient.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxAttempts    = 3
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Jitter randomizes each wait by up to this fraction (0.2 = ±20%) so
+	// clients recovering together don't retry in lockstep
+	Jitter float64
+
+	// OnRetry, if set, is called before each wait
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+// StatusError is a provider response with a failing HTTP status.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("provider returned %d: %v", e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("provider returned %d", e.StatusCode)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus wraps a provider error with the response status so Retryable
+// can classify it.
+func HTTPStatus(statusCode int, err error) error {
+	return &StatusError{StatusCode: statusCode, Err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error as not worth retrying, such as a rejected
+// recipient.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Retryable reports whether a failed call may succeed if repeated: server
+// errors, throttling, timeouts and network failures are; other client (4xx)
+// errors, cancellation and errors marked Permanent are not. Unclassified
+// errors are retried.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+	}
+
+	// Timeouts and network failures land here too
+	return true
+}
+
+// Do calls fn until it succeeds, fails with a non-retryable error, runs out
+// of attempts or ctx ends, and returns the last error. Waits grow
+// exponentially from InitialBackoff up to MaxBackoff.
+func Do(ctx context.Context, policy Policy, fn func(ctx context.Context) error) error {
+	policy = policy.withDefaults()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || !Retryable(err) {
+			return err
+		}
+
+		wait := policy.backoff(attempt)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		p.Jitter = 0
+	}
+	return p
+}
+
+// backoff is the wait after the given (1-based) failed attempt.
+func (p Policy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		wait = time.Duration(float64(wait) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return wait
+}
@@ -0,0 +1,139 @@
+// Package shutdown stops a service's components in dependency order, so
+// work in flight is finished and flushed before the connections it needs
+// are closed. Deferred Close calls run in whatever order they were
+// registered, which can close a database under a worker still writing.
+package shutdown
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+// Step stops one component. It should return once the component has
+// stopped or ctx is done, whichever comes first.
+type Step struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+type phase struct {
+	name    string
+	timeout time.Duration
+	steps   []Step
+}
+
+// Sequence runs phases one after another. The steps of a phase run
+// together and share its deadline; a step that misses it is logged and
+// left behind so later phases still run.
+type Sequence struct {
+	log    logger.Logger
+	phases []phase
+}
+
+func New(log logger.Logger) *Sequence {
+	return &Sequence{log: log}
+}
+
+// Phase adds a phase after those already added.
+func (s *Sequence) Phase(name string, timeout time.Duration, steps ...Step) {
+	s.phases = append(s.phases, phase{name: name, timeout: timeout, steps: steps})
+}
+
+// Run stops everything, phase by phase, and reports whether every step
+// finished cleanly in time.
+func (s *Sequence) Run() bool {
+	clean := true
+	for _, phase := range s.phases {
+		started := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), phase.timeout)
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, step := range phase.steps {
+			wg.Add(1)
+			go func(step Step) {
+				defer wg.Done()
+				if err := run(ctx, step); err != nil {
+					s.log.Error("Shutdown step failed", "phase", phase.name, "step", step.Name, "error", err)
+					mu.Lock()
+					clean = false
+					mu.Unlock()
+				}
+			}(step)
+		}
+		wg.Wait()
+		cancel()
+
+		s.log.Info("Shutdown phase complete", "phase", phase.name, "took", time.Since(started).Round(time.Millisecond))
+	}
+	return clean
+}
+
+// run calls the step and gives up on it at the deadline, for steps that
+// can't be interrupted.
+func run(ctx context.Context, step Step) error {
+	done := make(chan error, 1)
+	go func() { done <- step.Run(ctx) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Server stops an HTTP server accepting requests and waits for those in
+// flight.
+func Server(name string, srv *http.Server) Step {
+	return Step{Name: name, Run: srv.Shutdown}
+}
+
+// GRPCServer is the part of *grpc.Server that GRPC needs.
+type GRPCServer interface {
+	GracefulStop()
+	Stop()
+}
+
+// GRPC stops a gRPC server accepting calls and waits for those in flight,
+// cutting them off at the deadline.
+func GRPC(name string, srv GRPCServer) Step {
+	return Step{Name: name, Run: func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			srv.Stop()
+			return ctx.Err()
+		}
+	}}
+}
+
+// Cancel stops background work by cancelling its context, then waits for
+// done to close once the work has finished.
+func Cancel(name string, cancel context.CancelFunc, done <-chan struct{}) Step {
+	return Step{Name: name, Run: func(ctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+}
+
+// Close closes a connection or client.
+func Close(name string, close func() error) Step {
+	return Step{Name: name, Run: func(context.Context) error { return close() }}
+}
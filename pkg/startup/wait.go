@@ -0,0 +1,95 @@
+// Package startup waits for a service's dependencies instead of exiting on
+// the first failed connection, so services tolerate starting before their
+// databases and brokers as they do under Kubernetes or docker-compose.
+package startup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bhanukaranwal/urbanzen/internal/config"
+	"github.com/bhanukaranwal/urbanzen/pkg/logger"
+)
+
+// Waiter retries dependency connections against a single deadline, set by
+// startup.wait_timeout, shared by everything a service waits for.
+type Waiter struct {
+	ctx      context.Context
+	cfg      *config.Config
+	log      logger.Logger
+	deadline time.Time
+}
+
+func New(ctx context.Context, cfg *config.Config, log logger.Logger) *Waiter {
+	return &Waiter{
+		ctx:      ctx,
+		cfg:      cfg,
+		log:      log,
+		deadline: time.Now().Add(cfg.Startup.WaitTimeout),
+	}
+}
+
+// Dependencies waits until Postgres, Redis and every Kafka broker accept
+// connections, logging the ones still unreachable after each attempt. It
+// does nothing unless startup.check_dependencies is set.
+func (w *Waiter) Dependencies() error {
+	if !w.cfg.Startup.CheckDependencies {
+		return nil
+	}
+	return w.Retry("dependencies", func() error {
+		return w.cfg.CheckDependencies(w.cfg.Startup.DependencyTimeout)
+	})
+}
+
+// Retry calls attempt until it succeeds, backing off exponentially between
+// failures, and gives up with the last error once the deadline has passed.
+// It always makes at least one attempt.
+func (w *Waiter) Retry(name string, attempt func() error) error {
+	backoff := w.cfg.Startup.InitialBackoff
+	started := time.Now()
+
+	for tries := 1; ; tries++ {
+		err := attempt()
+		if err == nil {
+			if tries > 1 {
+				w.log.Info("Dependency ready", "dependency", name, "attempts", tries,
+					"waited", time.Since(started).Round(time.Millisecond))
+			}
+			return nil
+		}
+
+		remaining := time.Until(w.deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("%s not ready after %d attempts: %w", name, tries, err)
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+
+		w.log.Warn("Waiting for dependency", "dependency", name, "attempt", tries,
+			"retry_in", backoff, "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-w.ctx.Done():
+			return fmt.Errorf("%s not ready: %w", name, w.ctx.Err())
+		}
+
+		backoff *= 2
+		if backoff > w.cfg.Startup.MaxBackoff {
+			backoff = w.cfg.Startup.MaxBackoff
+		}
+	}
+}
+
+// Connect is Retry for constructors that return a connection.
+func Connect[T any](w *Waiter, name string, connect func() (T, error)) (T, error) {
+	var conn T
+	err := w.Retry(name, func() error {
+		var err error
+		conn, err = connect()
+		return err
+	})
+	return conn, err
+}
@@ -0,0 +1,55 @@
+// Package timebucket aligns aggregation windows to a local calendar, so
+// daily and monthly rollups start at local midnight rather than UTC's.
+// For India (IST, UTC+05:30, no DST) even hourly buckets differ from UTC
+// ones by half an hour.
+package timebucket
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+var locations sync.Map // name -> *time.Location
+
+// LoadLocation returns the named IANA time zone, such as "Asia/Kolkata".
+// Unlike time.LoadLocation it rejects "" and "Local", which would silently
+// mean UTC or the server's own zone, and it caches lookups for hot paths.
+func LoadLocation(name string) (*time.Location, error) {
+	if cached, ok := locations.Load(name); ok {
+		return cached.(*time.Location), nil
+	}
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("time zone must be an IANA name such as Asia/Kolkata (got %q)", name)
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	locations.Store(name, location)
+	return location, nil
+}
+
+// Start returns the start of the interval-long bucket containing t. When
+// interval divides a day, buckets are counted from local midnight in loc,
+// so a daily bucket is a local calendar day even across DST changes.
+// Longer or irregular intervals fall back to UTC epoch alignment, as
+// time.Truncate does.
+func Start(t time.Time, interval time.Duration, loc *time.Location) time.Time {
+	if interval <= 0 || interval > day || day%interval != 0 {
+		return t.Truncate(interval)
+	}
+
+	local := t.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	return midnight.Add(local.Sub(midnight) / interval * interval)
+}
+
+// MonthStart returns local midnight on the first of t's month in loc.
+func MonthStart(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,116 @@
+// Package units converts measurements between units of the same quantity.
+package units
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// unit is expressed relative to its quantity's base unit:
+// base = value*factor + offset.
+type unit struct {
+	quantity string
+	factor   float64
+	offset   float64
+}
+
+type Registry struct {
+	mu    sync.RWMutex
+	units map[string]unit
+}
+
+func NewRegistry() *Registry {
+	return &Registry{units: make(map[string]unit)}
+}
+
+// Register adds a unit and any aliases it is reported under. factor and
+// offset convert a value in this unit to the quantity's base unit.
+func (r *Registry) Register(quantity string, factor, offset float64, name string, aliases ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u := unit{quantity: quantity, factor: factor, offset: offset}
+	for _, n := range append([]string{name}, aliases...) {
+		r.units[normalize(n)] = u
+	}
+}
+
+func (r *Registry) Known(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, exists := r.units[normalize(name)]
+	return exists
+}
+
+// Convert returns value, given in from, expressed in to. Both units must be
+// registered and measure the same quantity.
+func (r *Registry) Convert(value float64, from, to string) (float64, error) {
+	r.mu.RLock()
+	src, srcOK := r.units[normalize(from)]
+	dst, dstOK := r.units[normalize(to)]
+	r.mu.RUnlock()
+
+	if !srcOK {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	if !dstOK {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if src.quantity != dst.quantity {
+		return 0, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from, src.quantity, to, dst.quantity)
+	}
+
+	base := value*src.factor + src.offset
+	return (base - dst.offset) / dst.factor, nil
+}
+
+func normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.ReplaceAll(name, "³", "3")
+	return strings.ReplaceAll(name, " ", "")
+}
+
+// Default holds the units reported by utility meters and sensors.
+var Default = NewRegistry()
+
+func init() {
+	// Flow, base L/min
+	Default.Register("flow", 1, 0, "L/min", "lpm", "l/m")
+	Default.Register("flow", 1.0/60, 0, "L/h", "lph")
+	Default.Register("flow", 60, 0, "L/s", "lps")
+	Default.Register("flow", 1000.0/60, 0, "m³/h", "cmh")
+	Default.Register("flow", 1000, 0, "m³/min")
+	Default.Register("flow", 3.785411784, 0, "gal/min", "gpm")
+
+	// Volume, base L
+	Default.Register("volume", 1, 0, "L", "liters", "litres")
+	Default.Register("volume", 1000, 0, "m³", "kl", "cubic_meters")
+	Default.Register("volume", 3.785411784, 0, "gal", "gallons")
+
+	// Energy, base kWh
+	Default.Register("energy", 1, 0, "kWh")
+	Default.Register("energy", 0.001, 0, "Wh")
+	Default.Register("energy", 1000, 0, "MWh")
+
+	// Power, base kW
+	Default.Register("power", 1, 0, "kW")
+	Default.Register("power", 0.001, 0, "W")
+	Default.Register("power", 1000, 0, "MW")
+
+	// Pressure, base bar
+	Default.Register("pressure", 1, 0, "bar")
+	Default.Register("pressure", 0.01, 0, "kPa")
+	Default.Register("pressure", 0.0689475729, 0, "psi")
+
+	// Temperature, base °C
+	Default.Register("temperature", 1, 0, "°C", "C", "celsius")
+	Default.Register("temperature", 5.0/9, -160.0/9, "°F", "F", "fahrenheit")
+	Default.Register("temperature", 1, -273.15, "K", "kelvin")
+
+	// Electrical
+	Default.Register("voltage", 1, 0, "V")
+	Default.Register("voltage", 1000, 0, "kV")
+	Default.Register("current", 1, 0, "A")
+	Default.Register("current", 0.001, 0, "mA")
+}